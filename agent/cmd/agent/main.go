@@ -2,46 +2,68 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
+	"maps"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"golang.org/x/sync/errgroup"
 
 	"github.com/pingsantohq/agent/internal/backfill"
+	"github.com/pingsantohq/agent/internal/buildinfo"
 	"github.com/pingsantohq/agent/internal/certs"
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/configcli"
 	"github.com/pingsantohq/agent/internal/diag"
 	"github.com/pingsantohq/agent/internal/enroll"
 	"github.com/pingsantohq/agent/internal/health"
+	"github.com/pingsantohq/agent/internal/labelscli"
 	"github.com/pingsantohq/agent/internal/logging"
 	"github.com/pingsantohq/agent/internal/metrics"
+	"github.com/pingsantohq/agent/internal/monitoring"
+	"github.com/pingsantohq/agent/internal/netproxy"
+	"github.com/pingsantohq/agent/internal/profiling"
 	"github.com/pingsantohq/agent/internal/queue"
 	"github.com/pingsantohq/agent/internal/queue/persist"
+	"github.com/pingsantohq/agent/internal/reqstamp"
 	"github.com/pingsantohq/agent/internal/runtime"
 	"github.com/pingsantohq/agent/internal/scheduler"
+	"github.com/pingsantohq/agent/internal/setup"
+	"github.com/pingsantohq/agent/internal/spiffe"
+	"github.com/pingsantohq/agent/internal/throttle"
+	"github.com/pingsantohq/agent/internal/transmit"
 	"github.com/pingsantohq/agent/internal/upgrade"
 	"github.com/pingsantohq/agent/internal/upgrade/verify"
 	"github.com/pingsantohq/agent/internal/upgradecli"
 	"github.com/pingsantohq/agent/internal/uplink"
 	"github.com/pingsantohq/agent/internal/worker"
+	"github.com/pingsantohq/agent/pkg/revocation"
 	"github.com/pingsantohq/agent/pkg/types"
 )
 
 const (
-	defaultMetricsAddr         = "127.0.0.1:9310"
+	defaultMetricsAddr         = config.DefaultMonitoringListen
 	defaultDiskCapBytes        = 2 << 30
 	defaultSpillThreshold      = 0.8
-	defaultMonitorSyncInterval = 15 * time.Second
+	defaultMonitorSyncInterval = config.DefaultMonitorSyncInterval
+	defaultLongPollTimeout     = config.DefaultLongPollTimeout
+	monitorSyncModePush        = "push"
+	monitorSyncModeLongPoll    = "long_poll"
+	planSyncModePush           = "push"
 )
 
 func main() {
@@ -58,12 +80,18 @@ func main() {
 	switch cmd {
 	case "run":
 		err = run(ctx, os.Args[2:])
+	case "setup":
+		err = setup.Run(ctx, os.Args[2:], setup.Dependencies{})
 	case "enroll":
 		err = enroll.Run(ctx, os.Args[2:], enroll.Dependencies{})
 	case "diag":
 		err = diag.Run(ctx, os.Args[2:], diag.Dependencies{})
 	case "upgrades":
 		err = upgradecli.Run(ctx, os.Args[2:], upgradecli.Dependencies{})
+	case "config":
+		err = configcli.Run(ctx, os.Args[2:], configcli.Dependencies{})
+	case "labels":
+		err = labelscli.Run(ctx, os.Args[2:], labelscli.Dependencies{})
 	case "-h", "--help", "help":
 		printUsage()
 		return
@@ -82,12 +110,18 @@ func main() {
 func run(ctx context.Context, args []string) error {
 	fs := flag.NewFlagSet("run", flag.ContinueOnError)
 	configPath := fs.String("config", config.DefaultConfigPath, "Path to agent configuration file")
+	enablePprof := fs.Bool("pprof", false, "Serve net/http/pprof on the monitoring listener")
+	strict := fs.Bool("strict", false, "Fail on unrecognized config keys")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
-	cfg, err := config.Load(ctx, *configPath)
+	loadConfig := config.Load
+	if *strict {
+		loadConfig = config.LoadStrict
+	}
+	cfg, err := loadConfig(ctx, *configPath)
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
@@ -105,26 +139,69 @@ func run(ctx context.Context, args []string) error {
 		return fmt.Errorf("load agent state: %w", err)
 	}
 
+	overlay, err := config.LoadOverlay(cfg.Agent.DataDir)
+	if err != nil {
+		return err
+	}
+	effective := newEffectiveConfig(cfg, overlay)
+	cfg = effective.current()
+
+	bootstrapPath := cfg.Agent.BootstrapPath
+	if bootstrapPath == "" {
+		bootstrapPath = config.DefaultBootstrapPath
+	}
+	bootstrap, hasBootstrap, err := config.LoadBootstrap(bootstrapPath)
+	if err != nil {
+		return err
+	}
+
 	serverURL := cfg.Agent.Server
 	if serverURL == "" {
 		serverURL = state.Server
 	}
 	if serverURL == "" {
-		return fmt.Errorf("server URL missing from config and state")
+		serverURL = bootstrap.Server
+	}
+	if serverURL == "" {
+		return fmt.Errorf("server URL missing from config, state, and bootstrap plan")
 	}
 
 	logger := logging.New()
-	logger.Printf("agent starting (server=%s, data_dir=%s)", serverURL, cfg.Agent.DataDir)
+	logOutput := logging.NewOutput(logger)
+	if err := logOutput.Set(cfg.Logging.Output); err != nil {
+		return fmt.Errorf("configure logging: %w", err)
+	}
+	defer logOutput.Close()
+	if err := logOutput.SetLevel(cfg.Logging.Level); err != nil {
+		return fmt.Errorf("configure logging: %w", err)
+	}
+	build := buildinfo.Get()
+	logger.Printf("agent %s (%s) starting (server=%s, data_dir=%s)", build.Version, build.Commit, serverURL, cfg.Agent.DataDir)
+
+	// Count this start before anything an upgraded binary could crash in.
+	crashGuard := &upgrade.CrashLoopGuard{
+		DataDir:     cfg.Agent.DataDir,
+		MaxRestarts: cfg.Upgrade.CrashLoopRestarts,
+		Window:      cfg.Upgrade.CrashLoopWindow,
+		Installer:   &upgrade.BinaryInstaller{Logger: logger},
+		Restarter:   &upgrade.ExecRestarter{Logger: logger},
+		Logger:      logger,
+	}
+	if err := crashGuard.Check(ctx, os.Args, os.Environ()); err != nil {
+		logger.Printf("upgrade crash-loop guard: %v", err)
+	}
 
 	metricsStore := metrics.NewStore()
 
 	queueCapacity := cfg.Queue.MemItemsCap
 	if queueCapacity <= 0 {
-		queueCapacity = 1024
+		queueCapacity = config.DefaultMemItemsCap
 	}
 
 	monitorInterval := defaultMonitorSyncInterval
 	healthChecker := health.NewChecker(metricsStore, queueCapacity, monitorInterval*3)
+	configureHealth(healthChecker, cfg)
+	healthChecker.SetStartupGrace(time.Now().UTC(), cfg.Health.StartupGrace)
 
 	opts := []runtime.Option{
 		runtime.WithQueueCapacity(queueCapacity),
@@ -137,7 +214,11 @@ func run(ctx context.Context, args []string) error {
 	if cfg.Run.TickResolution > 0 {
 		opts = append(opts, runtime.WithTickResolution(cfg.Run.TickResolution))
 	}
+	if cfg.Run.DedupeProbes {
+		opts = append(opts, runtime.WithProbeDedupe(true))
+	}
 
+	var spillStore *persist.Store
 	if cfg.Queue.SpillToDisk {
 		spillDir := filepath.Join(cfg.Agent.DataDir, "spill")
 		diskCap, err := queue.ParseSize(cfg.Queue.DiskBytesCap, defaultDiskCapBytes)
@@ -148,31 +229,109 @@ func run(ctx context.Context, args []string) error {
 		if err != nil {
 			return fmt.Errorf("open spill store: %w", err)
 		}
+		spillStore = store
 		opts = append(opts, runtime.WithSpill(store, defaultSpillThreshold))
+		healthChecker.Register(health.SpillCapacityCheck(store.SizeBytes, diskCap))
 		backfillCtrl := backfill.New(store, backfill.WithMetrics(metricsStore.BackfillRecorder()))
 		opts = append(opts, runtime.WithBackfillController(backfillCtrl))
 		defer store.Close()
 	}
 
-	tlsConfig, err := certs.LoadClientTLSConfig(state.CertPath, state.KeyPath, state.CAPath, serverURL)
-	if err != nil {
-		return fmt.Errorf("load TLS config: %w", err)
+	var (
+		svidSource    *spiffe.Source
+		certExpiry    time.Time
+		certExpiryErr error
+	)
+	if cfg.Agent.SPIFFESocket != "" {
+		svidSource, err = spiffe.NewSource(ctx, cfg.Agent.SPIFFESocket, logger)
+		if err != nil {
+			return fmt.Errorf("obtain SPIFFE SVID: %w", err)
+		}
+		certExpiry = svidSource.SVID().Certificate.Leaf.NotAfter
+	} else {
+		certExpiry, certExpiryErr = certs.ClientCertExpiry(state.CertPath)
 	}
 
-	if expiry, err := certs.ClientCertExpiry(state.CertPath); err != nil {
-		logger.Printf("failed to determine certificate expiry: %v", err)
+	if certExpiryErr != nil {
+		logger.Printf("failed to determine certificate expiry: %v", certExpiryErr)
 	} else {
-		healthChecker.SetCertExpiry(expiry.UTC())
+		healthChecker.SetCertExpiry(certExpiry.UTC())
+	}
+
+	proxy, err := netproxy.FromConfig(cfg.Proxy)
+	if err != nil {
+		return fmt.Errorf("configure proxy: %w", err)
+	}
+	revocationChecker, err := newRevocationChecker(cfg.Agent.Revocation, proxy, logger)
+	if err != nil {
+		return err
+	}
+
+	// loadTLS re-reads state.yaml on every load, so paths rewritten by
+	// `enroll --renew` or a CA rotation apply along with the files' contents.
+	loadTLS := func() (*tls.Config, []string, error) {
+		current, err := config.LoadState(ctx, cfg.Agent.DataDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load state: %w", err)
+		}
+		files := append([]string{config.StatePath(cfg.Agent.DataDir)}, current.CABundles()...)
+		var tlsConfig *tls.Config
+		if svidSource != nil {
+			tlsConfig, err = certs.ClientTLSConfigFunc(svidSource.GetClientCertificate, current.CABundles(), serverURL)
+		} else {
+			keyPath := current.KeyPath
+			if cfg.Agent.ClientKey != "" {
+				keyPath = cfg.Agent.ClientKey
+			}
+			tlsConfig, err = certs.LoadClientTLSConfig(current.CertPath, keyPath, current.CABundles(), serverURL)
+			files = append(files, current.CertPath, keyPath)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("load TLS config: %w", err)
+		}
+		if err := certs.PinControllerSPKI(tlsConfig, cfg.Agent.ControllerPins); err != nil {
+			return nil, nil, fmt.Errorf("agent.controller_pins: %w", err)
+		}
+		certs.CheckControllerRevocation(tlsConfig, revocationChecker)
+		return tlsConfig, files, nil
+	}
+	tlsTransport, err := certs.NewReloadingTransport(&http.Transport{
+		ForceAttemptHTTP2:   true,
+		Proxy:               proxy,
+		MaxIdleConnsPerHost: 10,
+	}, loadTLS, logger)
+	if err != nil {
+		return err
 	}
 
 	httpClient := &http.Client{
-		Timeout: 10 * time.Second,
-		Transport: &http.Transport{
-			TLSClientConfig:     tlsConfig,
-			ForceAttemptHTTP2:   true,
-			Proxy:               http.ProxyFromEnvironment,
-			MaxIdleConnsPerHost: 10,
-		},
+		Timeout:   10 * time.Second,
+		Transport: reqstamp.Wrap(tlsTransport),
+	}
+
+	// renewCertificate swaps in a certificate for a new key over the current
+	// connection and reloads TLS at once, instead of on the next file check.
+	renewCertificate := func(ctx context.Context) error {
+		if svidSource != nil {
+			return fmt.Errorf("%w: the client certificate comes from SPIFFE", uplink.ErrDirectiveUnsupported)
+		}
+		if cfg.Agent.ClientKey != "" {
+			return fmt.Errorf("%w: agent.client_key is set; renew with `pingsanto-agent enroll --renew`", uplink.ErrDirectiveUnsupported)
+		}
+		current, err := config.LoadState(ctx, cfg.Agent.DataDir)
+		if err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+		renewer := certs.Renewer{Client: httpClient, Server: serverURL}
+		cert, err := renewer.Renew(ctx, current.AgentID, certs.Paths{Cert: current.CertPath, Key: current.KeyPath})
+		if err != nil {
+			return err
+		}
+		if _, err := tlsTransport.Reload(); err != nil {
+			return err
+		}
+		logger.Printf("renewed client certificate; valid until %s", cert.NotAfter.UTC().Format(time.RFC3339))
+		return nil
 	}
 
 	uplinkClient, err := uplink.NewClient(
@@ -185,11 +344,31 @@ func run(ctx context.Context, args []string) error {
 			HTTPClient: httpClient,
 			Metrics:    metricsStore,
 			Logger:     logger,
+			Directives: directiveHandler(logger, renewCertificate),
+			ClockSkew:  healthChecker.ObserveClockSkew,
+			Labels:     newStateLabels(cfg.Agent.DataDir, state.Labels, logger).current,
 		},
 	)
 	if err != nil {
 		return fmt.Errorf("init uplink client: %w", err)
 	}
+	if certExpiryErr == nil {
+		uplinkClient.SetCertExpiry(certExpiry)
+	}
+	if svidSource != nil {
+		svidSource.OnUpdate(func(svid spiffe.SVID) {
+			healthChecker.SetCertExpiry(svid.Certificate.Leaf.NotAfter.UTC())
+			uplinkClient.SetCertExpiry(svid.Certificate.Leaf.NotAfter)
+		})
+	}
+	tlsTransport.OnReload(func(tlsConfig *tls.Config) {
+		if len(tlsConfig.Certificates) == 0 || tlsConfig.Certificates[0].Leaf == nil {
+			return
+		}
+		notAfter := tlsConfig.Certificates[0].Leaf.NotAfter
+		healthChecker.SetCertExpiry(notAfter.UTC())
+		uplinkClient.SetCertExpiry(notAfter)
+	})
 
 	upgradeClient, err := upgrade.NewClient(httpClient, serverURL, state.AgentID, logger)
 	if err != nil {
@@ -203,23 +382,32 @@ func run(ctx context.Context, args []string) error {
 		Now:        time.Now,
 	}
 
-	pubKey := verify.DefaultPublicKey()
-	if envKey := strings.TrimSpace(os.Getenv("PINGSANTO_AGENT_MINISIGN_PUBKEY")); envKey != "" {
-		pubKey = envKey
-	}
-	if strings.TrimSpace(pubKey) == "" {
-		return fmt.Errorf("minisign public key not configured; set PINGSANTO_AGENT_MINISIGN_PUBKEY or update embedded key")
-	}
-	verifier, err := verify.NewMinisignVerifier(pubKey)
+	verifier, err := newArtifactVerifier(cfg.Upgrade.Signature)
 	if err != nil {
-		return fmt.Errorf("init minisign verifier: %w", err)
+		return err
 	}
 	planApplier.Verifier = verifier
 	installer := &upgrade.BinaryInstaller{Logger: logger}
 	restarter := &upgrade.ExecRestarter{Logger: logger}
 
+	downloadRateLimit, err := queue.ParseSize(cfg.Upgrade.DownloadRateLimit, 0)
+	if err != nil {
+		return fmt.Errorf("upgrade.download_rate_limit: %w", err)
+	}
+	maintenanceWindows, err := upgrade.ParseMaintenanceWindows(cfg.Upgrade.MaintenanceWindows)
+	if err != nil {
+		return fmt.Errorf("upgrade.maintenance_windows: %w", err)
+	}
 	upgrader := upgrade.NewManager(
-		upgrade.Config{DataDir: cfg.Agent.DataDir},
+		upgrade.Config{
+			DataDir:            cfg.Agent.DataDir,
+			DownloadRateLimit:  downloadRateLimit,
+			VerifyWindow:       cfg.Upgrade.VerifyWindow,
+			MaintenanceWindows: maintenanceWindows,
+			AllowedChannels:    cfg.Upgrade.AllowedChannels,
+			KeepBundles:        cfg.Upgrade.KeepBundles,
+			PushPlans:          strings.EqualFold(cfg.Upgrade.PlanSync, planSyncModePush),
+		},
 		upgrade.Dependencies{
 			Logger:      logger,
 			PlanFetcher: upgradeClient,
@@ -227,24 +415,90 @@ func run(ctx context.Context, args []string) error {
 			Applier:     planApplier,
 			Installer:   installer,
 			Restarter:   restarter,
+			Readiness:   healthChecker,
+			Health:      healthChecker,
+			Metrics:     metricsStore.UpgradeRecorder(),
 			Args:        os.Args,
 			Env:         os.Environ(),
 			Now:         time.Now,
 		},
 	)
 	opts = append(opts, runtime.WithUpgradeManager(upgrader))
+	healthChecker.Register(health.UpgradeCheck(upgrader.LastFailure))
 
 	rt := runtime.New(opts...)
+	liveness := health.NewLiveness(cfg.Health.LivenessWindow)
+	liveness.WatchScheduler(rt.SchedulerLastTick)
+	liveness.WatchWorkers(rt.WorkerProgress)
+
+	if hasBootstrap && len(bootstrap.Monitors) > 0 {
+		// Lifeline monitors run until the controller delivers the first snapshot.
+		specs := snapshotToSpecs(types.MonitorSnapshot{Monitors: bootstrap.Monitors})
+		rt.UpdateMonitors(specs)
+		logger.Printf("bootstrap plan loaded from %s: %d lifeline monitors", bootstrapPath, len(specs))
+	}
+
+	healthChecker.Register(healthChecker.UplinkCheck())
+	transmitOpts := []transmit.Option{transmit.WithSendObserver(func(err error) {
+		// A throttled upload reached the controller, so the uplink is up.
+		if _, ok := throttle.Delay(err); ok {
+			err = nil
+		}
+		if err != nil {
+			logOutput.Debug().Printf("results upload failed: %v", err)
+		} else {
+			logOutput.Debug().Printf("results upload succeeded")
+		}
+		healthChecker.ObserveUpload(err)
+	})}
+	if cfg.Transmit.BatchSize > 0 {
+		transmitOpts = append(transmitOpts, transmit.WithBatchSize(cfg.Transmit.BatchSize))
+	}
+	if cfg.Transmit.MaxLatency > 0 {
+		transmitOpts = append(transmitOpts, transmit.WithMaxLatency(cfg.Transmit.MaxLatency))
+	}
+	transmitter := rt.NewTransmitter(uplinkClient, transmitOpts...)
 
-	transmitter := rt.NewTransmitter(uplinkClient)
+	exporters, err := newTelemetry(cfg, metricsStore, state.AgentID, proxy, logger)
+	if err != nil {
+		return err
+	}
+	if exporters.webhook != nil {
+		healthChecker.OnTransition(exporters.webhook.Notify)
+	}
+
+	listener, err := newMonitorListener(cfg.Monitoring)
+	if err != nil {
+		return fmt.Errorf("configure monitoring listener: %w", err)
+	}
 
 	runCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
 	wait := rt.Start(runCtx)
 
+	targets := reloadTargets{
+		runtime: rt,
+		checker: healthChecker,
+		uplink:  uplinkClient,
+		spill:   spillStore,
+		logs:    logOutput,
+	}
+
 	grp, groupCtx := errgroup.WithContext(runCtx)
 
+	if svidSource != nil {
+		grp.Go(func() error {
+			_ = svidSource.Run(groupCtx)
+			return nil
+		})
+	}
+
+	grp.Go(func() error {
+		tlsTransport.Run(groupCtx, certs.DefaultReloadInterval)
+		return nil
+	})
+
 	grp.Go(func() error {
 		if err := transmitter.Run(groupCtx); err != nil && !errors.Is(err, context.Canceled) {
 			return err
@@ -252,12 +506,8 @@ func run(ctx context.Context, args []string) error {
 		return nil
 	})
 
-	heartbeatInterval := time.Duration(cfg.Agent.HeartbeatSec) * time.Second
-	if heartbeatInterval <= 0 {
-		heartbeatInterval = 15 * time.Second
-	}
 	grp.Go(func() error {
-		err := uplinkClient.RunHeartbeat(groupCtx, heartbeatInterval)
+		err := uplinkClient.RunHeartbeat(groupCtx, heartbeatInterval(cfg))
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -265,7 +515,9 @@ func run(ctx context.Context, args []string) error {
 	})
 
 	grp.Go(func() error {
-		err := runMonitorSync(groupCtx, uplinkClient, rt, logger, monitorInterval, healthChecker.ObserveMonitorSync)
+		err := runMonitorSync(groupCtx, uplinkClient, rt, logger, monitorInterval, cfg.MonitorSync, healthChecker.ObserveMonitorSync, func(overlay *types.ConfigOverlay) {
+			applyOverlay(overlay, effective, targets, logger)
+		}, newMonitorOverrides(cfg.MonitorSync.OverridesFile, logger))
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -279,7 +531,56 @@ func run(ctx context.Context, args []string) error {
 	})
 
 	grp.Go(func() error {
-		return serveMonitoring(groupCtx, defaultMetricsAddr, metricsStore, healthChecker, logger)
+		var pprofHandler http.Handler
+		if *enablePprof || cfg.Debug.Pprof {
+			pprofHandler = profiling.Handler(cfg.Debug.PprofToken)
+		}
+		return serveMonitoring(groupCtx, listener, metricsStore, healthChecker, liveness, pprofHandler, logger)
+	})
+
+	if exporters.otlp != nil {
+		grp.Go(func() error {
+			if err := exporters.otlp.Run(groupCtx); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		})
+	}
+
+	if exporters.statsd != nil {
+		grp.Go(func() error {
+			if err := exporters.statsd.Run(groupCtx); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		})
+	}
+
+	if exporters.push != nil {
+		grp.Go(func() error {
+			if err := exporters.push.Run(groupCtx); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		})
+	}
+
+	if exporters.webhook != nil {
+		grp.Go(func() error {
+			healthChecker.Watch(groupCtx, cfg.Health.Webhook.Interval)
+			return nil
+		})
+		grp.Go(func() error {
+			if err := exporters.webhook.Run(groupCtx); err != nil && !errors.Is(err, context.Canceled) {
+				return err
+			}
+			return nil
+		})
+	}
+
+	grp.Go(func() error {
+		watchReload(groupCtx, *configPath, loadConfig, effective, targets, logger)
+		return nil
 	})
 
 	if err := grp.Wait(); err != nil && !errors.Is(err, context.Canceled) {
@@ -291,43 +592,462 @@ func run(ctx context.Context, args []string) error {
 	return nil
 }
 
+// reloadTargets are the running components that pick up agent.yaml changes
+// on SIGHUP, so tuning the agent never discards the in-memory queue.
+type reloadTargets struct {
+	runtime *runtime.Runtime
+	checker *health.Checker
+	uplink  *uplink.Client
+	spill   *persist.Store
+	logs    *logging.Output
+}
+
+// stateLabels serves the labels recorded in state.yaml, re-reading the file
+// only when its modification time changes.
+type stateLabels struct {
+	dataDir string
+	logger  *log.Logger
+
+	mu      sync.Mutex
+	modTime time.Time
+	labels  map[string]string
+}
+
+func newStateLabels(dataDir string, labels map[string]string, logger *log.Logger) *stateLabels {
+	s := &stateLabels{dataDir: dataDir, logger: logger, labels: labels}
+	if info, err := os.Stat(config.StatePath(dataDir)); err == nil {
+		s.modTime = info.ModTime()
+	}
+	return s
+}
+
+func (s *stateLabels) current() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info, err := os.Stat(config.StatePath(s.dataDir))
+	if err != nil || info.ModTime().Equal(s.modTime) {
+		return s.labels
+	}
+	state, err := config.LoadState(context.Background(), s.dataDir)
+	if err != nil {
+		s.logger.Printf("reload labels from state: %v", err)
+		return s.labels
+	}
+	s.modTime = info.ModTime()
+	if !maps.Equal(state.Labels, s.labels) {
+		s.logger.Printf("agent labels changed; reporting on next heartbeat")
+		s.labels = state.Labels
+	}
+	return s.labels
+}
+
+// monitorOverrides serves the local monitor overrides file, re-reading it
+// only when its modification time changes. A nil *monitorOverrides applies
+// nothing.
+type monitorOverrides struct {
+	path   string
+	logger *log.Logger
+
+	mu        sync.Mutex
+	modTime   time.Time
+	overrides config.MonitorOverrides
+}
+
+func newMonitorOverrides(path string, logger *log.Logger) *monitorOverrides {
+	if strings.TrimSpace(path) == "" {
+		return nil
+	}
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	return &monitorOverrides{path: path, logger: logger}
+}
+
+// refresh returns the current overrides and reports whether they changed
+// since the previous call. A missing file means no overrides; an invalid one
+// is logged and the previous overrides stay in effect.
+func (m *monitorOverrides) refresh() (config.MonitorOverrides, bool) {
+	if m == nil {
+		return config.MonitorOverrides{}, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	info, err := os.Stat(m.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		changed := !m.modTime.IsZero()
+		if changed {
+			m.logger.Printf("monitor overrides %s removed; using controller settings", m.path)
+		}
+		m.modTime, m.overrides = time.Time{}, config.MonitorOverrides{}
+		return m.overrides, changed
+	}
+	if err != nil || info.ModTime().Equal(m.modTime) {
+		return m.overrides, false
+	}
+	overrides, err := config.LoadMonitorOverrides(m.path)
+	if err != nil {
+		m.logger.Printf("monitor overrides not applied: %v", err)
+		return m.overrides, false
+	}
+	m.modTime, m.overrides = info.ModTime(), overrides
+	m.logger.Printf("monitor overrides loaded from %s: disabled=%d adjusted=%d", m.path, len(overrides.Disabled), len(overrides.Monitors))
+	return m.overrides, true
+}
+
+// applyMonitorOverrides drops disabled monitors and replaces overridden
+// cadences and timeouts.
+func applyMonitorOverrides(specs []scheduler.MonitorSpec, overrides config.MonitorOverrides) []scheduler.MonitorSpec {
+	if overrides.IsZero() {
+		return specs
+	}
+	out := make([]scheduler.MonitorSpec, 0, len(specs))
+	for _, spec := range specs {
+		override, ok := overrides.Lookup(spec.MonitorID)
+		if ok {
+			if override.Disabled {
+				continue
+			}
+			if override.Cadence > 0 {
+				spec.Cadence = override.Cadence
+			}
+			if override.Timeout > 0 {
+				spec.Timeout = override.Timeout
+			}
+		}
+		out = append(out, spec)
+	}
+	return out
+}
+
+// effectiveConfig is the local config with the controller's overlay applied.
+// SIGHUP replaces the local half and monitor syncs replace the overlay; both
+// re-apply the merged result.
+type effectiveConfig struct {
+	mu      sync.Mutex
+	base    config.Config
+	overlay *types.ConfigOverlay
+}
+
+func newEffectiveConfig(base config.Config, overlay *types.ConfigOverlay) *effectiveConfig {
+	return &effectiveConfig{base: base, overlay: overlay}
+}
+
+func (e *effectiveConfig) current() config.Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return config.ApplyOverlay(e.base, e.overlay)
+}
+
+// setBase records a reloaded local config and returns the effective config.
+func (e *effectiveConfig) setBase(cfg config.Config) config.Config {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.base = cfg
+	return config.ApplyOverlay(e.base, e.overlay)
+}
+
+// setOverlay records overlay and reports whether it differs from the previous one.
+func (e *effectiveConfig) setOverlay(overlay *types.ConfigOverlay) (config.Config, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	changed := (e.overlay == nil) != (overlay == nil) || (overlay != nil && *overlay != *e.overlay)
+	e.overlay = overlay
+	return config.ApplyOverlay(e.base, e.overlay), changed
+}
+
+// applyOverlay applies a config overlay received with a monitor snapshot and
+// persists it under data_dir. Overlays that cannot be applied are ignored.
+func applyOverlay(overlay *types.ConfigOverlay, effective *effectiveConfig, targets reloadTargets, logger *log.Logger) {
+	if overlay != nil && overlay.QueueDiskBytesCap != "" {
+		if _, err := queue.ParseSize(overlay.QueueDiskBytesCap, 0); err != nil {
+			logger.Printf("ignoring config overlay: queue_disk_bytes_cap: %v", err)
+			return
+		}
+	}
+	if overlay != nil {
+		if _, err := logging.ParseLevel(overlay.LogLevel); err != nil {
+			logger.Printf("ignoring config overlay: log_level: %v", err)
+			return
+		}
+	}
+	cfg, changed := effective.setOverlay(overlay)
+	if !changed {
+		return
+	}
+	if overlay == nil {
+		logger.Printf("config overlay cleared by controller")
+	} else {
+		logger.Printf("config overlay received (heartbeat_sec=%d queue_mem_items_cap=%d queue_disk_bytes_cap=%q log_level=%q)", overlay.HeartbeatSec, overlay.QueueMemItemsCap, overlay.QueueDiskBytesCap, overlay.LogLevel)
+	}
+	if err := config.SaveOverlay(cfg.Agent.DataDir, overlay); err != nil {
+		logger.Printf("persist config overlay: %v", err)
+	}
+	applyReload(cfg, targets, logger)
+}
+
+// watchReload re-reads the config file on SIGHUP and applies the settings
+// that can change without a restart, with any controller overlay on top. A
+// config that fails to load (including strict-mode unknown keys) is ignored.
+func watchReload(ctx context.Context, configPath string, loadConfig func(context.Context, string) (config.Config, error), effective *effectiveConfig, targets reloadTargets, logger *log.Logger) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		cfg, err := loadConfig(ctx, configPath)
+		if err != nil {
+			logger.Printf("config reload failed, keeping current settings: %v", err)
+			continue
+		}
+		applyReload(effective.setBase(cfg), targets, logger)
+	}
+}
+
+// applyReload pushes reloadable settings to the running components: log
+// output and level, worker count, queue limits, heartbeat interval and health
+// thresholds. Server, identity and listener settings still need a restart.
+func applyReload(cfg config.Config, t reloadTargets, logger *log.Logger) {
+	if t.logs != nil {
+		if err := t.logs.Set(cfg.Logging.Output); err != nil {
+			logger.Printf("config reloaded; keeping current log output: %v", err)
+		}
+		if err := t.logs.SetLevel(cfg.Logging.Level); err != nil {
+			logger.Printf("config reloaded; keeping current log level: %v", err)
+		}
+	}
+
+	if cfg.Run.Workers <= 0 {
+		logger.Printf("config reloaded; run.workers unset, keeping %d workers", t.runtime.WorkerCount())
+	} else if current := t.runtime.WorkerCount(); current != cfg.Run.Workers {
+		logger.Printf("config reloaded; resizing worker pool %d -> %d", current, cfg.Run.Workers)
+		t.runtime.SetWorkerCount(cfg.Run.Workers)
+	}
+
+	queueCapacity := cfg.Queue.MemItemsCap
+	if queueCapacity <= 0 {
+		queueCapacity = config.DefaultMemItemsCap
+	}
+	if current := t.runtime.ResultsQueue().Capacity(); current != queueCapacity {
+		logger.Printf("config reloaded; resizing result queue %d -> %d", current, queueCapacity)
+		t.runtime.ResultsQueue().SetCapacity(queueCapacity)
+	}
+	if t.checker != nil {
+		t.checker.SetQueueCapacity(queueCapacity)
+		configureHealth(t.checker, cfg)
+	}
+
+	if t.spill != nil {
+		diskCap, err := queue.ParseSize(cfg.Queue.DiskBytesCap, defaultDiskCapBytes)
+		if err != nil {
+			logger.Printf("config reloaded; keeping spill cap: parse disk_bytes_cap: %v", err)
+		} else if err := t.spill.SetMaxBytes(diskCap); err != nil {
+			logger.Printf("config reloaded; apply spill cap: %v", err)
+		} else if t.checker != nil {
+			t.checker.Register(health.SpillCapacityCheck(t.spill.SizeBytes, diskCap))
+		}
+	}
+
+	if t.uplink != nil {
+		t.uplink.SetHeartbeatInterval(heartbeatInterval(cfg))
+	}
+}
+
+// configureHealth applies the readiness thresholds from cfg.
+func configureHealth(checker *health.Checker, cfg config.Config) {
+	checker.SetClockSkewThreshold(cfg.Agent.ClockSkewThreshold)
+	checker.SetQueuePressureThreshold(cfg.Health.QueuePressurePct)
+	checker.SetMonitorStaleAfter(cfg.Health.MonitorStaleAfter)
+	checker.SetCertExpiryWarning(cfg.Health.CertExpiryWarning)
+	checker.SetUplinkFailureThreshold(cfg.Health.UplinkFailureThreshold)
+}
+
+func heartbeatInterval(cfg config.Config) time.Duration {
+	interval := time.Duration(cfg.Agent.HeartbeatSec) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultHeartbeatSec * time.Second
+	}
+	return interval
+}
+
 func printUsage() {
 	fmt.Println("PingSanto Agent CLI")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("  pingsanto-agent run [--config /etc/pingsanto/agent.yaml]")
+	fmt.Println("  pingsanto-agent run [--config /etc/pingsanto/agent.yaml] [--strict]")
+	fmt.Println("  pingsanto-agent setup [--server URL] [--token TOKEN] [--labels k=v,...] [--proxy URL] [--skip-systemd] [--no-start] [--non-interactive]")
 	fmt.Println("  pingsanto-agent enroll --server URL --token TOKEN [--labels k=v,...] [--data-dir dir] [--config-path path]")
+	fmt.Println("  pingsanto-agent enroll --renew --token TOKEN [--data-dir dir]")
+	fmt.Println("  pingsanto-agent enroll --server URL --oidc-issuer URL --oidc-client-id ID [--labels k=v,...]")
+	fmt.Println("  pingsanto-agent enroll --server URL --token TOKEN --attest aws|gcp|azure [--attest-audience AUD]")
+	fmt.Println("  pingsanto-agent enroll --from-bundle PATH [--labels k=v,...] [--data-dir dir]")
 	fmt.Println("  pingsanto-agent diag [--config path] [--data-dir dir] [--logs dir] [--output file] [--include-spill]")
-	fmt.Println("  pingsanto-agent upgrades [--pause|--resume|--status] [--channel stable|canary] [--config path] [--data-dir dir]")
+	fmt.Println("  pingsanto-agent upgrades [--pause|--resume|--status|--check|--apply-now|--rollback] [--channel stable|canary] [--output text|json] [--config path] [--data-dir dir]")
+	fmt.Println("  pingsanto-agent config validate [--config path] [--strict]")
+	fmt.Println("  pingsanto-agent config show [--effective] [--config path]")
+	fmt.Println("  pingsanto-agent labels list|set k=v...|unset k... [--config path] [--data-dir dir]")
+}
+
+// newRevocationChecker builds the controller certificate revocation checker
+// from cfg; OCSP and CRL fetches go through proxy like controller traffic.
+func newRevocationChecker(cfg config.RevocationConfig, proxy func(*http.Request) (*url.URL, error), logger *log.Logger) (*revocation.Checker, error) {
+	mode, err := revocation.ParseMode(cfg.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("agent.revocation.mode: %w", err)
+	}
+	return &revocation.Checker{
+		Mode:     mode,
+		CRLFiles: cfg.CRLFiles,
+		Proxy:    proxy,
+		Timeout:  cfg.Timeout,
+		Logger:   logger,
+	}, nil
 }
 
-func serveMonitoring(ctx context.Context, addr string, store *metrics.Store, checker *health.Checker, logger *log.Logger) error {
+// newArtifactVerifier builds the upgrade signature verifier selected by
+// upgrade.signature.
+func newArtifactVerifier(cfg config.SignatureConfig) (upgrade.SignatureVerifier, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "", "minisign":
+		pubKey := verify.DefaultPublicKey()
+		if envKey := strings.TrimSpace(os.Getenv("PINGSANTO_AGENT_MINISIGN_PUBKEY")); envKey != "" {
+			pubKey = envKey
+		}
+		if strings.TrimSpace(pubKey) == "" {
+			return nil, fmt.Errorf("minisign public key not configured; set PINGSANTO_AGENT_MINISIGN_PUBKEY or update embedded key")
+		}
+		verifier, err := verify.NewMinisignVerifier(pubKey)
+		if err != nil {
+			return nil, fmt.Errorf("init minisign verifier: %w", err)
+		}
+		return verifier, nil
+	case "cosign":
+		if cfg.PublicKeyFile != "" {
+			key, err := os.ReadFile(cfg.PublicKeyFile)
+			if err != nil {
+				return nil, fmt.Errorf("upgrade.signature.public_key_file: %w", err)
+			}
+			verifier, err := verify.NewCosignKeyVerifier(key)
+			if err != nil {
+				return nil, fmt.Errorf("init cosign verifier: %w", err)
+			}
+			return verifier, nil
+		}
+		roots, err := os.ReadFile(cfg.FulcioRootsFile)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade.signature.fulcio_roots_file: %w", err)
+		}
+		rekorKey, err := os.ReadFile(cfg.RekorPublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade.signature.rekor_public_key_file: %w", err)
+		}
+		verifier, err := verify.NewCosignKeylessVerifier(verify.CosignKeylessOptions{
+			FulcioRootsPEM:    roots,
+			RekorPublicKeyPEM: rekorKey,
+			Identity:          cfg.CertificateIdentity,
+			Issuer:            cfg.CertificateOIDCIssuer,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("init cosign verifier: %w", err)
+		}
+		return verifier, nil
+	case "gpg":
+		keyring, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade.signature.public_key_file: %w", err)
+		}
+		verifier, err := verify.NewGPGVerifier(keyring)
+		if err != nil {
+			return nil, fmt.Errorf("init gpg verifier: %w", err)
+		}
+		return verifier, nil
+	default:
+		return nil, fmt.Errorf("upgrade.signature.type: unsupported type %q", cfg.Type)
+	}
+}
+
+// monitorListener is the resolved bind address, TLS and auth settings for the
+// metrics/health server.
+type monitorListener struct {
+	addr string
+	tls  *tls.Config
+	auth monitoring.Auth
+}
+
+func newMonitorListener(cfg config.MonitoringConfig) (monitorListener, error) {
+	l := monitorListener{
+		addr: strings.TrimSpace(cfg.Listen),
+		auth: monitoring.Auth{
+			BearerToken: cfg.Auth.BearerToken,
+			Username:    cfg.Auth.Username,
+			Password:    cfg.Auth.Password,
+		},
+	}
+	if l.addr == "" {
+		l.addr = defaultMetricsAddr
+	}
+	if cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != "" || cfg.TLS.ClientCAFile != "" {
+		tlsCfg, err := monitoring.ServerTLSConfig(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile)
+		if err != nil {
+			return monitorListener{}, err
+		}
+		l.tls = tlsCfg
+	}
+	if !monitoring.IsLoopback(l.addr) && !l.auth.Enabled() && cfg.TLS.ClientCAFile == "" {
+		return monitorListener{}, fmt.Errorf("monitoring listen address %s is not loopback; configure monitoring.auth or monitoring.tls.client_ca_file", l.addr)
+	}
+	return l, nil
+}
+
+func (l monitorListener) url(path string) string {
+	scheme := "http"
+	if l.tls != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + l.addr + path
+}
+
+func serveMonitoring(ctx context.Context, listener monitorListener, store *metrics.Store, checker *health.Checker, liveness *health.Liveness, pprofHandler http.Handler, logger *log.Logger) error {
 	mux := http.NewServeMux()
 	mux.Handle("/metrics", metrics.NewHTTPHandler(store))
 	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
-	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
-		if checker == nil {
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		if liveness == nil {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
-		ready, reasons := checker.Ready(time.Now().UTC())
-		if !ready {
+		live, reasons := liveness.Live(time.Now().UTC())
+		if !live {
 			http.Error(w, strings.Join(reasons, "; "), http.StatusServiceUnavailable)
 			return
 		}
 		w.WriteHeader(http.StatusOK)
 	})
+	mux.HandleFunc("/readyz", readyzHandler(checker))
+	if pprofHandler != nil {
+		mux.Handle(profiling.Prefix, pprofHandler)
+		logger.Printf("pprof enabled on %s", listener.url(profiling.Prefix))
+	}
 
 	srv := &http.Server{
-		Addr:    addr,
-		Handler: mux,
+		Addr:      listener.addr,
+		Handler:   listener.auth.Wrap(mux),
+		TLSConfig: listener.tls,
 	}
 
 	errCh := make(chan error, 1)
 	go func() {
-		logger.Printf("metrics listening on http://%s", addr)
+		logger.Printf("metrics listening on %s", listener.url(""))
+		if listener.tls != nil {
+			errCh <- srv.ListenAndServeTLS("", "")
+			return
+		}
 		errCh <- srv.ListenAndServe()
 	}()
 
@@ -347,23 +1067,104 @@ func serveMonitoring(ctx context.Context, addr string, store *metrics.Store, che
 	}
 }
 
-func runMonitorSync(ctx context.Context, client *uplink.Client, rt *runtime.Runtime, logger *log.Logger, interval time.Duration, report func(time.Time, error)) error {
+// readyzHandler serves readiness as 200/503 with the joined reasons, or as a
+// structured health.Report with ?format=json.
+func readyzHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if checker == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		report := checker.Report(time.Now().UTC())
+		status := http.StatusOK
+		if !report.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			_ = json.NewEncoder(w).Encode(report)
+			return
+		}
+		if !report.Ready {
+			http.Error(w, strings.Join(report.Reasons, "; "), status)
+			return
+		}
+		w.WriteHeader(status)
+	}
+}
+
+// runMonitorSync keeps the runtime's monitors in step with the controller.
+// Each applied snapshot's config overlay is passed to onOverlay, if set.
+// Local overrides are layered over every snapshot and re-applied when the
+// overrides file changes, checked on each sync.
+func runMonitorSync(ctx context.Context, client *uplink.Client, rt *runtime.Runtime, logger *log.Logger, interval time.Duration, syncCfg config.MonitorSyncConfig, report func(time.Time, error), onOverlay func(*types.ConfigOverlay), overrides *monitorOverrides) error {
 	if interval <= 0 {
 		interval = defaultMonitorSyncInterval
 	}
+	longPollTimeout := syncCfg.LongPollTimeout
+	if longPollTimeout <= 0 {
+		longPollTimeout = defaultLongPollTimeout
+	}
 
 	if logger == nil {
 		logger = log.New(io.Discard, "", 0)
 	}
 
 	var (
-		etag  string
-		state map[string]scheduler.MonitorSpec
+		etag      string
+		revision  string
+		unchanged bool
+		notBefore time.Time
+		state     map[string]scheduler.MonitorSpec
 	)
-	syncOnce := func() error {
-		result, err := client.FetchMonitors(ctx, etag)
+	// throttled records a server-requested back-off that the next fetch must honour.
+	throttled := func(err error) {
+		if delay, ok := throttle.Delay(err); ok {
+			notBefore = time.Now().Add(delay)
+			logger.Printf("monitor sync throttled by server; backing off %s", delay)
+		}
+	}
+	apply := func(snapshot types.MonitorSnapshot) {
+		var (
+			upserts int
+			removed int
+		)
+		if snapshot.Incremental {
+			state, upserts, removed = applyIncrementalSnapshot(state, snapshot)
+		} else {
+			state = snapshotToSpecMap(snapshot)
+			upserts = len(state)
+			removed = 0
+		}
+		local, _ := overrides.refresh()
+		specs := applyMonitorOverrides(specsFromState(state), local)
+		rt.UpdateMonitors(specs)
+		revision = snapshot.Revision
+		logger.Printf("monitor sync applied revision=%s incremental=%t upserts=%d removed=%d monitors=%d", snapshot.Revision, snapshot.Incremental, upserts, removed, len(specs))
+		if onOverlay != nil {
+			onOverlay(snapshot.Config)
+		}
+	}
+	// reapplyOverrides reschedules the current snapshot when the overrides
+	// file changed without a new revision arriving.
+	reapplyOverrides := func() {
+		local, changed := overrides.refresh()
+		if !changed || state == nil {
+			return
+		}
+		specs := applyMonitorOverrides(specsFromState(state), local)
+		rt.UpdateMonitors(specs)
+		logger.Printf("monitor overrides re-applied revision=%s monitors=%d", revision, len(specs))
+	}
+	syncOnce := func(wait time.Duration) error {
+		if err := throttle.Sleep(ctx, time.Until(notBefore)); err != nil {
+			return err
+		}
+		result, err := client.FetchMonitorsWait(ctx, etag, wait)
 		timestamp := time.Now().UTC()
 		if err != nil {
+			throttled(err)
 			if report != nil {
 				report(timestamp, err)
 			}
@@ -373,21 +1174,11 @@ func runMonitorSync(ctx context.Context, client *uplink.Client, rt *runtime.Runt
 		if report != nil {
 			report(timestamp, nil)
 		}
+		unchanged = result.NotModified
 		if !result.NotModified {
-			var (
-				upserts int
-				removed int
-			)
-			if result.Snapshot.Incremental {
-				state, upserts, removed = applyIncrementalSnapshot(state, result.Snapshot)
-			} else {
-				state = snapshotToSpecMap(result.Snapshot)
-				upserts = len(state)
-				removed = 0
-			}
-			specs := specsFromState(state)
-			rt.UpdateMonitors(specs)
-			logger.Printf("monitor sync applied revision=%s incremental=%t upserts=%d removed=%d monitors=%d", result.Snapshot.Revision, result.Snapshot.Incremental, upserts, removed, len(specs))
+			apply(result.Snapshot)
+		} else {
+			reapplyOverrides()
 		}
 		if result.ETag != "" {
 			etag = result.ETag
@@ -395,7 +1186,7 @@ func runMonitorSync(ctx context.Context, client *uplink.Client, rt *runtime.Runt
 		return nil
 	}
 
-	if err := syncOnce(); err != nil {
+	if err := syncOnce(0); err != nil {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
@@ -403,6 +1194,58 @@ func runMonitorSync(ctx context.Context, client *uplink.Client, rt *runtime.Runt
 		}
 	}
 
+	if strings.EqualFold(syncCfg.Mode, monitorSyncModeLongPoll) {
+		for {
+			started := time.Now()
+			err := syncOnce(longPollTimeout)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// A server without long-poll support answers 304 immediately; pace those
+			// like regular polling instead of spinning.
+			if err == nil && !(unchanged && time.Since(started) < longPollTimeout/2) {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+
+	if strings.EqualFold(syncCfg.Mode, monitorSyncModePush) {
+		for {
+			err := client.StreamMonitors(ctx, revision, func(ev uplink.MonitorStreamEvent) error {
+				if report != nil {
+					report(time.Now().UTC(), nil)
+				}
+				if !ev.Keepalive {
+					apply(ev.Snapshot)
+				} else {
+					reapplyOverrides()
+				}
+				return nil
+			})
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, uplink.ErrStreamUnsupported) {
+				logger.Printf("monitor stream unsupported by server; falling back to polling every %s", interval)
+				break
+			}
+			logger.Printf("monitor stream interrupted: %v", err)
+			throttled(err)
+			// Catch up with a regular fetch so pushes missed while disconnected are not lost.
+			_ = syncOnce(0)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+	}
+
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -411,7 +1254,7 @@ func runMonitorSync(ctx context.Context, client *uplink.Client, rt *runtime.Runt
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			_ = syncOnce()
+			_ = syncOnce(0)
 		}
 	}
 }
@@ -508,3 +1351,103 @@ func monitorAssignmentToSpec(mon types.MonitorAssignment) (scheduler.MonitorSpec
 	}
 	return spec, true
 }
+
+// telemetry holds the optional exporters and readiness webhook.
+type telemetry struct {
+	otlp    *metrics.OTLPExporter
+	statsd  *metrics.StatsDExporter
+	push    *metrics.PushExporter
+	webhook *health.Webhook
+}
+
+// newTelemetry builds the exporters cfg enables. Their HTTP requests go
+// through proxy like every other outbound request.
+func newTelemetry(cfg config.Config, metricsStore *metrics.Store, agentID string, proxy netproxy.Func, logger *log.Logger) (telemetry, error) {
+	var out telemetry
+	var err error
+	egress := http.DefaultTransport.(*http.Transport).Clone()
+	egress.Proxy = proxy
+	if otlpCfg := cfg.Metrics.OTLP; strings.TrimSpace(otlpCfg.Endpoint) != "" {
+		out.otlp, err = metrics.NewOTLPExporter(metricsStore, metrics.OTLPOptions{
+			Endpoint:   otlpCfg.Endpoint,
+			Headers:    otlpCfg.Headers,
+			Interval:   otlpCfg.Interval,
+			Timeout:    otlpCfg.Timeout,
+			Resource:   map[string]string{"service.instance.id": agentID},
+			HTTPClient: &http.Client{Transport: egress, Timeout: otlpCfg.Timeout},
+			Logger:     logger,
+		})
+		if err != nil {
+			return telemetry{}, fmt.Errorf("init otlp exporter: %w", err)
+		}
+	}
+
+	if statsdCfg := cfg.Metrics.StatsD; strings.TrimSpace(statsdCfg.Address) != "" {
+		tags := map[string]string{"agent_id": agentID}
+		for k, v := range statsdCfg.Tags {
+			tags[k] = v
+		}
+		out.statsd, err = metrics.NewStatsDExporter(metricsStore, metrics.StatsDOptions{
+			Address:  statsdCfg.Address,
+			Flavor:   statsdCfg.Flavor,
+			Prefix:   statsdCfg.Prefix,
+			Tags:     tags,
+			Interval: statsdCfg.Interval,
+			Logger:   logger,
+		})
+		if err != nil {
+			return telemetry{}, fmt.Errorf("init statsd exporter: %w", err)
+		}
+	}
+
+	if pushCfg := cfg.Metrics.Push; strings.TrimSpace(pushCfg.URL) != "" {
+		out.push, err = metrics.NewPushExporter(metricsStore, metrics.PushOptions{
+			Mode:       pushCfg.Mode,
+			URL:        pushCfg.URL,
+			Job:        pushCfg.Job,
+			Instance:   agentID,
+			Headers:    pushCfg.Headers,
+			Username:   pushCfg.Username,
+			Password:   pushCfg.Password,
+			Interval:   pushCfg.Interval,
+			Timeout:    pushCfg.Timeout,
+			HTTPClient: &http.Client{Transport: egress, Timeout: pushCfg.Timeout},
+			Logger:     logger,
+		})
+		if err != nil {
+			return telemetry{}, fmt.Errorf("init metrics push: %w", err)
+		}
+	}
+
+	if hookCfg := cfg.Health.Webhook; strings.TrimSpace(hookCfg.URL) != "" {
+		out.webhook, err = health.NewWebhook(health.WebhookOptions{
+			URL:         hookCfg.URL,
+			Headers:     hookCfg.Headers,
+			AgentID:     agentID,
+			MaxAttempts: hookCfg.MaxAttempts,
+			Timeout:     hookCfg.Timeout,
+			HTTPClient:  &http.Client{Transport: egress, Timeout: hookCfg.Timeout},
+			Logger:      logger,
+		})
+		if err != nil {
+			return telemetry{}, fmt.Errorf("init readiness webhook: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// directiveHandler acts on controller directives delivered with heartbeat responses.
+func directiveHandler(logger *log.Logger, renewCertificate func(context.Context) error) uplink.DirectiveHandler {
+	return func(ctx context.Context, directive types.Directive) error {
+		switch directive.Type {
+		case types.DirectiveRenewCertificate:
+			logger.Printf("controller requested certificate renewal (directive %s)", directive.ID)
+			if err := renewCertificate(ctx); err != nil {
+				return fmt.Errorf("renew certificate: %w", err)
+			}
+			return nil
+		default:
+			return fmt.Errorf("%w: %s", uplink.ErrDirectiveUnsupported, directive.Type)
+		}
+	}
+}
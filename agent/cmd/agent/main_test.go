@@ -1,9 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/health"
+	"github.com/pingsantohq/agent/internal/logging"
+	"github.com/pingsantohq/agent/internal/metrics"
+	"github.com/pingsantohq/agent/internal/netproxy"
+	"github.com/pingsantohq/agent/internal/runtime"
+	"github.com/pingsantohq/agent/internal/scheduler"
+	"github.com/pingsantohq/agent/internal/worker"
 	"github.com/pingsantohq/agent/pkg/types"
 )
 
@@ -139,3 +157,270 @@ func TestApplyIncrementalSnapshot(t *testing.T) {
 		t.Fatalf("expected m3 to be inserted")
 	}
 }
+
+func TestMonitorOverridesRetimeAndDisable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overrides.yaml")
+	if err := os.WriteFile(path, []byte("disabled: [m2]\nmonitors:\n  m1:\n    cadence: 30s\n    timeout: 5s\n"), 0o600); err != nil {
+		t.Fatalf("write overrides: %v", err)
+	}
+	overrides := newMonitorOverrides(path, nil)
+	local, changed := overrides.refresh()
+	if !changed {
+		t.Fatalf("expected first refresh to report a change")
+	}
+	if _, changed := overrides.refresh(); changed {
+		t.Fatalf("expected unchanged file to be cached")
+	}
+
+	specs := applyMonitorOverrides([]scheduler.MonitorSpec{
+		{MonitorID: "m1", Cadence: 3 * time.Second, Timeout: time.Second},
+		{MonitorID: "m2", Cadence: 3 * time.Second, Timeout: time.Second},
+		{MonitorID: "m3", Cadence: 3 * time.Second, Timeout: time.Second},
+	}, local)
+	if len(specs) != 2 || specs[0].MonitorID != "m1" || specs[1].MonitorID != "m3" {
+		t.Fatalf("expected m2 to be dropped, got %+v", specs)
+	}
+	if specs[0].Cadence != 30*time.Second || specs[0].Timeout != 5*time.Second {
+		t.Fatalf("expected m1 retimed, got %+v", specs[0])
+	}
+	if specs[1].Cadence != 3*time.Second {
+		t.Fatalf("expected m3 untouched, got %+v", specs[1])
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove overrides: %v", err)
+	}
+	if local, changed := overrides.refresh(); !changed || !local.IsZero() {
+		t.Fatalf("expected removal to clear overrides, got %+v changed=%t", local, changed)
+	}
+	if local, changed := (*monitorOverrides)(nil).refresh(); changed || !local.IsZero() {
+		t.Fatalf("expected nil overrides to apply nothing")
+	}
+}
+
+func TestNewMonitorListenerGuardsNonLoopback(t *testing.T) {
+	l, err := newMonitorListener(config.MonitoringConfig{})
+	if err != nil {
+		t.Fatalf("default listener: %v", err)
+	}
+	if l.addr != defaultMetricsAddr || l.tls != nil || l.url("/metrics") != "http://127.0.0.1:9310/metrics" {
+		t.Fatalf("unexpected default listener %+v", l)
+	}
+
+	if _, err := newMonitorListener(config.MonitoringConfig{Listen: "0.0.0.0:9310"}); err == nil {
+		t.Fatalf("expected non-loopback listener without auth to be rejected")
+	}
+
+	cfg := config.MonitoringConfig{Listen: "0.0.0.0:9310"}
+	cfg.Auth.BearerToken = "scrape"
+	if _, err := newMonitorListener(cfg); err != nil {
+		t.Fatalf("expected authenticated listener to be accepted: %v", err)
+	}
+}
+
+func TestReadyzHandlerJSON(t *testing.T) {
+	checker := health.NewChecker(metrics.NewStore(), 10, time.Minute)
+	checker.Register(checker.UplinkCheck())
+	handler := readyzHandler(checker)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "monitors not yet synced\n" {
+		t.Fatalf("unexpected plain response %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz?format=json", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected json response %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var report health.Report
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if report.Ready || len(report.Categories) != 1 || report.Categories[0].Name != "MONITOR_PENDING" || report.Categories[0].Severity != health.SeverityInfo {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(report.Checks) != 6 || report.Checks[1].Name != "monitor_sync" || report.Checks[1].OK || !report.Checks[0].OK {
+		t.Fatalf("unexpected checks %+v", report.Checks)
+	}
+	if report.CheckedAt.IsZero() || report.Since.IsZero() || report.LastMonitorSync != nil {
+		t.Fatalf("unexpected timestamps %+v", report)
+	}
+
+	checker.ObserveMonitorSync(time.Now(), nil)
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz?format=json", nil))
+	report = health.Report{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	if rec.Code != http.StatusOK || !report.Ready || report.LastMonitorSync == nil || len(report.Reasons) != 0 {
+		t.Fatalf("expected ready report, got %d %+v", rec.Code, report)
+	}
+}
+
+func TestApplyReloadResizesRuntime(t *testing.T) {
+	store := metrics.NewStore()
+	rt := runtime.New(
+		runtime.WithQueueCapacity(1024),
+		runtime.WithMetricsStore(store),
+		runtime.WithWorkerOptions(worker.WithWorkerCount(2)),
+	)
+	checker := health.NewChecker(store, 1024, time.Minute)
+	checker.ObserveMonitorSync(time.Now(), nil)
+
+	var cfg config.Config
+	cfg.Run.Workers = 3
+	cfg.Queue.MemItemsCap = 10
+	cfg.Health.QueuePressurePct = 50
+	applyReload(cfg, reloadTargets{runtime: rt, checker: checker}, log.New(io.Discard, "", 0))
+
+	if rt.WorkerCount() != 3 {
+		t.Fatalf("expected 3 workers, got %d", rt.WorkerCount())
+	}
+	if rt.ResultsQueue().Capacity() != 10 {
+		t.Fatalf("expected queue capacity 10, got %d", rt.ResultsQueue().Capacity())
+	}
+	store.QueueRecorder().ObserveQueueDepth(5)
+	if ready, reasons := checker.Ready(time.Now()); ready || reasons[0] != "queue above 50% of capacity" {
+		t.Fatalf("expected reloaded queue thresholds, got ready=%v reasons=%v", ready, reasons)
+	}
+}
+
+func TestApplyOverlayLayersOverLocalConfig(t *testing.T) {
+	store := metrics.NewStore()
+	rt := runtime.New(runtime.WithQueueCapacity(1024), runtime.WithMetricsStore(store))
+	targets := reloadTargets{runtime: rt}
+	logger := log.New(io.Discard, "", 0)
+
+	var base config.Config
+	base.Agent.DataDir = t.TempDir()
+	base.Queue.MemItemsCap = 1024
+	effective := newEffectiveConfig(base, nil)
+
+	applyOverlay(&types.ConfigOverlay{QueueMemItemsCap: 64}, effective, targets, logger)
+	if rt.ResultsQueue().Capacity() != 64 {
+		t.Fatalf("expected overlay queue capacity 64, got %d", rt.ResultsQueue().Capacity())
+	}
+	if persisted, err := config.LoadOverlay(base.Agent.DataDir); err != nil || persisted == nil || persisted.QueueMemItemsCap != 64 {
+		t.Fatalf("expected overlay to be persisted, got %+v, %v", persisted, err)
+	}
+
+	applyOverlay(&types.ConfigOverlay{QueueDiskBytesCap: "lots"}, effective, targets, logger)
+	if rt.ResultsQueue().Capacity() != 64 {
+		t.Fatalf("invalid overlay should be ignored, got capacity %d", rt.ResultsQueue().Capacity())
+	}
+
+	base.Queue.MemItemsCap = 2048
+	if cfg := effective.setBase(base); cfg.Queue.MemItemsCap != 64 {
+		t.Fatalf("overlay should survive a local reload, got %d", cfg.Queue.MemItemsCap)
+	}
+
+	applyOverlay(nil, effective, targets, logger)
+	if rt.ResultsQueue().Capacity() != 2048 {
+		t.Fatalf("expected local capacity after overlay cleared, got %d", rt.ResultsQueue().Capacity())
+	}
+}
+
+func TestApplyOverlayLogLevel(t *testing.T) {
+	rt := runtime.New(runtime.WithQueueCapacity(1024), runtime.WithMetricsStore(metrics.NewStore()))
+	logger := log.New(io.Discard, "", 0)
+	logs := logging.NewOutput(logger)
+	defer logs.Close()
+	targets := reloadTargets{runtime: rt, logs: logs}
+
+	var base config.Config
+	base.Agent.DataDir = t.TempDir()
+	base.Logging.Output = filepath.Join(base.Agent.DataDir, "agent.log")
+	effective := newEffectiveConfig(base, nil)
+	applyReload(effective.current(), targets, logger)
+	logged := func() string {
+		data, _ := os.ReadFile(base.Logging.Output)
+		return string(data)
+	}
+
+	logs.Debug().Printf("hidden")
+	applyOverlay(&types.ConfigOverlay{LogLevel: "debug"}, effective, targets, logger)
+	logs.Debug().Printf("shown")
+	if got := logged(); strings.Contains(got, "hidden") || !strings.Contains(got, "debug: shown") {
+		t.Fatalf("expected only debug lines logged after the overlay, got %q", got)
+	}
+
+	applyOverlay(&types.ConfigOverlay{LogLevel: "loud"}, effective, targets, logger)
+	if cfg := effective.current(); cfg.Logging.Level != "debug" {
+		t.Fatalf("invalid log level should be ignored, got %q", cfg.Logging.Level)
+	}
+
+	applyOverlay(nil, effective, targets, logger)
+	logs.Debug().Printf("quiet again")
+	if strings.Contains(logged(), "quiet again") {
+		t.Fatalf("expected debug lines discarded once the overlay is cleared")
+	}
+}
+
+func TestTelemetryUsesProxy(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]string{}
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute target URL.
+		mu.Lock()
+		seen[r.URL.Host] = r.Method
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxyServer.Close()
+
+	proxy, err := netproxy.FromConfig(config.ProxyConfig{URL: proxyServer.URL})
+	if err != nil {
+		t.Fatalf("proxy: %v", err)
+	}
+	var cfg config.Config
+	cfg.Metrics.OTLP.Endpoint = "http://otel.example.invalid:4318"
+	cfg.Metrics.Push.URL = "http://pushgateway.example.invalid:9091"
+	cfg.Health.Webhook.URL = "http://hooks.example.invalid/ready"
+
+	exporters, err := newTelemetry(cfg, metrics.NewStore(), "agent-1", proxy, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("newTelemetry: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exporters.otlp.Export(ctx); err != nil {
+		t.Fatalf("otlp export: %v", err)
+	}
+	if err := exporters.push.Push(ctx); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	exporters.webhook.Notify(health.Transition{Ready: true, At: time.Now()})
+	hookCtx, stopHook := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = exporters.webhook.Run(hookCtx)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		mu.Lock()
+		_, delivered := seen["hooks.example.invalid"]
+		mu.Unlock()
+		if delivered || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	stopHook()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	for host, method := range map[string]string{
+		"otel.example.invalid:4318":        http.MethodPost,
+		"pushgateway.example.invalid:9091": http.MethodPut,
+		"hooks.example.invalid":            http.MethodPost,
+	} {
+		if seen[host] != method {
+			t.Fatalf("expected %s %s through the proxy, saw %v", method, host, seen)
+		}
+	}
+}
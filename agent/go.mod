@@ -1,19 +1,28 @@
 module github.com/pingsantohq/agent
 
-go 1.23.4
+go 1.24.0
 
 toolchain go1.24.9
 
 require (
+	github.com/BurntSushi/toml v1.5.0
+	github.com/ProtonMail/go-crypto v1.5.2
+	github.com/ThalesIgnite/crypto11 v1.2.5
+	github.com/google/go-tpm v0.9.8
 	github.com/google/uuid v1.6.0
 	github.com/jedisct1/go-minisign v0.0.0-20241212093149-d2f9f49435c7
+	github.com/klauspost/compress v1.18.0
+	golang.org/x/crypto v0.41.0
 	golang.org/x/sync v0.6.0
+	golang.org/x/sys v0.35.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require golang.org/x/time v0.5.0
 
 require (
-	golang.org/x/crypto v0.31.0 // indirect
-	golang.org/x/sys v0.28.0 // indirect
+	github.com/cloudflare/circl v1.6.3 // indirect
+	github.com/miekg/pkcs11 v1.0.3-0.20190429190417-a667d056470f // indirect
+	github.com/pkg/errors v0.8.1 // indirect
+	github.com/thales-e-security/pool v0.0.2 // indirect
 )
@@ -0,0 +1,161 @@
+// Package attest fetches the cloud instance identity document the agent
+// attaches to enrollment requests, so the controller can check which cloud
+// account or project the host runs in.
+package attest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Supported providers.
+const (
+	ProviderAWS   = "aws"
+	ProviderGCP   = "gcp"
+	ProviderAzure = "azure"
+)
+
+// DefaultAzureResource is the token audience requested from Azure when none
+// is configured.
+const DefaultAzureResource = "https://management.azure.com/"
+
+const (
+	defaultMetadataURL = "http://169.254.169.254"
+	maxResponseBytes   = 64 << 10
+)
+
+// Document is the identity evidence sent with an enrollment request. AWS
+// fills Document and Signature (the instance identity document and its
+// base64 RSA-SHA256 signature); GCP and Azure fill Token with a signed JWT.
+type Document struct {
+	Provider  string `json:"provider"`
+	Document  string `json:"document,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Token     string `json:"token,omitempty"`
+}
+
+// Fetcher reads identity documents from the instance metadata service.
+type Fetcher struct {
+	// HTTPClient must not use a proxy: the metadata service is link-local.
+	HTTPClient *http.Client
+	// MetadataURL overrides http://169.254.169.254, for tests.
+	MetadataURL string
+	// Audience is the JWT audience requested on GCP (typically the
+	// controller URL) and the resource requested on Azure.
+	Audience string
+}
+
+// ParseProvider validates a provider name.
+func ParseProvider(raw string) (string, error) {
+	switch p := strings.ToLower(strings.TrimSpace(raw)); p {
+	case ProviderAWS, ProviderGCP, ProviderAzure:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unsupported cloud provider %q (want aws, gcp or azure)", raw)
+	}
+}
+
+// Fetch returns the identity document of the instance the agent runs on.
+func (f Fetcher) Fetch(ctx context.Context, provider string) (Document, error) {
+	switch provider {
+	case ProviderAWS:
+		return f.fetchAWS(ctx)
+	case ProviderGCP:
+		return f.fetchGCP(ctx)
+	case ProviderAzure:
+		return f.fetchAzure(ctx)
+	default:
+		return Document{}, fmt.Errorf("unsupported cloud provider %q", provider)
+	}
+}
+
+// fetchAWS uses IMDSv2: a session token first, then the document and its
+// signature.
+func (f Fetcher) fetchAWS(ctx context.Context) (Document, error) {
+	token, err := f.get(ctx, http.MethodPut, "/latest/api/token", map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": "60"})
+	if err != nil {
+		return Document{}, fmt.Errorf("aws metadata token: %w", err)
+	}
+	headers := map[string]string{"X-aws-ec2-metadata-token": token}
+	doc, err := f.get(ctx, http.MethodGet, "/latest/dynamic/instance-identity/document", headers)
+	if err != nil {
+		return Document{}, fmt.Errorf("aws identity document: %w", err)
+	}
+	sig, err := f.get(ctx, http.MethodGet, "/latest/dynamic/instance-identity/signature", headers)
+	if err != nil {
+		return Document{}, fmt.Errorf("aws identity signature: %w", err)
+	}
+	return Document{Provider: ProviderAWS, Document: doc, Signature: strings.Join(strings.Fields(sig), "")}, nil
+}
+
+func (f Fetcher) fetchGCP(ctx context.Context) (Document, error) {
+	if f.Audience == "" {
+		return Document{}, fmt.Errorf("gcp identity token: audience required")
+	}
+	query := url.Values{"audience": {f.Audience}, "format": {"full"}}
+	token, err := f.get(ctx, http.MethodGet, "/computeMetadata/v1/instance/service-accounts/default/identity?"+query.Encode(), map[string]string{"Metadata-Flavor": "Google"})
+	if err != nil {
+		return Document{}, fmt.Errorf("gcp identity token: %w", err)
+	}
+	return Document{Provider: ProviderGCP, Token: strings.TrimSpace(token)}, nil
+}
+
+// fetchAzure requests a managed identity token; its xms_mirid claim names
+// the VM and the subscription it belongs to.
+func (f Fetcher) fetchAzure(ctx context.Context) (Document, error) {
+	resource := f.Audience
+	if resource == "" {
+		resource = DefaultAzureResource
+	}
+	query := url.Values{"api-version": {"2018-02-01"}, "resource": {resource}}
+	body, err := f.get(ctx, http.MethodGet, "/metadata/identity/oauth2/token?"+query.Encode(), map[string]string{"Metadata": "true"})
+	if err != nil {
+		return Document{}, fmt.Errorf("azure identity token: %w", err)
+	}
+	var payload struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil || payload.AccessToken == "" {
+		return Document{}, fmt.Errorf("azure identity token: unexpected response")
+	}
+	return Document{Provider: ProviderAzure, Token: payload.AccessToken}, nil
+}
+
+func (f Fetcher) get(ctx context.Context, method, path string, headers map[string]string) (string, error) {
+	base := f.MetadataURL
+	if base == "" {
+		base = defaultMetadataURL
+	}
+	client := f.HTTPClient
+	if client == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		client = &http.Client{Timeout: 5 * time.Second, Transport: transport}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(base, "/")+path, nil)
+	if err != nil {
+		return "", err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service returned %s", resp.Status)
+	}
+	return string(body), nil
+}
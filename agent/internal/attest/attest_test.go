@@ -0,0 +1,63 @@
+package attest
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFetcherReadsEachProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				http.Error(w, "bad token request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("imds-token"))
+		case "/latest/dynamic/instance-identity/document", "/latest/dynamic/instance-identity/signature":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "imds-token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path == "/latest/dynamic/instance-identity/document" {
+				w.Write([]byte(`{"accountId":"123456789012"}`))
+			} else {
+				w.Write([]byte("c2ln\nbmF0dXJl\n"))
+			}
+		case "/computeMetadata/v1/instance/service-accounts/default/identity":
+			if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Query().Get("audience") != "https://central.example.com" || r.URL.Query().Get("format") != "full" {
+				http.Error(w, "bad gcp request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("gcp.jwt.token\n"))
+		case "/metadata/identity/oauth2/token":
+			if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("resource") != DefaultAzureResource {
+				http.Error(w, "bad azure request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"access_token":"azure.jwt.token"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	aws, err := Fetcher{MetadataURL: srv.URL}.Fetch(ctx, ProviderAWS)
+	if err != nil || aws.Document != `{"accountId":"123456789012"}` || aws.Signature != "c2lnbmF0dXJl" {
+		t.Fatalf("unexpected aws document %+v, %v", aws, err)
+	}
+	gcp, err := Fetcher{MetadataURL: srv.URL, Audience: "https://central.example.com"}.Fetch(ctx, ProviderGCP)
+	if err != nil || gcp.Token != "gcp.jwt.token" {
+		t.Fatalf("unexpected gcp document %+v, %v", gcp, err)
+	}
+	azure, err := Fetcher{MetadataURL: srv.URL}.Fetch(ctx, ProviderAzure)
+	if err != nil || azure.Token != "azure.jwt.token" || azure.Provider != ProviderAzure {
+		t.Fatalf("unexpected azure document %+v, %v", azure, err)
+	}
+	if _, err := ParseProvider("oracle"); err == nil {
+		t.Fatalf("expected unknown provider to be rejected")
+	}
+}
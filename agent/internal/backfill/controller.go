@@ -2,6 +2,7 @@ package backfill
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"golang.org/x/time/rate"
@@ -11,11 +12,20 @@ import (
 	"github.com/pingsantohq/agent/pkg/types"
 )
 
+// throughputWindow is how long replayed results are accumulated before the
+// replay throughput gauge is refreshed.
+const throughputWindow = 30 * time.Second
+
 type Controller struct {
 	store    *persist.Store
 	limiter  *rate.Limiter
 	maxBatch int
 	metrics  metrics.BackfillRecorder
+	now      func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	windowCount int
 }
 
 type Option func(*Controller)
@@ -54,10 +64,12 @@ func New(store *persist.Store, opts ...Option) *Controller {
 		limiter:  limiter,
 		maxBatch: 256,
 		metrics:  metrics.NoopBackfillRecorder{},
+		now:      time.Now,
 	}
 	for _, opt := range opts {
 		opt(c)
 	}
+	c.windowStart = c.now()
 	c.recordPending()
 	return c
 }
@@ -102,6 +114,10 @@ func (c *Controller) Ack(batch Batch) error {
 	if err := batch.ack(); err != nil {
 		return err
 	}
+	c.metrics.ObserveReplayedBatch(len(batch.Results))
+	c.mu.Lock()
+	c.windowCount += len(batch.Results)
+	c.mu.Unlock()
 	c.recordPending()
 	return nil
 }
@@ -144,4 +160,30 @@ func (c *Controller) recordPending() {
 		return
 	}
 	c.metrics.ObservePendingBytes(c.store.SizeBytes())
+	c.recordReplayProgress()
+}
+
+// recordReplayProgress publishes the age of the oldest spilled result and,
+// once per throughputWindow, the replay rate over that window.
+func (c *Controller) recordReplayProgress() {
+	now := c.now()
+	var lag time.Duration
+	if head, err := c.store.ReadBatch(1); err == nil && len(head.Results) > 0 {
+		if ts := head.Results[0].Timestamp; !ts.IsZero() {
+			lag = now.Sub(ts)
+		}
+	}
+	c.metrics.ObserveReplayLag(lag)
+
+	c.mu.Lock()
+	elapsed := now.Sub(c.windowStart)
+	if elapsed < throughputWindow {
+		c.mu.Unlock()
+		return
+	}
+	perSecond := float64(c.windowCount) / elapsed.Seconds()
+	c.windowStart = now
+	c.windowCount = 0
+	c.mu.Unlock()
+	c.metrics.ObserveReplayThroughput(perSecond)
 }
@@ -125,3 +125,63 @@ func TestControllerMetricsRecorder(t *testing.T) {
 		t.Fatalf("expected pending bytes 0 after ack, got %d", got)
 	}
 }
+
+func TestControllerReplayTelemetry(t *testing.T) {
+	dir := t.TempDir()
+	store, err := persist.Open(filepath.Join(dir, "spill"), 1<<20, 256)
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer store.Close()
+
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		if err := store.Append(types.ProbeResult{MonitorID: "m", Timestamp: base.Add(time.Duration(i) * time.Hour)}); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+
+	now := base.Add(3 * time.Hour)
+	mstore := metrics.NewStore()
+	ctrl := New(store, WithRate(1000, 1000), WithMetrics(mstore.BackfillRecorder()))
+	ctrl.now = func() time.Time { return now }
+	ctrl.windowStart = now
+	ctrl.recordPending()
+
+	if got := mstore.Snapshot().BackfillReplayLagSeconds; got != (3 * time.Hour).Seconds() {
+		t.Fatalf("expected 3h lag before replay, got %vs", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	batch, err := ctrl.Next(ctx, 2)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	now = now.Add(40 * time.Second)
+	if err := ctrl.Ack(batch); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	snap := mstore.Snapshot()
+	if snap.BackfillReplayedBatches != 1 || snap.BackfillReplayedResults != 2 {
+		t.Fatalf("unexpected replay totals: %+v", snap)
+	}
+	if want := (time.Hour + 40*time.Second).Seconds(); snap.BackfillReplayLagSeconds != want {
+		t.Fatalf("expected lag %v after replaying two results, got %v", want, snap.BackfillReplayLagSeconds)
+	}
+	if want := 2.0 / 40; snap.BackfillReplayThroughput != want {
+		t.Fatalf("expected throughput %v, got %v", want, snap.BackfillReplayThroughput)
+	}
+
+	batch, err = ctrl.Next(ctx, 10)
+	if err != nil {
+		t.Fatalf("Next: %v", err)
+	}
+	if err := ctrl.Ack(batch); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if got := mstore.Snapshot().BackfillReplayLagSeconds; got != 0 {
+		t.Fatalf("expected zero lag once drained, got %v", got)
+	}
+}
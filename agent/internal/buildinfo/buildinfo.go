@@ -0,0 +1,59 @@
+// Package buildinfo identifies the running agent binary. Release builds set
+// the variables with -ldflags, e.g.
+//
+//	go build -ldflags "-X github.com/pingsantohq/agent/internal/buildinfo.Version=v1.4.0 \
+//	  -X github.com/pingsantohq/agent/internal/buildinfo.Commit=$(git rev-parse HEAD)" ./cmd/agent
+//
+// Local builds fall back to the module and VCS metadata embedded by the Go
+// toolchain.
+package buildinfo
+
+import (
+	"runtime"
+	"runtime/debug"
+	"sync"
+)
+
+var (
+	Version = ""
+	Commit  = ""
+)
+
+// Info describes the running binary.
+type Info struct {
+	Version   string
+	Commit    string
+	GoVersion string
+}
+
+var (
+	once   sync.Once
+	cached Info
+)
+
+// Get returns the build identity. Unknown fields are reported as "unknown",
+// except Version, which defaults to "dev".
+func Get() Info {
+	once.Do(func() {
+		cached = Info{Version: Version, Commit: Commit, GoVersion: runtime.Version()}
+		if bi, ok := debug.ReadBuildInfo(); ok {
+			if cached.Version == "" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+				cached.Version = bi.Main.Version
+			}
+			if cached.Commit == "" {
+				for _, setting := range bi.Settings {
+					if setting.Key == "vcs.revision" {
+						cached.Commit = setting.Value
+					}
+				}
+			}
+		}
+		if cached.Version == "" {
+			cached.Version = "dev"
+		}
+		if cached.Commit == "" {
+			cached.Commit = "unknown"
+		}
+	})
+	return cached
+}
@@ -8,6 +8,8 @@ import (
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/pingsantohq/agent/internal/attest"
 )
 
 const defaultEnrollPath = "/api/agent/v1/enroll"
@@ -28,20 +30,22 @@ func (h *HTTPIssuer) Enroll(ctx context.Context, req Request) (*Response, error)
 	if req.Server == "" {
 		return nil, fmt.Errorf("server is required")
 	}
-	if req.Token == "" {
+	if req.Token == "" && req.IDToken == "" {
 		return nil, fmt.Errorf("token is required")
 	}
 
 	endpoint := strings.TrimRight(req.Server, "/") + ensurePrefix(h.Path)
 
 	body := struct {
-		Token   string            `json:"token"`
-		Labels  map[string]string `json:"labels,omitempty"`
-		AgentID string            `json:"agent_id,omitempty"`
+		Token       string            `json:"token,omitempty"`
+		Labels      map[string]string `json:"labels,omitempty"`
+		AgentID     string            `json:"agent_id,omitempty"`
+		Attestation *attest.Document  `json:"attestation,omitempty"`
 	}{
-		Token:   req.Token,
-		Labels:  req.Labels,
-		AgentID: req.AgentID,
+		Token:       req.Token,
+		Labels:      req.Labels,
+		AgentID:     req.AgentID,
+		Attestation: req.Attestation,
 	}
 
 	payload, err := json.Marshal(body)
@@ -56,6 +60,9 @@ func (h *HTTPIssuer) Enroll(ctx context.Context, req Request) (*Response, error)
 	httpReq.Header.Set("Content-Type", "application/json")
 	httpReq.Header.Set("Accept", "application/json")
 	httpReq.Header.Set("User-Agent", "pingsanto-agent/0.0.1")
+	if req.IDToken != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+req.IDToken)
+	}
 
 	resp, err := h.Client.Do(httpReq)
 	if err != nil {
@@ -68,11 +75,12 @@ func (h *HTTPIssuer) Enroll(ctx context.Context, req Request) (*Response, error)
 	}
 
 	var payloadResp struct {
-		AgentID    string `json:"agent_id"`
-		CertPEM    string `json:"certificate_pem"`
-		KeyPEM     string `json:"private_key_pem"`
-		CAPEM      string `json:"ca_pem"`
-		ConfigYAML string `json:"config_yaml"`
+		AgentID    string            `json:"agent_id"`
+		CertPEM    string            `json:"certificate_pem"`
+		KeyPEM     string            `json:"private_key_pem"`
+		CAPEM      string            `json:"ca_pem"`
+		ConfigYAML string            `json:"config_yaml"`
+		Labels     map[string]string `json:"labels"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&payloadResp); err != nil {
@@ -85,6 +93,7 @@ func (h *HTTPIssuer) Enroll(ctx context.Context, req Request) (*Response, error)
 		KeyPEM:     []byte(payloadResp.KeyPEM),
 		CAPEM:      []byte(payloadResp.CAPEM),
 		ConfigYAML: []byte(payloadResp.ConfigYAML),
+		Labels:     payloadResp.Labels,
 	}, nil
 }
 
@@ -1,13 +1,23 @@
 package certs
 
-import "context"
+import (
+	"context"
+
+	"github.com/pingsantohq/agent/internal/attest"
+)
 
 type Request struct {
-	Server  string
-	Token   string
+	Server string
+	Token  string
+	// IDToken, used instead of Token, is an OIDC token from the device
+	// authorization flow; it is sent as a bearer credential.
+	IDToken string
 	Labels  map[string]string
 	DataDir string
 	AgentID string
+	// Attestation, when set, is the cloud instance identity document the
+	// controller checks against the token's account scope.
+	Attestation *attest.Document
 }
 
 type Response struct {
@@ -16,6 +26,8 @@ type Response struct {
 	KeyPEM     []byte
 	CAPEM      []byte
 	ConfigYAML []byte
+	// Labels assigned by the enrollment token; they override the agent's own.
+	Labels map[string]string
 }
 
 type Issuer interface {
@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Hardware key URI schemes. A key path starting with one of these names a key
+// held in a device rather than a PEM file.
+const (
+	KeySchemePKCS11 = "pkcs11"
+	KeySchemeTPM2   = "tpm2"
+)
+
+// KeyOpener returns a signer for the hardware key named by uri, e.g.
+// "pkcs11:token=agent;object=client" or "tpm2:handle=0x81000001". The private
+// key never leaves the device; TLS handshakes call Sign on it.
+type KeyOpener func(uri string) (crypto.Signer, error)
+
+var (
+	keyProvidersMu sync.RWMutex
+	keyProviders   = map[string]KeyOpener{}
+)
+
+// RegisterKeyProvider installs the opener for a hardware key scheme. Builds
+// tagged pkcs11 or tpm2 register theirs from init (keys_pkcs11.go,
+// keys_tpm2.go).
+func RegisterKeyProvider(scheme string, open KeyOpener) {
+	keyProvidersMu.Lock()
+	defer keyProvidersMu.Unlock()
+	keyProviders[scheme] = open
+}
+
+// IsKeyURI reports whether keyPath names a hardware key instead of a file.
+func IsKeyURI(keyPath string) bool {
+	scheme, _, ok := strings.Cut(keyPath, ":")
+	return ok && (scheme == KeySchemePKCS11 || scheme == KeySchemeTPM2)
+}
+
+// KeyProviderAvailable reports whether this build can open keys named by uri.
+func KeyProviderAvailable(uri string) bool {
+	scheme, _, _ := strings.Cut(uri, ":")
+	keyProvidersMu.RLock()
+	defer keyProvidersMu.RUnlock()
+	_, ok := keyProviders[scheme]
+	return ok
+}
+
+// LoadKeyPair loads the client certificate at certPath with its private key.
+// keyPath is either a PEM file or a hardware key URI; for the latter the
+// returned certificate's PrivateKey is the device-backed crypto.Signer.
+func LoadKeyPair(certPath, keyPath string) (tls.Certificate, error) {
+	if !IsKeyURI(keyPath) {
+		return tls.LoadX509KeyPair(certPath, keyPath)
+	}
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	var certificate tls.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			certificate.Certificate = append(certificate.Certificate, block.Bytes)
+		}
+	}
+	if len(certificate.Certificate) == 0 {
+		return tls.Certificate{}, fmt.Errorf("no certificate found in %s", certPath)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("parse certificate: %w", err)
+	}
+
+	scheme, _, _ := strings.Cut(keyPath, ":")
+	keyProvidersMu.RLock()
+	open := keyProviders[scheme]
+	keyProvidersMu.RUnlock()
+	if open == nil {
+		return tls.Certificate{}, fmt.Errorf("%s keys are not supported by this agent build", scheme)
+	}
+	signer, err := open(keyPath)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("open %s key: %w", scheme, err)
+	}
+	if !publicKeysEqual(leaf.PublicKey, signer.Public()) {
+		return tls.Certificate{}, errors.New("private key in device does not match certificate public key")
+	}
+	certificate.PrivateKey = signer
+	certificate.Leaf = leaf
+	return certificate, nil
+}
+
+func publicKeysEqual(a, b crypto.PublicKey) bool {
+	key, ok := a.(interface{ Equal(crypto.PublicKey) bool })
+	return ok && key.Equal(b)
+}
@@ -0,0 +1,80 @@
+//go:build pkcs11 && cgo
+
+package certs
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ThalesIgnite/crypto11"
+)
+
+// Builds with -tags pkcs11 open RFC 7512 keys such as
+// "pkcs11:token=pingsanto;object=agent-client?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:/etc/pingsanto/pin".
+// The token is chosen by "token" (label) or "serial", the key by "object"
+// (label) and/or "id".
+func init() {
+	RegisterKeyProvider(KeySchemePKCS11, openPKCS11Key)
+}
+
+// pkcs11Contexts keeps one logged-in session pool per module and token;
+// the library must not be initialised again on every credential reload.
+var (
+	pkcs11ContextsMu sync.Mutex
+	pkcs11Contexts   = map[crypto11.Config]*crypto11.Context{}
+)
+
+func openPKCS11Key(uri string) (crypto.Signer, error) {
+	parsed, err := ParseKeyURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	module := parsed.Query["module-path"]
+	if module == "" {
+		return nil, errors.New("pkcs11 key URI needs module-path")
+	}
+	pin, err := parsed.PIN()
+	if err != nil {
+		return nil, err
+	}
+	cfg := crypto11.Config{
+		Path:        module,
+		TokenLabel:  parsed.Path["token"],
+		TokenSerial: parsed.Path["serial"],
+		Pin:         pin,
+	}
+	if cfg.TokenLabel == "" && cfg.TokenSerial == "" {
+		return nil, errors.New("pkcs11 key URI needs token or serial")
+	}
+	var id, label []byte
+	if v, ok := parsed.Path["id"]; ok {
+		id = []byte(v)
+	}
+	if v, ok := parsed.Path["object"]; ok {
+		label = []byte(v)
+	}
+	if id == nil && label == nil {
+		return nil, errors.New("pkcs11 key URI needs object or id")
+	}
+
+	pkcs11ContextsMu.Lock()
+	defer pkcs11ContextsMu.Unlock()
+	ctx, ok := pkcs11Contexts[cfg]
+	if !ok {
+		ctx, err = crypto11.Configure(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("open token: %w", err)
+		}
+		pkcs11Contexts[cfg] = ctx
+	}
+	signer, err := ctx.FindKeyPair(id, label)
+	if err != nil {
+		return nil, fmt.Errorf("find key: %w", err)
+	}
+	if signer == nil {
+		return nil, errors.New("no key pair matches the URI")
+	}
+	return signer, nil
+}
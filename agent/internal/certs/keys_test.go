@@ -0,0 +1,95 @@
+package certs
+
+import (
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadKeyPairUsesHardwareKeyProvider(t *testing.T) {
+	caCert, caKey := mustCreateCA(t)
+	serverCert, serverKey := mustCreateServerCert(t, caCert, caKey)
+	clientCertPEM, clientKeyPEM := mustCreateClientCert(t, caCert, caKey)
+	_, otherKeyPEM := mustCreateClientCert(t, caCert, caKey)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "client.crt")
+	if err := os.WriteFile(certPath, clientCertPEM, 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+
+	// Builds tagged pkcs11 or tpm2 register real providers; swap them out so
+	// only the fake device below is available.
+	keyProvidersMu.Lock()
+	registered := keyProviders
+	keyProviders = map[string]KeyOpener{}
+	keyProvidersMu.Unlock()
+	t.Cleanup(func() {
+		keyProvidersMu.Lock()
+		keyProviders = registered
+		keyProvidersMu.Unlock()
+	})
+
+	// The fake device holds PEM keys by object name.
+	keys := map[string][]byte{"tpm2:handle=0x81000001": clientKeyPEM, "tpm2:handle=0x81000002": otherKeyPEM}
+	RegisterKeyProvider(KeySchemeTPM2, func(uri string) (crypto.Signer, error) {
+		block, _ := pem.Decode(keys[uri])
+		if block == nil {
+			return nil, errors.New("no such object")
+		}
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	})
+
+	if !IsKeyURI("tpm2:handle=0x81000001") || IsKeyURI(certPath) || IsKeyURI("C:\\keys\\client.key") {
+		t.Fatalf("unexpected IsKeyURI classification")
+	}
+	if !KeyProviderAvailable("tpm2:handle=0x81000001") || KeyProviderAvailable("pkcs11:object=client") {
+		t.Fatalf("unexpected provider availability")
+	}
+
+	certificate, err := LoadKeyPair(certPath, "tpm2:handle=0x81000001")
+	if err != nil {
+		t.Fatalf("LoadKeyPair: %v", err)
+	}
+	if _, ok := certificate.PrivateKey.(crypto.Signer); !ok || certificate.Leaf == nil {
+		t.Fatalf("expected signer-backed certificate, got %+v", certificate)
+	}
+
+	if _, err := LoadKeyPair(certPath, "tpm2:handle=0x81000002"); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Fatalf("expected key mismatch error, got %v", err)
+	}
+	if _, err := LoadKeyPair(certPath, "pkcs11:object=client"); err == nil || !strings.Contains(err.Error(), "not supported by this agent build") {
+		t.Fatalf("expected unsupported provider error, got %v", err)
+	}
+
+	serverTLSCert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("load server keypair: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverTLSCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := VerifyKeyPairVia(ctx, server.URL, nil, certPath, "tpm2:handle=0x81000001", caCert, nil, nil); err != nil {
+		t.Fatalf("handshake with hardware key: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+//go:build tpm2 && linux
+
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"sync"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+	"github.com/google/go-tpm/tpmutil"
+)
+
+// Builds with -tags tpm2 open "tpm2:handle=0x81000001" keys: a persistent,
+// unrestricted signing key in the TPM. "?device=/dev/tpm0" overrides the
+// resource manager at /dev/tpmrm0, and "pin-value" or "pin-source" supplies
+// the key's authorization value.
+func init() {
+	RegisterKeyProvider(KeySchemeTPM2, openTPM2Key)
+}
+
+const defaultTPMDevice = "/dev/tpmrm0"
+
+// tpmDevice serializes commands to one open TPM. Devices stay open for the
+// life of the process so credential reloads do not leak file descriptors.
+type tpmDevice struct {
+	mu sync.Mutex
+	rw io.ReadWriteCloser
+}
+
+var (
+	tpmDevicesMu sync.Mutex
+	tpmDevices   = map[string]*tpmDevice{}
+)
+
+func openTPMDevice(path string) (*tpmDevice, error) {
+	tpmDevicesMu.Lock()
+	defer tpmDevicesMu.Unlock()
+	if dev, ok := tpmDevices[path]; ok {
+		return dev, nil
+	}
+	rw, err := tpm2.OpenTPM(path)
+	if err != nil {
+		return nil, err
+	}
+	dev := &tpmDevice{rw: rw}
+	tpmDevices[path] = dev
+	return dev, nil
+}
+
+func openTPM2Key(uri string) (crypto.Signer, error) {
+	parsed, err := ParseKeyURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	handleValue, ok := parsed.Path["handle"]
+	if !ok {
+		return nil, errors.New("tpm2 key URI needs handle=0x...")
+	}
+	handle, err := strconv.ParseUint(handleValue, 0, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handle %q: %w", handleValue, err)
+	}
+	password, err := parsed.PIN()
+	if err != nil {
+		return nil, err
+	}
+	path := parsed.Query["device"]
+	if path == "" {
+		path = defaultTPMDevice
+	}
+	dev, err := openTPMDevice(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+
+	dev.mu.Lock()
+	public, _, _, err := tpm2.ReadPublic(dev.rw, tpmutil.Handle(handle))
+	dev.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("read public key at %s: %w", handleValue, err)
+	}
+	if public.Attributes&tpm2.FlagSign == 0 || public.Attributes&tpm2.FlagRestricted != 0 {
+		return nil, fmt.Errorf("key at %s is not an unrestricted signing key", handleValue)
+	}
+	pub, err := public.Key()
+	if err != nil {
+		return nil, err
+	}
+	return &tpmKey{dev: dev, handle: tpmutil.Handle(handle), password: password, public: pub}, nil
+}
+
+// tpmKey signs TLS handshakes with a key that never leaves the TPM.
+type tpmKey struct {
+	dev      *tpmDevice
+	handle   tpmutil.Handle
+	password string
+	public   crypto.PublicKey
+}
+
+func (k *tpmKey) Public() crypto.PublicKey { return k.public }
+
+func (k *tpmKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash, err := tpm2.HashToAlgorithm(opts.HashFunc())
+	if err != nil {
+		return nil, err
+	}
+	scheme := &tpm2.SigScheme{Hash: hash}
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		scheme.Alg = tpm2.AlgRSASSA
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			scheme.Alg = tpm2.AlgRSAPSS
+		}
+	case *ecdsa.PublicKey:
+		scheme.Alg = tpm2.AlgECDSA
+	default:
+		return nil, fmt.Errorf("unsupported TPM key type %T", k.public)
+	}
+
+	k.dev.mu.Lock()
+	sig, err := tpm2.Sign(k.dev.rw, k.handle, k.password, digest, nil, scheme)
+	k.dev.mu.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("tpm2 sign: %w", err)
+	}
+	switch {
+	case sig.RSA != nil:
+		return sig.RSA.Signature, nil
+	case sig.ECC != nil:
+		return asn1.Marshal(struct{ R, S *big.Int }{sig.ECC.R, sig.ECC.S})
+	default:
+		return nil, errors.New("tpm2 sign: empty signature")
+	}
+}
@@ -0,0 +1,78 @@
+package certs
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// KeyURI is a parsed hardware key URI in the RFC 7512 layout:
+// "scheme:attr=value;attr=value?query=value&query=value". Path attributes
+// name the key ("token", "object", "id", "handle"); query attributes say how
+// to reach it ("module-path", "device", "pin-value", "pin-source").
+type KeyURI struct {
+	Scheme string
+	Path   map[string]string
+	Query  map[string]string
+}
+
+// ParseKeyURI parses a pkcs11: or tpm2: key URI. Values are percent-decoded.
+func ParseKeyURI(uri string) (KeyURI, error) {
+	scheme, rest, ok := strings.Cut(uri, ":")
+	if !ok || (scheme != KeySchemePKCS11 && scheme != KeySchemeTPM2) {
+		return KeyURI{}, fmt.Errorf("unsupported key URI %q", uri)
+	}
+	path, query, _ := strings.Cut(rest, "?")
+	parsed := KeyURI{Scheme: scheme, Path: map[string]string{}, Query: map[string]string{}}
+	if err := parseKeyURIAttrs(path, ";", parsed.Path); err != nil {
+		return KeyURI{}, fmt.Errorf("key URI %q: %w", uri, err)
+	}
+	if err := parseKeyURIAttrs(query, "&", parsed.Query); err != nil {
+		return KeyURI{}, fmt.Errorf("key URI %q: %w", uri, err)
+	}
+	return parsed, nil
+}
+
+func parseKeyURIAttrs(s, sep string, into map[string]string) error {
+	if s == "" {
+		return nil
+	}
+	for _, attr := range strings.Split(s, sep) {
+		name, value, ok := strings.Cut(attr, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("invalid attribute %q", attr)
+		}
+		decoded, err := url.PathUnescape(value)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+		if _, dup := into[name]; dup {
+			return fmt.Errorf("duplicate attribute %q", name)
+		}
+		into[name] = decoded
+	}
+	return nil
+}
+
+// PIN returns the PIN or password the URI carries: "pin-value" inline, or
+// "pin-source=file:/path" read from a file so it stays out of agent.yaml.
+// An empty PIN is returned when neither is set.
+func (u KeyURI) PIN() (string, error) {
+	if value, ok := u.Query["pin-value"]; ok {
+		return value, nil
+	}
+	source, ok := u.Query["pin-source"]
+	if !ok {
+		return "", nil
+	}
+	path, ok := strings.CutPrefix(source, "file:")
+	if !ok {
+		return "", fmt.Errorf("unsupported pin-source %q, want file:/path", source)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read pin-source: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,53 @@
+package certs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseKeyURI(t *testing.T) {
+	pinPath := filepath.Join(t.TempDir(), "pin")
+	if err := os.WriteFile(pinPath, []byte("1234\n"), 0o600); err != nil {
+		t.Fatalf("write pin: %v", err)
+	}
+
+	uri, err := ParseKeyURI("pkcs11:token=ping%20santo;object=agent-client?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:" + pinPath)
+	if err != nil {
+		t.Fatalf("ParseKeyURI: %v", err)
+	}
+	if uri.Scheme != KeySchemePKCS11 || uri.Path["token"] != "ping santo" || uri.Path["object"] != "agent-client" {
+		t.Fatalf("unexpected path attributes: %+v", uri)
+	}
+	if uri.Query["module-path"] != "/usr/lib/softhsm/libsofthsm2.so" {
+		t.Fatalf("unexpected module path %q", uri.Query["module-path"])
+	}
+	if pin, err := uri.PIN(); err != nil || pin != "1234" {
+		t.Fatalf("PIN() = %q, %v", pin, err)
+	}
+
+	uri, err = ParseKeyURI("tpm2:handle=0x81000001")
+	if err != nil {
+		t.Fatalf("ParseKeyURI tpm2: %v", err)
+	}
+	if uri.Path["handle"] != "0x81000001" || len(uri.Query) != 0 {
+		t.Fatalf("unexpected tpm2 URI: %+v", uri)
+	}
+	if pin, err := uri.PIN(); err != nil || pin != "" {
+		t.Fatalf("expected no PIN, got %q, %v", pin, err)
+	}
+
+	for _, bad := range []string{
+		"file:/etc/key.pem",
+		"pkcs11:token",
+		"pkcs11:object=a;object=b",
+		"tpm2:handle=%zz",
+	} {
+		if _, err := ParseKeyURI(bad); err == nil {
+			t.Fatalf("expected error for %q", bad)
+		}
+	}
+	if _, err := (KeyURI{Query: map[string]string{"pin-source": "env:PIN"}}).PIN(); err == nil {
+		t.Fatalf("expected error for unsupported pin-source")
+	}
+}
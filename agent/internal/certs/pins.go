@@ -0,0 +1,81 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const pinPrefix = "sha256/"
+
+// SPKIPin returns the pin of cert's public key in the "sha256/<base64>" form
+// accepted by PinControllerSPKI.
+func SPKIPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return pinPrefix + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// ParsePins validates SPKI pins written as "sha256/<base64>" (the prefix is
+// optional) and returns their digests.
+func ParsePins(pins []string) ([][]byte, error) {
+	digests := make([][]byte, 0, len(pins))
+	for _, pin := range pins {
+		raw := strings.TrimPrefix(strings.TrimSpace(pin), pinPrefix)
+		digest, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("invalid SPKI pin %q: want sha256/<base64 of the SHA-256 of the public key>", pin)
+		}
+		digests = append(digests, digest)
+	}
+	return digests, nil
+}
+
+// PinControllerSPKI makes cfg reject servers whose verified certificate chain
+// contains none of pins, so a certificate from another CA in the trust bundle
+// cannot impersonate the controller. Pinning a backup key, or the issuing CA,
+// keeps rotations possible. An empty pins list leaves cfg unchanged.
+func PinControllerSPKI(cfg *tls.Config, pins []string) error {
+	if len(pins) == 0 {
+		return nil
+	}
+	digests, err := ParsePins(pins)
+	if err != nil {
+		return err
+	}
+	next := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := matchPins(cs, digests); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+	return nil
+}
+
+func matchPins(cs tls.ConnectionState, digests [][]byte) error {
+	chains := cs.VerifiedChains
+	if len(chains) == 0 {
+		chains = [][]*x509.Certificate{cs.PeerCertificates}
+	}
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, digest := range digests {
+				if string(sum[:]) == string(digest) {
+					return nil
+				}
+			}
+		}
+	}
+	if len(cs.PeerCertificates) > 0 {
+		return fmt.Errorf("controller certificate does not match any pinned key (server presented %s)", SPKIPin(cs.PeerCertificates[0]))
+	}
+	return errors.New("controller certificate does not match any pinned key")
+}
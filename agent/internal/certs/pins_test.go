@@ -0,0 +1,70 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinControllerSPKI(t *testing.T) {
+	caCert, caKey := mustCreateCA(t)
+	serverCert, serverKey := mustCreateServerCert(t, caCert, caKey)
+	clientCertPEM, clientKeyPEM := mustCreateClientCert(t, caCert, caKey)
+	otherCA, _ := mustCreateCA(t)
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, "client.crt")
+	keyPath := filepath.Join(dir, "client.key")
+	if err := os.WriteFile(certPath, clientCertPEM, 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyPath, clientKeyPEM, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	serverTLSCert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("load server keypair: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverTLSCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	leaf, _ := x509.ParseCertificate(serverTLSCert.Certificate[0])
+	ca, _ := parseCert(caCert)
+	other, _ := parseCert(otherCA)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for name, pins := range map[string][]string{
+		"leaf":         {SPKIPin(leaf)},
+		"issuing CA":   {SPKIPin(other), SPKIPin(ca)},
+		"without pins": nil,
+		"bare base64":  {strings.TrimPrefix(SPKIPin(leaf), "sha256/")},
+	} {
+		if err := VerifyKeyPairVia(ctx, server.URL, nil, certPath, keyPath, caCert, pins, nil); err != nil {
+			t.Fatalf("%s: expected pinned handshake to succeed, got %v", name, err)
+		}
+	}
+	err = VerifyKeyPairVia(ctx, server.URL, nil, certPath, keyPath, caCert, []string{SPKIPin(other)}, nil)
+	if err == nil || !strings.Contains(err.Error(), "does not match any pinned key") || !strings.Contains(err.Error(), SPKIPin(leaf)) {
+		t.Fatalf("expected pin mismatch naming the served key, got %v", err)
+	}
+	if _, err := ParsePins([]string{"sha256/not-base64"}); err == nil {
+		t.Fatalf("expected malformed pin to be rejected")
+	}
+}
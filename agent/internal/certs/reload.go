@@ -0,0 +1,175 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReloadInterval is how often a ReloadingTransport checks its files.
+const DefaultReloadInterval = 30 * time.Second
+
+// TLSLoader builds the client TLS configuration and returns the files it was
+// read from (certificate, key, CA bundles and the state file naming them).
+type TLSLoader func() (*tls.Config, []string, error)
+
+// ReloadingTransport is an http.RoundTripper whose TLS configuration is
+// rebuilt when any file it was loaded from changes, so rotated credentials
+// are picked up without restarting the agent. Requests in flight finish on
+// the connection they started on; new requests use the new configuration.
+type ReloadingTransport struct {
+	template *http.Transport
+	load     TLSLoader
+	logger   *log.Logger
+
+	current atomic.Pointer[http.Transport]
+
+	mu       sync.Mutex
+	stamps   map[string]fileStamp
+	onReload []func(*tls.Config)
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+// NewReloadingTransport loads the initial TLS configuration. template
+// supplies every other transport setting; its TLSClientConfig is ignored.
+func NewReloadingTransport(template *http.Transport, load TLSLoader, logger *log.Logger) (*ReloadingTransport, error) {
+	if template == nil || load == nil {
+		return nil, errors.New("transport template and TLS loader required")
+	}
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	t := &ReloadingTransport{template: template, load: load, logger: logger}
+	cfg, files, err := load()
+	if err != nil {
+		return nil, err
+	}
+	t.install(cfg, files, nil)
+	return t, nil
+}
+
+// TLSConfig returns the configuration new connections use.
+func (t *ReloadingTransport) TLSConfig() *tls.Config {
+	return t.current.Load().TLSClientConfig
+}
+
+// OnReload registers fn to be called with every reloaded configuration.
+func (t *ReloadingTransport) OnReload(fn func(*tls.Config)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onReload = append(t.onReload, fn)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ReloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.current.Load().RoundTrip(req)
+}
+
+// CloseIdleConnections closes idle connections of the current transport.
+func (t *ReloadingTransport) CloseIdleConnections() {
+	t.current.Load().CloseIdleConnections()
+}
+
+// Reload rebuilds the TLS configuration if a watched file changed since the
+// last successful load and reports whether it did. On error, e.g. a key that
+// no longer matches a half-written certificate, the previous configuration
+// stays in use and the next call retries.
+func (t *ReloadingTransport) Reload() (bool, error) {
+	t.mu.Lock()
+	changed := false
+	seen := make(map[string]fileStamp, len(t.stamps))
+	for path, stamp := range t.stamps {
+		seen[path] = statFile(path)
+		if seen[path] != stamp {
+			changed = true
+		}
+	}
+	t.mu.Unlock()
+	if !changed {
+		return false, nil
+	}
+
+	cfg, files, err := t.load()
+	if err != nil {
+		return false, fmt.Errorf("reload TLS credentials: %w", err)
+	}
+	previous := t.current.Load()
+	// Stamps taken before loading, so a file rewritten while it was being
+	// read triggers another reload.
+	t.install(cfg, files, seen)
+	previous.CloseIdleConnections()
+
+	t.mu.Lock()
+	callbacks := slices.Clone(t.onReload)
+	t.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(cfg)
+	}
+	return true, nil
+}
+
+// Run checks for changed files every interval until ctx ends.
+func (t *ReloadingTransport) Run(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := t.Reload()
+			switch {
+			case err != nil:
+				t.logger.Printf("%v; keeping previous credentials", err)
+			case reloaded:
+				t.logger.Printf("TLS credentials reloaded")
+			}
+		}
+	}
+}
+
+func (t *ReloadingTransport) install(cfg *tls.Config, files []string, seen map[string]fileStamp) {
+	stamps := make(map[string]fileStamp, len(files))
+	for _, path := range files {
+		if path == "" || IsKeyURI(path) {
+			continue
+		}
+		if stamp, ok := seen[path]; ok {
+			stamps[path] = stamp
+		} else {
+			stamps[path] = statFile(path)
+		}
+	}
+	next := t.template.Clone()
+	next.TLSClientConfig = cfg
+	t.current.Store(next)
+
+	t.mu.Lock()
+	t.stamps = stamps
+	t.mu.Unlock()
+}
+
+func statFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
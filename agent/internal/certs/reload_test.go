@@ -0,0 +1,103 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReloadingTransportPicksUpRotatedCertificate(t *testing.T) {
+	caCert, caKey := mustCreateCA(t)
+	serverCert, serverKey := mustCreateServerCert(t, caCert, caKey)
+	serverTLSCert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("load server keypair: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caCert)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+		io.WriteString(w, hex.EncodeToString(sum[:]))
+	}))
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverTLSCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	certPath, keyPath, caPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caPath, caCert, 0o600); err != nil {
+		t.Fatalf("write CA: %v", err)
+	}
+	generation := 0
+	install := func(certPEM, keyPEM []byte) string {
+		generation++
+		stamp := time.Now().Add(time.Duration(generation) * time.Minute)
+		for path, data := range map[string][]byte{certPath: certPEM, keyPath: keyPEM} {
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatalf("write %s: %v", path, err)
+			}
+			os.Chtimes(path, stamp, stamp)
+		}
+		cert, _ := parseCert(certPEM)
+		sum := sha256.Sum256(cert.Raw)
+		return hex.EncodeToString(sum[:])
+	}
+	firstCert, firstKey := mustCreateClientCert(t, caCert, caKey)
+	first := install(firstCert, firstKey)
+
+	reloads := 0
+	transport, err := NewReloadingTransport(&http.Transport{}, func() (*tls.Config, []string, error) {
+		cfg, err := LoadClientTLSConfig(certPath, keyPath, []string{caPath}, server.URL)
+		return cfg, []string{certPath, keyPath, caPath}, err
+	}, nil)
+	if err != nil {
+		t.Fatalf("NewReloadingTransport: %v", err)
+	}
+	transport.OnReload(func(*tls.Config) { reloads++ })
+	presented := func() string {
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := presented(); got != first {
+		t.Fatalf("expected initial certificate, got %s", got)
+	}
+	if reloaded, err := transport.Reload(); reloaded || err != nil {
+		t.Fatalf("expected no reload without changes, got %t, %v", reloaded, err)
+	}
+
+	secondCert, secondKey := mustCreateClientCert(t, caCert, caKey)
+	second := install(secondCert, secondKey)
+	if reloaded, err := transport.Reload(); !reloaded || err != nil {
+		t.Fatalf("expected reload after rotation, got %t, %v", reloaded, err)
+	}
+	if got := presented(); got != second || reloads != 1 {
+		t.Fatalf("expected rotated certificate after %d reloads, got %s", reloads, got)
+	}
+
+	// A half-finished rotation leaves the last good credentials in place.
+	install(firstCert, secondKey)
+	if reloaded, err := transport.Reload(); reloaded || err == nil {
+		t.Fatalf("expected mismatched key pair to fail, got %t, %v", reloaded, err)
+	}
+	if got := presented(); got != second {
+		t.Fatalf("expected previous certificate to stay in use, got %s", got)
+	}
+}
@@ -0,0 +1,146 @@
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultRenewPath = "/api/agent/v1/certificate"
+
+// Renewer replaces the agent's client certificate in place, answering the
+// controller's renew_certificate directive without an enrollment token. It
+// generates a new key, has the controller sign a request for it over the
+// current mTLS connection, and swaps the certificate and key files. The
+// private key never leaves the host.
+type Renewer struct {
+	// Client must present the agent's current client certificate.
+	Client *http.Client
+	Server string
+	Path   string
+}
+
+// Renew obtains a certificate for a new key and writes both over paths.Cert
+// and paths.Key. It returns the new certificate.
+func (r *Renewer) Renew(ctx context.Context, agentID string, paths Paths) (*x509.Certificate, error) {
+	if r.Client == nil || r.Server == "" {
+		return nil, errors.New("renew certificate: client and server required")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate key: %w", err)
+	}
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: agentID}}, key)
+	if err != nil {
+		return nil, fmt.Errorf("create certificate request: %w", err)
+	}
+	payload, err := json.Marshal(struct {
+		CSRPEM string `json:"csr_pem"`
+	}{CSRPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))})
+	if err != nil {
+		return nil, err
+	}
+
+	path := r.Path
+	if path == "" {
+		path = defaultRenewPath
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(r.Server, "/")+ensurePrefix(path), bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("build renewal request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := r.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("perform renewal request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("renewal failed: status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var out struct {
+		CertPEM string `json:"certificate_pem"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode renewal response: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("encode key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	pair, err := tls.X509KeyPair([]byte(out.CertPEM), keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("renewed certificate does not match the new key: %w", err)
+	}
+	if err := ReplaceKeyPair(paths, []byte(out.CertPEM), keyPEM); err != nil {
+		return nil, err
+	}
+	return pair.Leaf, nil
+}
+
+// ReplaceKeyPair writes certPEM and keyPEM next to paths.Cert and paths.Key
+// and renames them into place, so neither file is ever half written. The key
+// is renamed first: a reload between the two renames sees a mismatched pair,
+// fails, and keeps the previous credentials until the certificate lands.
+func ReplaceKeyPair(paths Paths, certPEM, keyPEM []byte) error {
+	if paths.Cert == "" || paths.Key == "" {
+		return errors.New("replace key pair: certificate and key paths required")
+	}
+	keyTmp, err := writeTemp(paths.Key, keyPEM)
+	if err != nil {
+		return err
+	}
+	certTmp, err := writeTemp(paths.Cert, certPEM)
+	if err != nil {
+		os.Remove(keyTmp)
+		return err
+	}
+	if err := os.Rename(keyTmp, paths.Key); err != nil {
+		os.Remove(keyTmp)
+		os.Remove(certTmp)
+		return fmt.Errorf("replace key %q: %w", paths.Key, err)
+	}
+	if err := os.Rename(certTmp, paths.Cert); err != nil {
+		os.Remove(certTmp)
+		return fmt.Errorf("replace certificate %q: %w", paths.Cert, err)
+	}
+	return nil
+}
+
+func writeTemp(path string, data []byte) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return "", fmt.Errorf("create temporary file for %q: %w", path, err)
+	}
+	name := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(name)
+		return "", fmt.Errorf("write %q: %w", path, err)
+	}
+	return name, nil
+}
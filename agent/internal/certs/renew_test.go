@@ -0,0 +1,136 @@
+package certs
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenewerReplacesKeyPair(t *testing.T) {
+	caPEM, caKey := mustCreateCA(t)
+	caCert, err := parseCert(caPEM)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultRenewPath || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		var req struct {
+			CSRPEM string `json:"csr_pem"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		block, _ := pem.Decode([]byte(req.CSRPEM))
+		if block == nil || block.Type != "CERTIFICATE REQUEST" {
+			http.Error(w, "bad csr", http.StatusBadRequest)
+			return
+		}
+		csr, err := x509.ParseCertificateRequest(block.Bytes)
+		if err != nil || csr.CheckSignature() != nil {
+			http.Error(w, "bad csr", http.StatusBadRequest)
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(10),
+			Subject:      csr.Subject,
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(24 * time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, caCert, csr.PublicKey, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"certificate_pem": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+			"ca_pem":          string(caPEM),
+		})
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	oldCert, oldKey := mustCreateClientCert(t, caPEM, caKey)
+	paths := Paths{Cert: filepath.Join(dir, "agent.crt"), Key: filepath.Join(dir, "agent.key")}
+	if err := os.WriteFile(paths.Cert, oldCert, 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(paths.Key, oldKey, 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	renewer := &Renewer{Client: server.Client(), Server: server.URL}
+	leaf, err := renewer.Renew(context.Background(), "agent-test", paths)
+	if err != nil {
+		t.Fatalf("renew: %v", err)
+	}
+	if leaf.Subject.CommonName != "agent-test" {
+		t.Fatalf("unexpected subject %q", leaf.Subject.CommonName)
+	}
+
+	certPEM, err := os.ReadFile(paths.Cert)
+	if err != nil {
+		t.Fatalf("read cert: %v", err)
+	}
+	keyPEM, err := os.ReadFile(paths.Key)
+	if err != nil {
+		t.Fatalf("read key: %v", err)
+	}
+	if string(certPEM) == string(oldCert) || string(keyPEM) == string(oldKey) {
+		t.Fatalf("expected certificate and key to be replaced")
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatalf("renewed files do not form a key pair: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected no temporary files left behind, got %d entries", len(entries))
+	}
+}
+
+func TestRenewerKeepsFilesOnFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "certificate renewal requires mTLS", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	paths := Paths{Cert: filepath.Join(dir, "agent.crt"), Key: filepath.Join(dir, "agent.key")}
+	if err := os.WriteFile(paths.Cert, []byte("cert"), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(paths.Key, []byte("key"), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	renewer := &Renewer{Client: server.Client(), Server: server.URL}
+	if _, err := renewer.Renew(context.Background(), "agent-test", paths); err == nil {
+		t.Fatalf("expected renewal error")
+	}
+	if data, _ := os.ReadFile(paths.Cert); string(data) != "cert" {
+		t.Fatalf("certificate changed after failed renewal: %q", data)
+	}
+	if data, _ := os.ReadFile(paths.Key); string(data) != "key" {
+		t.Fatalf("key changed after failed renewal: %q", data)
+	}
+}
@@ -0,0 +1,25 @@
+package certs
+
+import (
+	"crypto/tls"
+
+	"github.com/pingsantohq/agent/pkg/revocation"
+)
+
+// CheckControllerRevocation makes cfg reject controllers whose certificate
+// checker reports revoked. A nil or disabled checker leaves cfg unchanged.
+func CheckControllerRevocation(cfg *tls.Config, checker *revocation.Checker) {
+	if !checker.Enabled() {
+		return
+	}
+	next := cfg.VerifyConnection
+	cfg.VerifyConnection = func(cs tls.ConnectionState) error {
+		if err := checker.VerifyConnection(cs); err != nil {
+			return err
+		}
+		if next != nil {
+			return next(cs)
+		}
+		return nil
+	}
+}
@@ -9,9 +9,11 @@ import (
 )
 
 // LoadClientTLSConfig loads an mTLS client configuration using the provided
-// certificate, key, and optional CA bundle. The serverURL is used to derive the
-// expected ServerName for TLS verification.
-func LoadClientTLSConfig(certPath, keyPath, caPath, serverURL string) (*tls.Config, error) {
+// certificate, key, and optional CA bundles; the controller may present a
+// chain from any of them. keyPath may be a hardware key URI (see
+// LoadKeyPair). The serverURL is used to derive the expected ServerName for
+// TLS verification.
+func LoadClientTLSConfig(certPath, keyPath string, caPaths []string, serverURL string) (*tls.Config, error) {
 	if certPath == "" || keyPath == "" {
 		return nil, fmt.Errorf("client certificate and key paths must be provided")
 	}
@@ -19,19 +21,42 @@ func LoadClientTLSConfig(certPath, keyPath, caPath, serverURL string) (*tls.Conf
 		return nil, fmt.Errorf("server URL must be provided")
 	}
 
-	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+	certificate, err := LoadKeyPair(certPath, keyPath)
 	if err != nil {
 		return nil, fmt.Errorf("load client certificate: %w", err)
 	}
 
+	tlsConfig, err := clientTLSConfig(caPaths, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.Certificates = []tls.Certificate{certificate}
+	return tlsConfig, nil
+}
+
+// ClientTLSConfigFunc is LoadClientTLSConfig for a certificate that rotates
+// at runtime: getCert is consulted on every handshake.
+func ClientTLSConfigFunc(getCert func(*tls.CertificateRequestInfo) (*tls.Certificate, error), caPaths []string, serverURL string) (*tls.Config, error) {
+	if serverURL == "" {
+		return nil, fmt.Errorf("server URL must be provided")
+	}
+	tlsConfig, err := clientTLSConfig(caPaths, serverURL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.GetClientCertificate = getCert
+	return tlsConfig, nil
+}
+
+func clientTLSConfig(caPaths []string, serverURL string) (*tls.Config, error) {
+	caPEM, err := ReadCABundles(caPaths)
+	if err != nil {
+		return nil, err
+	}
 	var roots *x509.CertPool
-	if caPath != "" {
-		data, err := os.ReadFile(caPath)
-		if err != nil {
-			return nil, fmt.Errorf("read CA bundle: %w", err)
-		}
+	if len(caPEM) > 0 {
 		roots = x509.NewCertPool()
-		if !roots.AppendCertsFromPEM(data) {
+		if !roots.AppendCertsFromPEM(caPEM) {
 			return nil, fmt.Errorf("invalid CA bundle")
 		}
 	}
@@ -44,11 +69,30 @@ func LoadClientTLSConfig(certPath, keyPath, caPath, serverURL string) (*tls.Conf
 		return nil, fmt.Errorf("server URL missing hostname")
 	}
 
-	tlsConfig := &tls.Config{
-		Certificates: []tls.Certificate{certificate},
-		MinVersion:   tls.VersionTLS12,
-		RootCAs:      roots,
-		ServerName:   parsed.Hostname(),
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    roots,
+		ServerName: parsed.Hostname(),
+	}, nil
+}
+
+// ReadCABundles concatenates the PEM CA bundles at paths, rejecting any file
+// without a certificate so a truncated bundle is not silently ignored.
+func ReadCABundles(paths []string) ([]byte, error) {
+	var bundle []byte
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read CA bundle: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("invalid CA bundle %s", path)
+		}
+		bundle = append(bundle, data...)
+		bundle = append(bundle, '\n')
 	}
-	return tlsConfig, nil
+	return bundle, nil
 }
@@ -0,0 +1,61 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadClientTLSConfigTrustsEveryCABundle(t *testing.T) {
+	oldCA, oldKey := mustCreateCA(t)
+	newCA, newKey := mustCreateCA(t)
+	serverCert, serverKey := mustCreateServerCert(t, newCA, newKey)
+	clientCert, clientKey := mustCreateClientCert(t, oldCA, oldKey)
+
+	dir := t.TempDir()
+	files := map[string][]byte{"client.crt": clientCert, "client.key": clientKey, "ca.pem": oldCA, "ca-next.pem": newCA}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+	}
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverTLSCert, err := tls.X509KeyPair(serverCert, serverKey)
+	if err != nil {
+		t.Fatalf("load server keypair: %v", err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{serverTLSCert}}
+	server.StartTLS()
+	defer server.Close()
+
+	get := func(caPaths []string) error {
+		cfg, err := LoadClientTLSConfig(path("client.crt"), path("client.key"), caPaths, server.URL)
+		if err != nil {
+			t.Fatalf("LoadClientTLSConfig: %v", err)
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err := get([]string{path("ca.pem")}); err == nil {
+		t.Fatalf("expected controller on the new CA to be rejected with only the old bundle")
+	}
+	if err := get([]string{path("ca.pem"), path("ca-next.pem")}); err != nil {
+		t.Fatalf("expected controller on the new CA to be trusted during rotation, got %v", err)
+	}
+
+	if err := os.WriteFile(path("empty.pem"), []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("write empty bundle: %v", err)
+	}
+	if _, err := ReadCABundles([]string{path("ca.pem"), path("empty.pem")}); err == nil {
+		t.Fatalf("expected bundle without certificates to be rejected")
+	}
+}
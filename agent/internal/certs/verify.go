@@ -6,16 +6,44 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/pingsantohq/agent/pkg/revocation"
 )
 
 func VerifyConnection(ctx context.Context, serverURL string, certPEM, keyPEM, caPEM []byte) error {
+	return VerifyConnectionVia(ctx, serverURL, nil, certPEM, keyPEM, caPEM)
+}
+
+// VerifyConnectionVia performs the mTLS handshake check through the supplied
+// proxy selector. A nil proxy dials the server directly.
+func VerifyConnectionVia(ctx context.Context, serverURL string, proxy func(*http.Request) (*url.URL, error), certPEM, keyPEM, caPEM []byte) error {
 	if len(certPEM) == 0 || len(keyPEM) == 0 {
 		return fmt.Errorf("client certificate or key missing")
 	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("load client certificate: %w", err)
+	}
+	return verifyCertificate(ctx, serverURL, proxy, certificate, caPEM, nil, nil)
+}
+
+// VerifyKeyPairVia is VerifyConnectionVia for a certificate and key on the
+// host; keyPath may be a hardware key URI (see LoadKeyPair). A non-empty pins
+// list also checks the controller against SPKI pins (see PinControllerSPKI),
+// and a non-nil revocation checker its revocation status.
+func VerifyKeyPairVia(ctx context.Context, serverURL string, proxy func(*http.Request) (*url.URL, error), certPath, keyPath string, caPEM []byte, pins []string, checker *revocation.Checker) error {
+	certificate, err := LoadKeyPair(certPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("load client certificate: %w", err)
+	}
+	return verifyCertificate(ctx, serverURL, proxy, certificate, caPEM, pins, checker)
+}
 
+func verifyCertificate(ctx context.Context, serverURL string, proxy func(*http.Request) (*url.URL, error), certificate tls.Certificate, caPEM []byte, pins []string, checker *revocation.Checker) error {
 	parsedURL, err := url.Parse(serverURL)
 	if err != nil {
 		return fmt.Errorf("parse server url: %w", err)
@@ -30,11 +58,6 @@ func VerifyConnection(ctx context.Context, serverURL string, certPEM, keyPEM, ca
 		peer = net.JoinHostPort(parsedURL.Host, "443")
 	}
 
-	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
-	if err != nil {
-		return fmt.Errorf("load client certificate: %w", err)
-	}
-
 	var roots *x509.CertPool
 	if len(caPEM) > 0 {
 		roots = x509.NewCertPool()
@@ -53,6 +76,14 @@ func VerifyConnection(ctx context.Context, serverURL string, certPEM, keyPEM, ca
 	if host := parsedURL.Hostname(); host != "" {
 		tlsConfig.ServerName = host
 	}
+	if err := PinControllerSPKI(tlsConfig, pins); err != nil {
+		return err
+	}
+	CheckControllerRevocation(tlsConfig, checker)
+
+	if proxy != nil {
+		return verifyViaProxy(ctx, serverURL, proxy, tlsConfig)
+	}
 
 	dialer := &net.Dialer{Timeout: 5 * time.Second}
 	if deadline, ok := ctx.Deadline(); ok {
@@ -80,3 +111,28 @@ func VerifyConnection(ctx context.Context, serverURL string, certPEM, keyPEM, ca
 
 	return nil
 }
+
+// verifyViaProxy tunnels through the proxy with an HTTPS request; any HTTP
+// response proves the mutual TLS handshake succeeded.
+func verifyViaProxy(ctx context.Context, serverURL string, proxy func(*http.Request) (*url.URL, error), tlsConfig *tls.Config) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           proxy,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, serverURL, nil)
+	if err != nil {
+		return fmt.Errorf("build verification request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tls handshake via proxy failed: %w", err)
+	}
+	resp.Body.Close()
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return fmt.Errorf("no peer certificates received")
+	}
+	return nil
+}
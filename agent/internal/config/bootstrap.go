@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+// DefaultBootstrapPath is where install packages place the optional bootstrap plan.
+const DefaultBootstrapPath = "/etc/pingsanto/bootstrap.yaml"
+
+// Bootstrap is a cold-start plan shipped inside the install package. It names the
+// controller to contact and a set of lifeline monitors the agent runs until the
+// controller delivers its first monitor snapshot.
+type Bootstrap struct {
+	Server   string                    `yaml:"server"`
+	Monitors []types.MonitorAssignment `yaml:"monitors"`
+}
+
+// LoadBootstrap reads the bootstrap plan at path. A missing file is not an error;
+// ok reports whether a plan was found.
+func LoadBootstrap(path string) (plan Bootstrap, ok bool, err error) {
+	if path == "" {
+		return Bootstrap{}, false, nil
+	}
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return Bootstrap{}, false, nil
+		}
+		return Bootstrap{}, false, fmt.Errorf("read bootstrap plan %q: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return Bootstrap{}, false, fmt.Errorf("parse bootstrap plan %q: %w", path, err)
+	}
+	return plan, true, nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadBootstrap(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "bootstrap.yaml")
+	data := []byte(`server: https://central.example.com
+monitors:
+  - monitor_id: lifeline_gw
+    protocol: icmp
+    targets: ["192.0.2.1"]
+    cadence_ms: 5000
+`)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write bootstrap: %v", err)
+	}
+
+	plan, ok, err := LoadBootstrap(path)
+	if err != nil || !ok {
+		t.Fatalf("LoadBootstrap: ok=%t err=%v", ok, err)
+	}
+	if plan.Server != "https://central.example.com" {
+		t.Fatalf("unexpected server: %s", plan.Server)
+	}
+	if len(plan.Monitors) != 1 || plan.Monitors[0].MonitorID != "lifeline_gw" || plan.Monitors[0].CadenceMillis != 5000 {
+		t.Fatalf("unexpected monitors: %+v", plan.Monitors)
+	}
+}
+
+func TestLoadBootstrapMissingFile(t *testing.T) {
+	_, ok, err := LoadBootstrap(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil || ok {
+		t.Fatalf("expected missing bootstrap to be ignored, ok=%t err=%v", ok, err)
+	}
+}
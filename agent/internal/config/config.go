@@ -2,12 +2,17 @@ package config
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"gopkg.in/yaml.v3"
 )
 
@@ -17,23 +22,260 @@ const (
 )
 
 type Config struct {
-	Agent  AgentConfig `yaml:"agent"`
-	Queue  QueueConfig `yaml:"queue"`
-	Probes ProbeConfig `yaml:"probes"`
-	Run    RunConfig   `yaml:"run"`
+	// Version is the schema version of the file. Older versions are migrated
+	// on load (see CurrentConfigVersion); after Load it is always current.
+	Version     int               `yaml:"version" env:"-"`
+	Agent       AgentConfig       `yaml:"agent"`
+	Queue       QueueConfig       `yaml:"queue"`
+	Probes      ProbeConfig       `yaml:"probes"`
+	Run         RunConfig         `yaml:"run"`
+	Transmit    TransmitConfig    `yaml:"transmit"`
+	MonitorSync MonitorSyncConfig `yaml:"monitor_sync"`
+	Proxy       ProxyConfig       `yaml:"proxy"`
+	Metrics     MetricsConfig     `yaml:"metrics"`
+	Debug       DebugConfig       `yaml:"debug"`
+	Monitoring  MonitoringConfig  `yaml:"monitoring"`
+	Health      HealthConfig      `yaml:"health"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Upgrade     UpgradeConfig     `yaml:"upgrade"`
+}
+
+// UpgradeConfig tunes how upgrade artifacts are fetched. DownloadRateLimit
+// caps artifact download throughput as a size per second (e.g. 2MiB), so a
+// fleet-wide rollout leaves room on shared uplinks; empty means unlimited.
+// VerifyWindow bounds how long a freshly upgraded agent has to reach the
+// controller and sync monitors before the previous binary is restored
+// (default 5m). The previous binary is also restored, and the new version
+// marked bad, when the upgraded agent is restarted CrashLoopRestarts times
+// (default 3) within CrashLoopWindow of the upgrade (default 10m).
+// MaintenanceWindows, such as "Sat 02:00-04:00" in local time, limit when
+// plans are applied; empty means any time. AllowedChannels restricts the
+// channels this host may follow, e.g. ["stable"] on production sites; empty
+// allows any. KeepBundles is how many versions' downloads and extracted
+// bundles stay under data_dir/upgrades (default 3). PlanSync "push" also
+// holds the controller's plan stream open so published plans arrive without
+// waiting for the next poll; "poll" (default) only polls.
+type UpgradeConfig struct {
+	DownloadRateLimit  string        `yaml:"download_rate_limit"`
+	VerifyWindow       time.Duration `yaml:"verify_window"`
+	CrashLoopRestarts  int           `yaml:"crash_loop_restarts"`
+	CrashLoopWindow    time.Duration `yaml:"crash_loop_window"`
+	MaintenanceWindows []string      `yaml:"maintenance_windows"`
+	AllowedChannels    []string      `yaml:"allowed_channels"`
+	KeepBundles        int           `yaml:"keep_bundles"`
+	PlanSync           string        `yaml:"plan_sync"`
+
+	Signature SignatureConfig `yaml:"signature"`
+}
+
+// SignatureConfig selects how upgrade artifact signatures are verified. Type
+// "minisign" (default) uses the embedded key or PINGSANTO_AGENT_MINISIGN_PUBKEY.
+// Type "cosign" verifies `cosign sign-blob` signatures: with PublicKeyFile
+// against that key, otherwise keyless against the Fulcio roots and Rekor key,
+// requiring a certificate for CertificateIdentity issued via
+// CertificateOIDCIssuer. Type "gpg" verifies detached OpenPGP signatures
+// against the keyring in PublicKeyFile.
+type SignatureConfig struct {
+	Type                  string `yaml:"type"`
+	PublicKeyFile         string `yaml:"public_key_file"`
+	FulcioRootsFile       string `yaml:"fulcio_roots_file"`
+	RekorPublicKeyFile    string `yaml:"rekor_public_key_file"`
+	CertificateIdentity   string `yaml:"certificate_identity"`
+	CertificateOIDCIssuer string `yaml:"certificate_oidc_issuer"`
+}
+
+// LoggingConfig selects where the agent log is written: "stdout" (default),
+// "stderr" or a file path. A file is reopened on SIGHUP, so logrotate can
+// move it aside without copytruncate. Level is "info" (default) or "debug".
+type LoggingConfig struct {
+	Output string `yaml:"output"`
+	Level  string `yaml:"level"`
 }
 
 type RunConfig struct {
 	Workers        int           `yaml:"workers"`
 	TickResolution time.Duration `yaml:"tick_resolution"`
+	// DedupeProbes probes identical monitor definitions once per tick and
+	// reports the result under every matching monitor ID.
+	DedupeProbes bool `yaml:"dedupe_probes"`
+}
+
+// TransmitConfig bounds how results are coalesced before upload. A batch is
+// flushed once BatchSize results are buffered or the oldest has waited MaxLatency.
+type TransmitConfig struct {
+	BatchSize  int           `yaml:"batch_size"`
+	MaxLatency time.Duration `yaml:"max_latency"`
+}
+
+// MonitorSyncConfig selects how monitor assignments are received. Mode "poll"
+// (the default) fetches on an interval; "push" holds a stream open to the
+// controller and falls back to polling when the stream is unavailable;
+// "long_poll" asks the controller to hold each fetch for up to LongPollTimeout
+// until the revision changes.
+type MonitorSyncConfig struct {
+	Mode            string        `yaml:"mode"`
+	LongPollTimeout time.Duration `yaml:"long_poll_timeout"`
+	// OverridesFile optionally names a MonitorOverrides file that disables or
+	// retimes controller-assigned monitors on this agent only.
+	OverridesFile string `yaml:"overrides_file"`
+}
+
+// ProxyConfig routes every outbound connection to the controller through an
+// explicit proxy. URL accepts http://, https://, socks5:// and socks5h://
+// schemes; Username and Password override any credentials embedded in URL.
+// Hosts matching NoProxy (exact names, ".domain" suffixes, IPs or CIDRs) are
+// dialled directly. When URL is empty the standard proxy environment
+// variables apply.
+type ProxyConfig struct {
+	URL      string   `yaml:"url"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	NoProxy  []string `yaml:"no_proxy"`
+}
+
+// HealthConfig tunes readiness thresholds. QueuePressurePct is the queue fill
+// percentage that reports QUEUE_PRESSURE (default 100); MonitorStaleAfter is
+// how long without a successful monitor sync before MONITOR_STALE (default
+// three sync intervals); CertExpiryWarning is how far ahead of expiry
+// CERT_EXPIRING is raised (default 1h). LivenessWindow is how long the
+// scheduler, or workers with pending jobs, may go without progress before
+// /livez fails (default 2m). StartupGrace keeps a fresh agent ready while it
+// waits for its first monitor sync (default 0, disabled).
+// UplinkFailureThreshold is how many consecutive failed result uploads report
+// UPLINK_DOWN (default 3).
+type HealthConfig struct {
+	QueuePressurePct       int           `yaml:"queue_pressure_pct"`
+	MonitorStaleAfter      time.Duration `yaml:"monitor_stale_after"`
+	CertExpiryWarning      time.Duration `yaml:"cert_expiry_warning"`
+	LivenessWindow         time.Duration `yaml:"liveness_window"`
+	StartupGrace           time.Duration `yaml:"startup_grace"`
+	UplinkFailureThreshold int           `yaml:"uplink_failure_threshold"`
+	// Webhook posts a JSON notification whenever readiness flips.
+	Webhook ReadinessWebhookConfig `yaml:"webhook"`
+}
+
+// ReadinessWebhookConfig enables readiness transition notifications when URL
+// is set. Readiness is re-evaluated every Interval (default 15s) so flips are
+// noticed without /readyz being polled; failed deliveries are retried up to
+// MaxAttempts times (default 5) with exponential backoff.
+type ReadinessWebhookConfig struct {
+	URL         string            `yaml:"url"`
+	Headers     map[string]string `yaml:"headers"`
+	Interval    time.Duration     `yaml:"interval"`
+	Timeout     time.Duration     `yaml:"timeout"`
+	MaxAttempts int               `yaml:"max_attempts"`
+}
+
+// MonitoringConfig controls the local metrics/health listener. Listen
+// defaults to 127.0.0.1:9310; binding any non-loopback address requires Auth
+// credentials or a TLS client CA. TLS is enabled when CertFile is set.
+type MonitoringConfig struct {
+	Listen string               `yaml:"listen"`
+	TLS    MonitoringTLSConfig  `yaml:"tls"`
+	Auth   MonitoringAuthConfig `yaml:"auth"`
+}
+
+type MonitoringTLSConfig struct {
+	CertFile     string `yaml:"cert_file"`
+	KeyFile      string `yaml:"key_file"`
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// MonitoringAuthConfig accepts a bearer token, basic auth, or both.
+type MonitoringAuthConfig struct {
+	BearerToken string `yaml:"bearer_token"`
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+}
+
+// DebugConfig exposes runtime profiling on the monitoring listener. When
+// Pprof is set, /debug/pprof/ is served to loopback clients, and to remote
+// clients presenting PprofToken as a bearer token.
+type DebugConfig struct {
+	Pprof      bool   `yaml:"pprof"`
+	PprofToken string `yaml:"pprof_token"`
+}
+
+// MetricsConfig controls how agent metrics leave the host besides the local
+// Prometheus endpoint.
+type MetricsConfig struct {
+	OTLP   OTLPConfig   `yaml:"otlp"`
+	StatsD StatsDConfig `yaml:"statsd"`
+	Push   PushConfig   `yaml:"push"`
+}
+
+// OTLPConfig pushes metrics to an OpenTelemetry collector over OTLP/HTTP.
+// Export is disabled when Endpoint is empty. Headers are sent with every
+// request, typically for collector authentication.
+type OTLPConfig struct {
+	Endpoint string            `yaml:"endpoint"`
+	Headers  map[string]string `yaml:"headers"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
+}
+
+// StatsDConfig pushes metrics to a local StatsD or DogStatsD daemon. Address
+// is host:port (UDP) or unix:///path for a datagram socket; export is disabled
+// when it is empty. Flavor is "dogstatsd" (default) or "statsd".
+type StatsDConfig struct {
+	Address  string            `yaml:"address"`
+	Flavor   string            `yaml:"flavor"`
+	Prefix   string            `yaml:"prefix"`
+	Tags     map[string]string `yaml:"tags"`
+	Interval time.Duration     `yaml:"interval"`
+}
+
+// PushConfig periodically writes the metrics snapshot to a Prometheus
+// Pushgateway or remote_write endpoint for agents that cannot be scraped.
+// Push is disabled when URL is empty. Mode is "pushgateway" (default) or
+// "remote_write"; Job defaults to pingsanto-agent.
+type PushConfig struct {
+	Mode     string            `yaml:"mode"`
+	URL      string            `yaml:"url"`
+	Job      string            `yaml:"job"`
+	Headers  map[string]string `yaml:"headers"`
+	Username string            `yaml:"username"`
+	Password string            `yaml:"password"`
+	Interval time.Duration     `yaml:"interval"`
+	Timeout  time.Duration     `yaml:"timeout"`
 }
 
 type AgentConfig struct {
-	Server         string                `yaml:"server"`
-	DataDir        string                `yaml:"data_dir"`
-	Labels         []string              `yaml:"labels"`
-	HeartbeatSec   int                   `yaml:"heartbeat_sec"`
-	RateGovernance *RateGovernanceConfig `yaml:"rate_governance"`
+	Server        string   `yaml:"server"`
+	DataDir       string   `yaml:"data_dir"`
+	Labels        []string `yaml:"labels"`
+	HeartbeatSec  int      `yaml:"heartbeat_sec"`
+	BootstrapPath string   `yaml:"bootstrap_path"`
+	// ClientKey overrides the key_path recorded in state.yaml. A pkcs11: or
+	// tpm2: URI keeps the client key in a hardware device.
+	ClientKey string `yaml:"client_key"`
+	// SPIFFESocket, when set, takes the client certificate from a SPIFFE
+	// Workload API endpoint (unix:///run/spire/sockets/agent.sock) instead of
+	// state.yaml and follows SVID rotations without a restart.
+	SPIFFESocket string `yaml:"spiffe_socket"`
+	// ControllerPins are SPKI pins ("sha256/<base64>") for the controller's
+	// certificate chain; when set, a chain matching none is rejected even if
+	// a trusted CA issued it.
+	ControllerPins []string `yaml:"controller_pins"`
+	// Revocation checks the controller certificate chain against OCSP and
+	// CRLs.
+	Revocation RevocationConfig `yaml:"revocation"`
+	// ClockSkewThreshold is the tolerated offset from the controller clock before
+	// readiness reports CLOCK_SKEW (default 5s).
+	ClockSkewThreshold time.Duration         `yaml:"clock_skew_threshold"`
+	RateGovernance     *RateGovernanceConfig `yaml:"rate_governance"`
+}
+
+// RevocationConfig selects how a revoked or unverifiable controller
+// certificate is handled. Mode "off" (default) skips the check; "soft" rejects
+// certificates reported revoked but tolerates unreachable OCSP responders and
+// CRL distribution points; "hard" also rejects certificates whose status
+// cannot be determined. CRLFiles are local CRLs consulted first; Timeout
+// bounds each OCSP or CRL fetch (default 5s).
+type RevocationConfig struct {
+	Mode     string        `yaml:"mode"`
+	CRLFiles []string      `yaml:"crl_files"`
+	Timeout  time.Duration `yaml:"timeout"`
 }
 
 type RateGovernanceConfig struct {
@@ -54,27 +296,133 @@ type ProbeConfig struct {
 	DNSResolvers []string `yaml:"dns_resolvers"`
 }
 
+// Load reads the config at path, merges any drop-in files from
+// DropInDir(path) over it, applies PINGSANTO_* environment overrides on top
+// and resolves secret references (see ResolveSecrets). Files ending in .json
+// or .toml are parsed as JSON or TOML using the same keys as YAML; anything
+// else is parsed as YAML. A missing file is tolerated when overrides are set,
+// so containers can be configured from the environment alone.
 func Load(ctx context.Context, path string) (Config, error) {
+	return load(path, false)
+}
+
+// LoadStrict is Load, but fails on keys that match no config field (e.g. a
+// typo like heartbeat_secs) instead of silently ignoring them.
+func LoadStrict(ctx context.Context, path string) (Config, error) {
+	return load(path, true)
+}
+
+func load(path string, strict bool) (Config, error) {
 	var cfg Config
 
-	f, err := os.Open(filepath.Clean(path))
-	if err != nil {
+	data, err := os.ReadFile(filepath.Clean(path))
+	switch {
+	case errors.Is(err, fs.ErrNotExist) && hasEnvOverrides(os.LookupEnv):
+	case err != nil:
 		return cfg, fmt.Errorf("open config %q: %w", path, err)
+	default:
+		if err := decodeConfig(path, data, &cfg, strict); err != nil {
+			return cfg, fmt.Errorf("parse config %q: %w", path, err)
+		}
 	}
-	defer f.Close()
 
-	data, err := io.ReadAll(f)
-	if err != nil {
-		return cfg, fmt.Errorf("read config %q: %w", path, err)
+	if err := loadDropIns(DropInDir(path), &cfg, strict); err != nil {
+		return cfg, err
 	}
 
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return cfg, fmt.Errorf("parse config %q: %w", path, err)
-	}
+	cfg.Version = CurrentConfigVersion
 
+	if err := ApplyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	if err := ResolveSecrets(&cfg); err != nil {
+		return cfg, err
+	}
 	return cfg, nil
 }
 
+// DropInDir returns the drop-in directory for the config at path, e.g.
+// /etc/pingsanto/agent.yaml.d for /etc/pingsanto/agent.yaml.
+func DropInDir(path string) string {
+	return filepath.Clean(path) + ".d"
+}
+
+// loadDropIns merges every .yaml, .yml, .json and .toml file in dir over cfg
+// in lexical order. Fields set in a drop-in replace the base value; maps are
+// merged key by key and lists are replaced whole. A missing dir is ignored.
+func loadDropIns(dir string, cfg *Config, strict bool) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read config drop-ins %q: %w", dir, err)
+	}
+	// os.ReadDir sorts entries by filename.
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml", ".json", ".toml":
+		default:
+			continue
+		}
+		path := filepath.Join(dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("open config drop-in %q: %w", path, err)
+		}
+		if err := decodeConfig(path, data, cfg, strict); err != nil {
+			return fmt.Errorf("parse config drop-in %q: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// decodeConfig unmarshals data into cfg according to the file extension.
+// JSON and TOML documents are decoded through YAML so the yaml struct tags
+// and duration parsing apply unchanged. Every document is migrated to
+// CurrentConfigVersion before it is applied. In strict mode unknown keys are
+// an error.
+func decodeConfig(path string, data []byte, cfg *Config, strict bool) error {
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		if !json.Valid(data) {
+			var scratch any
+			return json.Unmarshal(data, &scratch)
+		}
+	case ".toml":
+		doc = map[string]any{}
+		if _, err := toml.Decode(string(data), &doc); err != nil {
+			return err
+		}
+	}
+	if doc == nil {
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		if doc == nil {
+			return nil
+		}
+	}
+	if err := migrateConfig(doc); err != nil {
+		return err
+	}
+	if strict {
+		if unknown := unknownKeys(doc, reflect.TypeOf(Config{}), ""); len(unknown) > 0 {
+			return fmt.Errorf("unknown config keys: %s", strings.Join(unknown, ", "))
+		}
+	}
+	migrated, err := yaml.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(migrated, cfg)
+}
+
 func LoadFromEnv(ctx context.Context) (Config, error) {
 	path := os.Getenv(envConfigPath)
 	if path == "" {
@@ -2,9 +2,12 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 const sampleYAML = `
@@ -20,6 +23,23 @@ queue:
 probes:
   workers: auto
   dns_resolvers: [system]
+transmit:
+  batch_size: 512
+  max_latency: 1500ms
+monitor_sync:
+  mode: push
+  long_poll_timeout: 45s
+proxy:
+  url: socks5://proxy.corp.example:1080
+  username: svc-pingsanto
+  password: s3cret
+  no_proxy:
+    - .internal.example
+health:
+  queue_pressure_pct: 80
+  monitor_stale_after: 10m
+  cert_expiry_warning: 168h
+  startup_grace: 90s
 `
 
 func TestLoad(t *testing.T) {
@@ -45,6 +65,18 @@ func TestLoad(t *testing.T) {
 	if len(cfg.Probes.DNSResolvers) != 1 || cfg.Probes.DNSResolvers[0] != "system" {
 		t.Fatalf("unexpected dns resolvers: %#v", cfg.Probes.DNSResolvers)
 	}
+	if cfg.Transmit.BatchSize != 512 || cfg.Transmit.MaxLatency != 1500*time.Millisecond {
+		t.Fatalf("unexpected transmit config: %+v", cfg.Transmit)
+	}
+	if cfg.MonitorSync.Mode != "push" || cfg.MonitorSync.LongPollTimeout != 45*time.Second {
+		t.Fatalf("unexpected monitor sync config: %+v", cfg.MonitorSync)
+	}
+	if cfg.Proxy.URL != "socks5://proxy.corp.example:1080" || cfg.Proxy.Username != "svc-pingsanto" || len(cfg.Proxy.NoProxy) != 1 {
+		t.Fatalf("unexpected proxy config: %+v", cfg.Proxy)
+	}
+	if cfg.Health.QueuePressurePct != 80 || cfg.Health.MonitorStaleAfter != 10*time.Minute || cfg.Health.CertExpiryWarning != 168*time.Hour || cfg.Health.StartupGrace != 90*time.Second {
+		t.Fatalf("unexpected health config: %+v", cfg.Health)
+	}
 }
 
 func TestLoadFromEnv(t *testing.T) {
@@ -67,3 +99,198 @@ func TestLoadFromEnv(t *testing.T) {
 		t.Fatalf("unexpected data dir: %s", cfg.Agent.DataDir)
 	}
 }
+
+const sampleJSON = `{
+  "agent": {"server": "https://central.example.com", "labels": ["site=ATL-1"], "heartbeat_sec": 15},
+  "queue": {"mem_items_cap": 200000, "spill_to_disk": true},
+  "transmit": {"max_latency": "1500ms"},
+  "health": {"webhook": {"url": "https://hooks.example.com/ready", "headers": {"X-Token": "abc"}}}
+}`
+
+const sampleTOML = `
+# provisioned by fleet tooling
+[agent]
+server = "https://central.example.com"
+labels = [
+  "site=ATL-1", # trailing comment
+  'env=prod',
+]
+heartbeat_sec = 15
+
+[queue]
+mem_items_cap = 200_000
+spill_to_disk = true
+
+[transmit]
+max_latency = "1500ms"
+
+[health.webhook]
+url = "https://hooks.example.com/ready"
+headers = { X-Token = "abc" }
+`
+
+func TestLoadJSONAndTOML(t *testing.T) {
+	for name, body := range map[string]string{"agent.json": sampleJSON, "agent.toml": sampleTOML} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+				t.Fatalf("write config: %v", err)
+			}
+			cfg, err := Load(context.Background(), path)
+			if err != nil {
+				t.Fatalf("Load returned error: %v", err)
+			}
+			if cfg.Agent.Server != "https://central.example.com" || cfg.Agent.HeartbeatSec != 15 || cfg.Agent.Labels[0] != "site=ATL-1" {
+				t.Fatalf("unexpected agent config: %+v", cfg.Agent)
+			}
+			if cfg.Queue.MemItemsCap != 200000 || !cfg.Queue.SpillToDisk {
+				t.Fatalf("unexpected queue config: %+v", cfg.Queue)
+			}
+			if cfg.Transmit.MaxLatency != 1500*time.Millisecond {
+				t.Fatalf("unexpected max latency: %s", cfg.Transmit.MaxLatency)
+			}
+			if cfg.Health.Webhook.URL != "https://hooks.example.com/ready" || cfg.Health.Webhook.Headers["X-Token"] != "abc" {
+				t.Fatalf("unexpected webhook config: %+v", cfg.Health.Webhook)
+			}
+		})
+	}
+}
+
+func TestLoadRejectsMalformedJSONAndTOML(t *testing.T) {
+	for name, body := range map[string]string{
+		"agent.json": `{"agent": {"server": }`,
+		"agent.toml": "[agent]\nserver = unquoted\n",
+	} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		if _, err := Load(context.Background(), path); err == nil {
+			t.Fatalf("%s: expected parse error", name)
+		}
+	}
+}
+
+func TestLoadMergesDropIns(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(path, []byte(sampleYAML), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	dropIns := DropInDir(path)
+	if err := os.Mkdir(dropIns, 0o755); err != nil {
+		t.Fatalf("mkdir drop-ins: %v", err)
+	}
+	files := map[string]string{
+		"10-site.yaml":  "agent:\n  labels: [site=ORD-2]\nqueue:\n  mem_items_cap: 1000\n",
+		"20-queue.toml": "[queue]\nmem_items_cap = 5000\n",
+		"README.md":     "not config",
+	}
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dropIns, name), []byte(body), 0o600); err != nil {
+			t.Fatalf("write drop-in: %v", err)
+		}
+	}
+
+	cfg, err := Load(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Load returned error: %v", err)
+	}
+	if len(cfg.Agent.Labels) != 1 || cfg.Agent.Labels[0] != "site=ORD-2" {
+		t.Fatalf("expected drop-in to replace labels, got %#v", cfg.Agent.Labels)
+	}
+	if cfg.Queue.MemItemsCap != 5000 {
+		t.Fatalf("expected later drop-in to win, got %d", cfg.Queue.MemItemsCap)
+	}
+	if cfg.Agent.Server != "https://central.example.com" || !cfg.Queue.SpillToDisk {
+		t.Fatalf("expected base values to survive: %+v %+v", cfg.Agent, cfg.Queue)
+	}
+}
+
+func TestLoadMigratesAndRejectsConfigVersions(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write config: %v", err)
+		}
+		return path
+	}
+
+	cfg, err := Load(context.Background(), write("legacy.yaml", sampleYAML))
+	if err != nil {
+		t.Fatalf("Load unversioned config: %v", err)
+	}
+	if cfg.Version != CurrentConfigVersion || cfg.Agent.Server != "https://central.example.com" {
+		t.Fatalf("expected unversioned config migrated to v%d, got v%d %+v", CurrentConfigVersion, cfg.Version, cfg.Agent)
+	}
+
+	future := fmt.Sprintf("version: %d\nagent:\n  server: https://central.example.com\n", CurrentConfigVersion+1)
+	_, err = Load(context.Background(), write("future.yaml", future))
+	if err == nil || !strings.Contains(err.Error(), "newer than this agent supports") {
+		t.Fatalf("expected future version to be rejected, got %v", err)
+	}
+
+	if _, err := Load(context.Background(), write("bad.json", `{"version": "two"}`)); err == nil {
+		t.Fatalf("expected non-numeric version to be rejected")
+	}
+}
+
+func TestLoadStrictRejectsUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.yaml")
+	body := sampleYAML + "monitoring:\n  listen: 127.0.0.1:9310\n  lisen_tls: true\nagentt:\n  x: 1\n"
+	body = strings.Replace(body, "heartbeat_sec: 15", "heartbeat_secs: 15", 1)
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	if _, err := Load(context.Background(), path); err != nil {
+		t.Fatalf("lenient Load should ignore unknown keys: %v", err)
+	}
+	_, err := LoadStrict(context.Background(), path)
+	if err == nil {
+		t.Fatalf("expected strict load to fail")
+	}
+	for _, want := range []string{"agent.heartbeat_secs (did you mean agent.heartbeat_sec?)", "agentt (did you mean agent?)", "monitoring.lisen_tls"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected %q in error, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadMonitorOverrides(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, body string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	overrides, err := LoadMonitorOverrides(write("ok.yaml", "disabled: [mon_a]\nmonitors:\n  mon_b:\n    cadence: 10s\n"))
+	if err != nil {
+		t.Fatalf("LoadMonitorOverrides: %v", err)
+	}
+	if o, ok := overrides.Lookup("mon_a"); !ok || !o.Disabled {
+		t.Fatalf("expected mon_a disabled, got %+v", o)
+	}
+	if o, ok := overrides.Lookup("mon_b"); !ok || o.Cadence != 10*time.Second || o.Disabled {
+		t.Fatalf("expected mon_b cadence 10s, got %+v", o)
+	}
+	if _, ok := overrides.Lookup("mon_c"); ok {
+		t.Fatalf("expected no override for mon_c")
+	}
+
+	if overrides, err := LoadMonitorOverrides(write("empty.yaml", "")); err != nil || !overrides.IsZero() {
+		t.Fatalf("expected empty file to override nothing, got %+v, %v", overrides, err)
+	}
+	for name, body := range map[string]string{
+		"typo.yaml":     "monitors:\n  mon_b:\n    cadance: 10s\n",
+		"negative.yaml": "monitors:\n  mon_b:\n    timeout: -1s\n",
+	} {
+		if _, err := LoadMonitorOverrides(write(name, body)); err == nil {
+			t.Fatalf("expected %s to be rejected", name)
+		}
+	}
+}
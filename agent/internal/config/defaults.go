@@ -0,0 +1,21 @@
+package config
+
+import "time"
+
+// Defaults the run command applies when the corresponding field is unset.
+// `config show --effective` reports the same values.
+const (
+	DefaultMemItemsCap      = 1024
+	DefaultDiskBytesCap     = "2GiB"
+	DefaultHeartbeatSec     = 15
+	DefaultMonitoringListen = "127.0.0.1:9310"
+	DefaultMonitorSyncMode  = "poll"
+	DefaultPlanSyncMode     = "poll"
+	DefaultLogOutput        = "stdout"
+	DefaultLogLevel         = "info"
+
+	// DefaultMonitorSyncInterval is how often monitor assignments are polled;
+	// readiness reports MONITOR_STALE after three missed intervals by default.
+	DefaultMonitorSyncInterval = 15 * time.Second
+	DefaultLongPollTimeout     = 30 * time.Second
+)
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix starts every config override variable. The rest of the name is
+// the field's YAML path upper-cased and joined with underscores, e.g.
+// agent.server is PINGSANTO_AGENT_SERVER and queue.mem_items_cap is
+// PINGSANTO_QUEUE_MEM_ITEMS_CAP.
+const envPrefix = "PINGSANTO"
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// ApplyEnv overrides cfg fields from PINGSANTO_* environment variables.
+// Lists are comma-separated; maps are comma-separated key=value pairs. Fields
+// tagged env:"-" cannot be overridden.
+func ApplyEnv(cfg *Config) error {
+	_, err := applyEnv(cfg, os.LookupEnv)
+	return err
+}
+
+func applyEnv(cfg *Config, lookup func(string) (string, bool)) (bool, error) {
+	return applyEnvStruct(reflect.ValueOf(cfg).Elem(), envPrefix, lookup)
+}
+
+// hasEnvOverrides reports whether any config override variable is set.
+func hasEnvOverrides(lookup func(string) (string, bool)) bool {
+	var scratch Config
+	changed, _ := applyEnv(&scratch, lookup)
+	return changed
+}
+
+func applyEnvStruct(v reflect.Value, prefix string, lookup func(string) (string, bool)) (bool, error) {
+	t := v.Type()
+	changed := false
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" || !field.IsExported() || field.Tag.Get("env") == "-" {
+			continue
+		}
+		name := prefix + "_" + strings.ToUpper(tag)
+		fv := v.Field(i)
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			ok, err := applyEnvStruct(fv, name, lookup)
+			if err != nil {
+				return changed, err
+			}
+			changed = changed || ok
+		case field.Type.Kind() == reflect.Pointer && field.Type.Elem().Kind() == reflect.Struct:
+			target := fv
+			if fv.IsNil() {
+				target = reflect.New(field.Type.Elem())
+			}
+			ok, err := applyEnvStruct(target.Elem(), name, lookup)
+			if err != nil {
+				return changed, err
+			}
+			if ok {
+				fv.Set(target)
+				changed = true
+			}
+		default:
+			raw, ok := lookup(name)
+			if !ok {
+				continue
+			}
+			if err := setEnvField(fv, raw); err != nil {
+				return changed, fmt.Errorf("env %s: %w", name, err)
+			}
+			changed = true
+		}
+	}
+	return changed, nil
+}
+
+func setEnvField(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported list type %s", v.Type())
+		}
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String || v.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("unsupported map type %s", v.Type())
+		}
+		m := make(map[string]string)
+		for _, pair := range strings.Split(raw, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(pair, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				return fmt.Errorf("invalid key=value pair %q", pair)
+			}
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvOverridesFields(t *testing.T) {
+	env := map[string]string{
+		"PINGSANTO_AGENT_SERVER":                    "https://ctrl.example.com",
+		"PINGSANTO_AGENT_DATA_DIR":                  "/data",
+		"PINGSANTO_AGENT_LABELS":                    "site=atl, env=prod",
+		"PINGSANTO_AGENT_RATE_GOVERNANCE_ENABLED":   "true",
+		"PINGSANTO_QUEUE_MEM_ITEMS_CAP":             "4096",
+		"PINGSANTO_QUEUE_SPILL_TO_DISK":             "1",
+		"PINGSANTO_RUN_WORKERS":                     "8",
+		"PINGSANTO_RUN_TICK_RESOLUTION":             "250ms",
+		"PINGSANTO_METRICS_STATSD_TAGS":             "region=us, tier=edge",
+		"PINGSANTO_HEALTH_WEBHOOK_URL":              "https://hooks.example.com",
+		"PINGSANTO_MONITORING_AUTH_BEARER_TOKEN":    "tok",
+		"PINGSANTO_HEALTH_UPLINK_FAILURE_THRESHOLD": "4",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	cfg := Config{Agent: AgentConfig{Server: "https://old.example.com", HeartbeatSec: 30}}
+	changed, err := applyEnv(&cfg, lookup)
+	if err != nil || !changed {
+		t.Fatalf("applyEnv: changed=%v err=%v", changed, err)
+	}
+	if cfg.Agent.Server != "https://ctrl.example.com" || cfg.Agent.DataDir != "/data" || cfg.Agent.HeartbeatSec != 30 {
+		t.Fatalf("unexpected agent config %+v", cfg.Agent)
+	}
+	if len(cfg.Agent.Labels) != 2 || cfg.Agent.Labels[1] != "env=prod" {
+		t.Fatalf("unexpected labels %v", cfg.Agent.Labels)
+	}
+	if cfg.Agent.RateGovernance == nil || !cfg.Agent.RateGovernance.Enabled {
+		t.Fatalf("expected rate governance allocated from env")
+	}
+	if cfg.Queue.MemItemsCap != 4096 || !cfg.Queue.SpillToDisk || cfg.Run.Workers != 8 || cfg.Run.TickResolution != 250*time.Millisecond {
+		t.Fatalf("unexpected queue/run config %+v %+v", cfg.Queue, cfg.Run)
+	}
+	if cfg.Metrics.StatsD.Tags["tier"] != "edge" || cfg.Health.Webhook.URL != "https://hooks.example.com" || cfg.Monitoring.Auth.BearerToken != "tok" || cfg.Health.UplinkFailureThreshold != 4 {
+		t.Fatalf("unexpected nested config %+v", cfg)
+	}
+}
+
+func TestApplyEnvRejectsInvalidValues(t *testing.T) {
+	lookup := func(name string) (string, bool) {
+		if name == "PINGSANTO_RUN_WORKERS" {
+			return "many", true
+		}
+		return "", false
+	}
+	var cfg Config
+	if _, err := applyEnv(&cfg, lookup); err == nil || err.Error() != `env PINGSANTO_RUN_WORKERS: invalid integer "many"` {
+		t.Fatalf("expected invalid integer error, got %v", err)
+	}
+	var untouched Config
+	if changed, err := applyEnv(&untouched, func(string) (string, bool) { return "", false }); changed || err != nil || untouched.Agent.RateGovernance != nil {
+		t.Fatalf("expected no changes without env, got changed=%v err=%v", changed, err)
+	}
+}
+
+func TestLoadFromEnvWithoutFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.yaml")
+	if _, err := Load(context.Background(), path); err == nil {
+		t.Fatalf("expected error for missing file without overrides")
+	}
+	t.Setenv("PINGSANTO_AGENT_SERVER", "https://ctrl.example.com")
+	cfg, err := Load(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Agent.Server != "https://ctrl.example.com" {
+		t.Fatalf("expected server from env, got %q", cfg.Agent.Server)
+	}
+
+	if err := os.WriteFile(path, []byte("agent:\n  server: https://file.example.com\n  data_dir: /var/lib/pingsanto\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	cfg, err = Load(context.Background(), path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Agent.Server != "https://ctrl.example.com" || cfg.Agent.DataDir != "/var/lib/pingsanto" {
+		t.Fatalf("expected env to override file, got %+v", cfg.Agent)
+	}
+}
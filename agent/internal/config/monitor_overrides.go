@@ -0,0 +1,82 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MonitorOverrides adjusts monitors delivered by the controller for a single
+// site. It is read from monitor_sync.overrides_file:
+//
+//	disabled: [mon_noisy]
+//	monitors:
+//	  mon_slow_link:
+//	    cadence: 30s
+//	    timeout: 5s
+type MonitorOverrides struct {
+	// Disabled lists monitor IDs that are never scheduled on this agent.
+	Disabled []string `yaml:"disabled"`
+	// Monitors holds per-monitor adjustments keyed by monitor ID.
+	Monitors map[string]MonitorOverride `yaml:"monitors"`
+}
+
+// MonitorOverride replaces the cadence and/or timeout of one monitor; zero
+// values keep the controller's setting.
+type MonitorOverride struct {
+	Disabled bool          `yaml:"disabled"`
+	Cadence  time.Duration `yaml:"cadence"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// IsZero reports whether the overrides change nothing.
+func (o MonitorOverrides) IsZero() bool {
+	return len(o.Disabled) == 0 && len(o.Monitors) == 0
+}
+
+// Lookup returns the effective override for monitorID, folding the Disabled
+// list into the per-monitor entry.
+func (o MonitorOverrides) Lookup(monitorID string) (MonitorOverride, bool) {
+	override, ok := o.Monitors[monitorID]
+	for _, id := range o.Disabled {
+		if id == monitorID {
+			override.Disabled = true
+			ok = true
+			break
+		}
+	}
+	return override, ok
+}
+
+// LoadMonitorOverrides reads and validates the overrides file at path. Unknown
+// keys are rejected so a misspelt field does not silently leave a monitor
+// running at its controller settings.
+func LoadMonitorOverrides(path string) (MonitorOverrides, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return MonitorOverrides{}, fmt.Errorf("read monitor overrides: %w", err)
+	}
+	var overrides MonitorOverrides
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&overrides); err != nil && !errors.Is(err, io.EOF) {
+		return MonitorOverrides{}, fmt.Errorf("decode monitor overrides %s: %w", path, err)
+	}
+	for _, id := range overrides.Disabled {
+		if strings.TrimSpace(id) == "" {
+			return MonitorOverrides{}, fmt.Errorf("monitor overrides %s: disabled contains an empty monitor id", path)
+		}
+	}
+	for id, override := range overrides.Monitors {
+		if override.Cadence < 0 || override.Timeout < 0 {
+			return MonitorOverrides{}, fmt.Errorf("monitor overrides %s: %s: cadence and timeout must not be negative", path, id)
+		}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+// OverlayFileName holds the last config overlay received from the controller,
+// so it survives restarts and `config show --effective` can report it.
+const OverlayFileName = "config_overlay.json"
+
+// OverlayPath returns the persisted overlay location under dataDir.
+func OverlayPath(dataDir string) string {
+	return filepath.Join(dataDir, OverlayFileName)
+}
+
+// ApplyOverlay returns cfg with the non-zero overlay fields applied. A nil
+// overlay returns cfg unchanged.
+func ApplyOverlay(cfg Config, overlay *types.ConfigOverlay) Config {
+	if overlay == nil {
+		return cfg
+	}
+	if overlay.HeartbeatSec > 0 {
+		cfg.Agent.HeartbeatSec = overlay.HeartbeatSec
+	}
+	if overlay.QueueMemItemsCap > 0 {
+		cfg.Queue.MemItemsCap = overlay.QueueMemItemsCap
+	}
+	if overlay.QueueDiskBytesCap != "" {
+		cfg.Queue.DiskBytesCap = overlay.QueueDiskBytesCap
+	}
+	if overlay.LogLevel != "" {
+		cfg.Logging.Level = overlay.LogLevel
+	}
+	return cfg
+}
+
+// LoadOverlay reads the persisted overlay. It returns nil when none is stored.
+func LoadOverlay(dataDir string) (*types.ConfigOverlay, error) {
+	data, err := os.ReadFile(OverlayPath(dataDir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read config overlay: %w", err)
+	}
+	var overlay types.ConfigOverlay
+	if err := json.Unmarshal(data, &overlay); err != nil {
+		return nil, fmt.Errorf("decode config overlay: %w", err)
+	}
+	return &overlay, nil
+}
+
+// SaveOverlay persists overlay atomically; a nil overlay removes the file.
+func SaveOverlay(dataDir string, overlay *types.ConfigOverlay) error {
+	path := OverlayPath(dataDir)
+	if overlay == nil {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove config overlay: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(overlay, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode config overlay: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("write config overlay: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("commit config overlay: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+func TestOverlayRoundTripAndApply(t *testing.T) {
+	dir := t.TempDir()
+	if overlay, err := LoadOverlay(dir); err != nil || overlay != nil {
+		t.Fatalf("expected no overlay, got %+v, %v", overlay, err)
+	}
+
+	want := &types.ConfigOverlay{HeartbeatSec: 30, QueueDiskBytesCap: "1GiB", LogLevel: "debug"}
+	if err := SaveOverlay(dir, want); err != nil {
+		t.Fatalf("SaveOverlay: %v", err)
+	}
+	got, err := LoadOverlay(dir)
+	if err != nil || got == nil || *got != *want {
+		t.Fatalf("expected %+v, got %+v, %v", want, got, err)
+	}
+
+	var cfg Config
+	cfg.Agent.HeartbeatSec = 15
+	cfg.Queue.MemItemsCap = 4096
+	cfg.Queue.DiskBytesCap = "2GiB"
+	cfg = ApplyOverlay(cfg, got)
+	if cfg.Agent.HeartbeatSec != 30 || cfg.Queue.DiskBytesCap != "1GiB" || cfg.Queue.MemItemsCap != 4096 || cfg.Logging.Level != "debug" {
+		t.Fatalf("unexpected effective config: %+v %+v %+v", cfg.Agent, cfg.Queue, cfg.Logging)
+	}
+
+	if err := SaveOverlay(dir, nil); err != nil {
+		t.Fatalf("clear overlay: %v", err)
+	}
+	if overlay, err := LoadOverlay(dir); err != nil || overlay != nil {
+		t.Fatalf("expected cleared overlay, got %+v, %v", overlay, err)
+	}
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	secretFilePrefix = "file:"
+	secretEnvPrefix  = "env:"
+)
+
+// ResolveSecrets replaces secret references in sensitive fields with their
+// values: "file:/run/secrets/token" reads the file (trailing newlines are
+// trimmed) and "env:NAME" reads an environment variable. Other values are
+// left as written.
+func ResolveSecrets(cfg *Config) error {
+	return resolveSecrets(cfg, os.ReadFile, os.LookupEnv)
+}
+
+func resolveSecrets(cfg *Config, readFile func(string) ([]byte, error), lookup func(string) (string, bool)) error {
+	for field, value := range cfg.secretFields() {
+		resolved, err := resolveSecret(*value, readFile, lookup)
+		if err != nil {
+			return fmt.Errorf("resolve %s: %w", field, err)
+		}
+		*value = resolved
+	}
+	for field, ptr := range cfg.secretMaps() {
+		headers := *ptr
+		keys := make([]string, 0, len(headers))
+		for k := range headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			resolved, err := resolveSecret(headers[k], readFile, lookup)
+			if err != nil {
+				return fmt.Errorf("resolve %s.%s: %w", field, k, err)
+			}
+			headers[k] = resolved
+		}
+	}
+	return nil
+}
+
+// secretFields lists the sensitive string fields by YAML path.
+func (c *Config) secretFields() map[string]*string {
+	return map[string]*string{
+		"proxy.password":               &c.Proxy.Password,
+		"monitoring.auth.bearer_token": &c.Monitoring.Auth.BearerToken,
+		"monitoring.auth.password":     &c.Monitoring.Auth.Password,
+		"debug.pprof_token":            &c.Debug.PprofToken,
+		"metrics.push.password":        &c.Metrics.Push.Password,
+	}
+}
+
+// secretMaps lists header maps whose values commonly carry credentials.
+func (c *Config) secretMaps() map[string]*map[string]string {
+	return map[string]*map[string]string{
+		"metrics.otlp.headers":   &c.Metrics.OTLP.Headers,
+		"metrics.push.headers":   &c.Metrics.Push.Headers,
+		"health.webhook.headers": &c.Health.Webhook.Headers,
+	}
+}
+
+func resolveSecret(value string, readFile func(string) ([]byte, error), lookup func(string) (string, bool)) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFilePrefix):
+		path := strings.TrimSpace(strings.TrimPrefix(value, secretFilePrefix))
+		if path == "" {
+			return "", fmt.Errorf("empty file reference")
+		}
+		data, err := readFile(filepath.Clean(path))
+		if err != nil {
+			return "", fmt.Errorf("read secret file: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, secretEnvPrefix):
+		name := strings.TrimSpace(strings.TrimPrefix(value, secretEnvPrefix))
+		if name == "" {
+			return "", fmt.Errorf("empty env reference")
+		}
+		resolved, ok := lookup(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return resolved, nil
+	default:
+		return value, nil
+	}
+}
+
+// redactedValue replaces secrets in Redact output.
+const redactedValue = "REDACTED"
+
+// Redact returns a copy of cfg with sensitive fields, header values and URL
+// passwords masked, for display in support output.
+func Redact(cfg Config) Config {
+	for _, value := range cfg.secretFields() {
+		if *value != "" {
+			*value = redactedValue
+		}
+	}
+	for _, headers := range cfg.secretMaps() {
+		if *headers == nil {
+			continue
+		}
+		// Copy rather than mask in place: the maps are shared with the caller.
+		masked := make(map[string]string, len(*headers))
+		for k := range *headers {
+			masked[k] = redactedValue
+		}
+		*headers = masked
+	}
+	for _, raw := range []*string{&cfg.Agent.Server, &cfg.Proxy.URL, &cfg.Metrics.OTLP.Endpoint, &cfg.Metrics.Push.URL, &cfg.Health.Webhook.URL} {
+		if u, err := url.Parse(*raw); err == nil && u.User != nil {
+			*raw = u.Redacted()
+		}
+	}
+	return cfg
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	files := map[string]string{"/run/secrets/proxy": "hunter2\n"}
+	env := map[string]string{"OTLP_TOKEN": "Bearer abc"}
+	readFile := func(path string) ([]byte, error) {
+		if v, ok := files[path]; ok {
+			return []byte(v), nil
+		}
+		return nil, fs.ErrNotExist
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+
+	var cfg Config
+	cfg.Proxy.Password = "file:/run/secrets/proxy"
+	cfg.Monitoring.Auth.Password = "plain"
+	cfg.Metrics.OTLP.Headers = map[string]string{"Authorization": "env:OTLP_TOKEN"}
+	cfg.Agent.Server = "env:NOT_A_SECRET_FIELD"
+
+	if err := resolveSecrets(&cfg, readFile, lookup); err != nil {
+		t.Fatalf("resolveSecrets: %v", err)
+	}
+	if cfg.Proxy.Password != "hunter2" {
+		t.Fatalf("expected file secret, got %q", cfg.Proxy.Password)
+	}
+	if cfg.Monitoring.Auth.Password != "plain" {
+		t.Fatalf("expected literal to be kept, got %q", cfg.Monitoring.Auth.Password)
+	}
+	if cfg.Metrics.OTLP.Headers["Authorization"] != "Bearer abc" {
+		t.Fatalf("expected env secret in header, got %q", cfg.Metrics.OTLP.Headers["Authorization"])
+	}
+	if cfg.Agent.Server != "env:NOT_A_SECRET_FIELD" {
+		t.Fatalf("non-secret field should not be resolved, got %q", cfg.Agent.Server)
+	}
+
+	cfg.Debug.PprofToken = "file:/run/secrets/missing"
+	err := resolveSecrets(&cfg, readFile, lookup)
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "debug.pprof_token") {
+		t.Fatalf("expected missing file error naming the field, got %v", err)
+	}
+
+	cfg.Debug.PprofToken = "env:UNSET"
+	if err := resolveSecrets(&cfg, readFile, lookup); err == nil || !strings.Contains(err.Error(), "UNSET") {
+		t.Fatalf("expected unset env error, got %v", err)
+	}
+}
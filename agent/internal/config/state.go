@@ -22,9 +22,13 @@ type State struct {
 	CertPath    string            `yaml:"cert_path"`
 	KeyPath     string            `yaml:"key_path"`
 	CAPath      string            `yaml:"ca_path"`
+	CAPaths     []string          `yaml:"ca_paths,omitempty"` // further CA bundles trusted alongside CAPath
 	ConfigPath  string            `yaml:"config_path"`
 	Credentials struct {
 		TokenHash string `yaml:"token_hash"`
+		// OIDCIssuer records the provider that authorized a device-flow
+		// enrollment; TokenHash is empty in that case.
+		OIDCIssuer string `yaml:"oidc_issuer,omitempty"`
 	} `yaml:"credentials"`
 	Upgrade UpgradeState `yaml:"upgrade"`
 }
@@ -34,26 +38,86 @@ type UpgradeState struct {
 	Paused  bool                `yaml:"paused"`
 	Plan    UpgradePlanState    `yaml:"plan"`
 	Applied UpgradeAppliedState `yaml:"applied"`
+
+	Staged      UpgradeStagedState   `yaml:"staged,omitempty"`
+	ApplyNow    UpgradeApplyNowState `yaml:"apply_now,omitempty"`
+	Pending     UpgradePendingState  `yaml:"pending,omitempty"`
+	Rollback    UpgradeRollbackState `yaml:"rollback,omitempty"`
+	BadVersions []string             `yaml:"bad_versions,omitempty"`
 }
 
 type UpgradePlanState struct {
-	Version      string              `yaml:"version"`
-	Channel      string              `yaml:"channel"`
-	Source       string              `yaml:"source"`
-	Paused       bool                `yaml:"paused"`
-	ArtifactURL  string              `yaml:"artifact_url"`
-	SignatureURL string              `yaml:"signature_url"`
-	SHA256       string              `yaml:"sha256"`
-	ForceApply   bool                `yaml:"force_apply"`
-	Notes        string              `yaml:"notes"`
-	Schedule     UpgradePlanSchedule `yaml:"schedule"`
-	RetrievedAt  time.Time           `yaml:"retrieved_at"`
-	ETag         string              `yaml:"etag"`
+	Version         string              `yaml:"version"`
+	Channel         string              `yaml:"channel"`
+	Source          string              `yaml:"source"`
+	Paused          bool                `yaml:"paused"`
+	ArtifactURL     string              `yaml:"artifact_url"`
+	SignatureURL    string              `yaml:"signature_url"`
+	SHA256          string              `yaml:"sha256"`
+	ForceApply      bool                `yaml:"force_apply"`
+	Notes           string              `yaml:"notes"`
+	IgnoreReadiness bool                `yaml:"ignore_readiness,omitempty"`
+	Schedule        UpgradePlanSchedule `yaml:"schedule"`
+	RetrievedAt     time.Time           `yaml:"retrieved_at"`
+	ETag            string              `yaml:"etag"`
 }
 
 type UpgradePlanSchedule struct {
-	Earliest *time.Time `yaml:"earliest,omitempty"`
-	Latest   *time.Time `yaml:"latest,omitempty"`
+	Earliest           *time.Time `yaml:"earliest,omitempty"`
+	Latest             *time.Time `yaml:"latest,omitempty"`
+	MaintenanceWindows []string   `yaml:"maintenance_windows,omitempty"`
+	Prefetch           bool       `yaml:"prefetch,omitempty"`
+}
+
+// UpgradeStagedState records an artifact that was downloaded, verified and
+// extracted ahead of its rollout window, so the install inside the window
+// can skip the download. It is cleared when an install is attempted.
+type UpgradeStagedState struct {
+	Version      string    `yaml:"version,omitempty"`
+	SHA256       string    `yaml:"sha256,omitempty"`
+	ArtifactPath string    `yaml:"artifact_path,omitempty"`
+	BundlePath   string    `yaml:"bundle_path,omitempty"`
+	BinaryPath   string    `yaml:"binary_path,omitempty"`
+	DeltaFrom    string    `yaml:"delta_from,omitempty"`
+	StagedAt     time.Time `yaml:"staged_at,omitempty"`
+}
+
+// UpgradeApplyNowState is an operator request, made with `upgrades
+// --apply-now`, to install Version at once regardless of the plan's rollout
+// and maintenance windows. It is cleared when an install is attempted or the
+// plan moves to another version.
+type UpgradeApplyNowState struct {
+	Version     string    `yaml:"version,omitempty"`
+	RequestedAt time.Time `yaml:"requested_at,omitempty"`
+}
+
+// UpgradePendingState records an upgrade whose new binary has been started.
+// It survives the exec so the new process can verify its health and count
+// its own restarts; it is cleared once the crash-loop window has passed
+// after verification, or when the previous binary is restored.
+type UpgradePendingState struct {
+	Version         string    `yaml:"version,omitempty"`
+	PreviousVersion string    `yaml:"previous_version,omitempty"`
+	Channel         string    `yaml:"channel,omitempty"`
+	TargetPath      string    `yaml:"target_path,omitempty"`
+	BackupPath      string    `yaml:"backup_path,omitempty"`
+	RestartedAt     time.Time `yaml:"restarted_at,omitempty"`
+	Starts          int       `yaml:"starts,omitempty"`
+	Verified        bool      `yaml:"verified,omitempty"`
+}
+
+// UpgradeRollbackState records a rollback made outside the upgrade
+// manager, after a crash loop or by `upgrades --rollback`, until it has been
+// reported. Restart asks a running agent to exec the restored binary.
+type UpgradeRollbackState struct {
+	Version         string    `yaml:"version,omitempty"`
+	PreviousVersion string    `yaml:"previous_version,omitempty"`
+	Channel         string    `yaml:"channel,omitempty"`
+	Stage           string    `yaml:"stage,omitempty"`
+	Reason          string    `yaml:"reason,omitempty"`
+	Starts          int       `yaml:"starts,omitempty"`
+	At              time.Time `yaml:"at,omitempty"`
+	Restart         bool      `yaml:"restart,omitempty"`
 }
 
 type UpgradeAppliedState struct {
@@ -62,6 +126,21 @@ type UpgradeAppliedState struct {
 	AppliedAt   time.Time `yaml:"applied_at"`
 	LastAttempt time.Time `yaml:"last_attempt"`
 	LastError   string    `yaml:"last_error"`
+	// PreviousVersion is the version kept in the installer's .bak binary.
+	PreviousVersion string `yaml:"previous_version,omitempty"`
+}
+
+// CABundles returns CAPath followed by CAPaths, skipping empty entries.
+// Listing the new controller CA in CAPaths keeps the agent connected while
+// the controller switches to it.
+func (s State) CABundles() []string {
+	var paths []string
+	for _, path := range append([]string{s.CAPath}, s.CAPaths...) {
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
 }
 
 func StatePath(dir string) string {
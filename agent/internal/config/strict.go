@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// unknownKeys returns the dotted paths of keys in doc that match no field of
+// struct type t, each with a suggestion when a field name is one or two edits
+// away. Map-typed fields accept any key.
+func unknownKeys(doc map[string]any, t reflect.Type, prefix string) []string {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if tag == "" || tag == "-" || !field.IsExported() {
+			continue
+		}
+		fields[tag] = field.Type
+	}
+
+	var unknown []string
+	for key, value := range doc {
+		ft, ok := fields[key]
+		if !ok {
+			entry := prefix + key
+			if hint := closestKey(key, fields); hint != "" {
+				entry += fmt.Sprintf(" (did you mean %s?)", prefix+hint)
+			}
+			unknown = append(unknown, entry)
+			continue
+		}
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		nested, isMap := value.(map[string]any)
+		if ft.Kind() == reflect.Struct && ft != durationType && isMap {
+			unknown = append(unknown, unknownKeys(nested, ft, prefix+key+".")...)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func closestKey(key string, fields map[string]reflect.Type) string {
+	best, bestDist := "", 3
+	for name := range fields {
+		if d := editDistance(key, name); d < bestDist || (d == bestDist && name < best) {
+			best, bestDist = name, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"math"
+)
+
+// CurrentConfigVersion is the agent.yaml schema version this build writes and
+// understands. Bump it together with a new entry in configMigrations whenever
+// a release renames, moves or reinterprets config keys.
+const CurrentConfigVersion = 1
+
+// configMigrations[n] rewrites a version-n document into version n+1 in place.
+var configMigrations = []func(doc map[string]any) error{
+	// 0 -> 1: files written before the version key existed share the v1 layout.
+	func(doc map[string]any) error { return nil },
+}
+
+// migrateConfig upgrades doc to CurrentConfigVersion. A missing version key
+// means version 0. Documents from a newer schema are rejected rather than
+// half-applied.
+func migrateConfig(doc map[string]any) error {
+	version, err := documentVersion(doc)
+	if err != nil {
+		return err
+	}
+	if version > CurrentConfigVersion {
+		return fmt.Errorf("config version %d is newer than this agent supports (%d); upgrade the agent before deploying this config", version, CurrentConfigVersion)
+	}
+	for v := version; v < CurrentConfigVersion; v++ {
+		if err := configMigrations[v](doc); err != nil {
+			return fmt.Errorf("migrate config from version %d to %d: %w", v, v+1, err)
+		}
+	}
+	doc["version"] = CurrentConfigVersion
+	return nil
+}
+
+func documentVersion(doc map[string]any) (int, error) {
+	raw, ok := doc["version"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+	var version int
+	switch v := raw.(type) {
+	case int:
+		version = v
+	case int64:
+		version = int(v)
+	case uint64:
+		version = int(v)
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, fmt.Errorf("config version %v must be a whole number", v)
+		}
+		version = int(v)
+	default:
+		return 0, fmt.Errorf("config version %v must be a number", raw)
+	}
+	if version < 0 {
+		return 0, fmt.Errorf("config version %d must not be negative", version)
+	}
+	return version, nil
+}
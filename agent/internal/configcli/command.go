@@ -0,0 +1,410 @@
+package configcli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/certs"
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/logging"
+	"github.com/pingsantohq/agent/internal/queue"
+	"github.com/pingsantohq/agent/internal/upgrade"
+)
+
+type Dependencies struct {
+	Out  io.Writer
+	Stat func(string) (fs.FileInfo, error)
+}
+
+// Issue is one problem found in the agent configuration.
+type Issue struct {
+	Field   string
+	Message string
+}
+
+func (i Issue) String() string {
+	return i.Field + ": " + i.Message
+}
+
+// Run dispatches `pingsanto-agent config <subcommand>`.
+func Run(ctx context.Context, args []string, deps Dependencies) error {
+	if deps.Out == nil {
+		deps.Out = os.Stdout
+	}
+	if deps.Stat == nil {
+		deps.Stat = os.Stat
+	}
+	if len(args) == 0 {
+		return errors.New("usage: pingsanto-agent config validate|show [--config path]")
+	}
+	switch args[0] {
+	case "validate":
+		return runValidate(ctx, args[1:], deps)
+	case "show":
+		return runShow(ctx, args[1:], deps)
+	default:
+		return fmt.Errorf("unknown config subcommand %q (want validate or show)", args[0])
+	}
+}
+
+func runValidate(ctx context.Context, args []string, deps Dependencies) error {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to agent configuration file")
+	strict := fs.Bool("strict", false, "Fail on unrecognized config keys")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	loadConfig := config.Load
+	if *strict {
+		loadConfig = config.LoadStrict
+	}
+	cfg, err := loadConfig(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+
+	issues := Validate(ctx, cfg, deps.Stat)
+	if len(issues) == 0 {
+		fmt.Fprintf(deps.Out, "%s: OK\n", *configPath)
+		return nil
+	}
+	fmt.Fprintf(deps.Out, "%s: %d problem(s)\n", *configPath, len(issues))
+	for _, issue := range issues {
+		fmt.Fprintf(deps.Out, "  - %s\n", issue)
+	}
+	return fmt.Errorf("config has %d problem(s)", len(issues))
+}
+
+// Validate checks value ranges and that referenced files and directories
+// exist. stat defaults to os.Stat.
+func Validate(ctx context.Context, cfg config.Config, stat func(string) (fs.FileInfo, error)) []Issue {
+	if stat == nil {
+		stat = os.Stat
+	}
+	v := &validator{stat: stat}
+
+	agent := cfg.Agent
+	if agent.Server != "" {
+		v.url("agent.server", agent.Server, "http", "https")
+	}
+	dataDirOK := false
+	if strings.TrimSpace(agent.DataDir) == "" {
+		v.add("agent.data_dir", "is required; set it to the directory holding state.yaml (e.g. /var/lib/pingsanto/agent)")
+	} else {
+		dataDirOK = v.dir("agent.data_dir", agent.DataDir)
+	}
+	for _, label := range agent.Labels {
+		if key, _, ok := strings.Cut(label, "="); !ok || strings.TrimSpace(key) == "" {
+			v.add("agent.labels", fmt.Sprintf("%q must be key=value", label))
+		}
+	}
+	v.nonNegative("agent.heartbeat_sec", agent.HeartbeatSec)
+	v.nonNegativeDuration("agent.clock_skew_threshold", agent.ClockSkewThreshold)
+	if agent.BootstrapPath != "" {
+		v.file("agent.bootstrap_path", agent.BootstrapPath)
+	}
+	if _, err := certs.ParsePins(agent.ControllerPins); err != nil {
+		v.add("agent.controller_pins", err.Error())
+	}
+	if agent.SPIFFESocket != "" && agent.ClientKey != "" {
+		v.add("agent.client_key", "cannot be combined with agent.spiffe_socket")
+	}
+	switch {
+	case agent.ClientKey == "":
+	case certs.IsKeyURI(agent.ClientKey):
+		if !certs.KeyProviderAvailable(agent.ClientKey) {
+			v.add("agent.client_key", fmt.Sprintf("%q needs hardware key support this agent build does not include; build with -tags pkcs11 or -tags tpm2", agent.ClientKey))
+		}
+	default:
+		v.file("agent.client_key", agent.ClientKey)
+	}
+	v.oneOf("agent.revocation.mode", agent.Revocation.Mode, "", "off", "soft", "hard")
+	for _, path := range agent.Revocation.CRLFiles {
+		v.file("agent.revocation.crl_files", path)
+	}
+	v.nonNegativeDuration("agent.revocation.timeout", agent.Revocation.Timeout)
+	if rg := agent.RateGovernance; rg != nil {
+		v.nonNegative("agent.rate_governance.global_pps_cap", rg.GlobalPPSCap)
+		v.nonNegative("agent.rate_governance.per_dest_pps_cap", rg.PerDestinationPPSCap)
+		v.nonNegative("agent.rate_governance.notify_if_sustained_minutes", rg.NotifyIfSustainedMin)
+	}
+
+	if dataDirOK {
+		v.state(ctx, agent)
+	}
+
+	v.nonNegative("queue.mem_items_cap", cfg.Queue.MemItemsCap)
+	if _, err := queue.ParseSize(cfg.Queue.DiskBytesCap, 0); err != nil {
+		v.add("queue.disk_bytes_cap", fmt.Sprintf("%q is not a size; use e.g. 512MiB or 2GiB", cfg.Queue.DiskBytesCap))
+	}
+
+	if _, err := queue.ParseSize(cfg.Upgrade.DownloadRateLimit, 0); err != nil {
+		v.add("upgrade.download_rate_limit", fmt.Sprintf("%q is not a size; use e.g. 512KiB or 2MiB", cfg.Upgrade.DownloadRateLimit))
+	}
+	v.nonNegativeDuration("upgrade.verify_window", cfg.Upgrade.VerifyWindow)
+	v.nonNegative("upgrade.crash_loop_restarts", cfg.Upgrade.CrashLoopRestarts)
+	v.nonNegativeDuration("upgrade.crash_loop_window", cfg.Upgrade.CrashLoopWindow)
+	v.nonNegative("upgrade.keep_bundles", cfg.Upgrade.KeepBundles)
+	v.oneOf("upgrade.plan_sync", cfg.Upgrade.PlanSync, "", "poll", "push")
+	if _, err := upgrade.ParseMaintenanceWindows(cfg.Upgrade.MaintenanceWindows); err != nil {
+		v.add("upgrade.maintenance_windows", err.Error())
+	}
+	for i, channel := range cfg.Upgrade.AllowedChannels {
+		v.oneOf(fmt.Sprintf("upgrade.allowed_channels[%d]", i), channel, "stable", "canary")
+	}
+	v.signature(cfg.Upgrade.Signature)
+
+	v.nonNegative("run.workers", cfg.Run.Workers)
+	v.nonNegativeDuration("run.tick_resolution", cfg.Run.TickResolution)
+	v.nonNegative("transmit.batch_size", cfg.Transmit.BatchSize)
+	v.nonNegativeDuration("transmit.max_latency", cfg.Transmit.MaxLatency)
+
+	v.oneOf("monitor_sync.mode", cfg.MonitorSync.Mode, "", "poll", "long_poll", "push")
+	v.nonNegativeDuration("monitor_sync.long_poll_timeout", cfg.MonitorSync.LongPollTimeout)
+	if path := cfg.MonitorSync.OverridesFile; path != "" {
+		if _, err := v.stat(path); err == nil {
+			if _, err := config.LoadMonitorOverrides(path); err != nil {
+				v.add("monitor_sync.overrides_file", err.Error())
+			}
+		}
+	}
+
+	if cfg.Proxy.URL != "" {
+		v.url("proxy.url", cfg.Proxy.URL, "http", "https", "socks5", "socks5h")
+	}
+
+	if cfg.Metrics.OTLP.Endpoint != "" {
+		v.url("metrics.otlp.endpoint", cfg.Metrics.OTLP.Endpoint, "http", "https")
+	}
+	if cfg.Metrics.StatsD.Address != "" {
+		v.hostPort("metrics.statsd.address", cfg.Metrics.StatsD.Address)
+	}
+	v.oneOf("metrics.statsd.flavor", cfg.Metrics.StatsD.Flavor, "", "dogstatsd", "statsd")
+	if cfg.Metrics.Push.URL != "" {
+		v.url("metrics.push.url", cfg.Metrics.Push.URL, "http", "https")
+	}
+	v.oneOf("metrics.push.mode", cfg.Metrics.Push.Mode, "", "pushgateway", "remote_write")
+
+	mon := cfg.Monitoring
+	if mon.Listen != "" {
+		v.hostPort("monitoring.listen", mon.Listen)
+	}
+	if (mon.TLS.CertFile == "") != (mon.TLS.KeyFile == "") {
+		v.add("monitoring.tls", "cert_file and key_file must be set together")
+	}
+	for field, path := range map[string]string{
+		"monitoring.tls.cert_file":      mon.TLS.CertFile,
+		"monitoring.tls.key_file":       mon.TLS.KeyFile,
+		"monitoring.tls.client_ca_file": mon.TLS.ClientCAFile,
+	} {
+		if path != "" {
+			v.file(field, path)
+		}
+	}
+	if (mon.Auth.Username == "") != (mon.Auth.Password == "") {
+		v.add("monitoring.auth", "username and password must be set together")
+	}
+
+	health := cfg.Health
+	if health.QueuePressurePct < 0 || health.QueuePressurePct > 100 {
+		v.add("health.queue_pressure_pct", fmt.Sprintf("%d is out of range; use 1-100 (0 keeps the default of 100)", health.QueuePressurePct))
+	}
+	v.nonNegativeDuration("health.monitor_stale_after", health.MonitorStaleAfter)
+	v.nonNegativeDuration("health.cert_expiry_warning", health.CertExpiryWarning)
+	v.nonNegativeDuration("health.liveness_window", health.LivenessWindow)
+	v.nonNegativeDuration("health.startup_grace", health.StartupGrace)
+	v.nonNegative("health.uplink_failure_threshold", health.UplinkFailureThreshold)
+	if health.Webhook.URL != "" {
+		v.url("health.webhook.url", health.Webhook.URL, "http", "https")
+	}
+	v.nonNegative("health.webhook.max_attempts", health.Webhook.MaxAttempts)
+
+	switch out := strings.TrimSpace(cfg.Logging.Output); out {
+	case "", "stdout", "stderr":
+	default:
+		v.dir("logging.output", filepath.Dir(out))
+	}
+	if _, err := logging.ParseLevel(cfg.Logging.Level); err != nil {
+		v.add("logging.level", err.Error())
+	}
+
+	sort.SliceStable(v.issues, func(i, j int) bool { return v.issues[i].Field < v.issues[j].Field })
+	return v.issues
+}
+
+type validator struct {
+	stat   func(string) (fs.FileInfo, error)
+	issues []Issue
+}
+
+func (v *validator) add(field, message string) {
+	v.issues = append(v.issues, Issue{Field: field, Message: message})
+}
+
+func (v *validator) nonNegative(field string, n int) {
+	if n < 0 {
+		v.add(field, fmt.Sprintf("%d must not be negative (0 uses the default)", n))
+	}
+}
+
+func (v *validator) nonNegativeDuration(field string, d time.Duration) {
+	if d < 0 {
+		v.add(field, fmt.Sprintf("%s must not be negative (0 uses the default)", d))
+	}
+}
+
+func (v *validator) oneOf(field, value string, allowed ...string) {
+	for _, a := range allowed {
+		if strings.EqualFold(value, a) {
+			return
+		}
+	}
+	var named []string
+	for _, a := range allowed {
+		if a != "" {
+			named = append(named, a)
+		}
+	}
+	v.add(field, fmt.Sprintf("%q is not supported; use one of %s", value, strings.Join(named, ", ")))
+}
+
+func (v *validator) url(field, raw string, schemes ...string) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Host == "" {
+		v.add(field, fmt.Sprintf("%q is not an absolute URL", raw))
+		return
+	}
+	for _, s := range schemes {
+		if u.Scheme == s {
+			return
+		}
+	}
+	v.add(field, fmt.Sprintf("scheme %q is not supported; use %s", u.Scheme, strings.Join(schemes, ", ")))
+}
+
+func (v *validator) hostPort(field, addr string) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		v.add(field, fmt.Sprintf("%q must be host:port", addr))
+	}
+}
+
+func (v *validator) dir(field, path string) bool {
+	info, err := v.stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		v.add(field, fmt.Sprintf("directory %s does not exist; create it or fix the path", path))
+	case err != nil:
+		v.add(field, fmt.Sprintf("cannot access %s: %v", path, err))
+	case !info.IsDir():
+		v.add(field, fmt.Sprintf("%s is not a directory", path))
+	default:
+		return true
+	}
+	return false
+}
+
+func (v *validator) file(field, path string) {
+	info, err := v.stat(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		v.add(field, fmt.Sprintf("file %s does not exist", path))
+	case err != nil:
+		v.add(field, fmt.Sprintf("cannot access %s: %v", path, err))
+	case info.IsDir():
+		v.add(field, fmt.Sprintf("%s is a directory, expected a file", path))
+	}
+}
+
+// signature checks that the gpg settings name a keyring and the cosign
+// settings name either a public key or a complete keyless trust configuration.
+func (v *validator) signature(sig config.SignatureConfig) {
+	v.oneOf("upgrade.signature.type", sig.Type, "", "minisign", "cosign", "gpg")
+	if strings.EqualFold(sig.Type, "gpg") {
+		if sig.PublicKeyFile == "" {
+			v.add("upgrade.signature.public_key_file", "required for gpg verification")
+			return
+		}
+		v.file("upgrade.signature.public_key_file", sig.PublicKeyFile)
+		return
+	}
+	if !strings.EqualFold(sig.Type, "cosign") {
+		return
+	}
+	if sig.PublicKeyFile != "" {
+		v.file("upgrade.signature.public_key_file", sig.PublicKeyFile)
+		return
+	}
+	for field, path := range map[string]string{
+		"upgrade.signature.fulcio_roots_file":     sig.FulcioRootsFile,
+		"upgrade.signature.rekor_public_key_file": sig.RekorPublicKeyFile,
+	} {
+		if path == "" {
+			v.add(field, "required for keyless cosign verification without public_key_file")
+			continue
+		}
+		v.file(field, path)
+	}
+	if sig.CertificateIdentity == "" {
+		v.add("upgrade.signature.certificate_identity", "required for keyless cosign verification without public_key_file")
+	}
+	if sig.CertificateOIDCIssuer == "" {
+		v.add("upgrade.signature.certificate_oidc_issuer", "required for keyless cosign verification without public_key_file")
+	}
+}
+
+// state checks the enrollment state in data_dir: the certificate paths it
+// records must exist, and a server must be known from config or state.
+func (v *validator) state(ctx context.Context, agent config.AgentConfig) {
+	statePath := config.StatePath(agent.DataDir)
+	if _, err := v.stat(statePath); errors.Is(err, fs.ErrNotExist) {
+		v.add("agent.data_dir", fmt.Sprintf("%s not found; run `pingsanto-agent enroll` first", statePath))
+		return
+	}
+	state, err := config.LoadState(ctx, agent.DataDir)
+	if err != nil {
+		v.add("agent.data_dir", fmt.Sprintf("cannot read state: %v", err))
+		return
+	}
+	if agent.Server == "" && state.Server == "" {
+		v.add("agent.server", "is not set and state.yaml records no server")
+	}
+	paths := map[string]string{
+		"state.cert_path": state.CertPath,
+		"state.key_path":  state.KeyPath,
+		"state.ca_path":   state.CAPath,
+	}
+	for i, path := range state.CAPaths {
+		paths[fmt.Sprintf("state.ca_paths[%d]", i)] = path
+	}
+	if agent.ClientKey != "" {
+		delete(paths, "state.key_path")
+	}
+	if agent.SPIFFESocket != "" {
+		// The client certificate comes from the Workload API; ca.pem stays
+		// optional for verifying the controller.
+		delete(paths, "state.cert_path")
+		delete(paths, "state.key_path")
+		if state.CAPath == "" {
+			delete(paths, "state.ca_path")
+		}
+	}
+	for field, path := range paths {
+		if path == "" {
+			v.add(field, "is empty; re-run `pingsanto-agent enroll`")
+			continue
+		}
+		v.file(field, path)
+	}
+}
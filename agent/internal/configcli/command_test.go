@@ -0,0 +1,118 @@
+package configcli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+func enrolledDataDir(t *testing.T) string {
+	t.Helper()
+	dataDir := filepath.Join(t.TempDir(), "data")
+	certs := map[string]string{}
+	for _, name := range []string{"agent.crt", "agent.key", "ca.pem"} {
+		path := filepath.Join(dataDir, name)
+		certs[name] = path
+	}
+	if err := config.SaveState(context.Background(), dataDir, config.State{
+		AgentID:  "agt_1",
+		Server:   "https://ctrl.example.com",
+		CertPath: certs["agent.crt"],
+		KeyPath:  certs["agent.key"],
+		CAPath:   certs["ca.pem"],
+	}); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+	for _, path := range certs {
+		if err := os.WriteFile(path, []byte("pem"), 0o600); err != nil {
+			t.Fatalf("write %s: %v", path, err)
+		}
+	}
+	return dataDir
+}
+
+func TestRunValidateOK(t *testing.T) {
+	dataDir := enrolledDataDir(t)
+	configPath := filepath.Join(t.TempDir(), "agent.yaml")
+	if err := os.WriteFile(configPath, []byte("agent:\n  data_dir: "+dataDir+"\nqueue:\n  disk_bytes_cap: 1GiB\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run(context.Background(), []string{"validate", "--config", configPath}, Dependencies{Out: &out}); err != nil {
+		t.Fatalf("validate: %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "OK") {
+		t.Fatalf("expected OK output, got %q", out.String())
+	}
+}
+
+func TestRunValidateReportsProblems(t *testing.T) {
+	dataDir := enrolledDataDir(t)
+	if err := os.Remove(filepath.Join(dataDir, "agent.key")); err != nil {
+		t.Fatalf("remove key: %v", err)
+	}
+	configPath := filepath.Join(t.TempDir(), "agent.yaml")
+	body := strings.Join([]string{
+		"agent:",
+		"  data_dir: " + dataDir,
+		"  server: ftp://ctrl.example.com",
+		"run:",
+		"  workers: -2",
+		"queue:",
+		"  disk_bytes_cap: lots",
+		"monitor_sync:",
+		"  mode: websocket",
+		"health:",
+		"  queue_pressure_pct: 150",
+		"monitoring:",
+		"  tls:",
+		"    cert_file: /nonexistent/monitor.crt",
+		"upgrade:",
+		"  allowed_channels: [stable, beta]",
+		"  signature:",
+		"    type: cosign",
+		"    fulcio_roots_file: /nonexistent/fulcio.pem",
+		"    certificate_identity: release@example.com",
+		"",
+	}, "\n")
+	if err := os.WriteFile(configPath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var out bytes.Buffer
+	err := Run(context.Background(), []string{"validate", "--config", configPath}, Dependencies{Out: &out})
+	if err == nil {
+		t.Fatalf("expected validation failure")
+	}
+	for _, want := range []string{
+		`agent.server: scheme "ftp" is not supported`,
+		"health.queue_pressure_pct: 150 is out of range",
+		`monitor_sync.mode: "websocket" is not supported; use one of poll, long_poll, push`,
+		"monitoring.tls: cert_file and key_file must be set together",
+		"monitoring.tls.cert_file: file /nonexistent/monitor.crt does not exist",
+		`queue.disk_bytes_cap: "lots" is not a size`,
+		"run.workers: -2 must not be negative",
+		`upgrade.allowed_channels[1]: "beta" is not supported; use one of stable, canary`,
+		"state.key_path: file " + filepath.Join(dataDir, "agent.key") + " does not exist",
+		"upgrade.signature.certificate_oidc_issuer: required for keyless cosign verification",
+		"upgrade.signature.fulcio_roots_file: file /nonexistent/fulcio.pem does not exist",
+		"upgrade.signature.rekor_public_key_file: required for keyless cosign verification",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Fatalf("expected %q in output:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestValidateRequiresDataDir(t *testing.T) {
+	issues := Validate(context.Background(), config.Config{}, nil)
+	if len(issues) != 1 || issues[0].Field != "agent.data_dir" {
+		t.Fatalf("expected missing data_dir issue, got %v", issues)
+	}
+}
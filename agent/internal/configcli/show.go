@@ -0,0 +1,83 @@
+package configcli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"runtime"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/health"
+	"github.com/pingsantohq/agent/internal/transmit"
+)
+
+// runShow prints the loaded configuration as YAML with secrets redacted.
+// With --effective, the controller overlay persisted in data_dir and the
+// built-in defaults are applied first, matching what `run` would use.
+func runShow(ctx context.Context, args []string, deps Dependencies) error {
+	fs := flag.NewFlagSet("config show", flag.ContinueOnError)
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to agent configuration file")
+	effective := fs.Bool("effective", false, "Apply the controller overlay and built-in defaults")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cfg, err := config.Load(ctx, *configPath)
+	if err != nil {
+		return err
+	}
+
+	source := "file, drop-ins and environment"
+	if *effective {
+		source += ", controller overlay and defaults"
+		if cfg.Agent.DataDir != "" {
+			overlay, err := config.LoadOverlay(cfg.Agent.DataDir)
+			if err != nil {
+				return err
+			}
+			cfg = config.ApplyOverlay(cfg, overlay)
+		}
+		cfg = withDefaults(cfg)
+	}
+
+	data, err := yaml.Marshal(config.Redact(cfg))
+	if err != nil {
+		return fmt.Errorf("encode config: %w", err)
+	}
+	fmt.Fprintf(deps.Out, "# %s (%s; secrets redacted)\n", *configPath, source)
+	_, err = deps.Out.Write(data)
+	return err
+}
+
+// withDefaults fills unset fields with the values the run command falls
+// back to.
+func withDefaults(cfg config.Config) config.Config {
+	orDefault(&cfg.Agent.HeartbeatSec, config.DefaultHeartbeatSec)
+	orDefault(&cfg.Agent.ClockSkewThreshold, health.DefaultClockSkewThreshold)
+	orDefault(&cfg.Queue.MemItemsCap, config.DefaultMemItemsCap)
+	orDefault(&cfg.Queue.DiskBytesCap, config.DefaultDiskBytesCap)
+	orDefault(&cfg.Run.Workers, runtime.NumCPU())
+	orDefault(&cfg.Transmit.BatchSize, transmit.DefaultBatchSize)
+	orDefault(&cfg.Transmit.MaxLatency, transmit.DefaultMaxLatency)
+	orDefault(&cfg.MonitorSync.Mode, config.DefaultMonitorSyncMode)
+	orDefault(&cfg.MonitorSync.LongPollTimeout, config.DefaultLongPollTimeout)
+	orDefault(&cfg.Upgrade.PlanSync, config.DefaultPlanSyncMode)
+	orDefault(&cfg.Monitoring.Listen, config.DefaultMonitoringListen)
+	orDefault(&cfg.Health.QueuePressurePct, 100)
+	orDefault(&cfg.Health.MonitorStaleAfter, 3*config.DefaultMonitorSyncInterval)
+	orDefault(&cfg.Health.CertExpiryWarning, health.DefaultCertExpiryWarning)
+	orDefault(&cfg.Health.LivenessWindow, health.DefaultLivenessWindow)
+	orDefault(&cfg.Health.UplinkFailureThreshold, health.DefaultUplinkFailureThreshold)
+	orDefault(&cfg.Logging.Output, config.DefaultLogOutput)
+	orDefault(&cfg.Logging.Level, config.DefaultLogLevel)
+	return cfg
+}
+
+func orDefault[T comparable](field *T, def T) {
+	var zero T
+	if *field == zero {
+		*field = def
+	}
+}
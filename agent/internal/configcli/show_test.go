@@ -0,0 +1,51 @@
+package configcli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+func TestRunShowEffectiveRedactsSecrets(t *testing.T) {
+	dataDir := t.TempDir()
+	if err := config.SaveOverlay(dataDir, &types.ConfigOverlay{HeartbeatSec: 45}); err != nil {
+		t.Fatalf("save overlay: %v", err)
+	}
+	configPath := filepath.Join(t.TempDir(), "agent.yaml")
+	body := "agent:\n  data_dir: " + dataDir + "\nproxy:\n  url: http://svc:pw@proxy.example:3128\n  password: hunter2\nmetrics:\n  otlp:\n    headers:\n      Authorization: Bearer abc\n"
+	if err := os.WriteFile(configPath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run(context.Background(), []string{"show", "--effective", "--config", configPath}, Dependencies{Out: &out}); err != nil {
+		t.Fatalf("show: %v", err)
+	}
+	for _, secret := range []string{"hunter2", "Bearer abc", ":pw@"} {
+		if strings.Contains(out.String(), secret) {
+			t.Fatalf("output leaks %q:\n%s", secret, out.String())
+		}
+	}
+
+	var shown config.Config
+	if err := yaml.Unmarshal(out.Bytes(), &shown); err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if shown.Agent.HeartbeatSec != 45 {
+		t.Fatalf("expected overlay heartbeat 45, got %d", shown.Agent.HeartbeatSec)
+	}
+	if shown.Queue.MemItemsCap != config.DefaultMemItemsCap || shown.Monitoring.Listen != config.DefaultMonitoringListen {
+		t.Fatalf("expected defaults applied, got %+v %+v", shown.Queue, shown.Monitoring)
+	}
+	if shown.Proxy.Password != "REDACTED" || shown.Metrics.OTLP.Headers["Authorization"] != "REDACTED" {
+		t.Fatalf("expected redacted secrets, got %+v %+v", shown.Proxy, shown.Metrics.OTLP.Headers)
+	}
+}
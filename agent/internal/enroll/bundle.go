@@ -0,0 +1,159 @@
+package enroll
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/agent/internal/certs"
+)
+
+// Files in an offline enrollment bundle. agent.yaml is optional.
+const (
+	bundleStateFile  = "state.yaml"
+	bundleCertFile   = "client.crt"
+	bundleKeyFile    = "client.key"
+	bundleCAFile     = "ca.pem"
+	bundleConfigFile = "agent.yaml"
+
+	maxBundleFileBytes = 1 << 20
+)
+
+var bundleFiles = []string{bundleStateFile, bundleCertFile, bundleKeyFile, bundleCAFile, bundleConfigFile}
+
+// bundle is an enrollment the controller prepared for a host that cannot
+// reach it (POST /api/admin/v1/enrollment/bundles).
+type bundle struct {
+	Server   string
+	Response *certs.Response
+}
+
+type bundleState struct {
+	AgentID string            `yaml:"agent_id"`
+	Server  string            `yaml:"server"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// loadBundle reads an offline enrollment bundle from a directory (e.g. a
+// mounted USB stick) or a .tar.gz as served by the controller, and checks
+// that its credentials are usable at now.
+func loadBundle(bundlePath string, now time.Time) (bundle, error) {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return bundle{}, fmt.Errorf("open enrollment bundle: %w", err)
+	}
+	var files map[string][]byte
+	if info.IsDir() {
+		files, err = readBundleDir(bundlePath)
+	} else {
+		files, err = readBundleArchive(bundlePath)
+	}
+	if err != nil {
+		return bundle{}, fmt.Errorf("read enrollment bundle %s: %w", bundlePath, err)
+	}
+	for _, name := range []string{bundleStateFile, bundleCertFile, bundleKeyFile, bundleCAFile} {
+		if len(files[name]) == 0 {
+			return bundle{}, fmt.Errorf("enrollment bundle %s: missing %s", bundlePath, name)
+		}
+	}
+
+	var state bundleState
+	if err := yaml.Unmarshal(files[bundleStateFile], &state); err != nil {
+		return bundle{}, fmt.Errorf("enrollment bundle %s: parse %s: %w", bundlePath, bundleStateFile, err)
+	}
+	if strings.TrimSpace(state.AgentID) == "" {
+		return bundle{}, fmt.Errorf("enrollment bundle %s: %s records no agent_id", bundlePath, bundleStateFile)
+	}
+	pair, err := tls.X509KeyPair(files[bundleCertFile], files[bundleKeyFile])
+	if err != nil {
+		return bundle{}, fmt.Errorf("enrollment bundle %s: %w", bundlePath, err)
+	}
+	if now.After(pair.Leaf.NotAfter) {
+		return bundle{}, fmt.Errorf("enrollment bundle %s: certificate expired at %s", bundlePath, pair.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(files[bundleCAFile]) {
+		return bundle{}, fmt.Errorf("enrollment bundle %s: %s contains no certificates", bundlePath, bundleCAFile)
+	}
+
+	return bundle{
+		Server: strings.TrimSpace(state.Server),
+		Response: &certs.Response{
+			AgentID:    strings.TrimSpace(state.AgentID),
+			CertPEM:    files[bundleCertFile],
+			KeyPEM:     files[bundleKeyFile],
+			CAPEM:      files[bundleCAFile],
+			ConfigYAML: files[bundleConfigFile],
+			Labels:     state.Labels,
+		},
+	}, nil
+}
+
+func readBundleDir(dir string) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+	for _, name := range bundleFiles {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, iofs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files[name] = data
+	}
+	return files, nil
+}
+
+// readBundleArchive extracts the known bundle files from a tar.gz, whatever
+// directory they are nested in. Nothing is written to disk.
+func readBundleArchive(archivePath string) (map[string][]byte, error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Base(header.Name)
+		if header.Typeflag != tar.TypeReg || !slices.Contains(bundleFiles, name) {
+			continue
+		}
+		if _, dup := files[name]; dup {
+			return nil, fmt.Errorf("duplicate %s", name)
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, maxBundleFileBytes+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxBundleFileBytes {
+			return nil, fmt.Errorf("%s exceeds %d bytes", name, maxBundleFileBytes)
+		}
+		files[name] = data
+	}
+}
@@ -3,11 +3,14 @@ package enroll
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
 	iofs "io/fs"
+	"maps"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,8 +18,11 @@ import (
 
 	"github.com/google/uuid"
 
+	"github.com/pingsantohq/agent/internal/attest"
 	"github.com/pingsantohq/agent/internal/certs"
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/netproxy"
+	"github.com/pingsantohq/agent/internal/oidc"
 )
 
 const defaultDataDir = "/var/lib/pingsanto/agent"
@@ -25,15 +31,44 @@ type Dependencies struct {
 	Issuer certs.Issuer
 	Now    func() time.Time
 	Verify func(context.Context, string, *certs.Response) error
+	// OIDCClient talks to the OIDC provider during device-flow enrollment.
+	OIDCClient *http.Client
+	// Sleep paces device-flow token polling.
+	Sleep func(context.Context, time.Duration) error
+	// Attest fetches the cloud instance identity document for --attest.
+	Attest func(ctx context.Context, provider, audience string) (attest.Document, error)
 }
 
-func (d *Dependencies) ensure() {
+// ensure fills in the default dependencies. previous is the enrollment being
+// renewed, if any; its client certificate is presented to the controller,
+// which only lets a renewal keep its agent ID with a token scoped to that
+// agent or with proof of the existing identity.
+func (d *Dependencies) ensure(proxy netproxy.Func, previous *config.State) {
 	if d.Issuer == nil {
-		d.Issuer = certs.NewHTTPIssuer(nil)
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		if previous != nil && previous.CertPath != "" && previous.KeyPath != "" {
+			// An expired certificate would fail the handshake outright, so it
+			// is left out and the token alone must be scoped to the agent.
+			if cert, err := certs.LoadKeyPair(previous.CertPath, previous.KeyPath); err == nil && (cert.Leaf == nil || time.Now().Before(cert.Leaf.NotAfter)) {
+				transport.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+			}
+		}
+		d.Issuer = certs.NewHTTPIssuer(&http.Client{Timeout: 10 * time.Second, Transport: transport})
 	}
 	if d.Now == nil {
 		d.Now = time.Now
 	}
+	if d.OIDCClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = proxy
+		d.OIDCClient = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	}
+	if d.Attest == nil {
+		d.Attest = func(ctx context.Context, provider, audience string) (attest.Document, error) {
+			return attest.Fetcher{Audience: audience}.Fetch(ctx, provider)
+		}
+	}
 	if d.Verify == nil {
 		d.Verify = func(ctx context.Context, server string, resp *certs.Response) error {
 			if resp == nil {
@@ -42,30 +77,101 @@ func (d *Dependencies) ensure() {
 			if len(resp.CertPEM) == 0 || len(resp.KeyPEM) == 0 {
 				return nil
 			}
+			if proxied(proxy, server) {
+				return certs.VerifyConnectionVia(ctx, server, proxy, resp.CertPEM, resp.KeyPEM, resp.CAPEM)
+			}
 			return certs.VerifyConnection(ctx, server, resp.CertPEM, resp.KeyPEM, resp.CAPEM)
 		}
 	}
 }
 
 func Run(ctx context.Context, args []string, deps Dependencies) error {
-	deps.ensure()
-
 	fs := flag.NewFlagSet("enroll", flag.ContinueOnError)
-	server := fs.String("server", "", "PingSanto central server URL (required)")
+	server := fs.String("server", "", "PingSanto central server URL (defaults to the bootstrap plan server)")
 	token := fs.String("token", "", "Enrollment token generated by central (required)")
 	labels := fs.String("labels", "", "Comma-separated label assignments (e.g. site=ATL-1,isp=Comcast)")
 	dataDir := fs.String("data-dir", defaultDataDir, "Agent data directory")
 	configPath := fs.String("config-path", config.DefaultConfigPath, "Destination for signed agent config")
+	bootstrapPath := fs.String("bootstrap", config.DefaultBootstrapPath, "Bootstrap plan shipped with the install package")
+	proxyURL := fs.String("proxy", "", "Proxy for enrollment traffic (http://, https://, socks5://; defaults to proxy.url in the existing agent config)")
+	renew := fs.Bool("renew", false, "Re-enroll an existing agent, keeping its agent ID and labels while obtaining fresh credentials")
+	oidcIssuer := fs.String("oidc-issuer", "", "Authorize enrollment through this OIDC provider's device flow instead of --token")
+	oidcClientID := fs.String("oidc-client-id", "", "OAuth client ID registered for agent enrollment (with --oidc-issuer)")
+	oidcScopes := fs.String("oidc-scopes", "openid", "Space-separated scopes requested during device-flow enrollment")
+	attestProvider := fs.String("attest", "", "Attach this cloud's instance identity document (aws, gcp or azure) for tokens scoped to cloud accounts")
+	attestAudience := fs.String("attest-audience", "", "Audience requested for the identity token (gcp defaults to the server URL, azure to "+attest.DefaultAzureResource+")")
+	fromBundle := fs.String("from-bundle", "", "Enroll without contacting central, from a bundle it generated (directory or .tar.gz)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	var previous *config.State
+	if *renew {
+		if explicit["labels"] {
+			return fmt.Errorf("--labels cannot be combined with --renew; change labels with `pingsanto-agent labels set`")
+		}
+		state, err := loadEnrolledState(ctx, *dataDir)
+		if err != nil {
+			return err
+		}
+		previous = &state
+		if *server == "" {
+			*server = state.Server
+		}
+		if !explicit["config-path"] && state.ConfigPath != "" {
+			*configPath = state.ConfigPath
+		}
+	}
+
+	proxyCfg, err := enrollProxyConfig(ctx, *proxyURL, *configPath)
+	if err != nil {
+		return err
+	}
+	proxy, err := netproxy.FromConfig(proxyCfg)
+	if err != nil {
+		return fmt.Errorf("configure proxy: %w", err)
+	}
+	deps.ensure(proxy, previous)
+
+	var offline *bundle
+	if *fromBundle != "" {
+		if *token != "" || *oidcIssuer != "" || *attestProvider != "" {
+			return fmt.Errorf("--from-bundle cannot be combined with --token, --oidc-issuer or --attest")
+		}
+		b, err := loadBundle(*fromBundle, deps.Now())
+		if err != nil {
+			return err
+		}
+		offline = &b
+		if *server == "" {
+			*server = b.Server
+		}
+	}
 
 	if *server == "" {
-		return fmt.Errorf("--server is required")
+		plan, ok, err := config.LoadBootstrap(*bootstrapPath)
+		if err != nil {
+			return err
+		}
+		if ok {
+			*server = plan.Server
+		}
 	}
-	if *token == "" {
-		return fmt.Errorf("--token is required")
+
+	if *server == "" {
+		return fmt.Errorf("--server is required (or ship a bootstrap plan with a server entry)")
+	}
+	switch {
+	case offline != nil:
+	case *oidcIssuer != "" && *token != "":
+		return fmt.Errorf("--token and --oidc-issuer are mutually exclusive")
+	case *oidcIssuer != "" && *oidcClientID == "":
+		return fmt.Errorf("--oidc-client-id is required with --oidc-issuer")
+	case *oidcIssuer == "" && *token == "":
+		return fmt.Errorf("--token is required (or authorize with --oidc-issuer and --oidc-client-id)")
 	}
 
 	if err := os.MkdirAll(*dataDir, 0o700); err != nil {
@@ -73,14 +179,18 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 	}
 
 	statePath := config.StatePath(*dataDir)
-	if _, err := os.Stat(statePath); err == nil {
-		return fmt.Errorf("agent already enrolled: state file %q exists", statePath)
-	} else if err != nil && !errors.Is(err, iofs.ErrNotExist) {
-		return fmt.Errorf("check state file %q: %w", statePath, err)
+	if previous == nil {
+		if _, err := os.Stat(statePath); err == nil {
+			return fmt.Errorf("agent already enrolled: state file %q exists (use --renew to obtain fresh credentials)", statePath)
+		} else if err != nil && !errors.Is(err, iofs.ErrNotExist) {
+			return fmt.Errorf("check state file %q: %w", statePath, err)
+		}
 	}
 
-	labelMap, err := parseLabels(*labels)
-	if err != nil {
+	var labelMap map[string]string
+	if previous != nil {
+		labelMap = previous.Labels
+	} else if labelMap, err = parseLabels(*labels); err != nil {
 		return err
 	}
 
@@ -90,35 +200,97 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 		Labels:  labelMap,
 		DataDir: *dataDir,
 	}
-
-	resp, err := deps.Issuer.Enroll(ctx, req)
-	if err != nil {
-		return fmt.Errorf("enrollment request failed: %w", err)
+	if previous != nil {
+		req.AgentID = previous.AgentID
 	}
-	if err := deps.Verify(ctx, *server, resp); err != nil {
-		return fmt.Errorf("mTLS verification failed: %w", err)
+	var resp *certs.Response
+	if offline != nil {
+		// The host may not reach central yet, so the handshake check is
+		// skipped; the first run reports connection failures.
+		resp = offline.Response
+	} else {
+		if *oidcIssuer != "" {
+			flow := &oidc.DeviceFlow{
+				Issuer:     *oidcIssuer,
+				ClientID:   *oidcClientID,
+				Scopes:     strings.Fields(*oidcScopes),
+				HTTPClient: deps.OIDCClient,
+				Sleep:      deps.Sleep,
+			}
+			tok, err := flow.Authorize(ctx, printDeviceCode)
+			if err != nil {
+				return fmt.Errorf("oidc device authorization: %w", err)
+			}
+			if tok.IDToken == "" {
+				return fmt.Errorf("oidc device authorization: %s issued no ID token; include the openid scope", *oidcIssuer)
+			}
+			req.IDToken = tok.IDToken
+		}
+
+		if *attestProvider != "" {
+			provider, err := attest.ParseProvider(*attestProvider)
+			if err != nil {
+				return err
+			}
+			audience := *attestAudience
+			if audience == "" && provider == attest.ProviderGCP {
+				audience = *server
+			}
+			doc, err := deps.Attest(ctx, provider, audience)
+			if err != nil {
+				return fmt.Errorf("cloud attestation: %w", err)
+			}
+			req.Attestation = &doc
+		}
+
+		resp, err = deps.Issuer.Enroll(ctx, req)
+		if err != nil {
+			return fmt.Errorf("enrollment request failed: %w", err)
+		}
+		if err := deps.Verify(ctx, *server, resp); err != nil {
+			return fmt.Errorf("mTLS verification failed: %w", err)
+		}
 	}
 
 	agentID := ""
 	if resp != nil && resp.AgentID != "" {
 		agentID = resp.AgentID
 	}
+	if previous != nil {
+		if agentID != "" && agentID != previous.AgentID {
+			return fmt.Errorf("renewal rejected: controller issued agent ID %s, expected %s; existing credentials left in place", agentID, previous.AgentID)
+		}
+		agentID = previous.AgentID
+	}
 	if agentID == "" {
 		agentID = "agt_" + strings.ReplaceAll(uuid.NewString(), "-", "")
 	}
 
-	state := config.State{
-		AgentID:    agentID,
-		Server:     *server,
-		Labels:     labelMap,
-		EnrolledAt: deps.Now().UTC(),
-		CertPath:   filepath.Join(*dataDir, "client.crt"),
-		KeyPath:    filepath.Join(*dataDir, "client.key"),
-		CAPath:     filepath.Join(*dataDir, "ca.pem"),
-		ConfigPath: *configPath,
+	state := config.State{}
+	if previous != nil {
+		// Keep upgrade bookkeeping and anything else recorded since enrollment.
+		state = *previous
+	}
+	state.AgentID = agentID
+	state.Server = *server
+	if resp != nil && len(resp.Labels) > 0 {
+		merged := make(map[string]string, len(labelMap)+len(resp.Labels))
+		maps.Copy(merged, labelMap)
+		maps.Copy(merged, resp.Labels)
+		labelMap = merged
+	}
+	state.Labels = labelMap
+	state.EnrolledAt = deps.Now().UTC()
+	state.CertPath = filepath.Join(*dataDir, "client.crt")
+	state.KeyPath = filepath.Join(*dataDir, "client.key")
+	state.CAPath = filepath.Join(*dataDir, "ca.pem")
+	state.ConfigPath = *configPath
+	state.Credentials.TokenHash = ""
+	state.Credentials.OIDCIssuer = *oidcIssuer
+	if *token != "" {
+		sum := sha256.Sum256([]byte(*token))
+		state.Credentials.TokenHash = hex.EncodeToString(sum[:])
 	}
-	sum := sha256.Sum256([]byte(*token))
-	state.Credentials.TokenHash = hex.EncodeToString(sum[:])
 
 	if resp != nil {
 		paths := certs.Paths{
@@ -129,19 +301,91 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 		if err := certs.Persist(paths, resp); err != nil {
 			return err
 		}
-		if err := config.WriteSignedConfig(*configPath, resp.ConfigYAML); err != nil {
-			return err
+		// A renewal keeps the operator's existing config; it is only written
+		// when missing.
+		if _, err := os.Stat(*configPath); previous == nil || errors.Is(err, iofs.ErrNotExist) {
+			if err := config.WriteSignedConfig(*configPath, resp.ConfigYAML); err != nil {
+				return err
+			}
 		}
 	}
 
+	if previous != nil {
+		if err := config.UpdateState(ctx, *dataDir, state); err != nil {
+			return err
+		}
+		fmt.Printf("Credentials renewed. Agent ID: %s\n", agentID)
+		return nil
+	}
 	if err := config.SaveState(ctx, *dataDir, state); err != nil {
 		return err
 	}
 
+	if offline != nil {
+		fmt.Printf("Enrollment complete from bundle %s (connectivity not verified). Agent ID: %s\n", *fromBundle, agentID)
+		return nil
+	}
 	fmt.Printf("Enrollment complete. Agent ID: %s\n", agentID)
 	return nil
 }
 
+// printDeviceCode tells the operator where to approve the enrollment.
+func printDeviceCode(code oidc.DeviceCode) {
+	if code.VerificationURIComplete != "" {
+		fmt.Printf("To authorize this agent, open %s\n", code.VerificationURIComplete)
+		fmt.Printf("and confirm the code %s.\n", code.UserCode)
+	} else {
+		fmt.Printf("To authorize this agent, open %s and enter the code %s\n", code.VerificationURI, code.UserCode)
+	}
+	fmt.Println("Waiting for authorization...")
+}
+
+// loadEnrolledState returns the state of an existing enrollment for --renew.
+func loadEnrolledState(ctx context.Context, dataDir string) (config.State, error) {
+	statePath := config.StatePath(dataDir)
+	if _, err := os.Stat(statePath); errors.Is(err, iofs.ErrNotExist) {
+		return config.State{}, fmt.Errorf("--renew requires an existing enrollment: state file %q not found", statePath)
+	}
+	state, err := config.LoadState(ctx, dataDir)
+	if err != nil {
+		return config.State{}, err
+	}
+	if state.AgentID == "" {
+		return config.State{}, fmt.Errorf("--renew requires an existing enrollment: %q records no agent_id", statePath)
+	}
+	return state, nil
+}
+
+// enrollProxyConfig prefers the --proxy flag and otherwise reuses the proxy
+// section of an agent config already present on the host.
+func enrollProxyConfig(ctx context.Context, flagURL, configPath string) (config.ProxyConfig, error) {
+	if strings.TrimSpace(flagURL) != "" {
+		return config.ProxyConfig{URL: flagURL}, nil
+	}
+	if _, err := os.Stat(configPath); err != nil {
+		return config.ProxyConfig{}, nil
+	}
+	cfg, err := config.Load(ctx, configPath)
+	if err != nil {
+		return config.ProxyConfig{}, err
+	}
+	return cfg.Proxy, nil
+}
+
+// proxied reports whether traffic to server would be routed through proxy, so
+// the handshake check keeps dialling directly when no proxy is in play.
+func proxied(proxy netproxy.Func, server string) bool {
+	if proxy == nil {
+		return false
+	}
+	req, err := http.NewRequest(http.MethodGet, server, nil)
+	if err != nil {
+		return false
+	}
+	u, err := proxy(req)
+	return err == nil && u != nil
+}
+
 func parseLabels(input string) (map[string]string, error) {
 	result := make(map[string]string)
 	if strings.TrimSpace(input) == "" {
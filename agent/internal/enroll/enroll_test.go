@@ -1,14 +1,29 @@
 package enroll
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pingsantohq/agent/internal/attest"
 	"github.com/pingsantohq/agent/internal/certs"
 	"github.com/pingsantohq/agent/internal/config"
 )
@@ -136,3 +151,275 @@ func TestRunCreatesStateFile(t *testing.T) {
 		t.Fatalf("issuer request token mismatch")
 	}
 }
+
+func TestRunUsesBootstrapServer(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	bootstrapPath := filepath.Join(dir, "bootstrap.yaml")
+	if err := os.WriteFile(bootstrapPath, []byte("server: https://bootstrap.example.com\n"), 0o600); err != nil {
+		t.Fatalf("write bootstrap: %v", err)
+	}
+	stub := &stubIssuer{resp: &certs.Response{AgentID: "agt_boot"}}
+
+	args := []string{
+		"--token", "ABC123",
+		"--data-dir", filepath.Join(dir, "data"),
+		"--config-path", filepath.Join(dir, "agent.yaml"),
+		"--bootstrap", bootstrapPath,
+	}
+	deps := Dependencies{
+		Issuer: stub,
+		Verify: func(ctx context.Context, server string, resp *certs.Response) error { return nil },
+	}
+	if err := Run(ctx, args, deps); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if stub.request.Server != "https://bootstrap.example.com" {
+		t.Fatalf("expected bootstrap server, got %q", stub.request.Server)
+	}
+}
+
+func TestRunRejectsUnsupportedProxy(t *testing.T) {
+	dir := t.TempDir()
+	args := []string{
+		"--server", "https://central.example.com",
+		"--token", "ABC123",
+		"--data-dir", dir,
+		"--config-path", filepath.Join(dir, "agent.yaml"),
+		"--proxy", "ftp://proxy.corp.example:21",
+	}
+	stub := &stubIssuer{}
+	err := Run(context.Background(), args, Dependencies{Issuer: stub})
+	if err == nil || !strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Fatalf("expected proxy scheme error, got %v", err)
+	}
+	if stub.request.Server != "" {
+		t.Fatalf("issuer should not be called with an invalid proxy")
+	}
+}
+
+func TestRunRenewKeepsIdentity(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, "agent.yaml")
+	if err := os.WriteFile(configPath, []byte("agent:\n  heartbeat_sec: 30\n"), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	existing := config.State{
+		AgentID:    "agt_keep",
+		Server:     "https://central.example.com",
+		Labels:     map[string]string{"site": "ATL-1"},
+		ConfigPath: configPath,
+	}
+	existing.Upgrade.Channel = "beta"
+	if err := config.SaveState(ctx, dir, existing); err != nil {
+		t.Fatalf("SaveState: %v", err)
+	}
+
+	stub := &stubIssuer{resp: &certs.Response{
+		AgentID:    "agt_keep",
+		CertPEM:    []byte("NEWCERT"),
+		KeyPEM:     []byte("NEWKEY"),
+		ConfigYAML: []byte("agent:\n  server: https://central.example.com\n"),
+	}}
+	deps := Dependencies{
+		Issuer: stub,
+		Verify: func(ctx context.Context, server string, resp *certs.Response) error { return nil },
+	}
+	if err := Run(ctx, []string{"--renew", "--token", "NEW", "--data-dir", dir}, deps); err != nil {
+		t.Fatalf("Run --renew: %v", err)
+	}
+	if stub.request.AgentID != "agt_keep" || stub.request.Server != "https://central.example.com" || stub.request.Labels["site"] != "ATL-1" {
+		t.Fatalf("unexpected renewal request %+v", stub.request)
+	}
+
+	state, err := config.LoadState(ctx, dir)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if state.AgentID != "agt_keep" || state.Labels["site"] != "ATL-1" || state.Upgrade.Channel != "beta" {
+		t.Fatalf("expected identity and upgrade state preserved, got %+v", state)
+	}
+	if cert, _ := os.ReadFile(filepath.Join(dir, "client.crt")); string(cert) != "NEWCERT" {
+		t.Fatalf("expected renewed certificate, got %q", cert)
+	}
+	if data, _ := os.ReadFile(configPath); string(data) != "agent:\n  heartbeat_sec: 30\n" {
+		t.Fatalf("expected existing config untouched, got %q", data)
+	}
+
+	stub.resp.AgentID = "agt_other"
+	stub.resp.CertPEM = []byte("WRONG")
+	if err := Run(ctx, []string{"--renew", "--token", "NEW", "--data-dir", dir}, deps); err == nil || !strings.Contains(err.Error(), "expected agt_keep") {
+		t.Fatalf("expected agent ID mismatch error, got %v", err)
+	}
+	if cert, _ := os.ReadFile(filepath.Join(dir, "client.crt")); string(cert) != "NEWCERT" {
+		t.Fatalf("mismatched renewal must not replace credentials, got %q", cert)
+	}
+	if err := Run(ctx, []string{"--renew", "--token", "NEW", "--data-dir", t.TempDir()}, deps); err == nil || !strings.Contains(err.Error(), "existing enrollment") {
+		t.Fatalf("expected missing enrollment error, got %v", err)
+	}
+}
+
+func TestRunAuthorizesWithOIDCDeviceFlow(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			fmt.Fprintf(w, `{"device_authorization_endpoint":%q,"token_endpoint":%q}`, idp.URL+"/device", idp.URL+"/token")
+		case "/device":
+			fmt.Fprintf(w, `{"device_code":"dev","user_code":"ABCD-EFGH","verification_uri":%q,"interval":1}`, idp.URL+"/activate")
+		case "/token":
+			fmt.Fprint(w, `{"id_token":"signed-id-token","token_type":"Bearer"}`)
+		}
+	}))
+	defer idp.Close()
+
+	stub := &stubIssuer{resp: &certs.Response{AgentID: "agt_oidc"}}
+	args := []string{
+		"--server", "https://central.example.com",
+		"--oidc-issuer", idp.URL,
+		"--oidc-client-id", "pingsanto-agent",
+		"--data-dir", dir,
+		"--config-path", filepath.Join(dir, "agent.yaml"),
+	}
+	deps := Dependencies{
+		Issuer:     stub,
+		Verify:     func(ctx context.Context, server string, resp *certs.Response) error { return nil },
+		OIDCClient: idp.Client(),
+		Sleep:      func(context.Context, time.Duration) error { return nil },
+	}
+	if err := Run(ctx, args, deps); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if stub.request.IDToken != "signed-id-token" || stub.request.Token != "" {
+		t.Fatalf("expected enrollment with the ID token, got %+v", stub.request)
+	}
+	state, err := config.LoadState(ctx, dir)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if state.Credentials.OIDCIssuer != idp.URL || state.Credentials.TokenHash != "" {
+		t.Fatalf("unexpected credentials %+v", state.Credentials)
+	}
+
+	if err := Run(ctx, []string{"--server", "https://central.example.com", "--oidc-issuer", idp.URL, "--data-dir", t.TempDir()}, deps); err == nil || !strings.Contains(err.Error(), "--oidc-client-id") {
+		t.Fatalf("expected missing client id error, got %v", err)
+	}
+}
+
+func TestRunAttachesCloudAttestation(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	stub := &stubIssuer{resp: &certs.Response{AgentID: "agt_cloud"}}
+	var gotProvider, gotAudience string
+	deps := Dependencies{
+		Issuer: stub,
+		Verify: func(ctx context.Context, server string, resp *certs.Response) error { return nil },
+		Attest: func(ctx context.Context, provider, audience string) (attest.Document, error) {
+			gotProvider, gotAudience = provider, audience
+			return attest.Document{Provider: provider, Token: "identity-jwt"}, nil
+		},
+	}
+	args := []string{
+		"--server", "https://central.example.com",
+		"--token", "enroll-token",
+		"--attest", "GCP",
+		"--data-dir", dir,
+		"--config-path", filepath.Join(dir, "agent.yaml"),
+	}
+	if err := Run(ctx, args, deps); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if gotProvider != attest.ProviderGCP || gotAudience != "https://central.example.com" {
+		t.Fatalf("unexpected attestation request %q %q", gotProvider, gotAudience)
+	}
+	if stub.request.Attestation == nil || stub.request.Attestation.Token != "identity-jwt" {
+		t.Fatalf("expected attestation in request, got %+v", stub.request)
+	}
+
+	if err := Run(ctx, []string{"--server", "https://central.example.com", "--token", "t", "--attest", "oracle", "--data-dir", t.TempDir()}, deps); err == nil {
+		t.Fatalf("expected unsupported provider to be rejected")
+	}
+}
+
+func TestRunEnrollsFromBundle(t *testing.T) {
+	ctx := context.Background()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "agt_offline"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	writeBundle := func(path string, files map[string][]byte) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		tw := tar.NewWriter(gw)
+		for name, data := range files {
+			tw.WriteHeader(&tar.Header{Name: "pingsanto-enroll_agt_offline/" + name, Mode: 0o600, Size: int64(len(data))})
+			tw.Write(data)
+		}
+		tw.Close()
+		gw.Close()
+		if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+			t.Fatalf("write bundle: %v", err)
+		}
+	}
+	bundleDir := t.TempDir()
+	bundlePath := filepath.Join(bundleDir, "bundle.tar.gz")
+	writeBundle(bundlePath, map[string][]byte{
+		"state.yaml": []byte("agent_id: agt_offline\nserver: https://central.example.com\nlabels:\n  site: ATL-1\n"),
+		"client.crt": certPEM,
+		"client.key": keyPEM,
+		"ca.pem":     certPEM,
+	})
+
+	dir := t.TempDir()
+	deps := Dependencies{
+		Issuer: &stubIssuer{err: fmt.Errorf("issuer must not be called")},
+		Verify: func(context.Context, string, *certs.Response) error { return fmt.Errorf("verify must not be called") },
+	}
+	args := []string{"--from-bundle", bundlePath, "--labels", "site=local,env=prod", "--data-dir", dir, "--config-path", filepath.Join(dir, "agent.yaml")}
+	if err := Run(ctx, args, deps); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	state, err := config.LoadState(ctx, dir)
+	if err != nil {
+		t.Fatalf("LoadState: %v", err)
+	}
+	if state.AgentID != "agt_offline" || state.Server != "https://central.example.com" || state.Labels["site"] != "ATL-1" || state.Labels["env"] != "prod" {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	if data, err := os.ReadFile(state.KeyPath); err != nil || !bytes.Equal(data, keyPEM) {
+		t.Fatalf("expected bundle key to be installed: %v", err)
+	}
+
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherDER, _ := x509.MarshalECPrivateKey(otherKey)
+	mismatched := filepath.Join(bundleDir, "mismatched")
+	os.Mkdir(mismatched, 0o700)
+	for name, data := range map[string][]byte{
+		"state.yaml": []byte("agent_id: agt_offline\n"),
+		"client.crt": certPEM,
+		"client.key": pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: otherDER}),
+		"ca.pem":     certPEM,
+	} {
+		os.WriteFile(filepath.Join(mismatched, name), data, 0o600)
+	}
+	args = []string{"--from-bundle", mismatched, "--server", "https://central.example.com", "--data-dir", t.TempDir()}
+	if err := Run(ctx, args, deps); err == nil || !strings.Contains(err.Error(), "enrollment bundle") {
+		t.Fatalf("expected mismatched key pair to be rejected, got %v", err)
+	}
+}
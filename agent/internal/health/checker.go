@@ -1,6 +1,7 @@
 package health
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -10,8 +11,15 @@ import (
 )
 
 const (
-	defaultMonitorStale    = time.Minute
-	certExpiryWarningAhead = time.Hour
+	defaultMonitorStale  = time.Minute
+	defaultWatchInterval = 15 * time.Second
+	// DefaultCertExpiryWarning is how far ahead of expiry CERT_EXPIRING is raised.
+	DefaultCertExpiryWarning = time.Hour
+	// DefaultUplinkFailureThreshold is how many consecutive failed result
+	// uploads report UPLINK_DOWN.
+	DefaultUplinkFailureThreshold = 3
+	// DefaultClockSkewThreshold is the controller clock offset beyond which the agent reports CLOCK_SKEW.
+	DefaultClockSkewThreshold = 5 * time.Second
 )
 
 const (
@@ -21,25 +29,111 @@ const (
 	categoryMonitorError   = "MONITOR_ERROR"
 	categoryCertExpiring   = "CERT_EXPIRING"
 	categoryCertExpired    = "CERT_EXPIRED"
+	categoryClockSkew      = "CLOCK_SKEW"
+	categorySpillNearCap   = "SPILL_NEAR_CAP"
+	categoryUplinkDown     = "UPLINK_DOWN"
+	categoryUpgradeFailed  = "UPGRADE_FAILED"
 )
 
+// spillWarnRatio is the fraction of the spill cap beyond which the oldest
+// spilled results are about to be evicted.
+const spillWarnRatio = 0.9
+
+// Severities attached to readiness failures.
 const (
-	severityInfo     = "info"
-	severityWarning  = "warning"
-	severityCritical = "critical"
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
 )
 
-// Checker evaluates readiness conditions for the agent.
+// Failure describes why a readiness check is not passing. The zero value
+// means the check passed.
+type Failure struct {
+	Reason   string
+	Category string
+	Severity string
+}
+
+// Check is a named readiness condition. Category and Severity label failures
+// that leave their own fields empty.
+type Check struct {
+	Name     string
+	Category string
+	Severity string
+	Func     func(now time.Time) Failure
+}
+
+// CheckResult is the outcome of one registered check.
+type CheckResult struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Reason   string `json:"reason,omitempty"`
+	Category string `json:"category,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+// Category is an active readiness category and its severity.
+type Category struct {
+	Name     string `json:"name"`
+	Severity string `json:"severity"`
+}
+
+// Report is the full outcome of a readiness evaluation, served by
+// /readyz?format=json. Since is when the current state was first observed;
+// the optional timestamps are omitted until known.
+type Report struct {
+	Ready                bool          `json:"ready"`
+	CheckedAt            time.Time     `json:"checked_at"`
+	Since                time.Time     `json:"since"`
+	Reasons              []string      `json:"reasons,omitempty"`
+	Categories           []Category    `json:"categories,omitempty"`
+	Checks               []CheckResult `json:"checks"`
+	LastMonitorSync      *time.Time    `json:"last_monitor_sync,omitempty"`
+	LastMonitorSyncError *time.Time    `json:"last_monitor_sync_error,omitempty"`
+	CertExpiry           *time.Time    `json:"cert_expiry,omitempty"`
+}
+
+// Transition describes a change in overall readiness observed by Ready.
+type Transition struct {
+	Ready      bool
+	At         time.Time
+	Reasons    []string
+	Categories []metrics.ReadinessCategory
+}
+
+// Checker evaluates readiness conditions for the agent. Conditions are held
+// in a registry so subsystems can contribute their own checks; the monitor
+// sync, queue, certificate and clock skew checks are registered by
+// NewChecker, while the uplink, spill store and upgrade manager register
+// theirs as they start.
 type Checker struct {
-	metrics       *metrics.Store
-	queueCapacity int
-	staleAfter    time.Duration
+	metrics *metrics.Store
+
+	checksMu sync.RWMutex
+	checks   []Check
+
+	transitionMu sync.Mutex
+	readyKnown   bool
+	lastReady    bool
+	readySince   time.Time
+	listeners    []func(Transition)
 
 	mu                 sync.RWMutex
+	queueCapacity      int
+	staleAfter         time.Duration
+	graceUntil         time.Time
+	queuePressurePct   int
+	certWarning        time.Duration
 	lastMonitorSuccess time.Time
 	monitorErr         string
 	lastMonitorError   time.Time
 	certExpiry         time.Time
+	clockSkew          time.Duration
+	clockSkewKnown     bool
+	skewThreshold      time.Duration
+	uploadFailures     int
+	uploadErr          string
+	uplinkThreshold    int
 }
 
 // NewChecker constructs a readiness checker bound to the provided metrics store.
@@ -47,10 +141,49 @@ func NewChecker(store *metrics.Store, queueCapacity int, staleAfter time.Duratio
 	if staleAfter <= 0 {
 		staleAfter = defaultMonitorStale
 	}
-	return &Checker{
-		metrics:       store,
-		queueCapacity: queueCapacity,
-		staleAfter:    staleAfter,
+	c := &Checker{
+		metrics:          store,
+		queueCapacity:    queueCapacity,
+		staleAfter:       staleAfter,
+		queuePressurePct: 100,
+		certWarning:      DefaultCertExpiryWarning,
+		skewThreshold:    DefaultClockSkewThreshold,
+		uplinkThreshold:  DefaultUplinkFailureThreshold,
+	}
+	c.Register(Check{Name: "queue", Category: categoryQueuePressure, Severity: SeverityWarning, Func: c.checkQueue})
+	c.Register(Check{Name: "monitor_sync", Func: c.checkMonitorSync})
+	c.Register(Check{Name: "monitor_error", Category: categoryMonitorError, Severity: SeverityCritical, Func: c.checkMonitorError})
+	c.Register(Check{Name: "certificate", Func: c.checkCertificate})
+	c.Register(Check{Name: "clock_skew", Category: categoryClockSkew, Severity: SeverityWarning, Func: c.checkClockSkew})
+	return c
+}
+
+// Register adds a readiness check, replacing any existing check with the
+// same name. Checks are evaluated in registration order.
+func (c *Checker) Register(check Check) {
+	if check.Name == "" || check.Func == nil {
+		return
+	}
+	c.checksMu.Lock()
+	defer c.checksMu.Unlock()
+	for i := range c.checks {
+		if c.checks[i].Name == check.Name {
+			c.checks[i] = check
+			return
+		}
+	}
+	c.checks = append(c.checks, check)
+}
+
+// Unregister removes the named check.
+func (c *Checker) Unregister(name string) {
+	c.checksMu.Lock()
+	defer c.checksMu.Unlock()
+	for i := range c.checks {
+		if c.checks[i].Name == name {
+			c.checks = append(c.checks[:i], c.checks[i+1:]...)
+			return
+		}
 	}
 }
 
@@ -68,6 +201,37 @@ func (c *Checker) ObserveMonitorSync(ts time.Time, err error) {
 	c.lastMonitorError = time.Time{}
 }
 
+// ObserveUpload records the outcome of a results upload. Consecutive
+// failures are counted until the next success.
+func (c *Checker) ObserveUpload(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.uploadFailures = 0
+		c.uploadErr = ""
+		return
+	}
+	c.uploadFailures++
+	c.uploadErr = err.Error()
+}
+
+// UplinkCheck returns the UPLINK_DOWN check fed by ObserveUpload, for the
+// agent to register once results are being uploaded.
+func (c *Checker) UplinkCheck() Check {
+	return Check{Name: "uplink", Category: categoryUplinkDown, Severity: SeverityCritical, Func: c.checkUplink}
+}
+
+// SetUplinkFailureThreshold sets how many consecutive upload failures report
+// UPLINK_DOWN. A non-positive value restores the default.
+func (c *Checker) SetUplinkFailureThreshold(n int) {
+	if n <= 0 {
+		n = DefaultUplinkFailureThreshold
+	}
+	c.mu.Lock()
+	c.uplinkThreshold = n
+	c.mu.Unlock()
+}
+
 // SetCertExpiry records the expiry timestamp of the current client certificate.
 func (c *Checker) SetCertExpiry(expiry time.Time) {
 	c.mu.Lock()
@@ -75,69 +239,346 @@ func (c *Checker) SetCertExpiry(expiry time.Time) {
 	c.mu.Unlock()
 }
 
+// SetClockSkewThreshold overrides the tolerated clock offset from the controller.
+// A non-positive value restores the default.
+func (c *Checker) SetClockSkewThreshold(threshold time.Duration) {
+	if threshold <= 0 {
+		threshold = DefaultClockSkewThreshold
+	}
+	c.mu.Lock()
+	c.skewThreshold = threshold
+	c.mu.Unlock()
+}
+
+// SetQueueCapacity updates the in-memory queue size QUEUE_PRESSURE is
+// measured against, e.g. after a config reload.
+func (c *Checker) SetQueueCapacity(capacity int) {
+	c.mu.Lock()
+	c.queueCapacity = capacity
+	c.mu.Unlock()
+}
+
+// SetQueuePressureThreshold sets the queue fill percentage (1-100) at which
+// QUEUE_PRESSURE is reported. Out-of-range values restore the default of 100.
+func (c *Checker) SetQueuePressureThreshold(pct int) {
+	if pct <= 0 || pct > 100 {
+		pct = 100
+	}
+	c.mu.Lock()
+	c.queuePressurePct = pct
+	c.mu.Unlock()
+}
+
+// SetMonitorStaleAfter overrides how long the last successful monitor sync
+// may age before MONITOR_STALE. A non-positive value keeps the current setting.
+func (c *Checker) SetMonitorStaleAfter(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.staleAfter = d
+	c.mu.Unlock()
+}
+
+// SetStartupGrace suppresses MONITOR_PENDING until grace has elapsed after
+// startedAt, so a fresh agent is not reported not-ready during its first
+// monitor sync. A non-positive grace disables the window.
+func (c *Checker) SetStartupGrace(startedAt time.Time, grace time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if grace <= 0 {
+		c.graceUntil = time.Time{}
+		return
+	}
+	c.graceUntil = startedAt.Add(grace)
+}
+
+// SetCertExpiryWarning overrides how far ahead of expiry CERT_EXPIRING is
+// raised. A non-positive value restores the default.
+func (c *Checker) SetCertExpiryWarning(d time.Duration) {
+	if d <= 0 {
+		d = DefaultCertExpiryWarning
+	}
+	c.mu.Lock()
+	c.certWarning = d
+	c.mu.Unlock()
+}
+
+// ObserveClockSkew records the local clock offset from the controller (positive
+// when the agent runs ahead) and publishes it as a metric.
+func (c *Checker) ObserveClockSkew(skew time.Duration) {
+	c.mu.Lock()
+	c.clockSkew = skew
+	c.clockSkewKnown = true
+	c.mu.Unlock()
+	if c.metrics != nil {
+		c.metrics.ObserveClockSkew(skew)
+	}
+}
+
+// Evaluate runs every registered check and returns one result per check.
+func (c *Checker) Evaluate(now time.Time) []CheckResult {
+	c.checksMu.RLock()
+	checks := append([]Check(nil), c.checks...)
+	c.checksMu.RUnlock()
+	return evaluateChecks(checks, now)
+}
+
+func evaluateChecks(checks []Check, now time.Time) []CheckResult {
+	results := make([]CheckResult, 0, len(checks))
+	for _, check := range checks {
+		failure := check.Func(now)
+		result := CheckResult{Name: check.Name, OK: failure.Reason == ""}
+		if !result.OK {
+			result.Reason = failure.Reason
+			result.Category = failure.Category
+			if result.Category == "" {
+				result.Category = check.Category
+			}
+			result.Severity = failure.Severity
+			if result.Severity == "" {
+				result.Severity = check.Severity
+			}
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
 // Ready evaluates all readiness conditions and returns the overall status and reasons for failure.
 func (c *Checker) Ready(now time.Time) (bool, []string) {
-	reasons := make([]string, 0, 4)
-	categories := make([]metrics.ReadinessCategory, 0, 4)
-	appendCategory := func(name, severity string) {
+	report := c.Report(now)
+	return report.Ready, report.Reasons
+}
+
+// Operational reports whether the agent has done its job since starting: it
+// synced monitors from the controller at least once, the latest sync did not
+// fail, and result uploads are not failing. Unlike Ready it ignores the
+// startup grace period and local conditions such as queue pressure, so it
+// suits judging whether a freshly upgraded binary works.
+func (c *Checker) Operational(now time.Time) (bool, []string) {
+	c.mu.RLock()
+	synced := !c.lastMonitorSuccess.IsZero()
+	c.mu.RUnlock()
+	var reasons []string
+	if !synced {
+		reasons = append(reasons, "monitors not yet synced")
+	}
+	for _, check := range []func(time.Time) Failure{c.checkMonitorError, c.checkUplink} {
+		if failure := check(now); failure.Reason != "" {
+			reasons = append(reasons, failure.Reason)
+		}
+	}
+	return len(reasons) == 0, reasons
+}
+
+// Report evaluates all readiness conditions like Ready and returns the
+// per-check results with the timestamps behind them.
+func (c *Checker) Report(now time.Time) Report {
+	report := Report{CheckedAt: now, Checks: c.Evaluate(now)}
+	var categories []metrics.ReadinessCategory
+	for _, result := range report.Checks {
+		if result.OK {
+			continue
+		}
+		report.Reasons = append(report.Reasons, result.Reason)
+		report.Categories = append(report.Categories, Category{Name: result.Category, Severity: result.Severity})
 		categories = append(categories, metrics.ReadinessCategory{
-			Name:     name,
-			Severity: severity,
+			Name:     result.Category,
+			Severity: result.Severity,
 		})
 	}
 
-	if c.metrics != nil && c.queueCapacity > 0 {
-		snap := c.metrics.Snapshot()
-		if snap.QueueDepth >= int64(c.queueCapacity) {
-			reasons = append(reasons, "queue capacity exceeded")
-			appendCategory(categoryQueuePressure, severityWarning)
+	report.Ready = len(report.Reasons) == 0
+	if c.metrics != nil {
+		if report.Ready {
+			c.metrics.ObserveReadiness(true, "", nil)
+		} else {
+			c.metrics.ObserveReadiness(false, strings.Join(report.Reasons, "; "), categories)
 		}
 	}
+	report.Since = c.noteTransition(Transition{Ready: report.Ready, At: now, Reasons: report.Reasons, Categories: categories})
 
 	c.mu.RLock()
-	lastSuccess := c.lastMonitorSuccess
-	monitorErr := c.monitorErr
-	lastErr := c.lastMonitorError
-	certExpiry := c.certExpiry
-	staleAfter := c.staleAfter
+	report.LastMonitorSync = optionalTime(c.lastMonitorSuccess)
+	report.LastMonitorSyncError = optionalTime(c.lastMonitorError)
+	report.CertExpiry = optionalTime(c.certExpiry)
 	c.mu.RUnlock()
+	return report
+}
 
-	if lastSuccess.IsZero() {
-		reasons = append(reasons, "monitors not yet synced")
-		appendCategory(categoryMonitorPending, severityInfo)
-	} else if staleAfter > 0 && now.Sub(lastSuccess) > staleAfter {
-		reasons = append(reasons, fmt.Sprintf("monitor sync stale (%s)", now.Sub(lastSuccess).Round(time.Second)))
-		appendCategory(categoryMonitorStale, severityWarning)
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// OnTransition registers fn to be called whenever Ready observes readiness
+// flip. The first evaluation only establishes the baseline. fn runs on the
+// evaluating goroutine and must not block.
+func (c *Checker) OnTransition(fn func(Transition)) {
+	if fn == nil {
+		return
 	}
+	c.transitionMu.Lock()
+	c.listeners = append(c.listeners, fn)
+	c.transitionMu.Unlock()
+}
 
-	if monitorErr != "" {
-		if staleAfter <= 0 || now.Sub(lastErr) <= staleAfter {
-			reasons = append(reasons, fmt.Sprintf("monitor sync failing: %s", monitorErr))
-			appendCategory(categoryMonitorError, severityCritical)
+// noteTransition records the latest state, notifies listeners on a flip and
+// returns when the current state began.
+func (c *Checker) noteTransition(t Transition) time.Time {
+	c.transitionMu.Lock()
+	changed := c.readyKnown && c.lastReady != t.Ready
+	if !c.readyKnown || changed {
+		c.readySince = t.At
+	}
+	c.readyKnown = true
+	c.lastReady = t.Ready
+	since := c.readySince
+	listeners := c.listeners
+	c.transitionMu.Unlock()
+	if changed {
+		for _, fn := range listeners {
+			fn(t)
 		}
 	}
+	return since
+}
 
-	if !certExpiry.IsZero() {
-		if !certExpiry.After(now) {
-			reasons = append(reasons, "client certificate expired")
-			appendCategory(categoryCertExpired, severityCritical)
-		} else if certExpiry.Sub(now) < certExpiryWarningAhead {
-			reasons = append(reasons, "client certificate expiring soon")
-			appendCategory(categoryCertExpiring, severityWarning)
+// Watch evaluates readiness every interval until ctx is cancelled, so
+// transitions are noticed even when nothing polls /readyz.
+func (c *Checker) Watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultWatchInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.Ready(time.Now().UTC())
 		}
 	}
+}
 
-	ready := len(reasons) == 0
-	if c.metrics != nil {
-		reasonText := strings.Join(reasons, "; ")
-		if ready {
-			c.metrics.ObserveReadiness(true, "", nil)
-		} else {
-			c.metrics.ObserveReadiness(false, reasonText, categories)
+func (c *Checker) checkQueue(time.Time) Failure {
+	c.mu.RLock()
+	capacity, pct := c.queueCapacity, c.queuePressurePct
+	c.mu.RUnlock()
+	if c.metrics == nil || capacity <= 0 {
+		return Failure{}
+	}
+	depth := c.metrics.Snapshot().QueueDepth
+	if pct >= 100 {
+		if depth >= int64(capacity) {
+			return Failure{Reason: "queue capacity exceeded"}
+		}
+		return Failure{}
+	}
+	if depth*100 >= int64(capacity)*int64(pct) {
+		return Failure{Reason: fmt.Sprintf("queue above %d%% of capacity", pct)}
+	}
+	return Failure{}
+}
+
+func (c *Checker) checkMonitorSync(now time.Time) Failure {
+	c.mu.RLock()
+	lastSuccess, staleAfter, graceUntil := c.lastMonitorSuccess, c.staleAfter, c.graceUntil
+	c.mu.RUnlock()
+	if lastSuccess.IsZero() {
+		if now.Before(graceUntil) {
+			return Failure{}
 		}
+		return Failure{Reason: "monitors not yet synced", Category: categoryMonitorPending, Severity: SeverityInfo}
 	}
-	if !ready {
-		return false, reasons
+	if staleAfter > 0 && now.Sub(lastSuccess) > staleAfter {
+		return Failure{
+			Reason:   fmt.Sprintf("monitor sync stale (%s)", now.Sub(lastSuccess).Round(time.Second)),
+			Category: categoryMonitorStale,
+			Severity: SeverityWarning,
+		}
+	}
+	return Failure{}
+}
+
+func (c *Checker) checkUplink(time.Time) Failure {
+	c.mu.RLock()
+	failures, lastErr, threshold := c.uploadFailures, c.uploadErr, c.uplinkThreshold
+	c.mu.RUnlock()
+	if failures < threshold {
+		return Failure{}
+	}
+	return Failure{Reason: fmt.Sprintf("results upload failing (%d consecutive): %s", failures, lastErr)}
+}
+
+func (c *Checker) checkMonitorError(now time.Time) Failure {
+	c.mu.RLock()
+	monitorErr, lastErr, staleAfter := c.monitorErr, c.lastMonitorError, c.staleAfter
+	c.mu.RUnlock()
+	if monitorErr != "" && (staleAfter <= 0 || now.Sub(lastErr) <= staleAfter) {
+		return Failure{Reason: fmt.Sprintf("monitor sync failing: %s", monitorErr)}
+	}
+	return Failure{}
+}
+
+func (c *Checker) checkCertificate(now time.Time) Failure {
+	c.mu.RLock()
+	certExpiry, warnAhead := c.certExpiry, c.certWarning
+	c.mu.RUnlock()
+	switch {
+	case certExpiry.IsZero():
+		return Failure{}
+	case !certExpiry.After(now):
+		return Failure{Reason: "client certificate expired", Category: categoryCertExpired, Severity: SeverityCritical}
+	case certExpiry.Sub(now) < warnAhead:
+		return Failure{Reason: "client certificate expiring soon", Category: categoryCertExpiring, Severity: SeverityWarning}
+	}
+	return Failure{}
+}
+
+func (c *Checker) checkClockSkew(time.Time) Failure {
+	c.mu.RLock()
+	clockSkew, clockSkewKnown, skewThreshold := c.clockSkew, c.clockSkewKnown, c.skewThreshold
+	c.mu.RUnlock()
+	if clockSkewKnown && (clockSkew > skewThreshold || clockSkew < -skewThreshold) {
+		return Failure{Reason: fmt.Sprintf("clock skew %s from controller exceeds %s", clockSkew.Round(time.Millisecond), skewThreshold)}
+	}
+	return Failure{}
+}
+
+// SpillCapacityCheck fails once the spill store holds more than 90% of
+// capBytes, the point at which further spills start evicting unsent results.
+func SpillCapacityCheck(sizeBytes func() int64, capBytes int64) Check {
+	return Check{
+		Name:     "spill_capacity",
+		Category: categorySpillNearCap,
+		Severity: SeverityWarning,
+		Func: func(time.Time) Failure {
+			if capBytes <= 0 {
+				return Failure{}
+			}
+			size := sizeBytes()
+			if float64(size) < spillWarnRatio*float64(capBytes) {
+				return Failure{}
+			}
+			return Failure{Reason: fmt.Sprintf("spill store at %d%% of capacity", size*100/capBytes)}
+		},
+	}
+}
+
+// UpgradeCheck fails while lastFailure reports a failed upgrade attempt, so
+// a rolled-back or broken upgrade is visible until the next attempt.
+func UpgradeCheck(lastFailure func() string) Check {
+	return Check{
+		Name:     "upgrade",
+		Category: categoryUpgradeFailed,
+		Severity: SeverityWarning,
+		Func: func(time.Time) Failure {
+			return Failure{Reason: lastFailure()}
+		},
 	}
-	return true, nil
 }
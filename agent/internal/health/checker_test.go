@@ -31,7 +31,7 @@ func TestCheckerReadyConditions(t *testing.T) {
 	if snap.ReadyTransitions != 0 || snap.NotReadyTransitions != 0 || snap.ReadyAlerts != 0 {
 		t.Fatalf("expected readiness counters to remain zero initially, got %+v", snap)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorPending, severityInfo) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorPending, SeverityInfo) {
 		t.Fatalf("expected MONITOR_PENDING category, got %+v", snap.ReadyCategories)
 	}
 
@@ -73,7 +73,7 @@ func TestCheckerReadyConditions(t *testing.T) {
 	if snap.ReadyTransitions != 1 || snap.NotReadyTransitions != 1 || snap.ReadyAlerts != 1 {
 		t.Fatalf("expected counters after queue alert to be (1,1,1), got %+v", snap)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryQueuePressure, severityWarning) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryQueuePressure, SeverityWarning) {
 		t.Fatalf("expected QUEUE_PRESSURE category, got %+v", snap.ReadyCategories)
 	}
 
@@ -94,7 +94,7 @@ func TestCheckerReadyConditions(t *testing.T) {
 	if snap.ReadyTransitions != 1 || snap.NotReadyTransitions != 1 || snap.ReadyAlerts != 1 {
 		t.Fatalf("expected counters unchanged during stale period, got %+v", snap)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorStale, severityWarning) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorStale, SeverityWarning) {
 		t.Fatalf("expected MONITOR_STALE category, got %+v", snap.ReadyCategories)
 	}
 
@@ -117,10 +117,10 @@ func TestCheckerReadyConditions(t *testing.T) {
 	if snap.ReadyTransitions != 1 || snap.NotReadyTransitions != 1 || snap.ReadyAlerts != 1 {
 		t.Fatalf("expected counters unchanged during repeated failure, got %+v", snap)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorError, severityCritical) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorError, SeverityCritical) {
 		t.Fatalf("expected MONITOR_ERROR category, got %+v", snap.ReadyCategories)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorStale, severityWarning) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryMonitorStale, SeverityWarning) {
 		t.Fatalf("expected stale category persisted, got %+v", snap.ReadyCategories)
 	}
 
@@ -164,7 +164,7 @@ func TestCheckerReadyConditions(t *testing.T) {
 	if snap.ReadyTransitions != 2 || snap.NotReadyTransitions != 2 || snap.ReadyAlerts != 2 {
 		t.Fatalf("expected counters after cert warning to be (2,2,2), got %+v", snap)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryCertExpiring, severityWarning) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryCertExpiring, SeverityWarning) {
 		t.Fatalf("expected CERT_EXPIRING category, got %+v", snap.ReadyCategories)
 	}
 
@@ -208,7 +208,7 @@ func TestCheckerExpiredCertificate(t *testing.T) {
 	if !strings.Contains(snap.ReadyReason, "client certificate expired") {
 		t.Fatalf("expected readiness reason to mention expiry, got %q", snap.ReadyReason)
 	}
-	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryCertExpired, severityCritical) {
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryCertExpired, SeverityCritical) {
 		t.Fatalf("expected CERT_EXPIRED category, got %+v", snap.ReadyCategories)
 	}
 }
@@ -221,3 +221,211 @@ func containsCategoryWithSeverity(categories []metrics.ReadinessCategory, name,
 	}
 	return false
 }
+
+func TestCheckerClockSkew(t *testing.T) {
+	store := metrics.NewStore()
+	checker := NewChecker(store, 0, time.Minute)
+	now := time.Unix(1000, 0).UTC()
+	checker.ObserveMonitorSync(now, nil)
+	checker.SetClockSkewThreshold(2 * time.Second)
+
+	checker.ObserveClockSkew(-1500 * time.Millisecond)
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready within skew threshold, got %v", reasons)
+	}
+
+	checker.ObserveClockSkew(-3 * time.Second)
+	ready, reasons := checker.Ready(now)
+	if ready || len(reasons) != 1 || !strings.Contains(reasons[0], "clock skew -3s") {
+		t.Fatalf("expected clock skew reason, got ready=%v reasons=%v", ready, reasons)
+	}
+	snap := store.Snapshot()
+	if !containsCategoryWithSeverity(snap.ReadyCategories, categoryClockSkew, SeverityWarning) {
+		t.Fatalf("expected CLOCK_SKEW category, got %+v", snap.ReadyCategories)
+	}
+	if !snap.ClockSkewKnown || snap.ClockSkewSeconds != -3 {
+		t.Fatalf("expected skew gauge -3s, got %+v", snap)
+	}
+}
+
+func TestCheckerRegistry(t *testing.T) {
+	store := metrics.NewStore()
+	checker := NewChecker(store, 0, time.Minute)
+	now := time.Now()
+	checker.ObserveMonitorSync(now, nil)
+
+	failing := true
+	checker.Register(Check{
+		Name:     "uplink",
+		Category: "UPLINK_DOWN",
+		Severity: SeverityCritical,
+		Func: func(time.Time) Failure {
+			if failing {
+				return Failure{Reason: "uplink unreachable"}
+			}
+			return Failure{}
+		},
+	})
+
+	ready, reasons := checker.Ready(now)
+	if ready || len(reasons) != 1 || reasons[0] != "uplink unreachable" {
+		t.Fatalf("expected registered check to fail readiness, got ready=%v reasons=%v", ready, reasons)
+	}
+	if !containsCategoryWithSeverity(store.Snapshot().ReadyCategories, "UPLINK_DOWN", SeverityCritical) {
+		t.Fatalf("expected registered category, got %+v", store.Snapshot().ReadyCategories)
+	}
+
+	results := checker.Evaluate(now)
+	names := make([]string, 0, len(results))
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	if got := strings.Join(names, ","); got != "queue,monitor_sync,monitor_error,certificate,clock_skew,uplink" {
+		t.Fatalf("unexpected check order %s", got)
+	}
+
+	failing = false
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready once check passes, got %v", reasons)
+	}
+
+	failing = true
+	checker.Unregister("uplink")
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready after unregistering, got %v", reasons)
+	}
+}
+
+func TestSpillCapacityCheck(t *testing.T) {
+	size := int64(50)
+	check := SpillCapacityCheck(func() int64 { return size }, 100)
+	if f := check.Func(time.Now()); f.Reason != "" {
+		t.Fatalf("expected pass at 50%%, got %q", f.Reason)
+	}
+	size = 95
+	if f := check.Func(time.Now()); f.Reason != "spill store at 95% of capacity" {
+		t.Fatalf("unexpected failure %q", f.Reason)
+	}
+}
+
+func TestUpgradeCheckFailsReadiness(t *testing.T) {
+	store := metrics.NewStore()
+	checker := NewChecker(store, 0, time.Minute)
+	now := time.Now()
+	checker.ObserveMonitorSync(now, nil)
+	lastFailure := ""
+	checker.Register(UpgradeCheck(func() string { return lastFailure }))
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready without a failed upgrade, got %v", reasons)
+	}
+
+	lastFailure = "upgrade to 1.2.0 failed: restart: exec failed"
+	report := checker.Report(now)
+	if report.Ready || len(report.Reasons) != 1 || report.Reasons[0] != lastFailure {
+		t.Fatalf("expected failed upgrade to turn readiness red, got %+v", report)
+	}
+	if report.Categories[0] != (Category{Name: "UPGRADE_FAILED", Severity: SeverityWarning}) {
+		t.Fatalf("unexpected category %+v", report.Categories)
+	}
+	if !containsCategoryWithSeverity(store.Snapshot().ReadyCategories, "UPGRADE_FAILED", SeverityWarning) {
+		t.Fatalf("expected UPGRADE_FAILED in metrics, got %+v", store.Snapshot().ReadyCategories)
+	}
+}
+
+func TestCheckerConfigurableThresholds(t *testing.T) {
+	store := metrics.NewStore()
+	checker := NewChecker(store, 100, time.Minute)
+	now := time.Now()
+	checker.ObserveMonitorSync(now, nil)
+
+	checker.SetQueuePressureThreshold(80)
+	store.QueueRecorder().ObserveQueueDepth(79)
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready below 80%%, got %v", reasons)
+	}
+	store.QueueRecorder().ObserveQueueDepth(80)
+	if ready, reasons := checker.Ready(now); ready || reasons[0] != "queue above 80% of capacity" {
+		t.Fatalf("expected queue pressure at 80%%, got ready=%v reasons=%v", ready, reasons)
+	}
+	store.QueueRecorder().ObserveQueueDepth(0)
+
+	checker.SetMonitorStaleAfter(10 * time.Minute)
+	if ready, reasons := checker.Ready(now.Add(5 * time.Minute)); !ready {
+		t.Fatalf("expected monitor sync fresh within 10m, got %v", reasons)
+	}
+
+	checker.SetCertExpiry(now.Add(48 * time.Hour))
+	if ready, _ := checker.Ready(now); !ready {
+		t.Fatalf("expected ready with default 1h cert warning")
+	}
+	checker.SetCertExpiryWarning(7 * 24 * time.Hour)
+	if ready, reasons := checker.Ready(now); ready || reasons[0] != "client certificate expiring soon" {
+		t.Fatalf("expected cert warning within 7d window, got ready=%v reasons=%v", ready, reasons)
+	}
+}
+
+func TestCheckerStartupGrace(t *testing.T) {
+	checker := NewChecker(metrics.NewStore(), 100, time.Minute)
+	start := time.Now()
+	checker.SetStartupGrace(start, 30*time.Second)
+
+	if ready, reasons := checker.Ready(start.Add(10 * time.Second)); !ready {
+		t.Fatalf("expected ready during startup grace, got %v", reasons)
+	}
+	ready, reasons := checker.Ready(start.Add(31 * time.Second))
+	if ready || len(reasons) != 1 || reasons[0] != "monitors not yet synced" {
+		t.Fatalf("expected MONITOR_PENDING after grace, got ready=%v reasons=%v", ready, reasons)
+	}
+	checker.ObserveMonitorSync(start.Add(40*time.Second), errors.New("boom"))
+	checker.SetStartupGrace(start, time.Hour)
+	if ready, reasons := checker.Ready(start.Add(41 * time.Second)); ready || reasons[0] != "monitor sync failing: boom" {
+		t.Fatalf("expected sync errors to surface during grace, got ready=%v reasons=%v", ready, reasons)
+	}
+}
+
+func TestCheckerUplinkDown(t *testing.T) {
+	checker := NewChecker(metrics.NewStore(), 100, time.Minute)
+	checker.Register(checker.UplinkCheck())
+	now := time.Now()
+	checker.ObserveMonitorSync(now, nil)
+
+	checker.ObserveUpload(errors.New("connection refused"))
+	checker.ObserveUpload(errors.New("connection refused"))
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready below threshold, got %v", reasons)
+	}
+	checker.ObserveUpload(errors.New("connection refused"))
+	report := checker.Report(now)
+	if report.Ready || report.Reasons[0] != "results upload failing (3 consecutive): connection refused" {
+		t.Fatalf("expected UPLINK_DOWN, got %+v", report)
+	}
+	if report.Categories[0] != (Category{Name: "UPLINK_DOWN", Severity: SeverityCritical}) {
+		t.Fatalf("unexpected category %+v", report.Categories)
+	}
+
+	checker.ObserveUpload(nil)
+	if ready, reasons := checker.Ready(now); !ready {
+		t.Fatalf("expected ready after a successful upload, got %v", reasons)
+	}
+}
+
+func TestCheckerOperational(t *testing.T) {
+	checker := NewChecker(metrics.NewStore(), 1, time.Minute)
+	start := time.Now()
+	checker.SetStartupGrace(start, time.Hour)
+
+	if ok, reasons := checker.Operational(start); ok || len(reasons) != 1 || reasons[0] != "monitors not yet synced" {
+		t.Fatalf("expected unsynced agent to be non-operational despite grace, got ok=%v reasons=%v", ok, reasons)
+	}
+	checker.ObserveMonitorSync(start, nil)
+	for i := 0; i < DefaultUplinkFailureThreshold; i++ {
+		checker.ObserveUpload(errors.New("connection refused"))
+	}
+	if ok, _ := checker.Operational(start); ok {
+		t.Fatalf("expected failing uploads to make the agent non-operational")
+	}
+	checker.ObserveUpload(nil)
+	if ok, reasons := checker.Operational(start.Add(2 * time.Minute)); !ok {
+		t.Fatalf("expected operational after sync and upload, got %v", reasons)
+	}
+}
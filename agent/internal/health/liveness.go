@@ -0,0 +1,109 @@
+package health
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultLivenessWindow is how long the scheduler or a busy worker pool may
+// go without progress before /livez fails.
+const DefaultLivenessWindow = 2 * time.Minute
+
+const (
+	categorySchedulerStalled = "SCHEDULER_STALLED"
+	categoryWorkersStalled   = "WORKERS_STALLED"
+)
+
+// Liveness evaluates whether the agent's core loops are still making progress.
+// Unlike readiness, a failing liveness check means the process is wedged and
+// should be restarted by its supervisor.
+type Liveness struct {
+	window time.Duration
+
+	mu     sync.RWMutex
+	checks []Check
+}
+
+// NewLiveness constructs a liveness checker. A non-positive window uses
+// DefaultLivenessWindow.
+func NewLiveness(window time.Duration) *Liveness {
+	if window <= 0 {
+		window = DefaultLivenessWindow
+	}
+	return &Liveness{window: window}
+}
+
+// Window reports the progress window applied by the built-in checks.
+func (l *Liveness) Window() time.Duration {
+	return l.window
+}
+
+// Register adds a liveness check, replacing any existing check with the same name.
+func (l *Liveness) Register(check Check) {
+	if check.Name == "" || check.Func == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.checks {
+		if l.checks[i].Name == check.Name {
+			l.checks[i] = check
+			return
+		}
+	}
+	l.checks = append(l.checks, check)
+}
+
+// WatchScheduler fails liveness when the scheduler has not ticked within the
+// window. A zero time means the scheduler has not started yet and passes.
+func (l *Liveness) WatchScheduler(lastTick func() time.Time) {
+	l.Register(Check{
+		Name:     "scheduler",
+		Category: categorySchedulerStalled,
+		Severity: SeverityCritical,
+		Func: func(now time.Time) Failure {
+			last := lastTick()
+			if last.IsZero() || now.Sub(last) <= l.window {
+				return Failure{}
+			}
+			return Failure{Reason: fmt.Sprintf("scheduler has not ticked for %s", now.Sub(last).Truncate(time.Second))}
+		},
+	})
+}
+
+// WatchWorkers fails liveness when probe jobs are pending but no worker has
+// picked up or finished a job within the window. An idle pool always passes.
+func (l *Liveness) WatchWorkers(progress func() (pending int, last time.Time)) {
+	l.Register(Check{
+		Name:     "workers",
+		Category: categoryWorkersStalled,
+		Severity: SeverityCritical,
+		Func: func(now time.Time) Failure {
+			pending, last := progress()
+			if pending == 0 || last.IsZero() || now.Sub(last) <= l.window {
+				return Failure{}
+			}
+			return Failure{Reason: fmt.Sprintf("%d probe jobs pending with no worker progress for %s", pending, now.Sub(last).Truncate(time.Second))}
+		},
+	})
+}
+
+// Evaluate runs every registered liveness check.
+func (l *Liveness) Evaluate(now time.Time) []CheckResult {
+	l.mu.RLock()
+	checks := append([]Check(nil), l.checks...)
+	l.mu.RUnlock()
+	return evaluateChecks(checks, now)
+}
+
+// Live reports whether every liveness check passes, with the failure reasons.
+func (l *Liveness) Live(now time.Time) (bool, []string) {
+	var reasons []string
+	for _, result := range l.Evaluate(now) {
+		if !result.OK {
+			reasons = append(reasons, result.Reason)
+		}
+	}
+	return len(reasons) == 0, reasons
+}
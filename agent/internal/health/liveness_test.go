@@ -0,0 +1,51 @@
+package health
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLivenessSchedulerStall(t *testing.T) {
+	now := time.Now()
+	lastTick := time.Time{}
+	l := NewLiveness(time.Minute)
+	l.WatchScheduler(func() time.Time { return lastTick })
+
+	if live, reasons := l.Live(now); !live {
+		t.Fatalf("expected live before scheduler start, got %v", reasons)
+	}
+	lastTick = now.Add(-30 * time.Second)
+	if live, reasons := l.Live(now); !live {
+		t.Fatalf("expected live within window, got %v", reasons)
+	}
+	lastTick = now.Add(-2 * time.Minute)
+	live, reasons := l.Live(now)
+	if live || len(reasons) != 1 || reasons[0] != "scheduler has not ticked for 2m0s" {
+		t.Fatalf("expected scheduler stall, got live=%v reasons=%v", live, reasons)
+	}
+	results := l.Evaluate(now)
+	if results[0].Category != categorySchedulerStalled || results[0].Severity != SeverityCritical {
+		t.Fatalf("unexpected result %+v", results[0])
+	}
+}
+
+func TestLivenessWorkersStallOnlyWithPendingJobs(t *testing.T) {
+	now := time.Now()
+	pending := 0
+	last := now.Add(-10 * time.Minute)
+	l := NewLiveness(time.Minute)
+	l.WatchWorkers(func() (int, time.Time) { return pending, last })
+
+	if live, reasons := l.Live(now); !live {
+		t.Fatalf("expected idle pool to be live, got %v", reasons)
+	}
+	pending = 3
+	live, reasons := l.Live(now)
+	if live || reasons[0] != "3 probe jobs pending with no worker progress for 10m0s" {
+		t.Fatalf("expected worker stall, got live=%v reasons=%v", live, reasons)
+	}
+	last = now.Add(-5 * time.Second)
+	if live, reasons := l.Live(now); !live {
+		t.Fatalf("expected live after recent progress, got %v", reasons)
+	}
+}
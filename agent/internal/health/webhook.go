@@ -0,0 +1,198 @@
+package health
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWebhookTimeout     = 10 * time.Second
+	defaultWebhookMaxAttempts = 5
+	defaultWebhookBackoff     = time.Second
+	maxWebhookBackoff         = time.Minute
+	webhookQueueSize          = 32
+)
+
+// WebhookOptions configures readiness transition notifications.
+type WebhookOptions struct {
+	URL     string
+	Headers map[string]string
+	AgentID string
+	// MaxAttempts bounds delivery attempts per transition (default 5).
+	// Attempts back off exponentially from one second.
+	MaxAttempts int
+	Timeout     time.Duration
+	// HTTPClient carries the agent's proxy settings; Timeout applies when
+	// the client sets none.
+	HTTPClient *http.Client
+	Logger     *log.Logger
+}
+
+// WebhookPayload is the JSON body posted for each readiness transition.
+type WebhookPayload struct {
+	AgentID    string     `json:"agent_id,omitempty"`
+	Ready      bool       `json:"ready"`
+	State      string     `json:"state"`
+	Timestamp  time.Time  `json:"timestamp"`
+	Reasons    []string   `json:"reasons,omitempty"`
+	Categories []Category `json:"categories,omitempty"`
+}
+
+// Webhook posts readiness transitions to an HTTP endpoint. Notify queues a
+// transition without blocking; Run delivers them in order with retries.
+type Webhook struct {
+	url         string
+	headers     map[string]string
+	agentID     string
+	maxAttempts int
+	client      *http.Client
+	logger      *log.Logger
+	backoff     time.Duration
+	queue       chan Transition
+}
+
+// NewWebhook validates opts and returns a notifier.
+func NewWebhook(opts WebhookOptions) (*Webhook, error) {
+	endpoint, err := url.Parse(strings.TrimSpace(opts.URL))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid readiness webhook URL %q", opts.URL)
+	}
+	maxAttempts := opts.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultWebhookMaxAttempts
+	}
+	client := opts.HTTPClient
+	if client == nil || client.Timeout <= 0 {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = defaultWebhookTimeout
+		}
+		withTimeout := http.Client{Timeout: timeout}
+		if client != nil {
+			withTimeout = *client
+			withTimeout.Timeout = timeout
+		}
+		client = &withTimeout
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	return &Webhook{
+		url:         endpoint.String(),
+		headers:     opts.Headers,
+		agentID:     opts.AgentID,
+		maxAttempts: maxAttempts,
+		client:      client,
+		logger:      logger,
+		backoff:     defaultWebhookBackoff,
+		queue:       make(chan Transition, webhookQueueSize),
+	}, nil
+}
+
+// Notify queues t for delivery. When the queue is full the oldest pending
+// transition is dropped so the latest state always gets through.
+func (w *Webhook) Notify(t Transition) {
+	for {
+		select {
+		case w.queue <- t:
+			return
+		default:
+		}
+		select {
+		case <-w.queue:
+			w.logger.Printf("readiness webhook queue full; dropping oldest transition")
+		default:
+		}
+	}
+}
+
+// Run delivers queued transitions until ctx is cancelled.
+func (w *Webhook) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case t := <-w.queue:
+			if err := w.deliver(ctx, t); err != nil && ctx.Err() == nil {
+				w.logger.Printf("readiness webhook delivery failed: %v", err)
+			}
+		}
+	}
+}
+
+func (w *Webhook) deliver(ctx context.Context, t Transition) error {
+	body, err := json.Marshal(w.payload(t))
+	if err != nil {
+		return fmt.Errorf("encode payload: %w", err)
+	}
+	delay := w.backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := w.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.maxAttempts {
+			return fmt.Errorf("after %d attempts: %w", attempt, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		delay *= 2
+		if delay > maxWebhookBackoff {
+			delay = maxWebhookBackoff
+		}
+	}
+}
+
+// post sends one attempt and reports whether a failure is worth retrying.
+func (w *Webhook) post(ctx context.Context, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("send request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("webhook returned %s", resp.Status)
+}
+
+func (w *Webhook) payload(t Transition) WebhookPayload {
+	p := WebhookPayload{
+		AgentID:   w.agentID,
+		Ready:     t.Ready,
+		State:     "not_ready",
+		Timestamp: t.At.UTC(),
+		Reasons:   t.Reasons,
+	}
+	if t.Ready {
+		p.State = "ready"
+	}
+	for _, c := range t.Categories {
+		p.Categories = append(p.Categories, Category{Name: c.Name, Severity: c.Severity})
+	}
+	return p
+}
@@ -0,0 +1,112 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/metrics"
+)
+
+func TestCheckerOnTransitionFiresOnFlip(t *testing.T) {
+	store := metrics.NewStore()
+	checker := NewChecker(store, 10, time.Minute)
+	var got []Transition
+	checker.OnTransition(func(tr Transition) { got = append(got, tr) })
+
+	now := time.Now()
+	checker.Ready(now) // baseline: monitors pending
+	checker.Ready(now)
+	if len(got) != 0 {
+		t.Fatalf("expected no transition while state is unchanged, got %+v", got)
+	}
+	checker.ObserveMonitorSync(now, nil)
+	checker.Ready(now)
+	if len(got) != 1 || !got[0].Ready {
+		t.Fatalf("expected ready transition, got %+v", got)
+	}
+	store.QueueRecorder().ObserveQueueDepth(10)
+	checker.Ready(now)
+	if len(got) != 2 || got[1].Ready || got[1].Categories[0].Name != categoryQueuePressure {
+		t.Fatalf("expected not-ready transition with queue category, got %+v", got)
+	}
+}
+
+func TestWebhookRetriesUntilDelivered(t *testing.T) {
+	var attempts atomic.Int32
+	payloads := make(chan WebhookPayload, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("decode payload: %v", err)
+		}
+		if r.Header.Get("X-Token") != "secret" {
+			t.Errorf("missing custom header")
+		}
+		payloads <- p
+	}))
+	defer srv.Close()
+
+	hook, err := NewWebhook(WebhookOptions{URL: srv.URL, AgentID: "agt_1", Headers: map[string]string{"X-Token": "secret"}})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	hook.backoff = time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go hook.Run(ctx)
+
+	hook.Notify(Transition{
+		Ready:      false,
+		At:         time.Unix(1700000000, 0),
+		Reasons:    []string{"queue capacity exceeded"},
+		Categories: []metrics.ReadinessCategory{{Name: categoryQueuePressure, Severity: SeverityWarning}},
+	})
+
+	select {
+	case p := <-payloads:
+		if p.AgentID != "agt_1" || p.Ready || p.State != "not_ready" || len(p.Categories) != 1 || p.Categories[0].Name != categoryQueuePressure {
+			t.Fatalf("unexpected payload %+v", p)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("webhook not delivered")
+	}
+	if attempts.Load() != 3 {
+		t.Fatalf("expected 3 attempts, got %d", attempts.Load())
+	}
+}
+
+func TestWebhookDoesNotRetryClientErrors(t *testing.T) {
+	var attempts atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	hook, err := NewWebhook(WebhookOptions{URL: srv.URL})
+	if err != nil {
+		t.Fatalf("NewWebhook: %v", err)
+	}
+	hook.backoff = time.Millisecond
+	if err := hook.deliver(context.Background(), Transition{Ready: true, At: time.Now()}); err == nil {
+		t.Fatalf("expected delivery error")
+	}
+	if attempts.Load() != 1 {
+		t.Fatalf("expected a single attempt, got %d", attempts.Load())
+	}
+}
+
+func TestNewWebhookRejectsInvalidURL(t *testing.T) {
+	if _, err := NewWebhook(WebhookOptions{URL: "ftp://example.com"}); err == nil {
+		t.Fatalf("expected error for non-http URL")
+	}
+}
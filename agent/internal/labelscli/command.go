@@ -0,0 +1,125 @@
+package labelscli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+type Dependencies struct {
+	Out io.Writer
+}
+
+const usage = "usage: pingsanto-agent labels list|set k=v...|unset k... [--config path] [--data-dir dir]"
+
+// Run dispatches `pingsanto-agent labels <subcommand>`. Changes are written
+// to state.yaml; a running agent picks them up and reports them to the
+// controller on its next heartbeat.
+func Run(ctx context.Context, args []string, deps Dependencies) error {
+	if deps.Out == nil {
+		deps.Out = os.Stdout
+	}
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+	sub := args[0]
+	switch sub {
+	case "list", "set", "unset":
+	default:
+		return fmt.Errorf("unknown labels subcommand %q (want list, set or unset)", sub)
+	}
+
+	fs := flag.NewFlagSet("labels "+sub, flag.ContinueOnError)
+	configPath := fs.String("config", config.DefaultConfigPath, "Path to agent configuration file")
+	dataDirFlag := fs.String("data-dir", "", "Override for agent data directory")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	operands := fs.Args()
+	if sub != "list" && len(operands) == 0 {
+		return errors.New(usage)
+	}
+
+	dataDir := strings.TrimSpace(*dataDirFlag)
+	if dataDir == "" {
+		cfg, err := config.Load(ctx, *configPath)
+		if err != nil {
+			return fmt.Errorf("load config: %w", err)
+		}
+		dataDir = strings.TrimSpace(cfg.Agent.DataDir)
+	}
+	if dataDir == "" {
+		return fmt.Errorf("agent data directory is required (provide via --data-dir or config)")
+	}
+
+	state, err := config.LoadState(ctx, dataDir)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	if state.AgentID == "" {
+		return fmt.Errorf("agent is not enrolled (no agent_id in %s)", config.StatePath(dataDir))
+	}
+
+	if sub != "list" {
+		labels, err := apply(state.Labels, sub, operands)
+		if err != nil {
+			return err
+		}
+		state.Labels = labels
+		if err := config.UpdateState(ctx, dataDir, state); err != nil {
+			return fmt.Errorf("update state: %w", err)
+		}
+		fmt.Fprintln(deps.Out, "Labels updated; a running agent reports them on its next heartbeat.")
+	}
+
+	writeLabels(deps.Out, state.Labels)
+	return nil
+}
+
+// apply returns a copy of labels with the set or unset operands applied.
+func apply(labels map[string]string, sub string, operands []string) (map[string]string, error) {
+	out := make(map[string]string, len(labels))
+	for k, v := range labels {
+		out[k] = v
+	}
+	for _, op := range operands {
+		if sub == "unset" {
+			key := strings.TrimSpace(op)
+			if _, ok := out[key]; !ok {
+				return nil, fmt.Errorf("label %q is not set", key)
+			}
+			delete(out, key)
+			continue
+		}
+		key, value, ok := strings.Cut(op, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("invalid label %q (expected key=value)", op)
+		}
+		out[key] = value
+	}
+	return out, nil
+}
+
+func writeLabels(out io.Writer, labels map[string]string) {
+	if len(labels) == 0 {
+		fmt.Fprintln(out, "Labels: (none)")
+		return
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	fmt.Fprintln(out, "Labels:")
+	for _, k := range keys {
+		fmt.Fprintf(out, "  %s=%s\n", k, labels[k])
+	}
+}
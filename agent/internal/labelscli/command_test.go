@@ -0,0 +1,49 @@
+package labelscli
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+func TestRunSetAndUnsetLabels(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	if err := config.SaveState(ctx, dataDir, config.State{AgentID: "agt_1", Labels: map[string]string{"site": "ATL-1", "env": "prod"}}); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := Run(ctx, []string{"set", "--data-dir", dataDir, "site=ORD-2", "isp=Comcast"}, Dependencies{Out: &out}); err != nil {
+		t.Fatalf("labels set: %v", err)
+	}
+	if err := Run(ctx, []string{"unset", "--data-dir", dataDir, "env"}, Dependencies{Out: &out}); err != nil {
+		t.Fatalf("labels unset: %v", err)
+	}
+
+	state, err := config.LoadState(ctx, dataDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if len(state.Labels) != 2 || state.Labels["site"] != "ORD-2" || state.Labels["isp"] != "Comcast" {
+		t.Fatalf("unexpected labels: %v", state.Labels)
+	}
+
+	out.Reset()
+	if err := Run(ctx, []string{"list", "--data-dir", dataDir}, Dependencies{Out: &out}); err != nil {
+		t.Fatalf("labels list: %v", err)
+	}
+	if !strings.Contains(out.String(), "  isp=Comcast\n  site=ORD-2\n") {
+		t.Fatalf("unexpected list output: %q", out.String())
+	}
+
+	if err := Run(ctx, []string{"set", "--data-dir", dataDir, "bogus"}, Dependencies{Out: &out}); err == nil {
+		t.Fatalf("expected invalid label to be rejected")
+	}
+	if err := Run(ctx, []string{"unset", "--data-dir", dataDir, "missing"}, Dependencies{Out: &out}); err == nil {
+		t.Fatalf("expected unknown label to be rejected")
+	}
+}
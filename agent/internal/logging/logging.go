@@ -1,10 +1,117 @@
 package logging
 
 import (
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"strings"
+	"sync"
 )
 
 func New() *log.Logger {
 	return log.New(os.Stdout, "pingsanto-agent ", log.LstdFlags|log.LUTC)
 }
+
+// Log levels accepted by logging.level and the controller's config overlay.
+// The agent's regular log lines are info; debug adds per-upload detail.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+)
+
+// ParseLevel normalizes level, treating "" as info.
+func ParseLevel(level string) (string, error) {
+	switch level = strings.ToLower(strings.TrimSpace(level)); level {
+	case "", LevelInfo:
+		return LevelInfo, nil
+	case LevelDebug:
+		return LevelDebug, nil
+	default:
+		return "", fmt.Errorf("unknown log level %q; use %s or %s", level, LevelDebug, LevelInfo)
+	}
+}
+
+// Output points a logger at stdout, stderr or a file and can be re-applied
+// at runtime, e.g. to reopen a file after logrotate has moved it. It also
+// owns a debug logger that writes to the same place while the level is
+// debug and discards otherwise.
+type Output struct {
+	logger *log.Logger
+	debug  *log.Logger
+
+	mu      sync.Mutex
+	file    *os.File
+	w       io.Writer
+	debugOn bool
+}
+
+// NewOutput binds an Output to logger.
+func NewOutput(logger *log.Logger) *Output {
+	return &Output{
+		logger: logger,
+		debug:  log.New(io.Discard, logger.Prefix()+"debug: ", logger.Flags()),
+		w:      logger.Writer(),
+	}
+}
+
+// Debug returns the logger for debug lines.
+func (o *Output) Debug() *log.Logger {
+	return o.debug
+}
+
+// SetLevel switches between the debug and info levels; see ParseLevel.
+func (o *Output) SetLevel(level string) error {
+	level, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.debugOn = level == LevelDebug
+	o.applyDebug()
+	return nil
+}
+
+func (o *Output) applyDebug() {
+	if o.debugOn {
+		o.debug.SetOutput(o.w)
+	} else {
+		o.debug.SetOutput(io.Discard)
+	}
+}
+
+// Set switches the logger to dest: "" or "stdout", "stderr", or a file path
+// opened for append. The previous file, if any, is closed after the switch.
+func (o *Output) Set(dest string) error {
+	var w io.Writer
+	var file *os.File
+	switch dest = strings.TrimSpace(dest); dest {
+	case "", "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640)
+		if err != nil {
+			return fmt.Errorf("open log file %q: %w", dest, err)
+		}
+		w, file = f, f
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.logger.SetOutput(w)
+	o.w = w
+	o.applyDebug()
+	if o.file != nil {
+		o.file.Close()
+	}
+	o.file = file
+	return nil
+}
+
+// Close releases the current log file, if any, and reverts to stdout.
+func (o *Output) Close() error {
+	return o.Set("")
+}
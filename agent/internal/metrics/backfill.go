@@ -0,0 +1,56 @@
+package metrics
+
+import "time"
+
+// BackfillRecorder returns an implementation of BackfillRecorder backed by
+// the store's registry.
+func (s *Store) BackfillRecorder() BackfillRecorder {
+	return s.backfill
+}
+
+func newBackfillRecorder(s *Store) backfillRecorder {
+	return backfillRecorder{
+		pendingBytes: s.Gauge("pingsanto_agent_backfill_pending_bytes", "Bytes currently pending in backfill spill storage."),
+		batches:      s.Counter("pingsanto_agent_backfill_replayed_batches_total", "Batches replayed from spill storage."),
+		results:      s.Counter("pingsanto_agent_backfill_replayed_results_total", "Probe results replayed from spill storage."),
+		lag:          s.Gauge("pingsanto_agent_backfill_replay_lag_seconds", "Age of the oldest probe result still pending replay (0 when drained)."),
+		throughput:   s.Gauge("pingsanto_agent_backfill_replay_throughput", "Probe results replayed per second over the last measurement window."),
+	}
+}
+
+type backfillRecorder struct {
+	pendingBytes *Gauge
+	batches      *Counter
+	results      *Counter
+	lag          *Gauge
+	throughput   *Gauge
+}
+
+func (r backfillRecorder) ObservePendingBytes(bytes int64) {
+	if bytes < 0 {
+		bytes = 0
+	}
+	r.pendingBytes.Set(float64(bytes))
+}
+
+func (r backfillRecorder) ObserveReplayedBatch(results int) {
+	if results <= 0 {
+		return
+	}
+	r.batches.Inc()
+	r.results.Add(uint64(results))
+}
+
+func (r backfillRecorder) ObserveReplayLag(lag time.Duration) {
+	if lag < 0 {
+		lag = 0
+	}
+	r.lag.Set(lag.Seconds())
+}
+
+func (r backfillRecorder) ObserveReplayThroughput(perSecond float64) {
+	if perSecond < 0 {
+		perSecond = 0
+	}
+	r.throughput.Set(perSecond)
+}
@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/buildinfo"
+)
+
+// buildPrometheusLines identifies the binary and how long it has been running.
+func buildPrometheusLines(startedAt time.Time) []string {
+	info := buildinfo.Get()
+	return []string{
+		"# HELP pingsanto_agent_build_info Agent build identity (always 1).",
+		"# TYPE pingsanto_agent_build_info gauge",
+		fmt.Sprintf("pingsanto_agent_build_info{version=%q,commit=%q,goversion=%q} 1", info.Version, info.Commit, info.GoVersion),
+		"# HELP pingsanto_agent_uptime_seconds_total Seconds since the agent process started.",
+		"# TYPE pingsanto_agent_uptime_seconds_total counter",
+		fmt.Sprintf("pingsanto_agent_uptime_seconds_total %g", time.Since(startedAt).Seconds()),
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+
+package metrics
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileDescriptorUsage counts entries in /proc/self/fd and reads the soft
+// RLIMIT_NOFILE.
+func fileDescriptorUsage() (open int, limit uint64, ok bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, false
+	}
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err == nil {
+		limit = rl.Cur
+	}
+	return len(entries), limit, true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package metrics
+
+// fileDescriptorUsage is only implemented on Linux.
+func fileDescriptorUsage() (open int, limit uint64, ok bool) {
+	return 0, 0, false
+}
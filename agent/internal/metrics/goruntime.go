@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// goRuntimePrometheusLines reports Go runtime and process resource usage under
+// the conventional go_* and process_* names, so stock Go process dashboards
+// work against the agent. ReadMemStats briefly stops the world; that is
+// acceptable at scrape frequency.
+func goRuntimePrometheusLines() []string {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	var lastPause float64
+	if ms.NumGC > 0 {
+		lastPause = float64(ms.PauseNs[(ms.NumGC+255)%256]) / 1e9
+	}
+
+	lines := []string{
+		"# HELP go_goroutines Number of goroutines that currently exist.",
+		"# TYPE go_goroutines gauge",
+		fmt.Sprintf("go_goroutines %d", runtime.NumGoroutine()),
+		"# HELP go_memstats_heap_alloc_bytes Bytes of allocated heap objects.",
+		"# TYPE go_memstats_heap_alloc_bytes gauge",
+		fmt.Sprintf("go_memstats_heap_alloc_bytes %d", ms.HeapAlloc),
+		"# HELP go_memstats_heap_inuse_bytes Bytes in in-use heap spans.",
+		"# TYPE go_memstats_heap_inuse_bytes gauge",
+		fmt.Sprintf("go_memstats_heap_inuse_bytes %d", ms.HeapInuse),
+		"# HELP go_memstats_heap_objects Number of allocated heap objects.",
+		"# TYPE go_memstats_heap_objects gauge",
+		fmt.Sprintf("go_memstats_heap_objects %d", ms.HeapObjects),
+		"# HELP go_memstats_alloc_bytes_total Cumulative bytes allocated for heap objects.",
+		"# TYPE go_memstats_alloc_bytes_total counter",
+		fmt.Sprintf("go_memstats_alloc_bytes_total %d", ms.TotalAlloc),
+		"# HELP go_memstats_sys_bytes Total bytes of memory obtained from the OS.",
+		"# TYPE go_memstats_sys_bytes gauge",
+		fmt.Sprintf("go_memstats_sys_bytes %d", ms.Sys),
+		"# HELP go_memstats_next_gc_bytes Heap size target for the next GC cycle.",
+		"# TYPE go_memstats_next_gc_bytes gauge",
+		fmt.Sprintf("go_memstats_next_gc_bytes %d", ms.NextGC),
+		"# HELP go_gc_cycles_total Number of completed GC cycles.",
+		"# TYPE go_gc_cycles_total counter",
+		fmt.Sprintf("go_gc_cycles_total %d", ms.NumGC),
+		"# HELP go_gc_pause_seconds_total Cumulative stop-the-world GC pause time.",
+		"# TYPE go_gc_pause_seconds_total counter",
+		fmt.Sprintf("go_gc_pause_seconds_total %g", float64(ms.PauseTotalNs)/1e9),
+		"# HELP go_gc_last_pause_seconds Duration of the most recent GC pause.",
+		"# TYPE go_gc_last_pause_seconds gauge",
+		fmt.Sprintf("go_gc_last_pause_seconds %g", lastPause),
+	}
+
+	if open, limit, ok := fileDescriptorUsage(); ok {
+		lines = append(lines,
+			"# HELP process_open_fds Number of open file descriptors.",
+			"# TYPE process_open_fds gauge",
+			fmt.Sprintf("process_open_fds %d", open),
+		)
+		if limit > 0 {
+			lines = append(lines,
+				"# HELP process_max_fds Soft limit on open file descriptors.",
+				"# TYPE process_max_fds gauge",
+				fmt.Sprintf("process_max_fds %d", limit),
+			)
+		}
+	}
+	return lines
+}
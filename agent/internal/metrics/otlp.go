@@ -0,0 +1,425 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultOTLPInterval = 30 * time.Second
+	defaultOTLPTimeout  = 10 * time.Second
+	otlpMetricsPath     = "/v1/metrics"
+	otlpScopeName       = "github.com/pingsantohq/agent"
+
+	// aggregationTemporalityCumulative is AGGREGATION_TEMPORALITY_CUMULATIVE in the OTLP proto.
+	aggregationTemporalityCumulative = 2
+)
+
+// OTLPOptions configures periodic OTLP/HTTP export of the store.
+type OTLPOptions struct {
+	// Endpoint is the collector base URL (e.g. https://otel:4318) or the full
+	// metrics URL; /v1/metrics is appended when the URL has no path.
+	Endpoint string
+	Headers  map[string]string
+	Interval time.Duration
+	Timeout  time.Duration
+	// Resource attributes identify the agent, e.g. service.instance.id.
+	Resource map[string]string
+	// HTTPClient carries the agent's proxy settings; Timeout applies when
+	// the client sets none.
+	HTTPClient *http.Client
+	Logger     *log.Logger
+}
+
+// OTLPExporter pushes the store's metrics to an OpenTelemetry collector using
+// OTLP/HTTP with the JSON encoding, so no collector-side Prometheus scrape of
+// the agent is needed. Counters are exported as cumulative monotonic sums.
+type OTLPExporter struct {
+	store    *Store
+	url      string
+	headers  map[string]string
+	interval time.Duration
+	resource map[string]string
+	client   *http.Client
+	logger   *log.Logger
+}
+
+// NewOTLPExporter validates opts and returns an exporter bound to store.
+func NewOTLPExporter(store *Store, opts OTLPOptions) (*OTLPExporter, error) {
+	if store == nil {
+		return nil, fmt.Errorf("metrics store is required")
+	}
+	endpoint, err := url.Parse(strings.TrimSpace(opts.Endpoint))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", opts.Endpoint)
+	}
+	if endpoint.Path == "" || endpoint.Path == "/" {
+		endpoint.Path = otlpMetricsPath
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultOTLPInterval
+	}
+	client := opts.HTTPClient
+	if client == nil || client.Timeout <= 0 {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = defaultOTLPTimeout
+		}
+		withTimeout := http.Client{Timeout: timeout}
+		if client != nil {
+			withTimeout = *client
+			withTimeout.Timeout = timeout
+		}
+		client = &withTimeout
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	resource := map[string]string{"service.name": "pingsanto-agent"}
+	for k, v := range opts.Resource {
+		resource[k] = v
+	}
+	return &OTLPExporter{
+		store:    store,
+		url:      endpoint.String(),
+		headers:  opts.Headers,
+		interval: interval,
+		resource: resource,
+		client:   client,
+		logger:   logger,
+	}, nil
+}
+
+// Run exports on every interval until ctx is cancelled. Export failures are
+// logged and retried on the next tick.
+func (e *OTLPExporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.Export(ctx); err != nil {
+				e.logger.Printf("otlp metrics export failed: %v", err)
+			}
+		}
+	}
+}
+
+// Export sends one snapshot to the collector.
+func (e *OTLPExporter) Export(ctx context.Context) error {
+	payload, err := json.Marshal(e.buildRequest(time.Now()))
+	if err != nil {
+		return fmt.Errorf("marshal otlp request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("build otlp request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send otlp request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP JSON encoding of ExportMetricsServiceRequest. 64-bit integers are
+// strings, as required by the protobuf JSON mapping.
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	AsInt             *string         `json:"asInt,omitempty"`
+	AsDouble          *float64        `json:"asDouble,omitempty"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	TimeUnixNano      string          `json:"timeUnixNano"`
+	Count             string          `json:"count"`
+	Sum               float64         `json:"sum"`
+	BucketCounts      []string        `json:"bucketCounts"`
+	ExplicitBounds    []float64       `json:"explicitBounds"`
+}
+
+type otlpAttribute struct {
+	Key   string        `json:"key"`
+	Value otlpAttrValue `json:"value"`
+}
+
+type otlpAttrValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+func (e *OTLPExporter) buildRequest(now time.Time) otlpRequest {
+	snap := e.store.Snapshot()
+	b := otlpBuilder{
+		start: strconv.FormatInt(e.store.startedAt.UnixNano(), 10),
+		now:   strconv.FormatInt(now.UnixNano(), 10),
+	}
+
+	b.gaugeInt("pingsanto_agent_queue_depth_number", "Number of probe results currently buffered in memory.", "", snap.QueueDepth)
+	b.sumInt("pingsanto_agent_queue_dropped_total", "Total probe results dropped due to queue pressure.", snap.QueueDroppedTotal)
+	b.sumInt("pingsanto_agent_queue_spilled_total", "Total probe results spilled to disk.", snap.QueueSpilledTotal)
+	ready := int64(0)
+	if snap.Ready {
+		ready = 1
+	}
+	b.gaugeInt("pingsanto_agent_ready", "Whether the agent considers itself ready (1=ready).", "", ready)
+	b.sumPoints("pingsanto_agent_ready_transitions_total", "Count of readiness state transitions by resulting state.",
+		b.intPoint(snap.ReadyTransitions, "state", "ready"),
+		b.intPoint(snap.NotReadyTransitions, "state", "not_ready"))
+	b.sumInt("pingsanto_agent_ready_alerts_total", "Total number of readiness alert transitions.", snap.ReadyAlerts)
+	if snap.ClockSkewKnown {
+		v := snap.ClockSkewSeconds
+		b.metrics = append(b.metrics, otlpMetric{
+			Name:        "pingsanto_agent_clock_skew_seconds",
+			Description: "Agent clock offset from the controller (positive when the agent is ahead).",
+			Unit:        "s",
+			Gauge:       &otlpGauge{DataPoints: []otlpNumberPoint{{TimeUnixNano: b.now, AsDouble: &v}}},
+		})
+	}
+
+	if len(snap.UplinkEndpoints) > 0 {
+		var requests, errs, sent []otlpNumberPoint
+		var latency []otlpHistogramPoint
+		for _, ep := range snap.UplinkEndpoints {
+			classes := make([]string, 0, len(ep.StatusClasses))
+			for class := range ep.StatusClasses {
+				classes = append(classes, class)
+			}
+			sort.Strings(classes)
+			for _, class := range classes {
+				requests = append(requests, b.intPoint(ep.StatusClasses[class], "endpoint", ep.Endpoint, "code", class))
+			}
+			errs = append(errs, b.intPoint(ep.Errors, "endpoint", ep.Endpoint))
+			sent = append(sent, b.intPoint(ep.BytesSent, "endpoint", ep.Endpoint))
+			latency = append(latency, b.histogramPoint(UplinkLatencyBuckets, ep.LatencyBuckets, ep.LatencyCount, ep.LatencySum, "endpoint", ep.Endpoint))
+		}
+		b.sumPoints("pingsanto_agent_uplink_requests_total", "Requests sent to the central service by endpoint and status class.", requests...)
+		b.sumPoints("pingsanto_agent_uplink_request_errors_total", "Requests that failed in transport or returned a 4xx/5xx status.", errs...)
+		b.sumPoints("pingsanto_agent_uplink_request_bytes_sent_total", "Request body bytes sent to the central service.", sent...)
+		b.histogram("pingsanto_agent_uplink_request_duration_seconds", "Time until response headers were received.", latency)
+	}
+
+	if len(snap.Monitors) > 0 {
+		var results []otlpNumberPoint
+		var rtt []otlpHistogramPoint
+		for _, m := range snap.Monitors {
+			results = append(results,
+				b.intPoint(m.Successes, "monitor_id", m.MonitorID, "protocol", m.Protocol, "result", "success"),
+				b.intPoint(m.Failures, "monitor_id", m.MonitorID, "protocol", m.Protocol, "result", "failure"))
+			rtt = append(rtt, b.histogramPoint(ProbeLatencyBuckets, m.LatencyBuckets, m.LatencyCount, m.LatencySum, "monitor_id", m.MonitorID, "protocol", m.Protocol))
+		}
+		b.sumPoints("pingsanto_agent_probe_results_total", "Probe results by monitor and outcome.", results...)
+		b.histogram("pingsanto_agent_probe_rtt_seconds", "Round-trip time of successful probes by monitor.", rtt)
+	}
+
+	for _, f := range e.store.Registered() {
+		b.registered(f)
+	}
+
+	keys := make([]string, 0, len(e.resource))
+	for k := range e.resource {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	resource := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		resource = append(resource, otlpAttribute{Key: k, Value: otlpAttrValue{StringValue: e.resource[k]}})
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     otlpResource{Attributes: resource},
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: otlpScopeName}, Metrics: b.metrics}},
+	}}}
+}
+
+type otlpBuilder struct {
+	start   string
+	now     string
+	metrics []otlpMetric
+}
+
+func attrs(kv []string) []otlpAttribute {
+	out := make([]otlpAttribute, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		out = append(out, otlpAttribute{Key: kv[i], Value: otlpAttrValue{StringValue: kv[i+1]}})
+	}
+	return out
+}
+
+func (b *otlpBuilder) intPoint(v uint64, kv ...string) otlpNumberPoint {
+	s := strconv.FormatUint(v, 10)
+	return otlpNumberPoint{Attributes: attrs(kv), StartTimeUnixNano: b.start, TimeUnixNano: b.now, AsInt: &s}
+}
+
+func (b *otlpBuilder) gaugeInt(name, desc, unit string, v int64) {
+	s := strconv.FormatInt(v, 10)
+	b.metrics = append(b.metrics, otlpMetric{
+		Name:        name,
+		Description: desc,
+		Unit:        unit,
+		Gauge:       &otlpGauge{DataPoints: []otlpNumberPoint{{TimeUnixNano: b.now, AsInt: &s}}},
+	})
+}
+
+func (b *otlpBuilder) gaugeDouble(name, desc, unit string, v float64) {
+	b.metrics = append(b.metrics, otlpMetric{
+		Name:        name,
+		Description: desc,
+		Unit:        unit,
+		Gauge:       &otlpGauge{DataPoints: []otlpNumberPoint{{TimeUnixNano: b.now, AsDouble: &v}}},
+	})
+}
+
+func (b *otlpBuilder) sumInt(name, desc string, v uint64) {
+	b.sumPoints(name, desc, b.intPoint(v))
+}
+
+func (b *otlpBuilder) sumPoints(name, desc string, points ...otlpNumberPoint) {
+	b.metrics = append(b.metrics, otlpMetric{
+		Name:        name,
+		Description: desc,
+		Sum: &otlpSum{
+			DataPoints:             points,
+			AggregationTemporality: aggregationTemporalityCumulative,
+			IsMonotonic:            true,
+		},
+	})
+}
+
+// histogramPoint converts the store's cumulative bucket counts into the
+// per-bucket counts OTLP expects, with a trailing overflow bucket.
+func (b *otlpBuilder) histogramPoint(bounds []float64, cumulative []uint64, count uint64, sum float64, kv ...string) otlpHistogramPoint {
+	counts := make([]string, 0, len(bounds)+1)
+	var prev uint64
+	for _, c := range cumulative {
+		counts = append(counts, strconv.FormatUint(c-prev, 10))
+		prev = c
+	}
+	counts = append(counts, strconv.FormatUint(count-prev, 10))
+	return otlpHistogramPoint{
+		Attributes:        attrs(kv),
+		StartTimeUnixNano: b.start,
+		TimeUnixNano:      b.now,
+		Count:             strconv.FormatUint(count, 10),
+		Sum:               sum,
+		BucketCounts:      counts,
+		ExplicitBounds:    bounds,
+	}
+}
+
+// registered appends a family from the Store registry. Histogram units are
+// unknown, so none is set.
+func (b *otlpBuilder) registered(f RegisteredFamily) {
+	if len(f.Series) == 0 {
+		return
+	}
+	switch f.Kind {
+	case "counter":
+		points := make([]otlpNumberPoint, 0, len(f.Series))
+		for _, s := range f.Series {
+			points = append(points, b.intPoint(uint64(s.Value), s.Labels...))
+		}
+		b.sumPoints(f.Name, f.Help, points...)
+	case "gauge":
+		points := make([]otlpNumberPoint, 0, len(f.Series))
+		for _, s := range f.Series {
+			v := s.Value
+			points = append(points, otlpNumberPoint{Attributes: attrs(s.Labels), TimeUnixNano: b.now, AsDouble: &v})
+		}
+		b.metrics = append(b.metrics, otlpMetric{Name: f.Name, Description: f.Help, Gauge: &otlpGauge{DataPoints: points}})
+	case "histogram":
+		points := make([]otlpHistogramPoint, 0, len(f.Series))
+		for _, s := range f.Series {
+			points = append(points, b.histogramPoint(f.Bounds, s.Buckets, s.Count, s.Sum, s.Labels...))
+		}
+		b.metrics = append(b.metrics, otlpMetric{
+			Name:        f.Name,
+			Description: f.Help,
+			Histogram:   &otlpHistogram{DataPoints: points, AggregationTemporality: aggregationTemporalityCumulative},
+		})
+	}
+}
+
+func (b *otlpBuilder) histogram(name, desc string, points []otlpHistogramPoint) {
+	b.metrics = append(b.metrics, otlpMetric{
+		Name:        name,
+		Description: desc,
+		Unit:        "s",
+		Histogram: &otlpHistogram{
+			DataPoints:             points,
+			AggregationTemporality: aggregationTemporalityCumulative,
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOTLPExporterPostsJSONMetrics(t *testing.T) {
+	store := NewStore()
+	store.QueueRecorder().ObserveQueueDepth(3)
+	rec := store.ProbeRecorder()
+	rec.ObserveProbe("mon-a", "icmp", true, 3*time.Millisecond)
+	rec.ObserveProbe("mon-a", "icmp", true, 40*time.Millisecond)
+	rec.ObserveProbe("mon-a", "icmp", false, 0)
+
+	var got otlpRequest
+	var gotPath, gotAuth, gotType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotType = r.Header.Get("Content-Type")
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("decode body: %v", err)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	exp, err := NewOTLPExporter(store, OTLPOptions{
+		Endpoint: srv.URL,
+		Headers:  map[string]string{"Authorization": "Bearer token"},
+		Resource: map[string]string{"service.instance.id": "agent-1"},
+	})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter: %v", err)
+	}
+	if err := exp.Export(context.Background()); err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+
+	if gotPath != "/v1/metrics" || gotAuth != "Bearer token" || gotType != "application/json" {
+		t.Fatalf("unexpected request path=%q auth=%q type=%q", gotPath, gotAuth, gotType)
+	}
+	if len(got.ResourceMetrics) != 1 {
+		t.Fatalf("expected one resourceMetrics, got %d", len(got.ResourceMetrics))
+	}
+	rm := got.ResourceMetrics[0]
+	attrs := map[string]string{}
+	for _, a := range rm.Resource.Attributes {
+		attrs[a.Key] = a.Value.StringValue
+	}
+	if attrs["service.name"] != "pingsanto-agent" || attrs["service.instance.id"] != "agent-1" {
+		t.Fatalf("unexpected resource attributes %v", attrs)
+	}
+
+	metrics := map[string]otlpMetric{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+	depth := metrics["pingsanto_agent_queue_depth_number"]
+	if depth.Gauge == nil || *depth.Gauge.DataPoints[0].AsInt != "3" {
+		t.Fatalf("unexpected queue depth metric %+v", depth)
+	}
+	results := metrics["pingsanto_agent_probe_results_total"]
+	if results.Sum == nil || !results.Sum.IsMonotonic || len(results.Sum.DataPoints) != 2 {
+		t.Fatalf("unexpected probe results metric %+v", results)
+	}
+	rtt := metrics["pingsanto_agent_probe_rtt_seconds"]
+	if rtt.Histogram == nil || len(rtt.Histogram.DataPoints) != 1 {
+		t.Fatalf("unexpected rtt metric %+v", rtt)
+	}
+	point := rtt.Histogram.DataPoints[0]
+	if point.Count != "2" || len(point.BucketCounts) != len(ProbeLatencyBuckets)+1 {
+		t.Fatalf("unexpected rtt point %+v", point)
+	}
+	// 3ms lands in the 5ms bucket, 40ms in the 50ms bucket; counts are per-bucket.
+	if point.BucketCounts[1] != "1" || point.BucketCounts[4] != "1" || point.BucketCounts[5] != "0" {
+		t.Fatalf("unexpected bucket counts %v", point.BucketCounts)
+	}
+}
+
+func TestOTLPExporterRejectsInvalidEndpoint(t *testing.T) {
+	if _, err := NewOTLPExporter(NewStore(), OTLPOptions{Endpoint: "collector:4318"}); err == nil {
+		t.Fatalf("expected error for endpoint without scheme")
+	}
+}
+
+func TestOTLPExporterReportsCollectorErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	exp, err := NewOTLPExporter(NewStore(), OTLPOptions{Endpoint: srv.URL + "/otlp/v1/metrics"})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter: %v", err)
+	}
+	if err := exp.Export(context.Background()); err == nil {
+		t.Fatalf("expected error for 401 response")
+	}
+}
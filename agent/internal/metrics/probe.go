@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProbeLatencyBuckets are the histogram upper bounds, in seconds, for probe round-trip time.
+var ProbeLatencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// ProbeRecorder records individual probe outcomes per monitor.
+type ProbeRecorder interface {
+	ObserveProbe(monitorID, protocol string, success bool, rtt time.Duration)
+}
+
+type NoopProbeRecorder struct{}
+
+func (NoopProbeRecorder) ObserveProbe(string, string, bool, time.Duration) {}
+
+// MonitorProbeStats is a point-in-time copy of the counters for one monitor.
+type MonitorProbeStats struct {
+	MonitorID      string
+	Protocol       string
+	Successes      uint64
+	Failures       uint64
+	LatencyBuckets []uint64 // cumulative counts aligned with ProbeLatencyBuckets
+	LatencyCount   uint64
+	LatencySum     float64
+}
+
+type probeStats struct {
+	mu             sync.Mutex
+	protocol       string
+	successes      uint64
+	failures       uint64
+	latencyBuckets []uint64
+	latencyCount   uint64
+	latencySum     float64
+}
+
+// ProbeRecorder returns an implementation of ProbeRecorder backed by the store.
+func (s *Store) ProbeRecorder() ProbeRecorder {
+	return probeRecorder{store: s}
+}
+
+type probeRecorder struct {
+	store *Store
+}
+
+// ObserveProbe counts the outcome and, for successful probes, adds the RTT to
+// the monitor's latency histogram.
+func (r probeRecorder) ObserveProbe(monitorID, protocol string, success bool, rtt time.Duration) {
+	stats := r.store.getProbeStats(monitorID)
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	if protocol != "" {
+		stats.protocol = protocol
+	}
+	if !success {
+		stats.failures++
+		return
+	}
+	stats.successes++
+	seconds := rtt.Seconds()
+	for i, bound := range ProbeLatencyBuckets {
+		if seconds <= bound {
+			stats.latencyBuckets[i]++
+		}
+	}
+	stats.latencyCount++
+	stats.latencySum += seconds
+}
+
+// ForgetMonitors drops per-monitor series for monitors not in active, so
+// unassigned monitors stop being exported.
+func (s *Store) ForgetMonitors(active []string) {
+	keep := make(map[string]struct{}, len(active))
+	for _, id := range active {
+		keep[id] = struct{}{}
+	}
+	s.probeMonitors.Range(func(key, _ any) bool {
+		if id, _ := key.(string); id != "" {
+			if _, ok := keep[id]; !ok {
+				s.probeMonitors.Delete(key)
+			}
+		}
+		return true
+	})
+}
+
+func (s *Store) getProbeStats(monitorID string) *probeStats {
+	if monitorID == "" {
+		monitorID = "unknown"
+	}
+	if value, ok := s.probeMonitors.Load(monitorID); ok {
+		return value.(*probeStats)
+	}
+	stats := &probeStats{latencyBuckets: make([]uint64, len(ProbeLatencyBuckets))}
+	actual, _ := s.probeMonitors.LoadOrStore(monitorID, stats)
+	return actual.(*probeStats)
+}
+
+func (s *Store) probeSnapshot() []MonitorProbeStats {
+	var out []MonitorProbeStats
+	s.probeMonitors.Range(func(key, value any) bool {
+		monitorID, _ := key.(string)
+		stats, ok := value.(*probeStats)
+		if !ok {
+			return true
+		}
+		stats.mu.Lock()
+		out = append(out, MonitorProbeStats{
+			MonitorID:      monitorID,
+			Protocol:       stats.protocol,
+			Successes:      stats.successes,
+			Failures:       stats.failures,
+			LatencyBuckets: append([]uint64(nil), stats.latencyBuckets...),
+			LatencyCount:   stats.latencyCount,
+			LatencySum:     stats.latencySum,
+		})
+		stats.mu.Unlock()
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].MonitorID < out[j].MonitorID })
+	return out
+}
+
+func probePrometheusLines(monitors []MonitorProbeStats) []string {
+	lines := []string{
+		"# HELP pingsanto_agent_probe_results_total Probe results by monitor and outcome.",
+		"# TYPE pingsanto_agent_probe_results_total counter",
+	}
+	for _, m := range monitors {
+		lines = append(lines,
+			fmt.Sprintf("pingsanto_agent_probe_results_total{monitor_id=%q,protocol=%q,result=%q} %d", m.MonitorID, m.Protocol, "success", m.Successes),
+			fmt.Sprintf("pingsanto_agent_probe_results_total{monitor_id=%q,protocol=%q,result=%q} %d", m.MonitorID, m.Protocol, "failure", m.Failures),
+		)
+	}
+	lines = append(lines,
+		"# HELP pingsanto_agent_probe_rtt_seconds Round-trip time of successful probes by monitor.",
+		"# TYPE pingsanto_agent_probe_rtt_seconds histogram",
+	)
+	for _, m := range monitors {
+		for i, bound := range ProbeLatencyBuckets {
+			lines = append(lines, fmt.Sprintf("pingsanto_agent_probe_rtt_seconds_bucket{monitor_id=%q,protocol=%q,le=%q} %d", m.MonitorID, m.Protocol, strconv.FormatFloat(bound, 'g', -1, 64), m.LatencyBuckets[i]))
+		}
+		lines = append(lines,
+			fmt.Sprintf("pingsanto_agent_probe_rtt_seconds_bucket{monitor_id=%q,protocol=%q,le=\"+Inf\"} %d", m.MonitorID, m.Protocol, m.LatencyCount),
+			fmt.Sprintf("pingsanto_agent_probe_rtt_seconds_sum{monitor_id=%q,protocol=%q} %g", m.MonitorID, m.Protocol, m.LatencySum),
+			fmt.Sprintf("pingsanto_agent_probe_rtt_seconds_count{monitor_id=%q,protocol=%q} %d", m.MonitorID, m.Protocol, m.LatencyCount),
+		)
+	}
+	return lines
+}
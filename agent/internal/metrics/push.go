@@ -0,0 +1,369 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	PushModePushgateway = "pushgateway"
+	PushModeRemoteWrite = "remote_write"
+
+	defaultPushInterval = 30 * time.Second
+	defaultPushTimeout  = 10 * time.Second
+	defaultPushJob      = "pingsanto-agent"
+)
+
+// PushOptions configures periodic pushing of the metrics snapshot for agents
+// that cannot be scraped, e.g. behind NAT.
+type PushOptions struct {
+	// Mode is "pushgateway" (text exposition PUT to a Prometheus Pushgateway)
+	// or "remote_write" (Prometheus remote write protocol 1.0).
+	Mode string
+	// URL is the Pushgateway base URL or the full remote write endpoint.
+	URL string
+	// Job and Instance become the grouping key on the Pushgateway and the
+	// job/instance labels on remote-written series.
+	Job      string
+	Instance string
+	Headers  map[string]string
+	// Username and Password enable HTTP basic auth when Username is set.
+	Username string
+	Password string
+	Interval time.Duration
+	Timeout  time.Duration
+	// HTTPClient carries the agent's proxy settings; Timeout applies when
+	// the client sets none.
+	HTTPClient *http.Client
+	Logger     *log.Logger
+}
+
+// PushExporter periodically writes the store's Prometheus exposition to a
+// Pushgateway or remote write receiver.
+type PushExporter struct {
+	store    *Store
+	mode     string
+	url      string
+	job      string
+	instance string
+	headers  map[string]string
+	username string
+	password string
+	interval time.Duration
+	client   *http.Client
+	logger   *log.Logger
+	now      func() time.Time
+}
+
+// NewPushExporter validates opts and returns an exporter bound to store.
+func NewPushExporter(store *Store, opts PushOptions) (*PushExporter, error) {
+	if store == nil {
+		return nil, fmt.Errorf("metrics store is required")
+	}
+	mode := strings.ToLower(strings.TrimSpace(opts.Mode))
+	if mode == "" {
+		mode = PushModePushgateway
+	}
+	if mode != PushModePushgateway && mode != PushModeRemoteWrite {
+		return nil, fmt.Errorf("unsupported metrics push mode %q", opts.Mode)
+	}
+	endpoint, err := url.Parse(strings.TrimSpace(opts.URL))
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid metrics push URL %q", opts.URL)
+	}
+	job := strings.TrimSpace(opts.Job)
+	if job == "" {
+		job = defaultPushJob
+	}
+	if mode == PushModePushgateway {
+		// Grouping key values are escaped so slashes in IDs stay in one segment.
+		rawPath := strings.TrimSuffix(endpoint.EscapedPath(), "/") + "/metrics/job/" + url.PathEscape(job)
+		if opts.Instance != "" {
+			rawPath += "/instance/" + url.PathEscape(opts.Instance)
+		}
+		if endpoint.Path, err = url.PathUnescape(rawPath); err != nil {
+			return nil, fmt.Errorf("invalid metrics push URL %q", opts.URL)
+		}
+		endpoint.RawPath = rawPath
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultPushInterval
+	}
+	client := opts.HTTPClient
+	if client == nil || client.Timeout <= 0 {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = defaultPushTimeout
+		}
+		withTimeout := http.Client{Timeout: timeout}
+		if client != nil {
+			withTimeout = *client
+			withTimeout.Timeout = timeout
+		}
+		client = &withTimeout
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	return &PushExporter{
+		store:    store,
+		mode:     mode,
+		url:      endpoint.String(),
+		job:      job,
+		instance: opts.Instance,
+		headers:  opts.Headers,
+		username: opts.Username,
+		password: opts.Password,
+		interval: interval,
+		client:   client,
+		logger:   logger,
+		now:      time.Now,
+	}, nil
+}
+
+// Run pushes on every interval until ctx is cancelled. Push failures are
+// logged and retried on the next tick.
+func (e *PushExporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.Push(ctx); err != nil {
+				e.logger.Printf("metrics %s push failed: %v", e.mode, err)
+			}
+		}
+	}
+}
+
+// Push sends one snapshot.
+func (e *PushExporter) Push(ctx context.Context) error {
+	var exposition bytes.Buffer
+	if err := e.store.WritePrometheus(&exposition); err != nil {
+		return fmt.Errorf("render metrics: %w", err)
+	}
+
+	method := http.MethodPut
+	var body []byte
+	headers := map[string]string{}
+	switch e.mode {
+	case PushModeRemoteWrite:
+		series, err := parseExposition(exposition.Bytes())
+		if err != nil {
+			return err
+		}
+		extra := map[string]string{"job": e.job}
+		if e.instance != "" {
+			extra["instance"] = e.instance
+		}
+		method = http.MethodPost
+		body = snappyEncode(encodeWriteRequest(series, extra, e.now().UnixMilli()))
+		headers["Content-Type"] = "application/x-protobuf"
+		headers["Content-Encoding"] = "snappy"
+		headers["X-Prometheus-Remote-Write-Version"] = "0.1.0"
+	default:
+		body = exposition.Bytes()
+		headers["Content-Type"] = "text/plain; version=0.0.4"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("build push request: %w", err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+	if e.username != "" {
+		req.SetBasicAuth(e.username, e.password)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("send push request: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("push endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// expositionSample is one parsed line of the Prometheus text format. Labels
+// holds name/value pairs in the order they appeared.
+type expositionSample struct {
+	name   string
+	labels []string
+	value  float64
+}
+
+// parseExposition reads the subset of the text format WritePrometheus
+// produces: comment lines and `name{k="v",...} value` samples.
+func parseExposition(data []byte) ([]expositionSample, error) {
+	var out []expositionSample
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sample, err := parseExpositionLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("parse metric line %q: %w", line, err)
+		}
+		out = append(out, sample)
+	}
+	return out, scanner.Err()
+}
+
+func parseExpositionLine(line string) (expositionSample, error) {
+	var s expositionSample
+	end := strings.IndexAny(line, "{ ")
+	if end <= 0 {
+		return s, fmt.Errorf("missing value")
+	}
+	s.name = line[:end]
+	rest := line[end:]
+	if rest[0] == '{' {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimLeft(rest, " ,")
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			eq := strings.Index(rest, "=\"")
+			if eq <= 0 {
+				return s, fmt.Errorf("malformed labels")
+			}
+			key := rest[:eq]
+			rest = rest[eq+2:]
+			var value strings.Builder
+			closed := false
+			for i := 0; i < len(rest); i++ {
+				c := rest[i]
+				if c == '\\' && i+1 < len(rest) {
+					i++
+					switch rest[i] {
+					case 'n':
+						value.WriteByte('\n')
+					default:
+						value.WriteByte(rest[i])
+					}
+					continue
+				}
+				if c == '"' {
+					rest = rest[i+1:]
+					closed = true
+					break
+				}
+				value.WriteByte(c)
+			}
+			if !closed {
+				return s, fmt.Errorf("unterminated label value")
+			}
+			s.labels = append(s.labels, key, value.String())
+		}
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value")
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, err
+	}
+	s.value = value
+	return s, nil
+}
+
+// encodeWriteRequest builds a prometheus.WriteRequest protobuf message:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(samples []expositionSample, extra map[string]string, timestampMillis int64) []byte {
+	var req []byte
+	for _, sample := range samples {
+		labels := map[string]string{"__name__": sample.name}
+		for k, v := range extra {
+			labels[k] = v
+		}
+		for i := 0; i+1 < len(sample.labels); i += 2 {
+			labels[sample.labels[i]] = sample.labels[i+1]
+		}
+		names := make([]string, 0, len(labels))
+		for k := range labels {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+
+		var series []byte
+		for _, name := range names {
+			var label []byte
+			label = appendProtoBytes(label, 1, []byte(name))
+			label = appendProtoBytes(label, 2, []byte(labels[name]))
+			series = appendProtoBytes(series, 1, label)
+		}
+		var point []byte
+		point = binary.AppendUvarint(point, 1<<3|1)
+		point = binary.LittleEndian.AppendUint64(point, math.Float64bits(sample.value))
+		point = binary.AppendUvarint(point, 2<<3|0)
+		point = binary.AppendUvarint(point, uint64(timestampMillis))
+		series = appendProtoBytes(series, 2, point)
+
+		req = appendProtoBytes(req, 1, series)
+	}
+	return req
+}
+
+func appendProtoBytes(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// snappyEncode produces a valid snappy block made only of literal chunks.
+// Remote write mandates snappy framing but not compression, and metric
+// payloads are small enough that skipping the match search is fine.
+func snappyEncode(src []byte) []byte {
+	const maxChunk = 1 << 16
+	dst := binary.AppendUvarint(nil, uint64(len(src)))
+	for len(src) > 0 {
+		n := len(src)
+		if n > maxChunk {
+			n = maxChunk
+		}
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 1<<8:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPushExporterPushgateway(t *testing.T) {
+	store := NewStore()
+	store.QueueRecorder().ObserveQueueDepth(7)
+
+	var gotMethod, gotPath, gotUser string
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotMethod = r.Method
+		gotPath = r.URL.EscapedPath()
+		gotUser, _, _ = r.BasicAuth()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	exp, err := NewPushExporter(store, PushOptions{
+		URL:      srv.URL + "/",
+		Instance: "agent/1",
+		Username: "push",
+		Password: "pw",
+	})
+	if err != nil {
+		t.Fatalf("NewPushExporter: %v", err)
+	}
+	if err := exp.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotMethod != http.MethodPut || gotPath != "/metrics/job/pingsanto-agent/instance/agent%2F1" || gotUser != "push" {
+		t.Fatalf("unexpected request method=%s path=%s user=%q", gotMethod, gotPath, gotUser)
+	}
+	if !strings.Contains(string(body), "pingsanto_agent_queue_depth_number 7") {
+		t.Fatalf("expected exposition body, got %s", body)
+	}
+}
+
+func TestPushExporterRemoteWrite(t *testing.T) {
+	store := NewStore()
+	store.QueueRecorder().ObserveQueueDepth(7)
+
+	var headers http.Header
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	exp, err := NewPushExporter(store, PushOptions{
+		Mode:     PushModeRemoteWrite,
+		URL:      srv.URL + "/api/v1/write",
+		Instance: "agent-1",
+	})
+	if err != nil {
+		t.Fatalf("NewPushExporter: %v", err)
+	}
+	exp.now = func() time.Time { return time.UnixMilli(1700000000000) }
+	if err := exp.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if headers.Get("Content-Encoding") != "snappy" || headers.Get("Content-Type") != "application/x-protobuf" {
+		t.Fatalf("unexpected headers %v", headers)
+	}
+
+	raw := decodeLiteralSnappy(t, body)
+	for _, want := range []string{"__name__", "pingsanto_agent_queue_depth_number", "job", "pingsanto-agent", "instance", "agent-1"} {
+		if !bytes.Contains(raw, []byte(want)) {
+			t.Fatalf("expected %q in write request", want)
+		}
+	}
+}
+
+func TestParseExpositionLine(t *testing.T) {
+	s, err := parseExpositionLine(`pingsanto_agent_probe_latency_seconds_bucket{monitor_id="m\"1",le="+Inf"} 3`)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if s.name != "pingsanto_agent_probe_latency_seconds_bucket" || s.value != 3 {
+		t.Fatalf("unexpected sample %+v", s)
+	}
+	if len(s.labels) != 4 || s.labels[1] != `m"1` || s.labels[3] != "+Inf" {
+		t.Fatalf("unexpected labels %q", s.labels)
+	}
+	if _, err := parseExpositionLine(`bad{x="y} 1`); err == nil {
+		t.Fatalf("expected error for unterminated label")
+	}
+}
+
+func decodeLiteralSnappy(t *testing.T, src []byte) []byte {
+	t.Helper()
+	size, n := binary.Uvarint(src)
+	src = src[n:]
+	var out []byte
+	for len(src) > 0 {
+		tag := src[0]
+		if tag&3 != 0 {
+			t.Fatalf("unexpected non-literal snappy element")
+		}
+		length := int(tag>>2) + 1
+		src = src[1:]
+		switch tag >> 2 {
+		case 60:
+			length = int(src[0]) + 1
+			src = src[1:]
+		case 61:
+			length = (int(src[0]) | int(src[1])<<8) + 1
+			src = src[2:]
+		}
+		out = append(out, src[:length]...)
+		src = src[length:]
+	}
+	if uint64(len(out)) != size {
+		t.Fatalf("snappy length mismatch: header %d, decoded %d", size, len(out))
+	}
+	return out
+}
@@ -1,5 +1,7 @@
 package metrics
 
+import "time"
+
 type QueueRecorder interface {
 	ObserveQueueDepth(depth int)
 	IncQueueDrops()
@@ -14,8 +16,19 @@ func (NoopQueueRecorder) IncQueueSpills()             {}
 
 type BackfillRecorder interface {
 	ObservePendingBytes(bytes int64)
+	// ObserveReplayedBatch counts a batch of spilled results delivered upstream.
+	ObserveReplayedBatch(results int)
+	// ObserveReplayLag records the age of the oldest pending spilled result;
+	// zero once the spill store is drained.
+	ObserveReplayLag(lag time.Duration)
+	// ObserveReplayThroughput records replayed results per second over the
+	// most recent measurement window.
+	ObserveReplayThroughput(perSecond float64)
 }
 
 type NoopBackfillRecorder struct{}
 
-func (NoopBackfillRecorder) ObservePendingBytes(bytes int64) {}
+func (NoopBackfillRecorder) ObservePendingBytes(bytes int64)           {}
+func (NoopBackfillRecorder) ObserveReplayedBatch(results int)          {}
+func (NoopBackfillRecorder) ObserveReplayLag(lag time.Duration)        {}
+func (NoopBackfillRecorder) ObserveReplayThroughput(perSecond float64) {}
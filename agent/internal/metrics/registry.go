@@ -0,0 +1,355 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Subsystems register their own metrics on the Store instead of adding
+// fields to it. Registered families are rendered by WritePrometheus and
+// forwarded by the OTLP, StatsD and push exporters.
+//
+//	batches := store.CounterVec("pingsanto_agent_transmit_batches_total", "Batches uploaded by outcome.", "result")
+//	batches.With("success").Inc()
+//
+// Registering an existing name returns the existing family when the kind,
+// labels and buckets match, so packages may register lazily. A conflicting
+// registration or a malformed name panics, as it is a programming error.
+
+type metricKind int
+
+const (
+	kindCounter metricKind = iota
+	kindGauge
+	kindHistogram
+)
+
+func (k metricKind) String() string {
+	switch k {
+	case kindCounter:
+		return "counter"
+	case kindGauge:
+		return "gauge"
+	default:
+		return "histogram"
+	}
+}
+
+// Counter is a monotonically increasing count.
+type Counter struct {
+	v atomic.Uint64
+}
+
+func (c *Counter) Inc()          { c.v.Add(1) }
+func (c *Counter) Add(n uint64)  { c.v.Add(n) }
+func (c *Counter) Value() uint64 { return c.v.Load() }
+
+// Gauge is a value that can go up and down.
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) { g.bits.Store(math.Float64bits(v)) }
+
+// SetTime sets the gauge to t as Unix seconds.
+func (g *Gauge) SetTime(t time.Time) { g.Set(float64(t.UnixNano()) / 1e9) }
+
+func (g *Gauge) Add(delta float64) {
+	for {
+		old := g.bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + delta)
+		if g.bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// Histogram counts observations into fixed buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64 // cumulative counts aligned with bounds
+	count   uint64
+	sum     float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveDuration records d in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+// CounterVec partitions a counter by label values.
+type CounterVec struct{ f *family }
+
+// With returns the counter for the given label values, in registration order.
+func (v *CounterVec) With(values ...string) *Counter { return v.f.series(values).counter }
+
+// GaugeVec partitions a gauge by label values.
+type GaugeVec struct{ f *family }
+
+func (v *GaugeVec) With(values ...string) *Gauge { return v.f.series(values).gauge }
+
+// Reset drops every series, e.g. before re-publishing an info-style gauge.
+func (v *GaugeVec) Reset() { v.f.reset() }
+
+// HistogramVec partitions a histogram by label values.
+type HistogramVec struct{ f *family }
+
+func (v *HistogramVec) With(values ...string) *Histogram { return v.f.series(values).histogram }
+
+// Counter registers (or returns) an unlabelled counter.
+func (s *Store) Counter(name, help string) *Counter {
+	return s.CounterVec(name, help).With()
+}
+
+// CounterVec registers (or returns) a counter partitioned by labels.
+func (s *Store) CounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: s.register(name, help, kindCounter, labels, nil)}
+}
+
+// Gauge registers (or returns) an unlabelled gauge.
+func (s *Store) Gauge(name, help string) *Gauge {
+	return s.GaugeVec(name, help).With()
+}
+
+// GaugeVec registers (or returns) a gauge partitioned by labels.
+func (s *Store) GaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: s.register(name, help, kindGauge, labels, nil)}
+}
+
+// Histogram registers (or returns) an unlabelled histogram with the given
+// ascending bucket upper bounds.
+func (s *Store) Histogram(name, help string, buckets []float64) *Histogram {
+	return s.HistogramVec(name, help, buckets).With()
+}
+
+// HistogramVec registers (or returns) a histogram partitioned by labels.
+func (s *Store) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{f: s.register(name, help, kindHistogram, labels, buckets)}
+}
+
+type family struct {
+	name   string
+	help   string
+	kind   metricKind
+	labels []string
+	bounds []float64
+
+	mu      sync.Mutex
+	entries map[string]*seriesEntry
+}
+
+type seriesEntry struct {
+	values    []string
+	counter   *Counter
+	gauge     *Gauge
+	histogram *Histogram
+}
+
+func (s *Store) register(name, help string, kind metricKind, labels []string, bounds []float64) *family {
+	if !validMetricName(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !validMetricName(label) || strings.Contains(label, ":") || label == "le" {
+			panic(fmt.Sprintf("metrics: invalid label name %q for %s", label, name))
+		}
+	}
+	if kind == kindHistogram && !sort.Float64sAreSorted(bounds) {
+		panic(fmt.Sprintf("metrics: histogram %s buckets must be ascending", name))
+	}
+
+	s.registryMu.Lock()
+	defer s.registryMu.Unlock()
+	if existing, ok := s.registry[name]; ok {
+		if existing.kind != kind || !slices.Equal(existing.labels, labels) || !slices.Equal(existing.bounds, bounds) {
+			panic(fmt.Sprintf("metrics: %s already registered as a different %s", name, existing.kind))
+		}
+		return existing
+	}
+	if s.registry == nil {
+		s.registry = make(map[string]*family)
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  append([]string(nil), labels...),
+		bounds:  append([]float64(nil), bounds...),
+		entries: make(map[string]*seriesEntry),
+	}
+	s.registry[name] = f
+	return f
+}
+
+func (f *family) series(values []string) *seriesEntry {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\x00")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if e, ok := f.entries[key]; ok {
+		return e
+	}
+	e := &seriesEntry{values: append([]string(nil), values...)}
+	switch f.kind {
+	case kindCounter:
+		e.counter = &Counter{}
+	case kindGauge:
+		e.gauge = &Gauge{}
+	case kindHistogram:
+		e.histogram = &Histogram{bounds: f.bounds, buckets: make([]uint64, len(f.bounds))}
+	}
+	f.entries[key] = e
+	return e
+}
+
+func (f *family) reset() {
+	f.mu.Lock()
+	f.entries = make(map[string]*seriesEntry)
+	f.mu.Unlock()
+}
+
+// RegisteredFamily is a point-in-time copy of one registered metric.
+type RegisteredFamily struct {
+	Name   string
+	Help   string
+	Kind   string // counter, gauge or histogram
+	Bounds []float64
+	Series []RegisteredSeries
+}
+
+// RegisteredSeries is one label combination. Labels alternates names and
+// values. Value holds counter and gauge readings; histograms use Buckets
+// (cumulative, aligned with the family Bounds), Count and Sum.
+type RegisteredSeries struct {
+	Labels  []string
+	Value   float64
+	Buckets []uint64
+	Count   uint64
+	Sum     float64
+}
+
+// Registered returns every registered family sorted by name, with series
+// sorted by label values.
+func (s *Store) Registered() []RegisteredFamily {
+	s.registryMu.Lock()
+	families := make([]*family, 0, len(s.registry))
+	for _, f := range s.registry {
+		families = append(families, f)
+	}
+	s.registryMu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	out := make([]RegisteredFamily, 0, len(families))
+	for _, f := range families {
+		rf := RegisteredFamily{Name: f.name, Help: f.help, Kind: f.kind.String(), Bounds: f.bounds}
+		f.mu.Lock()
+		keys := make([]string, 0, len(f.entries))
+		for k := range f.entries {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			e := f.entries[k]
+			rs := RegisteredSeries{Labels: make([]string, 0, 2*len(f.labels))}
+			for i, label := range f.labels {
+				rs.Labels = append(rs.Labels, label, e.values[i])
+			}
+			switch f.kind {
+			case kindCounter:
+				rs.Value = float64(e.counter.Value())
+			case kindGauge:
+				rs.Value = e.gauge.Value()
+			case kindHistogram:
+				e.histogram.mu.Lock()
+				rs.Buckets = append([]uint64(nil), e.histogram.buckets...)
+				rs.Count = e.histogram.count
+				rs.Sum = e.histogram.sum
+				e.histogram.mu.Unlock()
+			}
+			rf.Series = append(rf.Series, rs)
+		}
+		f.mu.Unlock()
+		out = append(out, rf)
+	}
+	return out
+}
+
+func registeredPrometheusLines(families []RegisteredFamily) []string {
+	var lines []string
+	for _, f := range families {
+		if len(f.Series) == 0 {
+			continue
+		}
+		lines = append(lines,
+			fmt.Sprintf("# HELP %s %s", f.Name, f.Help),
+			fmt.Sprintf("# TYPE %s %s", f.Name, f.Kind),
+		)
+		for _, s := range f.Series {
+			switch f.Kind {
+			case "histogram":
+				for i, bound := range f.Bounds {
+					lines = append(lines, fmt.Sprintf("%s_bucket%s %d", f.Name, promLabels(s.Labels, "le", strconv.FormatFloat(bound, 'g', -1, 64)), s.Buckets[i]))
+				}
+				lines = append(lines,
+					fmt.Sprintf("%s_bucket%s %d", f.Name, promLabels(s.Labels, "le", "+Inf"), s.Count),
+					fmt.Sprintf("%s_sum%s %g", f.Name, promLabels(s.Labels), s.Sum),
+					fmt.Sprintf("%s_count%s %d", f.Name, promLabels(s.Labels), s.Count),
+				)
+			default:
+				lines = append(lines, fmt.Sprintf("%s%s %g", f.Name, promLabels(s.Labels), s.Value))
+			}
+		}
+	}
+	return lines
+}
+
+// promLabels renders name/value pairs as a Prometheus label set, or "" when
+// there are none.
+func promLabels(kv []string, extra ...string) string {
+	kv = append(append([]string(nil), kv...), extra...)
+	if len(kv) == 0 {
+		return ""
+	}
+	parts := make([]string, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		parts = append(parts, fmt.Sprintf("%s=%q", kv[i], kv[i+1]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func validMetricName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+		case r >= '0' && r <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisteredMetricsRenderInPrometheus(t *testing.T) {
+	store := NewStore()
+	batches := store.CounterVec("pingsanto_agent_test_batches_total", "Batches by result.", "result")
+	batches.With("success").Add(3)
+	batches.With("failure").Inc()
+	store.Gauge("pingsanto_agent_test_inflight", "In-flight items.").Set(2.5)
+	h := store.Histogram("pingsanto_agent_test_duration_seconds", "Durations.", []float64{0.1, 1})
+	h.ObserveDuration(50 * time.Millisecond)
+	h.Observe(5)
+
+	// Re-registering with the same shape returns the same series.
+	store.CounterVec("pingsanto_agent_test_batches_total", "Batches by result.", "result").With("success").Inc()
+
+	var buf bytes.Buffer
+	if err := store.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE pingsanto_agent_test_batches_total counter",
+		`pingsanto_agent_test_batches_total{result="failure"} 1`,
+		`pingsanto_agent_test_batches_total{result="success"} 4`,
+		"# TYPE pingsanto_agent_test_inflight gauge",
+		"pingsanto_agent_test_inflight 2.5",
+		`pingsanto_agent_test_duration_seconds_bucket{le="0.1"} 1`,
+		`pingsanto_agent_test_duration_seconds_bucket{le="1"} 1`,
+		`pingsanto_agent_test_duration_seconds_bucket{le="+Inf"} 2`,
+		"pingsanto_agent_test_duration_seconds_sum 5.05",
+		"pingsanto_agent_test_duration_seconds_count 2",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestRegisterConflictPanics(t *testing.T) {
+	store := NewStore()
+	store.Counter("pingsanto_agent_test_total", "help")
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic on conflicting registration")
+		}
+	}()
+	store.Gauge("pingsanto_agent_test_total", "help")
+}
+
+func TestRegisteredMetricsReachExporters(t *testing.T) {
+	store := NewStore()
+	store.CounterVec("pingsanto_agent_test_batches_total", "Batches by result.", "result").With("success").Add(2)
+
+	exp, err := NewStatsDExporter(store, StatsDOptions{Address: "127.0.0.1:8125"})
+	if err != nil {
+		t.Fatalf("NewStatsDExporter: %v", err)
+	}
+	lines := strings.Join(exp.linesLocked(), "\n")
+	if !strings.Contains(lines, "pingsanto.agent.test_batches:2|c|#result:success") {
+		t.Fatalf("expected registered counter in statsd lines:\n%s", lines)
+	}
+
+	otlp, err := NewOTLPExporter(store, OTLPOptions{Endpoint: "http://127.0.0.1:4318"})
+	if err != nil {
+		t.Fatalf("NewOTLPExporter: %v", err)
+	}
+	found := false
+	for _, m := range otlp.buildRequest(time.Now()).ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		if m.Name == "pingsanto_agent_test_batches_total" && m.Sum != nil && *m.Sum.DataPoints[0].AsInt == "2" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected registered counter in otlp request")
+	}
+}
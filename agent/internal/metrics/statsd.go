@@ -0,0 +1,303 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsD flavours.
+const (
+	StatsDFlavorDogStatsD = "dogstatsd"
+	StatsDFlavorPlain     = "statsd"
+)
+
+const (
+	defaultStatsDInterval = 10 * time.Second
+	defaultStatsDPrefix   = "pingsanto.agent"
+	// maxStatsDPacket keeps datagrams under a typical 1500-byte MTU.
+	maxStatsDPacket = 1432
+)
+
+// StatsDOptions configures periodic StatsD export of the store.
+type StatsDOptions struct {
+	// Address is host:port for UDP or unix:///path/to/socket for a unix
+	// datagram socket.
+	Address string
+	// Flavor is "dogstatsd" (default, labels sent as tags) or "statsd"
+	// (labels folded into the metric name).
+	Flavor   string
+	Prefix   string
+	Tags     map[string]string
+	Interval time.Duration
+	Logger   *log.Logger
+}
+
+// StatsDExporter pushes the store's metrics to a local StatsD or DogStatsD
+// daemon. Gauges are sent as-is; monotonic counters are sent as the increase
+// since the previous flush; histograms are reduced to count and sum counters.
+type StatsDExporter struct {
+	store    *Store
+	network  string
+	address  string
+	flavor   string
+	prefix   string
+	tags     []string
+	interval time.Duration
+	logger   *log.Logger
+
+	mu   sync.Mutex
+	conn net.Conn
+	last map[string]uint64
+}
+
+// NewStatsDExporter validates opts and returns an exporter bound to store.
+func NewStatsDExporter(store *Store, opts StatsDOptions) (*StatsDExporter, error) {
+	if store == nil {
+		return nil, fmt.Errorf("metrics store is required")
+	}
+	address := strings.TrimSpace(opts.Address)
+	network := "udp"
+	if path, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, address = "unixgram", path
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		return nil, fmt.Errorf("invalid statsd address %q: %w", opts.Address, err)
+	}
+	if address == "" {
+		return nil, fmt.Errorf("statsd address is required")
+	}
+	flavor := strings.ToLower(strings.TrimSpace(opts.Flavor))
+	switch flavor {
+	case "":
+		flavor = StatsDFlavorDogStatsD
+	case StatsDFlavorDogStatsD, StatsDFlavorPlain:
+	default:
+		return nil, fmt.Errorf("unsupported statsd flavor %q", opts.Flavor)
+	}
+	prefix := strings.Trim(strings.TrimSpace(opts.Prefix), ".")
+	if opts.Prefix == "" {
+		prefix = defaultStatsDPrefix
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = defaultStatsDInterval
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	tags := make([]string, 0, len(opts.Tags))
+	for k, v := range opts.Tags {
+		tags = append(tags, statsdTag(k, v))
+	}
+	sort.Strings(tags)
+	return &StatsDExporter{
+		store:    store,
+		network:  network,
+		address:  address,
+		flavor:   flavor,
+		prefix:   prefix,
+		tags:     tags,
+		interval: interval,
+		logger:   logger,
+		last:     make(map[string]uint64),
+	}, nil
+}
+
+// Run flushes on every interval until ctx is cancelled. Send failures are
+// logged and the socket is re-dialled on the next flush.
+func (e *StatsDExporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	defer e.close()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := e.Flush(); err != nil {
+				e.logger.Printf("statsd flush failed: %v", err)
+			}
+		}
+	}
+}
+
+// Flush sends one snapshot to the daemon.
+func (e *StatsDExporter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn == nil {
+		conn, err := net.Dial(e.network, e.address)
+		if err != nil {
+			return fmt.Errorf("dial statsd: %w", err)
+		}
+		e.conn = conn
+	}
+	for _, packet := range packStatsDLines(e.linesLocked()) {
+		if _, err := e.conn.Write(packet); err != nil {
+			e.conn.Close()
+			e.conn = nil
+			return fmt.Errorf("write statsd: %w", err)
+		}
+	}
+	return nil
+}
+
+func (e *StatsDExporter) close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.conn != nil {
+		e.conn.Close()
+		e.conn = nil
+	}
+}
+
+func (e *StatsDExporter) linesLocked() []string {
+	snap := e.store.Snapshot()
+	var lines []string
+	gauge := func(name string, value string, kv ...string) {
+		lines = append(lines, e.line(name, value, "g", kv))
+	}
+	counter := func(name string, value uint64, kv ...string) {
+		key := name + "\x00" + strings.Join(kv, "\x00")
+		delta := value
+		if prev, ok := e.last[key]; ok && value >= prev {
+			delta = value - prev
+		}
+		e.last[key] = value
+		if delta > 0 {
+			lines = append(lines, e.line(name, strconv.FormatUint(delta, 10), "c", kv))
+		}
+	}
+
+	gauge("queue.depth", strconv.FormatInt(snap.QueueDepth, 10))
+	counter("queue.dropped", snap.QueueDroppedTotal)
+	counter("queue.spilled", snap.QueueSpilledTotal)
+	ready := "0"
+	if snap.Ready {
+		ready = "1"
+	}
+	gauge("ready", ready)
+	counter("ready.transitions", snap.ReadyTransitions, "state", "ready")
+	counter("ready.transitions", snap.NotReadyTransitions, "state", "not_ready")
+	counter("ready.alerts", snap.ReadyAlerts)
+	if snap.ClockSkewKnown {
+		gauge("clock_skew_seconds", strconv.FormatFloat(snap.ClockSkewSeconds, 'f', -1, 64))
+	}
+
+	for _, ep := range snap.UplinkEndpoints {
+		classes := make([]string, 0, len(ep.StatusClasses))
+		for class := range ep.StatusClasses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			counter("uplink.requests", ep.StatusClasses[class], "endpoint", ep.Endpoint, "code", class)
+		}
+		counter("uplink.errors", ep.Errors, "endpoint", ep.Endpoint)
+		counter("uplink.bytes_sent", ep.BytesSent, "endpoint", ep.Endpoint)
+		counter("uplink.duration.count", ep.LatencyCount, "endpoint", ep.Endpoint)
+		counter("uplink.duration.sum_ms", uint64(ep.LatencySum*1000), "endpoint", ep.Endpoint)
+	}
+
+	for _, m := range snap.Monitors {
+		counter("probe.results", m.Successes, "monitor_id", m.MonitorID, "protocol", m.Protocol, "result", "success")
+		counter("probe.results", m.Failures, "monitor_id", m.MonitorID, "protocol", m.Protocol, "result", "failure")
+		counter("probe.rtt.count", m.LatencyCount, "monitor_id", m.MonitorID, "protocol", m.Protocol)
+		counter("probe.rtt.sum_ms", uint64(m.LatencySum*1000), "monitor_id", m.MonitorID, "protocol", m.Protocol)
+	}
+
+	for _, f := range e.store.Registered() {
+		name := statsdRegisteredName(f.Name)
+		for _, s := range f.Series {
+			switch f.Kind {
+			case "counter":
+				counter(name, uint64(s.Value), s.Labels...)
+			case "gauge":
+				gauge(name, strconv.FormatFloat(s.Value, 'f', -1, 64), s.Labels...)
+			case "histogram":
+				counter(name+".count", s.Count, s.Labels...)
+				if strings.HasSuffix(f.Name, "_seconds") {
+					counter(name+".sum_ms", uint64(s.Sum*1000), s.Labels...)
+				} else {
+					counter(name+".sum", uint64(s.Sum), s.Labels...)
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// statsdRegisteredName maps a registered Prometheus name onto the exporter's
+// prefix: pingsanto_agent_transmit_batches_total becomes transmit_batches.
+func statsdRegisteredName(name string) string {
+	name = strings.TrimPrefix(name, "pingsanto_agent_")
+	name = strings.TrimSuffix(name, "_total")
+	return strings.TrimSuffix(name, "_seconds")
+}
+
+// line renders one metric. DogStatsD carries labels as tags; plain StatsD has
+// no tags, so label values become extra name segments.
+func (e *StatsDExporter) line(name, value, kind string, kv []string) string {
+	full := name
+	if e.prefix != "" {
+		full = e.prefix + "." + name
+	}
+	if e.flavor == StatsDFlavorPlain {
+		for i := 1; i < len(kv); i += 2 {
+			full += "." + statsdSanitize(kv[i])
+		}
+		return fmt.Sprintf("%s:%s|%s", full, value, kind)
+	}
+	tags := append([]string(nil), e.tags...)
+	for i := 0; i+1 < len(kv); i += 2 {
+		tags = append(tags, statsdTag(kv[i], kv[i+1]))
+	}
+	if len(tags) == 0 {
+		return fmt.Sprintf("%s:%s|%s", full, value, kind)
+	}
+	return fmt.Sprintf("%s:%s|%s|#%s", full, value, kind, strings.Join(tags, ","))
+}
+
+func statsdTag(key, value string) string {
+	return statsdSanitize(key) + ":" + statsdSanitize(value)
+}
+
+// statsdSanitize strips characters that delimit the StatsD line protocol.
+func statsdSanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '#', '@', '\n', ' ':
+			return '_'
+		}
+		return r
+	}, s)
+}
+
+// packStatsDLines joins lines into newline-separated datagrams no larger than
+// maxStatsDPacket.
+func packStatsDLines(lines []string) [][]byte {
+	var packets [][]byte
+	var current []byte
+	for _, line := range lines {
+		if len(current) > 0 && len(current)+1+len(line) > maxStatsDPacket {
+			packets = append(packets, current)
+			current = nil
+		}
+		if len(current) > 0 {
+			current = append(current, '\n')
+		}
+		current = append(current, line...)
+	}
+	if len(current) > 0 {
+		packets = append(packets, current)
+	}
+	return packets
+}
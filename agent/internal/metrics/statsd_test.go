@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var lines []string
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+		conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	}
+	return lines
+}
+
+func TestStatsDExporterSendsDogStatsDDeltas(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer conn.Close()
+
+	store := NewStore()
+	store.QueueRecorder().ObserveQueueDepth(7)
+	store.QueueRecorder().IncQueueDrops()
+	store.ProbeRecorder().ObserveProbe("mon-a", "icmp", true, 5*time.Millisecond)
+
+	exp, err := NewStatsDExporter(store, StatsDOptions{
+		Address: conn.LocalAddr().String(),
+		Tags:    map[string]string{"agent_id": "agent-1"},
+	})
+	if err != nil {
+		t.Fatalf("NewStatsDExporter: %v", err)
+	}
+	defer exp.close()
+
+	if err := exp.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	out := strings.Join(readStatsD(t, conn), "\n")
+	for _, want := range []string{
+		"pingsanto.agent.queue.depth:7|g|#agent_id:agent-1",
+		"pingsanto.agent.queue.dropped:1|c|#agent_id:agent-1",
+		"pingsanto.agent.probe.results:1|c|#agent_id:agent-1,monitor_id:mon-a,protocol:icmp,result:success",
+		"pingsanto.agent.probe.rtt.sum_ms:5|c|#agent_id:agent-1,monitor_id:mon-a,protocol:icmp",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in:\n%s", want, out)
+		}
+	}
+
+	store.QueueRecorder().IncQueueDrops()
+	store.QueueRecorder().IncQueueDrops()
+	if err := exp.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	out = strings.Join(readStatsD(t, conn), "\n")
+	if !strings.Contains(out, "pingsanto.agent.queue.dropped:2|c") {
+		t.Fatalf("expected counter delta of 2 in:\n%s", out)
+	}
+	if strings.Contains(out, "probe.results") {
+		t.Fatalf("unchanged counters should not be resent:\n%s", out)
+	}
+}
+
+func TestStatsDExporterPlainFlavorFoldsLabels(t *testing.T) {
+	exp, err := NewStatsDExporter(NewStore(), StatsDOptions{Address: "127.0.0.1:8125", Flavor: "statsd", Prefix: "edge"})
+	if err != nil {
+		t.Fatalf("NewStatsDExporter: %v", err)
+	}
+	got := exp.line("probe.results", "3", "c", []string{"monitor_id", "mon:a", "result", "success"})
+	if got != "edge.probe.results.mon_a.success:3|c" {
+		t.Fatalf("unexpected line %q", got)
+	}
+}
+
+func TestPackStatsDLinesRespectsPacketSize(t *testing.T) {
+	line := strings.Repeat("x", 600)
+	packets := packStatsDLines([]string{line, line, line})
+	if len(packets) != 2 {
+		t.Fatalf("expected 2 packets, got %d", len(packets))
+	}
+	for _, p := range packets {
+		if len(p) > maxStatsDPacket {
+			t.Fatalf("packet too large: %d", len(p))
+		}
+	}
+}
@@ -8,21 +8,33 @@ import (
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 // Store maintains in-memory gauges and counters for agent telemetry.
 type Store struct {
-	queueDepth           atomic.Int64
-	queueDrops           atomic.Uint64
-	queueSpills          atomic.Uint64
-	backfillPendingBytes atomic.Int64
-	readinessState       atomic.Int64
-	readinessReason      atomic.Value
-	readinessCategories  atomic.Value
-	readyTransitions     atomic.Uint64
-	notReadyTransitions  atomic.Uint64
-	readyAlerts          atomic.Uint64
-	categoryTotals       sync.Map // categoryKey -> *atomic.Uint64
+	queueDepth          atomic.Int64
+	queueDrops          atomic.Uint64
+	queueSpills         atomic.Uint64
+	readinessState      atomic.Int64
+	readinessReason     atomic.Value
+	readinessCategories atomic.Value
+	readyTransitions    atomic.Uint64
+	notReadyTransitions atomic.Uint64
+	readyAlerts         atomic.Uint64
+	categoryTotals      sync.Map // categoryKey -> *atomic.Uint64
+	uplinkEndpoints     sync.Map // endpoint -> *uplinkStats
+	probeMonitors       sync.Map // monitor ID -> *probeStats
+	clockSkewNanos      atomic.Int64
+	clockSkewKnown      atomic.Bool
+	startedAt           time.Time
+
+	registryMu sync.Mutex
+	registry   map[string]*family
+
+	// backfill is registered in NewStore so heartbeats can read it back
+	// through Snapshot.
+	backfill backfillRecorder
 }
 
 // ReadinessCategory captures a categorized readiness reason with severity.
@@ -38,9 +50,10 @@ type categoryKey struct {
 
 // NewStore constructs a Store with zeroed metrics.
 func NewStore() *Store {
-	store := &Store{}
+	store := &Store{startedAt: time.Now()}
 	store.readinessReason.Store("")
 	store.readinessCategories.Store([]ReadinessCategory(nil))
+	store.backfill = newBackfillRecorder(store)
 	return store
 }
 
@@ -50,13 +63,23 @@ type Snapshot struct {
 	QueueDroppedTotal    uint64
 	QueueSpilledTotal    uint64
 	BackfillPendingBytes int64
-	Ready                bool
-	ReadyReason          string
-	ReadyTransitions     uint64
-	NotReadyTransitions  uint64
-	ReadyAlerts          uint64
-	ReadyCategories      []ReadinessCategory
-	CategoryTransitions  []CategoryCount
+	// Backfill replay progress: totals delivered from the spill store, the
+	// age of the oldest pending result, and recent results per second.
+	BackfillReplayedBatches  uint64
+	BackfillReplayedResults  uint64
+	BackfillReplayLagSeconds float64
+	BackfillReplayThroughput float64
+	Ready                    bool
+	ReadyReason              string
+	ReadyTransitions         uint64
+	NotReadyTransitions      uint64
+	ReadyAlerts              uint64
+	ReadyCategories          []ReadinessCategory
+	CategoryTransitions      []CategoryCount
+	UplinkEndpoints          []UplinkEndpointStats
+	Monitors                 []MonitorProbeStats
+	ClockSkewKnown           bool
+	ClockSkewSeconds         float64
 }
 
 // CategoryCount captures accumulated transition counts per category/severity.
@@ -90,17 +113,25 @@ func (s *Store) Snapshot() Snapshot {
 		return true
 	})
 	return Snapshot{
-		QueueDepth:           s.queueDepth.Load(),
-		QueueDroppedTotal:    s.queueDrops.Load(),
-		QueueSpilledTotal:    s.queueSpills.Load(),
-		BackfillPendingBytes: s.backfillPendingBytes.Load(),
-		Ready:                s.readinessState.Load() == 1,
-		ReadyReason:          readyReason,
-		ReadyTransitions:     s.readyTransitions.Load(),
-		NotReadyTransitions:  s.notReadyTransitions.Load(),
-		ReadyAlerts:          s.readyAlerts.Load(),
-		ReadyCategories:      categories,
-		CategoryTransitions:  categoryCounts,
+		QueueDepth:               s.queueDepth.Load(),
+		QueueDroppedTotal:        s.queueDrops.Load(),
+		QueueSpilledTotal:        s.queueSpills.Load(),
+		BackfillPendingBytes:     int64(s.backfill.pendingBytes.Value()),
+		BackfillReplayedBatches:  s.backfill.batches.Value(),
+		BackfillReplayedResults:  s.backfill.results.Value(),
+		BackfillReplayLagSeconds: s.backfill.lag.Value(),
+		BackfillReplayThroughput: s.backfill.throughput.Value(),
+		Ready:                    s.readinessState.Load() == 1,
+		ReadyReason:              readyReason,
+		ReadyTransitions:         s.readyTransitions.Load(),
+		NotReadyTransitions:      s.notReadyTransitions.Load(),
+		ReadyAlerts:              s.readyAlerts.Load(),
+		ReadyCategories:          categories,
+		CategoryTransitions:      categoryCounts,
+		UplinkEndpoints:          s.uplinkSnapshot(),
+		Monitors:                 s.probeSnapshot(),
+		ClockSkewKnown:           s.clockSkewKnown.Load(),
+		ClockSkewSeconds:         time.Duration(s.clockSkewNanos.Load()).Seconds(),
 	}
 }
 
@@ -109,11 +140,6 @@ func (s *Store) QueueRecorder() QueueRecorder {
 	return queueRecorder{store: s}
 }
 
-// BackfillRecorder returns an implementation of BackfillRecorder backed by the store.
-func (s *Store) BackfillRecorder() BackfillRecorder {
-	return backfillRecorder{store: s}
-}
-
 type queueRecorder struct {
 	store *Store
 }
@@ -130,15 +156,10 @@ func (r queueRecorder) IncQueueSpills() {
 	r.store.queueSpills.Add(1)
 }
 
-type backfillRecorder struct {
-	store *Store
-}
-
-func (r backfillRecorder) ObservePendingBytes(bytes int64) {
-	if bytes < 0 {
-		bytes = 0
-	}
-	r.store.backfillPendingBytes.Store(bytes)
+// ObserveClockSkew records the agent clock offset from the controller (positive when ahead).
+func (s *Store) ObserveClockSkew(skew time.Duration) {
+	s.clockSkewNanos.Store(int64(skew))
+	s.clockSkewKnown.Store(true)
 }
 
 func (s *Store) ObserveReadiness(ready bool, reason string, categories []ReadinessCategory) {
@@ -261,9 +282,6 @@ func (s *Store) WritePrometheus(w io.Writer) error {
 		"# HELP pingsanto_agent_queue_spilled_total Total probe results spilled to disk.",
 		"# TYPE pingsanto_agent_queue_spilled_total counter",
 		fmt.Sprintf("pingsanto_agent_queue_spilled_total %d", snap.QueueSpilledTotal),
-		"# HELP pingsanto_agent_backfill_pending_bytes Bytes currently pending in backfill spill storage.",
-		"# TYPE pingsanto_agent_backfill_pending_bytes gauge",
-		fmt.Sprintf("pingsanto_agent_backfill_pending_bytes %d", snap.BackfillPendingBytes),
 		"# HELP pingsanto_agent_ready Whether the agent considers itself ready (1=ready).",
 		"# TYPE pingsanto_agent_ready gauge",
 		fmt.Sprintf("pingsanto_agent_ready %d", readyValue),
@@ -312,6 +330,18 @@ func (s *Store) WritePrometheus(w io.Writer) error {
 			lines = append(lines, fmt.Sprintf("pingsanto_agent_ready_category_transitions_total{category=%q,severity=%q} %d", cc.Category, cc.Severity, cc.Count))
 		}
 	}
+	if snap.ClockSkewKnown {
+		lines = append(lines,
+			"# HELP pingsanto_agent_clock_skew_seconds Agent clock offset from the controller Date header (positive when the agent is ahead).",
+			"# TYPE pingsanto_agent_clock_skew_seconds gauge",
+			fmt.Sprintf("pingsanto_agent_clock_skew_seconds %g", snap.ClockSkewSeconds),
+		)
+	}
+	lines = append(lines, uplinkPrometheusLines(snap.UplinkEndpoints)...)
+	lines = append(lines, probePrometheusLines(snap.Monitors)...)
+	lines = append(lines, registeredPrometheusLines(s.Registered())...)
+	lines = append(lines, buildPrometheusLines(s.startedAt)...)
+	lines = append(lines, goRuntimePrometheusLines()...)
 	lines = append(lines, "")
 	for _, line := range lines {
 		if _, err := io.WriteString(w, line+"\n"); err != nil {
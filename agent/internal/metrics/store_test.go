@@ -1,11 +1,16 @@
 package metrics
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/buildinfo"
 )
 
 func TestStoreQueueRecorder(t *testing.T) {
@@ -42,6 +47,18 @@ func TestStoreBackfillRecorder(t *testing.T) {
 	if got := store.Snapshot().BackfillPendingBytes; got != 0 {
 		t.Fatalf("expected clamp to 0 got %d", got)
 	}
+
+	// The values live in the registry, so OTLP and StatsD export them too.
+	rec.ObserveReplayedBatch(3)
+	var found bool
+	for _, f := range store.Registered() {
+		if f.Name == "pingsanto_agent_backfill_replayed_results_total" {
+			found = f.Kind == "counter" && len(f.Series) == 1 && f.Series[0].Value == 3
+		}
+	}
+	if !found || store.Snapshot().BackfillReplayedResults != 3 {
+		t.Fatalf("replayed results not registered: %+v", store.Registered())
+	}
 }
 
 func TestStoreWritePrometheus(t *testing.T) {
@@ -244,3 +261,98 @@ func getTransitionCount(counts []CategoryCount, category, severity string) uint6
 	}
 	return 0
 }
+
+func TestStoreWritePrometheusUplinkRequests(t *testing.T) {
+	store := NewStore()
+	rec := store.UplinkRecorder()
+	rec.ObserveRequest("results", 202, nil, 80*time.Millisecond, 512)
+	rec.ObserveRequest("results", 0, errors.New("dial tcp: connection refused"), time.Second, 512)
+	rec.ObserveRequest("heartbeat", 429, nil, 20*time.Millisecond, 64)
+
+	var buf strings.Builder
+	if err := store.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`pingsanto_agent_uplink_requests_total{endpoint="results",code="2xx"} 1`,
+		`pingsanto_agent_uplink_requests_total{endpoint="results",code="error"} 1`,
+		`pingsanto_agent_uplink_requests_total{endpoint="heartbeat",code="4xx"} 1`,
+		`pingsanto_agent_uplink_request_errors_total{endpoint="results"} 1`,
+		`pingsanto_agent_uplink_request_errors_total{endpoint="heartbeat"} 1`,
+		`pingsanto_agent_uplink_request_bytes_sent_total{endpoint="results"} 1024`,
+		`pingsanto_agent_uplink_request_duration_seconds_bucket{endpoint="results",le="0.1"} 1`,
+		`pingsanto_agent_uplink_request_duration_seconds_count{endpoint="results"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestStoreWritePrometheusProbeHistograms(t *testing.T) {
+	store := NewStore()
+	rec := store.ProbeRecorder()
+	rec.ObserveProbe("mon-a", "icmp", true, 3*time.Millisecond)
+	rec.ObserveProbe("mon-a", "icmp", true, 40*time.Millisecond)
+	rec.ObserveProbe("mon-a", "icmp", false, 0)
+	rec.ObserveProbe("mon-b", "tcp", true, 2*time.Second)
+
+	var buf strings.Builder
+	if err := store.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`pingsanto_agent_probe_results_total{monitor_id="mon-a",protocol="icmp",result="success"} 2`,
+		`pingsanto_agent_probe_results_total{monitor_id="mon-a",protocol="icmp",result="failure"} 1`,
+		`pingsanto_agent_probe_rtt_seconds_bucket{monitor_id="mon-a",protocol="icmp",le="0.005"} 1`,
+		`pingsanto_agent_probe_rtt_seconds_bucket{monitor_id="mon-a",protocol="icmp",le="0.05"} 2`,
+		`pingsanto_agent_probe_rtt_seconds_count{monitor_id="mon-a",protocol="icmp"} 2`,
+		`pingsanto_agent_probe_rtt_seconds_bucket{monitor_id="mon-b",protocol="tcp",le="1"} 0`,
+		`pingsanto_agent_probe_rtt_seconds_bucket{monitor_id="mon-b",protocol="tcp",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+
+	store.ForgetMonitors([]string{"mon-b"})
+	snap := store.Snapshot()
+	if len(snap.Monitors) != 1 || snap.Monitors[0].MonitorID != "mon-b" {
+		t.Fatalf("expected only mon-b after forgetting, got %+v", snap.Monitors)
+	}
+}
+
+func TestStoreWritePrometheusGoRuntime(t *testing.T) {
+	var buf strings.Builder
+	if err := NewStore().WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE go_goroutines gauge",
+		"go_memstats_heap_alloc_bytes ",
+		"go_gc_pause_seconds_total ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+	if _, _, ok := fileDescriptorUsage(); ok && !strings.Contains(out, "process_open_fds ") {
+		t.Fatalf("expected process_open_fds in output:\n%s", out)
+	}
+}
+
+func TestStoreWritePrometheusBuildInfo(t *testing.T) {
+	var buf strings.Builder
+	if err := NewStore().WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	info := buildinfo.Get()
+	want := fmt.Sprintf("pingsanto_agent_build_info{version=%q,commit=%q,goversion=%q} 1", info.Version, info.Commit, info.GoVersion)
+	if !strings.Contains(out, want) || !strings.Contains(out, "pingsanto_agent_uptime_seconds_total ") {
+		t.Fatalf("expected build info and uptime in output:\n%s", out)
+	}
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/pingsantohq/agent/internal/buildinfo"
+)
+
+// UpgradeRecorder records the self-upgrade state so dashboards can show
+// version drift without querying the controller.
+type UpgradeRecorder interface {
+	// ObserveUpgradeState publishes the running version (the binary's build
+	// version when no upgrade has been applied yet), the configured
+	// channel, and whether upgrades are paused locally or by the controller.
+	ObserveUpgradeState(version, channel string, localPaused, controllerPaused bool)
+	// ObservePlanFetch records a successful plan fetch (including 304s).
+	ObservePlanFetch(at time.Time)
+	// ObserveUpgradeResult records the outcome of an upgrade attempt: status
+	// is success, failed or deferred; version is the plan's target version.
+	ObserveUpgradeResult(status, version string, at time.Time)
+}
+
+type NoopUpgradeRecorder struct{}
+
+func (NoopUpgradeRecorder) ObserveUpgradeState(string, string, bool, bool) {}
+func (NoopUpgradeRecorder) ObservePlanFetch(time.Time)                     {}
+func (NoopUpgradeRecorder) ObserveUpgradeResult(string, string, time.Time) {}
+
+// UpgradeRecorder returns an implementation of UpgradeRecorder backed by the
+// store's registry.
+func (s *Store) UpgradeRecorder() UpgradeRecorder {
+	return upgradeRecorder{
+		info:         s.GaugeVec("pingsanto_agent_upgrade_info", "Running agent version and upgrade channel (always 1).", "version", "channel"),
+		paused:       s.GaugeVec("pingsanto_agent_upgrade_paused", "Whether automatic upgrades are paused (1=paused) by source.", "source"),
+		planFetch:    s.Gauge("pingsanto_agent_upgrade_plan_fetch_timestamp_seconds", "Unix time of the last successful upgrade plan fetch."),
+		result:       s.GaugeVec("pingsanto_agent_upgrade_last_result_info", "Outcome and target version of the most recent upgrade attempt (always 1).", "status", "version"),
+		resultTime:   s.Gauge("pingsanto_agent_upgrade_last_result_timestamp_seconds", "Unix time of the most recent upgrade attempt outcome."),
+		resultsTotal: s.CounterVec("pingsanto_agent_upgrade_attempts_total", "Upgrade attempt outcomes by status.", "status"),
+	}
+}
+
+type upgradeRecorder struct {
+	info         *GaugeVec
+	paused       *GaugeVec
+	planFetch    *Gauge
+	result       *GaugeVec
+	resultTime   *Gauge
+	resultsTotal *CounterVec
+}
+
+func (r upgradeRecorder) ObserveUpgradeState(version, channel string, localPaused, controllerPaused bool) {
+	if version == "" {
+		version = buildinfo.Get().Version
+	}
+	r.info.Reset()
+	r.info.With(version, channel).Set(1)
+	r.paused.With("local").Set(boolGauge(localPaused))
+	r.paused.With("controller").Set(boolGauge(controllerPaused))
+}
+
+func (r upgradeRecorder) ObservePlanFetch(at time.Time) {
+	r.planFetch.SetTime(at)
+}
+
+func (r upgradeRecorder) ObserveUpgradeResult(status, version string, at time.Time) {
+	r.result.Reset()
+	r.result.With(status, version).Set(1)
+	r.resultTime.SetTime(at)
+	r.resultsTotal.With(status).Inc()
+}
+
+func boolGauge(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
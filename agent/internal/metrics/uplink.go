@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UplinkLatencyBuckets are the histogram upper bounds, in seconds, for uplink request latency.
+var UplinkLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// UplinkRecorder records the outcome of requests sent to the central service.
+type UplinkRecorder interface {
+	// ObserveRequest records one request. status is 0 when no response was received.
+	ObserveRequest(endpoint string, status int, err error, latency time.Duration, bytesSent int64)
+}
+
+type NoopUplinkRecorder struct{}
+
+func (NoopUplinkRecorder) ObserveRequest(string, int, error, time.Duration, int64) {}
+
+// UplinkEndpointStats is a point-in-time copy of the counters for one uplink endpoint.
+type UplinkEndpointStats struct {
+	Endpoint       string
+	Requests       uint64
+	Errors         uint64
+	StatusClasses  map[string]uint64
+	BytesSent      uint64
+	LatencyBuckets []uint64 // cumulative counts aligned with UplinkLatencyBuckets
+	LatencyCount   uint64
+	LatencySum     float64
+}
+
+type uplinkStats struct {
+	mu             sync.Mutex
+	requests       uint64
+	errors         uint64
+	statusClasses  map[string]uint64
+	bytesSent      uint64
+	latencyBuckets []uint64
+	latencyCount   uint64
+	latencySum     float64
+}
+
+// UplinkRecorder returns an implementation of UplinkRecorder backed by the store.
+func (s *Store) UplinkRecorder() UplinkRecorder {
+	return uplinkRecorder{store: s}
+}
+
+type uplinkRecorder struct {
+	store *Store
+}
+
+func (r uplinkRecorder) ObserveRequest(endpoint string, status int, err error, latency time.Duration, bytesSent int64) {
+	stats := r.store.getUplinkStats(endpoint)
+	class := statusClass(status)
+	seconds := latency.Seconds()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.requests++
+	if err != nil || status >= 400 || status == 0 {
+		stats.errors++
+	}
+	stats.statusClasses[class]++
+	if bytesSent > 0 {
+		stats.bytesSent += uint64(bytesSent)
+	}
+	if status != 0 {
+		for i, bound := range UplinkLatencyBuckets {
+			if seconds <= bound {
+				stats.latencyBuckets[i]++
+			}
+		}
+		stats.latencyCount++
+		stats.latencySum += seconds
+	}
+}
+
+func (s *Store) getUplinkStats(endpoint string) *uplinkStats {
+	if endpoint == "" {
+		endpoint = "unknown"
+	}
+	if value, ok := s.uplinkEndpoints.Load(endpoint); ok {
+		return value.(*uplinkStats)
+	}
+	stats := &uplinkStats{
+		statusClasses:  map[string]uint64{},
+		latencyBuckets: make([]uint64, len(UplinkLatencyBuckets)),
+	}
+	actual, _ := s.uplinkEndpoints.LoadOrStore(endpoint, stats)
+	return actual.(*uplinkStats)
+}
+
+// statusClass buckets an HTTP status as "2xx".."5xx", or "error" when no response arrived.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "error"
+	}
+	return strconv.Itoa(status/100) + "xx"
+}
+
+func (s *Store) uplinkSnapshot() []UplinkEndpointStats {
+	var out []UplinkEndpointStats
+	s.uplinkEndpoints.Range(func(key, value any) bool {
+		endpoint, _ := key.(string)
+		stats, ok := value.(*uplinkStats)
+		if !ok {
+			return true
+		}
+		stats.mu.Lock()
+		classes := make(map[string]uint64, len(stats.statusClasses))
+		for k, v := range stats.statusClasses {
+			classes[k] = v
+		}
+		out = append(out, UplinkEndpointStats{
+			Endpoint:       endpoint,
+			Requests:       stats.requests,
+			Errors:         stats.errors,
+			StatusClasses:  classes,
+			BytesSent:      stats.bytesSent,
+			LatencyBuckets: append([]uint64(nil), stats.latencyBuckets...),
+			LatencyCount:   stats.latencyCount,
+			LatencySum:     stats.latencySum,
+		})
+		stats.mu.Unlock()
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].Endpoint < out[j].Endpoint })
+	return out
+}
+
+func uplinkPrometheusLines(endpoints []UplinkEndpointStats) []string {
+	lines := []string{
+		"# HELP pingsanto_agent_uplink_requests_total Requests sent to the central service by endpoint and status class.",
+		"# TYPE pingsanto_agent_uplink_requests_total counter",
+	}
+	for _, ep := range endpoints {
+		classes := make([]string, 0, len(ep.StatusClasses))
+		for class := range ep.StatusClasses {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			lines = append(lines, fmt.Sprintf("pingsanto_agent_uplink_requests_total{endpoint=%q,code=%q} %d", ep.Endpoint, class, ep.StatusClasses[class]))
+		}
+	}
+	lines = append(lines,
+		"# HELP pingsanto_agent_uplink_request_errors_total Requests that failed in transport or returned a 4xx/5xx status.",
+		"# TYPE pingsanto_agent_uplink_request_errors_total counter",
+	)
+	for _, ep := range endpoints {
+		lines = append(lines, fmt.Sprintf("pingsanto_agent_uplink_request_errors_total{endpoint=%q} %d", ep.Endpoint, ep.Errors))
+	}
+	lines = append(lines,
+		"# HELP pingsanto_agent_uplink_request_bytes_sent_total Request body bytes sent to the central service.",
+		"# TYPE pingsanto_agent_uplink_request_bytes_sent_total counter",
+	)
+	for _, ep := range endpoints {
+		lines = append(lines, fmt.Sprintf("pingsanto_agent_uplink_request_bytes_sent_total{endpoint=%q} %d", ep.Endpoint, ep.BytesSent))
+	}
+	lines = append(lines,
+		"# HELP pingsanto_agent_uplink_request_duration_seconds Time until response headers were received.",
+		"# TYPE pingsanto_agent_uplink_request_duration_seconds histogram",
+	)
+	for _, ep := range endpoints {
+		for i, bound := range UplinkLatencyBuckets {
+			lines = append(lines, fmt.Sprintf("pingsanto_agent_uplink_request_duration_seconds_bucket{endpoint=%q,le=%q} %d", ep.Endpoint, strconv.FormatFloat(bound, 'g', -1, 64), ep.LatencyBuckets[i]))
+		}
+		lines = append(lines,
+			fmt.Sprintf("pingsanto_agent_uplink_request_duration_seconds_bucket{endpoint=%q,le=\"+Inf\"} %d", ep.Endpoint, ep.LatencyCount),
+			fmt.Sprintf("pingsanto_agent_uplink_request_duration_seconds_sum{endpoint=%q} %g", ep.Endpoint, ep.LatencySum),
+			fmt.Sprintf("pingsanto_agent_uplink_request_duration_seconds_count{endpoint=%q} %d", ep.Endpoint, ep.LatencyCount),
+		)
+	}
+	return lines
+}
@@ -0,0 +1,118 @@
+// Package monitoring secures the agent's local metrics/health listener so it
+// can be exposed beyond loopback for centralized scraping.
+package monitoring
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Auth describes the credentials scrapers must present. A bearer token and
+// basic auth may both be configured; either one is accepted.
+type Auth struct {
+	BearerToken string
+	Username    string
+	Password    string
+}
+
+// Enabled reports whether any credential is configured.
+func (a Auth) Enabled() bool {
+	return a.BearerToken != "" || a.Username != ""
+}
+
+// Wrap rejects requests lacking valid credentials with 401. When no
+// credentials are configured h is returned unchanged.
+func (a Auth) Wrap(h http.Handler) http.Handler {
+	if !a.Enabled() {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authorized(r) {
+			if a.Username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="pingsanto-agent"`)
+			} else {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (a Auth) authorized(r *http.Request) bool {
+	if a.BearerToken != "" {
+		const prefix = "Bearer "
+		if value := r.Header.Get("Authorization"); strings.HasPrefix(value, prefix) {
+			presented := strings.TrimSpace(strings.TrimPrefix(value, prefix))
+			if equal(presented, a.BearerToken) {
+				return true
+			}
+		}
+	}
+	if a.Username != "" {
+		if user, pass, ok := r.BasicAuth(); ok {
+			// Evaluate both comparisons so timing does not reveal which failed.
+			userOK := equal(user, a.Username)
+			passOK := equal(pass, a.Password)
+			if userOK && passOK {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// ServerTLSConfig loads the listener certificate. When clientCAFile is set,
+// scrapers must present a certificate signed by one of its CAs.
+func ServerTLSConfig(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("monitoring tls requires both cert_file and key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load monitoring certificate: %w", err)
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+	if clientCAFile != "" {
+		pem, err := os.ReadFile(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read monitoring client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("monitoring client CA %s contains no certificates", clientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// IsLoopback reports whether addr (host:port) binds only loopback
+// interfaces. An empty or wildcard host is not loopback; "localhost" is.
+func IsLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,64 @@
+package monitoring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthWrap(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h := Auth{BearerToken: "tok", Username: "prom", Password: "pw"}.Wrap(ok)
+
+	cases := []struct {
+		name  string
+		setup func(*http.Request)
+		want  int
+	}{
+		{"none", func(*http.Request) {}, http.StatusUnauthorized},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer tok") }, http.StatusOK},
+		{"bad bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer nope") }, http.StatusUnauthorized},
+		{"basic", func(r *http.Request) { r.SetBasicAuth("prom", "pw") }, http.StatusOK},
+		{"bad basic", func(r *http.Request) { r.SetBasicAuth("prom", "wrong") }, http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		tc.setup(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	Auth{}.Wrap(ok).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected disabled auth to pass through, got %d", rec.Code)
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1:9310": true,
+		"[::1]:9310":     true,
+		"localhost:9310": true,
+		"0.0.0.0:9310":   false,
+		":9310":          false,
+		"10.0.0.5:9310":  false,
+		"bogus":          false,
+	}
+	for addr, want := range cases {
+		if got := IsLoopback(addr); got != want {
+			t.Fatalf("IsLoopback(%q) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestServerTLSConfigRequiresKeyPair(t *testing.T) {
+	if _, err := ServerTLSConfig("cert.pem", "", ""); err == nil {
+		t.Fatalf("expected error without key file")
+	}
+}
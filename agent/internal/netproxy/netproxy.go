@@ -0,0 +1,91 @@
+// Package netproxy turns the agent's proxy configuration into the proxy
+// selector used by every outbound HTTP transport. HTTP and HTTPS proxies
+// receive credentials as Proxy-Authorization; SOCKS5 proxies authenticate
+// with username/password, both handled natively by net/http.
+package netproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+// Func is the proxy selector signature accepted by http.Transport.
+type Func func(*http.Request) (*url.URL, error)
+
+// FromConfig builds a proxy selector from cfg. An empty URL falls back to the
+// HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+func FromConfig(cfg config.ProxyConfig) (Func, error) {
+	raw := strings.TrimSpace(cfg.URL)
+	if raw == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("parse proxy url: %w", err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q (want http, https, socks5 or socks5h)", proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", raw)
+	}
+	if cfg.Username != "" {
+		proxyURL.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+
+	bypass := make([]string, 0, len(cfg.NoProxy))
+	for _, entry := range cfg.NoProxy {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			bypass = append(bypass, entry)
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if req.URL != nil && bypassed(req.URL.Hostname(), bypass) {
+			return nil, nil
+		}
+		return proxyURL, nil
+	}, nil
+}
+
+// Transport returns a clone of the default transport routed through cfg.
+func Transport(cfg config.ProxyConfig) (*http.Transport, error) {
+	proxy, err := FromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	return transport, nil
+}
+
+func bypassed(host string, bypass []string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range bypass {
+		switch {
+		case entry == "*":
+			return true
+		case strings.Contains(entry, "/"):
+			if _, network, err := net.ParseCIDR(entry); err == nil && ip != nil && network.Contains(ip) {
+				return true
+			}
+		case strings.HasPrefix(entry, "."):
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return true
+			}
+		default:
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
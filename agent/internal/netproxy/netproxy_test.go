@@ -0,0 +1,77 @@
+package netproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+func TestFromConfigAppliesCredentialsAndBypass(t *testing.T) {
+	proxy, err := FromConfig(config.ProxyConfig{
+		URL:      "socks5://proxy.corp.example:1080",
+		Username: "svc",
+		Password: "p@ss",
+		NoProxy:  []string{".internal.example", "10.0.0.0/8", "localhost"},
+	})
+	if err != nil {
+		t.Fatalf("FromConfig: %v", err)
+	}
+
+	cases := map[string]bool{
+		"https://central.example.com/api":      true,
+		"https://ctl.internal.example/api":     false,
+		"https://internal.example/api":         false,
+		"https://10.1.2.3/api":                 false,
+		"https://localhost:8443/api":           false,
+		"https://notlocalhost.example.com/api": true,
+	}
+	for target, proxied := range cases {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		got, err := proxy(req)
+		if err != nil {
+			t.Fatalf("proxy(%s): %v", target, err)
+		}
+		if (got != nil) != proxied {
+			t.Fatalf("proxy(%s) = %v, want proxied=%v", target, got, proxied)
+		}
+		if got != nil {
+			if pw, _ := got.User.Password(); got.User.Username() != "svc" || pw != "p@ss" {
+				t.Fatalf("unexpected proxy credentials: %v", got.User)
+			}
+		}
+	}
+}
+
+func TestFromConfigRejectsUnsupportedScheme(t *testing.T) {
+	_, err := FromConfig(config.ProxyConfig{URL: "ftp://proxy:21"})
+	if err == nil || !strings.Contains(err.Error(), "unsupported proxy scheme") {
+		t.Fatalf("expected scheme error, got %v", err)
+	}
+}
+
+func TestHTTPProxyReceivesAuthorization(t *testing.T) {
+	authCh := make(chan string, 1)
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authCh <- r.Header.Get("Proxy-Authorization")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxyServer.Close()
+
+	transport, err := Transport(config.ProxyConfig{URL: proxyServer.URL, Username: "svc", Password: "secret"})
+	if err != nil {
+		t.Fatalf("Transport: %v", err)
+	}
+	client := &http.Client{Transport: transport}
+	resp, err := client.Get("http://central.example.invalid/healthz")
+	if err != nil {
+		t.Fatalf("request via proxy: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := <-authCh; !strings.HasPrefix(got, "Basic ") {
+		t.Fatalf("expected basic proxy credentials, got %q", got)
+	}
+}
@@ -0,0 +1,227 @@
+// Package oidc implements the OAuth 2.0 device authorization grant (RFC
+// 8628) against an OpenID Connect provider, so an operator can authorize an
+// agent from another device instead of copying a token onto it.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+	defaultInterval = 5 * time.Second
+	slowDownBackoff = 5 * time.Second
+)
+
+// ErrAccessDenied is returned when the operator declines the request.
+var ErrAccessDenied = errors.New("authorization denied by the operator")
+
+// ErrExpired is returned when the device code expires before approval.
+var ErrExpired = errors.New("device code expired before authorization; run the command again")
+
+// DeviceFlow obtains tokens for ClientID from Issuer.
+type DeviceFlow struct {
+	Issuer   string
+	ClientID string
+	// Scopes defaults to "openid".
+	Scopes     []string
+	HTTPClient *http.Client
+	// Sleep waits between token polls; it defaults to a context-aware timer.
+	Sleep func(context.Context, time.Duration) error
+}
+
+// DeviceCode is the device authorization response shown to the operator.
+type DeviceCode struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// Token is a successful token response.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	IDToken     string `json:"id_token"`
+	TokenType   string `json:"token_type"`
+}
+
+// Credential returns the token to present to the controller: the ID token,
+// or the access token when the provider issued none.
+func (t Token) Credential() string {
+	if t.IDToken != "" {
+		return t.IDToken
+	}
+	return t.AccessToken
+}
+
+type discovery struct {
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+}
+
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// Authorize runs the whole flow: it requests a device code, passes it to
+// prompt for display, and polls until the operator approves or denies.
+func (f *DeviceFlow) Authorize(ctx context.Context, prompt func(DeviceCode)) (Token, error) {
+	endpoints, err := f.discover(ctx)
+	if err != nil {
+		return Token{}, err
+	}
+	code, err := f.requestCode(ctx, endpoints.DeviceAuthorizationEndpoint)
+	if err != nil {
+		return Token{}, err
+	}
+	prompt(code)
+	return f.poll(ctx, endpoints.TokenEndpoint, code)
+}
+
+func (f *DeviceFlow) client() *http.Client {
+	if f.HTTPClient != nil {
+		return f.HTTPClient
+	}
+	return &http.Client{Timeout: 10 * time.Second}
+}
+
+func (f *DeviceFlow) discover(ctx context.Context) (discovery, error) {
+	issuer := strings.TrimRight(strings.TrimSpace(f.Issuer), "/")
+	if issuer == "" || strings.TrimSpace(f.ClientID) == "" {
+		return discovery{}, errors.New("oidc issuer and client id are required")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return discovery{}, fmt.Errorf("build discovery request: %w", err)
+	}
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return discovery{}, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return discovery{}, fmt.Errorf("oidc discovery: status %s", resp.Status)
+	}
+	var doc discovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return discovery{}, fmt.Errorf("decode oidc discovery: %w", err)
+	}
+	if doc.DeviceAuthorizationEndpoint == "" || doc.TokenEndpoint == "" {
+		return discovery{}, fmt.Errorf("issuer %s does not support the device authorization grant", issuer)
+	}
+	return doc, nil
+}
+
+func (f *DeviceFlow) requestCode(ctx context.Context, endpoint string) (DeviceCode, error) {
+	scopes := f.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid"}
+	}
+	form := url.Values{"client_id": {f.ClientID}, "scope": {strings.Join(scopes, " ")}}
+	var code DeviceCode
+	status, err := f.postForm(ctx, endpoint, form, &code)
+	if err != nil {
+		return DeviceCode{}, fmt.Errorf("device authorization: %w", err)
+	}
+	if status != http.StatusOK {
+		return DeviceCode{}, fmt.Errorf("device authorization: status %d", status)
+	}
+	if code.DeviceCode == "" || code.UserCode == "" || code.VerificationURI == "" {
+		return DeviceCode{}, errors.New("device authorization: incomplete response")
+	}
+	return code, nil
+}
+
+func (f *DeviceFlow) poll(ctx context.Context, endpoint string, code DeviceCode) (Token, error) {
+	sleep := f.Sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	interval := time.Duration(code.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	var deadline time.Time
+	if code.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	}
+	form := url.Values{"grant_type": {deviceCodeGrant}, "device_code": {code.DeviceCode}, "client_id": {f.ClientID}}
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			return Token{}, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return Token{}, ErrExpired
+		}
+		var body struct {
+			Token
+			oauthError
+		}
+		status, err := f.postForm(ctx, endpoint, form, &body)
+		if err != nil {
+			return Token{}, fmt.Errorf("token request: %w", err)
+		}
+		if status == http.StatusOK && body.Credential() != "" {
+			return body.Token, nil
+		}
+		switch body.Code {
+		case "authorization_pending":
+		case "slow_down":
+			interval += slowDownBackoff
+		case "access_denied":
+			return Token{}, ErrAccessDenied
+		case "expired_token":
+			return Token{}, ErrExpired
+		case "":
+			return Token{}, fmt.Errorf("token request: status %d", status)
+		default:
+			return Token{}, fmt.Errorf("token request: %s: %s", body.Code, body.Description)
+		}
+	}
+}
+
+func (f *DeviceFlow) postForm(ctx context.Context, endpoint string, form url.Values, out any) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := f.client().Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return resp.StatusCode, fmt.Errorf("decode response (status %d): %w", resp.StatusCode, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
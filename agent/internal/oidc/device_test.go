@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeProvider answers the token endpoint with the queued error codes, then
+// issues tokens.
+func fakeProvider(t *testing.T, pending ...string) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"device_authorization_endpoint": srv.URL + "/device",
+				"token_endpoint":                srv.URL + "/token",
+			})
+		case "/device":
+			if r.FormValue("client_id") != "agent-enroll" || r.FormValue("scope") != "openid" {
+				t.Errorf("unexpected device request %v", r.Form)
+			}
+			json.NewEncoder(w).Encode(DeviceCode{DeviceCode: "dev-1", UserCode: "WDJB-MJHT", VerificationURI: srv.URL + "/activate", ExpiresIn: 600, Interval: 1})
+		case "/token":
+			if r.FormValue("grant_type") != deviceCodeGrant || r.FormValue("device_code") != "dev-1" {
+				t.Errorf("unexpected token request %v", r.Form)
+			}
+			if len(pending) > 0 {
+				code := pending[0]
+				pending = pending[1:]
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": code})
+				return
+			}
+			json.NewEncoder(w).Encode(Token{AccessToken: "access", IDToken: "id-token", TokenType: "Bearer"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDeviceFlowPollsUntilAuthorized(t *testing.T) {
+	srv := fakeProvider(t, "authorization_pending", "slow_down")
+	var waits []time.Duration
+	flow := &DeviceFlow{
+		Issuer:   srv.URL,
+		ClientID: "agent-enroll",
+		Sleep: func(_ context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			return nil
+		},
+	}
+	var shown DeviceCode
+	tok, err := flow.Authorize(context.Background(), func(code DeviceCode) { shown = code })
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if shown.UserCode != "WDJB-MJHT" {
+		t.Fatalf("expected user code to be shown, got %+v", shown)
+	}
+	if tok.Credential() != "id-token" {
+		t.Fatalf("expected id token credential, got %+v", tok)
+	}
+	if len(waits) != 3 || waits[0] != time.Second || waits[2] != 6*time.Second {
+		t.Fatalf("expected 1s polling slowed to 6s, got %v", waits)
+	}
+}
+
+func TestDeviceFlowReportsDenial(t *testing.T) {
+	srv := fakeProvider(t, "access_denied")
+	flow := &DeviceFlow{Issuer: srv.URL, ClientID: "agent-enroll", Sleep: func(context.Context, time.Duration) error { return nil }}
+	if _, err := flow.Authorize(context.Background(), func(DeviceCode) {}); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected ErrAccessDenied, got %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Package profiling exposes net/http/pprof on the agent monitoring listener
+// behind a loopback/token guard.
+package profiling
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"strings"
+)
+
+// Prefix is the path the handler is mounted under.
+const Prefix = "/debug/pprof/"
+
+// Handler serves the pprof endpoints. Requests from loopback addresses are
+// always allowed; other clients must send "Authorization: Bearer <token>",
+// and are refused outright when token is empty.
+func Handler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(Prefix, pprof.Index)
+	mux.HandleFunc(Prefix+"cmdline", pprof.Cmdline)
+	mux.HandleFunc(Prefix+"profile", pprof.Profile)
+	mux.HandleFunc(Prefix+"symbol", pprof.Symbol)
+	mux.HandleFunc(Prefix+"trace", pprof.Trace)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowed(r, token) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func allowed(r *http.Request, token string) bool {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+			return true
+		}
+	}
+	if token == "" {
+		return false
+	}
+	const prefix = "Bearer "
+	value := r.Header.Get("Authorization")
+	if !strings.HasPrefix(value, prefix) {
+		return false
+	}
+	presented := strings.TrimSpace(strings.TrimPrefix(value, prefix))
+	return subtle.ConstantTimeCompare([]byte(presented), []byte(token)) == 1
+}
@@ -0,0 +1,47 @@
+package profiling
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerGuardsNonLoopbackClients(t *testing.T) {
+	h := Handler("s3cret")
+
+	cases := []struct {
+		name   string
+		remote string
+		auth   string
+		want   int
+	}{
+		{"loopback", "127.0.0.1:5000", "", http.StatusOK},
+		{"loopback v6", "[::1]:5000", "", http.StatusOK},
+		{"remote without token", "192.0.2.10:5000", "", http.StatusForbidden},
+		{"remote wrong token", "192.0.2.10:5000", "Bearer nope", http.StatusForbidden},
+		{"remote with token", "192.0.2.10:5000", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, Prefix+"cmdline", nil)
+		req.RemoteAddr = tc.remote
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d", tc.name, tc.want, rec.Code)
+		}
+	}
+}
+
+func TestHandlerWithoutTokenIsLoopbackOnly(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, Prefix, nil)
+	req.RemoteAddr = "192.0.2.10:5000"
+	req.Header.Set("Authorization", "Bearer ")
+	rec := httptest.NewRecorder()
+	Handler("").ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
@@ -260,6 +260,21 @@ func (s *Store) SizeBytes() int64 {
 	return s.totalSize
 }
 
+// SetMaxBytes changes the disk cap at runtime, evicting the oldest segments
+// if the store is already above the new cap.
+func (s *Store) SetMaxBytes(maxBytes int64) error {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxBytes
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxBytes = maxBytes
+	if s.segmentSize > maxBytes {
+		s.segmentSize = maxBytes
+	}
+	return s.enforceMaxBytes()
+}
+
 func (s *Store) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
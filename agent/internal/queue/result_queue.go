@@ -15,6 +15,7 @@ type ResultQueue struct {
 	capacity  int
 	items     []types.ProbeResult
 	spill     *persist.Store
+	ratio     float64
 	threshold int
 	spilled   uint64
 	dropped   uint64
@@ -39,13 +40,55 @@ func (q *ResultQueue) AttachSpill(store *persist.Store, thresholdRatio float64)
 	if thresholdRatio <= 0 || thresholdRatio > 1 {
 		thresholdRatio = 0.8
 	}
-	threshold := int(float64(q.capacity) * thresholdRatio)
+	q.ratio = thresholdRatio
+	q.updateThresholdLocked()
+}
+
+func (q *ResultQueue) updateThresholdLocked() {
+	if q.spill == nil {
+		return
+	}
+	threshold := int(float64(q.capacity) * q.ratio)
 	if threshold < 1 {
 		threshold = q.capacity
 	}
 	q.threshold = threshold
 }
 
+// SetCapacity changes the in-memory limit at runtime. When shrinking, the
+// oldest excess results are spilled (or dropped without a spill store) just
+// as Enqueue would.
+func (q *ResultQueue) SetCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.capacity = capacity
+	q.updateThresholdLocked()
+	for len(q.items) > q.capacity {
+		if q.spillOldestLocked() {
+			continue
+		}
+		if len(q.items) <= q.capacity {
+			break
+		}
+		removed := q.items[0]
+		q.items = q.items[1:]
+		q.dropped++
+		q.recordEvent(types.EventQueueDrop, removed.MonitorID)
+		q.incrementDrop()
+		q.observeDepthLocked()
+	}
+}
+
+// Capacity reports the in-memory limit.
+func (q *ResultQueue) Capacity() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.capacity
+}
+
 func (q *ResultQueue) SetEventRecorder(rec events.Recorder) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
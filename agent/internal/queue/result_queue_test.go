@@ -122,3 +122,41 @@ func sampleResult(id string) types.ProbeResult {
 		MonitorID: id,
 	}
 }
+
+func TestResultQueueSetCapacity(t *testing.T) {
+	q := NewResultQueue(4)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		q.Enqueue(sampleResult(id))
+	}
+	q.SetCapacity(2)
+	if q.Capacity() != 2 || q.Len() != 2 || q.Stats().Dropped != 2 {
+		t.Fatalf("expected oldest results dropped on shrink, got %+v", q.Stats())
+	}
+	if drained := q.Drain(0); drained[0].MonitorID != "c" || drained[1].MonitorID != "d" {
+		t.Fatalf("expected newest results kept, got %+v", drained)
+	}
+
+	q.SetCapacity(3)
+	for _, id := range []string{"e", "f", "g"} {
+		if q.Enqueue(sampleResult(id)) {
+			t.Fatalf("did not expect drop after growing capacity")
+		}
+	}
+}
+
+func TestResultQueueSetCapacitySpillsExcess(t *testing.T) {
+	store, err := persist.Open(filepath.Join(t.TempDir(), "spill"), 1<<20, 1<<16)
+	if err != nil {
+		t.Fatalf("open spill: %v", err)
+	}
+	defer store.Close()
+	q := NewResultQueue(4)
+	q.AttachSpill(store, 1)
+	for _, id := range []string{"a", "b", "c"} {
+		q.Enqueue(sampleResult(id))
+	}
+	q.SetCapacity(1)
+	if stats := q.Stats(); stats.Len != 1 || stats.Spilled != 2 || stats.Dropped != 0 {
+		t.Fatalf("expected excess spilled to disk, got %+v", stats)
+	}
+}
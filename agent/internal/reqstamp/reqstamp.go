@@ -0,0 +1,66 @@
+// Package reqstamp adds freshness headers to every request the agent sends to
+// the controller so it can reject stale or replayed requests.
+package reqstamp
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// HeaderTimestamp carries the send time in unix seconds.
+	HeaderTimestamp = "X-PingSanto-Timestamp"
+	// HeaderNonce carries a random, single-use request identifier.
+	HeaderNonce = "X-PingSanto-Nonce"
+)
+
+// Transport stamps outgoing requests before delegating to Base.
+type Transport struct {
+	Base http.RoundTripper
+	Now  func() time.Time
+}
+
+// Wrap returns base wrapped in a stamping Transport.
+func Wrap(base http.RoundTripper) *Transport {
+	return &Transport{Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	now := t.Now
+	if now == nil {
+		now = time.Now
+	}
+	nonce, err := newNonce()
+	if err != nil {
+		return nil, err
+	}
+	stamped := req.Clone(req.Context())
+	stamped.Header.Set(HeaderTimestamp, strconv.FormatInt(now().Unix(), 10))
+	stamped.Header.Set(HeaderNonce, nonce)
+	return base.RoundTrip(stamped)
+}
+
+// CloseIdleConnections forwards to the wrapped transport so http.Client can release connections.
+func (t *Transport) CloseIdleConnections() {
+	type closeIdler interface{ CloseIdleConnections() }
+	if ci, ok := t.Base.(closeIdler); ok {
+		ci.CloseIdleConnections()
+	}
+}
+
+func newNonce() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", fmt.Errorf("generate request nonce: %w", err)
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
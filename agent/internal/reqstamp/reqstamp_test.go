@@ -0,0 +1,37 @@
+package reqstamp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTransportStampsUniqueNonces(t *testing.T) {
+	type stamp struct{ ts, nonce string }
+	seen := make(chan stamp, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen <- stamp{r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce)}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{
+		Base: server.Client().Transport,
+		Now:  func() time.Time { return time.Unix(1700000000, 0) },
+	}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	first, second := <-seen, <-seen
+	if first.ts != "1700000000" || second.ts != "1700000000" {
+		t.Fatalf("unexpected timestamps %q %q", first.ts, second.ts)
+	}
+	if len(first.nonce) != 32 || first.nonce == second.nonce {
+		t.Fatalf("expected distinct 128-bit nonces, got %q %q", first.nonce, second.nonce)
+	}
+}
@@ -89,6 +89,7 @@ type Runtime struct {
 	pool      *worker.Pool
 	backfill  *backfill.Controller
 	upgrader  *upgrade.Manager
+	metrics   *metrics.Store
 }
 
 func New(opts ...Option) *Runtime {
@@ -109,7 +110,11 @@ func New(opts ...Option) *Runtime {
 		results.SetMetricsRecorder(cfg.metricsStore.QueueRecorder())
 	}
 	_sched := scheduler.New(jobs, cfg.schedulerOpts...)
-	_pool := worker.NewPool(jobs, results, cfg.workerOpts...)
+	workerOpts := cfg.workerOpts
+	if cfg.metricsStore != nil {
+		workerOpts = append([]worker.PoolOption{worker.WithProbeRecorder(cfg.metricsStore.ProbeRecorder())}, workerOpts...)
+	}
+	_pool := worker.NewPool(jobs, results, workerOpts...)
 
 	if cfg.backfillCtrl != nil && cfg.metricsStore != nil {
 		cfg.backfillCtrl.SetMetrics(cfg.metricsStore.BackfillRecorder())
@@ -122,6 +127,7 @@ func New(opts ...Option) *Runtime {
 		pool:      _pool,
 		backfill:  cfg.backfillCtrl,
 		upgrader:  cfg.upgradeManager,
+		metrics:   cfg.metricsStore,
 	}
 }
 
@@ -152,6 +158,33 @@ func (r *Runtime) Start(ctx context.Context) func() {
 
 func (r *Runtime) UpdateMonitors(specs []scheduler.MonitorSpec) {
 	r.scheduler.Update(specs)
+	if r.metrics != nil {
+		active := make([]string, 0, len(specs))
+		for _, spec := range specs {
+			active = append(active, spec.MonitorID)
+		}
+		r.metrics.ForgetMonitors(active)
+	}
+}
+
+// SetWorkerCount resizes the probe worker pool without restarting the runtime.
+func (r *Runtime) SetWorkerCount(n int) {
+	r.pool.Resize(n)
+}
+
+// WorkerCount reports the current probe worker pool size.
+func (r *Runtime) WorkerCount() int {
+	return r.pool.Size()
+}
+
+// SchedulerLastTick reports when the scheduler last ticked.
+func (r *Runtime) SchedulerLastTick() time.Time {
+	return r.scheduler.LastTick()
+}
+
+// WorkerProgress reports pending probe jobs and when workers last made progress.
+func (r *Runtime) WorkerProgress() (int, time.Time) {
+	return r.pool.Progress()
 }
 
 func (r *Runtime) ResultsQueue() *queue.ResultQueue {
@@ -178,6 +211,11 @@ func WithTickResolution(d time.Duration) Option {
 	return WithSchedulerOptions(scheduler.WithTickResolution(d))
 }
 
+// WithProbeDedupe shares one probe between monitors with identical definitions.
+func WithProbeDedupe(enabled bool) Option {
+	return WithSchedulerOptions(scheduler.WithDedupe(enabled))
+}
+
 func WithNow(now func() time.Time) Option {
 	return WithSchedulerOptions(scheduler.WithNow(now))
 }
@@ -2,7 +2,10 @@ package scheduler
 
 import (
 	"context"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pingsantohq/agent/internal/worker"
@@ -23,6 +26,11 @@ type Scheduler struct {
 
 	now func() time.Time
 
+	dedupe bool
+
+	// lastTick holds the UnixNano of the most recent tick for liveness checks.
+	lastTick atomic.Int64
+
 	mu      sync.Mutex
 	entries map[string]*entry
 }
@@ -51,6 +59,15 @@ func WithNow(now func() time.Time) Option {
 	}
 }
 
+// WithDedupe makes monitors that come due together with the same protocol,
+// targets, timeout and configuration share a single probe job; the worker
+// fans the result out to every monitor ID.
+func WithDedupe(enabled bool) Option {
+	return func(s *Scheduler) {
+		s.dedupe = enabled
+	}
+}
+
 func New(jobCh chan<- worker.Job, opts ...Option) *Scheduler {
 	s := &Scheduler{
 		jobCh:          jobCh,
@@ -88,46 +105,97 @@ func (s *Scheduler) Start(ctx context.Context) {
 	ticker := time.NewTicker(s.tickResolution)
 	defer ticker.Stop()
 
+	s.lastTick.Store(s.now().UnixNano())
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			s.tick(s.now())
+			now := s.now()
+			s.tick(now)
+			s.lastTick.Store(now.UnixNano())
 		}
 	}
 }
 
+// LastTick reports when the scheduler loop last completed a tick, or the
+// zero time if it has not started.
+func (s *Scheduler) LastTick() time.Time {
+	ns := s.lastTick.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
 func (s *Scheduler) tick(now time.Time) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	for id, e := range s.entries {
-		if e.paused {
+	var due []*entry
+	for _, e := range s.entries {
+		if e.paused || now.Before(e.next) {
 			continue
 		}
-		if !now.Before(e.next) {
-			job := worker.Job{
-				MonitorID:     e.spec.MonitorID,
-				Protocol:      e.spec.Protocol,
-				Targets:       append([]string{}, e.spec.Targets...),
-				Cadence:       e.spec.Cadence,
-				Timeout:       e.spec.Timeout,
-				ScheduledFor:  e.next,
-				Configuration: e.spec.Configuration,
-			}
-			select {
-			case s.jobCh <- job:
-			default:
-			}
-			interval := e.spec.Cadence
-			if interval <= 0 {
-				interval = 3 * time.Second
-			}
-			for !now.Before(e.next) {
-				e.next = e.next.Add(interval)
+		due = append(due, e)
+	}
+	if s.dedupe {
+		// Sorted so the lowest monitor ID consistently carries the probe.
+		sort.Slice(due, func(i, j int) bool { return due[i].spec.MonitorID < due[j].spec.MonitorID })
+	}
+
+	var jobs []worker.Job
+	shared := make(map[string]int)
+	for _, e := range due {
+		key := ""
+		if s.dedupe {
+			key = probeKey(e.spec)
+			if idx, ok := shared[key]; ok {
+				jobs[idx].SharedWith = append(jobs[idx].SharedWith, e.spec.MonitorID)
+				s.advance(e, now)
+				continue
 			}
-			s.entries[id] = e
+			shared[key] = len(jobs)
+		}
+		jobs = append(jobs, worker.Job{
+			MonitorID:     e.spec.MonitorID,
+			Protocol:      e.spec.Protocol,
+			Targets:       append([]string{}, e.spec.Targets...),
+			Cadence:       e.spec.Cadence,
+			Timeout:       e.spec.Timeout,
+			ScheduledFor:  e.next,
+			Configuration: e.spec.Configuration,
+		})
+		s.advance(e, now)
+	}
+
+	for _, job := range jobs {
+		select {
+		case s.jobCh <- job:
+		default:
 		}
 	}
 }
+
+func (s *Scheduler) advance(e *entry, now time.Time) {
+	interval := e.spec.Cadence
+	if interval <= 0 {
+		interval = 3 * time.Second
+	}
+	for !now.Before(e.next) {
+		e.next = e.next.Add(interval)
+	}
+}
+
+// probeKey identifies monitors whose probes are interchangeable. Target order
+// does not matter.
+func probeKey(spec MonitorSpec) string {
+	targets := append([]string(nil), spec.Targets...)
+	sort.Strings(targets)
+	return strings.Join([]string{
+		strings.ToLower(spec.Protocol),
+		strings.Join(targets, ","),
+		spec.Timeout.String(),
+		spec.Configuration,
+	}, "\x00")
+}
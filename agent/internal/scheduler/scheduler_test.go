@@ -93,3 +93,33 @@ func TestSchedulerUpdateReplacesMonitors(t *testing.T) {
 		t.Fatalf("expected job for mon2")
 	}
 }
+
+func TestSchedulerDedupeSharesIdenticalMonitors(t *testing.T) {
+	jobCh := make(chan worker.Job, 10)
+	current := time.Unix(0, 0).UTC()
+	s := New(jobCh, WithNow(func() time.Time { return current }), WithDedupe(true))
+
+	s.Update([]MonitorSpec{
+		{MonitorID: "mon-b", Protocol: "icmp", Targets: []string{"203.0.113.2", "203.0.113.1"}, Cadence: time.Second, Timeout: time.Second},
+		{MonitorID: "mon-a", Protocol: "icmp", Targets: []string{"203.0.113.1", "203.0.113.2"}, Cadence: time.Second, Timeout: time.Second},
+		{MonitorID: "mon-c", Protocol: "icmp", Targets: []string{"203.0.113.1", "203.0.113.2"}, Cadence: time.Second, Timeout: 2 * time.Second},
+	})
+
+	current = current.Add(time.Second)
+	s.tick(current)
+
+	if len(jobCh) != 2 {
+		t.Fatalf("expected 2 jobs, got %d", len(jobCh))
+	}
+	jobs := map[string]worker.Job{}
+	for len(jobCh) > 0 {
+		job := <-jobCh
+		jobs[job.MonitorID] = job
+	}
+	if got := jobs["mon-a"].SharedWith; len(got) != 1 || got[0] != "mon-b" {
+		t.Fatalf("expected mon-a to carry mon-b, got %v", got)
+	}
+	if got := jobs["mon-c"]; got.MonitorID != "mon-c" || len(got.SharedWith) != 0 {
+		t.Fatalf("expected mon-c probed on its own, got %+v", got)
+	}
+}
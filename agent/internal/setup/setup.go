@@ -0,0 +1,431 @@
+package setup
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/agent/internal/certs"
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/enroll"
+	"github.com/pingsantohq/agent/internal/netproxy"
+	"github.com/pingsantohq/agent/internal/reqstamp"
+	"github.com/pingsantohq/agent/internal/upgrade"
+	"github.com/pingsantohq/agent/internal/uplink"
+	"github.com/pingsantohq/agent/pkg/revocation"
+)
+
+const (
+	defaultDataDir    = "/var/lib/pingsanto/agent"
+	defaultUnitPath   = "/etc/systemd/system/pingsanto-agent.service"
+	systemdRuntimeDir = "/run/systemd/system"
+)
+
+// Check is one line of the setup summary.
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Dependencies provides optional overrides for testing.
+type Dependencies struct {
+	In  io.Reader
+	Out io.Writer
+	// Enroll runs the enrollment command with the given arguments.
+	Enroll func(ctx context.Context, args []string) error
+	// Verify runs the post-enrollment connectivity checks.
+	Verify func(ctx context.Context, configPath string) []Check
+	// RunCommand runs systemctl.
+	RunCommand func(ctx context.Context, name string, args ...string) ([]byte, error)
+	Executable func() (string, error)
+	// SystemdAvailable reports whether the host is booted with systemd.
+	SystemdAvailable func() bool
+}
+
+func (d *Dependencies) ensure() {
+	if d.In == nil {
+		d.In = os.Stdin
+	}
+	if d.Out == nil {
+		d.Out = os.Stdout
+	}
+	if d.Enroll == nil {
+		d.Enroll = func(ctx context.Context, args []string) error {
+			return enroll.Run(ctx, args, enroll.Dependencies{})
+		}
+	}
+	if d.Verify == nil {
+		d.Verify = verifyConnectivity
+	}
+	if d.RunCommand == nil {
+		d.RunCommand = func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			return exec.CommandContext(ctx, name, args...).CombinedOutput()
+		}
+	}
+	if d.Executable == nil {
+		d.Executable = os.Executable
+	}
+	if d.SystemdAvailable == nil {
+		d.SystemdAvailable = func() bool {
+			_, err := os.Stat(systemdRuntimeDir)
+			return err == nil
+		}
+	}
+}
+
+// Run performs first-boot bring-up: enroll, write agent.yaml, verify
+// connectivity, install the systemd unit and print a readiness summary.
+// Missing values are prompted for unless --non-interactive is set. Re-running
+// on an enrolled host skips enrollment and only re-checks.
+func Run(ctx context.Context, args []string, deps Dependencies) error {
+	deps.ensure()
+
+	fs := flag.NewFlagSet("setup", flag.ContinueOnError)
+	server := fs.String("server", "", "PingSanto central server URL")
+	token := fs.String("token", "", "Enrollment token generated by central")
+	labels := fs.String("labels", "", "Comma-separated label assignments (e.g. site=ATL-1,isp=Comcast)")
+	dataDir := fs.String("data-dir", defaultDataDir, "Agent data directory")
+	configPath := fs.String("config-path", config.DefaultConfigPath, "Agent config file to write")
+	bootstrapPath := fs.String("bootstrap", config.DefaultBootstrapPath, "Bootstrap plan shipped with the install package")
+	proxyURL := fs.String("proxy", "", "Proxy for controller traffic (http://, https://, socks5://)")
+	unitPath := fs.String("systemd-unit", defaultUnitPath, "Where to install the systemd unit")
+	skipSystemd := fs.Bool("skip-systemd", false, "Do not install or start the systemd unit")
+	noStart := fs.Bool("no-start", false, "Enable the systemd unit without starting it")
+	nonInteractive := fs.Bool("non-interactive", false, "Fail instead of prompting for missing values")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	enrolled := false
+	if _, err := os.Stat(config.StatePath(*dataDir)); err == nil {
+		enrolled = true
+	} else if !errors.Is(err, iofs.ErrNotExist) {
+		return fmt.Errorf("check state file: %w", err)
+	}
+
+	if *server == "" {
+		if plan, ok, err := config.LoadBootstrap(*bootstrapPath); err == nil && ok {
+			*server = plan.Server
+		}
+	}
+	prompt := newPrompter(deps.In, deps.Out, !*nonInteractive)
+	var err error
+	if *server, err = prompt.ask("Controller URL", *server, true); err != nil {
+		return err
+	}
+	if !enrolled {
+		if *token, err = prompt.ask("Enrollment token", *token, true); err != nil {
+			return err
+		}
+		if *labels, err = prompt.ask("Labels (k=v,...)", *labels, false); err != nil {
+			return err
+		}
+	}
+
+	var checks []Check
+	if enrolled {
+		checks = append(checks, Check{Name: "enrollment", OK: true, Detail: "already enrolled; skipped"})
+	} else {
+		enrollArgs := []string{
+			"--server", *server,
+			"--token", *token,
+			"--data-dir", *dataDir,
+			"--config-path", *configPath,
+			"--bootstrap", *bootstrapPath,
+		}
+		if *labels != "" {
+			enrollArgs = append(enrollArgs, "--labels", *labels)
+		}
+		if *proxyURL != "" {
+			enrollArgs = append(enrollArgs, "--proxy", *proxyURL)
+		}
+		if err := deps.Enroll(ctx, enrollArgs); err != nil {
+			checks = append(checks, Check{Name: "enrollment", Detail: err.Error()})
+			writeSummary(deps.Out, checks)
+			return fmt.Errorf("setup failed: enrollment: %w", err)
+		}
+		checks = append(checks, Check{Name: "enrollment", OK: true})
+	}
+
+	wrote, err := writeBaseConfig(*configPath, *server, *dataDir, *proxyURL)
+	if err != nil {
+		checks = append(checks, Check{Name: "config", Detail: err.Error()})
+		writeSummary(deps.Out, checks)
+		return fmt.Errorf("setup failed: %w", err)
+	}
+	detail := "kept existing " + *configPath
+	if wrote {
+		detail = "wrote " + *configPath
+	}
+	checks = append(checks, Check{Name: "config", OK: true, Detail: detail})
+
+	checks = append(checks, deps.Verify(ctx, *configPath)...)
+
+	if *skipSystemd {
+		checks = append(checks, Check{Name: "systemd unit", OK: true, Detail: "skipped (--skip-systemd)"})
+	} else if !deps.SystemdAvailable() {
+		checks = append(checks, Check{Name: "systemd unit", OK: true, Detail: "skipped (systemd not running on this host)"})
+	} else {
+		checks = append(checks, installUnit(ctx, deps, *unitPath, *configPath, !*noStart))
+	}
+
+	if !writeSummary(deps.Out, checks) {
+		return errors.New("setup incomplete; see failed checks above")
+	}
+	return nil
+}
+
+type prompter struct {
+	in          *bufio.Reader
+	out         io.Writer
+	interactive bool
+}
+
+func newPrompter(in io.Reader, out io.Writer, interactive bool) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out, interactive: interactive}
+}
+
+// ask returns current when set, otherwise prompts. Required values fail in
+// non-interactive mode or on empty input.
+func (p *prompter) ask(label, current string, required bool) (string, error) {
+	if current != "" {
+		return current, nil
+	}
+	if !p.interactive {
+		if required {
+			return "", fmt.Errorf("%s is required in non-interactive mode", strings.ToLower(label))
+		}
+		return "", nil
+	}
+	fmt.Fprintf(p.out, "%s: ", label)
+	line, err := p.in.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("read %s: %w", strings.ToLower(label), err)
+	}
+	value := strings.TrimSpace(line)
+	if value == "" && required {
+		return "", fmt.Errorf("%s is required", strings.ToLower(label))
+	}
+	return value, nil
+}
+
+type baseConfig struct {
+	Version int `yaml:"version"`
+	Agent   struct {
+		Server  string `yaml:"server"`
+		DataDir string `yaml:"data_dir"`
+	} `yaml:"agent"`
+	Proxy *struct {
+		URL string `yaml:"url"`
+	} `yaml:"proxy,omitempty"`
+}
+
+// writeBaseConfig writes a minimal agent.yaml unless one already exists, for
+// example the signed config delivered during enrollment.
+func writeBaseConfig(path, server, dataDir, proxyURL string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return false, nil
+	} else if !errors.Is(err, iofs.ErrNotExist) {
+		return false, fmt.Errorf("check config %q: %w", path, err)
+	}
+	var cfg baseConfig
+	cfg.Version = config.CurrentConfigVersion
+	cfg.Agent.Server = server
+	cfg.Agent.DataDir = dataDir
+	if proxyURL != "" {
+		cfg.Proxy = &struct {
+			URL string `yaml:"url"`
+		}{URL: proxyURL}
+	}
+	data, err := yaml.Marshal(&cfg)
+	if err != nil {
+		return false, fmt.Errorf("encode config: %w", err)
+	}
+	if err := config.WriteSignedConfig(path, data); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func unitFile(executable, configPath string) string {
+	return fmt.Sprintf(`[Unit]
+Description=PingSanto monitoring agent
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart=%s run --config %s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+RestartSec=5
+
+[Install]
+WantedBy=multi-user.target
+`, executable, configPath)
+}
+
+func installUnit(ctx context.Context, deps Dependencies, unitPath, configPath string, start bool) Check {
+	check := Check{Name: "systemd unit"}
+	exe, err := deps.Executable()
+	if err != nil {
+		check.Detail = fmt.Sprintf("resolve executable: %v", err)
+		return check
+	}
+	if err := os.MkdirAll(filepath.Dir(unitPath), 0o755); err != nil {
+		check.Detail = fmt.Sprintf("ensure unit dir: %v", err)
+		return check
+	}
+	if err := os.WriteFile(unitPath, []byte(unitFile(exe, configPath)), 0o644); err != nil {
+		check.Detail = fmt.Sprintf("write unit: %v", err)
+		return check
+	}
+	unit := filepath.Base(unitPath)
+	commands := [][]string{{"daemon-reload"}, {"enable", unit}}
+	if start {
+		commands = append(commands, []string{"restart", unit})
+	}
+	for _, args := range commands {
+		if out, err := deps.RunCommand(ctx, "systemctl", args...); err != nil {
+			check.Detail = fmt.Sprintf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+			return check
+		}
+	}
+	check.OK = true
+	check.Detail = "installed " + unitPath
+	if start {
+		check.Detail += " and started"
+	}
+	return check
+}
+
+// verifyConnectivity exercises the same calls the agent makes at startup:
+// an mTLS handshake, an upgrade plan fetch and a monitor snapshot fetch.
+func verifyConnectivity(ctx context.Context, configPath string) []Check {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	cfg, err := config.Load(ctx, configPath)
+	if err != nil {
+		return []Check{{Name: "TLS handshake", Detail: fmt.Sprintf("load config: %v", err)}}
+	}
+	state, err := config.LoadState(ctx, cfg.Agent.DataDir)
+	if err != nil {
+		return []Check{{Name: "TLS handshake", Detail: fmt.Sprintf("load state: %v", err)}}
+	}
+	server := cfg.Agent.Server
+	if server == "" {
+		server = state.Server
+	}
+
+	proxy, err := netproxy.FromConfig(cfg.Proxy)
+	if err != nil {
+		return []Check{{Name: "TLS handshake", Detail: fmt.Sprintf("configure proxy: %v", err)}}
+	}
+
+	keyPath := state.KeyPath
+	if cfg.Agent.ClientKey != "" {
+		keyPath = cfg.Agent.ClientKey
+	}
+
+	mode, err := revocation.ParseMode(cfg.Agent.Revocation.Mode)
+	if err != nil {
+		return []Check{{Name: "TLS handshake", Detail: fmt.Sprintf("agent.revocation.mode: %v", err)}}
+	}
+	checker := &revocation.Checker{
+		Mode:     mode,
+		CRLFiles: cfg.Agent.Revocation.CRLFiles,
+		Proxy:    proxy,
+		Timeout:  cfg.Agent.Revocation.Timeout,
+	}
+
+	tlsCheck := Check{Name: "TLS handshake"}
+	caPEM, err := certs.ReadCABundles(state.CABundles())
+	if err != nil {
+		tlsCheck.Detail = err.Error()
+		return []Check{tlsCheck}
+	}
+	if err := certs.VerifyKeyPairVia(ctx, server, proxy, state.CertPath, keyPath, caPEM, cfg.Agent.ControllerPins, checker); err != nil {
+		tlsCheck.Detail = err.Error()
+	} else {
+		tlsCheck.OK = true
+		tlsCheck.Detail = server
+	}
+	if !tlsCheck.OK {
+		return []Check{tlsCheck}
+	}
+
+	tlsConfig, err := certs.LoadClientTLSConfig(state.CertPath, keyPath, state.CABundles(), server)
+	if err == nil {
+		err = certs.PinControllerSPKI(tlsConfig, cfg.Agent.ControllerPins)
+		certs.CheckControllerRevocation(tlsConfig, checker)
+	}
+	if err != nil {
+		return []Check{tlsCheck, {Name: "upgrade plan", Detail: err.Error()}}
+	}
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: reqstamp.Wrap(&http.Transport{
+			TLSClientConfig: tlsConfig,
+			Proxy:           proxy,
+		}),
+	}
+
+	planCheck := Check{Name: "upgrade plan"}
+	if client, err := upgrade.NewClient(httpClient, server, state.AgentID, nil); err != nil {
+		planCheck.Detail = err.Error()
+	} else if res, err := client.FetchPlan(ctx, state.Upgrade.Channel, ""); err != nil {
+		planCheck.Detail = err.Error()
+	} else {
+		planCheck.OK = true
+		planCheck.Detail = fmt.Sprintf("channel %s, version %s", res.Plan.Channel, res.Plan.Artifact.Version)
+	}
+
+	monitorCheck := Check{Name: "monitor assignments"}
+	if client, err := uplink.NewClient(uplink.Config{ServerURL: server, AgentID: state.AgentID}, uplink.Dependencies{HTTPClient: httpClient}); err != nil {
+		monitorCheck.Detail = err.Error()
+	} else if res, err := client.FetchMonitors(ctx, ""); err != nil {
+		monitorCheck.Detail = err.Error()
+	} else {
+		monitorCheck.OK = true
+		monitorCheck.Detail = fmt.Sprintf("%d monitors (revision %s)", len(res.Snapshot.Monitors), res.Snapshot.Revision)
+	}
+
+	return []Check{tlsCheck, planCheck, monitorCheck}
+}
+
+// writeSummary prints the checks and reports whether all passed.
+func writeSummary(out io.Writer, checks []Check) bool {
+	ready := true
+	fmt.Fprintln(out)
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, c := range checks {
+		status := "OK"
+		if !c.OK {
+			status = "FAIL"
+			ready = false
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", status, c.Name, c.Detail)
+	}
+	tw.Flush()
+	if ready {
+		fmt.Fprintln(out, "\nAgent is set up and ready. Check /readyz on 127.0.0.1:9310 once it is running.")
+	} else {
+		fmt.Fprintln(out, "\nSetup incomplete. Fix the failed checks and re-run `pingsanto-agent setup`.")
+	}
+	return ready
+}
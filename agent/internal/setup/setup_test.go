@@ -0,0 +1,113 @@
+package setup
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+func TestRunPromptsEnrollsAndInstallsUnit(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	dataDir := filepath.Join(dir, "data")
+	configPath := filepath.Join(dir, "agent.yaml")
+	unitPath := filepath.Join(dir, "systemd", "pingsanto-agent.service")
+
+	var enrollArgs []string
+	var commands []string
+	var out bytes.Buffer
+	deps := Dependencies{
+		In:  strings.NewReader("https://central.example.com\ntok-123\nsite=ATL-1\n"),
+		Out: &out,
+		Enroll: func(ctx context.Context, args []string) error {
+			enrollArgs = args
+			return nil
+		},
+		Verify: func(ctx context.Context, path string) []Check {
+			return []Check{{Name: "TLS handshake", OK: true}, {Name: "monitor assignments", OK: true}}
+		},
+		RunCommand: func(ctx context.Context, name string, args ...string) ([]byte, error) {
+			commands = append(commands, name+" "+strings.Join(args, " "))
+			return nil, nil
+		},
+		Executable:       func() (string, error) { return "/usr/local/bin/pingsanto-agent", nil },
+		SystemdAvailable: func() bool { return true },
+	}
+
+	args := []string{"--data-dir", dataDir, "--config-path", configPath, "--systemd-unit", unitPath, "--bootstrap", filepath.Join(dir, "missing.yaml")}
+	if err := Run(ctx, args, deps); err != nil {
+		t.Fatalf("Run: %v\n%s", err, out.String())
+	}
+
+	joined := strings.Join(enrollArgs, " ")
+	for _, want := range []string{"--server https://central.example.com", "--token tok-123", "--labels site=ATL-1"} {
+		if !strings.Contains(joined, want) {
+			t.Fatalf("expected %q in enroll args %v", want, enrollArgs)
+		}
+	}
+
+	cfg, err := config.Load(ctx, configPath)
+	if err != nil {
+		t.Fatalf("load written config: %v", err)
+	}
+	if cfg.Agent.Server != "https://central.example.com" || cfg.Agent.DataDir != dataDir {
+		t.Fatalf("unexpected config %+v", cfg.Agent)
+	}
+
+	unit, err := os.ReadFile(unitPath)
+	if err != nil {
+		t.Fatalf("read unit: %v", err)
+	}
+	if !strings.Contains(string(unit), "ExecStart=/usr/local/bin/pingsanto-agent run --config "+configPath) {
+		t.Fatalf("unexpected unit:\n%s", unit)
+	}
+	if got := strings.Join(commands, "; "); got != "systemctl daemon-reload; systemctl enable pingsanto-agent.service; systemctl restart pingsanto-agent.service" {
+		t.Fatalf("unexpected systemctl calls: %s", got)
+	}
+	if !strings.Contains(out.String(), "Agent is set up and ready") {
+		t.Fatalf("expected ready summary:\n%s", out.String())
+	}
+}
+
+func TestRunNonInteractiveRequiresToken(t *testing.T) {
+	dir := t.TempDir()
+	err := Run(context.Background(), []string{
+		"--non-interactive",
+		"--server", "https://central.example.com",
+		"--data-dir", dir,
+		"--bootstrap", filepath.Join(dir, "missing.yaml"),
+	}, Dependencies{In: strings.NewReader(""), Out: &bytes.Buffer{}})
+	if err == nil || !strings.Contains(err.Error(), "enrollment token is required") {
+		t.Fatalf("expected token error, got %v", err)
+	}
+}
+
+func TestRunReportsFailedChecks(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	err := Run(context.Background(), []string{
+		"--server", "https://central.example.com",
+		"--token", "tok",
+		"--data-dir", dir,
+		"--config-path", filepath.Join(dir, "agent.yaml"),
+		"--bootstrap", filepath.Join(dir, "missing.yaml"),
+		"--skip-systemd",
+	}, Dependencies{
+		Out:    &out,
+		Enroll: func(ctx context.Context, args []string) error { return nil },
+		Verify: func(ctx context.Context, path string) []Check {
+			return []Check{{Name: "TLS handshake", Detail: "x509: certificate signed by unknown authority"}}
+		},
+	})
+	if err == nil {
+		t.Fatalf("expected error when a check fails")
+	}
+	if !strings.Contains(out.String(), "FAIL  TLS handshake") {
+		t.Fatalf("expected failed check in summary:\n%s", out.String())
+	}
+}
@@ -0,0 +1,102 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	initialFetchTimeout = 30 * time.Second
+	reconnectDelay      = 5 * time.Second
+)
+
+// Source keeps the latest SVID from the Workload API for use as the agent's
+// TLS client certificate.
+type Source struct {
+	client *Client
+	logger *log.Logger
+
+	mu       sync.RWMutex
+	svid     SVID
+	onUpdate []func(SVID)
+}
+
+// NewSource fetches the current SVID from endpoint. Call Run to follow
+// rotations afterwards.
+func NewSource(ctx context.Context, endpoint string, logger *log.Logger) (*Source, error) {
+	client, err := NewClient(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, initialFetchTimeout)
+	defer cancel()
+	svid, err := client.Fetch(fetchCtx)
+	if err != nil {
+		return nil, err
+	}
+	logger.Printf("spiffe: obtained SVID %s (expires %s)", svid.ID, svid.Certificate.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	return &Source{client: client, logger: logger, svid: svid}, nil
+}
+
+// SVID returns the current SVID.
+func (s *Source) SVID() SVID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.svid
+}
+
+// OnUpdate registers fn to be called with every rotated SVID.
+func (s *Source) OnUpdate(fn func(SVID)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onUpdate = append(s.onUpdate, fn)
+}
+
+// GetClientCertificate implements tls.Config.GetClientCertificate, so new
+// connections always present the current SVID.
+func (s *Source) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	svid := s.SVID()
+	return &svid.Certificate, nil
+}
+
+// Run follows SVID rotations until ctx ends, reconnecting to the Workload API
+// when the stream breaks. The last SVID stays in use while disconnected.
+func (s *Source) Run(ctx context.Context) error {
+	for {
+		err := s.client.Watch(ctx, s.update)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.logger.Printf("spiffe: %v; reconnecting in %s", err, reconnectDelay)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+func (s *Source) update(svid SVID) {
+	s.mu.Lock()
+	previous := s.svid
+	s.svid = svid
+	callbacks := append([]func(SVID){}, s.onUpdate...)
+	s.mu.Unlock()
+	if previous.Certificate.Leaf != nil && previous.Certificate.Leaf.Equal(svid.Certificate.Leaf) {
+		return
+	}
+	if previous.ID != "" && previous.ID != svid.ID {
+		s.logger.Printf("spiffe: SPIFFE ID changed from %s to %s", previous.ID, svid.ID)
+	}
+	s.logger.Printf("spiffe: rotated SVID %s (expires %s)", svid.ID, svid.Certificate.Leaf.NotAfter.UTC().Format(time.RFC3339))
+	for _, fn := range callbacks {
+		fn(svid)
+	}
+}
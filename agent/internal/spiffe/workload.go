@@ -0,0 +1,291 @@
+// Package spiffe obtains X.509-SVIDs from a SPIFFE Workload API endpoint
+// (e.g. a SPIRE agent socket) and keeps the agent's client certificate
+// current as they rotate.
+//
+// The Workload API is gRPC; this package speaks the single streaming call it
+// needs (FetchX509SVID) over HTTP/2 cleartext on the unix socket, so no gRPC
+// or protobuf dependency is required.
+package spiffe
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const fetchX509SVIDPath = "/SpiffeWorkloadAPI/FetchX509SVID"
+
+// SVID is an X.509 SPIFFE identity document.
+type SVID struct {
+	// ID is the SPIFFE ID, e.g. spiffe://example.org/pingsanto/agent/agt_123.
+	ID string
+	// Certificate holds the SVID chain and its private key, ready for TLS.
+	Certificate tls.Certificate
+	// Bundle is the trust bundle of the SVID's trust domain.
+	Bundle []*x509.Certificate
+}
+
+// Client calls the Workload API on a unix socket.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for endpoint, given as unix:///path/to/socket
+// (the SPIFFE_ENDPOINT_SOCKET format) or a plain socket path.
+func NewClient(endpoint string) (*Client, error) {
+	path, err := socketPath(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{
+		Protocols: protocols,
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return &Client{http: &http.Client{Transport: transport}}, nil
+}
+
+func socketPath(endpoint string) (string, error) {
+	endpoint = strings.TrimSpace(endpoint)
+	if endpoint == "" {
+		return "", errors.New("workload API endpoint is empty")
+	}
+	if !strings.Contains(endpoint, ":") {
+		return endpoint, nil
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("parse workload API endpoint: %w", err)
+	}
+	if u.Scheme != "unix" {
+		return "", fmt.Errorf("workload API endpoint %q: only unix:// sockets are supported", endpoint)
+	}
+	path := u.Path
+	if path == "" {
+		path = u.Opaque
+	}
+	if path == "" {
+		return "", fmt.Errorf("workload API endpoint %q has no socket path", endpoint)
+	}
+	return path, nil
+}
+
+// Watch streams SVID updates to fn until ctx ends or the stream breaks. The
+// Workload API sends the current SVID immediately and again on every
+// rotation.
+func (c *Client) Watch(ctx context.Context, fn func(SVID)) error {
+	// An empty X509SVIDRequest: uncompressed, zero-length gRPC message.
+	body := bytes.NewReader([]byte{0, 0, 0, 0, 0})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost"+fetchX509SVIDPath, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	// Required by the Workload API to reject requests forwarded by proxies.
+	req.Header.Set("workload.spiffe.io", "true")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("workload API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("workload API: unexpected status %s", resp.Status)
+	}
+	if err := grpcStatus(resp.Header); err != nil {
+		return err
+	}
+
+	for {
+		msg, err := readMessage(resp.Body)
+		if errors.Is(err, io.EOF) {
+			if err := grpcStatus(resp.Trailer); err != nil {
+				return err
+			}
+			return errors.New("workload API: stream closed")
+		}
+		if err != nil {
+			return fmt.Errorf("workload API: %w", err)
+		}
+		svid, err := parseX509SVIDResponse(msg)
+		if err != nil {
+			return fmt.Errorf("workload API: %w", err)
+		}
+		fn(svid)
+	}
+}
+
+// Fetch returns the current SVID.
+func (c *Client) Fetch(ctx context.Context) (SVID, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		got   SVID
+		found bool
+	)
+	err := c.Watch(ctx, func(svid SVID) {
+		if !found {
+			got, found = svid, true
+			cancel()
+		}
+	})
+	if found {
+		return got, nil
+	}
+	return SVID{}, err
+}
+
+// grpcStatus converts a non-OK grpc-status header or trailer into an error.
+func grpcStatus(h http.Header) error {
+	code := h.Get("Grpc-Status")
+	if code == "" || code == "0" {
+		return nil
+	}
+	msg, _ := url.PathUnescape(h.Get("Grpc-Message"))
+	return fmt.Errorf("workload API: grpc status %s: %s", code, msg)
+}
+
+// readMessage reads one length-prefixed gRPC message.
+func readMessage(r io.Reader) ([]byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, errors.New("truncated message header")
+		}
+		return nil, err
+	}
+	if header[0] != 0 {
+		return nil, errors.New("compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > 4<<20 {
+		return nil, fmt.Errorf("message of %d bytes is too large", size)
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("truncated message: %w", err)
+	}
+	return msg, nil
+}
+
+// parseX509SVIDResponse decodes the first SVID of an X509SVIDResponse
+// (field 1: repeated X509SVID svids).
+func parseX509SVIDResponse(msg []byte) (SVID, error) {
+	var first []byte
+	err := walkFields(msg, func(num int, value []byte) {
+		if num == 1 && first == nil {
+			first = value
+		}
+	})
+	if err != nil {
+		return SVID{}, err
+	}
+	if first == nil {
+		return SVID{}, errors.New("response contains no SVID")
+	}
+	return parseX509SVID(first)
+}
+
+// parseX509SVID decodes an X509SVID message: spiffe_id (1), x509_svid (2,
+// DER chain), x509_svid_key (3, PKCS#8) and bundle (4, DER certificates).
+func parseX509SVID(msg []byte) (SVID, error) {
+	var id string
+	var chainDER, keyDER, bundleDER []byte
+	err := walkFields(msg, func(num int, value []byte) {
+		switch num {
+		case 1:
+			id = string(value)
+		case 2:
+			chainDER = value
+		case 3:
+			keyDER = value
+		case 4:
+			bundleDER = value
+		}
+	})
+	if err != nil {
+		return SVID{}, err
+	}
+	if !strings.HasPrefix(id, "spiffe://") {
+		return SVID{}, fmt.Errorf("invalid SPIFFE ID %q", id)
+	}
+	chain, err := x509.ParseCertificates(chainDER)
+	if err != nil {
+		return SVID{}, fmt.Errorf("parse SVID certificates: %w", err)
+	}
+	if len(chain) == 0 {
+		return SVID{}, errors.New("SVID has no certificates")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(keyDER)
+	if err != nil {
+		return SVID{}, fmt.Errorf("parse SVID key: %w", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return SVID{}, errors.New("SVID key cannot sign")
+	}
+	bundle, err := x509.ParseCertificates(bundleDER)
+	if err != nil {
+		return SVID{}, fmt.Errorf("parse trust bundle: %w", err)
+	}
+	certificate := tls.Certificate{PrivateKey: signer, Leaf: chain[0]}
+	for _, cert := range chain {
+		certificate.Certificate = append(certificate.Certificate, cert.Raw)
+	}
+	return SVID{ID: id, Certificate: certificate, Bundle: bundle}, nil
+}
+
+// walkFields calls fn for every length-delimited field in a protobuf message
+// and skips the others.
+func walkFields(msg []byte, fn func(num int, value []byte)) error {
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return errors.New("malformed protobuf tag")
+		}
+		msg = msg[n:]
+		num := int(tag >> 3)
+		switch tag & 7 {
+		case 0: // varint
+			if _, n = binary.Uvarint(msg); n <= 0 {
+				return errors.New("malformed protobuf varint")
+			}
+			msg = msg[n:]
+		case 1: // 64-bit
+			if len(msg) < 8 {
+				return errors.New("truncated protobuf field")
+			}
+			msg = msg[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(msg)
+			if n <= 0 || uint64(len(msg)-n) < size {
+				return errors.New("truncated protobuf field")
+			}
+			fn(num, msg[n:n+int(size)])
+			msg = msg[n+int(size):]
+		case 5: // 32-bit
+			if len(msg) < 4 {
+				return errors.New("truncated protobuf field")
+			}
+			msg = msg[4:]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d", tag&7)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package spiffe
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSourceFollowsRotation(t *testing.T) {
+	first := mustSVIDMessage(t, "spiffe://example.org/pingsanto/agent/agt_1", 1)
+	second := mustSVIDMessage(t, "spiffe://example.org/pingsanto/agent/agt_1", 2)
+
+	endpoint := serveWorkloadAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("workload.spiffe.io") != "true" || r.URL.Path != fetchX509SVIDPath {
+			w.Header().Set("Grpc-Status", "3")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.WriteHeader(http.StatusOK)
+		for _, msg := range [][]byte{first, second} {
+			w.Write(grpcFrame(msg))
+			w.(http.Flusher).Flush()
+		}
+		<-r.Context().Done()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	source, err := NewSource(ctx, endpoint, nil)
+	if err != nil {
+		t.Fatalf("NewSource: %v", err)
+	}
+	svid := source.SVID()
+	if svid.ID != "spiffe://example.org/pingsanto/agent/agt_1" || svid.Certificate.Leaf.SerialNumber.Int64() != 1 || len(svid.Bundle) != 1 {
+		t.Fatalf("unexpected initial SVID %+v", svid)
+	}
+
+	rotated := make(chan SVID, 1)
+	source.OnUpdate(func(svid SVID) { rotated <- svid })
+	go source.Run(ctx)
+
+	select {
+	case svid := <-rotated:
+		if svid.Certificate.Leaf.SerialNumber.Int64() != 2 {
+			t.Fatalf("expected rotated serial 2, got %v", svid.Certificate.Leaf.SerialNumber)
+		}
+	case <-ctx.Done():
+		t.Fatalf("rotation not observed")
+	}
+	cert, err := source.GetClientCertificate(nil)
+	if err != nil || cert.Leaf.SerialNumber.Int64() != 2 {
+		t.Fatalf("expected TLS to present the rotated SVID, got %v, %v", cert, err)
+	}
+}
+
+func TestFetchReportsWorkloadAPIErrors(t *testing.T) {
+	endpoint := serveWorkloadAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Grpc-Status", "7")
+		w.Header().Set("Grpc-Message", url.PathEscape("no identity issued"))
+		w.WriteHeader(http.StatusOK)
+	})
+	client, err := NewClient(endpoint)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := client.Fetch(ctx); err == nil || !strings.Contains(err.Error(), "grpc status 7: no identity issued") {
+		t.Fatalf("expected grpc status error, got %v", err)
+	}
+
+	if _, err := NewClient("tcp://127.0.0.1:8081"); err == nil {
+		t.Fatalf("expected non-unix endpoint to be rejected")
+	}
+}
+
+// serveWorkloadAPI serves handler over cleartext HTTP/2 on a unix socket and
+// returns its unix:// endpoint.
+func serveWorkloadAPI(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	srv := &http.Server{Handler: handler, Protocols: protocols}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return "unix://" + path
+}
+
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+// mustSVIDMessage builds an X509SVIDResponse holding one self-signed SVID.
+func mustSVIDMessage(t *testing.T, id string, serial int64) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	uri, _ := url.Parse(id)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "agent"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		URIs:         []*url.URL{uri},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	var svid []byte
+	svid = appendField(svid, 1, []byte(id))
+	svid = appendField(svid, 2, der)
+	svid = appendField(svid, 3, keyDER)
+	svid = appendField(svid, 4, der)
+	return appendField(nil, 1, svid)
+}
+
+func appendField(b []byte, num int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num<<3|2))
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
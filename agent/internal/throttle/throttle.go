@@ -0,0 +1,102 @@
+// Package throttle interprets server-driven load shedding: HTTP 429 responses
+// and 503 responses carrying Retry-After. Clients convert such responses into
+// *Error so the loops driving them can back off for the requested duration.
+package throttle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetryAfter applies when a 429 carries no usable Retry-After header.
+	DefaultRetryAfter = 30 * time.Second
+	// MaxRetryAfter caps server-provided delays so a bad header cannot stall the agent.
+	MaxRetryAfter = 15 * time.Minute
+)
+
+// Error reports that the server asked the client to slow down.
+type Error struct {
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("throttled by server (%s), retry after %s", e.Status, e.RetryAfter)
+}
+
+// FromResponse returns an *Error when resp signals load shedding, otherwise nil.
+func FromResponse(resp *http.Response, now time.Time) error {
+	if resp == nil {
+		return nil
+	}
+	delay, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), now)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		if !ok {
+			delay = DefaultRetryAfter
+		}
+	case http.StatusServiceUnavailable:
+		if !ok {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &Error{Status: resp.Status, RetryAfter: delay}
+}
+
+// ParseRetryAfter accepts both delta-seconds and HTTP-date forms.
+func ParseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	var delay time.Duration
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		delay = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		delay = at.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+	if delay > MaxRetryAfter {
+		delay = MaxRetryAfter
+	}
+	return delay, true
+}
+
+// Delay extracts the requested back-off from err, if it wraps an *Error.
+func Delay(err error) (time.Duration, bool) {
+	var terr *Error
+	if errors.As(err, &terr) {
+		return terr.RetryAfter, true
+	}
+	return 0, false
+}
+
+// Sleep blocks for d or until ctx is done, returning ctx.Err() in the latter case.
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,50 @@
+package throttle
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFromResponse(t *testing.T) {
+	now := time.Date(2025, 10, 22, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name   string
+		status int
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"429 seconds", http.StatusTooManyRequests, "120", 2 * time.Minute, true},
+		{"429 default", http.StatusTooManyRequests, "", DefaultRetryAfter, true},
+		{"429 http date", http.StatusTooManyRequests, now.Add(45 * time.Second).Format(http.TimeFormat), 45 * time.Second, true},
+		{"429 capped", http.StatusTooManyRequests, "86400", MaxRetryAfter, true},
+		{"503 with header", http.StatusServiceUnavailable, "5", 5 * time.Second, true},
+		{"503 without header", http.StatusServiceUnavailable, "", 0, false},
+		{"500 ignored", http.StatusInternalServerError, "5", 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tc.status, Status: http.StatusText(tc.status), Header: http.Header{}}
+			if tc.header != "" {
+				resp.Header.Set("Retry-After", tc.header)
+			}
+			err := FromResponse(resp, now)
+			delay, ok := Delay(fmt.Errorf("wrapped: %w", err))
+			if err == nil {
+				delay, ok = 0, false
+			}
+			if ok != tc.ok || delay != tc.want {
+				t.Fatalf("got delay=%s ok=%t, want %s %t", delay, ok, tc.want, tc.ok)
+			}
+		})
+	}
+}
+
+func TestDelayIgnoresOtherErrors(t *testing.T) {
+	if _, ok := Delay(errors.New("boom")); ok {
+		t.Fatalf("expected plain errors to carry no delay")
+	}
+}
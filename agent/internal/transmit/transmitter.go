@@ -7,6 +7,7 @@ import (
 
 	"github.com/pingsantohq/agent/internal/backfill"
 	"github.com/pingsantohq/agent/internal/queue"
+	"github.com/pingsantohq/agent/internal/throttle"
 	"github.com/pingsantohq/agent/pkg/types"
 )
 
@@ -34,6 +35,16 @@ func WithBatchSize(size int) Option {
 	}
 }
 
+// WithMaxLatency bounds how long a partial batch may wait in the live queue
+// before it is flushed. Zero flushes whatever is available on every pass.
+func WithMaxLatency(d time.Duration) Option {
+	return func(t *Transmitter) {
+		if d >= 0 {
+			t.maxLatency = d
+		}
+	}
+}
+
 // WithIdleSleep customises the sleep interval when no data is available.
 func WithIdleSleep(d time.Duration) Option {
 	return func(t *Transmitter) {
@@ -52,6 +63,14 @@ func WithRetrySleep(d time.Duration) Option {
 	}
 }
 
+// WithSendObserver registers fn to be called after every send attempt with
+// its outcome, nil on success.
+func WithSendObserver(fn func(error)) Option {
+	return func(t *Transmitter) {
+		t.observe = fn
+	}
+}
+
 // Transmitter drains live results from the in-memory queue and replays buffered
 // data from the backfill controller, handing both streams to a downstream sink.
 type Transmitter struct {
@@ -59,18 +78,31 @@ type Transmitter struct {
 	backfill   *backfill.Controller
 	sink       Sink
 	batchSize  int
+	maxLatency time.Duration
 	idleSleep  time.Duration
 	retrySleep time.Duration
+	now        func() time.Time
+	observe    func(error)
+
+	pendingSince time.Time
 }
 
+// Defaults used when WithBatchSize or WithMaxLatency is not given.
+const (
+	DefaultBatchSize  = 256
+	DefaultMaxLatency = time.Second
+)
+
 // New constructs a Transmitter. The queue and sink are required.
 func New(queue *queue.ResultQueue, sink Sink, opts ...Option) *Transmitter {
 	t := &Transmitter{
 		queue:      queue,
 		sink:       sink,
-		batchSize:  256,
+		batchSize:  DefaultBatchSize,
+		maxLatency: DefaultMaxLatency,
 		idleSleep:  100 * time.Millisecond,
 		retrySleep: 200 * time.Millisecond,
+		now:        time.Now,
 	}
 	for _, opt := range opts {
 		opt(t)
@@ -79,8 +111,9 @@ func New(queue *queue.ResultQueue, sink Sink, opts ...Option) *Transmitter {
 }
 
 // Run blocks until the context is cancelled or an unrecoverable error occurs.
-// It prioritises draining the live queue, falling back to replaying persisted
-// batches through the backfill controller when idle.
+// Live results are coalesced and flushed once a full batch is available or the
+// oldest buffered result has waited maxLatency. Persisted batches are replayed
+// through the backfill controller only while the live queue is empty.
 func (t *Transmitter) Run(ctx context.Context) error {
 	if t.queue == nil {
 		return errors.New("transmitter queue is nil")
@@ -94,10 +127,14 @@ func (t *Transmitter) Run(ctx context.Context) error {
 			return err
 		}
 
-		sent := t.flushQueue(ctx)
+		sent, wait := t.flushQueue(ctx)
 		if sent {
 			continue
 		}
+		if wait > 0 {
+			t.sleep(ctx, minDuration(wait, t.idleSleep))
+			continue
+		}
 
 		replayed, err := t.flushBackfill(ctx)
 		if err != nil {
@@ -119,21 +156,49 @@ func (t *Transmitter) Run(ctx context.Context) error {
 	}
 }
 
-func (t *Transmitter) flushQueue(ctx context.Context) bool {
+// flushQueue sends a batch from the live queue when it is due. When results are
+// buffered but neither the size nor the latency bound has been reached, it
+// returns the remaining time until the deadline.
+func (t *Transmitter) flushQueue(ctx context.Context) (bool, time.Duration) {
+	pending := t.queue.Len()
+	if pending == 0 {
+		t.pendingSince = time.Time{}
+		return false, 0
+	}
+	now := t.now()
+	if t.pendingSince.IsZero() {
+		t.pendingSince = now
+	}
+	if pending < t.batchSize {
+		if remaining := t.maxLatency - now.Sub(t.pendingSince); remaining > 0 {
+			return false, remaining
+		}
+	}
+
+	since := t.pendingSince
 	results := t.queue.Drain(t.batchSize)
 	if len(results) == 0 {
-		return false
+		t.pendingSince = time.Time{}
+		return false, 0
+	}
+	if t.queue.Len() == 0 {
+		t.pendingSince = time.Time{}
+	} else {
+		t.pendingSince = now
 	}
 
-	if err := t.sink.Send(ctx, results); err != nil {
+	if err := t.send(ctx, results); err != nil {
 		for _, res := range results {
 			t.queue.Enqueue(res)
 		}
-		t.sleep(ctx, t.retrySleep)
-		return true
+		// Re-queued results keep their original deadline so the retry is not
+		// delayed by another full latency window.
+		t.pendingSince = since
+		t.sleep(ctx, t.backoff(err))
+		return true, 0
 	}
 
-	return true
+	return true, 0
 }
 
 func (t *Transmitter) flushBackfill(ctx context.Context) (bool, error) {
@@ -149,8 +214,8 @@ func (t *Transmitter) flushBackfill(ctx context.Context) (bool, error) {
 		return false, nil
 	}
 
-	if err := t.sink.Send(ctx, batch.Results); err != nil {
-		t.sleep(ctx, t.retrySleep)
+	if err := t.send(ctx, batch.Results); err != nil {
+		t.sleep(ctx, t.backoff(err))
 		return true, nil
 	}
 
@@ -161,6 +226,29 @@ func (t *Transmitter) flushBackfill(ctx context.Context) (bool, error) {
 	return true, nil
 }
 
+func (t *Transmitter) send(ctx context.Context, results []types.ProbeResult) error {
+	err := t.sink.Send(ctx, results)
+	if t.observe != nil && ctx.Err() == nil {
+		t.observe(err)
+	}
+	return err
+}
+
+// backoff returns the server-requested delay for throttled sends, otherwise the retry sleep.
+func (t *Transmitter) backoff(err error) time.Duration {
+	if delay, ok := throttle.Delay(err); ok && delay > t.retrySleep {
+		return delay
+	}
+	return t.retrySleep
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if b > 0 && b < a {
+		return b
+	}
+	return a
+}
+
 func (t *Transmitter) sleep(ctx context.Context, d time.Duration) {
 	if d <= 0 {
 		return
@@ -11,6 +11,7 @@ import (
 	"github.com/pingsantohq/agent/internal/backfill"
 	"github.com/pingsantohq/agent/internal/queue"
 	"github.com/pingsantohq/agent/internal/queue/persist"
+	"github.com/pingsantohq/agent/internal/throttle"
 	"github.com/pingsantohq/agent/pkg/types"
 )
 
@@ -126,7 +127,7 @@ func TestTransmitterPrefersLiveQueue(t *testing.T) {
 	q.Enqueue(types.ProbeResult{MonitorID: "live-2"})
 
 	sink := newRecordingSink()
-	tx := New(q, sink, WithBackfill(ctrl), WithIdleSleep(10*time.Millisecond), WithRetrySleep(10*time.Millisecond))
+	tx := New(q, sink, WithBackfill(ctrl), WithMaxLatency(0), WithIdleSleep(10*time.Millisecond), WithRetrySleep(10*time.Millisecond))
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -153,6 +154,72 @@ func TestTransmitterPrefersLiveQueue(t *testing.T) {
 	}
 }
 
+func TestTransmitterCoalescesUntilBatchSize(t *testing.T) {
+	q := queue.NewResultQueue(8)
+	sink := newRecordingSink()
+	tx := New(q, sink, WithBatchSize(3), WithMaxLatency(time.Hour), WithIdleSleep(5*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tx.Run(ctx)
+	}()
+
+	q.Enqueue(types.ProbeResult{MonitorID: "a"})
+	q.Enqueue(types.ProbeResult{MonitorID: "b"})
+	if _, ok := sink.waitForBatch(1, 50*time.Millisecond); ok {
+		t.Fatalf("expected partial batch to be held until full")
+	}
+
+	q.Enqueue(types.ProbeResult{MonitorID: "c"})
+	batch, ok := sink.waitForBatch(1, time.Second)
+	if !ok {
+		t.Fatalf("expected full batch to flush")
+	}
+	if len(batch) != 3 {
+		t.Fatalf("expected 3 results in batch, got %d", len(batch))
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
+func TestTransmitterFlushesPartialBatchAfterMaxLatency(t *testing.T) {
+	q := queue.NewResultQueue(8)
+	sink := newRecordingSink()
+	tx := New(q, sink, WithBatchSize(100), WithMaxLatency(30*time.Millisecond), WithIdleSleep(5*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- tx.Run(ctx)
+	}()
+
+	start := time.Now()
+	q.Enqueue(types.ProbeResult{MonitorID: "late"})
+	batch, ok := sink.waitForBatch(1, time.Second)
+	if !ok {
+		t.Fatalf("expected partial batch to flush after deadline")
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("expected flush after max latency, got %s", elapsed)
+	}
+	if len(batch) != 1 || batch[0].MonitorID != "late" {
+		t.Fatalf("unexpected batch contents: %+v", batch)
+	}
+
+	cancel()
+	if err := <-errCh; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+}
+
 type recordingSink struct {
 	mu      sync.Mutex
 	batches [][]types.ProbeResult
@@ -268,3 +335,50 @@ func waitUntil(t *testing.T, timeout time.Duration, fn func() bool) {
 		t.Fatalf("condition not met within %s", timeout)
 	}
 }
+
+func TestTransmitterBackoffHonoursServerThrottle(t *testing.T) {
+	tx := New(queue.NewResultQueue(1), nil, WithRetrySleep(200*time.Millisecond))
+	if got := tx.backoff(errors.New("boom")); got != 200*time.Millisecond {
+		t.Fatalf("expected retry sleep for plain errors, got %s", got)
+	}
+	if got := tx.backoff(&throttle.Error{RetryAfter: 5 * time.Second}); got != 5*time.Second {
+		t.Fatalf("expected server delay, got %s", got)
+	}
+}
+
+func TestTransmitterReportsSendOutcomes(t *testing.T) {
+	q := queue.NewResultQueue(10)
+	sink := newFailOnceSink()
+	var mu sync.Mutex
+	var outcomes []error
+	tx := New(q, sink,
+		WithMaxLatency(0),
+		WithRetrySleep(5*time.Millisecond),
+		WithSendObserver(func(err error) {
+			mu.Lock()
+			outcomes = append(outcomes, err)
+			mu.Unlock()
+		}),
+	)
+	q.Enqueue(types.ProbeResult{MonitorID: "m1"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go tx.Run(ctx)
+
+	<-sink.first
+	waitUntil(t, time.Second, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(outcomes) >= 1
+	})
+	close(sink.allow)
+	waitUntil(t, time.Second, func() bool { return len(sink.Results()) == 1 })
+	cancel()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if outcomes[0] == nil || outcomes[len(outcomes)-1] != nil {
+		t.Fatalf("expected failure then success, got %v", outcomes)
+	}
+}
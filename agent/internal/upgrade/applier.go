@@ -2,6 +2,7 @@ package upgrade
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
 	"context"
 	"crypto/sha256"
@@ -13,12 +14,21 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/pingsantohq/agent/internal/config"
 )
 
+const (
+	artifactFileName = "artifact.tar.gz"
+	// deltaFormatBSDiff and deltaFormatZstd are the delta formats the
+	// applier can patch.
+	deltaFormatBSDiff = "bsdiff"
+	deltaFormatZstd   = "zstd"
+)
+
 // SignatureVerifier validates artifact signatures when provided.
 type SignatureVerifier interface {
 	Verify(ctx context.Context, artifactPath, signaturePath string) error
@@ -32,6 +42,9 @@ type ApplyResult struct {
 	BundlePath      string
 	ArtifactPath    string
 	BinaryPath      string
+	// DeltaFrom is the base version when the artifact was rebuilt from a
+	// delta patch instead of downloaded.
+	DeltaFrom string
 }
 
 // PlanApplier applies upgrade plans and returns the outcome.
@@ -46,6 +59,15 @@ type Applier struct {
 	Verifier   SignatureVerifier
 	Logger     *log.Logger
 	Now        func() time.Time
+	// DownloadRateLimit caps download throughput in bytes per second; zero
+	// means unlimited.
+	DownloadRateLimit int64
+}
+
+// SetDownloadRateLimit sets DownloadRateLimit; the upgrade manager calls it
+// with Config.DownloadRateLimit.
+func (a *Applier) SetDownloadRateLimit(bytesPerSecond int64) {
+	a.DownloadRateLimit = bytesPerSecond
 }
 
 // Apply performs the upgrade stages and returns the resulting metadata.
@@ -67,25 +89,34 @@ func (a *Applier) Apply(ctx context.Context, plan Plan, state config.State) (App
 	}
 
 	bundleDir := filepath.Join(a.DataDir, "upgrades", plan.Artifact.Version)
-	if err := os.RemoveAll(bundleDir); err != nil {
+	if err := clearBundleDir(bundleDir); err != nil {
 		return result, fmt.Errorf("clear bundle dir: %w", err)
 	}
 	if err := os.MkdirAll(bundleDir, 0o755); err != nil {
 		return result, fmt.Errorf("create bundle dir: %w", err)
 	}
-
-	artifactPath := filepath.Join(bundleDir, "artifact.tar.gz")
-	if err := a.download(ctx, plan.Artifact.URL, artifactPath); err != nil {
+	if err := checkDiskSpace(bundleDir, plan.Artifact.Size); err != nil {
 		return result, err
 	}
-	if err := verifySHA256(artifactPath, plan.Artifact.SHA256); err != nil {
-		return result, err
+
+	artifactPath := filepath.Join(bundleDir, artifactFileName)
+	if delta, ok := selectDelta(plan.Artifact, state.Upgrade.Applied.Version); ok {
+		if err := a.applyDelta(ctx, delta, plan.Artifact, bundleDir, artifactPath); err != nil {
+			a.logf("upgrade applier: delta from %s unusable, downloading full artifact: %v", delta.FromVersion, err)
+		} else {
+			result.DeltaFrom = delta.FromVersion
+		}
+	}
+	if result.DeltaFrom == "" {
+		if err := a.fetchFirst(ctx, artifactURLs(plan.Artifact), artifactPath, plan.Artifact.SHA256); err != nil {
+			return result, err
+		}
 	}
 	result.ArtifactPath = artifactPath
 
-	if plan.Artifact.SignatureURL != "" {
+	if signatureURLs := signatureURLs(plan.Artifact); len(signatureURLs) > 0 {
 		signaturePath := filepath.Join(bundleDir, "artifact.sig")
-		if err := a.download(ctx, plan.Artifact.SignatureURL, signaturePath); err != nil {
+		if err := a.fetchFirst(ctx, signatureURLs, signaturePath, ""); err != nil {
 			return result, err
 		}
 		if a.Verifier != nil {
@@ -114,39 +145,165 @@ func (a *Applier) Apply(ctx context.Context, plan Plan, state config.State) (App
 	return result, nil
 }
 
-func (a *Applier) download(ctx context.Context, url, dest string) error {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// artifactURLs lists the artifact's primary URL followed by its mirrors.
+func artifactURLs(artifact PlanArtifact) []string {
+	urls := []string{artifact.URL}
+	for _, mirror := range artifact.Mirrors {
+		urls = append(urls, mirror.URL)
+	}
+	return urls
+}
+
+// signatureURLs lists every distinct signature location, primary first.
+func signatureURLs(artifact PlanArtifact) []string {
+	var urls []string
+	candidates := []string{artifact.SignatureURL}
+	for _, mirror := range artifact.Mirrors {
+		candidates = append(candidates, mirror.SignatureURL)
+	}
+	for _, url := range candidates {
+		if url != "" && !slices.Contains(urls, url) {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// fetchFirst downloads dest from the first of urls that serves it, checking
+// wantSHA256 when set. A host that is down or serves a corrupt copy is
+// skipped; the error of the last one is returned if none succeeds.
+func (a *Applier) fetchFirst(ctx context.Context, urls []string, dest, wantSHA256 string) error {
+	var err error
+	for i, url := range urls {
+		if i > 0 {
+			a.logf("upgrade applier: %v; trying mirror %s", err, url)
+		}
+		if err = a.download(ctx, url, dest); err == nil {
+			if err = verifySHA256(dest, wantSHA256); err == nil {
+				return nil
+			}
+			os.Remove(dest)
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// selectDelta picks the supported patch whose base is the installed version.
+// Deltas are only used when the full artifact checksum is known, since that
+// checksum is what proves the patched result correct.
+func selectDelta(artifact PlanArtifact, installed string) (PlanDelta, bool) {
+	if installed == "" || strings.TrimSpace(artifact.SHA256) == "" {
+		return PlanDelta{}, false
+	}
+	for _, delta := range artifact.Deltas {
+		if delta.FromVersion == installed && supportedDeltaFormat(delta.Format) && delta.URL != "" && delta.BaseSHA256 != "" {
+			return delta, true
+		}
+	}
+	return PlanDelta{}, false
+}
+
+func supportedDeltaFormat(format string) bool {
+	return format == deltaFormatBSDiff || format == deltaFormatZstd
+}
+
+// applyDelta rebuilds the plan artifact at dest from the installed version's
+// artifact and a downloaded patch. The result may not grow beyond the
+// artifact's declared size. Any error leaves the caller to fall back to the
+// full download.
+func (a *Applier) applyDelta(ctx context.Context, delta PlanDelta, artifact PlanArtifact, bundleDir, dest string) error {
+	basePath := filepath.Join(a.DataDir, "upgrades", delta.FromVersion, artifactFileName)
+	if err := verifySHA256(basePath, delta.BaseSHA256); err != nil {
+		return fmt.Errorf("base artifact: %w", err)
+	}
+	patchPath := filepath.Join(bundleDir, "artifact.patch")
+	defer os.Remove(patchPath)
+	if err := a.download(ctx, delta.URL, patchPath); err != nil {
+		return err
+	}
+	if err := verifySHA256(patchPath, delta.SHA256); err != nil {
+		return fmt.Errorf("patch: %w", err)
+	}
+	limit := int64(maxPatchedSize)
+	if artifact.Size > 0 {
+		limit = artifact.Size
+	}
+	if err := patchFile(dest, delta.Format, basePath, patchPath, limit); err != nil {
+		os.Remove(dest)
+		return err
+	}
+	if err := verifySHA256(dest, artifact.SHA256); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("patched artifact: %w", err)
+	}
+	return nil
+}
+
+// patchFile writes the result of applying the patch at patchPath to the
+// file at basePath into dest.
+func patchFile(dest, format, basePath, patchPath string, limit int64) error {
+	patch, err := os.Open(patchPath)
 	if err != nil {
-		return fmt.Errorf("build request for %s: %w", url, err)
+		return fmt.Errorf("open patch: %w", err)
 	}
-	resp, err := a.HTTPClient.Do(req)
+	defer patch.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
 	if err != nil {
-		return fmt.Errorf("download %s: %w", url, err)
+		return fmt.Errorf("create %s: %w", dest, err)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("download %s: status %s", url, resp.Status)
+	defer out.Close()
+	w := bufio.NewWriter(out)
+
+	if format == deltaFormatZstd {
+		err = zstdPatchFile(w, basePath, patch, limit)
+	} else {
+		err = bspatchFile(w, basePath, patch, limit)
 	}
+	if err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write %s: %w", dest, err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("write %s: %w", dest, err)
+	}
+	return nil
+}
 
-	tmp := dest + ".tmp"
-	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+func bspatchFile(out io.Writer, basePath string, patch *os.File, limit int64) error {
+	base, err := os.Open(basePath)
+	if err != nil {
+		return fmt.Errorf("open base artifact: %w", err)
+	}
+	defer base.Close()
+	baseInfo, err := base.Stat()
 	if err != nil {
-		return fmt.Errorf("create %s: %w", tmp, err)
+		return fmt.Errorf("stat base artifact: %w", err)
 	}
-	if _, err := io.Copy(file, resp.Body); err != nil {
-		file.Close()
-		os.Remove(tmp)
-		return fmt.Errorf("write %s: %w", tmp, err)
+	patchInfo, err := patch.Stat()
+	if err != nil {
+		return fmt.Errorf("stat patch: %w", err)
 	}
-	if err := file.Close(); err != nil {
-		os.Remove(tmp)
-		return fmt.Errorf("close %s: %w", tmp, err)
+	return bspatch(out, io.NewSectionReader(base, 0, baseInfo.Size()), io.NewSectionReader(patch, 0, patchInfo.Size()), limit)
+}
+
+// zstdPatchFile reads the whole base, which zstd uses as its dictionary.
+func zstdPatchFile(out io.Writer, basePath string, patch *os.File, limit int64) error {
+	base, err := os.ReadFile(basePath)
+	if err != nil {
+		return fmt.Errorf("read base artifact: %w", err)
 	}
-	if err := os.Rename(tmp, dest); err != nil {
-		os.Remove(tmp)
-		return fmt.Errorf("commit %s: %w", dest, err)
+	return zstdPatch(out, base, patch, limit)
+}
+
+func (a *Applier) logf(format string, args ...any) {
+	if a.Logger != nil {
+		a.Logger.Printf(format, args...)
 	}
-	return nil
 }
 
 func verifySHA256(path string, expected string) error {
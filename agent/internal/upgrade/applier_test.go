@@ -8,11 +8,13 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
@@ -283,3 +285,321 @@ func buildTarGz(t *testing.T, files map[string]string) []byte {
 	}
 	return buf.Bytes()
 }
+
+func TestApplierApplyDelta(t *testing.T) {
+	for _, tc := range []struct{ format, patch string }{
+		{format: "bsdiff", patch: "testdata/agent_1.0.0_to_1.1.0.bsdiff"},
+		{format: "zstd", patch: "testdata/agent_1.0.0_to_1.1.0.zst"},
+	} {
+		t.Run(tc.format, func(t *testing.T) {
+			ctx := context.Background()
+			base, err := os.ReadFile("testdata/agent_1.0.0.tar.gz")
+			if err != nil {
+				t.Fatalf("read base: %v", err)
+			}
+			target, err := os.ReadFile("testdata/agent_1.1.0.tar.gz")
+			if err != nil {
+				t.Fatalf("read target: %v", err)
+			}
+			patch, err := os.ReadFile(tc.patch)
+			if err != nil {
+				t.Fatalf("read patch: %v", err)
+			}
+			baseSum := sha256.Sum256(base)
+			targetSum := sha256.Sum256(target)
+
+			var fullDownloads int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/artifact":
+					fullDownloads++
+					w.Write(target)
+				case "/patch":
+					w.Write(patch)
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			plan := Plan{
+				Artifact: PlanArtifact{
+					Version: "1.1.0",
+					URL:     server.URL + "/artifact",
+					SHA256:  hex.EncodeToString(targetSum[:]),
+					Deltas: []PlanDelta{{
+						FromVersion: "1.0.0",
+						Format:      tc.format,
+						URL:         server.URL + "/patch",
+						BaseSHA256:  hex.EncodeToString(baseSum[:]),
+					}},
+				},
+			}
+			state := config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}
+
+			run := func(installed []byte) ApplyResult {
+				t.Helper()
+				dataDir := t.TempDir()
+				baseDir := filepath.Join(dataDir, "upgrades", "1.0.0")
+				if err := os.MkdirAll(baseDir, 0o755); err != nil {
+					t.Fatalf("mkdir: %v", err)
+				}
+				if err := os.WriteFile(filepath.Join(baseDir, "artifact.tar.gz"), installed, 0o600); err != nil {
+					t.Fatalf("write base: %v", err)
+				}
+				applier := &Applier{DataDir: dataDir, HTTPClient: server.Client()}
+				result, err := applier.Apply(ctx, plan, state)
+				if err != nil {
+					t.Fatalf("Apply: %v", err)
+				}
+				got, err := os.ReadFile(result.ArtifactPath)
+				if err != nil || !bytes.Equal(got, target) {
+					t.Fatalf("unexpected artifact contents (%v)", err)
+				}
+				if filepath.Base(result.BinaryPath) != "pingsanto-agent" {
+					t.Fatalf("unexpected binary path %q", result.BinaryPath)
+				}
+				return result
+			}
+
+			if result := run(base); result.DeltaFrom != "1.0.0" || fullDownloads != 0 {
+				t.Fatalf("expected delta upgrade, got %+v with %d full downloads", result, fullDownloads)
+			}
+			if result := run([]byte("locally modified")); result.DeltaFrom != "" || fullDownloads != 1 {
+				t.Fatalf("expected fallback to full artifact, got %+v with %d full downloads", result, fullDownloads)
+			}
+		})
+	}
+}
+
+func TestBSPatchRejectsCorruptPatch(t *testing.T) {
+	patch, err := os.ReadFile("testdata/agent_1.0.0_to_1.1.0.bsdiff")
+	if err != nil {
+		t.Fatalf("read patch: %v", err)
+	}
+	if err := bspatch(io.Discard, sectionOf(nil), sectionOf(patch[:40]), maxPatchedSize); !errors.Is(err, errCorruptPatch) {
+		t.Fatalf("expected truncated patch to be rejected, got %v", err)
+	}
+	if err := bspatch(io.Discard, sectionOf(nil), sectionOf([]byte("not a patch")), maxPatchedSize); !errors.Is(err, errCorruptPatch) {
+		t.Fatalf("expected bad header to be rejected, got %v", err)
+	}
+}
+
+func TestDeltaPatchRejectsOutputBeyondLimit(t *testing.T) {
+	base, err := os.ReadFile("testdata/agent_1.0.0.tar.gz")
+	if err != nil {
+		t.Fatalf("read base: %v", err)
+	}
+	target, err := os.ReadFile("testdata/agent_1.1.0.tar.gz")
+	if err != nil {
+		t.Fatalf("read target: %v", err)
+	}
+	bsdiffPatch, err := os.ReadFile("testdata/agent_1.0.0_to_1.1.0.bsdiff")
+	if err != nil {
+		t.Fatalf("read bsdiff patch: %v", err)
+	}
+	zstdPatchBytes, err := os.ReadFile("testdata/agent_1.0.0_to_1.1.0.zst")
+	if err != nil {
+		t.Fatalf("read zstd patch: %v", err)
+	}
+
+	limit := int64(len(target))
+	var out bytes.Buffer
+	if err := bspatch(&out, sectionOf(base), sectionOf(bsdiffPatch), limit); err != nil || !bytes.Equal(out.Bytes(), target) {
+		t.Fatalf("expected bsdiff patch within limit to apply, got %v", err)
+	}
+	out.Reset()
+	if err := bspatch(&out, sectionOf(base), sectionOf(bsdiffPatch), limit-1); !errors.Is(err, errCorruptPatch) || out.Len() != 0 {
+		t.Fatalf("expected bsdiff output beyond limit to be rejected before writing, got %v with %d bytes", err, out.Len())
+	}
+	out.Reset()
+	if err := zstdPatch(&out, base, bytes.NewReader(zstdPatchBytes), limit); err != nil || !bytes.Equal(out.Bytes(), target) {
+		t.Fatalf("expected zstd patch within limit to apply, got %v", err)
+	}
+	if err := zstdPatch(io.Discard, base, bytes.NewReader(zstdPatchBytes), limit-1); !errors.Is(err, errCorruptPatch) {
+		t.Fatalf("expected zstd output beyond limit to be rejected, got %v", err)
+	}
+}
+
+func sectionOf(b []byte) *io.SectionReader {
+	return io.NewSectionReader(bytes.NewReader(b), 0, int64(len(b)))
+}
+
+func TestApplierResumesInterruptedDownload(t *testing.T) {
+	ctx := context.Background()
+	backoff := downloadBackoff
+	downloadBackoff = 0
+	t.Cleanup(func() { downloadBackoff = backoff })
+
+	artifactBytes := buildTarGz(t, map[string]string{"pingsanto-agent": string(bytes.Repeat([]byte("x"), 64<<10))})
+	sum := sha256.Sum256(artifactBytes)
+	modTime := time.Unix(1730000000, 0)
+
+	var ranges []string
+	var drop bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if drop {
+			// Send half the body, then kill the connection.
+			drop = false
+			w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+			w.Header().Set("Content-Length", strconv.Itoa(len(artifactBytes)))
+			w.Write(artifactBytes[:len(artifactBytes)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "artifact.tar.gz", modTime, bytes.NewReader(artifactBytes))
+	}))
+	t.Cleanup(server.Close)
+
+	plan := Plan{Artifact: PlanArtifact{Version: "1.1.0", URL: server.URL, SHA256: hex.EncodeToString(sum[:])}}
+	applier := &Applier{DataDir: t.TempDir(), HTTPClient: server.Client()}
+
+	// Dropped mid-transfer, then resumed within the same Apply.
+	drop = true
+	result, err := applier.Apply(ctx, plan, config.State{})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := fmt.Sprintf("bytes=%d-", len(artifactBytes)/2)
+	if len(ranges) != 2 || ranges[0] != "" || ranges[1] != want {
+		t.Fatalf("expected a full request then %q, got %q", want, ranges)
+	}
+	if _, err := os.Stat(result.ArtifactPath + partialSuffix); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected partial file to be committed, got %v", err)
+	}
+
+	// A partial left behind by an earlier Apply survives the bundle reset.
+	bundleDir := filepath.Dir(result.ArtifactPath)
+	os.WriteFile(result.ArtifactPath+partialSuffix, artifactBytes[:100], 0o600)
+	os.WriteFile(result.ArtifactPath+validatorSuffix, []byte(modTime.UTC().Format(http.TimeFormat)), 0o600)
+	ranges = nil
+	if _, err := applier.Apply(ctx, plan, config.State{}); err != nil {
+		t.Fatalf("second Apply: %v", err)
+	}
+	if len(ranges) != 1 || ranges[0] != "bytes=100-" {
+		t.Fatalf("expected resumed request, got %q", ranges)
+	}
+	if _, err := os.Stat(filepath.Join(bundleDir, "bundle", "pingsanto-agent")); err != nil {
+		t.Fatalf("expected extracted binary: %v", err)
+	}
+}
+
+func TestApplierDownloadRateLimit(t *testing.T) {
+	ctx := context.Background()
+	artifactBytes := bytes.Repeat([]byte("x"), 96<<10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(artifactBytes)
+	}))
+	t.Cleanup(server.Close)
+
+	applier := &Applier{DataDir: t.TempDir(), HTTPClient: server.Client()}
+	NewManager(Config{DownloadRateLimit: 128 << 10}, Dependencies{Applier: applier})
+	if applier.DownloadRateLimit != 128<<10 {
+		t.Fatalf("expected manager to hand the rate limit to the applier, got %d", applier.DownloadRateLimit)
+	}
+
+	// 64KiB of burst, then 32KiB at 128KiB/s: at least 250ms.
+	dest := filepath.Join(t.TempDir(), "artifact")
+	start := time.Now()
+	if err := applier.download(ctx, server.URL, dest); err != nil {
+		t.Fatalf("download: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("download finished in %s, expected throttling", elapsed)
+	}
+	if got, _ := os.ReadFile(dest); !bytes.Equal(got, artifactBytes) {
+		t.Fatalf("downloaded %d bytes, want %d", len(got), len(artifactBytes))
+	}
+}
+
+func TestApplierFailsOverToMirror(t *testing.T) {
+	ctx := context.Background()
+	artifactBytes := buildTarGz(t, map[string]string{"pingsanto-agent": "#!/bin/sh\n"})
+	sum := sha256.Sum256(artifactBytes)
+
+	var hits []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits = append(hits, r.URL.Path)
+		switch r.URL.Path {
+		case "/primary", "/primary.sig":
+			http.Error(w, "down", http.StatusBadGateway)
+		case "/corrupt":
+			w.Write([]byte("truncated"))
+		case "/mirror":
+			w.Write(artifactBytes)
+		case "/mirror.sig":
+			w.Write([]byte("signature"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	verifier := &recordingVerifier{}
+	applier := &Applier{DataDir: t.TempDir(), HTTPClient: server.Client(), Verifier: verifier}
+	plan := Plan{Artifact: PlanArtifact{
+		Version:      "1.1.0",
+		URL:          server.URL + "/primary",
+		SignatureURL: server.URL + "/primary.sig",
+		SHA256:       hex.EncodeToString(sum[:]),
+		Mirrors: []PlanMirror{
+			{URL: server.URL + "/corrupt"},
+			{URL: server.URL + "/mirror", SignatureURL: server.URL + "/mirror.sig"},
+		},
+	}}
+
+	result, err := applier.Apply(ctx, plan, config.State{})
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{"/primary", "/corrupt", "/mirror", "/primary.sig", "/mirror.sig"}
+	if fmt.Sprint(hits) != fmt.Sprint(want) {
+		t.Fatalf("requests %v, want %v", hits, want)
+	}
+	if got, _ := os.ReadFile(result.ArtifactPath); !bytes.Equal(got, artifactBytes) {
+		t.Fatalf("unexpected artifact contents")
+	}
+	if verifier.calls != 1 {
+		t.Fatalf("expected signature verification, got %d calls", verifier.calls)
+	}
+
+	plan.Artifact.Mirrors = plan.Artifact.Mirrors[:1]
+	if _, err := applier.Apply(ctx, plan, config.State{}); err == nil {
+		t.Fatalf("expected failure when no source serves the artifact")
+	}
+}
+
+type recordingVerifier struct{ calls int }
+
+func (v *recordingVerifier) Verify(ctx context.Context, artifactPath, signaturePath string) error {
+	v.calls++
+	return nil
+}
+
+func TestApplierRejectsArtifactLargerThanFreeSpace(t *testing.T) {
+	original := availableDiskSpace
+	availableDiskSpace = func(string) (uint64, error) { return 1 << 20, nil }
+	t.Cleanup(func() { availableDiskSpace = original })
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	applier := &Applier{DataDir: t.TempDir(), HTTPClient: server.Client()}
+	plan := Plan{Artifact: PlanArtifact{Version: "1.1.0", URL: server.URL + "/artifact", Size: 1 << 20}}
+	_, err := applier.Apply(context.Background(), plan, config.State{})
+	var diskErr *InsufficientDiskError
+	if !errors.As(err, &diskErr) {
+		t.Fatalf("expected InsufficientDiskError, got %v", err)
+	}
+	if diskErr.Required != 3<<20 || diskErr.Available != 1<<20 {
+		t.Fatalf("unexpected error %+v", diskErr)
+	}
+	if requests != 0 {
+		t.Fatalf("expected no download, got %d requests", requests)
+	}
+}
@@ -0,0 +1,116 @@
+package upgrade
+
+import (
+	"compress/bzip2"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	bsdiffMagic      = "BSDIFF40"
+	bsdiffHeaderSize = 32
+	// maxPatchedSize bounds the reconstructed artifact when the plan does
+	// not declare its size.
+	maxPatchedSize = 1 << 31
+	// patchChunkSize is how much of the diff block is applied at a time.
+	patchChunkSize = 32 << 10
+)
+
+// errCorruptPatch reports a patch that does not decode or does not fit the base.
+var errCorruptPatch = errors.New("corrupt delta patch")
+
+// bspatch applies a BSDIFF40 patch, as produced by bsdiff 4.x, to old and
+// streams the reconstructed file to out. Patches whose output would exceed
+// maxSize bytes are rejected before anything is written.
+func bspatch(out io.Writer, old, patch *io.SectionReader, maxSize int64) error {
+	var header [bsdiffHeaderSize]byte
+	if _, err := patch.ReadAt(header[:], 0); err != nil || string(header[:8]) != bsdiffMagic {
+		return fmt.Errorf("%w: bad header", errCorruptPatch)
+	}
+	ctrlLen := offtin(header[8:16])
+	diffLen := offtin(header[16:24])
+	newSize := offtin(header[24:32])
+	body := patch.Size() - bsdiffHeaderSize
+	if ctrlLen < 0 || diffLen < 0 || newSize < 0 || ctrlLen > body || diffLen > body-ctrlLen {
+		return fmt.Errorf("%w: bad header", errCorruptPatch)
+	}
+	if newSize > maxSize {
+		return fmt.Errorf("%w: output of %d bytes exceeds limit of %d", errCorruptPatch, newSize, maxSize)
+	}
+	ctrlStart := int64(bsdiffHeaderSize)
+	diffStart := ctrlStart + ctrlLen
+	extraStart := diffStart + diffLen
+	ctrl := bzip2.NewReader(io.NewSectionReader(patch, ctrlStart, ctrlLen))
+	diff := bzip2.NewReader(io.NewSectionReader(patch, diffStart, diffLen))
+	extra := bzip2.NewReader(io.NewSectionReader(patch, extraStart, body-ctrlLen-diffLen))
+
+	chunk := make([]byte, patchChunkSize)
+	base := make([]byte, patchChunkSize)
+	var triple [24]byte
+	var oldPos, newPos int64
+	for newPos < newSize {
+		if _, err := io.ReadFull(ctrl, triple[:]); err != nil {
+			return fmt.Errorf("%w: control block: %v", errCorruptPatch, err)
+		}
+		addLen, copyLen, seek := offtin(triple[0:8]), offtin(triple[8:16]), offtin(triple[16:24])
+		if addLen < 0 || copyLen < 0 || addLen > newSize-newPos || copyLen > newSize-newPos-addLen {
+			return fmt.Errorf("%w: control entry out of range", errCorruptPatch)
+		}
+
+		// The diff block holds bytewise differences against the old file.
+		for remaining := addLen; remaining > 0; {
+			n := min(remaining, int64(len(chunk)))
+			if _, err := io.ReadFull(diff, chunk[:n]); err != nil {
+				return fmt.Errorf("%w: diff block: %v", errCorruptPatch, err)
+			}
+			if err := addBase(chunk[:n], base, old, oldPos); err != nil {
+				return err
+			}
+			if _, err := out.Write(chunk[:n]); err != nil {
+				return err
+			}
+			oldPos += n
+			remaining -= n
+		}
+		newPos += addLen
+
+		// The extra block holds bytes inserted verbatim.
+		if _, err := io.CopyN(out, extra, copyLen); err != nil {
+			return fmt.Errorf("%w: extra block: %v", errCorruptPatch, err)
+		}
+		newPos += copyLen
+		oldPos += seek
+	}
+	return nil
+}
+
+// addBase adds the bytes of old starting at oldPos to chunk, skipping
+// positions outside the old file. buf is scratch space at least as large
+// as chunk.
+func addBase(chunk, buf []byte, old *io.SectionReader, oldPos int64) error {
+	lo := max(oldPos, 0)
+	hi := min(oldPos+int64(len(chunk)), old.Size())
+	if lo >= hi {
+		return nil
+	}
+	seg := buf[:hi-lo]
+	if n, err := old.ReadAt(seg, lo); n < len(seg) {
+		return fmt.Errorf("read base artifact: %w", err)
+	}
+	dst := chunk[lo-oldPos:]
+	for i, b := range seg {
+		dst[i] += b
+	}
+	return nil
+}
+
+// offtin decodes bsdiff's sign-magnitude little-endian integers.
+func offtin(b []byte) int64 {
+	v := int64(binary.LittleEndian.Uint64(b) &^ (1 << 63))
+	if b[7]&0x80 != 0 {
+		return -v
+	}
+	return v
+}
@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/throttle"
 )
 
 const (
@@ -49,13 +50,80 @@ type PlanArtifact struct {
 	URL          string
 	SHA256       string
 	SignatureURL string
-	ForceApply   bool
+	// Size is the artifact's length in bytes, when the controller knows it;
+	// the applier checks free disk space against it before downloading.
+	Size       int64
+	ForceApply bool
+	// IgnoreReadiness applies the plan even when local readiness checks fail.
+	IgnoreReadiness bool
+	// Deltas are patches from earlier versions that rebuild this artifact.
+	Deltas []PlanDelta
+	// Mirrors are tried in order when URL fails or serves a corrupt copy.
+	Mirrors []PlanMirror
+	// Platforms are builds for specific GOOS/GOARCH pairs; see ForPlatform.
+	Platforms []PlanPlatform
+}
+
+// PlanPlatform is the build of the plan version for one GOOS/GOARCH pair.
+type PlanPlatform struct {
+	OS           string
+	Arch         string
+	URL          string
+	SHA256       string
+	SignatureURL string
+	Size         int64
+	Deltas       []PlanDelta
+	Mirrors      []PlanMirror
+}
+
+// ForPlatform returns the artifact an agent on goos/goarch should install:
+// the matching platform entry, whose deltas and mirrors replace the
+// top-level ones, or else the top-level artifact. It reports false when the
+// plan lists platforms, none matches, and there is no top-level URL.
+func (a PlanArtifact) ForPlatform(goos, goarch string) (PlanArtifact, bool) {
+	resolved := a
+	resolved.Platforms = nil
+	for _, p := range a.Platforms {
+		if p.OS == goos && p.Arch == goarch {
+			resolved.URL = p.URL
+			resolved.SHA256 = p.SHA256
+			resolved.SignatureURL = p.SignatureURL
+			resolved.Size = p.Size
+			resolved.Deltas = p.Deltas
+			resolved.Mirrors = p.Mirrors
+			return resolved, true
+		}
+	}
+	return resolved, len(a.Platforms) == 0 || a.URL != ""
+}
+
+// PlanMirror is another location of the artifact and, optionally, its
+// signature.
+type PlanMirror struct {
+	URL          string
+	SignatureURL string
+}
+
+// PlanDelta is a binary patch from the artifact of FromVersion (whose
+// SHA-256 is BaseSHA256) to the plan artifact.
+type PlanDelta struct {
+	FromVersion string
+	Format      string
+	URL         string
+	SHA256      string
+	BaseSHA256  string
 }
 
 // PlanSchedule mirrors the JSON response schedule block.
 type PlanSchedule struct {
 	Earliest *time.Time
 	Latest   *time.Time
+	// MaintenanceWindows are recurring local-time windows such as
+	// "Sat 02:00-04:00"; see ParseMaintenanceWindow.
+	MaintenanceWindows []string
+	// Prefetch downloads and verifies the artifact as soon as the plan
+	// arrives, leaving only the install for the rollout window.
+	Prefetch bool
 }
 
 // Report captures upgrade status reports sent back to the controller.
@@ -73,10 +141,11 @@ type Report struct {
 
 // Client performs controller upgrade plan/report requests.
 type Client struct {
-	baseURL    string
-	httpClient *http.Client
-	agentID    string
-	logger     *log.Logger
+	baseURL      string
+	httpClient   *http.Client
+	streamClient *http.Client
+	agentID      string
+	logger       *log.Logger
 }
 
 // NewClient constructs an upgrade client with the provided HTTP transport.
@@ -90,11 +159,15 @@ func NewClient(httpClient *http.Client, baseURL, agentID string, logger *log.Log
 	if logger == nil {
 		logger = log.New(io.Discard, "", 0)
 	}
+	// Plan streams stay open indefinitely, so they cannot share the request timeout.
+	streamClient := *httpClient
+	streamClient.Timeout = 0
 	return &Client{
-		baseURL:    strings.TrimRight(baseURL, "/"),
-		httpClient: httpClient,
-		agentID:    agentID,
-		logger:     logger,
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		httpClient:   httpClient,
+		streamClient: &streamClient,
+		agentID:      agentID,
+		logger:       logger,
 	}, nil
 }
 
@@ -130,6 +203,10 @@ func (c *Client) FetchPlan(ctx context.Context, channel, etag string) (PlanResul
 	defer resp.Body.Close()
 	defer io.Copy(io.Discard, resp.Body) // ensure body fully read
 
+	if err := throttle.FromResponse(resp, time.Now()); err != nil {
+		return PlanResult{}, fmt.Errorf("fetch upgrade plan: %w", err)
+	}
+
 	switch resp.StatusCode {
 	case http.StatusNotModified:
 		return PlanResult{ETag: etag, NotModified: true}, nil
@@ -138,28 +215,7 @@ func (c *Client) FetchPlan(ctx context.Context, channel, etag string) (PlanResul
 		if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
 			return PlanResult{}, fmt.Errorf("decode upgrade plan: %w", err)
 		}
-		result := PlanResult{
-			Plan: Plan{
-				AgentID:     envelope.AgentID,
-				GeneratedAt: envelope.GeneratedAt,
-				Channel:     envelope.Channel,
-				Artifact: PlanArtifact{
-					Version:      envelope.Artifact.Version,
-					URL:          envelope.Artifact.URL,
-					SHA256:       envelope.Artifact.SHA256,
-					SignatureURL: envelope.Artifact.SignatureURL,
-					ForceApply:   envelope.Artifact.ForceApply,
-				},
-				Schedule: PlanSchedule{
-					Earliest: envelope.Schedule.Earliest,
-					Latest:   envelope.Schedule.Latest,
-				},
-				Paused: envelope.Paused,
-				Notes:  envelope.Notes,
-			},
-			ETag: resp.Header.Get("ETag"),
-		}
-		return result, nil
+		return PlanResult{Plan: envelope.plan(), ETag: resp.Header.Get("ETag")}, nil
 	case http.StatusNotFound:
 		return PlanResult{}, ErrPlanNotFound
 	case http.StatusForbidden, http.StatusUnauthorized:
@@ -211,6 +267,9 @@ func (c *Client) ReportUpgrade(ctx context.Context, report Report) error {
 	defer resp.Body.Close()
 	defer io.Copy(io.Discard, resp.Body)
 
+	if err := throttle.FromResponse(resp, time.Now()); err != nil {
+		return fmt.Errorf("send upgrade report: %w", err)
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("upgrade report failed: %s", resp.Status)
 	}
@@ -220,18 +279,21 @@ func (c *Client) ReportUpgrade(ctx context.Context, report Report) error {
 // ToState converts a plan into persisted upgrade state metadata.
 func (p Plan) ToState(now time.Time, etag string) config.UpgradePlanState {
 	state := config.UpgradePlanState{
-		Version:      p.Artifact.Version,
-		Channel:      p.Channel,
-		Source:       p.AgentID,
-		Paused:       p.Paused,
-		ArtifactURL:  p.Artifact.URL,
-		SignatureURL: p.Artifact.SignatureURL,
-		SHA256:       p.Artifact.SHA256,
-		ForceApply:   p.Artifact.ForceApply,
-		Notes:        p.Notes,
+		Version:         p.Artifact.Version,
+		Channel:         p.Channel,
+		Source:          p.AgentID,
+		Paused:          p.Paused,
+		ArtifactURL:     p.Artifact.URL,
+		SignatureURL:    p.Artifact.SignatureURL,
+		SHA256:          p.Artifact.SHA256,
+		ForceApply:      p.Artifact.ForceApply,
+		Notes:           p.Notes,
+		IgnoreReadiness: p.Artifact.IgnoreReadiness,
 		Schedule: config.UpgradePlanSchedule{
-			Earliest: p.Schedule.Earliest,
-			Latest:   p.Schedule.Latest,
+			Earliest:           p.Schedule.Earliest,
+			Latest:             p.Schedule.Latest,
+			MaintenanceWindows: p.Schedule.MaintenanceWindows,
+			Prefetch:           p.Schedule.Prefetch,
 		},
 		RetrievedAt: now.UTC(),
 		ETag:        etag,
@@ -265,17 +327,110 @@ type planEnvelope struct {
 	Notes       string       `json:"notes"`
 }
 
+func (e planEnvelope) plan() Plan {
+	return Plan{
+		AgentID:     e.AgentID,
+		GeneratedAt: e.GeneratedAt,
+		Channel:     e.Channel,
+		Artifact: PlanArtifact{
+			Version:         e.Artifact.Version,
+			URL:             e.Artifact.URL,
+			SHA256:          e.Artifact.SHA256,
+			SignatureURL:    e.Artifact.SignatureURL,
+			Size:            e.Artifact.Size,
+			ForceApply:      e.Artifact.ForceApply,
+			IgnoreReadiness: e.Artifact.IgnoreReadiness,
+			Deltas:          planDeltas(e.Artifact.Deltas),
+			Mirrors:         planMirrors(e.Artifact.Mirrors),
+			Platforms:       planPlatforms(e.Artifact.Platforms),
+		},
+		Schedule: PlanSchedule{
+			Earliest:           e.Schedule.Earliest,
+			Latest:             e.Schedule.Latest,
+			MaintenanceWindows: e.Schedule.MaintenanceWindows,
+			Prefetch:           e.Schedule.Prefetch,
+		},
+		Paused: e.Paused,
+		Notes:  e.Notes,
+	}
+}
+
 type planArtifact struct {
-	Version      string `json:"version"`
+	Version         string `json:"version"`
+	URL             string `json:"url"`
+	SHA256          string `json:"sha256"`
+	SignatureURL    string `json:"signature_url"`
+	Size            int64  `json:"size"`
+	ForceApply      bool   `json:"force_apply"`
+	IgnoreReadiness bool   `json:"ignore_readiness"`
+
+	Deltas    []planDelta    `json:"deltas"`
+	Mirrors   []planMirror   `json:"mirrors"`
+	Platforms []planPlatform `json:"platforms"`
+}
+
+type planPlatform struct {
+	OS           string       `json:"os"`
+	Arch         string       `json:"arch"`
+	URL          string       `json:"url"`
+	SHA256       string       `json:"sha256"`
+	SignatureURL string       `json:"signature_url"`
+	Size         int64        `json:"size"`
+	Deltas       []planDelta  `json:"deltas"`
+	Mirrors      []planMirror `json:"mirrors"`
+}
+
+func planPlatforms(in []planPlatform) []PlanPlatform {
+	var out []PlanPlatform
+	for _, p := range in {
+		out = append(out, PlanPlatform{
+			OS:           p.OS,
+			Arch:         p.Arch,
+			URL:          p.URL,
+			SHA256:       p.SHA256,
+			SignatureURL: p.SignatureURL,
+			Size:         p.Size,
+			Deltas:       planDeltas(p.Deltas),
+			Mirrors:      planMirrors(p.Mirrors),
+		})
+	}
+	return out
+}
+
+type planMirror struct {
 	URL          string `json:"url"`
-	SHA256       string `json:"sha256"`
 	SignatureURL string `json:"signature_url"`
-	ForceApply   bool   `json:"force_apply"`
+}
+
+func planMirrors(in []planMirror) []PlanMirror {
+	var out []PlanMirror
+	for _, m := range in {
+		out = append(out, PlanMirror(m))
+	}
+	return out
+}
+
+type planDelta struct {
+	FromVersion string `json:"from_version"`
+	Format      string `json:"format"`
+	URL         string `json:"url"`
+	SHA256      string `json:"sha256"`
+	BaseSHA256  string `json:"base_sha256"`
+}
+
+func planDeltas(in []planDelta) []PlanDelta {
+	var out []PlanDelta
+	for _, d := range in {
+		out = append(out, PlanDelta(d))
+	}
+	return out
 }
 
 type planSchedule struct {
-	Earliest *time.Time `json:"earliest"`
-	Latest   *time.Time `json:"latest"`
+	Earliest           *time.Time `json:"earliest"`
+	Latest             *time.Time `json:"latest"`
+	MaintenanceWindows []string   `json:"maintenance_windows"`
+	Prefetch           bool       `json:"prefetch"`
 }
 
 type reportPayload struct {
@@ -134,3 +134,53 @@ func TestClientReportUpgrade(t *testing.T) {
 		t.Fatalf("unexpected payload: %#v", received)
 	}
 }
+
+func TestClientStreamPlan(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultUpgradePlanStreamPath || r.URL.Query().Get("channel") != "canary" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		if r.Header.Get("If-None-Match") != `"etag-old"` || r.Header.Get("X-Agent-ID") != "agt_1" {
+			t.Errorf("unexpected headers %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		_, _ = w.Write([]byte("\n"))
+		for _, version := range []string{"1.2.3", "1.2.4"} {
+			plan := planEnvelope{Channel: "canary", Artifact: planArtifact{Version: version}}
+			_ = enc.Encode(planStreamEvent{ETag: `"etag-` + version + `"`, Plan: &plan})
+		}
+		_ = enc.Encode(planStreamEvent{NoPlan: true})
+	}))
+	defer ts.Close()
+
+	client, err := NewClient(ts.Client(), ts.URL, "agt_1", nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	var got []PlanResult
+	err = client.StreamPlan(context.Background(), "canary", `"etag-old"`, func(result PlanResult) error {
+		got = append(got, result)
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected error when the server closes the stream")
+	}
+	if len(got) != 3 || got[1].Plan.Artifact.Version != "1.2.4" || got[1].ETag != `"etag-1.2.4"` || got[2].ETag != "" || got[2].Plan.Artifact.Version != "" {
+		t.Fatalf("unexpected pushed plans: %#v", got)
+	}
+}
+
+func TestClientStreamPlanUnsupported(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	client, err := NewClient(ts.Client(), ts.URL, "agt_1", nil)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	err = client.StreamPlan(context.Background(), "stable", "", func(PlanResult) error { return nil })
+	if !errors.Is(err, ErrPlanStreamUnsupported) {
+		t.Fatalf("expected ErrPlanStreamUnsupported, got %v", err)
+	}
+}
@@ -0,0 +1,123 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+const (
+	defaultCrashLoopRestarts = 3
+	defaultCrashLoopWindow   = 10 * time.Minute
+)
+
+// CrashLoopGuard counts how often an upgraded agent starts and restores the
+// previous binary when the new one keeps crashing. Check runs at the very
+// start of the agent, before anything that could crash the new version, so
+// a binary that dies during startup is still caught.
+type CrashLoopGuard struct {
+	DataDir string
+	// MaxRestarts is how many restarts within Window trigger a rollback
+	// (default 3); the start that follows the upgrade is not a restart.
+	MaxRestarts int
+	Window      time.Duration
+	Installer   Installer
+	Restarter   Restarter
+	Logger      *log.Logger
+	Now         func() time.Time
+	LoadState   func(context.Context, string) (config.State, error)
+	UpdateState func(context.Context, string, config.State) error
+}
+
+// Check records this start of a pending upgrade. Once the crash-loop window
+// has passed after a verified upgrade the pending record is dropped. When
+// the restart budget is exhausted the backup binary is restored, the version
+// is marked bad, the rollback is queued for reporting, and the restored
+// binary is started in place of this process.
+func (g *CrashLoopGuard) Check(ctx context.Context, args, env []string) error {
+	g.defaults()
+	state, err := g.LoadState(ctx, g.DataDir)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	pending := state.Upgrade.Pending
+	if pending.Version == "" {
+		return nil
+	}
+	now := g.Now()
+	if now.Sub(pending.RestartedAt) > g.Window {
+		if pending.Verified {
+			state.Upgrade.Pending = config.UpgradePendingState{}
+			return g.UpdateState(ctx, g.DataDir, state)
+		}
+		// Left for post-upgrade verification, whose deadline has passed.
+		return nil
+	}
+
+	pending.Starts++
+	state.Upgrade.Pending = pending
+	if restarts := pending.Starts - 1; restarts < g.MaxRestarts {
+		return g.UpdateState(ctx, g.DataDir, state)
+	}
+
+	reason := fmt.Sprintf("crash loop: restarted %d times within %s of upgrade", pending.Starts-1, g.Window)
+	g.Logger.Printf("upgrade crash-loop guard: version=%s %s; rolling back to %s", pending.Version, reason, pending.PreviousVersion)
+	if g.Installer == nil {
+		return g.UpdateState(ctx, g.DataDir, state)
+	}
+	if err := g.Installer.Rollback(ctx, InstallResult{TargetPath: pending.TargetPath, BackupPath: pending.BackupPath}); err != nil {
+		// Keep counting; the next start tries again.
+		g.Logger.Printf("upgrade crash-loop guard: rollback failed: %v", err)
+		return g.UpdateState(ctx, g.DataDir, state)
+	}
+
+	state.Upgrade.Applied.Version = pending.PreviousVersion
+	state.Upgrade.Applied.PreviousVersion = ""
+	state.Upgrade.Applied.LastError = reason
+	if !slices.Contains(state.Upgrade.BadVersions, pending.Version) {
+		state.Upgrade.BadVersions = append(state.Upgrade.BadVersions, pending.Version)
+	}
+	state.Upgrade.Rollback = config.UpgradeRollbackState{
+		Version:         pending.Version,
+		PreviousVersion: pending.PreviousVersion,
+		Channel:         pending.Channel,
+		Stage:           "crash_loop",
+		Reason:          reason,
+		Starts:          pending.Starts,
+		At:              now.UTC(),
+	}
+	state.Upgrade.Pending = config.UpgradePendingState{}
+	if err := g.UpdateState(ctx, g.DataDir, state); err != nil {
+		return fmt.Errorf("record crash-loop rollback: %w", err)
+	}
+	if g.Restarter == nil || pending.TargetPath == "" {
+		return nil
+	}
+	return g.Restarter.Restart(ctx, pending.TargetPath, args, env)
+}
+
+func (g *CrashLoopGuard) defaults() {
+	if g.MaxRestarts <= 0 {
+		g.MaxRestarts = defaultCrashLoopRestarts
+	}
+	if g.Window <= 0 {
+		g.Window = defaultCrashLoopWindow
+	}
+	if g.Logger == nil {
+		g.Logger = log.New(io.Discard, "", 0)
+	}
+	if g.Now == nil {
+		g.Now = time.Now
+	}
+	if g.LoadState == nil {
+		g.LoadState = config.LoadState
+	}
+	if g.UpdateState == nil {
+		g.UpdateState = config.UpdateState
+	}
+}
@@ -0,0 +1,172 @@
+package upgrade
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+func TestCrashLoopGuardRollsBackAfterRepeatedRestarts(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1730000000, 0)
+	store := &fakeStateStore{state: pendingUpgradeState(now.Add(-time.Minute))}
+	installer := &fakeInstaller{}
+	restarter := &fakeRestarter{}
+	guard := &CrashLoopGuard{
+		DataDir:     "/fake",
+		MaxRestarts: 2,
+		Window:      10 * time.Minute,
+		Installer:   installer,
+		Restarter:   restarter,
+		Now:         func() time.Time { return now },
+		LoadState:   store.Load,
+		UpdateState: store.Update,
+	}
+
+	// The first start follows the upgrade; two more are crash restarts.
+	for start := 1; start <= 2; start++ {
+		if err := guard.Check(ctx, nil, nil); err != nil {
+			t.Fatalf("Check start %d: %v", start, err)
+		}
+		if got := store.state.Upgrade.Pending.Starts; got != start {
+			t.Fatalf("expected %d starts recorded, got %d", start, got)
+		}
+	}
+	if installer.rollbackCalls != 0 {
+		t.Fatalf("rolled back before the restart budget was spent")
+	}
+	if err := guard.Check(ctx, nil, nil); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if installer.rollbackCalls != 1 || restarter.calls != 1 {
+		t.Fatalf("expected rollback and restart, got rollbacks=%d restarts=%d", installer.rollbackCalls, restarter.calls)
+	}
+	upgradeState := store.state.Upgrade
+	if upgradeState.Pending.Version != "" || upgradeState.Applied.Version != "1.0.0" {
+		t.Fatalf("unexpected state after rollback: %+v", upgradeState)
+	}
+	if !slices.Equal(upgradeState.BadVersions, []string{"1.2.0"}) {
+		t.Fatalf("expected 1.2.0 marked bad, got %v", upgradeState.BadVersions)
+	}
+	if upgradeState.Rollback.Version != "1.2.0" || upgradeState.Rollback.Starts != 3 || upgradeState.Rollback.Reason == "" {
+		t.Fatalf("expected rollback queued for reporting, got %+v", upgradeState.Rollback)
+	}
+}
+
+func TestCrashLoopGuardForgetsVerifiedUpgradeAfterWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1730000000, 0)
+	state := pendingUpgradeState(now.Add(-time.Hour))
+	state.Upgrade.Pending.Starts = 5
+	state.Upgrade.Pending.Verified = true
+	store := &fakeStateStore{state: state}
+	installer := &fakeInstaller{}
+	guard := &CrashLoopGuard{
+		DataDir:     "/fake",
+		Installer:   installer,
+		Now:         func() time.Time { return now },
+		LoadState:   store.Load,
+		UpdateState: store.Update,
+	}
+	if err := guard.Check(ctx, nil, nil); err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if installer.rollbackCalls != 0 {
+		t.Fatalf("restarts after the window must not roll back")
+	}
+	if store.state.Upgrade.Pending != (config.UpgradePendingState{}) {
+		t.Fatalf("expected pending upgrade dropped, got %+v", store.state.Upgrade.Pending)
+	}
+}
+
+func TestManagerReportsCrashLoopRollbackAndSkipsBadVersion(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{state: config.State{
+		AgentID: "agt-1",
+		Upgrade: config.UpgradeState{
+			Applied:     config.UpgradeAppliedState{Version: "1.0.0"},
+			BadVersions: []string{"1.2.0"},
+			Rollback: config.UpgradeRollbackState{
+				Version:         "1.2.0",
+				PreviousVersion: "1.0.0",
+				Channel:         "stable",
+				Reason:          "crash loop: restarted 3 times within 10m0s of upgrade",
+				Starts:          4,
+				At:              time.Unix(1730000000, 0),
+			},
+		},
+	}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{Channel: "stable", Artifact: PlanArtifact{Version: "1.2.0"}}}}
+	applier := &fakeApplier{}
+	reporter := &fakeReporter{}
+	mgr := NewManager(
+		Config{DataDir: "/fake"},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Reporter:    reporter,
+		},
+	)
+
+	mgr.reportRollback(ctx, false)
+	if len(reporter.reports) != 1 {
+		t.Fatalf("expected rollback reported once, got %d", len(reporter.reports))
+	}
+	rep := reporter.reports[0]
+	if rep.Status != "failed" || rep.CurrentVersion != "1.2.0" || rep.PreviousVersion != "1.0.0" || rep.Details["stage"] != "crash_loop" || rep.Details["bad_version"] != true {
+		t.Fatalf("unexpected report: %#v", rep)
+	}
+	if store.state.Upgrade.Rollback.Version != "" {
+		t.Fatalf("expected reported rollback cleared")
+	}
+	mgr.reportRollback(ctx, false)
+	if len(reporter.reports) != 1 {
+		t.Fatalf("rollback reported twice")
+	}
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected bad version to be skipped")
+	}
+}
+
+func TestManagerRestartsAfterManualRollback(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{state: config.State{AgentID: "agt", Upgrade: config.UpgradeState{
+		Applied: config.UpgradeAppliedState{Version: "1.0.0", Path: "/opt/pingsanto/pingsanto-agent"},
+		Rollback: config.UpgradeRollbackState{
+			Version:         "1.2.0",
+			PreviousVersion: "1.0.0",
+			Stage:           "manual",
+			Reason:          "rolled back by operator",
+			At:              time.Unix(1730000000, 0),
+			Restart:         true,
+		},
+	}}}
+	reporter := &fakeReporter{}
+	restarter := &fakeRestarter{}
+	mgr := NewManager(
+		Config{DataDir: "/fake"},
+		Dependencies{LoadState: store.Load, UpdateState: store.Update, Reporter: reporter, Restarter: restarter},
+	)
+
+	mgr.reportRollback(ctx, true)
+	if len(reporter.reports) != 1 || reporter.reports[0].Details["stage"] != "manual" || reporter.reports[0].Status != "failed" {
+		t.Fatalf("unexpected reports %#v", reporter.reports)
+	}
+	if restarter.calls != 1 {
+		t.Fatalf("expected restart into the restored binary, got %d", restarter.calls)
+	}
+	mgr.reportRollback(ctx, true)
+	if len(reporter.reports) != 1 || restarter.calls != 1 {
+		t.Fatalf("rollback handled twice")
+	}
+}
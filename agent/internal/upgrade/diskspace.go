@@ -0,0 +1,41 @@
+package upgrade
+
+import "fmt"
+
+// diskSpaceFactor is how many artifact sizes an upgrade needs free: the
+// compressed download, the extracted bundle and the installed binary copy.
+const diskSpaceFactor = 3
+
+// availableDiskSpace returns the bytes available to unprivileged users on
+// the filesystem holding path. Tests replace it.
+var availableDiskSpace = freeDiskSpace
+
+// InsufficientDiskError reports that an upgrade was stopped before
+// downloading because the data directory lacks room for it.
+type InsufficientDiskError struct {
+	Path      string
+	Required  uint64
+	Available uint64
+}
+
+func (e *InsufficientDiskError) Error() string {
+	return fmt.Sprintf("insufficient disk space in %s: need %d bytes, %d available", e.Path, e.Required, e.Available)
+}
+
+// checkDiskSpace fails with *InsufficientDiskError when dir cannot hold an
+// upgrade of an artifact of size bytes. Unknown sizes are not checked, and
+// a filesystem that cannot be queried is left to fail on write.
+func checkDiskSpace(dir string, size int64) error {
+	if size <= 0 {
+		return nil
+	}
+	available, err := availableDiskSpace(dir)
+	if err != nil {
+		return nil
+	}
+	required := uint64(size) * diskSpaceFactor
+	if available < required {
+		return &InsufficientDiskError{Path: dir, Required: required, Available: available}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package upgrade
+
+import "errors"
+
+// freeDiskSpace is not implemented here, so checkDiskSpace skips the check.
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("disk space query not supported")
+}
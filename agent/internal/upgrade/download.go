@@ -0,0 +1,220 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// partialSuffix marks an interrupted download kept for resumption;
+	// validatorSuffix stores the ETag or Last-Modified it was fetched under.
+	partialSuffix   = ".part"
+	validatorSuffix = ".part.validator"
+
+	downloadAttempts = 3
+	// maxRateBurst bounds how far a rate-limited download may run ahead.
+	maxRateBurst = 64 << 10
+)
+
+// downloadBackoff is the pause before resuming an interrupted download.
+var downloadBackoff = 2 * time.Second
+
+// errRangeIgnored reports a partial response that does not continue where
+// the partial file ends.
+var errRangeIgnored = errors.New("server resumed at an unexpected offset")
+
+// download fetches url into dest. Bytes already in dest.part from an earlier,
+// interrupted attempt are kept and the rest is requested with a Range header;
+// If-Range makes the server send the whole file instead if it changed since.
+// A connection dropped mid-transfer is resumed up to downloadAttempts times
+// before giving up, and the partial file survives for the next Apply.
+func (a *Applier) download(ctx context.Context, url, dest string) error {
+	var err error
+	for attempt := 1; attempt <= downloadAttempts; attempt++ {
+		var progressed bool
+		progressed, err = a.downloadOnce(ctx, url, dest)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil || !progressed {
+			return err
+		}
+		if attempt < downloadAttempts {
+			a.logf("upgrade applier: %v; resuming", err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(downloadBackoff):
+			}
+		}
+	}
+	return err
+}
+
+// downloadOnce makes one request and reports whether any bytes were written,
+// which is when retrying with a Range header is worthwhile.
+func (a *Applier) downloadOnce(ctx context.Context, url, dest string) (bool, error) {
+	partial := dest + partialSuffix
+	validatorPath := dest + validatorSuffix
+	var offset int64
+	if info, err := os.Stat(partial); err == nil {
+		offset = info.Size()
+	}
+	validator, _ := os.ReadFile(validatorPath)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("build request for %s: %w", url, err)
+	}
+	if offset > 0 && len(validator) > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(validator))
+	}
+	resp, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		start, ok := contentRangeStart(resp.Header.Get("Content-Range"))
+		if !ok || start != offset {
+			// Start over rather than splice mismatched ranges.
+			discardPartial(dest)
+			return true, fmt.Errorf("download %s: %w", url, errRangeIgnored)
+		}
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		discardPartial(dest)
+		return true, fmt.Errorf("download %s: status %s", url, resp.Status)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		flags |= os.O_TRUNC
+		offset = 0
+		if err := saveValidator(validatorPath, resp.Header); err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("download %s: status %s", url, resp.Status)
+	}
+
+	file, err := os.OpenFile(partial, flags, 0o600)
+	if err != nil {
+		return false, fmt.Errorf("create %s: %w", partial, err)
+	}
+	written, err := io.Copy(file, a.throttle(ctx, resp.Body))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return written > 0, fmt.Errorf("download %s: interrupted after %d bytes: %w", url, offset+written, err)
+	}
+	if err := os.Rename(partial, dest); err != nil {
+		return false, fmt.Errorf("commit %s: %w", dest, err)
+	}
+	os.Remove(validatorPath)
+	return true, nil
+}
+
+// saveValidator records what If-Range should send when resuming. Without a
+// strong ETag or Last-Modified the server cannot tell us the file changed,
+// so resumption is disabled by removing any stale validator.
+func saveValidator(path string, header http.Header) error {
+	validator := header.Get("ETag")
+	if strings.HasPrefix(validator, "W/") {
+		validator = ""
+	}
+	if validator == "" {
+		validator = header.Get("Last-Modified")
+	}
+	if validator == "" {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("remove %s: %w", path, err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(validator), 0o600); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
+
+// contentRangeStart parses the first byte position of "bytes start-end/size".
+func contentRangeStart(header string) (int64, bool) {
+	spec, ok := strings.CutPrefix(header, "bytes ")
+	if !ok {
+		return 0, false
+	}
+	first, _, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	return start, err == nil
+}
+
+// throttle limits reads from r to DownloadRateLimit bytes per second.
+func (a *Applier) throttle(ctx context.Context, r io.Reader) io.Reader {
+	if a.DownloadRateLimit <= 0 {
+		return r
+	}
+	burst := int(min(a.DownloadRateLimit, maxRateBurst))
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: rate.NewLimiter(rate.Limit(a.DownloadRateLimit), burst)}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rate.Limiter
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > l.limiter.Burst() {
+		p = p[:l.limiter.Burst()]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		if waitErr := l.limiter.WaitN(l.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func discardPartial(dest string) {
+	os.Remove(dest + partialSuffix)
+	os.Remove(dest + validatorSuffix)
+}
+
+// clearBundleDir removes a previous attempt's files from dir but keeps
+// partial downloads so they can be resumed.
+func clearBundleDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && (strings.HasSuffix(name, partialSuffix) || strings.HasSuffix(name, validatorSuffix)) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
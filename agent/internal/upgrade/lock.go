@@ -0,0 +1,68 @@
+package upgrade
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// LockFileName is the file in the data directory that serialises installs.
+const LockFileName = "upgrade.lock"
+
+// ErrUpgradeLocked is returned by AcquireLock while another process holds
+// the upgrade lock.
+var ErrUpgradeLocked = errors.New("another upgrade is in progress")
+
+// errLockHeld is returned by lockFile when another handle holds the lock.
+var errLockHeld = errors.New("lock held")
+
+// Lock is an exclusive lock on the data directory's upgrade.lock: flock on
+// Unix, LockFileEx on Windows. The OS drops it when the holder exits (or, on
+// Unix, execs into a new binary), so a crashed upgrade never leaves the lock
+// behind.
+type Lock struct {
+	file *os.File
+}
+
+// AcquireLock takes the upgrade lock for dataDir without waiting. It returns
+// an error wrapping ErrUpgradeLocked, naming the holder's PID when known, if
+// another process already holds it.
+func AcquireLock(dataDir string) (*Lock, error) {
+	path := filepath.Join(dataDir, LockFileName)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open upgrade lock: %w", err)
+	}
+	if err := lockFile(file); err != nil {
+		holder, _ := os.ReadFile(path)
+		file.Close()
+		if errors.Is(err, errLockHeld) {
+			if pid := strings.TrimSpace(string(holder)); pid != "" {
+				return nil, fmt.Errorf("%w (pid %s holds %s)", ErrUpgradeLocked, pid, path)
+			}
+			return nil, fmt.Errorf("%w (%s is held)", ErrUpgradeLocked, path)
+		}
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release drops the lock. It is safe to call on a nil Lock.
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	_ = l.file.Truncate(0)
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,15 @@
+//go:build !unix && !windows
+
+package upgrade
+
+import "os"
+
+// lockFile is a no-op here: these platforms have no advisory file locks, so
+// concurrent installs are not serialised.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
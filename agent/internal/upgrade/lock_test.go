@@ -0,0 +1,25 @@
+package upgrade
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAcquireLockIsExclusive(t *testing.T) {
+	dir := t.TempDir()
+	first, err := AcquireLock(dir)
+	if err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	if _, err := AcquireLock(dir); !errors.Is(err, ErrUpgradeLocked) {
+		t.Fatalf("expected ErrUpgradeLocked, got %v", err)
+	}
+	if err := first.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	second, err := AcquireLock(dir)
+	if err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	second.Release()
+}
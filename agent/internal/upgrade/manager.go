@@ -6,18 +6,70 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/metrics"
+	"github.com/pingsantohq/agent/internal/throttle"
 )
 
 const defaultPollInterval = time.Minute
 
+// requestInterval is how often the state file is checked for requests from
+// `upgrades --apply-now` and `upgrades --rollback` between regular polls.
+var requestInterval = 5 * time.Second
+
 // Config configures the upgrade manager.
 type Config struct {
 	DataDir      string
 	PollInterval time.Duration
+	// DownloadRateLimit caps artifact downloads in bytes per second (0 is
+	// unlimited). It is handed to the applier when it supports throttling.
+	DownloadRateLimit int64
+	// VerifyWindow is how long a restarted agent has to become operational
+	// before the upgrade is rolled back (default 5m).
+	VerifyWindow time.Duration
+	// MaintenanceWindows restrict when plans are applied, on top of any
+	// windows the plan itself carries.
+	MaintenanceWindows []MaintenanceWindow
+	// AllowedChannels lists the channels whose plans may be applied; empty
+	// allows any. force_apply does not override it.
+	AllowedChannels []string
+	// KeepBundles is how many versions' downloads and extracted bundles are
+	// kept under the data directory's upgrades/ (default 3).
+	KeepBundles int
+	// GOOS and GOARCH select the plan's platform artifact (default: the
+	// running binary's).
+	GOOS   string
+	GOARCH string
+	// PushPlans holds the controller's plan stream open when PlanFetcher is
+	// a PlanStreamer, so new plans are applied as soon as they are published
+	// rather than at the next poll. Polling continues as a fallback.
+	PushPlans bool
+}
+
+// ChannelAllowed reports whether channel is in allowed. An empty allowlist
+// allows every channel.
+func ChannelAllowed(allowed []string, channel string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, ch := range allowed {
+		if strings.EqualFold(ch, channel) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitedApplier is implemented by appliers whose downloads can be
+// throttled, such as *Applier.
+type rateLimitedApplier interface {
+	SetDownloadRateLimit(bytesPerSecond int64)
 }
 
 // PlanFetcher fetches upgrade plans from the controller.
@@ -30,6 +82,12 @@ type Reporter interface {
 	ReportUpgrade(ctx context.Context, report Report) error
 }
 
+// ReadinessChecker reports whether the agent is healthy enough to take an upgrade.
+// health.Checker satisfies this interface.
+type ReadinessChecker interface {
+	Ready(now time.Time) (bool, []string)
+}
+
 // Dependencies allow tests to stub collaborators.
 type Dependencies struct {
 	Logger      *log.Logger
@@ -40,6 +98,9 @@ type Dependencies struct {
 	Applier     PlanApplier
 	Installer   Installer
 	Restarter   Restarter
+	Readiness   ReadinessChecker
+	Health      HealthVerifier
+	Metrics     metrics.UpgradeRecorder
 	Args        []string
 	Env         []string
 	Now         func() time.Time
@@ -58,6 +119,11 @@ type Manager struct {
 	restarter Restarter
 	args      []string
 	env       []string
+
+	deferred         *Plan
+	deferredReported string
+	applyNowSeen     time.Time
+	lastFailure      string
 }
 
 // NewManager constructs an Upgrade manager.
@@ -65,6 +131,18 @@ func NewManager(cfg Config, deps Dependencies) *Manager {
 	if cfg.PollInterval <= 0 {
 		cfg.PollInterval = defaultPollInterval
 	}
+	if cfg.VerifyWindow <= 0 {
+		cfg.VerifyWindow = defaultVerifyWindow
+	}
+	if cfg.KeepBundles <= 0 {
+		cfg.KeepBundles = defaultKeepBundles
+	}
+	if cfg.GOOS == "" {
+		cfg.GOOS = runtime.GOOS
+	}
+	if cfg.GOARCH == "" {
+		cfg.GOARCH = runtime.GOARCH
+	}
 	if deps.Logger == nil {
 		deps.Logger = log.New(io.Discard, "", 0)
 	}
@@ -77,6 +155,12 @@ func NewManager(cfg Config, deps Dependencies) *Manager {
 	if deps.Now == nil {
 		deps.Now = time.Now
 	}
+	if deps.Metrics == nil {
+		deps.Metrics = metrics.NoopUpgradeRecorder{}
+	}
+	if limited, ok := deps.Applier.(rateLimitedApplier); ok && cfg.DownloadRateLimit > 0 {
+		limited.SetDownloadRateLimit(cfg.DownloadRateLimit)
+	}
 	mgr := &Manager{cfg: cfg, deps: deps}
 	mgr.installer = deps.Installer
 	mgr.restarter = deps.Restarter
@@ -99,32 +183,134 @@ func (m *Manager) Paused() bool {
 	return m.paused
 }
 
+// LastFailure describes the most recent failed upgrade attempt, or returns
+// "" once an attempt succeeds or the controller replaces or withdraws the
+// plan. The agent's "upgrade" readiness check reports it.
+func (m *Manager) LastFailure() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.lastFailure
+}
+
+func (m *Manager) setLastFailure(reason string) {
+	m.mu.Lock()
+	m.lastFailure = reason
+	m.mu.Unlock()
+}
+
 // Run starts the polling loop until the context is cancelled.
 func (m *Manager) Run(ctx context.Context) error {
 	if m.cfg.DataDir == "" {
 		return nil
 	}
-	m.reload(ctx)
-	if err := m.poll(ctx); err != nil {
-		m.deps.Logger.Printf("upgrade manager: poll failed: %v", err)
+	m.reportRollback(ctx, false)
+	if err := m.verifyPending(ctx); err != nil {
+		return err
 	}
-
+	m.pruneAtStart(ctx)
 	ticker := time.NewTicker(m.cfg.PollInterval)
 	defer ticker.Stop()
 
+	pollOnce := func() error {
+		m.reload(ctx)
+		err := m.poll(ctx)
+		if err == nil {
+			return nil
+		}
+		m.deps.Logger.Printf("upgrade manager: poll failed: %v", err)
+		if delay, ok := throttle.Delay(err); ok && delay > m.cfg.PollInterval {
+			// Honour server-driven back-off before the next regular poll.
+			if err := throttle.Sleep(ctx, delay-m.cfg.PollInterval); err != nil {
+				return err
+			}
+			ticker.Reset(m.cfg.PollInterval)
+		}
+		return nil
+	}
+
+	if err := pollOnce(); err != nil {
+		return err
+	}
+	var pushed chan struct{}
+	if streamer, ok := m.deps.PlanFetcher.(PlanStreamer); ok && m.cfg.PushPlans {
+		pushed = make(chan struct{}, 1)
+		go m.streamPlans(ctx, streamer, pushed)
+	}
+	requests := time.NewTicker(requestInterval)
+	defer requests.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
-			m.reload(ctx)
-			if err := m.poll(ctx); err != nil {
-				m.deps.Logger.Printf("upgrade manager: poll failed: %v", err)
+			if err := pollOnce(); err != nil {
+				return err
+			}
+		case <-pushed:
+			if err := pollOnce(); err != nil {
+				return err
+			}
+		case <-requests.C:
+			m.reportRollback(ctx, true)
+			if m.applyNowRequested(ctx) {
+				if err := pollOnce(); err != nil {
+					return err
+				}
 			}
 		}
 	}
 }
 
+// streamPlans keeps the plan stream open and signals pushed for every plan
+// the controller sends or withdraws. The run loop then polls, so pushed plans go through
+// the same conditional fetch and checks as polled ones. The stream follows
+// the channel and plan ETag current when it connects; it reconnects after a
+// poll interval and gives up when the controller has no plan stream.
+func (m *Manager) streamPlans(ctx context.Context, streamer PlanStreamer, pushed chan<- struct{}) {
+	for {
+		channel, _, etag := m.snapshot()
+		err := streamer.StreamPlan(ctx, channel, etag, func(PlanResult) error {
+			select {
+			case pushed <- struct{}{}:
+			default:
+			}
+			return nil
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, ErrPlanStreamUnsupported) {
+			m.deps.Logger.Printf("upgrade manager: plan stream unsupported by server; polling every %s", m.cfg.PollInterval)
+			return
+		}
+		m.deps.Logger.Printf("upgrade manager: plan stream interrupted: %v", err)
+		delay := m.cfg.PollInterval
+		if throttled, ok := throttle.Delay(err); ok && throttled > delay {
+			delay = throttled
+		}
+		if err := throttle.Sleep(ctx, delay); err != nil {
+			return
+		}
+	}
+}
+
+// applyNowRequested reports, once per request, whether an operator has asked
+// for the plan to be applied immediately.
+func (m *Manager) applyNowRequested(ctx context.Context) bool {
+	state, err := m.deps.LoadState(ctx, m.cfg.DataDir)
+	if err != nil {
+		return false
+	}
+	request := state.Upgrade.ApplyNow
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if request.Version == "" || request.RequestedAt.Equal(m.applyNowSeen) {
+		return false
+	}
+	m.applyNowSeen = request.RequestedAt
+	return true
+}
+
 func (m *Manager) reload(ctx context.Context) {
 	if m.deps.LoadState == nil || m.cfg.DataDir == "" {
 		return
@@ -142,7 +328,12 @@ func (m *Manager) reload(ctx context.Context) {
 	m.channel = channel
 	m.paused = state.Upgrade.Paused
 	m.planETag = state.Upgrade.Plan.ETag
+	if state.Upgrade.ApplyNow.Version != "" {
+		// Fetch the full plan: a 304 carries nothing to apply.
+		m.planETag = ""
+	}
 	m.mu.Unlock()
+	m.deps.Metrics.ObserveUpgradeState(state.Upgrade.Applied.Version, channel, state.Upgrade.Paused, state.Upgrade.Plan.Paused)
 }
 
 func (m *Manager) poll(ctx context.Context) error {
@@ -155,13 +346,22 @@ func (m *Manager) poll(ctx context.Context) error {
 	if err != nil {
 		if errors.Is(err, ErrPlanNotFound) {
 			m.deps.Logger.Printf("upgrade manager: no upgrade plan for channel=%s", channel)
+			m.dropDeferred()
+			m.setLastFailure("")
 			return nil
 		}
 		return err
 	}
+	m.deps.Metrics.ObservePlanFetch(m.deps.Now())
 	if result.NotModified {
-		return nil
+		return m.retryDeferred(ctx, paused)
 	}
+	// The new plan supersedes any held one, even if it is paused or skipped
+	// below and never replaces it, so a later 304 cannot revive the old one.
+	// It also gets a fresh attempt, so an earlier failure no longer counts
+	// against readiness and cannot defer it.
+	m.dropDeferred()
+	m.setLastFailure("")
 
 	now := m.deps.Now().UTC()
 	statePlan := result.Plan.ToState(now, result.ETag)
@@ -171,6 +371,13 @@ func (m *Manager) poll(ctx context.Context) error {
 	}
 
 	m.deps.Logger.Printf("upgrade manager: fetched plan version=%s channel=%s paused=%t", result.Plan.Artifact.Version, result.Plan.Channel, result.Plan.Paused)
+	if request := state.Upgrade.ApplyNow; request.Version != "" &&
+		(request.Version != result.Plan.Artifact.Version || request.Version == state.Upgrade.Applied.Version) {
+		m.deps.Logger.Printf("upgrade manager: dropping apply-now request for version=%s; plan is version=%s, installed version=%s", request.Version, result.Plan.Artifact.Version, state.Upgrade.Applied.Version)
+		state.Upgrade.ApplyNow = config.UpgradeApplyNowState{}
+		m.saveState(ctx, state, "clear apply-now request")
+	}
+	m.deps.Metrics.ObserveUpgradeState(state.Upgrade.Applied.Version, channel, paused, result.Plan.Paused)
 	return m.applyPlan(ctx, result.Plan, state, paused)
 }
 
@@ -199,6 +406,22 @@ func (m *Manager) snapshot() (channel string, paused bool, etag string) {
 	return m.channel, m.paused, m.planETag
 }
 
+// disallowedChannel returns the channel, of the one the agent follows and
+// the one plan was published on, that the allowlist rejects, or "" when both
+// are allowed.
+func (m *Manager) disallowedChannel(plan Plan, state config.State) string {
+	followed := state.Upgrade.Channel
+	if followed == "" {
+		followed = "stable"
+	}
+	for _, channel := range []string{followed, plan.Channel} {
+		if channel != "" && !ChannelAllowed(m.cfg.AllowedChannels, channel) {
+			return channel
+		}
+	}
+	return ""
+}
+
 func (m *Manager) applyPlan(ctx context.Context, plan Plan, state config.State, locallyPaused bool) error {
 	if plan.Artifact.Version == "" {
 		return nil
@@ -215,19 +438,80 @@ func (m *Manager) applyPlan(ctx context.Context, plan Plan, state config.State,
 		return nil
 	}
 	now := m.deps.Now().UTC()
-	if plan.Schedule.Earliest != nil && now.Before(*plan.Schedule.Earliest) {
+	if plan.Artifact.Version == state.Upgrade.Applied.Version && !plan.Artifact.ForceApply {
+		return nil
+	}
+	if slices.Contains(state.Upgrade.BadVersions, plan.Artifact.Version) && !plan.Artifact.ForceApply {
+		m.deps.Logger.Printf("upgrade manager: skipping plan version=%s; it was marked bad after a rollback", plan.Artifact.Version)
+		return nil
+	}
+	if channel := m.disallowedChannel(plan, state); channel != "" {
+		m.deps.Logger.Printf("upgrade manager: refusing plan version=%s from channel=%s; allowed channels: %s", plan.Artifact.Version, channel, strings.Join(m.cfg.AllowedChannels, ","))
+		m.report(ctx, plan, state.AgentID, state.Upgrade.Applied.Version, "skipped", "channel "+channel+" not allowed on this agent", map[string]any{"stage": "channel", "channel": channel, "allowed_channels": m.cfg.AllowedChannels})
+		return nil
+	}
+	artifact, ok := plan.Artifact.ForPlatform(m.cfg.GOOS, m.cfg.GOARCH)
+	if !ok {
+		platform := m.cfg.GOOS + "/" + m.cfg.GOARCH
+		m.deps.Logger.Printf("upgrade manager: plan version=%s has no artifact for %s", plan.Artifact.Version, platform)
+		m.report(ctx, plan, state.AgentID, state.Upgrade.Applied.Version, "skipped", "no artifact for "+platform, map[string]any{"stage": "platform", "platform": platform})
+		return nil
+	}
+	plan.Artifact = artifact
+	applyNow := state.Upgrade.ApplyNow.Version == plan.Artifact.Version
+	if applyNow {
+		m.deps.Logger.Printf("upgrade manager: applying plan version=%s now at operator request; ignoring schedule windows", plan.Artifact.Version)
+	} else if plan.Schedule.Earliest != nil && now.Before(*plan.Schedule.Earliest) {
 		m.deps.Logger.Printf("upgrade manager: plan version=%s not within rollout window yet", plan.Artifact.Version)
+		m.holdPlan(plan)
+		m.prefetch(ctx, plan, state)
 		return nil
 	}
-	if plan.Artifact.Version == state.Upgrade.Applied.Version && !plan.Artifact.ForceApply {
+	if reasons := m.outsideWindows(plan, m.deps.Now().In(time.Local)); len(reasons) > 0 && !applyNow {
+		m.deferPlan(ctx, plan, state, "maintenance_window", "deferred: outside maintenance window", reasons)
+		m.prefetch(ctx, plan, state)
 		return nil
 	}
+	if m.deps.Readiness != nil && !plan.Artifact.ForceApply && !plan.Artifact.IgnoreReadiness {
+		if ready, reasons := m.deps.Readiness.Ready(now); !ready {
+			m.deferPlan(ctx, plan, state, "readiness", "deferred: not ready", reasons)
+			return nil
+		}
+	}
+	m.clearDeferred()
 	if m.deps.Applier == nil {
 		m.deps.Logger.Printf("upgrade manager: applier not configured; cannot apply plan version=%s", plan.Artifact.Version)
 		return nil
 	}
+	if m.cfg.DataDir != "" {
+		lock, err := AcquireLock(m.cfg.DataDir)
+		if errors.Is(err, ErrUpgradeLocked) {
+			m.deferPlan(ctx, plan, state, "lock", "deferred: another upgrade in progress", []string{err.Error()})
+			return nil
+		}
+		if err != nil {
+			m.report(ctx, plan, state.AgentID, state.Upgrade.Applied.Version, "failed", err.Error(), map[string]any{"stage": "lock"})
+			return err
+		}
+		// Held through the restart: exec closes the file and with it the lock.
+		defer lock.Release()
+		// The previous holder may have installed this version already.
+		if latest, err := m.deps.LoadState(ctx, m.cfg.DataDir); err == nil &&
+			latest.Upgrade.Applied.Version == plan.Artifact.Version && !plan.Artifact.ForceApply {
+			m.deps.Logger.Printf("upgrade manager: plan version=%s was applied by another process", plan.Artifact.Version)
+			return nil
+		}
+	}
 
-	applyResult, err := m.deps.Applier.Apply(ctx, plan, state)
+	applyResult, staged := m.stagedResult(plan, state)
+	var err error
+	if staged {
+		m.deps.Logger.Printf("upgrade manager: installing prefetched version=%s", plan.Artifact.Version)
+	} else {
+		applyResult, err = m.deps.Applier.Apply(ctx, plan, state)
+	}
+	state.Upgrade.Staged = config.UpgradeStagedState{}
+	state.Upgrade.ApplyNow = config.UpgradeApplyNowState{}
 	previousVersion := state.Upgrade.Applied.Version
 	state.Upgrade.Applied.LastAttempt = now
 
@@ -248,11 +532,19 @@ func (m *Manager) applyPlan(ctx context.Context, plan Plan, state config.State,
 		if m.deps.UpdateState != nil && m.cfg.DataDir != "" {
 			_ = m.deps.UpdateState(ctx, m.cfg.DataDir, state)
 		}
-		m.report(ctx, plan, state.AgentID, previousVersion, "failed", err.Error(), map[string]any{"stage": "apply"})
+		details := map[string]any{"stage": "apply"}
+		var diskErr *InsufficientDiskError
+		if errors.As(err, &diskErr) {
+			details["stage"] = "disk_space"
+			details["required_bytes"] = diskErr.Required
+			details["available_bytes"] = diskErr.Available
+		}
+		m.report(ctx, plan, state.AgentID, previousVersion, "failed", err.Error(), details)
 		return err
 	}
 
 	state.Upgrade.Applied.Version = plan.Artifact.Version
+	state.Upgrade.Applied.PreviousVersion = previousVersion
 	state.Upgrade.Applied.Path = installResult.TargetPath
 	state.Upgrade.Applied.AppliedAt = applyResult.AppliedAt
 	state.Upgrade.Applied.LastError = ""
@@ -268,13 +560,36 @@ func (m *Manager) applyPlan(ctx context.Context, plan Plan, state config.State,
 		"binary_path":    applyResult.BinaryPath,
 		"installed_path": installResult.TargetPath,
 	}
+	if applyResult.DeltaFrom != "" {
+		details["delta_from"] = applyResult.DeltaFrom
+	}
 	m.report(ctx, plan, state.AgentID, previousVersion, "success", fmt.Sprintf("applied %s", plan.Artifact.Version), details)
+	if m.cfg.DataDir != "" {
+		m.pruneBundles(state)
+	}
 
 	if m.restarter != nil && installResult.TargetPath != "" {
+		// The restarted process verifies its own health and rolls back
+		// through this record if it cannot reach the controller.
+		state.Upgrade.Pending = config.UpgradePendingState{
+			Version:         plan.Artifact.Version,
+			PreviousVersion: previousVersion,
+			Channel:         plan.Channel,
+			TargetPath:      installResult.TargetPath,
+			BackupPath:      installResult.BackupPath,
+			RestartedAt:     m.deps.Now().UTC(),
+		}
+		if m.deps.UpdateState != nil && m.cfg.DataDir != "" {
+			if updateErr := m.deps.UpdateState(ctx, m.cfg.DataDir, state); updateErr != nil {
+				m.deps.Logger.Printf("upgrade manager: failed to record pending upgrade: %v", updateErr)
+			}
+		}
 		restartErr := m.restarter.Restart(ctx, installResult.TargetPath, m.args, m.env)
 		if restartErr != nil {
+			state.Upgrade.Pending = config.UpgradePendingState{}
 			state.Upgrade.Applied.LastError = restartErr.Error()
 			state.Upgrade.Applied.Version = previousVersion
+			state.Upgrade.Applied.PreviousVersion = ""
 			if m.installer != nil {
 				if rbErr := m.installer.Rollback(ctx, installResult); rbErr != nil && m.deps.Logger != nil {
 					m.deps.Logger.Printf("upgrade manager: rollback failed: %v", rbErr)
@@ -291,7 +606,104 @@ func (m *Manager) applyPlan(ctx context.Context, plan Plan, state config.State,
 	return nil
 }
 
+// outsideWindows returns why local time t is outside the plan's or the
+// agent's maintenance windows, or nil when both allow the upgrade. A plan
+// window that does not parse blocks the upgrade rather than being ignored.
+func (m *Manager) outsideWindows(plan Plan, t time.Time) []string {
+	var reasons []string
+	planWindows, err := ParseMaintenanceWindows(plan.Schedule.MaintenanceWindows)
+	switch {
+	case err != nil:
+		reasons = append(reasons, fmt.Sprintf("plan %v", err))
+	case !InAnyWindow(planWindows, t):
+		reasons = append(reasons, "outside plan maintenance windows "+strings.Join(plan.Schedule.MaintenanceWindows, ", "))
+	}
+	if !InAnyWindow(m.cfg.MaintenanceWindows, t) {
+		var specs []string
+		for _, w := range m.cfg.MaintenanceWindows {
+			specs = append(specs, w.String())
+		}
+		reasons = append(reasons, "outside agent maintenance windows "+strings.Join(specs, ", "))
+	}
+	return reasons
+}
+
+// deferPlan holds the plan until the blocking condition clears: the agent
+// reports ready again or a maintenance window opens. The controller is told
+// once per version so a long deferral does not flood the upgrade history.
+func (m *Manager) deferPlan(ctx context.Context, plan Plan, state config.State, stage, message string, reasons []string) {
+	m.mu.Lock()
+	deferred := plan
+	m.deferred = &deferred
+	key := plan.Artifact.Version + "/" + stage
+	alreadyReported := m.deferredReported == key
+	m.deferredReported = key
+	m.mu.Unlock()
+
+	m.deps.Logger.Printf("upgrade manager: deferring plan version=%s; %s: %s", plan.Artifact.Version, strings.TrimPrefix(message, "deferred: "), strings.Join(reasons, "; "))
+	if alreadyReported {
+		return
+	}
+	details := map[string]any{
+		"stage":   stage,
+		"reasons": append([]string(nil), reasons...),
+	}
+	m.report(ctx, plan, state.AgentID, state.Upgrade.Applied.Version, "deferred", message, details)
+}
+
+// holdPlan keeps a plan waiting for schedule.earliest without reporting it,
+// so retryDeferred reconsiders it once conditional fetches return 304.
+func (m *Manager) holdPlan(plan Plan) {
+	m.mu.Lock()
+	m.deferred = &plan
+	m.mu.Unlock()
+}
+
+// dropDeferred forgets the held plan but keeps the report key, so a
+// re-fetched plan deferred for the same reason is not reported again.
+func (m *Manager) dropDeferred() {
+	m.mu.Lock()
+	m.deferred = nil
+	m.mu.Unlock()
+}
+
+func (m *Manager) clearDeferred() {
+	m.mu.Lock()
+	m.deferred = nil
+	m.deferredReported = ""
+	m.mu.Unlock()
+}
+
+// retryDeferred re-evaluates a plan previously held back by readiness, window
+// or schedule gating.
+// Conditional fetches return 304 for an unchanged plan, so the deferred copy is
+// the only way the manager learns it should try again.
+func (m *Manager) retryDeferred(ctx context.Context, paused bool) error {
+	m.mu.RLock()
+	deferred := m.deferred
+	m.mu.RUnlock()
+	if deferred == nil {
+		return nil
+	}
+	var state config.State
+	if m.deps.LoadState != nil && m.cfg.DataDir != "" {
+		loaded, err := m.deps.LoadState(ctx, m.cfg.DataDir)
+		if err != nil {
+			return err
+		}
+		state = loaded
+	}
+	return m.applyPlan(ctx, *deferred, state, paused)
+}
+
 func (m *Manager) report(ctx context.Context, plan Plan, agentID, previousVersion, status, message string, details map[string]any) {
+	m.deps.Metrics.ObserveUpgradeResult(status, plan.Artifact.Version, m.deps.Now())
+	switch status {
+	case "failed":
+		m.setLastFailure(fmt.Sprintf("upgrade to %s failed: %s", plan.Artifact.Version, message))
+	case "success":
+		m.setLastFailure("")
+	}
 	if m.deps.Reporter == nil {
 		return
 	}
@@ -1,15 +1,23 @@
 package upgrade
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"io"
 	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/metrics"
 )
 
 type fakePlanFetcher struct {
@@ -49,16 +57,18 @@ func (f *fakeStateStore) Update(ctx context.Context, _ string, state config.Stat
 }
 
 type fakeApplier struct {
-	mu     sync.Mutex
-	calls  int
-	result ApplyResult
-	err    error
+	mu       sync.Mutex
+	calls    int
+	lastPlan Plan
+	result   ApplyResult
+	err      error
 }
 
 func (f *fakeApplier) Apply(ctx context.Context, plan Plan, state config.State) (ApplyResult, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.calls++
+	f.lastPlan = plan
 	return f.result, f.err
 }
 
@@ -68,12 +78,14 @@ type fakeInstaller struct {
 	err           error
 	installCalls  int
 	rollbackCalls int
+	lastSource    string
 }
 
 func (f *fakeInstaller) Install(ctx context.Context, sourcePath string) (InstallResult, error) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
 	f.installCalls++
+	f.lastSource = sourcePath
 	if f.result.TargetPath == "" {
 		f.result.TargetPath = sourcePath
 	}
@@ -126,7 +138,7 @@ func TestManagerReloadsStateAndPersistsETag(t *testing.T) {
 		},
 	}
 	mgr := NewManager(
-		Config{DataDir: "/fake", PollInterval: 50 * time.Millisecond},
+		Config{DataDir: t.TempDir(), PollInterval: 50 * time.Millisecond},
 		Dependencies{
 			LoadState: store.Load,
 		},
@@ -190,7 +202,7 @@ func TestManagerPollAppliesPlan(t *testing.T) {
 	reporter := &fakeReporter{}
 
 	mgr := NewManager(
-		Config{DataDir: "/fake"},
+		Config{DataDir: t.TempDir()},
 		Dependencies{
 			Logger:      log.New(io.Discard, "", 0),
 			LoadState:   store.Load,
@@ -269,7 +281,7 @@ func TestManagerPollRespectsLocalPause(t *testing.T) {
 	reporter := &fakeReporter{}
 
 	mgr := NewManager(
-		Config{DataDir: "/fake"},
+		Config{DataDir: t.TempDir()},
 		Dependencies{
 			LoadState:   store.Load,
 			UpdateState: store.Update,
@@ -329,7 +341,7 @@ func TestManagerPollForceApplyOverridesPause(t *testing.T) {
 	installer := &fakeInstaller{result: InstallResult{TargetPath: "/usr/local/bin/pingsanto-agent"}}
 
 	mgr := NewManager(
-		Config{DataDir: "/fake"},
+		Config{DataDir: t.TempDir()},
 		Dependencies{
 			LoadState:   store.Load,
 			UpdateState: store.Update,
@@ -363,7 +375,7 @@ func TestManagerPollHandlesMissingPlan(t *testing.T) {
 	}
 	fetcher := &fakePlanFetcher{err: ErrPlanNotFound}
 	mgr := NewManager(
-		Config{DataDir: "/fake"},
+		Config{DataDir: t.TempDir()},
 		Dependencies{
 			LoadState:   store.Load,
 			UpdateState: store.Update,
@@ -386,7 +398,7 @@ func TestManagerPollPropagatesFetchError(t *testing.T) {
 	}
 	fetcher := &fakePlanFetcher{err: errors.New("network")}
 	mgr := NewManager(
-		Config{DataDir: "/fake"},
+		Config{DataDir: t.TempDir()},
 		Dependencies{
 			LoadState:   store.Load,
 			UpdateState: store.Update,
@@ -435,7 +447,7 @@ func TestManagerRestartFailureRollsBack(t *testing.T) {
 	reporter := &fakeReporter{}
 
 	mgr := NewManager(
-		Config{DataDir: "/fake"},
+		Config{DataDir: t.TempDir()},
 		Dependencies{
 			Logger:      log.New(io.Discard, "", 0),
 			LoadState:   store.Load,
@@ -466,6 +478,9 @@ func TestManagerRestartFailureRollsBack(t *testing.T) {
 	if reporter.reports[len(reporter.reports)-1].Status != "failed" {
 		t.Fatalf("expected final report to be failure")
 	}
+	if got := mgr.LastFailure(); !strings.Contains(got, "exec failed") {
+		t.Fatalf("expected failure reported to readiness, got %q", got)
+	}
 	store.mu.Lock()
 	final := store.state.Upgrade
 	store.mu.Unlock()
@@ -476,3 +491,852 @@ func TestManagerRestartFailureRollsBack(t *testing.T) {
 		t.Fatalf("expected last error recorded")
 	}
 }
+
+type fakeReadiness struct {
+	mu      sync.Mutex
+	ready   bool
+	reasons []string
+	calls   int
+}
+
+func (f *fakeReadiness) Ready(now time.Time) (bool, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.ready, append([]string(nil), f.reasons...)
+}
+
+func (f *fakeReadiness) set(ready bool, reasons ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.ready = ready
+	f.reasons = reasons
+}
+
+func TestManagerDefersPlanWhenNotReady(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{
+		state: config.State{
+			AgentID: "agt-1",
+			Upgrade: config.UpgradeState{
+				Channel: "stable",
+				Applied: config.UpgradeAppliedState{Version: "1.0.0"},
+			},
+		},
+	}
+	fetcher := &fakePlanFetcher{
+		result: PlanResult{
+			Plan: Plan{
+				AgentID:  "channel:stable",
+				Channel:  "stable",
+				Artifact: PlanArtifact{Version: "1.1.0", URL: "https://example.com", SHA256: "abc"},
+			},
+			ETag: `"etag-1"`,
+		},
+	}
+	applier := &fakeApplier{result: ApplyResult{BinaryPath: "/opt/tmp/bundle/pingsanto-agent"}}
+	reporter := &fakeReporter{}
+	readiness := &fakeReadiness{}
+	readiness.set(false, "queue capacity exceeded")
+
+	mgr := NewManager(
+		Config{DataDir: t.TempDir()},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Reporter:    reporter,
+			Readiness:   readiness,
+			Now: func() time.Time {
+				return time.Unix(1730000000, 0)
+			},
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll returned error: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected applier not invoked while not ready, got %d", applier.calls)
+	}
+	if len(reporter.reports) != 1 {
+		t.Fatalf("expected deferral report, got %d", len(reporter.reports))
+	}
+	rep := reporter.reports[0]
+	if rep.Status != "deferred" || rep.Message != "deferred: not ready" {
+		t.Fatalf("unexpected deferral report: %#v", rep)
+	}
+
+	// Unchanged plan (304) while still not ready should not emit another report.
+	fetcher.mu.Lock()
+	fetcher.result = PlanResult{NotModified: true, ETag: `"etag-1"`}
+	fetcher.mu.Unlock()
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("second poll returned error: %v", err)
+	}
+	if applier.calls != 0 || len(reporter.reports) != 1 {
+		t.Fatalf("expected no apply or extra report, got calls=%d reports=%d", applier.calls, len(reporter.reports))
+	}
+
+	readiness.set(true)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("third poll returned error: %v", err)
+	}
+	if applier.calls != 1 {
+		t.Fatalf("expected deferred plan applied once ready, got %d", applier.calls)
+	}
+	last := reporter.reports[len(reporter.reports)-1]
+	if last.Status != "success" {
+		t.Fatalf("expected success report after readiness recovered, got %#v", last)
+	}
+}
+
+func TestManagerDropsDeferredPlanWhenPlanChanges(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{
+		state: config.State{
+			AgentID: "agt-1",
+			Upgrade: config.UpgradeState{
+				Channel: "stable",
+				Applied: config.UpgradeAppliedState{Version: "1.0.0"},
+			},
+		},
+	}
+	fetcher := &fakePlanFetcher{
+		result: PlanResult{
+			Plan: Plan{Channel: "stable", Artifact: PlanArtifact{Version: "1.1.0", URL: "https://example.com", SHA256: "abc"}},
+			ETag: `"etag-1"`,
+		},
+	}
+	applier := &fakeApplier{result: ApplyResult{BinaryPath: "/opt/tmp/bundle/pingsanto-agent"}}
+	readiness := &fakeReadiness{}
+	readiness.set(false, "queue capacity exceeded")
+	mgr := NewManager(
+		Config{DataDir: t.TempDir()},
+		Dependencies{
+			Logger:      log.New(io.Discard, "", 0),
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Reporter:    &fakeReporter{},
+			Readiness:   readiness,
+			Now:         func() time.Time { return time.Unix(1730000000, 0) },
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	// The operator replaces 1.1.0 with a paused 1.2.0.
+	fetcher.mu.Lock()
+	fetcher.result = PlanResult{
+		Plan: Plan{Channel: "stable", Paused: true, Artifact: PlanArtifact{Version: "1.2.0", URL: "https://example.com", SHA256: "def"}},
+		ETag: `"etag-2"`,
+	}
+	fetcher.mu.Unlock()
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	readiness.set(true)
+	fetcher.mu.Lock()
+	fetcher.result = PlanResult{NotModified: true, ETag: `"etag-2"`}
+	fetcher.mu.Unlock()
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("superseded plan %s was applied", applier.lastPlan.Artifact.Version)
+	}
+}
+
+func TestManagerIgnoreReadinessOverridesGate(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{
+		state: config.State{
+			AgentID: "agt-1",
+			Upgrade: config.UpgradeState{Channel: "stable"},
+		},
+	}
+	fetcher := &fakePlanFetcher{
+		result: PlanResult{
+			Plan: Plan{
+				Channel: "stable",
+				Artifact: PlanArtifact{
+					Version:         "1.1.0",
+					URL:             "https://example.com",
+					IgnoreReadiness: true,
+				},
+			},
+		},
+	}
+	applier := &fakeApplier{result: ApplyResult{BinaryPath: "/opt/tmp/bundle/pingsanto-agent"}}
+	readiness := &fakeReadiness{}
+	readiness.set(false, "monitors not yet synced")
+
+	mgr := NewManager(
+		Config{DataDir: t.TempDir()},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Readiness:   readiness,
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll returned error: %v", err)
+	}
+	if applier.calls != 1 {
+		t.Fatalf("expected applier invoked despite readiness, got %d", applier.calls)
+	}
+	if readiness.calls != 0 {
+		t.Fatalf("expected readiness not consulted when overridden, got %d", readiness.calls)
+	}
+}
+
+func TestManagerRecordsUpgradeMetrics(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{
+		state: config.State{
+			AgentID: "agt-1",
+			Upgrade: config.UpgradeState{
+				Channel: "canary",
+				Paused:  true,
+				Applied: config.UpgradeAppliedState{Version: "1.0.0"},
+			},
+		},
+	}
+	fetcher := &fakePlanFetcher{
+		result: PlanResult{
+			Plan: Plan{
+				Channel:  "canary",
+				Artifact: PlanArtifact{Version: "1.1.0", URL: "https://example.com", SHA256: "abc"},
+			},
+			ETag: `"etag-new"`,
+		},
+	}
+	metricsStore := metrics.NewStore()
+	mgr := NewManager(
+		Config{DataDir: t.TempDir()},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     &fakeApplier{err: errors.New("download failed")},
+			Metrics:     metricsStore.UpgradeRecorder(),
+			Now: func() time.Time {
+				return time.Unix(1730000000, 0)
+			},
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll returned error: %v", err)
+	}
+	// Locally paused: the plan is fetched but not applied.
+	var buf bytes.Buffer
+	if err := metricsStore.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`pingsanto_agent_upgrade_info{version="1.0.0",channel="canary"} 1`,
+		`pingsanto_agent_upgrade_paused{source="local"} 1`,
+		`pingsanto_agent_upgrade_paused{source="controller"} 0`,
+		`pingsanto_agent_upgrade_plan_fetch_timestamp_seconds 1.73e+09`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in metrics:\n%s", want, out)
+		}
+	}
+
+	store.mu.Lock()
+	store.state.Upgrade.Paused = false
+	store.mu.Unlock()
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err == nil {
+		t.Fatalf("expected apply error")
+	}
+	buf.Reset()
+	if err := metricsStore.WritePrometheus(&buf); err != nil {
+		t.Fatalf("WritePrometheus: %v", err)
+	}
+	out = buf.String()
+	for _, want := range []string{
+		`pingsanto_agent_upgrade_paused{source="local"} 0`,
+		`pingsanto_agent_upgrade_last_result_info{status="failed",version="1.1.0"} 1`,
+		`pingsanto_agent_upgrade_attempts_total{status="failed"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in metrics:\n%s", want, out)
+		}
+	}
+}
+
+type fakeHealth struct {
+	mu      sync.Mutex
+	ok      bool
+	reasons []string
+	calls   int
+}
+
+func (f *fakeHealth) Operational(now time.Time) (bool, []string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return f.ok, f.reasons
+}
+
+func pendingUpgradeState(restartedAt time.Time) config.State {
+	return config.State{
+		AgentID: "agt-1",
+		Upgrade: config.UpgradeState{
+			Channel: "stable",
+			Applied: config.UpgradeAppliedState{Version: "1.2.0"},
+			Pending: config.UpgradePendingState{
+				Version:         "1.2.0",
+				PreviousVersion: "1.0.0",
+				Channel:         "stable",
+				TargetPath:      "/usr/local/bin/pingsanto-agent",
+				BackupPath:      "/usr/local/bin/pingsanto-agent.bak",
+				RestartedAt:     restartedAt,
+			},
+		},
+	}
+}
+
+func TestManagerRecordsPendingUpgradeBeforeRestart(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{Channel: "stable", Artifact: PlanArtifact{Version: "1.2.0", ForceApply: true}}}}
+	var pendingAtRestart config.UpgradePendingState
+	restarter := &pendingCapturingRestarter{store: store, captured: &pendingAtRestart}
+
+	mgr := NewManager(
+		Config{DataDir: t.TempDir()},
+		Dependencies{
+			Logger:      log.New(io.Discard, "", 0),
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     &fakeApplier{result: ApplyResult{BinaryPath: "/tmp/bundle/pingsanto-agent"}},
+			Installer:   &fakeInstaller{result: InstallResult{TargetPath: "/usr/local/bin/pingsanto-agent", BackupPath: "/usr/local/bin/pingsanto-agent.bak"}},
+			Restarter:   restarter,
+			Now:         func() time.Time { return time.Unix(1730000000, 0) },
+		},
+	)
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if pendingAtRestart.Version != "1.2.0" || pendingAtRestart.PreviousVersion != "1.0.0" || pendingAtRestart.BackupPath != "/usr/local/bin/pingsanto-agent.bak" {
+		t.Fatalf("pending upgrade not persisted before restart: %+v", pendingAtRestart)
+	}
+	if !pendingAtRestart.RestartedAt.Equal(time.Unix(1730000000, 0)) {
+		t.Fatalf("unexpected restarted_at %s", pendingAtRestart.RestartedAt)
+	}
+}
+
+type pendingCapturingRestarter struct {
+	store    *fakeStateStore
+	captured *config.UpgradePendingState
+}
+
+func (r *pendingCapturingRestarter) Restart(ctx context.Context, binaryPath string, args []string, env []string) error {
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	*r.captured = r.store.state.Upgrade.Pending
+	return nil
+}
+
+func TestManagerVerifyPendingAcceptsHealthyUpgrade(t *testing.T) {
+	store := &fakeStateStore{state: pendingUpgradeState(time.Now())}
+	installer := &fakeInstaller{}
+	reporter := &fakeReporter{}
+	mgr := NewManager(
+		Config{DataDir: t.TempDir(), VerifyWindow: time.Minute},
+		Dependencies{
+			Logger:      log.New(io.Discard, "", 0),
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			Installer:   installer,
+			Reporter:    reporter,
+			Health:      &fakeHealth{ok: true},
+		},
+	)
+	if err := mgr.verifyPending(context.Background()); err != nil {
+		t.Fatalf("verifyPending: %v", err)
+	}
+	if installer.rollbackCalls != 0 || len(reporter.reports) != 0 {
+		t.Fatalf("healthy upgrade should not roll back or report")
+	}
+	if !store.state.Upgrade.Pending.Verified {
+		t.Fatalf("expected pending upgrade marked verified, got %+v", store.state.Upgrade.Pending)
+	}
+	if store.state.Upgrade.Applied.Version != "1.2.0" {
+		t.Fatalf("expected applied version kept, got %s", store.state.Upgrade.Applied.Version)
+	}
+}
+
+func TestManagerVerifyPendingRollsBackUnhealthyUpgrade(t *testing.T) {
+	orig := verifyInterval
+	verifyInterval = 5 * time.Millisecond
+	defer func() { verifyInterval = orig }()
+
+	store := &fakeStateStore{state: pendingUpgradeState(time.Now())}
+	installer := &fakeInstaller{}
+	restarter := &fakeRestarter{}
+	reporter := &fakeReporter{}
+	healthVerifier := &fakeHealth{reasons: []string{"monitors not yet synced"}}
+	mgr := NewManager(
+		Config{DataDir: t.TempDir(), VerifyWindow: 30 * time.Millisecond},
+		Dependencies{
+			Logger:      log.New(io.Discard, "", 0),
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			Installer:   installer,
+			Restarter:   restarter,
+			Reporter:    reporter,
+			Health:      healthVerifier,
+		},
+	)
+	if err := mgr.verifyPending(context.Background()); err != nil {
+		t.Fatalf("verifyPending: %v", err)
+	}
+	if healthVerifier.calls < 2 {
+		t.Fatalf("expected health re-checked within the window, got %d checks", healthVerifier.calls)
+	}
+	if installer.rollbackCalls != 1 {
+		t.Fatalf("expected one rollback, got %d", installer.rollbackCalls)
+	}
+	if restarter.calls != 1 {
+		t.Fatalf("expected restart into restored binary, got %d", restarter.calls)
+	}
+	final := store.state.Upgrade
+	if final.Pending.Version != "" || final.Applied.Version != "1.0.0" {
+		t.Fatalf("unexpected state after rollback: %+v", final)
+	}
+	if !strings.Contains(final.Applied.LastError, "monitors not yet synced") {
+		t.Fatalf("expected failure reason recorded, got %q", final.Applied.LastError)
+	}
+	if len(reporter.reports) != 1 {
+		t.Fatalf("expected one report, got %d", len(reporter.reports))
+	}
+	rep := reporter.reports[0]
+	if rep.Status != "failed" || rep.CurrentVersion != "1.2.0" || rep.Details["stage"] != "verify" || rep.Details["rolled_back"] != true {
+		t.Fatalf("unexpected report: %#v", rep)
+	}
+	if !slices.Equal(final.BadVersions, []string{"1.2.0"}) {
+		t.Fatalf("expected 1.2.0 marked bad, got %v", final.BadVersions)
+	}
+
+	// The controller still offers 1.2.0; the agent must not reinstall it.
+	applier := &fakeApplier{}
+	mgr.deps.PlanFetcher = &fakePlanFetcher{result: PlanResult{Plan: Plan{Channel: "stable", Artifact: PlanArtifact{Version: "1.2.0"}}}}
+	mgr.deps.Applier = applier
+	mgr.reload(context.Background())
+	if err := mgr.poll(context.Background()); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected bad version to be skipped, applied %d times", applier.calls)
+	}
+}
+
+func TestManagerSelectsPlatformArtifact(t *testing.T) {
+	ctx := context.Background()
+	plan := Plan{
+		Channel: "stable",
+		Artifact: PlanArtifact{
+			Version:    "1.2.0",
+			URL:        "https://example.com/amd64.tar.gz",
+			SHA256:     "amd",
+			Mirrors:    []PlanMirror{{URL: "https://mirror.example.com/amd64.tar.gz"}},
+			ForceApply: true,
+			Platforms: []PlanPlatform{{
+				OS:     "linux",
+				Arch:   "arm64",
+				URL:    "https://example.com/arm64.tar.gz",
+				SHA256: "arm",
+			}},
+		},
+	}
+	newManager := func(goos, goarch string, applier *fakeApplier, reporter *fakeReporter) *Manager {
+		store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}}
+		return NewManager(
+			Config{DataDir: t.TempDir(), GOOS: goos, GOARCH: goarch},
+			Dependencies{
+				LoadState:   store.Load,
+				UpdateState: store.Update,
+				PlanFetcher: &fakePlanFetcher{result: PlanResult{Plan: plan}},
+				Applier:     applier,
+				Reporter:    reporter,
+			},
+		)
+	}
+
+	arm := &fakeApplier{}
+	mgr := newManager("linux", "arm64", arm, &fakeReporter{})
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	got := arm.lastPlan.Artifact
+	if got.URL != "https://example.com/arm64.tar.gz" || got.SHA256 != "arm" || len(got.Mirrors) != 0 {
+		t.Fatalf("expected arm64 artifact without amd64 mirrors, got %+v", got)
+	}
+
+	amd := &fakeApplier{}
+	mgr = newManager("linux", "amd64", amd, &fakeReporter{})
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if got := amd.lastPlan.Artifact; got.URL != "https://example.com/amd64.tar.gz" || len(got.Mirrors) != 1 {
+		t.Fatalf("expected top-level artifact, got %+v", got)
+	}
+
+	plan.Artifact.URL = ""
+	none := &fakeApplier{}
+	reporter := &fakeReporter{}
+	mgr = newManager("windows", "amd64", none, reporter)
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if none.calls != 0 {
+		t.Fatalf("expected no apply without a matching artifact")
+	}
+	if len(reporter.reports) != 1 || reporter.reports[0].Status != "skipped" || reporter.reports[0].Details["platform"] != "windows/amd64" {
+		t.Fatalf("unexpected reports %#v", reporter.reports)
+	}
+}
+
+func TestManagerRefusesPlanFromDisallowedChannel(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{
+		Channel:  "canary",
+		Artifact: PlanArtifact{Version: "1.2.0-rc1", ForceApply: true},
+	}}}
+	applier := &fakeApplier{}
+	reporter := &fakeReporter{}
+	mgr := NewManager(
+		Config{DataDir: t.TempDir(), AllowedChannels: []string{"stable"}},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Reporter:    reporter,
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected canary plan refused on a stable-only agent")
+	}
+	if len(reporter.reports) != 1 || reporter.reports[0].Status != "skipped" || reporter.reports[0].Details["stage"] != "channel" || reporter.reports[0].Details["channel"] != "canary" {
+		t.Fatalf("unexpected reports %#v", reporter.reports)
+	}
+
+	fetcher.result.Plan.Channel = "stable"
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 1 {
+		t.Fatalf("expected stable plan applied, got %d calls", applier.calls)
+	}
+}
+
+func TestManagerDefersPlanOutsideMaintenanceWindow(t *testing.T) {
+	ctx := context.Background()
+	localWindows, err := ParseMaintenanceWindows([]string{"Sat 00:00-06:00"})
+	if err != nil {
+		t.Fatalf("parse windows: %v", err)
+	}
+	store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{
+		Channel:  "stable",
+		Artifact: PlanArtifact{Version: "1.2.0", ForceApply: true},
+		Schedule: PlanSchedule{MaintenanceWindows: []string{"Sat 02:00-04:00"}},
+	}}}
+	applier := &fakeApplier{}
+	reporter := &fakeReporter{}
+	// 2025-11-01 is a Saturday; 01:00 is inside the agent's window only.
+	now := time.Date(2025, time.November, 1, 1, 0, 0, 0, time.Local)
+	mgr := NewManager(
+		Config{DataDir: t.TempDir(), MaintenanceWindows: localWindows},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Reporter:    reporter,
+			Now:         func() time.Time { return now },
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected plan deferred outside the plan window")
+	}
+	if len(reporter.reports) != 1 || reporter.reports[0].Status != "deferred" || reporter.reports[0].Details["stage"] != "maintenance_window" {
+		t.Fatalf("unexpected reports %#v", reporter.reports)
+	}
+
+	now = time.Date(2025, time.November, 1, 5, 0, 0, 0, time.Local)
+	fetcher.result = PlanResult{NotModified: true}
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("05:00 is outside the plan window")
+	}
+
+	now = time.Date(2025, time.November, 1, 3, 0, 0, 0, time.Local)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 1 {
+		t.Fatalf("expected deferred plan applied once both windows open, got %d calls", applier.calls)
+	}
+}
+
+func TestManagerDefersPlanWhileUpgradeLocked(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	held, err := AcquireLock(dataDir)
+	if err != nil {
+		t.Fatalf("acquire lock: %v", err)
+	}
+	store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{Channel: "stable", Artifact: PlanArtifact{Version: "1.2.0"}}}}
+	applier := &fakeApplier{}
+	reporter := &fakeReporter{}
+	mgr := NewManager(
+		Config{DataDir: dataDir},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Reporter:    reporter,
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected no install while another process holds the lock")
+	}
+	if len(reporter.reports) != 1 || reporter.reports[0].Details["stage"] != "lock" {
+		t.Fatalf("unexpected reports %#v", reporter.reports)
+	}
+
+	// The holder installed the same version before releasing the lock.
+	store.state.Upgrade.Applied.Version = "1.2.0"
+	if err := held.Release(); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	fetcher.result = PlanResult{NotModified: true}
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 0 {
+		t.Fatalf("expected version installed by the lock holder to be skipped")
+	}
+}
+
+func TestManagerPrefetchesBeforeRolloutWindow(t *testing.T) {
+	ctx := context.Background()
+	dataDir := t.TempDir()
+	artifactBytes := []byte("artifact")
+	sum := sha256.Sum256(artifactBytes)
+	artifactPath := filepath.Join(dataDir, "artifact.tar.gz")
+	binaryPath := filepath.Join(dataDir, "pingsanto-agent")
+	if err := os.WriteFile(artifactPath, artifactBytes, 0o644); err != nil {
+		t.Fatalf("write artifact: %v", err)
+	}
+	if err := os.WriteFile(binaryPath, []byte("binary"), 0o755); err != nil {
+		t.Fatalf("write binary: %v", err)
+	}
+
+	earliest := time.Date(2025, time.November, 1, 2, 0, 0, 0, time.UTC)
+	now := earliest.Add(-time.Hour)
+	store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.0.0"}}}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{
+		Channel:  "stable",
+		Artifact: PlanArtifact{Version: "1.2.0", SHA256: hex.EncodeToString(sum[:])},
+		Schedule: PlanSchedule{Earliest: &earliest, Prefetch: true},
+	}}}
+	applier := &fakeApplier{result: ApplyResult{AppliedVersion: "1.2.0", ArtifactPath: artifactPath, BinaryPath: binaryPath}}
+	installer := &fakeInstaller{}
+	reporter := &fakeReporter{}
+	mgr := NewManager(
+		Config{DataDir: dataDir},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Installer:   installer,
+			Reporter:    reporter,
+			Now:         func() time.Time { return now },
+		},
+	)
+
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 1 || installer.installCalls != 0 {
+		t.Fatalf("expected download only before the window, got %d applies and %d installs", applier.calls, installer.installCalls)
+	}
+	if staged := store.state.Upgrade.Staged; staged.Version != "1.2.0" || staged.BinaryPath != binaryPath {
+		t.Fatalf("unexpected staged state %+v", staged)
+	}
+	if len(reporter.reports) != 1 || reporter.reports[0].Status != "staged" {
+		t.Fatalf("unexpected reports %#v", reporter.reports)
+	}
+
+	// Still waiting: the staged bundle is not downloaded again.
+	fetcher.result = PlanResult{NotModified: true}
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 1 {
+		t.Fatalf("expected staged bundle reused, got %d applies", applier.calls)
+	}
+
+	now = earliest.Add(time.Minute)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if applier.calls != 1 || installer.installCalls != 1 || installer.lastSource != binaryPath {
+		t.Fatalf("expected staged binary installed without download, got %d applies, install of %q", applier.calls, installer.lastSource)
+	}
+	if got := store.state.Upgrade; got.Applied.Version != "1.2.0" || got.Staged.Version != "" {
+		t.Fatalf("unexpected upgrade state %+v", got)
+	}
+}
+
+func TestManagerApplyNowIgnoresScheduleWindows(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, time.November, 1, 12, 0, 0, 0, time.UTC)
+	earliest := now.Add(24 * time.Hour)
+	store := &fakeStateStore{state: config.State{Upgrade: config.UpgradeState{
+		Applied: config.UpgradeAppliedState{Version: "1.0.0"},
+		Plan:    config.UpgradePlanState{Version: "1.2.0", ETag: `"v1"`},
+	}}}
+	fetcher := &fakePlanFetcher{result: PlanResult{Plan: Plan{
+		Channel:  "stable",
+		Artifact: PlanArtifact{Version: "1.2.0"},
+		Schedule: PlanSchedule{Earliest: &earliest, MaintenanceWindows: []string{"Sun 02:00-03:00"}},
+	}, ETag: `"v1"`}}
+	applier := &fakeApplier{}
+	mgr := NewManager(
+		Config{DataDir: t.TempDir()},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+			Applier:     applier,
+			Now:         func() time.Time { return now },
+		},
+	)
+
+	if mgr.applyNowRequested(ctx) {
+		t.Fatalf("no request recorded yet")
+	}
+	store.state.Upgrade.ApplyNow = config.UpgradeApplyNowState{Version: "1.2.0", RequestedAt: now}
+	if !mgr.applyNowRequested(ctx) || mgr.applyNowRequested(ctx) {
+		t.Fatalf("expected the request to be seen exactly once")
+	}
+	mgr.reload(ctx)
+	if err := mgr.poll(ctx); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	if fetcher.lastETag != "" {
+		t.Fatalf("expected unconditional fetch, sent %q", fetcher.lastETag)
+	}
+	if applier.calls != 1 {
+		t.Fatalf("expected plan applied despite schedule windows, got %d calls", applier.calls)
+	}
+	if got := store.state.Upgrade; got.ApplyNow.Version != "" || got.Applied.Version != "1.2.0" {
+		t.Fatalf("unexpected upgrade state %+v", got)
+	}
+}
+
+type streamingPlanFetcher struct {
+	*fakePlanFetcher
+	push chan PlanResult
+}
+
+func (f *streamingPlanFetcher) StreamPlan(ctx context.Context, channel, etag string, handle func(PlanResult) error) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case result := <-f.push:
+			if err := handle(result); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func TestManagerPollsWhenPlanPushed(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	store := &fakeStateStore{
+		state: config.State{
+			Upgrade: config.UpgradeState{Channel: "stable"},
+		},
+	}
+	fetcher := &streamingPlanFetcher{fakePlanFetcher: &fakePlanFetcher{err: ErrPlanNotFound}, push: make(chan PlanResult)}
+	mgr := NewManager(
+		Config{DataDir: t.TempDir(), PollInterval: time.Hour, PushPlans: true},
+		Dependencies{
+			LoadState:   store.Load,
+			UpdateState: store.Update,
+			PlanFetcher: fetcher,
+		},
+	)
+	done := make(chan error, 1)
+	go func() { done <- mgr.Run(ctx) }()
+
+	fetcher.push <- PlanResult{ETag: `"etag-new"`}
+	for {
+		fetcher.mu.Lock()
+		calls := fetcher.calls
+		fetcher.mu.Unlock()
+		if calls >= 2 {
+			break
+		}
+		select {
+		case err := <-done:
+			t.Fatalf("Run returned early: %v", err)
+		case <-ctx.Done():
+			t.Fatalf("pushed plan did not trigger a poll")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	cancel()
+	<-done
+}
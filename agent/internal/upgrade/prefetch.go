@@ -0,0 +1,74 @@
+package upgrade
+
+import (
+	"context"
+	"os"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+// prefetch downloads, verifies and extracts the artifact of a plan that sets
+// schedule.prefetch while the plan waits for its rollout window, so the
+// install inside the window only swaps binaries and restarts. Failures are
+// logged and left to the install, which downloads again.
+func (m *Manager) prefetch(ctx context.Context, plan Plan, state config.State) {
+	if !plan.Schedule.Prefetch || m.deps.Applier == nil || m.cfg.DataDir == "" {
+		return
+	}
+	if _, ok := m.stagedResult(plan, state); ok {
+		return
+	}
+	lock, err := AcquireLock(m.cfg.DataDir)
+	if err != nil {
+		m.deps.Logger.Printf("upgrade manager: prefetch of version=%s postponed: %v", plan.Artifact.Version, err)
+		return
+	}
+	defer lock.Release()
+
+	result, err := m.deps.Applier.Apply(ctx, plan, state)
+	if err != nil {
+		m.deps.Logger.Printf("upgrade manager: prefetch of version=%s failed: %v", plan.Artifact.Version, err)
+		return
+	}
+	state.Upgrade.Staged = config.UpgradeStagedState{
+		Version:      plan.Artifact.Version,
+		SHA256:       plan.Artifact.SHA256,
+		ArtifactPath: result.ArtifactPath,
+		BundlePath:   result.BundlePath,
+		BinaryPath:   result.BinaryPath,
+		DeltaFrom:    result.DeltaFrom,
+		StagedAt:     m.deps.Now().UTC(),
+	}
+	m.saveState(ctx, state, "record staged upgrade")
+	m.deps.Logger.Printf("upgrade manager: prefetched version=%s; install waits for the rollout window", plan.Artifact.Version)
+	details := map[string]any{
+		"stage":       "prefetch",
+		"bundle_path": result.BundlePath,
+		"binary_path": result.BinaryPath,
+	}
+	m.report(ctx, plan, state.AgentID, state.Upgrade.Applied.Version, "staged", "prefetched "+plan.Artifact.Version, details)
+}
+
+// stagedResult returns the prefetched bundle for plan when one is recorded,
+// still on disk, and its artifact still matches the plan checksum.
+func (m *Manager) stagedResult(plan Plan, state config.State) (ApplyResult, bool) {
+	staged := state.Upgrade.Staged
+	if staged.Version == "" || staged.Version != plan.Artifact.Version || staged.SHA256 != plan.Artifact.SHA256 {
+		return ApplyResult{}, false
+	}
+	if _, err := os.Stat(staged.BinaryPath); err != nil {
+		return ApplyResult{}, false
+	}
+	if err := verifySHA256(staged.ArtifactPath, staged.SHA256); err != nil {
+		return ApplyResult{}, false
+	}
+	return ApplyResult{
+		AppliedVersion:  plan.Artifact.Version,
+		PreviousVersion: state.Upgrade.Applied.Version,
+		AppliedAt:       m.deps.Now().UTC(),
+		BundlePath:      staged.BundlePath,
+		ArtifactPath:    staged.ArtifactPath,
+		BinaryPath:      staged.BinaryPath,
+		DeltaFrom:       staged.DeltaFrom,
+	}, true
+}
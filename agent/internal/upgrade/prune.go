@@ -0,0 +1,100 @@
+package upgrade
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+// defaultKeepBundles is how many version directories under upgrades/ are
+// kept when Config.KeepBundles is unset.
+const defaultKeepBundles = 3
+
+// PruneBundles removes version directories under dataDir/upgrades, holding
+// downloaded artifacts and extracted bundles, beyond the keep most recently
+// modified. Versions in protect are never removed. It returns the removed
+// versions; a directory that cannot be removed is skipped and its error
+// returned with the rest.
+func PruneBundles(dataDir string, keep int, protect ...string) ([]string, error) {
+	root := filepath.Join(dataDir, "upgrades")
+	entries, err := os.ReadDir(root)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	type bundle struct {
+		version string
+		modTime time.Time
+	}
+	var bundles []bundle
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		bundles = append(bundles, bundle{version: entry.Name(), modTime: info.ModTime()})
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].modTime.After(bundles[j].modTime) })
+
+	var removed []string
+	var errs []error
+	for i, b := range bundles {
+		if i < keep || slices.Contains(protect, b.version) {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(root, b.version)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removed = append(removed, b.version)
+	}
+	return removed, errors.Join(errs...)
+}
+
+// pruneBundles applies PruneBundles with the configured retention, keeping
+// every version the state still refers to: the installed one (the base for
+// delta patches), the one it replaced, a prefetched one and the current plan,
+// whose partial download may be resumed. The caller holds the upgrade lock.
+func (m *Manager) pruneBundles(state config.State) {
+	protect := []string{
+		state.Upgrade.Applied.Version,
+		state.Upgrade.Applied.PreviousVersion,
+		state.Upgrade.Staged.Version,
+		state.Upgrade.Pending.Version,
+		state.Upgrade.Plan.Version,
+	}
+	removed, err := PruneBundles(m.cfg.DataDir, m.cfg.KeepBundles, protect...)
+	if len(removed) > 0 {
+		m.deps.Logger.Printf("upgrade manager: pruned old upgrade bundles for versions %v", removed)
+	}
+	if err != nil {
+		m.deps.Logger.Printf("upgrade manager: prune upgrade bundles: %v", err)
+	}
+}
+
+// pruneAtStart clears out bundles left by earlier runs. It is skipped while
+// another process holds the upgrade lock; the next install prunes instead.
+func (m *Manager) pruneAtStart(ctx context.Context) {
+	lock, err := AcquireLock(m.cfg.DataDir)
+	if err != nil {
+		return
+	}
+	defer lock.Release()
+	state, err := m.deps.LoadState(ctx, m.cfg.DataDir)
+	if err != nil {
+		return
+	}
+	m.pruneBundles(state)
+}
@@ -0,0 +1,47 @@
+package upgrade
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestPruneBundlesKeepsRecentAndProtectedVersions(t *testing.T) {
+	dataDir := t.TempDir()
+	root := filepath.Join(dataDir, "upgrades")
+	base := time.Date(2025, time.November, 1, 0, 0, 0, 0, time.UTC)
+	versions := []string{"1.0.0", "1.1.0", "1.2.0", "1.3.0", "1.4.0", "1.5.0"}
+	for i, version := range versions {
+		dir := filepath.Join(root, version)
+		if err := os.MkdirAll(filepath.Join(dir, "bundle"), 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, artifactFileName), []byte(version), 0o644); err != nil {
+			t.Fatalf("write artifact: %v", err)
+		}
+		modTime := base.Add(time.Duration(i) * time.Hour)
+		if err := os.Chtimes(dir, modTime, modTime); err != nil {
+			t.Fatalf("chtimes: %v", err)
+		}
+	}
+
+	removed, err := PruneBundles(dataDir, 2, "1.1.0")
+	if err != nil {
+		t.Fatalf("prune: %v", err)
+	}
+	slices.Sort(removed)
+	if want := []string{"1.0.0", "1.2.0", "1.3.0"}; !slices.Equal(removed, want) {
+		t.Fatalf("removed %v, want %v", removed, want)
+	}
+	for _, version := range []string{"1.1.0", "1.4.0", "1.5.0"} {
+		if _, err := os.Stat(filepath.Join(root, version, artifactFileName)); err != nil {
+			t.Fatalf("expected %s kept: %v", version, err)
+		}
+	}
+
+	if removed, err := PruneBundles(t.TempDir(), 2); err != nil || len(removed) != 0 {
+		t.Fatalf("expected nothing to prune without upgrades dir, got %v, %v", removed, err)
+	}
+}
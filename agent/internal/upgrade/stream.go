@@ -0,0 +1,113 @@
+package upgrade
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/throttle"
+)
+
+const defaultUpgradePlanStreamPath = defaultUpgradePlanPath + "/stream"
+
+// ErrPlanStreamUnsupported is returned when the controller does not expose
+// the plan stream.
+var ErrPlanStreamUnsupported = errors.New("plan stream not supported by server")
+
+// PlanStreamer is implemented by plan fetchers that can hold a push channel
+// to the controller open, such as *Client.
+type PlanStreamer interface {
+	StreamPlan(ctx context.Context, channel, etag string, handle func(PlanResult) error) error
+}
+
+type planStreamEvent struct {
+	ETag   string        `json:"etag"`
+	NoPlan bool          `json:"no_plan,omitempty"`
+	Plan   *planEnvelope `json:"plan"`
+}
+
+// StreamPlan opens the controller's plan stream for channel and invokes
+// handle for every plan pushed, and with an empty result when the controller
+// withdraws the agent's plan. The stream is newline-delimited JSON; blank
+// lines are keepalives. etag is the plan the agent already has, which the
+// controller does not resend. StreamPlan returns when the context is
+// cancelled, the server closes the stream, or handle returns an error.
+func (c *Client) StreamPlan(ctx context.Context, channel, etag string, handle func(PlanResult) error) error {
+	channel = strings.TrimSpace(channel)
+	if channel == "" {
+		channel = "stable"
+	}
+	reqURL, err := c.buildURL(defaultUpgradePlanStreamPath, url.Values{"channel": []string{channel}})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return fmt.Errorf("build plan stream request: %w", err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	req.Header.Set("User-Agent", userAgent)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if c.agentID != "" {
+		req.Header.Set("X-Agent-ID", c.agentID)
+	}
+
+	resp, err := c.streamClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("open plan stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := throttle.FromResponse(resp, time.Now()); err != nil {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("open plan stream: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		io.Copy(io.Discard, resp.Body)
+		return ErrPlanStreamUnsupported
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("plan stream failed: status %s", resp.Status)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			var event planStreamEvent
+			if uerr := json.Unmarshal(line, &event); uerr != nil {
+				return fmt.Errorf("decode plan stream event: %w", uerr)
+			}
+			switch {
+			case event.Plan != nil:
+				if herr := handle(PlanResult{Plan: event.Plan.plan(), ETag: event.ETag}); herr != nil {
+					return herr
+				}
+			case event.NoPlan:
+				if herr := handle(PlanResult{}); herr != nil {
+					return herr
+				}
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("plan stream closed by server")
+			}
+			return fmt.Errorf("read plan stream: %w", err)
+		}
+	}
+}
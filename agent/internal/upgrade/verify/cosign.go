@@ -0,0 +1,333 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Fulcio certificate extensions naming the OIDC issuer that vouched for the
+// signer: the deprecated raw-string form and its DER-encoded successor.
+var (
+	oidFulcioIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidFulcioIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// CosignKeylessOptions configure keyless (Fulcio/Rekor) verification.
+type CosignKeylessOptions struct {
+	// FulcioRootsPEM holds the Fulcio root and intermediate certificates.
+	FulcioRootsPEM []byte
+	// RekorPublicKeyPEM verifies the transparency log's signed entry
+	// timestamp, which proves the signature was made while the short-lived
+	// certificate was valid.
+	RekorPublicKeyPEM []byte
+	// Identity is the expected certificate subject: an email address or URI
+	// such as a CI workflow reference.
+	Identity string
+	// Issuer is the expected OIDC issuer, e.g.
+	// https://token.actions.githubusercontent.com.
+	Issuer string
+}
+
+// CosignVerifier verifies signatures made with `cosign sign-blob`. The
+// signature file is either the base64 signature (key-based signing) or the
+// JSON written by --bundle, which keyless signing requires.
+type CosignVerifier struct {
+	publicKey crypto.PublicKey
+
+	roots         *x509.CertPool
+	intermediates *x509.CertPool
+	rekorKey      crypto.PublicKey
+	identity      string
+	issuer        string
+}
+
+// NewCosignKeyVerifier returns a verifier for signatures made with the
+// private half of the PEM public key (cosign.pub).
+func NewCosignKeyVerifier(publicKeyPEM []byte) (*CosignVerifier, error) {
+	key, err := parsePublicKeyPEM(publicKeyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse cosign public key: %w", err)
+	}
+	return &CosignVerifier{publicKey: key}, nil
+}
+
+// NewCosignKeylessVerifier returns a verifier for keyless signatures: the
+// bundle's certificate must chain to the Fulcio roots, name the expected
+// identity and issuer, and be covered by a Rekor entry.
+func NewCosignKeylessVerifier(opts CosignKeylessOptions) (*CosignVerifier, error) {
+	if strings.TrimSpace(opts.Identity) == "" || strings.TrimSpace(opts.Issuer) == "" {
+		return nil, errors.New("cosign keyless verification requires a certificate identity and OIDC issuer")
+	}
+	v := &CosignVerifier{
+		roots:         x509.NewCertPool(),
+		intermediates: x509.NewCertPool(),
+		identity:      strings.TrimSpace(opts.Identity),
+		issuer:        strings.TrimSpace(opts.Issuer),
+	}
+	certs, err := parseCertificatesPEM(opts.FulcioRootsPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse fulcio roots: %w", err)
+	}
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			v.roots.AddCert(cert)
+		} else {
+			v.intermediates.AddCert(cert)
+		}
+	}
+	if v.rekorKey, err = parsePublicKeyPEM(opts.RekorPublicKeyPEM); err != nil {
+		return nil, fmt.Errorf("parse rekor public key: %w", err)
+	}
+	return v, nil
+}
+
+// cosignBundle is the document written by `cosign sign-blob --bundle`.
+type cosignBundle struct {
+	Base64Signature string `json:"base64Signature"`
+	// Cert is the base64-encoded PEM signing certificate (keyless only).
+	Cert        string       `json:"cert"`
+	RekorBundle *rekorBundle `json:"rekorBundle"`
+}
+
+type rekorBundle struct {
+	SignedEntryTimestamp []byte       `json:"SignedEntryTimestamp"`
+	Payload              rekorPayload `json:"Payload"`
+}
+
+type rekorPayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogIndex       int64  `json:"logIndex"`
+	LogID          string `json:"logID"`
+}
+
+// hashedRekord is the Rekor entry body for a signed blob digest.
+type hashedRekord struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+	} `json:"spec"`
+}
+
+// Verify checks the cosign signature at signaturePath over the artifact.
+func (v *CosignVerifier) Verify(ctx context.Context, artifactPath, signaturePath string) error {
+	if v == nil {
+		return errors.New("signature verifier not configured")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	raw, err := os.ReadFile(signaturePath)
+	if err != nil {
+		return fmt.Errorf("read signature %q: %w", signaturePath, err)
+	}
+	var bundle cosignBundle
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &bundle); err != nil {
+			return fmt.Errorf("decode cosign bundle %q: %w", signaturePath, err)
+		}
+	} else {
+		bundle.Base64Signature = string(trimmed)
+	}
+	signature, err := base64.StdEncoding.DecodeString(bundle.Base64Signature)
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("decode signature %q: invalid base64", signaturePath)
+	}
+	digest, err := fileSHA256(artifactPath)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	key := v.publicKey
+	if key == nil {
+		if key, err = v.verifyKeyless(bundle, digest); err != nil {
+			return err
+		}
+	}
+	return verifyDigest(key, digest, signature)
+}
+
+// verifyKeyless validates the bundle's certificate and Rekor entry and
+// returns the certificate key the signature must verify under.
+func (v *CosignVerifier) verifyKeyless(bundle cosignBundle, digest []byte) (crypto.PublicKey, error) {
+	if bundle.Cert == "" || bundle.RekorBundle == nil {
+		return nil, errors.New("keyless signature requires a cosign bundle with certificate and rekor entry")
+	}
+	certPEM, err := base64.StdEncoding.DecodeString(bundle.Cert)
+	if err != nil {
+		return nil, fmt.Errorf("decode signing certificate: %w", err)
+	}
+	certs, err := parseCertificatesPEM(certPEM)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing certificate: %w", err)
+	}
+	cert := certs[0]
+
+	// The certificate lives for minutes; what matters is that the log
+	// recorded the signature while it was valid.
+	rekor := bundle.RekorBundle
+	if err := v.verifySET(rekor); err != nil {
+		return nil, err
+	}
+	signedAt := time.Unix(rekor.Payload.IntegratedTime, 0)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         v.roots,
+		Intermediates: v.intermediates,
+		CurrentTime:   signedAt,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}); err != nil {
+		return nil, fmt.Errorf("verify signing certificate: %w", err)
+	}
+	if !slices.Contains(certificateIdentities(cert), v.identity) {
+		return nil, fmt.Errorf("signing certificate identity %v does not match %q", certificateIdentities(cert), v.identity)
+	}
+	if issuer := certificateIssuer(cert); issuer != v.issuer {
+		return nil, fmt.Errorf("signing certificate issuer %q does not match %q", issuer, v.issuer)
+	}
+
+	body, err := base64.StdEncoding.DecodeString(rekor.Payload.Body)
+	if err != nil {
+		return nil, fmt.Errorf("decode rekor entry: %w", err)
+	}
+	var entry hashedRekord
+	if err := json.Unmarshal(body, &entry); err != nil || entry.Kind != "hashedrekord" {
+		return nil, errors.New("rekor entry is not a hashedrekord")
+	}
+	if entry.Spec.Data.Hash.Algorithm != "sha256" || !strings.EqualFold(entry.Spec.Data.Hash.Value, hex.EncodeToString(digest)) {
+		return nil, errors.New("rekor entry does not cover this artifact")
+	}
+	if entry.Spec.Signature.Content != bundle.Base64Signature {
+		return nil, errors.New("rekor entry does not cover this signature")
+	}
+	return cert.PublicKey, nil
+}
+
+// verifySET checks Rekor's signature over the canonical JSON of the entry
+// payload (keys sorted, no whitespace, as encoding/json writes a map).
+func (v *CosignVerifier) verifySET(rekor *rekorBundle) error {
+	canonical, err := json.Marshal(map[string]any{
+		"body":           rekor.Payload.Body,
+		"integratedTime": rekor.Payload.IntegratedTime,
+		"logIndex":       rekor.Payload.LogIndex,
+		"logID":          rekor.Payload.LogID,
+	})
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(canonical)
+	if err := verifyDigest(v.rekorKey, sum[:], rekor.SignedEntryTimestamp); err != nil {
+		return fmt.Errorf("verify rekor signed entry timestamp: %w", err)
+	}
+	return nil
+}
+
+func verifyDigest(key crypto.PublicKey, digest, signature []byte) error {
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(k, digest, signature) {
+			return errors.New("signature verification failed")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, digest, signature); err != nil {
+			return errors.New("signature verification failed")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type %T", key)
+	}
+	return nil
+}
+
+func certificateIdentities(cert *x509.Certificate) []string {
+	identities := slices.Clone(cert.EmailAddresses)
+	for _, uri := range cert.URIs {
+		identities = append(identities, uri.String())
+	}
+	return identities
+}
+
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		switch {
+		case ext.Id.Equal(oidFulcioIssuerV2):
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		case ext.Id.Equal(oidFulcioIssuerV1):
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+func parsePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+func parseCertificatesPEM(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+	return certs, nil
+}
+
+func fileSHA256(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("read artifact %q: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, fmt.Errorf("read artifact %q: %w", path, err)
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,165 @@
+package verify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCosignKeyVerifier(t *testing.T) {
+	ctx := context.Background()
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	verifier, err := NewCosignKeyVerifier(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("NewCosignKeyVerifier: %v", err)
+	}
+
+	dir := t.TempDir()
+	artifact := writeTestFile(t, dir, "artifact.tar.gz", []byte("artifact contents"))
+	sum := sha256.Sum256([]byte("artifact contents"))
+	sig, _ := ecdsa.SignASN1(rand.Reader, key, sum[:])
+	encoded := base64.StdEncoding.EncodeToString(sig)
+
+	raw := writeTestFile(t, dir, "artifact.sig", []byte(encoded+"\n"))
+	if err := verifier.Verify(ctx, artifact, raw); err != nil {
+		t.Fatalf("Verify raw signature: %v", err)
+	}
+	bundle, _ := json.Marshal(map[string]string{"base64Signature": encoded})
+	if err := verifier.Verify(ctx, artifact, writeTestFile(t, dir, "artifact.bundle", bundle)); err != nil {
+		t.Fatalf("Verify bundle: %v", err)
+	}
+	tampered := writeTestFile(t, dir, "tampered.tar.gz", []byte("tampered contents"))
+	if err := verifier.Verify(ctx, tampered, raw); err == nil {
+		t.Fatalf("expected tampered artifact to be rejected")
+	}
+}
+
+func TestCosignKeylessVerifier(t *testing.T) {
+	ctx := context.Background()
+	const identity = "https://github.com/pingsantohq/agent/.github/workflows/release.yml@refs/heads/main"
+	const issuer = "https://token.actions.githubusercontent.com"
+	signedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+
+	rootKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test fulcio"},
+		NotBefore:             signedAt.Add(-time.Hour),
+		NotAfter:              signedAt.Add(365 * 24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, _ := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, &rootKey.PublicKey, rootKey)
+	root, _ := x509.ParseCertificate(rootDER)
+
+	// Short-lived leaf that expired long before verification.
+	issuerExt, _ := asn1.Marshal(issuer)
+	identityURI, _ := url.Parse(identity)
+	signerKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	leafDER, _ := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       signedAt.Add(-time.Minute),
+		NotAfter:        signedAt.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{identityURI},
+		ExtraExtensions: []pkix.Extension{{Id: oidFulcioIssuerV2, Value: issuerExt}},
+	}, root, &signerKey.PublicKey, rootKey)
+	leafPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER})
+
+	rekorKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rekorDER, _ := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+
+	newVerifier := func(identity string) *CosignVerifier {
+		v, err := NewCosignKeylessVerifier(CosignKeylessOptions{
+			FulcioRootsPEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}),
+			RekorPublicKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rekorDER}),
+			Identity:          identity,
+			Issuer:            issuer,
+		})
+		if err != nil {
+			t.Fatalf("NewCosignKeylessVerifier: %v", err)
+		}
+		return v
+	}
+
+	dir := t.TempDir()
+	content := []byte("artifact contents")
+	artifact := writeTestFile(t, dir, "artifact.tar.gz", content)
+	sum := sha256.Sum256(content)
+	sig, _ := ecdsa.SignASN1(rand.Reader, signerKey, sum[:])
+	encodedSig := base64.StdEncoding.EncodeToString(sig)
+
+	entry := map[string]any{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]any{
+			"data":      map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(sum[:])}},
+			"signature": map[string]any{"content": encodedSig, "publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString(leafPEM)}},
+		},
+	}
+	body, _ := json.Marshal(entry)
+	payload := rekorPayload{
+		Body:           base64.StdEncoding.EncodeToString(body),
+		IntegratedTime: signedAt.Unix(),
+		LogIndex:       42,
+		LogID:          "c0d23d6ad406973f9559f3ba2d1ca01f84147d8ffc5b8445c224f98b9591801d",
+	}
+	canonical, _ := json.Marshal(map[string]any{"body": payload.Body, "integratedTime": payload.IntegratedTime, "logIndex": payload.LogIndex, "logID": payload.LogID})
+	canonicalSum := sha256.Sum256(canonical)
+	set, _ := ecdsa.SignASN1(rand.Reader, rekorKey, canonicalSum[:])
+
+	bundle, _ := json.Marshal(cosignBundle{
+		Base64Signature: encodedSig,
+		Cert:            base64.StdEncoding.EncodeToString(leafPEM),
+		RekorBundle:     &rekorBundle{SignedEntryTimestamp: set, Payload: payload},
+	})
+	bundlePath := writeTestFile(t, dir, "artifact.bundle", bundle)
+
+	if err := newVerifier(identity).Verify(ctx, artifact, bundlePath); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if err := newVerifier("https://github.com/someone/else").Verify(ctx, artifact, bundlePath); err == nil {
+		t.Fatalf("expected identity mismatch to be rejected")
+	}
+
+	// Moving the log time outside the certificate's validity breaks the SET.
+	payload.IntegratedTime = time.Now().Unix()
+	forged, _ := json.Marshal(cosignBundle{
+		Base64Signature: encodedSig,
+		Cert:            base64.StdEncoding.EncodeToString(leafPEM),
+		RekorBundle:     &rekorBundle{SignedEntryTimestamp: set, Payload: payload},
+	})
+	if err := newVerifier(identity).Verify(ctx, artifact, writeTestFile(t, dir, "forged.bundle", forged)); err == nil {
+		t.Fatalf("expected forged rekor entry to be rejected")
+	}
+	if err := newVerifier(identity).Verify(ctx, artifact, writeTestFile(t, dir, "artifact.sig", []byte(encodedSig))); err == nil {
+		t.Fatalf("expected bare signature to be rejected in keyless mode")
+	}
+}
+
+func writeTestFile(t *testing.T, dir, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+	return path
+}
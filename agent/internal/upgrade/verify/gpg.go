@@ -0,0 +1,90 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
+)
+
+// armorPrefix starts every ASCII-armored OpenPGP block.
+const armorPrefix = "-----BEGIN PGP"
+
+// GPGVerifier verifies detached OpenPGP signatures, as made by
+// `gpg --detach-sign` with or without --armor, against a trusted keyring.
+// RSA, ECDSA and Ed25519 (EdDSA) keys are accepted.
+type GPGVerifier struct {
+	keyring openpgp.EntityList
+}
+
+// NewGPGVerifier parses the public keyring (armored or binary, as written by
+// `gpg --export`) whose keys may sign artifacts.
+func NewGPGVerifier(keyring []byte) (*GPGVerifier, error) {
+	if len(bytes.TrimSpace(keyring)) == 0 {
+		return nil, errors.New("gpg public keyring is required")
+	}
+	var (
+		entities openpgp.EntityList
+		err      error
+	)
+	if isArmored(keyring) {
+		entities, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(keyring))
+	} else {
+		entities, err = openpgp.ReadKeyRing(bytes.NewReader(keyring))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse gpg public keyring: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, errors.New("gpg public keyring contains no keys")
+	}
+	return &GPGVerifier{keyring: entities}, nil
+}
+
+// Verify checks the detached signature at signaturePath over the artifact.
+func (v *GPGVerifier) Verify(ctx context.Context, artifactPath, signaturePath string) error {
+	if v == nil {
+		return errors.New("signature verifier not configured")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if strings.TrimSpace(artifactPath) == "" {
+		return errors.New("artifact path is required")
+	}
+	if strings.TrimSpace(signaturePath) == "" {
+		return errors.New("signature path is required")
+	}
+
+	signature, err := os.ReadFile(signaturePath)
+	if err != nil {
+		return fmt.Errorf("read signature %q: %w", signaturePath, err)
+	}
+	artifact, err := os.Open(artifactPath)
+	if err != nil {
+		return fmt.Errorf("read artifact %q: %w", artifactPath, err)
+	}
+	defer artifact.Close()
+
+	if isArmored(signature) {
+		_, err = openpgp.CheckArmoredDetachedSignature(v.keyring, artifact, bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(v.keyring, artifact, bytes.NewReader(signature), nil)
+	}
+	if errors.Is(err, pgperrors.ErrUnknownIssuer) {
+		return errors.New("signature made by a key not in the trusted keyring")
+	}
+	if err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	return nil
+}
+
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(armorPrefix))
+}
@@ -0,0 +1,95 @@
+package verify
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGPGVerifier(t *testing.T) {
+	keyring, err := os.ReadFile(filepath.Clean("testdata/test.gpg.asc"))
+	if err != nil {
+		t.Fatalf("read keyring: %v", err)
+	}
+	verifier, err := NewGPGVerifier(keyring)
+	if err != nil {
+		t.Fatalf("NewGPGVerifier: %v", err)
+	}
+	ctx := context.Background()
+	artifact := filepath.Clean("testdata/artifact.bin")
+	for _, sig := range []string{"testdata/artifact.bin.asc", "testdata/artifact.bin.sig"} {
+		if err := verifier.Verify(ctx, artifact, filepath.Clean(sig)); err != nil {
+			t.Fatalf("Verify %s: %v", sig, err)
+		}
+	}
+
+	tampered := filepath.Join(t.TempDir(), "artifact.bin")
+	if err := os.WriteFile(tampered, []byte("tampered contents"), 0o644); err != nil {
+		t.Fatalf("write tampered artifact: %v", err)
+	}
+	if err := verifier.Verify(ctx, tampered, filepath.Clean("testdata/artifact.bin.asc")); err == nil {
+		t.Fatalf("expected tampered artifact to be rejected")
+	}
+}
+
+func TestGPGVerifierRejectsUntrustedSigner(t *testing.T) {
+	if _, err := NewGPGVerifier(nil); err == nil {
+		t.Fatalf("expected empty keyring to be rejected")
+	}
+	keyring, err := os.ReadFile(filepath.Clean("testdata/test.gpg.asc"))
+	if err != nil {
+		t.Fatalf("read keyring: %v", err)
+	}
+	verifier, err := NewGPGVerifier(keyring)
+	if err != nil {
+		t.Fatalf("NewGPGVerifier: %v", err)
+	}
+	ctx := context.Background()
+	artifact := filepath.Clean("testdata/artifact.bin")
+	err = verifier.Verify(ctx, artifact, filepath.Clean("testdata/artifact.bin.untrusted.asc"))
+	if err == nil || !strings.Contains(err.Error(), "not in the trusted keyring") {
+		t.Fatalf("expected untrusted signer to be rejected, got %v", err)
+	}
+	if err := verifier.Verify(ctx, artifact, filepath.Clean("testdata/artifact.bin.minisig")); err == nil {
+		t.Fatalf("expected minisign signature to be rejected")
+	}
+}
+
+func TestGPGVerifierEd25519(t *testing.T) {
+	keyring, err := os.ReadFile(filepath.Clean("testdata/test-ed25519.gpg.asc"))
+	if err != nil {
+		t.Fatalf("read keyring: %v", err)
+	}
+	verifier, err := NewGPGVerifier(keyring)
+	if err != nil {
+		t.Fatalf("NewGPGVerifier: %v", err)
+	}
+	ctx := context.Background()
+	artifact := filepath.Clean("testdata/artifact.bin")
+	if err := verifier.Verify(ctx, artifact, filepath.Clean("testdata/artifact.bin.ed25519.asc")); err != nil {
+		t.Fatalf("Verify ed25519 signature: %v", err)
+	}
+
+	tampered := filepath.Join(t.TempDir(), "artifact.bin")
+	if err := os.WriteFile(tampered, []byte("tampered contents"), 0o644); err != nil {
+		t.Fatalf("write tampered artifact: %v", err)
+	}
+	if err := verifier.Verify(ctx, tampered, filepath.Clean("testdata/artifact.bin.ed25519.asc")); err == nil {
+		t.Fatalf("expected tampered artifact to be rejected")
+	}
+
+	rsaKeyring, err := os.ReadFile(filepath.Clean("testdata/test.gpg.asc"))
+	if err != nil {
+		t.Fatalf("read keyring: %v", err)
+	}
+	rsaOnly, err := NewGPGVerifier(rsaKeyring)
+	if err != nil {
+		t.Fatalf("NewGPGVerifier: %v", err)
+	}
+	err = rsaOnly.Verify(ctx, artifact, filepath.Clean("testdata/artifact.bin.ed25519.asc"))
+	if err == nil || !strings.Contains(err.Error(), "not in the trusted keyring") {
+		t.Fatalf("expected ed25519 signer outside the keyring to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package upgrade
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+const defaultVerifyWindow = 5 * time.Minute
+
+// verifyInterval is how often a pending upgrade's health is re-checked.
+var verifyInterval = 5 * time.Second
+
+// HealthVerifier reports whether an agent restarted into a new version is
+// doing its job. health.Checker satisfies this interface.
+type HealthVerifier interface {
+	Operational(now time.Time) (bool, []string)
+}
+
+// verifyPending finishes an upgrade begun by the previous process. The new
+// binary has until RestartedAt+VerifyWindow to become operational; if it does
+// not, the backup binary is restored, the failure is reported and the agent
+// restarts into the previous version. It returns early only when ctx ends.
+func (m *Manager) verifyPending(ctx context.Context) error {
+	if m.deps.LoadState == nil {
+		return nil
+	}
+	state, err := m.deps.LoadState(ctx, m.cfg.DataDir)
+	if err != nil {
+		m.deps.Logger.Printf("upgrade manager: failed to load state: %v", err)
+		return nil
+	}
+	pending := state.Upgrade.Pending
+	if pending.Version == "" || pending.Verified {
+		return nil
+	}
+	if m.deps.Health == nil {
+		m.deps.Logger.Printf("upgrade manager: no health verifier; accepting version=%s unverified", pending.Version)
+		m.markVerified(ctx, state)
+		return nil
+	}
+
+	deadline := pending.RestartedAt.Add(m.cfg.VerifyWindow)
+	m.deps.Logger.Printf("upgrade manager: verifying version=%s until %s", pending.Version, deadline.UTC().Format(time.RFC3339))
+	ticker := time.NewTicker(verifyInterval)
+	defer ticker.Stop()
+	for {
+		now := m.deps.Now()
+		ok, reasons := m.deps.Health.Operational(now)
+		if ok {
+			m.deps.Logger.Printf("upgrade manager: version=%s verified healthy", pending.Version)
+			m.markVerified(ctx, state)
+			return nil
+		}
+		if !now.Before(deadline) {
+			m.rollbackPending(ctx, state, reasons)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// markVerified keeps the pending record, now verified, so the crash-loop
+// guard goes on counting restarts until its window has passed.
+func (m *Manager) markVerified(ctx context.Context, state config.State) {
+	state.Upgrade.Pending.Verified = true
+	m.saveState(ctx, state, "record verified upgrade")
+}
+
+func (m *Manager) clearPending(ctx context.Context, state config.State) {
+	state.Upgrade.Pending = config.UpgradePendingState{}
+	m.saveState(ctx, state, "clear pending upgrade")
+}
+
+func (m *Manager) saveState(ctx context.Context, state config.State, what string) {
+	if m.deps.UpdateState == nil {
+		return
+	}
+	if err := m.deps.UpdateState(ctx, m.cfg.DataDir, state); err != nil {
+		m.deps.Logger.Printf("upgrade manager: failed to %s: %v", what, err)
+	}
+}
+
+// reportRollback reports a rollback made outside the manager, by the
+// crash-loop guard or `upgrades --rollback`, then forgets it. When restart is
+// set and the rollback asked for it, the agent then execs the restored
+// binary; at startup the process already runs it.
+func (m *Manager) reportRollback(ctx context.Context, restart bool) {
+	if m.deps.LoadState == nil {
+		return
+	}
+	state, err := m.deps.LoadState(ctx, m.cfg.DataDir)
+	if err != nil {
+		m.deps.Logger.Printf("upgrade manager: failed to load state: %v", err)
+		return
+	}
+	rollback := state.Upgrade.Rollback
+	if rollback.Version == "" {
+		return
+	}
+	stage := rollback.Stage
+	if stage == "" {
+		stage = "crash_loop"
+	}
+	plan := Plan{Channel: rollback.Channel, Artifact: PlanArtifact{Version: rollback.Version}}
+	details := map[string]any{
+		"stage":          stage,
+		"rolled_back":    true,
+		"rolled_back_to": rollback.PreviousVersion,
+		"rolled_back_at": rollback.At.UTC().Format(time.RFC3339),
+		"bad_version":    true,
+	}
+	if rollback.Starts > 0 {
+		details["starts"] = rollback.Starts
+	}
+	m.report(ctx, plan, state.AgentID, rollback.PreviousVersion, "failed", rollback.Reason, details)
+	state.Upgrade.Rollback = config.UpgradeRollbackState{}
+	m.saveState(ctx, state, "clear reported rollback")
+
+	target := state.Upgrade.Applied.Path
+	if restart && rollback.Restart && m.restarter != nil && target != "" {
+		m.deps.Logger.Printf("upgrade manager: restarting into rolled back version=%s", rollback.PreviousVersion)
+		if err := m.restarter.Restart(ctx, target, m.args, m.env); err != nil {
+			m.deps.Logger.Printf("upgrade manager: restart into %s failed: %v", rollback.PreviousVersion, err)
+		}
+	}
+}
+
+// rollbackPending restores the binary the pending upgrade replaced, marks
+// the version bad so the same plan is not applied again, records and
+// reports why, and execs the restored binary.
+func (m *Manager) rollbackPending(ctx context.Context, state config.State, reasons []string) {
+	pending := state.Upgrade.Pending
+	message := fmt.Sprintf("post-upgrade verification failed after %s: %s", m.cfg.VerifyWindow, strings.Join(reasons, "; "))
+	m.deps.Logger.Printf("upgrade manager: version=%s %s; rolling back to %s", pending.Version, message, pending.PreviousVersion)
+
+	details := map[string]any{
+		"stage":          "verify",
+		"reasons":        append([]string(nil), reasons...),
+		"rolled_back_to": pending.PreviousVersion,
+		"bad_version":    true,
+	}
+	restored := false
+	if m.installer != nil {
+		install := InstallResult{TargetPath: pending.TargetPath, BackupPath: pending.BackupPath}
+		if err := m.installer.Rollback(ctx, install); err != nil {
+			m.deps.Logger.Printf("upgrade manager: rollback failed: %v", err)
+			details["rollback_error"] = err.Error()
+		} else {
+			restored = true
+		}
+	}
+	details["rolled_back"] = restored
+
+	if restored {
+		state.Upgrade.Applied.Version = pending.PreviousVersion
+		state.Upgrade.Applied.PreviousVersion = ""
+	}
+	state.Upgrade.Applied.LastError = message
+	if !slices.Contains(state.Upgrade.BadVersions, pending.Version) {
+		state.Upgrade.BadVersions = append(state.Upgrade.BadVersions, pending.Version)
+	}
+	plan := Plan{Channel: pending.Channel, Artifact: PlanArtifact{Version: pending.Version}}
+	m.clearPending(ctx, state)
+	m.report(ctx, plan, state.AgentID, pending.PreviousVersion, "failed", message, details)
+
+	if restored && m.restarter != nil && pending.TargetPath != "" {
+		if err := m.restarter.Restart(ctx, pending.TargetPath, m.args, m.env); err != nil {
+			m.deps.Logger.Printf("upgrade manager: restart into %s failed: %v", pending.PreviousVersion, err)
+		}
+	}
+}
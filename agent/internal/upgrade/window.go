@@ -0,0 +1,130 @@
+package upgrade
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// MaintenanceWindow is a weekly period, in the agent's local time, during
+// which upgrades may be applied. It is written "<days> HH:MM-HH:MM", where
+// days is "daily", a day name (Sat), a range (Mon-Fri) or a comma list of
+// those. A window whose end is not after its start runs past midnight and
+// belongs to the day it starts on: "Fri 22:00-02:00" ends Saturday 02:00.
+type MaintenanceWindow struct {
+	spec       string
+	days       [7]bool
+	start, end int // minutes after midnight
+}
+
+// ParseMaintenanceWindow parses a window such as "Sat 02:00-04:00".
+func ParseMaintenanceWindow(spec string) (MaintenanceWindow, error) {
+	w := MaintenanceWindow{spec: strings.TrimSpace(spec)}
+	dayPart, timePart, ok := strings.Cut(w.spec, " ")
+	if !ok {
+		return w, fmt.Errorf("maintenance window %q: want \"<days> HH:MM-HH:MM\"", spec)
+	}
+	if err := w.parseDays(dayPart); err != nil {
+		return w, fmt.Errorf("maintenance window %q: %w", spec, err)
+	}
+	from, to, ok := strings.Cut(strings.ReplaceAll(strings.TrimSpace(timePart), "–", "-"), "-")
+	if !ok {
+		return w, fmt.Errorf("maintenance window %q: want a time range HH:MM-HH:MM", spec)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return w, fmt.Errorf("maintenance window %q: %w", spec, err)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, fmt.Errorf("maintenance window %q: %w", spec, err)
+	}
+	if w.start == w.end {
+		return w, fmt.Errorf("maintenance window %q: start and end are equal", spec)
+	}
+	return w, nil
+}
+
+// ParseMaintenanceWindows parses every spec, stopping at the first error.
+func ParseMaintenanceWindows(specs []string) ([]MaintenanceWindow, error) {
+	windows := make([]MaintenanceWindow, 0, len(specs))
+	for _, spec := range specs {
+		w, err := ParseMaintenanceWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func (w *MaintenanceWindow) parseDays(raw string) error {
+	if strings.EqualFold(raw, "daily") {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+	for _, part := range strings.Split(raw, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok := weekdays[strings.ToLower(first)]
+		if !ok {
+			return fmt.Errorf("unknown day %q", first)
+		}
+		to := from
+		if isRange {
+			if to, ok = weekdays[strings.ToLower(last)]; !ok {
+				return fmt.Errorf("unknown day %q", last)
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseClock(raw string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(raw))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", raw)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains reports whether t, read in its own location, falls inside the
+// window.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	previous := (day + 6) % 7
+	return (w.days[day] && minute >= w.start) || (w.days[previous] && minute < w.end)
+}
+
+func (w MaintenanceWindow) String() string {
+	return w.spec
+}
+
+// InAnyWindow reports whether t is inside one of windows; no windows means
+// no restriction.
+func InAnyWindow(windows []MaintenanceWindow, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package upgrade
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2025-11-01 is a Saturday.
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2025, time.November, day, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+	cases := []struct {
+		spec string
+		at   time.Time
+		want bool
+	}{
+		{"Sat 02:00-04:00", at(1, "02:00"), true},
+		{"Sat 02:00–04:00", at(1, "03:59"), true},
+		{"Sat 02:00-04:00", at(1, "04:00"), false},
+		{"Sat 02:00-04:00", at(2, "03:00"), false},
+		{"Mon-Fri 01:00-02:00", at(3, "01:30"), true},
+		{"Mon-Fri 01:00-02:00", at(2, "01:30"), false},
+		{"Sat-Sun 12:00-13:00", at(2, "12:30"), true},
+		{"mon,wed 01:00-02:00", at(5, "01:00"), true},
+		{"daily 23:00-01:00", at(4, "00:30"), true},
+		{"Fri 22:00-02:00", at(1, "01:00"), true},
+		{"Fri 22:00-02:00", at(1, "22:30"), false},
+		{"Fri 22:00-02:00", at(7, "23:00"), true},
+	}
+	for _, tc := range cases {
+		w, err := ParseMaintenanceWindow(tc.spec)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.spec, err)
+		}
+		if got := w.Contains(tc.at); got != tc.want {
+			t.Fatalf("%q contains %s = %v, want %v", tc.spec, tc.at.Format(time.RFC1123), got, tc.want)
+		}
+	}
+}
+
+func TestParseMaintenanceWindowRejectsInvalid(t *testing.T) {
+	for _, spec := range []string{"", "Sat", "Caturday 02:00-04:00", "Sat 02:00", "Sat 25:00-26:00", "Sat 02:00-02:00", "Mon-Xyz 01:00-02:00"} {
+		if _, err := ParseMaintenanceWindow(spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package upgrade
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdPatch decodes a patch written by `zstd --patch-from=old` and streams
+// the reconstructed file to out. The decoder needs old in memory as its
+// dictionary; output beyond maxSize bytes is rejected.
+func zstdPatch(out io.Writer, old []byte, patch io.Reader, maxSize int64) error {
+	// --patch-from sizes the window to cover the base plus the output.
+	window := max(2*(uint64(len(old))+uint64(maxSize)), zstd.MinWindowSize)
+	dec, err := zstd.NewReader(patch,
+		zstd.WithDecoderConcurrency(1),
+		zstd.WithDecoderDictRaw(0, old),
+		zstd.WithDecoderMaxWindow(min(window, zstd.MaxWindowSize)),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCorruptPatch, err)
+	}
+	defer dec.Close()
+
+	n, err := io.Copy(out, io.LimitReader(dec, maxSize+1))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errCorruptPatch, err)
+	}
+	if n > maxSize {
+		return fmt.Errorf("%w: output exceeds limit of %d bytes", errCorruptPatch, maxSize)
+	}
+	return nil
+}
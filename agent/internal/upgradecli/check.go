@@ -0,0 +1,151 @@
+package upgradecli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"slices"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/certs"
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/netproxy"
+	"github.com/pingsantohq/agent/internal/reqstamp"
+	"github.com/pingsantohq/agent/internal/upgrade"
+)
+
+// newPlanFetcher builds a controller client from the agent's enrolled
+// certificate, the same way `setup` checks the upgrade endpoint.
+func newPlanFetcher(cfg config.Config, state config.State) (upgrade.PlanFetcher, error) {
+	server := cfg.Agent.Server
+	if server == "" {
+		server = state.Server
+	}
+	if server == "" {
+		return nil, errors.New("server URL missing from config and state")
+	}
+	proxy, err := netproxy.FromConfig(cfg.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("configure proxy: %w", err)
+	}
+	keyPath := state.KeyPath
+	if cfg.Agent.ClientKey != "" {
+		keyPath = cfg.Agent.ClientKey
+	}
+	tlsConfig, err := certs.LoadClientTLSConfig(state.CertPath, keyPath, state.CABundles(), server)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS config: %w", err)
+	}
+	if err := certs.PinControllerSPKI(tlsConfig, cfg.Agent.ControllerPins); err != nil {
+		return nil, fmt.Errorf("agent.controller_pins: %w", err)
+	}
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: reqstamp.Wrap(&http.Transport{
+			TLSClientConfig: tlsConfig,
+			Proxy:           proxy,
+		}),
+	}
+	return upgrade.NewClient(httpClient, server, state.AgentID, nil)
+}
+
+// checkReport is the outcome of --check.
+type checkReport struct {
+	Channel          string   `json:"channel"`
+	PlanFound        bool     `json:"plan_found"`
+	PlanVersion      string   `json:"plan_version,omitempty"`
+	InstalledVersion string   `json:"installed_version,omitempty"`
+	Pending          bool     `json:"pending"`
+	Waiting          []string `json:"waiting,omitempty"`
+}
+
+// checkPlan fetches the current plan, ignoring any cached ETag, and reports
+// whether it would upgrade this agent and what it is waiting for. State is
+// left untouched; the running agent picks the plan up on its next poll.
+func checkPlan(ctx context.Context, fetcher upgrade.PlanFetcher, cfg config.Config, state config.State, now time.Time) (checkReport, error) {
+	report := checkReport{Channel: state.Upgrade.Channel, InstalledVersion: state.Upgrade.Applied.Version}
+	if report.Channel == "" {
+		report.Channel = "stable"
+	}
+	result, err := fetcher.FetchPlan(ctx, report.Channel, "")
+	if errors.Is(err, upgrade.ErrPlanNotFound) {
+		return report, nil
+	}
+	if err != nil {
+		return checkReport{}, fmt.Errorf("fetch plan: %w", err)
+	}
+	plan := result.Plan
+	report.PlanFound = true
+	report.PlanVersion = plan.Artifact.Version
+	if plan.Channel != "" {
+		report.Channel = plan.Channel
+	}
+	report.Pending, report.Waiting = pendingUpgrade(plan, cfg, state, now)
+	return report, nil
+}
+
+func writeCheckReport(out io.Writer, report checkReport) {
+	if !report.PlanFound {
+		fmt.Fprintf(out, "No upgrade plan for channel %s\n", report.Channel)
+		fmt.Fprintln(out, "Upgrade pending: false")
+		return
+	}
+	fmt.Fprintf(out, "Controller plan: %s (channel=%s)\n", printableVersion(report.PlanVersion), report.Channel)
+	fmt.Fprintf(out, "Installed version: %s\n", printableVersion(report.InstalledVersion))
+	fmt.Fprintf(out, "Upgrade pending: %t\n", report.Pending)
+	for _, reason := range report.Waiting {
+		fmt.Fprintf(out, "  Waiting: %s\n", reason)
+	}
+}
+
+// pendingUpgrade reports whether plan would replace the installed version,
+// and the conditions that currently hold it back.
+func pendingUpgrade(plan upgrade.Plan, cfg config.Config, state config.State, now time.Time) (bool, []string) {
+	version := plan.Artifact.Version
+	force := plan.Artifact.ForceApply
+	if version == "" || (version == state.Upgrade.Applied.Version && !force) {
+		return false, nil
+	}
+	if slices.Contains(state.Upgrade.BadVersions, version) && !force {
+		return false, []string{"version was rolled back after a crash loop"}
+	}
+	for _, channel := range []string{state.Upgrade.Channel, plan.Channel} {
+		if channel != "" && !upgrade.ChannelAllowed(cfg.Upgrade.AllowedChannels, channel) {
+			return false, []string{"channel " + channel + " not in upgrade.allowed_channels"}
+		}
+	}
+	if _, ok := plan.Artifact.ForPlatform(runtime.GOOS, runtime.GOARCH); !ok {
+		return false, []string{"no artifact for " + runtime.GOOS + "/" + runtime.GOARCH}
+	}
+
+	var waiting []string
+	if state.Upgrade.Paused && !force {
+		waiting = append(waiting, "auto-upgrades paused locally")
+	}
+	if plan.Paused && !force {
+		waiting = append(waiting, "plan paused by controller")
+	}
+	if plan.Schedule.Earliest != nil && now.Before(*plan.Schedule.Earliest) {
+		waiting = append(waiting, "rollout window opens "+formatTime(*plan.Schedule.Earliest))
+	}
+	local := now.In(time.Local)
+	for _, specs := range [][]string{plan.Schedule.MaintenanceWindows, cfg.Upgrade.MaintenanceWindows} {
+		windows, err := upgrade.ParseMaintenanceWindows(specs)
+		if err != nil {
+			waiting = append(waiting, err.Error())
+		} else if !upgrade.InAnyWindow(windows, local) {
+			waiting = append(waiting, fmt.Sprintf("outside maintenance windows %v", specs))
+		}
+	}
+	return true, waiting
+}
+
+func printableVersion(v string) string {
+	if v == "" {
+		return "(unknown)"
+	}
+	return v
+}
@@ -11,11 +11,15 @@ import (
 	"time"
 
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/upgrade"
 )
 
 type Dependencies struct {
 	Now func() time.Time
 	Out io.Writer
+	// PlanFetcher serves --check; by default a controller client is built
+	// from the agent's certificate.
+	PlanFetcher upgrade.PlanFetcher
 }
 
 func Run(ctx context.Context, args []string, deps Dependencies) error {
@@ -33,6 +37,10 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 	pause := fs.Bool("pause", false, "Pause automatic upgrades")
 	resume := fs.Bool("resume", false, "Resume automatic upgrades")
 	status := fs.Bool("status", false, "Show current upgrade state")
+	check := fs.Bool("check", false, "Fetch the plan from the controller now and report whether an upgrade is pending")
+	applyNow := fs.Bool("apply-now", false, "Have the running agent install the current plan immediately, ignoring schedule windows")
+	rollback := fs.Bool("rollback", false, "Restore the binary replaced by the last upgrade and restart the agent into it")
+	output := fs.String("output", "text", "Output format (text|json)")
 
 	if err := fs.Parse(args); err != nil {
 		return err
@@ -41,6 +49,16 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 	if *pause && *resume {
 		return errors.New("cannot specify both --pause and --resume")
 	}
+	if *rollback && *applyNow {
+		return errors.New("cannot specify both --rollback and --apply-now")
+	}
+
+	switch *output {
+	case "text", "json":
+	default:
+		return fmt.Errorf("invalid output %q (allowed: text, json)", *output)
+	}
+	jsonOutput := *output == "json"
 
 	if *channel != "" {
 		normalized := strings.ToLower(*channel)
@@ -57,6 +75,10 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 		return fmt.Errorf("load config: %w", err)
 	}
 
+	if *channel != "" && !upgrade.ChannelAllowed(cfg.Upgrade.AllowedChannels, *channel) {
+		return fmt.Errorf("channel %s is not in upgrade.allowed_channels (%s)", *channel, strings.Join(cfg.Upgrade.AllowedChannels, ", "))
+	}
+
 	dataDir := strings.TrimSpace(*dataDirFlag)
 	if dataDir == "" {
 		dataDir = strings.TrimSpace(cfg.Agent.DataDir)
@@ -69,6 +91,18 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 	if err != nil {
 		return fmt.Errorf("load state: %w", err)
 	}
+	if *rollback {
+		if !jsonOutput {
+			return rollbackUpgrade(ctx, deps.Out, dataDir, deps.Now())
+		}
+		if err := rollbackUpgrade(ctx, io.Discard, dataDir, deps.Now()); err != nil {
+			return err
+		}
+		if state, err = config.LoadState(ctx, dataDir); err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+		return writeStatusJSON(deps.Out, state.Upgrade, nil)
+	}
 
 	modified := false
 	if *channel != "" && state.Upgrade.Channel != *channel {
@@ -84,6 +118,17 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 		state.Upgrade.Paused = false
 		modified = true
 	}
+	if *applyNow {
+		plan := state.Upgrade.Plan
+		if plan.Version == "" {
+			return errors.New("no upgrade plan received yet (see --check)")
+		}
+		if plan.Version == state.Upgrade.Applied.Version {
+			return fmt.Errorf("version %s is already installed", plan.Version)
+		}
+		state.Upgrade.ApplyNow = config.UpgradeApplyNowState{Version: plan.Version, RequestedAt: deps.Now().UTC()}
+		modified = true
+	}
 
 	if modified {
 		if state.Upgrade.Channel == "" {
@@ -94,15 +139,49 @@ func Run(ctx context.Context, args []string, deps Dependencies) error {
 		}
 	}
 
-	if !modified && !*status && *channel == "" && !*pause && !*resume {
+	if !modified && !*status && !*check && *channel == "" && !*pause && !*resume && !*applyNow {
 		*status = true
 	}
 
+	var report *checkReport
+	if *check {
+		fetcher := deps.PlanFetcher
+		if fetcher == nil {
+			if fetcher, err = newPlanFetcher(cfg, state); err != nil {
+				return err
+			}
+		}
+		result, err := checkPlan(ctx, fetcher, cfg, state, deps.Now())
+		if err != nil {
+			return err
+		}
+		report = &result
+	}
+	if jsonOutput {
+		// One document carries everything, so the output always parses.
+		return writeStatusJSON(deps.Out, state.Upgrade, report)
+	}
+
 	if *status || modified {
 		fmt.Fprintf(deps.Out, "Upgrade channel: %s\n", printableChannel(state.Upgrade.Channel))
 		fmt.Fprintf(deps.Out, "Auto-upgrades paused: %t\n", state.Upgrade.Paused)
 		writePlanStatus(deps.Out, state.Upgrade.Plan)
 		writeAppliedStatus(deps.Out, state.Upgrade.Applied)
+		if staged := state.Upgrade.Staged; staged.Version != "" {
+			fmt.Fprintf(deps.Out, "Prefetched: %s at %s (awaiting rollout window)\n", staged.Version, formatTime(staged.StagedAt))
+		}
+		if request := state.Upgrade.ApplyNow; request.Version != "" {
+			fmt.Fprintf(deps.Out, "Apply-now requested: %s at %s\n", request.Version, formatTime(request.RequestedAt))
+		}
+	}
+	if *applyNow {
+		fmt.Fprintln(deps.Out, "The running agent installs it within seconds, ignoring schedule windows.")
+		if state.Upgrade.Paused {
+			fmt.Fprintln(deps.Out, "Auto-upgrades are paused locally; run --resume for the request to take effect.")
+		}
+	}
+	if report != nil {
+		writeCheckReport(deps.Out, *report)
 	}
 	return nil
 }
@@ -137,6 +216,9 @@ func writePlanStatus(out io.Writer, plan config.UpgradePlanState) {
 		fmt.Fprintf(out, "  SHA256: %s\n", plan.SHA256)
 	}
 	fmt.Fprintf(out, "  Force apply: %t\n", plan.ForceApply)
+	if plan.IgnoreReadiness {
+		fmt.Fprintln(out, "  Ignore readiness: true")
+	}
 	fmt.Fprintf(out, "  Controller paused: %t\n", plan.Paused)
 	if plan.Schedule.Earliest != nil {
 		fmt.Fprintf(out, "  Window earliest: %s\n", formatTime(*plan.Schedule.Earliest))
@@ -144,6 +226,9 @@ func writePlanStatus(out io.Writer, plan config.UpgradePlanState) {
 	if plan.Schedule.Latest != nil {
 		fmt.Fprintf(out, "  Window latest: %s\n", formatTime(*plan.Schedule.Latest))
 	}
+	if plan.Schedule.Prefetch {
+		fmt.Fprintln(out, "  Prefetch: true")
+	}
 	if plan.Notes != "" {
 		fmt.Fprintf(out, "  Notes: %s\n", plan.Notes)
 	}
@@ -3,6 +3,7 @@ package upgradecli
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,6 +11,7 @@ import (
 	"time"
 
 	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/upgrade"
 	"gopkg.in/yaml.v3"
 )
 
@@ -135,4 +137,228 @@ func TestRunErrors(t *testing.T) {
 	if err := Run(ctx, []string{"--config", configPath}, deps); err == nil {
 		t.Fatalf("expected error loading state when absent")
 	}
+
+	stablePath := filepath.Join(tmp, "stable.yaml")
+	body := "agent:\n  data_dir: " + dataDir + "\nupgrade:\n  allowed_channels: [stable]\n"
+	if err := os.WriteFile(stablePath, []byte(body), 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	err := Run(ctx, []string{"--config", stablePath, "--channel", "canary"}, deps)
+	if err == nil || !strings.Contains(err.Error(), "upgrade.allowed_channels") {
+		t.Fatalf("expected canary refused by allowlist, got %v", err)
+	}
+}
+
+type stubPlanFetcher struct {
+	result  upgrade.PlanResult
+	channel string
+	etag    string
+}
+
+func (s *stubPlanFetcher) FetchPlan(ctx context.Context, channel, etag string) (upgrade.PlanResult, error) {
+	s.channel, s.etag = channel, etag
+	return s.result, nil
+}
+
+func TestRunCheckFetchesPlan(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	dataDir := filepath.Join(tmp, "data")
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		t.Fatalf("mkdir data dir: %v", err)
+	}
+	configPath := filepath.Join(tmp, "agent.yaml")
+	writeConfig(t, configPath, dataDir)
+	state := config.State{AgentID: "agt", Upgrade: config.UpgradeState{
+		Channel: "canary",
+		Plan:    config.UpgradePlanState{ETag: `"cached"`},
+		Applied: config.UpgradeAppliedState{Version: "1.2.2"},
+	}}
+	if err := config.SaveState(ctx, dataDir, state); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	now := time.Date(2025, time.November, 1, 12, 0, 0, 0, time.UTC)
+	earliest := now.Add(time.Hour)
+	fetcher := &stubPlanFetcher{result: upgrade.PlanResult{Plan: upgrade.Plan{
+		Channel:  "canary",
+		Artifact: upgrade.PlanArtifact{Version: "1.2.3", URL: "https://example.com/agent.tgz"},
+		Schedule: upgrade.PlanSchedule{Earliest: &earliest},
+	}}}
+	out := &bytes.Buffer{}
+	deps := Dependencies{Now: func() time.Time { return now }, Out: out, PlanFetcher: fetcher}
+	if err := Run(ctx, []string{"--config", configPath, "--check"}, deps); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if fetcher.channel != "canary" || fetcher.etag != "" {
+		t.Fatalf("expected unconditional fetch for canary, got channel=%q etag=%q", fetcher.channel, fetcher.etag)
+	}
+	output := out.String()
+	for _, want := range []string{"Controller plan: 1.2.3", "Installed version: 1.2.2", "Upgrade pending: true", "Waiting: rollout window opens"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected %q in output: %s", want, output)
+		}
+	}
+
+	out.Reset()
+	fetcher.result.Plan.Artifact.Version = "1.2.2"
+	if err := Run(ctx, []string{"--config", configPath, "--check"}, deps); err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !strings.Contains(out.String(), "Upgrade pending: false") {
+		t.Fatalf("expected no pending upgrade: %s", out.String())
+	}
+}
+
+func TestRunJSONOutput(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	dataDir := filepath.Join(tmp, "data")
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		t.Fatalf("mkdir data dir: %v", err)
+	}
+	configPath := filepath.Join(tmp, "agent.yaml")
+	writeConfig(t, configPath, dataDir)
+	appliedAt := time.Date(2025, time.October, 30, 8, 0, 0, 0, time.UTC)
+	state := config.State{AgentID: "agt", Upgrade: config.UpgradeState{
+		Channel: "stable",
+		Plan: config.UpgradePlanState{
+			Version:  "1.2.3",
+			Channel:  "stable",
+			Schedule: config.UpgradePlanSchedule{MaintenanceWindows: []string{"Sat 01:00-05:00"}},
+		},
+		Applied:     config.UpgradeAppliedState{Version: "1.2.2", PreviousVersion: "1.2.1", Path: "/usr/bin/agent", AppliedAt: appliedAt},
+		BadVersions: []string{"1.2.0"},
+	}}
+	if err := config.SaveState(ctx, dataDir, state); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	now := time.Date(2025, time.November, 1, 12, 0, 0, 0, time.UTC)
+	fetcher := &stubPlanFetcher{result: upgrade.PlanResult{Plan: upgrade.Plan{
+		Channel:  "stable",
+		Artifact: upgrade.PlanArtifact{Version: "1.2.3", URL: "https://example.com/agent.tgz"},
+	}}}
+	out := &bytes.Buffer{}
+	deps := Dependencies{Now: func() time.Time { return now }, Out: out, PlanFetcher: fetcher}
+	if err := Run(ctx, []string{"--config", configPath, "--check", "--output", "json"}, deps); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	var doc statusJSON
+	if err := json.Unmarshal(out.Bytes(), &doc); err != nil {
+		t.Fatalf("decode output: %v\n%s", err, out.String())
+	}
+	if doc.Channel != "stable" || doc.Plan == nil || doc.Plan.Version != "1.2.3" {
+		t.Fatalf("unexpected plan in output: %s", out.String())
+	}
+	if got := doc.Plan.Schedule.MaintenanceWindows; len(got) != 1 || got[0] != "Sat 01:00-05:00" {
+		t.Fatalf("expected maintenance window in output, got %v", got)
+	}
+	if doc.Applied.Version != "1.2.2" || doc.Applied.PreviousVersion != "1.2.1" || doc.Applied.AppliedAt == nil || !doc.Applied.AppliedAt.Equal(appliedAt) {
+		t.Fatalf("unexpected applied state: %+v", doc.Applied)
+	}
+	if len(doc.BadVersions) != 1 || doc.BadVersions[0] != "1.2.0" {
+		t.Fatalf("unexpected bad versions: %v", doc.BadVersions)
+	}
+	if doc.Check == nil || !doc.Check.PlanFound || doc.Check.PlanVersion != "1.2.3" || !doc.Check.Pending {
+		t.Fatalf("unexpected check result: %+v", doc.Check)
+	}
+
+	if err := Run(ctx, []string{"--config", configPath, "--output", "yaml"}, deps); err == nil {
+		t.Fatal("expected error for unsupported output format")
+	}
+}
+
+func TestRunApplyNowRecordsRequest(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	dataDir := filepath.Join(tmp, "data")
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		t.Fatalf("mkdir data dir: %v", err)
+	}
+	configPath := filepath.Join(tmp, "agent.yaml")
+	writeConfig(t, configPath, dataDir)
+	state := config.State{AgentID: "agt", Upgrade: config.UpgradeState{Applied: config.UpgradeAppliedState{Version: "1.2.2"}}}
+	if err := config.SaveState(ctx, dataDir, state); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	now := time.Date(2025, time.November, 1, 12, 0, 0, 0, time.UTC)
+	out := &bytes.Buffer{}
+	deps := Dependencies{Now: func() time.Time { return now }, Out: out}
+	if err := Run(ctx, []string{"--config", configPath, "--apply-now"}, deps); err == nil {
+		t.Fatalf("expected error without a plan")
+	}
+
+	state.Upgrade.Plan = config.UpgradePlanState{Version: "1.2.3", Channel: "stable"}
+	if err := config.UpdateState(ctx, dataDir, state); err != nil {
+		t.Fatalf("update state: %v", err)
+	}
+	if err := Run(ctx, []string{"--config", configPath, "--apply-now"}, deps); err != nil {
+		t.Fatalf("apply-now: %v", err)
+	}
+	loaded, err := config.LoadState(ctx, dataDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	if request := loaded.Upgrade.ApplyNow; request.Version != "1.2.3" || !request.RequestedAt.Equal(now) {
+		t.Fatalf("unexpected apply-now request %+v", request)
+	}
+	if !strings.Contains(out.String(), "Apply-now requested: 1.2.3") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+}
+
+func TestRunRollbackRestoresBackup(t *testing.T) {
+	ctx := context.Background()
+	tmp := t.TempDir()
+	dataDir := filepath.Join(tmp, "data")
+	if err := os.MkdirAll(dataDir, 0o700); err != nil {
+		t.Fatalf("mkdir data dir: %v", err)
+	}
+	configPath := filepath.Join(tmp, "agent.yaml")
+	writeConfig(t, configPath, dataDir)
+	target := filepath.Join(tmp, "pingsanto-agent")
+	if err := os.WriteFile(target, []byte("new"), 0o755); err != nil {
+		t.Fatalf("write target: %v", err)
+	}
+	if err := os.WriteFile(target+".bak", []byte("old"), 0o755); err != nil {
+		t.Fatalf("write backup: %v", err)
+	}
+	state := config.State{AgentID: "agt", Upgrade: config.UpgradeState{
+		Channel: "stable",
+		Applied: config.UpgradeAppliedState{Version: "1.2.3", PreviousVersion: "1.2.2", Path: target},
+	}}
+	if err := config.SaveState(ctx, dataDir, state); err != nil {
+		t.Fatalf("save state: %v", err)
+	}
+
+	now := time.Date(2025, time.November, 1, 12, 0, 0, 0, time.UTC)
+	out := &bytes.Buffer{}
+	deps := Dependencies{Now: func() time.Time { return now }, Out: out}
+	if err := Run(ctx, []string{"--config", configPath, "--rollback"}, deps); err != nil {
+		t.Fatalf("rollback: %v", err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "old" {
+		t.Fatalf("expected previous binary restored, got %q (%v)", data, err)
+	}
+	loaded, err := config.LoadState(ctx, dataDir)
+	if err != nil {
+		t.Fatalf("load state: %v", err)
+	}
+	up := loaded.Upgrade
+	if up.Applied.Version != "1.2.2" || up.Applied.PreviousVersion != "" {
+		t.Fatalf("unexpected applied state %+v", up.Applied)
+	}
+	if up.Rollback.Version != "1.2.3" || up.Rollback.Stage != "manual" || !up.Rollback.Restart || !up.Rollback.At.Equal(now) {
+		t.Fatalf("unexpected rollback state %+v", up.Rollback)
+	}
+	if len(up.BadVersions) != 1 || up.BadVersions[0] != "1.2.3" {
+		t.Fatalf("expected 1.2.3 marked bad, got %v", up.BadVersions)
+	}
+
+	if err := Run(ctx, []string{"--config", configPath, "--rollback"}, deps); err == nil {
+		t.Fatalf("expected error without a backup to restore")
+	}
 }
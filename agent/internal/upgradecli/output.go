@@ -0,0 +1,166 @@
+package upgradecli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/config"
+)
+
+// statusJSON is the `--output json` document. Field names are part of the
+// CLI's interface: configuration management asserts on them, so rename
+// nothing and only add fields.
+type statusJSON struct {
+	Channel     string        `json:"channel"`
+	Paused      bool          `json:"paused"`
+	Plan        *planJSON     `json:"plan"`
+	Applied     appliedJSON   `json:"applied"`
+	Staged      *stagedJSON   `json:"staged,omitempty"`
+	ApplyNow    *applyNowJSON `json:"apply_now,omitempty"`
+	Pending     *pendingJSON  `json:"pending,omitempty"`
+	Rollback    *rollbackJSON `json:"rollback,omitempty"`
+	BadVersions []string      `json:"bad_versions"`
+	Check       *checkReport  `json:"check,omitempty"`
+}
+
+type planJSON struct {
+	Version         string       `json:"version"`
+	Channel         string       `json:"channel"`
+	Source          string       `json:"source,omitempty"`
+	Paused          bool         `json:"paused"`
+	ArtifactURL     string       `json:"artifact_url,omitempty"`
+	SignatureURL    string       `json:"signature_url,omitempty"`
+	SHA256          string       `json:"sha256,omitempty"`
+	ForceApply      bool         `json:"force_apply"`
+	IgnoreReadiness bool         `json:"ignore_readiness"`
+	Notes           string       `json:"notes,omitempty"`
+	Schedule        scheduleJSON `json:"schedule"`
+	RetrievedAt     *time.Time   `json:"retrieved_at,omitempty"`
+}
+
+type scheduleJSON struct {
+	Earliest           *time.Time `json:"earliest,omitempty"`
+	Latest             *time.Time `json:"latest,omitempty"`
+	MaintenanceWindows []string   `json:"maintenance_windows,omitempty"`
+	Prefetch           bool       `json:"prefetch"`
+}
+
+type appliedJSON struct {
+	Version         string     `json:"version"`
+	PreviousVersion string     `json:"previous_version,omitempty"`
+	Path            string     `json:"path,omitempty"`
+	AppliedAt       *time.Time `json:"applied_at,omitempty"`
+	LastAttempt     *time.Time `json:"last_attempt,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+type stagedJSON struct {
+	Version  string     `json:"version"`
+	SHA256   string     `json:"sha256,omitempty"`
+	StagedAt *time.Time `json:"staged_at,omitempty"`
+}
+
+type applyNowJSON struct {
+	Version     string     `json:"version"`
+	RequestedAt *time.Time `json:"requested_at,omitempty"`
+}
+
+type pendingJSON struct {
+	Version         string     `json:"version"`
+	PreviousVersion string     `json:"previous_version,omitempty"`
+	RestartedAt     *time.Time `json:"restarted_at,omitempty"`
+	Starts          int        `json:"starts"`
+	Verified        bool       `json:"verified"`
+}
+
+type rollbackJSON struct {
+	Version         string     `json:"version"`
+	PreviousVersion string     `json:"previous_version,omitempty"`
+	Stage           string     `json:"stage,omitempty"`
+	Reason          string     `json:"reason,omitempty"`
+	At              *time.Time `json:"at,omitempty"`
+}
+
+// writeStatusJSON prints the upgrade state as one indented JSON object,
+// with the --check result attached when check is non-nil.
+func writeStatusJSON(out io.Writer, upgrade config.UpgradeState, check *checkReport) error {
+	doc := statusJSON{
+		Channel:     upgrade.Channel,
+		Paused:      upgrade.Paused,
+		BadVersions: upgrade.BadVersions,
+		Check:       check,
+		Applied: appliedJSON{
+			Version:         upgrade.Applied.Version,
+			PreviousVersion: upgrade.Applied.PreviousVersion,
+			Path:            upgrade.Applied.Path,
+			AppliedAt:       optionalTime(upgrade.Applied.AppliedAt),
+			LastAttempt:     optionalTime(upgrade.Applied.LastAttempt),
+			LastError:       upgrade.Applied.LastError,
+		},
+	}
+	if doc.BadVersions == nil {
+		doc.BadVersions = []string{}
+	}
+	if plan := upgrade.Plan; plan.Version != "" {
+		doc.Plan = &planJSON{
+			Version:         plan.Version,
+			Channel:         plan.Channel,
+			Source:          plan.Source,
+			Paused:          plan.Paused,
+			ArtifactURL:     plan.ArtifactURL,
+			SignatureURL:    plan.SignatureURL,
+			SHA256:          plan.SHA256,
+			ForceApply:      plan.ForceApply,
+			IgnoreReadiness: plan.IgnoreReadiness,
+			Notes:           plan.Notes,
+			Schedule: scheduleJSON{
+				Earliest:           plan.Schedule.Earliest,
+				Latest:             plan.Schedule.Latest,
+				MaintenanceWindows: plan.Schedule.MaintenanceWindows,
+				Prefetch:           plan.Schedule.Prefetch,
+			},
+			RetrievedAt: optionalTime(plan.RetrievedAt),
+		}
+	}
+	if staged := upgrade.Staged; staged.Version != "" {
+		doc.Staged = &stagedJSON{Version: staged.Version, SHA256: staged.SHA256, StagedAt: optionalTime(staged.StagedAt)}
+	}
+	if request := upgrade.ApplyNow; request.Version != "" {
+		doc.ApplyNow = &applyNowJSON{Version: request.Version, RequestedAt: optionalTime(request.RequestedAt)}
+	}
+	if pending := upgrade.Pending; pending.Version != "" {
+		doc.Pending = &pendingJSON{
+			Version:         pending.Version,
+			PreviousVersion: pending.PreviousVersion,
+			RestartedAt:     optionalTime(pending.RestartedAt),
+			Starts:          pending.Starts,
+			Verified:        pending.Verified,
+		}
+	}
+	if rollback := upgrade.Rollback; rollback.Version != "" {
+		doc.Rollback = &rollbackJSON{
+			Version:         rollback.Version,
+			PreviousVersion: rollback.PreviousVersion,
+			Stage:           rollback.Stage,
+			Reason:          rollback.Reason,
+			At:              optionalTime(rollback.At),
+		}
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return fmt.Errorf("encode status: %w", err)
+	}
+	return nil
+}
+
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	utc := t.UTC()
+	return &utc
+}
@@ -0,0 +1,75 @@
+package upgradecli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/pingsantohq/agent/internal/config"
+	"github.com/pingsantohq/agent/internal/upgrade"
+)
+
+const manualRollbackReason = "rolled back by operator"
+
+// rollbackUpgrade restores the binary the last upgrade replaced, the
+// installer's .bak next to the installed path. The backed-out version is
+// marked bad so the agent does not reinstall it, and a rollback is queued in
+// state: a running agent reports it to the controller and restarts into the
+// restored binary within seconds, otherwise the next start reports it.
+func rollbackUpgrade(ctx context.Context, out io.Writer, dataDir string, now time.Time) error {
+	lock, err := upgrade.AcquireLock(dataDir)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	state, err := config.LoadState(ctx, dataDir)
+	if err != nil {
+		return fmt.Errorf("load state: %w", err)
+	}
+	applied := state.Upgrade.Applied
+	if applied.Version == "" || applied.Path == "" {
+		return errors.New("no installed upgrade recorded in state")
+	}
+	install := upgrade.InstallResult{TargetPath: applied.Path, BackupPath: applied.Path + ".bak"}
+	if _, err := os.Stat(install.BackupPath); err != nil {
+		return fmt.Errorf("no previous binary to restore: %w", err)
+	}
+	if err := (&upgrade.BinaryInstaller{}).Rollback(ctx, install); err != nil {
+		return err
+	}
+
+	channel := state.Upgrade.Plan.Channel
+	if channel == "" {
+		channel = state.Upgrade.Channel
+	}
+	state.Upgrade.Rollback = config.UpgradeRollbackState{
+		Version:         applied.Version,
+		PreviousVersion: applied.PreviousVersion,
+		Channel:         channel,
+		Stage:           "manual",
+		Reason:          manualRollbackReason,
+		At:              now.UTC(),
+		Restart:         true,
+	}
+	if !slices.Contains(state.Upgrade.BadVersions, applied.Version) {
+		state.Upgrade.BadVersions = append(state.Upgrade.BadVersions, applied.Version)
+	}
+	state.Upgrade.Applied.Version = applied.PreviousVersion
+	state.Upgrade.Applied.PreviousVersion = ""
+	state.Upgrade.Applied.LastError = manualRollbackReason
+	state.Upgrade.Pending = config.UpgradePendingState{}
+	state.Upgrade.ApplyNow = config.UpgradeApplyNowState{}
+	if err := config.UpdateState(ctx, dataDir, state); err != nil {
+		return fmt.Errorf("update state: %w", err)
+	}
+
+	fmt.Fprintf(out, "Restored %s from %s\n", install.TargetPath, install.BackupPath)
+	fmt.Fprintf(out, "Rolled back %s -> %s; %s will not be reinstalled unless a plan forces it.\n", applied.Version, printableVersion(applied.PreviousVersion), applied.Version)
+	fmt.Fprintln(out, "A running agent reports the rollback and restarts within seconds; otherwise it is reported on the next start.")
+	return nil
+}
@@ -8,11 +8,13 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/pingsantohq/agent/internal/metrics"
+	"github.com/pingsantohq/agent/internal/throttle"
 	"github.com/pingsantohq/agent/internal/transmit"
 	"github.com/pingsantohq/agent/pkg/types"
 )
@@ -21,6 +23,10 @@ const (
 	defaultResultsPath   = "/api/agent/v1/results"
 	defaultHeartbeatPath = "/api/agent/v1/heartbeat"
 	defaultMonitorPath   = "/api/agent/v1/monitors"
+
+	// longPollGrace is added to the requested wait so the client does not give up
+	// before the server has had a chance to answer with 304.
+	longPollGrace = 10 * time.Second
 )
 
 // Config holds the static configuration for an Uplink client.
@@ -32,27 +38,45 @@ type Config struct {
 
 // Dependencies allow test overrides for HTTP client, clock, and logging.
 type Dependencies struct {
-	HTTPClient    *http.Client
-	Metrics       *metrics.Store
-	Now           func() time.Time
-	Logger        *log.Logger
-	ResultsPath   string
-	HeartbeatPath string
-	MonitorPath   string
+	HTTPClient       *http.Client
+	StreamHTTPClient *http.Client
+	Metrics          *metrics.Store
+	Now              func() time.Time
+	Logger           *log.Logger
+	ResultsPath      string
+	HeartbeatPath    string
+	MonitorPath      string
+	DirectivePath    string
+	Directives       DirectiveHandler
+	// ClockSkew receives the local clock offset from the controller, estimated from
+	// the Date header of each heartbeat response.
+	ClockSkew func(time.Duration)
+	// Labels, when set, is consulted before every heartbeat so label changes
+	// (e.g. `pingsanto-agent labels set`) reach the controller without a restart.
+	Labels func() map[string]string
 }
 
 // Client provides result publishing and heartbeat signalling to the central service.
 type Client struct {
 	httpClient   *http.Client
+	streamClient *http.Client
 	resultsURL   string
 	heartbeatURL string
 	monitorURL   string
+	directiveURL string
+	directives   DirectiveHandler
+	clockSkew    func(time.Duration)
+	certExpiry   atomic.Pointer[time.Time]
 	agentID      string
-	labels       map[string]string
+	labels       atomic.Pointer[map[string]string]
+	labelSource  func() map[string]string
 	metrics      *metrics.Store
+	requests     metrics.UplinkRecorder
 	now          func() time.Time
 	logger       *log.Logger
 	seq          atomic.Uint64
+	// heartbeatInterval carries interval changes to a running RunHeartbeat.
+	heartbeatInterval chan time.Duration
 }
 
 // NewClient builds an Uplink client from configuration and dependencies.
@@ -87,21 +111,59 @@ func NewClient(cfg Config, deps Dependencies) (*Client, error) {
 	if monitorPath == "" {
 		monitorPath = defaultMonitorPath
 	}
+	directivePath := deps.DirectivePath
+	if directivePath == "" {
+		directivePath = defaultDirectivePath
+	}
+	// Monitor streams stay open indefinitely, so they cannot share the request timeout.
+	streamClient := deps.StreamHTTPClient
+	if streamClient == nil {
+		clone := *httpClient
+		clone.Timeout = 0
+		streamClient = &clone
+	}
+
+	var requests metrics.UplinkRecorder = metrics.NoopUplinkRecorder{}
+	if deps.Metrics != nil {
+		requests = deps.Metrics.UplinkRecorder()
+	}
 
 	client := &Client{
 		httpClient:   httpClient,
+		streamClient: streamClient,
 		resultsURL:   joinURL(cfg.ServerURL, resultsPath),
 		heartbeatURL: joinURL(cfg.ServerURL, heartbeatPath),
 		monitorURL:   joinURL(cfg.ServerURL, monitorPath),
+		directiveURL: joinURL(cfg.ServerURL, directivePath),
+		directives:   deps.Directives,
+		clockSkew:    deps.ClockSkew,
 		agentID:      cfg.AgentID,
-		labels:       cloneLabels(cfg.Labels),
+		labelSource:  deps.Labels,
 		metrics:      deps.Metrics,
+		requests:     requests,
 		now:          now,
 		logger:       logger,
+
+		heartbeatInterval: make(chan time.Duration, 1),
 	}
+	client.SetLabels(cfg.Labels)
 	return client, nil
 }
 
+// SetLabels replaces the labels sent with heartbeats and result batches.
+func (c *Client) SetLabels(labels map[string]string) {
+	cloned := cloneLabels(labels)
+	c.labels.Store(&cloned)
+}
+
+// Labels returns the labels currently sent to the controller.
+func (c *Client) Labels() map[string]string {
+	if labels := c.labels.Load(); labels != nil {
+		return cloneLabels(*labels)
+	}
+	return nil
+}
+
 // Send implements transmit.Sink, encoding results into a result envelope.
 func (c *Client) Send(ctx context.Context, results []types.ProbeResult) error {
 	if len(results) == 0 {
@@ -112,7 +174,7 @@ func (c *Client) Send(ctx context.Context, results []types.ProbeResult) error {
 		AgentID:  c.agentID,
 		SentAt:   c.now().UTC(),
 		BatchSeq: c.seq.Add(1),
-		Labels:   cloneLabels(c.labels),
+		Labels:   c.Labels(),
 		Results:  cloneResults(results),
 	}
 
@@ -129,12 +191,15 @@ func (c *Client) Send(ctx context.Context, results []types.ProbeResult) error {
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "pingsanto-agent/0.0.1")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(c.httpClient, endpointResults, req)
 	if err != nil {
 		return fmt.Errorf("send results: %w", err)
 	}
 	defer resp.Body.Close()
 	io.Copy(io.Discard, resp.Body)
+	if err := throttle.FromResponse(resp, c.now()); err != nil {
+		return fmt.Errorf("results upload: %w", err)
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return fmt.Errorf("results upload failed: status %s", resp.Status)
 	}
@@ -151,59 +216,127 @@ func (c *Client) RunHeartbeat(ctx context.Context, interval time.Duration) error
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	c.sendHeartbeat(ctx)
+	beat := func() error {
+		delay := c.sendHeartbeat(ctx)
+		if delay <= 0 {
+			return nil
+		}
+		c.logger.Printf("heartbeat throttled by server; backing off %s", delay)
+		if err := throttle.Sleep(ctx, delay); err != nil {
+			return err
+		}
+		ticker.Reset(interval)
+		return nil
+	}
+
+	if err := beat(); err != nil {
+		return err
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
+		case next := <-c.heartbeatInterval:
+			if next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
 		case <-ticker.C:
-			c.sendHeartbeat(ctx)
+			if err := beat(); err != nil {
+				return err
+			}
 		}
 	}
 }
 
-func (c *Client) sendHeartbeat(ctx context.Context) {
+// SetHeartbeatInterval changes the cadence of a running RunHeartbeat; the next
+// heartbeat is sent one new interval from now. Non-positive values are ignored.
+func (c *Client) SetHeartbeatInterval(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	for {
+		select {
+		case c.heartbeatInterval <- interval:
+			return
+		default:
+		}
+		select {
+		case <-c.heartbeatInterval:
+		default:
+		}
+	}
+}
+
+// sendHeartbeat posts a single heartbeat and returns the back-off requested by
+// the server, if any.
+func (c *Client) sendHeartbeat(ctx context.Context) time.Duration {
+	if c.labelSource != nil {
+		c.SetLabels(c.labelSource())
+	}
 	payload := c.heartbeatPayload()
 	data, err := json.Marshal(payload)
 	if err != nil {
 		c.logger.Printf("heartbeat marshal failed: %v", err)
-		return
+		return 0
 	}
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.heartbeatURL, bytes.NewReader(data))
 	if err != nil {
 		c.logger.Printf("heartbeat request build failed: %v", err)
-		return
+		return 0
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("User-Agent", "pingsanto-agent/0.0.1")
 
-	resp, err := c.httpClient.Do(req)
+	sentAt := c.now()
+	resp, err := c.do(c.httpClient, endpointHeartbeat, req)
 	if err != nil {
 		c.logger.Printf("heartbeat send failed: %v", err)
-		return
+		return 0
 	}
 	defer resp.Body.Close()
-	io.Copy(io.Discard, resp.Body)
+	c.observeClockSkew(resp, sentAt, c.now())
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if delay, ok := throttle.Delay(throttle.FromResponse(resp, c.now())); ok {
+		return delay
+	}
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		c.logger.Printf("heartbeat failed: %s", resp.Status)
+		return 0
+	}
+	// Older controllers reply with an empty body; only a directive list is meaningful here.
+	var hbResp heartbeatResponse
+	if len(bytes.TrimSpace(body)) > 0 && json.Unmarshal(body, &hbResp) == nil {
+		c.handleDirectives(ctx, hbResp.Directives)
 	}
+	return 0
 }
 
 func (c *Client) heartbeatPayload() heartbeatPayload {
 	snap := metrics.Snapshot{}
+	var ready *bool
 	if c.metrics != nil {
 		snap = c.metrics.Snapshot()
+		ready = &snap.Ready
 	}
 	return heartbeatPayload{
-		AgentID:              c.agentID,
-		SentAt:               c.now().UTC(),
-		QueueDepth:           snap.QueueDepth,
-		QueueDroppedTotal:    snap.QueueDroppedTotal,
-		QueueSpilledTotal:    snap.QueueSpilledTotal,
-		BackfillPendingBytes: snap.BackfillPendingBytes,
+		AgentID:                  c.agentID,
+		SentAt:                   c.now().UTC(),
+		QueueDepth:               snap.QueueDepth,
+		QueueDroppedTotal:        snap.QueueDroppedTotal,
+		QueueSpilledTotal:        snap.QueueSpilledTotal,
+		BackfillPendingBytes:     snap.BackfillPendingBytes,
+		BackfillReplayedBatches:  snap.BackfillReplayedBatches,
+		BackfillReplayedResults:  snap.BackfillReplayedResults,
+		BackfillReplayLagSeconds: snap.BackfillReplayLagSeconds,
+		BackfillReplayThroughput: snap.BackfillReplayThroughput,
+		CertExpiresAt:            c.certExpiry.Load(),
+		Labels:                   c.Labels(),
+		Ready:                    ready,
+		ReadyReason:              snap.ReadyReason,
 	}
 }
 
@@ -217,7 +350,23 @@ type MonitorSnapshotResult struct {
 // FetchMonitors retrieves the current monitor assignment snapshot from the central service.
 // The caller may pass the previously observed ETag to leverage conditional requests.
 func (c *Client) FetchMonitors(ctx context.Context, etag string) (MonitorSnapshotResult, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.monitorURL, nil)
+	return c.FetchMonitorsWait(ctx, etag, 0)
+}
+
+// FetchMonitorsWait is the long-poll variant of FetchMonitors: when wait is positive the
+// server holds the request until the revision moves past etag or wait elapses, in which
+// case the result is NotModified.
+func (c *Client) FetchMonitorsWait(ctx context.Context, etag string, wait time.Duration) (MonitorSnapshotResult, error) {
+	monitorURL := c.monitorURL
+	httpClient := c.httpClient
+	if wait > 0 {
+		monitorURL += "?wait=" + url.QueryEscape(wait.String())
+		httpClient = c.streamClient
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, wait+longPollGrace)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, monitorURL, nil)
 	if err != nil {
 		return MonitorSnapshotResult{}, fmt.Errorf("build monitor request: %w", err)
 	}
@@ -226,7 +375,7 @@ func (c *Client) FetchMonitors(ctx context.Context, etag string) (MonitorSnapsho
 		req.Header.Set("If-None-Match", etag)
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(httpClient, endpointMonitors, req)
 	if err != nil {
 		return MonitorSnapshotResult{}, fmt.Errorf("fetch monitors: %w", err)
 	}
@@ -237,6 +386,9 @@ func (c *Client) FetchMonitors(ctx context.Context, etag string) (MonitorSnapsho
 		return MonitorSnapshotResult{}, fmt.Errorf("read monitor response: %w", err)
 	}
 
+	if err := throttle.FromResponse(resp, c.now()); err != nil {
+		return MonitorSnapshotResult{}, fmt.Errorf("fetch monitors: %w", err)
+	}
 	if resp.StatusCode == http.StatusNotModified {
 		return MonitorSnapshotResult{
 			ETag:        etag,
@@ -259,13 +411,58 @@ func (c *Client) FetchMonitors(ctx context.Context, etag string) (MonitorSnapsho
 	}, nil
 }
 
+// observeClockSkew compares the controller's Date header with the local clock at
+// the midpoint of the round trip. Date has one-second resolution, so the server
+// time is taken as the middle of that second.
+func (c *Client) observeClockSkew(resp *http.Response, sentAt, receivedAt time.Time) {
+	if c.clockSkew == nil {
+		return
+	}
+	serverDate, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	localMid := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+	c.clockSkew(localMid.Sub(serverDate.Add(500 * time.Millisecond)))
+}
+
+// Endpoint labels used for uplink request metrics.
+const (
+	endpointResults      = "results"
+	endpointHeartbeat    = "heartbeat"
+	endpointMonitors     = "monitors"
+	endpointStream       = "monitors_stream"
+	endpointDirectiveAck = "directive_ack"
+)
+
+// do sends req and records its outcome, latency to response headers, and
+// request body size under endpoint.
+func (c *Client) do(httpClient *http.Client, endpoint string, req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	status := 0
+	if resp != nil {
+		status = resp.StatusCode
+	}
+	c.requests.ObserveRequest(endpoint, status, err, time.Since(start), req.ContentLength)
+	return resp, err
+}
+
 type heartbeatPayload struct {
-	AgentID              string    `json:"agent_id"`
-	SentAt               time.Time `json:"sent_at"`
-	QueueDepth           int64     `json:"queue_depth"`
-	QueueDroppedTotal    uint64    `json:"queue_dropped_total"`
-	QueueSpilledTotal    uint64    `json:"queue_spilled_total"`
-	BackfillPendingBytes int64     `json:"backfill_pending_bytes"`
+	AgentID                  string            `json:"agent_id"`
+	SentAt                   time.Time         `json:"sent_at"`
+	QueueDepth               int64             `json:"queue_depth"`
+	QueueDroppedTotal        uint64            `json:"queue_dropped_total"`
+	QueueSpilledTotal        uint64            `json:"queue_spilled_total"`
+	BackfillPendingBytes     int64             `json:"backfill_pending_bytes"`
+	BackfillReplayedBatches  uint64            `json:"backfill_replayed_batches_total"`
+	BackfillReplayedResults  uint64            `json:"backfill_replayed_results_total"`
+	BackfillReplayLagSeconds float64           `json:"backfill_replay_lag_seconds"`
+	BackfillReplayThroughput float64           `json:"backfill_replay_throughput"`
+	CertExpiresAt            *time.Time        `json:"cert_expires_at,omitempty"`
+	Labels                   map[string]string `json:"labels,omitempty"`
+	Ready                    *bool             `json:"ready,omitempty"`
+	ReadyReason              string            `json:"ready_reason,omitempty"`
 }
 
 func cloneResults(in []types.ProbeResult) []types.ProbeResult {
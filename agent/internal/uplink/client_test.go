@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/pingsantohq/agent/internal/metrics"
+	"github.com/pingsantohq/agent/internal/throttle"
 	"github.com/pingsantohq/agent/pkg/types"
 )
 
@@ -104,11 +105,79 @@ func TestClientSendHandlesFailures(t *testing.T) {
 	}
 }
 
+func TestClientSendReportsServerThrottle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt_test"},
+		Dependencies{HTTPClient: server.Client()},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	err = client.Send(context.Background(), []types.ProbeResult{{MonitorID: "mon"}})
+	if delay, ok := throttle.Delay(err); !ok || delay != 7*time.Second {
+		t.Fatalf("expected 7s throttle delay, got %v (err=%v)", delay, err)
+	}
+}
+
+func TestClientRecordsUplinkRequestMetrics(t *testing.T) {
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	store := metrics.NewStore()
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt_test"},
+		Dependencies{HTTPClient: server.Client(), Metrics: store},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	results := []types.ProbeResult{{MonitorID: "mon-1"}}
+	if err := client.Send(context.Background(), results); err == nil {
+		t.Fatalf("expected error from 502")
+	}
+	fail = false
+	if err := client.Send(context.Background(), results); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+
+	snap := store.Snapshot()
+	if len(snap.UplinkEndpoints) != 1 {
+		t.Fatalf("expected one endpoint, got %+v", snap.UplinkEndpoints)
+	}
+	ep := snap.UplinkEndpoints[0]
+	if ep.Endpoint != endpointResults || ep.Requests != 2 || ep.Errors != 1 {
+		t.Fatalf("unexpected endpoint stats: %+v", ep)
+	}
+	if ep.StatusClasses["2xx"] != 1 || ep.StatusClasses["5xx"] != 1 {
+		t.Fatalf("unexpected status classes: %+v", ep.StatusClasses)
+	}
+	if ep.BytesSent == 0 || ep.LatencyCount != 2 {
+		t.Fatalf("expected bytes and latency recorded: %+v", ep)
+	}
+}
+
 func TestHeartbeatIncludesMetrics(t *testing.T) {
 	store := metrics.NewStore()
 	store.QueueRecorder().ObserveQueueDepth(7)
 	store.QueueRecorder().IncQueueDrops()
 	store.BackfillRecorder().ObservePendingBytes(1024)
+	store.BackfillRecorder().ObserveReplayedBatch(3)
+	store.BackfillRecorder().ObserveReplayLag(2 * time.Hour)
 
 	hbCh := make(chan heartbeatPayload, 1)
 
@@ -157,9 +226,12 @@ func TestHeartbeatIncludesMetrics(t *testing.T) {
 		if hb.AgentID != "agt_test" {
 			t.Fatalf("unexpected agent id %s", hb.AgentID)
 		}
-		if hb.QueueDepth != 7 || hb.QueueDroppedTotal != 1 || hb.BackfillPendingBytes != 1024 {
+		if hb.QueueDepth != 7 || hb.QueueDroppedTotal != 1 || hb.BackfillPendingBytes != 1024 || hb.Ready == nil {
 			t.Fatalf("unexpected heartbeat payload: %+v", hb)
 		}
+		if hb.BackfillReplayedBatches != 1 || hb.BackfillReplayedResults != 3 || hb.BackfillReplayLagSeconds != 7200 {
+			t.Fatalf("unexpected backfill replay fields: %+v", hb)
+		}
 		cancel()
 	case <-time.After(time.Second):
 		t.Fatalf("timeout waiting for heartbeat")
@@ -170,6 +242,94 @@ func TestHeartbeatIncludesMetrics(t *testing.T) {
 	}
 }
 
+func TestHeartbeatEstimatesClockSkewFromDate(t *testing.T) {
+	serverTime := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", serverTime.Format(http.TimeFormat))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	local := serverTime.Add(10 * time.Second)
+	var skews []time.Duration
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt_test"},
+		Dependencies{
+			HTTPClient: server.Client(),
+			Now:        func() time.Time { return local },
+			ClockSkew:  func(d time.Duration) { skews = append(skews, d) },
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.sendHeartbeat(context.Background())
+
+	if len(skews) != 1 || skews[0] != 9500*time.Millisecond {
+		t.Fatalf("expected 9.5s skew estimate, got %v", skews)
+	}
+}
+
+func TestHeartbeatReportsUpdatedLabels(t *testing.T) {
+	var got []map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload heartbeatPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Fatalf("decode heartbeat: %v", err)
+		}
+		got = append(got, payload.Labels)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	current := map[string]string{"site": "atl"}
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt_test", Labels: map[string]string{"site": "old"}},
+		Dependencies{
+			HTTPClient: server.Client(),
+			Labels:     func() map[string]string { return current },
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.sendHeartbeat(context.Background())
+	current = map[string]string{"site": "atl", "env": "prod"}
+	client.sendHeartbeat(context.Background())
+
+	if len(got) != 2 || got[0]["site"] != "atl" || got[1]["env"] != "prod" {
+		t.Fatalf("unexpected heartbeat labels: %v", got)
+	}
+	if labels := client.Labels(); labels["env"] != "prod" {
+		t.Fatalf("expected client labels to follow source, got %v", labels)
+	}
+}
+
+func TestSetHeartbeatIntervalRetimesRunningLoop(t *testing.T) {
+	beats := make(chan struct{}, 16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		beats <- struct{}{}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(Config{ServerURL: server.URL, AgentID: "agt_test"}, Dependencies{HTTPClient: server.Client()})
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.RunHeartbeat(ctx, time.Hour)
+
+	<-beats // immediate first heartbeat
+	client.SetHeartbeatInterval(10 * time.Millisecond)
+	select {
+	case <-beats:
+	case <-time.After(time.Second):
+		t.Fatalf("heartbeat interval change was not applied")
+	}
+}
+
 func TestFetchMonitorsReturnsSnapshot(t *testing.T) {
 	snapshot := types.MonitorSnapshot{
 		Revision:    "rev-1",
@@ -272,3 +432,35 @@ func TestFetchMonitorsHandlesNotModified(t *testing.T) {
 		t.Fatalf("expected two fetch calls, got %d", calls)
 	}
 }
+
+func TestFetchMonitorsWaitRequestsLongPoll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultMonitorPath {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		if wait := r.URL.Query().Get("wait"); wait != "30s" {
+			t.Fatalf("expected wait=30s, got %q", wait)
+		}
+		if r.Header.Get("If-None-Match") != "rev-1" {
+			t.Fatalf("expected if-none-match rev-1, got %q", r.Header.Get("If-None-Match"))
+		}
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt-test"},
+		Dependencies{HTTPClient: server.Client()},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	result, err := client.FetchMonitorsWait(context.Background(), "rev-1", 30*time.Second)
+	if err != nil {
+		t.Fatalf("FetchMonitorsWait: %v", err)
+	}
+	if !result.NotModified || result.ETag != "rev-1" {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+}
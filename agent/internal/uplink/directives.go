@@ -0,0 +1,82 @@
+package uplink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+const defaultDirectivePath = "/api/agent/v1/directives"
+
+// ErrDirectiveUnsupported may be returned by a DirectiveHandler for directive types the agent cannot act on.
+var ErrDirectiveUnsupported = errors.New("directive not supported")
+
+// DirectiveHandler acts on a directive received in a heartbeat response. A nil error
+// acknowledges the directive as done.
+type DirectiveHandler func(ctx context.Context, directive types.Directive) error
+
+type heartbeatResponse struct {
+	Directives []types.Directive `json:"directives"`
+}
+
+// SetCertExpiry records the client certificate expiry reported in subsequent heartbeats.
+func (c *Client) SetCertExpiry(expiry time.Time) {
+	expiry = expiry.UTC()
+	c.certExpiry.Store(&expiry)
+}
+
+func (c *Client) handleDirectives(ctx context.Context, directives []types.Directive) {
+	for _, directive := range directives {
+		if directive.ID == "" {
+			continue
+		}
+		ack := types.DirectiveAck{Status: types.DirectiveDone}
+		err := ErrDirectiveUnsupported
+		if c.directives != nil {
+			err = c.directives(ctx, directive)
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrDirectiveUnsupported):
+			ack = types.DirectiveAck{Status: types.DirectiveUnsupported, Message: err.Error()}
+		default:
+			ack = types.DirectiveAck{Status: types.DirectiveFailed, Message: err.Error()}
+		}
+		if err := c.ackDirective(ctx, directive.ID, ack); err != nil {
+			c.logger.Printf("directive %s (%s) ack failed: %v", directive.ID, directive.Type, err)
+		}
+	}
+}
+
+func (c *Client) ackDirective(ctx context.Context, id string, ack types.DirectiveAck) error {
+	data, err := json.Marshal(ack)
+	if err != nil {
+		return fmt.Errorf("marshal directive ack: %w", err)
+	}
+	ackURL := c.directiveURL + "/" + url.PathEscape(id) + "/ack"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ackURL, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("build directive ack: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pingsanto-agent/0.0.1")
+
+	resp, err := c.do(c.httpClient, endpointDirectiveAck, req)
+	if err != nil {
+		return fmt.Errorf("send directive ack: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("directive ack failed: status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package uplink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+func TestHeartbeatDeliversDirectivesAndAcks(t *testing.T) {
+	expiry := time.Date(2026, 12, 1, 0, 0, 0, 0, time.UTC)
+	acks := make(chan types.DirectiveAck, 2)
+	var reportedExpiry *time.Time
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case defaultHeartbeatPath:
+			var payload heartbeatPayload
+			if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+				t.Errorf("decode heartbeat: %v", err)
+			}
+			reportedExpiry = payload.CertExpiresAt
+			_ = json.NewEncoder(w).Encode(heartbeatResponse{Directives: []types.Directive{
+				{ID: "d1", Type: types.DirectiveRenewCertificate},
+				{ID: "d2", Type: "reboot"},
+			}})
+		case defaultDirectivePath + "/d1/ack", defaultDirectivePath + "/d2/ack":
+			var ack types.DirectiveAck
+			if err := json.NewDecoder(r.Body).Decode(&ack); err != nil {
+				t.Errorf("decode ack: %v", err)
+			}
+			acks <- ack
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	var handled []string
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt_test"},
+		Dependencies{
+			HTTPClient: server.Client(),
+			Directives: func(ctx context.Context, d types.Directive) error {
+				handled = append(handled, d.ID)
+				if d.Type == types.DirectiveRenewCertificate {
+					return errors.New("issuer unavailable")
+				}
+				return ErrDirectiveUnsupported
+			},
+		},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	client.SetCertExpiry(expiry)
+
+	if delay := client.sendHeartbeat(context.Background()); delay != 0 {
+		t.Fatalf("unexpected delay %s", delay)
+	}
+	if reportedExpiry == nil || !reportedExpiry.Equal(expiry) {
+		t.Fatalf("expected cert expiry %s in heartbeat, got %v", expiry, reportedExpiry)
+	}
+	if len(handled) != 2 {
+		t.Fatalf("expected both directives handled, got %v", handled)
+	}
+	if ack := <-acks; ack.Status != types.DirectiveFailed || ack.Message != "issuer unavailable" {
+		t.Fatalf("unexpected ack for d1: %+v", ack)
+	}
+	if ack := <-acks; ack.Status != types.DirectiveUnsupported {
+		t.Fatalf("unexpected ack for d2: %+v", ack)
+	}
+}
@@ -0,0 +1,95 @@
+package uplink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pingsantohq/agent/internal/throttle"
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+const monitorStreamSuffix = "/stream"
+
+// ErrStreamUnsupported is returned when the central service does not expose the monitor stream.
+var ErrStreamUnsupported = errors.New("monitor stream not supported by server")
+
+// MonitorStreamEvent is a single message received on the monitor stream. Keepalive
+// events carry no snapshot and only confirm the connection is still healthy.
+type MonitorStreamEvent struct {
+	Snapshot  types.MonitorSnapshot
+	Keepalive bool
+}
+
+// StreamMonitors opens a push channel to the central service and invokes handle for
+// every snapshot or keepalive received. The stream is newline-delimited JSON; blank
+// lines are keepalives. since carries the last applied revision so the server can
+// resume with an incremental delta. StreamMonitors returns when the context is
+// cancelled, the server closes the stream, or handle returns an error.
+func (c *Client) StreamMonitors(ctx context.Context, since string, handle func(MonitorStreamEvent) error) error {
+	streamURL := c.monitorURL + monitorStreamSuffix
+	if since != "" {
+		streamURL += "?since=" + url.QueryEscape(since)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, streamURL, nil)
+	if err != nil {
+		return fmt.Errorf("build monitor stream request: %w", err)
+	}
+	req.Header.Set("Accept", "application/x-ndjson")
+	req.Header.Set("User-Agent", "pingsanto-agent/0.0.1")
+
+	resp, err := c.do(c.streamClient, endpointStream, req)
+	if err != nil {
+		return fmt.Errorf("open monitor stream: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if err := throttle.FromResponse(resp, c.now()); err != nil {
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("open monitor stream: %w", err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented:
+		io.Copy(io.Discard, resp.Body)
+		return ErrStreamUnsupported
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		io.Copy(io.Discard, resp.Body)
+		return fmt.Errorf("monitor stream failed: status %s", resp.Status)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) == 0 {
+			if err == nil {
+				if herr := handle(MonitorStreamEvent{Keepalive: true}); herr != nil {
+					return herr
+				}
+				continue
+			}
+		} else {
+			var snapshot types.MonitorSnapshot
+			if uerr := json.Unmarshal(line, &snapshot); uerr != nil {
+				return fmt.Errorf("decode monitor stream event: %w", uerr)
+			}
+			if herr := handle(MonitorStreamEvent{Snapshot: snapshot}); herr != nil {
+				return herr
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if errors.Is(err, io.EOF) {
+				return fmt.Errorf("monitor stream closed by server")
+			}
+			return fmt.Errorf("read monitor stream: %w", err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package uplink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/agent/pkg/types"
+)
+
+func TestStreamMonitorsDeliversSnapshotsAndKeepalives(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != defaultMonitorPath+monitorStreamSuffix {
+			t.Fatalf("unexpected path %s", r.URL.Path)
+		}
+		if since := r.URL.Query().Get("since"); since != "rev-1" {
+			t.Fatalf("expected since=rev-1, got %q", since)
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(w)
+		w.Write([]byte("\n"))
+		enc.Encode(types.MonitorSnapshot{
+			Revision:    "rev-2",
+			GeneratedAt: time.Unix(123, 0).UTC(),
+			Incremental: true,
+			Removed:     []string{"mon-old"},
+			Monitors:    []types.MonitorAssignment{{MonitorID: "mon-new", Protocol: "icmp"}},
+		})
+	}))
+	defer server.Close()
+
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt-test"},
+		Dependencies{HTTPClient: server.Client()},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	var events []MonitorStreamEvent
+	err = client.StreamMonitors(context.Background(), "rev-1", func(ev MonitorStreamEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err == nil {
+		t.Fatalf("expected error when server closes the stream")
+	}
+	if len(events) != 2 || !events[0].Keepalive {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	snap := events[1].Snapshot
+	if snap.Revision != "rev-2" || !snap.Incremental || len(snap.Removed) != 1 || len(snap.Monitors) != 1 {
+		t.Fatalf("unexpected snapshot: %+v", snap)
+	}
+}
+
+func TestStreamMonitorsReportsUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	client, err := NewClient(
+		Config{ServerURL: server.URL, AgentID: "agt-test"},
+		Dependencies{HTTPClient: server.Client()},
+	)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+
+	err = client.StreamMonitors(context.Background(), "", func(MonitorStreamEvent) error { return nil })
+	if !errors.Is(err, ErrStreamUnsupported) {
+		t.Fatalf("expected ErrStreamUnsupported, got %v", err)
+	}
+}
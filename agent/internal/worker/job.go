@@ -10,4 +10,7 @@ type Job struct {
 	Timeout       time.Duration
 	ScheduledFor  time.Time
 	Configuration string
+	// SharedWith lists further monitors with an identical probe definition.
+	// The job is probed once and each result is copied to these monitor IDs.
+	SharedWith []string
 }
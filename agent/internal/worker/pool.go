@@ -4,7 +4,10 @@ import (
 	"context"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/pingsantohq/agent/internal/metrics"
 	"github.com/pingsantohq/agent/internal/probe"
 	"github.com/pingsantohq/agent/internal/queue"
 	"github.com/pingsantohq/agent/pkg/types"
@@ -15,12 +18,28 @@ type ResultSink interface {
 }
 
 type Pool struct {
-	jobs        <-chan Job
-	results     ResultSink
-	workerCount int
-	batcher     func(context.Context, []probe.Request) ([]types.ProbeResult, error)
+	jobs         <-chan Job
+	results      ResultSink
+	workerCount  int
+	rampInterval time.Duration
+	batcher      func(context.Context, []probe.Request) ([]types.ProbeResult, error)
+	probes       metrics.ProbeRecorder
+
+	// inFlight counts jobs being probed; lastProgress is the UnixNano at which
+	// a worker last picked up or finished a job.
+	inFlight     atomic.Int64
+	lastProgress atomic.Int64
+
+	mu    sync.Mutex
+	ctx   context.Context
+	wg    *sync.WaitGroup
+	stops []chan struct{}
 }
 
+// defaultRampInterval staggers workers added at runtime so a scale-up does not
+// fire a burst of probes at once.
+const defaultRampInterval = 50 * time.Millisecond
+
 type PoolOption func(*Pool)
 
 func WithWorkerCount(n int) PoolOption {
@@ -39,12 +58,32 @@ func WithBatcher(fn func(context.Context, []probe.Request) ([]types.ProbeResult,
 	}
 }
 
+// WithRampInterval sets the delay between workers started by Resize.
+func WithRampInterval(d time.Duration) PoolOption {
+	return func(p *Pool) {
+		if d >= 0 {
+			p.rampInterval = d
+		}
+	}
+}
+
+// WithProbeRecorder records every probe result for per-monitor metrics.
+func WithProbeRecorder(rec metrics.ProbeRecorder) PoolOption {
+	return func(p *Pool) {
+		if rec != nil {
+			p.probes = rec
+		}
+	}
+}
+
 func NewPool(jobs <-chan Job, results ResultSink, opts ...PoolOption) *Pool {
 	p := &Pool{
-		jobs:        jobs,
-		results:     results,
-		workerCount: runtime.NumCPU(),
-		batcher:     probe.Batch,
+		jobs:         jobs,
+		results:      results,
+		workerCount:  runtime.NumCPU(),
+		rampInterval: defaultRampInterval,
+		batcher:      probe.Batch,
+		probes:       metrics.NoopProbeRecorder{},
 	}
 	for _, opt := range opts {
 		opt(p)
@@ -59,22 +98,79 @@ func NewPool(jobs <-chan Job, results ResultSink, opts ...PoolOption) *Pool {
 }
 
 func (p *Pool) Start(ctx context.Context) *sync.WaitGroup {
-	var wg sync.WaitGroup
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ctx = ctx
+	p.wg = &sync.WaitGroup{}
+	p.lastProgress.Store(time.Now().UnixNano())
 	for i := 0; i < p.workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			p.runWorker(ctx)
-		}()
+		p.spawnLocked(0)
 	}
-	return &wg
+	return p.wg
 }
 
-func (p *Pool) runWorker(ctx context.Context) {
+// Size reports the number of workers the pool is currently running or ramping up.
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.wg == nil {
+		return p.workerCount
+	}
+	return len(p.stops)
+}
+
+// Resize changes the worker count at runtime. New workers start one
+// rampInterval apart; removed workers finish the probe they are running and
+// exit before taking another job, so in-flight results are never dropped.
+func (p *Pool) Resize(n int) {
+	if n <= 0 {
+		n = 1
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.workerCount = n
+	if p.wg == nil || p.ctx.Err() != nil {
+		return
+	}
+	for i := 0; len(p.stops) < n; i++ {
+		p.spawnLocked(time.Duration(i+1) * p.rampInterval)
+	}
+	for len(p.stops) > n {
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
+	}
+}
+
+func (p *Pool) spawnLocked(delay time.Duration) {
+	stop := make(chan struct{})
+	p.stops = append(p.stops, stop)
+	ctx := p.ctx
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+			select {
+			case <-ctx.Done():
+				return
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+		}
+		p.runWorker(ctx, stop)
+	}()
+}
+
+func (p *Pool) runWorker(ctx context.Context, stop <-chan struct{}) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-stop:
+			return
 		case job, ok := <-p.jobs:
 			if !ok {
 				return
@@ -84,7 +180,25 @@ func (p *Pool) runWorker(ctx context.Context) {
 	}
 }
 
+// Progress reports how many jobs are queued or being probed and when a
+// worker last picked up or finished one. Pending work with no recent progress
+// means the workers are wedged.
+func (p *Pool) Progress() (pending int, last time.Time) {
+	pending = int(p.inFlight.Load()) + len(p.jobs)
+	if ns := p.lastProgress.Load(); ns != 0 {
+		last = time.Unix(0, ns)
+	}
+	return pending, last
+}
+
 func (p *Pool) handleJob(ctx context.Context, job Job) {
+	p.inFlight.Add(1)
+	p.lastProgress.Store(time.Now().UnixNano())
+	defer func() {
+		p.lastProgress.Store(time.Now().UnixNano())
+		p.inFlight.Add(-1)
+	}()
+
 	req := probe.Request{
 		MonitorID: job.MonitorID,
 		Protocol:  job.Protocol,
@@ -98,6 +212,16 @@ func (p *Pool) handleJob(ctx context.Context, job Job) {
 	}
 
 	for _, res := range results {
-		p.results.Enqueue(res)
+		p.emit(res)
+		for _, monitorID := range job.SharedWith {
+			shared := res
+			shared.MonitorID = monitorID
+			p.emit(shared)
+		}
 	}
 }
+
+func (p *Pool) emit(res types.ProbeResult) {
+	p.probes.ObserveProbe(res.MonitorID, res.Proto, res.Success, time.Duration(res.RTTMilliseconds*float64(time.Millisecond)))
+	p.results.Enqueue(res)
+}
@@ -59,3 +59,124 @@ func TestPoolProcessesJob(t *testing.T) {
 		t.Fatalf("unexpected monitor id %s", results[0].MonitorID)
 	}
 }
+
+func TestPoolResizeDrainsInFlightProbes(t *testing.T) {
+	jobs := make(chan Job)
+	resultQueue := queue.NewResultQueue(100)
+	release := make(chan struct{})
+	var active atomic.Int32
+
+	batcher := func(ctx context.Context, reqs []probe.Request) ([]types.ProbeResult, error) {
+		active.Add(1)
+		<-release
+		active.Add(-1)
+		return []types.ProbeResult{{MonitorID: reqs[0].MonitorID}}, nil
+	}
+
+	p := NewPool(jobs, resultQueue, WithWorkerCount(1), WithBatcher(batcher), WithRampInterval(time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg := p.Start(ctx)
+
+	p.Resize(3)
+	if p.Size() != 3 {
+		t.Fatalf("expected 3 workers, got %d", p.Size())
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case jobs <- Job{MonitorID: "mon"}:
+		case <-time.After(time.Second):
+			t.Fatalf("scaled-up workers did not accept job %d", i)
+		}
+	}
+	waitFor := time.After(time.Second)
+	for active.Load() < 3 {
+		select {
+		case <-waitFor:
+			t.Fatalf("expected 3 concurrent probes, got %d", active.Load())
+		case <-time.After(time.Millisecond):
+		}
+	}
+
+	// Shrinking while all three probes are in flight must let them finish.
+	p.Resize(1)
+	close(release)
+	deadline := time.After(time.Second)
+	for resultQueue.Len() < 3 {
+		select {
+		case <-deadline:
+			t.Fatalf("in-flight probes dropped on scale-down; got %d results", resultQueue.Len())
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+	if p.Size() != 1 {
+		t.Fatalf("expected 1 worker after scale-down, got %d", p.Size())
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestPoolFansOutSharedJobResults(t *testing.T) {
+	resultQueue := queue.NewResultQueue(10)
+	probes := atomic.Int32{}
+	batcher := func(ctx context.Context, reqs []probe.Request) ([]types.ProbeResult, error) {
+		probes.Add(int32(len(reqs)))
+		return []types.ProbeResult{{MonitorID: reqs[0].MonitorID, Proto: reqs[0].Protocol, RTTMilliseconds: 12, Success: true}}, nil
+	}
+
+	p := NewPool(make(chan Job), resultQueue, WithBatcher(batcher))
+	p.handleJob(context.Background(), Job{MonitorID: "mon1", Protocol: "icmp", SharedWith: []string{"mon2", "mon3"}})
+
+	if probes.Load() != 1 {
+		t.Fatalf("expected a single probe, got %d", probes.Load())
+	}
+	results := resultQueue.Drain(0)
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results got %d", len(results))
+	}
+	for i, want := range []string{"mon1", "mon2", "mon3"} {
+		if results[i].MonitorID != want || results[i].RTTMilliseconds != 12 {
+			t.Fatalf("unexpected result %d: %+v", i, results[i])
+		}
+	}
+}
+
+func TestPoolProgressTracksInFlightJobs(t *testing.T) {
+	jobs := make(chan Job, 1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	batcher := func(ctx context.Context, reqs []probe.Request) ([]types.ProbeResult, error) {
+		close(started)
+		<-release
+		return nil, nil
+	}
+
+	p := NewPool(jobs, queue.NewResultQueue(10), WithWorkerCount(1), WithBatcher(batcher))
+	if pending, last := p.Progress(); pending != 0 || !last.IsZero() {
+		t.Fatalf("expected no progress before start, got %d %v", pending, last)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := p.Start(ctx)
+	startedAt := time.Now()
+
+	jobs <- Job{MonitorID: "mon1", Protocol: "icmp"}
+	<-started
+	if pending, last := p.Progress(); pending != 1 || last.Before(startedAt) {
+		t.Fatalf("expected one in-flight job, got %d %v", pending, last)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		if pending, _ := p.Progress(); pending == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for job to finish")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	wg.Wait()
+}
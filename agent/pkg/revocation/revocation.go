@@ -0,0 +1,339 @@
+// Package revocation checks X.509 certificates against OCSP responders and
+// CRLs. The agent uses it for controller certificates and the controller for
+// agent client certificates.
+package revocation
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Mode selects how a Checker treats certificates.
+type Mode string
+
+const (
+	// Off skips revocation checks.
+	Off Mode = "off"
+	// Soft rejects certificates reported revoked but accepts those
+	// whose status cannot be determined, e.g. while the OCSP responder is down.
+	Soft Mode = "soft"
+	// Hard also rejects certificates whose status cannot be
+	// determined.
+	Hard Mode = "hard"
+)
+
+const (
+	defaultTimeout  = 5 * time.Second
+	defaultCacheTTL = 10 * time.Minute
+	maxResponse     = 10 << 20
+)
+
+// ErrRevoked is returned for certificates their issuer revoked.
+var ErrRevoked = errors.New("certificate has been revoked")
+
+var errOtherIssuer = errors.New("CRL is from another issuer")
+
+// ParseMode validates a configured mode; empty means off.
+func ParseMode(s string) (Mode, error) {
+	switch mode := Mode(strings.ToLower(strings.TrimSpace(s))); mode {
+	case "":
+		return Off, nil
+	case Off, Soft, Hard:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid revocation mode %q: want off, soft or hard", s)
+}
+
+// Checker determines whether certificates have been revoked. It
+// consults, in order, a stapled OCSP response, CRLFiles, the certificate's
+// OCSP responders and its CRL distribution points; the first definitive
+// answer wins and is cached.
+type Checker struct {
+	Mode Mode
+	// CRLFiles are local CRLs (PEM or DER), e.g. for a CA that publishes no
+	// reachable distribution point. CRLs from other issuers are ignored.
+	CRLFiles []string
+	// HTTPClient fetches OCSP responses and CRLs. When nil, a client with
+	// Timeout (default 5s) dialling through Proxy is used.
+	HTTPClient *http.Client
+	Proxy      func(*http.Request) (*url.URL, error)
+	Timeout    time.Duration
+	// CacheTTL bounds how long a status is reused (default 10m); a response
+	// whose next update is sooner expires then.
+	CacheTTL time.Duration
+	// Logger records soft-failed checks (default: discarded).
+	Logger *log.Logger
+	Now    func() time.Time
+
+	mu     sync.Mutex
+	client *http.Client
+	cache  map[string]certStatus
+}
+
+type certStatus struct {
+	revoked   bool
+	revokedAt time.Time
+	source    string
+	expires   time.Time
+}
+
+// Enabled reports whether c checks anything.
+func (c *Checker) Enabled() bool {
+	return c != nil && c.Mode != "" && c.Mode != Off
+}
+
+// Check returns an error if cert, issued by issuer, has been revoked or, in
+// hard mode, if its status cannot be determined. stapled is an optional OCSP
+// response from the TLS handshake.
+func (c *Checker) Check(cert, issuer *x509.Certificate, stapled []byte) error {
+	if !c.Enabled() {
+		return nil
+	}
+	status, err := c.status(cert, issuer, stapled)
+	if err != nil {
+		return c.undetermined(cert, err)
+	}
+	if status.revoked {
+		return fmt.Errorf("%w: %s (revoked %s according to %s)", ErrRevoked, describeCert(cert), status.revokedAt.UTC().Format(time.RFC3339), status.source)
+	}
+	return nil
+}
+
+// VerifyConnection checks every certificate of the verified chain below the
+// trust anchor; it has the signature of tls.Config.VerifyConnection.
+func (c *Checker) VerifyConnection(cs tls.ConnectionState) error {
+	if !c.Enabled() {
+		return nil
+	}
+	if len(cs.VerifiedChains) == 0 {
+		if len(cs.PeerCertificates) == 0 {
+			return c.undetermined(nil, errors.New("no peer certificate"))
+		}
+		return c.undetermined(cs.PeerCertificates[0], errors.New("certificate chain was not verified"))
+	}
+	chain := cs.VerifiedChains[0]
+	for i := 0; i+1 < len(chain); i++ {
+		var stapled []byte
+		if i == 0 {
+			stapled = cs.OCSPResponse
+		}
+		if err := c.Check(chain[i], chain[i+1], stapled); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Checker) undetermined(cert *x509.Certificate, err error) error {
+	subject := "peer certificate"
+	if cert != nil {
+		subject = describeCert(cert)
+	}
+	if c.Mode == Soft {
+		c.logger().Printf("revocation: status of %s unknown, accepting (soft-fail): %v", subject, err)
+		return nil
+	}
+	return fmt.Errorf("revocation status of %s unknown: %w", subject, err)
+}
+
+func (c *Checker) status(cert, issuer *x509.Certificate, stapled []byte) (certStatus, error) {
+	now := c.now()
+	issuerKey := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+	key := fmt.Sprintf("%x/%s", issuerKey, cert.SerialNumber)
+	c.mu.Lock()
+	cached, ok := c.cache[key]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached, nil
+	}
+
+	var errs []error
+	remember := func(status certStatus) (certStatus, error) {
+		if limit := now.Add(c.cacheTTL()); status.expires.IsZero() || status.expires.After(limit) {
+			status.expires = limit
+		}
+		c.mu.Lock()
+		if c.cache == nil {
+			c.cache = make(map[string]certStatus)
+		}
+		c.cache[key] = status
+		c.mu.Unlock()
+		return status, nil
+	}
+	if len(stapled) > 0 {
+		status, err := ocspStatus(stapled, cert, issuer, now)
+		if err == nil {
+			status.source = "stapled OCSP response"
+			return remember(status)
+		}
+		errs = append(errs, fmt.Errorf("stapled OCSP response: %w", err))
+	}
+	for _, path := range c.CRLFiles {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			var status certStatus
+			status, err = crlStatus(data, cert, issuer, now)
+			if err == nil {
+				status.source = path
+				return remember(status)
+			}
+		}
+		if !errors.Is(err, errOtherIssuer) {
+			errs = append(errs, fmt.Errorf("CRL %s: %w", path, err))
+		}
+	}
+	for _, server := range cert.OCSPServer {
+		status, err := c.fetchOCSP(server, cert, issuer, now)
+		if err == nil {
+			status.source = server
+			return remember(status)
+		}
+		errs = append(errs, fmt.Errorf("OCSP %s: %w", server, err))
+	}
+	for _, point := range cert.CRLDistributionPoints {
+		data, err := c.fetch(http.MethodGet, point, "", nil)
+		if err == nil {
+			var status certStatus
+			status, err = crlStatus(data, cert, issuer, now)
+			if err == nil {
+				status.source = point
+				return remember(status)
+			}
+		}
+		errs = append(errs, fmt.Errorf("CRL %s: %w", point, err))
+	}
+	if len(errs) == 0 {
+		return certStatus{}, errors.New("no OCSP responder, CRL distribution point or matching CRL file")
+	}
+	return certStatus{}, errors.Join(errs...)
+}
+
+func (c *Checker) fetchOCSP(server string, cert, issuer *x509.Certificate, now time.Time) (certStatus, error) {
+	req, err := ocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		return certStatus{}, fmt.Errorf("build request: %w", err)
+	}
+	data, err := c.fetch(http.MethodPost, server, "application/ocsp-request", req)
+	if err != nil {
+		return certStatus{}, err
+	}
+	return ocspStatus(data, cert, issuer, now)
+}
+
+func (c *Checker) fetch(method, target, contentType string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponse))
+}
+
+func ocspStatus(data []byte, cert, issuer *x509.Certificate, now time.Time) (certStatus, error) {
+	resp, err := ocsp.ParseResponseForCert(data, cert, issuer)
+	if err != nil {
+		return certStatus{}, err
+	}
+	if !resp.NextUpdate.IsZero() && now.After(resp.NextUpdate) {
+		return certStatus{}, fmt.Errorf("response expired at %s", resp.NextUpdate.UTC().Format(time.RFC3339))
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		return certStatus{expires: resp.NextUpdate}, nil
+	case ocsp.Revoked:
+		return certStatus{revoked: true, revokedAt: resp.RevokedAt, expires: resp.NextUpdate}, nil
+	default:
+		return certStatus{}, errors.New("responder does not know the certificate")
+	}
+}
+
+func crlStatus(data []byte, cert, issuer *x509.Certificate, now time.Time) (certStatus, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return certStatus{}, fmt.Errorf("parse: %w", err)
+	}
+	if !bytes.Equal(crl.RawIssuer, issuer.RawSubject) {
+		return certStatus{}, errOtherIssuer
+	}
+	if err := crl.CheckSignatureFrom(issuer); err != nil {
+		return certStatus{}, fmt.Errorf("verify signature: %w", err)
+	}
+	if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+		return certStatus{}, fmt.Errorf("CRL expired at %s", crl.NextUpdate.UTC().Format(time.RFC3339))
+	}
+	for _, entry := range crl.RevokedCertificateEntries {
+		if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return certStatus{revoked: true, revokedAt: entry.RevocationTime, expires: crl.NextUpdate}, nil
+		}
+	}
+	return certStatus{expires: crl.NextUpdate}, nil
+}
+
+func describeCert(cert *x509.Certificate) string {
+	return fmt.Sprintf("certificate %q (serial %x)", cert.Subject.CommonName, cert.SerialNumber)
+}
+
+func (c *Checker) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.client == nil {
+		timeout := c.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		c.client = &http.Client{Timeout: timeout, Transport: &http.Transport{Proxy: c.Proxy}}
+	}
+	return c.client
+}
+
+func (c *Checker) cacheTTL() time.Duration {
+	if c.CacheTTL > 0 {
+		return c.CacheTTL
+	}
+	return defaultCacheTTL
+}
+
+func (c *Checker) logger() *log.Logger {
+	if c.Logger != nil {
+		return c.Logger
+	}
+	return log.New(io.Discard, "", 0)
+}
+
+func (c *Checker) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,186 @@
+package revocation
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestCheckerOCSP(t *testing.T) {
+	ca, caKey := mustCreateCA(t)
+
+	var requests atomic.Int32
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		tmpl := ocsp.Response{
+			SerialNumber: req.SerialNumber,
+			Status:       ocsp.Good,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}
+		if req.SerialNumber.Int64() == 20 {
+			tmpl.Status = ocsp.Revoked
+			tmpl.RevokedAt = time.Now().Add(-time.Hour)
+		}
+		resp, err := ocsp.CreateResponse(ca, ca, tmpl, caKey)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(resp)
+	}))
+	defer responder.Close()
+
+	good := mustIssueLeaf(t, ca, caKey, 10, func(c *x509.Certificate) { c.OCSPServer = []string{responder.URL} })
+	revoked := mustIssueLeaf(t, ca, caKey, 20, func(c *x509.Certificate) { c.OCSPServer = []string{responder.URL} })
+
+	checker := &Checker{Mode: Hard}
+	if err := checker.Check(good, ca, nil); err != nil {
+		t.Fatalf("expected good certificate to pass, got %v", err)
+	}
+	if err := checker.Check(good, ca, nil); err != nil || requests.Load() != 1 {
+		t.Fatalf("expected cached status, got %v after %d requests", err, requests.Load())
+	}
+	if err := checker.Check(revoked, ca, nil); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected revoked certificate to fail, got %v", err)
+	}
+	soft := &Checker{Mode: Soft}
+	if err := soft.Check(revoked, ca, nil); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected soft mode to reject revoked certificates, got %v", err)
+	}
+}
+
+func TestCheckerCRLFile(t *testing.T) {
+	ca, caKey := mustCreateCA(t)
+	other, otherKey := mustCreateCA(t)
+
+	dir := t.TempDir()
+	crlPath := filepath.Join(dir, "ca.crl")
+	otherPath := filepath.Join(dir, "other.crl")
+	writeCRL(t, crlPath, ca, caKey, 30)
+	writeCRL(t, otherPath, other, otherKey, 31)
+
+	checker := &Checker{Mode: Hard, CRLFiles: []string{otherPath, crlPath}}
+	if err := checker.Check(mustIssueLeaf(t, ca, caKey, 31, nil), ca, nil); err != nil {
+		t.Fatalf("expected certificate absent from its issuer's CRL to pass, got %v", err)
+	}
+	if err := checker.Check(mustIssueLeaf(t, ca, caKey, 30, nil), ca, nil); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected certificate listed in CRL to fail, got %v", err)
+	}
+}
+
+func TestCheckerSoftAndHardFail(t *testing.T) {
+	ca, caKey := mustCreateCA(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	leaf := mustIssueLeaf(t, ca, caKey, 40, func(c *x509.Certificate) {
+		c.OCSPServer = []string{down.URL}
+		c.CRLDistributionPoints = []string{down.URL + "/ca.crl"}
+	})
+
+	if err := (&Checker{Mode: Soft}).Check(leaf, ca, nil); err != nil {
+		t.Fatalf("expected soft mode to accept unknown status, got %v", err)
+	}
+	if err := (&Checker{Mode: Hard}).Check(leaf, ca, nil); err == nil || errors.Is(err, ErrRevoked) {
+		t.Fatalf("expected hard mode to reject unknown status, got %v", err)
+	}
+	if err := (&Checker{Mode: Off}).Check(leaf, ca, nil); err != nil {
+		t.Fatalf("expected off mode to skip the check, got %v", err)
+	}
+	if _, err := ParseMode("strict"); err == nil {
+		t.Fatalf("expected unknown mode to be rejected")
+	}
+}
+
+func mustCreateCA(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "PingSanto Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+	return ca, key
+}
+
+func mustIssueLeaf(t *testing.T, ca *x509.Certificate, caKey *rsa.PrivateKey, serial int64, mutate func(*x509.Certificate)) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "controller"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if mutate != nil {
+		mutate(tmpl)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse certificate: %v", err)
+	}
+	return cert
+}
+
+func writeCRL(t *testing.T, path string, ca *x509.Certificate, caKey *rsa.PrivateKey, revoked int64) {
+	t.Helper()
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(revoked), RevocationTime: time.Now().Add(-time.Hour)},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatalf("create CRL: %v", err)
+	}
+	if err := os.WriteFile(path, der, 0o600); err != nil {
+		t.Fatalf("write CRL: %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package types
+
+import "time"
+
+// Directive types issued by the central service.
+const (
+	DirectiveRenewCertificate = "renew_certificate"
+)
+
+// Directive outcome statuses reported back to the central service.
+const (
+	DirectiveDone        = "done"
+	DirectiveFailed      = "failed"
+	DirectiveUnsupported = "unsupported"
+)
+
+// Directive is an instruction from the central service delivered alongside heartbeat responses.
+type Directive struct {
+	ID        string         `json:"id"`
+	Type      string         `json:"type"`
+	Payload   map[string]any `json:"payload,omitempty"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// DirectiveAck reports how the agent handled a directive.
+type DirectiveAck struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
@@ -20,4 +20,17 @@ type MonitorSnapshot struct {
 	Monitors    []MonitorAssignment `json:"monitors" yaml:"monitors"`
 	Incremental bool                `json:"incremental,omitempty" yaml:"incremental,omitempty"`
 	Removed     []string            `json:"removed,omitempty" yaml:"removed,omitempty"`
+	// Config is the fleet-wide config overlay in effect; nil means none.
+	Config *ConfigOverlay `json:"config,omitempty" yaml:"config,omitempty"`
+}
+
+// ConfigOverlay is agent tuning pushed by the controller alongside monitor
+// snapshots. It is applied over the local config; zero fields leave the local
+// value in place.
+type ConfigOverlay struct {
+	HeartbeatSec      int       `json:"heartbeat_sec,omitempty" yaml:"heartbeat_sec,omitempty"`
+	QueueMemItemsCap  int       `json:"queue_mem_items_cap,omitempty" yaml:"queue_mem_items_cap,omitempty"`
+	QueueDiskBytesCap string    `json:"queue_disk_bytes_cap,omitempty" yaml:"queue_disk_bytes_cap,omitempty"`
+	LogLevel          string    `json:"log_level,omitempty" yaml:"log_level,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at,omitempty" yaml:"updated_at,omitempty"`
 }
@@ -12,6 +12,7 @@ func TestMonitorSnapshotJSONContract(t *testing.T) {
         "generated_at": "2025-10-22T20:11:33Z",
         "incremental": true,
         "removed": ["mon-abandoned"],
+        "config": {"heartbeat_sec": 30, "queue_disk_bytes_cap": "1GiB"},
         "monitors": [
             {
                 "monitor_id": "mon-new",
@@ -46,6 +47,9 @@ func TestMonitorSnapshotJSONContract(t *testing.T) {
 	if len(snapshot.Removed) != 1 || snapshot.Removed[0] != "mon-abandoned" {
 		t.Fatalf("unexpected removed list: %+v", snapshot.Removed)
 	}
+	if snapshot.Config == nil || snapshot.Config.HeartbeatSec != 30 || snapshot.Config.QueueDiskBytesCap != "1GiB" {
+		t.Fatalf("unexpected config overlay: %+v", snapshot.Config)
+	}
 	if !snapshot.GeneratedAt.Equal(time.Date(2025, 10, 22, 20, 11, 33, 0, time.UTC)) {
 		t.Fatalf("unexpected generated_at: %s", snapshot.GeneratedAt)
 	}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,17 +14,29 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/pingsantohq/agent/pkg/revocation"
 	"github.com/pingsantohq/controller/internal/artifacts"
+	"github.com/pingsantohq/controller/internal/attest"
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/issuer"
+	"github.com/pingsantohq/controller/internal/notify"
+	"github.com/pingsantohq/controller/internal/results"
 	"github.com/pingsantohq/controller/internal/server"
 	"github.com/pingsantohq/controller/internal/store"
+	"github.com/pingsantohq/controller/internal/webhook"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	logger := log.New(os.Stdout, "controller ", log.LstdFlags|log.Lmicroseconds)
+	// Printf-style logs and request logs share one structured handler, so
+	// every line is JSON (or logfmt with LOG_FORMAT=text).
+	logHandler := newLogHandler(os.Getenv("LOG_FORMAT"))
+	logger := slog.NewLogLogger(logHandler, slog.LevelInfo)
 
 	ctx := context.Background()
 	var (
 		st      store.Store
+		rs      results.Store
 		cleanup func()
 	)
 
@@ -32,6 +47,7 @@ func main() {
 			logger.Fatalf("failed to connect to database: %v", err)
 		}
 		st = pgStore
+		rs = results.NewPostgresStore(pgStore.Pool())
 		cleanup = func() { pgStore.Close() }
 		logger.Println("upgrade API using PostgreSQL store")
 	} else {
@@ -50,6 +66,98 @@ func main() {
 		AdminBearerToken: os.Getenv("ADMIN_BEARER_TOKEN"),
 		PublicBaseURL:    os.Getenv("PUBLIC_BASE_URL"),
 		ArtifactPath:     getenvDefault("ARTIFACT_PATH", "/artifacts"),
+		ReplayProtection: getenvDefault("AGENT_REPLAY_PROTECTION", server.ReplayProtectionOff),
+
+		SPIFFETrustDomain: strings.TrimSpace(os.Getenv("SPIFFE_TRUST_DOMAIN")),
+		SPIFFEAgentPrefix: os.Getenv("SPIFFE_AGENT_PATH_PREFIX"),
+
+		TLSCertFile:        strings.TrimSpace(os.Getenv("TLS_CERT_FILE")),
+		TLSKeyFile:         strings.TrimSpace(os.Getenv("TLS_KEY_FILE")),
+		AgentClientCAFiles: splitList(os.Getenv("AGENT_CLIENT_CA_FILES")),
+		AgentCRLFiles:      splitList(os.Getenv("AGENT_CRL_FILES")),
+
+		GRPCAddr: strings.TrimSpace(os.Getenv("GRPC_LISTEN_ADDR")),
+	}
+	if raw := strings.TrimSpace(os.Getenv("AGENT_MAX_CLOCK_SKEW")); raw != "" {
+		skew, err := time.ParseDuration(raw)
+		if err != nil || skew <= 0 {
+			logger.Fatalf("invalid AGENT_MAX_CLOCK_SKEW %q", raw)
+		}
+		cfg.ReplayMaxSkew = skew
+	}
+	if err := server.ValidateAgentAuthMode(cfg.AgentAuthMode); err != nil {
+		logger.Fatalf("invalid AGENT_AUTH_MODE: %v", err)
+	}
+	if raw := os.Getenv("AGENT_CERT_REVOCATION"); raw != "" {
+		mode, err := revocation.ParseMode(raw)
+		if err != nil {
+			logger.Fatalf("invalid AGENT_CERT_REVOCATION: %v", err)
+		}
+		cfg.AgentCertRevocation = string(mode)
+	}
+	if raw := strings.TrimSpace(os.Getenv("ADMIN_API_KEYS")); raw != "" {
+		keys, err := parseAPIKeys(raw)
+		if err != nil {
+			logger.Fatalf("invalid ADMIN_API_KEYS: %v", err)
+		}
+		cfg.AdminAPIKeys = keys
+	}
+	for _, limit := range []struct {
+		prefix string
+		rate   *float64
+		burst  *int
+	}{
+		{"AGENT", &cfg.AgentRateLimit, &cfg.AgentRateBurst},
+		{"ADMIN", &cfg.AdminRateLimit, &cfg.AdminRateBurst},
+	} {
+		if raw := strings.TrimSpace(os.Getenv(limit.prefix + "_RATE_LIMIT")); raw != "" {
+			rate, err := strconv.ParseFloat(raw, 64)
+			if err != nil || rate < 0 {
+				logger.Fatalf("invalid %s_RATE_LIMIT %q", limit.prefix, raw)
+			}
+			*limit.rate = rate
+		}
+		burst, err := getenvInt(limit.prefix + "_RATE_BURST")
+		if err != nil || burst < 0 {
+			logger.Fatalf("invalid %s_RATE_BURST: %v", limit.prefix, err)
+		}
+		*limit.burst = burst
+	}
+	cfg.Webhooks = webhook.Config{
+		URLs:   splitList(os.Getenv("WEBHOOK_URLS")),
+		Secret: os.Getenv("WEBHOOK_SECRET"),
+		Events: splitList(os.Getenv("WEBHOOK_EVENTS")),
+	}
+	if err := cfg.Webhooks.Validate(); err != nil {
+		logger.Fatalf("invalid webhook configuration: %v", err)
+	}
+	if raw := strings.TrimSpace(os.Getenv("CONTROLLER_STATS_INTERVAL")); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			logger.Fatalf("invalid CONTROLLER_STATS_INTERVAL %q", raw)
+		}
+		cfg.StatsInterval = interval
+	}
+	if raw := strings.TrimSpace(os.Getenv("CONTROLLER_STATS_RETENTION")); raw != "" {
+		retention, err := time.ParseDuration(raw)
+		if err != nil || retention <= 0 {
+			logger.Fatalf("invalid CONTROLLER_STATS_RETENTION %q", raw)
+		}
+		cfg.StatsRetention = retention
+	}
+	if raw := strings.TrimSpace(os.Getenv("PLAN_REQUIRE_IF_MATCH")); raw != "" {
+		require, err := strconv.ParseBool(raw)
+		if err != nil {
+			logger.Fatalf("invalid PLAN_REQUIRE_IF_MATCH %q", raw)
+		}
+		cfg.RequirePlanIfMatch = require
+	}
+	if raw := strings.TrimSpace(os.Getenv("AGENT_STALE_AFTER")); raw != "" {
+		staleAfter, err := time.ParseDuration(raw)
+		if err != nil || staleAfter <= 0 {
+			logger.Fatalf("invalid AGENT_STALE_AFTER %q", raw)
+		}
+		cfg.AgentStaleAfter = staleAfter
 	}
 
 	artifactDir := getenvDefault("ARTIFACTS_DIR", "./artifacts")
@@ -70,23 +178,127 @@ func main() {
 		logger.Fatalf("failed to initialize artifact store: %v", err)
 	}
 
-	srv := server.New(cfg, server.Dependencies{
+	deps := server.Dependencies{
 		Logger:        logger,
+		Log:           slog.New(logHandler),
 		Store:         st,
 		ArtifactStore: artifactStore,
-	})
+		Results:       rs,
+	}
+	if caCert := strings.TrimSpace(os.Getenv("ENROLL_CA_CERT_FILE")); caCert != "" {
+		var validity time.Duration
+		if raw := strings.TrimSpace(os.Getenv("ENROLL_CERT_VALIDITY")); raw != "" {
+			validity, err = time.ParseDuration(raw)
+			if err != nil || validity <= 0 {
+				logger.Fatalf("invalid ENROLL_CERT_VALIDITY %q", raw)
+			}
+		}
+		ca, err := issuer.LoadCA(caCert, os.Getenv("ENROLL_CA_KEY_FILE"), strings.TrimSpace(os.Getenv("ENROLL_TRUST_BUNDLE_FILE")), validity)
+		if err != nil {
+			logger.Fatalf("failed to load enrollment CA: %v", err)
+		}
+		deps.Issuer = ca
+		logger.Println("agent enrollment enabled")
+	}
+	awsCertFiles := splitList(os.Getenv("ATTEST_AWS_CERT_FILES"))
+	gcpAudience := strings.TrimSpace(os.Getenv("ATTEST_GCP_AUDIENCE"))
+	azureAudience := strings.TrimSpace(os.Getenv("ATTEST_AZURE_AUDIENCE"))
+	azureTenants := splitList(os.Getenv("ATTEST_AZURE_TENANTS"))
+	if len(awsCertFiles) > 0 || gcpAudience != "" || azureAudience != "" || len(azureTenants) > 0 {
+		awsCerts, err := attest.LoadAWSCertificates(awsCertFiles)
+		if err != nil {
+			logger.Fatalf("invalid ATTEST_AWS_CERT_FILES: %v", err)
+		}
+		deps.Attestor = &attest.Verifier{
+			AWSCertificates: awsCerts,
+			GCPAudience:     gcpAudience,
+			AzureAudience:   azureAudience,
+			AzureTenants:    azureTenants,
+		}
+		logger.Println("cloud identity attestation enabled")
+	}
+	if oidcIssuer := strings.TrimSpace(os.Getenv("ENROLL_OIDC_ISSUER")); oidcIssuer != "" {
+		audience := strings.TrimSpace(os.Getenv("ENROLL_OIDC_AUDIENCE"))
+		if audience == "" {
+			logger.Fatalf("ENROLL_OIDC_AUDIENCE is required with ENROLL_OIDC_ISSUER")
+		}
+		rules, err := attest.ParseClaimRules(splitList(os.Getenv("ENROLL_OIDC_ALLOW")))
+		if err != nil {
+			logger.Fatalf("invalid ENROLL_OIDC_ALLOW: %v", err)
+		}
+		if len(rules) == 0 {
+			logger.Fatalf("ENROLL_OIDC_ALLOW is required with ENROLL_OIDC_ISSUER")
+		}
+		deps.OIDC = &attest.OIDC{Issuer: oidcIssuer, Audience: audience, Allow: rules}
+		logger.Printf("oidc enrollment enabled for %s", oidcIssuer)
+	}
+
+	var notifiers notify.Multi
+	if url := strings.TrimSpace(os.Getenv("NOTIFY_SLACK_WEBHOOK_URL")); url != "" {
+		notifiers = append(notifiers, notify.Slack{WebhookURL: url, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if addr := strings.TrimSpace(os.Getenv("NOTIFY_SMTP_ADDR")); addr != "" {
+		mail := notify.SMTP{
+			Addr:     addr,
+			From:     strings.TrimSpace(os.Getenv("NOTIFY_SMTP_FROM")),
+			To:       splitList(os.Getenv("NOTIFY_SMTP_TO")),
+			Username: os.Getenv("NOTIFY_SMTP_USERNAME"),
+			Password: os.Getenv("NOTIFY_SMTP_PASSWORD"),
+		}
+		if err := mail.Validate(); err != nil {
+			logger.Fatalf("invalid NOTIFY_SMTP_* configuration: %v", err)
+		}
+		notifiers = append(notifiers, mail)
+	}
+	if len(notifiers) > 0 {
+		deps.Notifier = notifiers
+		logger.Printf("publish notifications enabled (%d destination(s))", len(notifiers))
+	}
+
+	srv := server.New(cfg, deps)
+	tlsConfig, err := server.TLSConfig(cfg)
+	if err != nil {
+		logger.Fatalf("invalid TLS configuration: %v", err)
+	}
+	srv.TLSConfig = tlsConfig
 
 	shutdownCtx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	go srv.RunStats(shutdownCtx)
+	go srv.RunRollouts(shutdownCtx)
+	go srv.RunWebhooks(shutdownCtx)
+
 	serverErr := make(chan error, 1)
 	go func() {
-		logger.Printf("starting controller on %s", srv.Addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Printf("starting controller on %s (tls=%t)", srv.Addr, tlsConfig != nil)
+		var err error
+		if tlsConfig != nil {
+			// Certificates are already loaded into TLSConfig.
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			serverErr <- err
 		}
 	}()
 
+	var grpcServer *grpc.Server
+	if cfg.GRPCAddr != "" {
+		lis, err := net.Listen("tcp", cfg.GRPCAddr)
+		if err != nil {
+			logger.Fatalf("gRPC listen on %s: %v", cfg.GRPCAddr, err)
+		}
+		grpcServer = srv.GRPCServer(tlsConfig)
+		go func() {
+			logger.Printf("starting gRPC agent API on %s (tls=%t)", cfg.GRPCAddr, tlsConfig != nil)
+			if err := grpcServer.Serve(lis); err != nil {
+				serverErr <- err
+			}
+		}()
+	}
+
 	select {
 	case <-shutdownCtx.Done():
 		logger.Println("shutdown signal received")
@@ -96,10 +308,80 @@ func main() {
 
 	ctxTimeout, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	if err := srv.Shutdown(ctxTimeout); err != nil {
+	shutdown(ctxTimeout, logger, srv.Server, grpcServer)
+	logger.Println("controller stopped")
+}
+
+// shutdown drains the HTTP server and, when grpcServer is non-nil, the gRPC
+// server until ctx expires, then cuts off the gRPC streams still open.
+func shutdown(ctx context.Context, logger *log.Logger, srv *http.Server, grpcServer *grpc.Server) {
+	grpcStopped := make(chan struct{})
+	if grpcServer != nil {
+		go func() {
+			grpcServer.GracefulStop()
+			close(grpcStopped)
+		}()
+	}
+	if err := srv.Shutdown(ctx); err != nil {
 		logger.Printf("graceful shutdown failed: %v", err)
 	}
-	logger.Println("controller stopped")
+	if grpcServer == nil {
+		return
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		// Monitor streams never end on their own; cut them off at the
+		// shutdown deadline.
+		grpcServer.Stop()
+	}
+}
+
+// parseAPIKeys reads comma-separated name=key pairs; name:role=key limits a
+// key to an admin role such as viewer.
+func parseAPIKeys(raw string) ([]auth.APIKey, error) {
+	var keys []auth.APIKey
+	for _, entry := range strings.Split(raw, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, key, ok := strings.Cut(entry, "=")
+		name, key = strings.TrimSpace(name), strings.TrimSpace(key)
+		if !ok || name == "" || key == "" {
+			return nil, fmt.Errorf("entry %q must be name=key or name:role=key", entry)
+		}
+		apiKey := auth.APIKey{Name: name, Key: key}
+		if base, role, ok := strings.Cut(name, ":"); ok {
+			scopes, known := auth.RoleScopes(strings.TrimSpace(role))
+			if !known {
+				return nil, fmt.Errorf("entry %q: unknown role %q (want %s)", name, role, strings.Join(auth.AdminRoles, ", "))
+			}
+			apiKey.Name, apiKey.Scopes = strings.TrimSpace(base), scopes
+		}
+		keys = append(keys, apiKey)
+	}
+	return keys, nil
+}
+
+// newLogHandler selects slog's text handler for format "text", JSON otherwise.
+func newLogHandler(format string) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if strings.EqualFold(strings.TrimSpace(format), "text") {
+		return slog.NewTextHandler(os.Stdout, opts)
+	}
+	return slog.NewJSONHandler(os.Stdout, opts)
+}
+
+// splitList reads a comma-separated list, dropping empty entries.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 func getenvDefault(key, def string) string {
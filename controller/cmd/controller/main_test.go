@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownWithoutGRPCServer(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		close(started)
+		<-release
+	})}
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	go srv.Serve(lis)
+	t.Cleanup(func() {
+		close(release)
+		srv.Close()
+	})
+
+	resp, err := http.Get("http://" + lis.Addr().String())
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	<-started
+
+	// The hung stream keeps Shutdown busy until the deadline.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	shutdown(ctx, log.New(io.Discard, "", 0), srv, nil)
+	if ctx.Err() == nil {
+		t.Fatalf("expected shutdown to run until the deadline")
+	}
+}
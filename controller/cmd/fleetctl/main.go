@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/bundle"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		printUsage()
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "lint":
+		err = runLint(os.Args[2:], os.Stdout)
+	case "apply":
+		err = runApply(os.Args[2:], os.Stdout)
+	case "-h", "--help", "help":
+		printUsage()
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", os.Args[1])
+		printUsage()
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func printUsage() {
+	fmt.Println("PingSanto fleet CLI")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  fleetctl lint BUNDLE.yaml [BUNDLE.yaml...]")
+	fmt.Println("  fleetctl apply [--base-url URL] [--token TOKEN] BUNDLE.yaml")
+}
+
+// runLint validates each bundle file and reports every problem found.
+func runLint(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("lint requires at least one bundle file")
+	}
+	failed := 0
+	for _, path := range args {
+		b, err := loadBundle(path)
+		if err != nil {
+			fmt.Fprintf(out, "%s: %v\n", path, err)
+			failed++
+			continue
+		}
+		problems := bundle.Lint(b)
+		for _, p := range problems {
+			fmt.Fprintf(out, "%s: %s\n", path, p)
+		}
+		if len(problems) > 0 {
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "%s: ok (%d monitors, %d agents)\n", path, len(b.Monitors), len(bundle.Snapshots(b)))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d bundle(s) failed lint", failed, len(args))
+	}
+	return nil
+}
+
+func runApply(args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("apply", flag.ContinueOnError)
+	baseURL := fs.String("base-url", os.Getenv("CONTROLLER_BASE_URL"), "Controller base URL")
+	token := fs.String("token", os.Getenv("CONTROLLER_ADMIN_TOKEN"), "Admin bearer token")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("apply requires exactly one bundle file")
+	}
+	if *baseURL == "" || *token == "" {
+		return fmt.Errorf("base-url and token are required (set flags or CONTROLLER_BASE_URL/CONTROLLER_ADMIN_TOKEN)")
+	}
+
+	path := fs.Arg(0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read bundle: %w", err)
+	}
+	// Lint locally first so obvious mistakes never reach the controller.
+	b, err := bundle.Parse(data)
+	if err != nil {
+		return err
+	}
+	if err := bundle.Validate(b); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/admin/v1/monitors/bundles", *baseURL), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("apply request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("controller responded with %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+
+	var payload struct {
+		Agents []struct {
+			AgentID  string `json:"agent_id"`
+			Revision string `json:"revision"`
+		} `json:"agents"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return fmt.Errorf("decode response: %w", err)
+	}
+	for _, agent := range payload.Agents {
+		fmt.Fprintf(out, "%s -> revision %s\n", agent.AgentID, agent.Revision)
+	}
+	fmt.Fprintf(out, "bundle applied to %d agent(s)\n", len(payload.Agents))
+	return nil
+}
+
+func loadBundle(path string) (bundle.Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return bundle.Bundle{}, fmt.Errorf("read bundle: %w", err)
+	}
+	return bundle.Parse(data)
+}
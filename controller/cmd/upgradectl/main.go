@@ -8,8 +8,10 @@ import (
 	"io"
 	"mime/multipart"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -23,15 +25,59 @@ func main() {
 	artifactURL := flag.String("artifact-url", "", "Artifact download URL (required unless --upload-artifact used)")
 	checksum := flag.String("sha256", "", "Artifact SHA-256 checksum (required unless upload sets it)")
 	signatureURL := flag.String("signature-url", "", "Signature URL (optional)")
+	size := flag.Int64("size", 0, "Artifact size in bytes, checked against agent free disk space (set by --upload-artifact)")
 	notes := flag.String("notes", "", "Notes for plan")
 	force := flag.Bool("force", false, "Force apply even if agent paused")
+	ignoreReadiness := flag.Bool("ignore-readiness", false, "Apply even when the agent reports not ready")
 	paused := flag.Bool("paused", false, "Pause auto-upgrades at controller")
 	scheduleEarliest := flag.String("schedule-earliest", "", "Rollout window start (RFC3339 UTC)")
 	scheduleLatest := flag.String("schedule-latest", "", "Rollout window end (RFC3339 UTC)")
+	prefetch := flag.Bool("prefetch", false, "Have agents download and verify the artifact now and install when the window opens")
+	rolloutPercent := flag.Int("rollout-percent", -1, "Share of the channel's agents (0-100) that receive a channel plan (default 100)")
+	ifMatch := flag.String("if-match", "", "Only replace the plan if its current ETag matches (printed after each update)")
+	createOnly := flag.Bool("create-only", false, "Only create the plan; fail if one already exists")
+	setRollout := flag.Int("set-rollout", -1, "Change the rollout percentage of the --channel plan and exit")
 	historyAgent := flag.String("history", "", "Show upgrade history for the specified agent and exit")
 	historyLimit := flag.Int("history-limit", 20, "Number of history entries to fetch with --history")
+	historyCursor := flag.String("history-cursor", "", "Page token from a previous --history run to fetch older entries")
 	uploadArtifact := flag.String("upload-artifact", "", "Path to artifact file to upload before plan update")
 	uploadSignature := flag.String("upload-signature", "", "Optional path to signature file when uploading artifact")
+	var deltas []map[string]any
+	flag.Func("delta", "Delta patch as from=VERSION,url=URL,base_sha256=HEX[,sha256=HEX][,format=bsdiff|zstd] (repeatable)", func(raw string) error {
+		delta, err := parseDelta(raw)
+		if err != nil {
+			return err
+		}
+		deltas = append(deltas, delta)
+		return nil
+	})
+	var mirrors []map[string]any
+	flag.Func("mirror", "Artifact mirror as URL[,SIGNATURE_URL], tried in order after --artifact-url (repeatable)", func(raw string) error {
+		url, signature, _ := strings.Cut(raw, ",")
+		if strings.TrimSpace(url) == "" {
+			return fmt.Errorf("mirror URL required")
+		}
+		mirror := map[string]any{"url": strings.TrimSpace(url)}
+		if signature = strings.TrimSpace(signature); signature != "" {
+			mirror["signature_url"] = signature
+		}
+		mirrors = append(mirrors, mirror)
+		return nil
+	})
+	var platforms []map[string]any
+	flag.Func("platform", "Per-platform artifact as os=GOOS,arch=GOARCH,url=URL,sha256=HEX[,signature_url=URL][,size=BYTES] (repeatable)", func(raw string) error {
+		platform, err := parsePlatform(raw)
+		if err != nil {
+			return err
+		}
+		platforms = append(platforms, platform)
+		return nil
+	})
+	var maintenanceWindows []string
+	flag.Func("maintenance-window", "Recurring window in agent local time, e.g. \"Sat 02:00-04:00\" or \"Mon-Fri 01:00-03:00\" (repeatable)", func(raw string) error {
+		maintenanceWindows = append(maintenanceWindows, raw)
+		return nil
+	})
 	flag.Parse()
 
 	if *baseURL == "" || *token == "" {
@@ -40,13 +86,22 @@ func main() {
 	}
 
 	if *historyAgent != "" {
-		if err := showHistory(*baseURL, *token, *historyAgent, *historyLimit); err != nil {
+		if err := showHistory(*baseURL, *token, *historyAgent, *historyLimit, *historyCursor); err != nil {
 			fmt.Fprintf(os.Stderr, "history fetch failed: %v\n", err)
 			os.Exit(1)
 		}
 		return
 	}
 
+	if *setRollout >= 0 {
+		if err := updateRollout(*baseURL, *token, *channel, *setRollout); err != nil {
+			fmt.Fprintf(os.Stderr, "rollout update failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("channel %s plan now rolls out to %d%% of agents\n", *channel, *setRollout)
+		return
+	}
+
 	if *uploadArtifact != "" {
 		if strings.TrimSpace(*version) == "" {
 			fmt.Fprintln(os.Stderr, "version is required when uploading an artifact")
@@ -66,10 +121,13 @@ func main() {
 		if *signatureURL == "" {
 			*signatureURL = meta.SignatureURL
 		}
+		if *size == 0 {
+			*size = meta.Size
+		}
 	}
 
-	if *version == "" || *artifactURL == "" || *checksum == "" {
-		fmt.Fprintln(os.Stderr, "version, artifact-url, and sha256 are required")
+	if *version == "" || (len(platforms) == 0 && (*artifactURL == "" || *checksum == "")) {
+		fmt.Fprintln(os.Stderr, "version, artifact-url, and sha256 are required (artifact-url and sha256 may be omitted with --platform)")
 		os.Exit(1)
 	}
 
@@ -88,12 +146,36 @@ func main() {
 		"notes":    *notes,
 	}
 
+	if *rolloutPercent >= 0 {
+		payload["rollout_percent"] = *rolloutPercent
+	}
+	if *size > 0 {
+		payload["artifact"].(map[string]any)["size"] = *size
+	}
+	if *ignoreReadiness {
+		payload["artifact"].(map[string]any)["ignore_readiness"] = true
+	}
+	if len(deltas) > 0 {
+		payload["artifact"].(map[string]any)["deltas"] = deltas
+	}
+	if len(mirrors) > 0 {
+		payload["artifact"].(map[string]any)["mirrors"] = mirrors
+	}
+	if len(platforms) > 0 {
+		payload["artifact"].(map[string]any)["platforms"] = platforms
+	}
 	if *scheduleEarliest != "" {
 		payload["schedule"].(map[string]any)["earliest"] = *scheduleEarliest
 	}
 	if *scheduleLatest != "" {
 		payload["schedule"].(map[string]any)["latest"] = *scheduleLatest
 	}
+	if *prefetch {
+		payload["schedule"].(map[string]any)["prefetch"] = true
+	}
+	if len(maintenanceWindows) > 0 {
+		payload["schedule"].(map[string]any)["maintenance_windows"] = maintenanceWindows
+	}
 
 	body, err := json.Marshal(payload)
 	if err != nil {
@@ -108,6 +190,12 @@ func main() {
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+*token)
+	if *ifMatch != "" {
+		req.Header.Set("If-Match", *ifMatch)
+	}
+	if *createOnly {
+		req.Header.Set("If-None-Match", "*")
+	}
 
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Do(req)
@@ -117,16 +205,82 @@ func main() {
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		fmt.Fprintf(os.Stderr, "plan was changed by someone else (current ETag %s); review it and retry with --if-match\n", resp.Header.Get("ETag"))
+		os.Exit(1)
+	}
 	if resp.StatusCode >= 300 {
 		fmt.Fprintf(os.Stderr, "controller responded with %s\n", resp.Status)
 		os.Exit(1)
 	}
 
-	fmt.Println("upgrade plan updated successfully")
+	fmt.Printf("upgrade plan updated successfully (ETag %s)\n", resp.Header.Get("ETag"))
+}
+
+// parseDelta turns a --delta value into the plan's delta object. The format
+// defaults to bsdiff.
+func parseDelta(raw string) (map[string]any, error) {
+	delta := map[string]any{"format": "bsdiff"}
+	for _, field := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid delta field %q", field)
+		}
+		switch key {
+		case "from":
+			delta["from_version"] = value
+		case "url", "sha256", "base_sha256":
+			delta[key] = value
+		case "format":
+			if value != "bsdiff" && value != "zstd" {
+				return nil, fmt.Errorf("unsupported delta format %q", value)
+			}
+			delta[key] = value
+		default:
+			return nil, fmt.Errorf("unknown delta field %q", key)
+		}
+	}
+	for _, key := range []string{"from_version", "url", "base_sha256"} {
+		if delta[key] == nil {
+			return nil, fmt.Errorf("delta %q missing %s", raw, key)
+		}
+	}
+	return delta, nil
+}
+
+func parsePlatform(raw string) (map[string]any, error) {
+	platform := map[string]any{}
+	for _, field := range strings.Split(raw, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok || value == "" {
+			return nil, fmt.Errorf("invalid platform field %q", field)
+		}
+		switch key {
+		case "os", "arch", "url", "sha256", "signature_url":
+			platform[key] = value
+		case "size":
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid platform size %q", value)
+			}
+			platform[key] = n
+		default:
+			return nil, fmt.Errorf("unknown platform field %q", key)
+		}
+	}
+	for _, key := range []string{"os", "arch", "url", "sha256"} {
+		if platform[key] == nil {
+			return nil, fmt.Errorf("platform %q missing %s", raw, key)
+		}
+	}
+	return platform, nil
 }
 
-func showHistory(baseURL, token, agentID string, limit int) error {
+func showHistory(baseURL, token, agentID string, limit int, cursor string) error {
 	url := fmt.Sprintf("%s/api/admin/v1/upgrade/history/%s?limit=%d", baseURL, agentID, limit)
+	if cursor != "" {
+		url += "&cursor=" + neturl.QueryEscape(cursor)
+	}
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
 		return err
@@ -155,6 +309,7 @@ func showHistory(baseURL, token, agentID string, limit int) error {
 			StartedAt       time.Time `json:"started_at"`
 			CompletedAt     time.Time `json:"completed_at"`
 		} `json:"items"`
+		NextCursor string `json:"next_cursor"`
 	}
 
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
@@ -165,6 +320,36 @@ func showHistory(baseURL, token, agentID string, limit int) error {
 	for _, item := range payload.Items {
 		fmt.Printf("[%s] %s -> %s (%s) %s\n", item.CompletedAt.UTC().Format(time.RFC3339), item.PreviousVersion, item.CurrentVersion, item.Status, item.Message)
 	}
+	if payload.NextCursor != "" {
+		fmt.Printf("More entries: --history-cursor %s\n", payload.NextCursor)
+	}
+	return nil
+}
+
+// updateRollout sets the rollout percentage of the channel's plan.
+func updateRollout(baseURL, token, channel string, percent int) error {
+	body, err := json.Marshal(map[string]int{"percent": percent})
+	if err != nil {
+		return err
+	}
+	key := "channel:" + strings.ToLower(strings.TrimSpace(channel))
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/admin/v1/upgrade/plan/%s/rollout", baseURL, key), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("controller responded with %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
 	return nil
 }
 
@@ -172,6 +357,7 @@ type uploadResponse struct {
 	DownloadURL  string
 	SignatureURL string
 	SHA256       string
+	Size         int64
 }
 
 func uploadArtifactFile(baseURL, token, artifactPath, signaturePath, version string) (uploadResponse, error) {
@@ -244,6 +430,7 @@ func uploadArtifactFile(baseURL, token, artifactPath, signaturePath, version str
 			DownloadURL  string `json:"download_url"`
 			SignatureURL string `json:"signature_url"`
 			SHA256       string `json:"sha256"`
+			Size         int64  `json:"size"`
 		} `json:"artifact"`
 	}
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
@@ -253,5 +440,6 @@ func uploadArtifactFile(baseURL, token, artifactPath, signaturePath, version str
 	result.DownloadURL = payload.Artifact.DownloadURL
 	result.SignatureURL = payload.Artifact.SignatureURL
 	result.SHA256 = payload.Artifact.SHA256
+	result.Size = payload.Artifact.Size
 	return result, nil
 }
@@ -25,7 +25,7 @@ func TestUploadArtifactFile(t *testing.T) {
 			t.Fatalf("unexpected auth header: %s", auth)
 		}
 		w.Header().Set("Content-Type", "application/json")
-		io.WriteString(w, `{"artifact":{"download_url":"https://example.com/a","signature_url":"","sha256":"abc"}}`)
+		io.WriteString(w, `{"artifact":{"download_url":"https://example.com/a","signature_url":"","sha256":"abc","size":8}}`)
 	}))
 	defer ts.Close()
 
@@ -33,7 +33,7 @@ func TestUploadArtifactFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("uploadArtifactFile: %v", err)
 	}
-	if meta.DownloadURL != "https://example.com/a" || meta.SHA256 != "abc" {
+	if meta.DownloadURL != "https://example.com/a" || meta.SHA256 != "abc" || meta.Size != 8 {
 		t.Fatalf("unexpected meta: %+v", meta)
 	}
 }
@@ -55,12 +55,33 @@ func TestShowHistory(t *testing.T) {
 		if !strings.HasPrefix(r.URL.Path, "/api/admin/v1/upgrade/history/") {
 			t.Fatalf("unexpected path: %s", r.URL.Path)
 		}
+		if r.URL.Query().Get("cursor") != "c1" {
+			t.Fatalf("cursor not forwarded: %s", r.URL.RawQuery)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		io.WriteString(w, response)
 	}))
 	defer ts.Close()
 
-	if err := showHistory(ts.URL, "token", "agt", 5); err != nil {
+	if err := showHistory(ts.URL, "token", "agt", 5, "c1"); err != nil {
 		t.Fatalf("showHistory: %v", err)
 	}
 }
+
+func TestUpdateRollout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/admin/v1/upgrade/plan/channel:canary/rollout" {
+			t.Fatalf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"percent":25}` {
+			t.Fatalf("unexpected body %s", body)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	if err := updateRollout(ts.URL, "token", "Canary", 25); err != nil {
+		t.Fatalf("updateRollout: %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+// Package attest verifies cloud instance identity documents that agents
+// attach to enrollment requests and extracts the account they prove.
+package attest
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported providers.
+const (
+	ProviderAWS   = "aws"
+	ProviderGCP   = "gcp"
+	ProviderAzure = "azure"
+)
+
+// Default key sets and audience.
+const (
+	GoogleJWKSURL        = "https://www.googleapis.com/oauth2/v3/certs"
+	AzureJWKSURL         = "https://login.microsoftonline.com/common/discovery/keys"
+	DefaultAzureAudience = "https://management.azure.com/"
+
+	jwksTTL = time.Hour
+)
+
+// ErrUnverified is returned for any document that fails verification.
+var ErrUnverified = errors.New("cloud identity could not be verified")
+
+// Document is the identity evidence an agent sends: the AWS instance
+// identity document with its base64 RSA-SHA256 signature, or a GCP/Azure
+// JWT.
+type Document struct {
+	Provider  string `json:"provider"`
+	Document  string `json:"document,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Token     string `json:"token,omitempty"`
+}
+
+// Identity is what a verified document proves. Account is the AWS account
+// ID, GCP project ID or Azure subscription ID.
+type Identity struct {
+	Provider   string `json:"provider"`
+	Account    string `json:"account"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Region     string `json:"region,omitempty"`
+}
+
+// Verifier checks identity documents. Providers without configuration are
+// rejected.
+type Verifier struct {
+	// AWSCertificates are the AWS public certificates for the regions agents
+	// run in, as published in the EC2 documentation.
+	AWSCertificates []*x509.Certificate
+	// GCPAudience must match the audience agents request, normally the
+	// controller URL.
+	GCPAudience string
+	// AzureAudience is the resource agents request tokens for.
+	AzureAudience string
+	// AzureTenants, when set, restricts Azure tokens to these tenant IDs.
+	AzureTenants []string
+
+	HTTPClient    *http.Client
+	Now           func() time.Time
+	GoogleJWKSURL string
+	AzureJWKSURL  string
+
+	mu   sync.Mutex
+	keys map[string]jwksEntry
+}
+
+type jwksEntry struct {
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+// LoadAWSCertificates reads PEM certificates from files.
+func LoadAWSCertificates(paths []string) ([]*x509.Certificate, error) {
+	var out []*x509.Certificate
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read AWS certificate: %w", err)
+		}
+		found := false
+		for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+			if block.Type != "CERTIFICATE" {
+				continue
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse AWS certificate %s: %w", path, err)
+			}
+			out = append(out, cert)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("%s contains no certificates", path)
+		}
+	}
+	return out, nil
+}
+
+// Verify checks doc and returns the identity it proves. Failures wrap
+// ErrUnverified.
+func (v *Verifier) Verify(ctx context.Context, doc Document) (Identity, error) {
+	var (
+		id  Identity
+		err error
+	)
+	switch doc.Provider {
+	case ProviderAWS:
+		id, err = v.verifyAWS(doc)
+	case ProviderGCP:
+		id, err = v.verifyGCP(ctx, doc)
+	case ProviderAzure:
+		id, err = v.verifyAzure(ctx, doc)
+	default:
+		err = fmt.Errorf("unsupported provider %q", doc.Provider)
+	}
+	if err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrUnverified, err)
+	}
+	if id.Account == "" {
+		return Identity{}, fmt.Errorf("%w: document names no account", ErrUnverified)
+	}
+	return id, nil
+}
+
+func (v *Verifier) verifyAWS(doc Document) (Identity, error) {
+	if len(v.AWSCertificates) == 0 {
+		return Identity{}, errors.New("no AWS certificates configured")
+	}
+	sig, err := base64.StdEncoding.DecodeString(doc.Signature)
+	if err != nil {
+		return Identity{}, fmt.Errorf("decode signature: %w", err)
+	}
+	verified := false
+	for _, cert := range v.AWSCertificates {
+		if cert.CheckSignature(x509.SHA256WithRSA, []byte(doc.Document), sig) == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return Identity{}, errors.New("signature does not match any AWS certificate")
+	}
+	var payload struct {
+		AccountID  string `json:"accountId"`
+		InstanceID string `json:"instanceId"`
+		Region     string `json:"region"`
+	}
+	if err := json.Unmarshal([]byte(doc.Document), &payload); err != nil {
+		return Identity{}, fmt.Errorf("parse document: %w", err)
+	}
+	return Identity{Provider: ProviderAWS, Account: payload.AccountID, InstanceID: payload.InstanceID, Region: payload.Region}, nil
+}
+
+func (v *Verifier) verifyGCP(ctx context.Context, doc Document) (Identity, error) {
+	if v.GCPAudience == "" {
+		return Identity{}, errors.New("no GCP audience configured")
+	}
+	var claims struct {
+		jwtClaims
+		Google struct {
+			ComputeEngine struct {
+				ProjectID  string `json:"project_id"`
+				InstanceID string `json:"instance_id"`
+				Zone       string `json:"zone"`
+			} `json:"compute_engine"`
+		} `json:"google"`
+	}
+	if err := v.verifyJWT(ctx, doc.Token, cmp.Or(v.GoogleJWKSURL, GoogleJWKSURL), &claims); err != nil {
+		return Identity{}, err
+	}
+	if claims.Issuer != "https://accounts.google.com" && claims.Issuer != "accounts.google.com" {
+		return Identity{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if err := claims.check(v.GCPAudience, v.now()); err != nil {
+		return Identity{}, err
+	}
+	ce := claims.Google.ComputeEngine
+	return Identity{Provider: ProviderGCP, Account: ce.ProjectID, InstanceID: ce.InstanceID, Region: ce.Zone}, nil
+}
+
+func (v *Verifier) verifyAzure(ctx context.Context, doc Document) (Identity, error) {
+	var claims struct {
+		jwtClaims
+		TenantID string `json:"tid"`
+		// ResourceID is /subscriptions/<id>/resourcegroups/<rg>/providers/
+		// Microsoft.Compute/virtualMachines/<name>.
+		ResourceID string `json:"xms_mirid"`
+	}
+	if err := v.verifyJWT(ctx, doc.Token, cmp.Or(v.AzureJWKSURL, AzureJWKSURL), &claims); err != nil {
+		return Identity{}, err
+	}
+	if claims.TenantID == "" || claims.Issuer != "https://sts.windows.net/"+claims.TenantID+"/" {
+		return Identity{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if len(v.AzureTenants) > 0 && !slices.Contains(v.AzureTenants, claims.TenantID) {
+		return Identity{}, fmt.Errorf("tenant %s not allowed", claims.TenantID)
+	}
+	if err := claims.check(cmp.Or(v.AzureAudience, DefaultAzureAudience), v.now()); err != nil {
+		return Identity{}, err
+	}
+	parts := strings.Split(strings.Trim(claims.ResourceID, "/"), "/")
+	if len(parts) < 2 || !strings.EqualFold(parts[0], "subscriptions") {
+		return Identity{}, fmt.Errorf("token names no subscription")
+	}
+	return Identity{Provider: ProviderAzure, Account: parts[1], InstanceID: parts[len(parts)-1]}, nil
+}
+
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Audience  audience `json:"aud"`
+	ExpiresAt int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+}
+
+// audience accepts both the string and the array form of "aud".
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+func (c jwtClaims) check(aud string, now time.Time) error {
+	if !slices.Contains(c.Audience, aud) {
+		return fmt.Errorf("audience %v does not include %s", []string(c.Audience), aud)
+	}
+	const leeway = time.Minute
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)) {
+		return errors.New("token expired")
+	}
+	if c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)) {
+		return errors.New("token not yet valid")
+	}
+	return nil
+}
+
+// verifyJWT checks an RS256 signature against the key set at jwksURL and
+// decodes the payload into claims.
+func (v *Verifier) verifyJWT(ctx context.Context, token, jwksURL string, claims any) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("decode token header: %w", err)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return fmt.Errorf("parse token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+	key, err := v.key(ctx, jwksURL, header.Kid)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("decode token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return errors.New("token signature invalid")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("decode token payload: %w", err)
+	}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("parse token payload: %w", err)
+	}
+	return nil
+}
+
+// key returns the signing key kid from the cached key set, refetching it
+// when stale or when kid is unknown (keys rotate).
+func (v *Verifier) key(ctx context.Context, jwksURL, kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	entry, ok := v.keys[jwksURL]
+	if ok && v.now().Sub(entry.fetched) < jwksTTL {
+		if key := entry.keys[kid]; key != nil {
+			return key, nil
+		}
+	}
+	keys, err := v.fetchJWKS(ctx, jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	if v.keys == nil {
+		v.keys = make(map[string]jwksEntry)
+	}
+	v.keys[jwksURL] = jwksEntry{keys: keys, fetched: v.now()}
+	if key := keys[kid]; key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+func (v *Verifier) fetchJWKS(ctx context.Context, jwksURL string) (map[string]*rsa.PublicKey, error) {
+	client := v.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch signing keys: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch signing keys: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&set); err != nil {
+		return nil, fmt.Errorf("parse signing keys: %w", err)
+	}
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func (v *Verifier) now() time.Time {
+	if v.Now != nil {
+		return v.Now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,101 @@
+package attest
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestVerifierAWS(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "aws"}, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+	der, _ := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+
+	doc := `{"accountId":"111111111111","instanceId":"i-0abc","region":"us-east-1"}`
+	digest := sha256.Sum256([]byte(doc))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+
+	v := &Verifier{AWSCertificates: []*x509.Certificate{cert}}
+	id, err := v.Verify(context.Background(), Document{Provider: ProviderAWS, Document: doc, Signature: base64.StdEncoding.EncodeToString(sig)})
+	if err != nil || id.Account != "111111111111" || id.InstanceID != "i-0abc" || id.Region != "us-east-1" {
+		t.Fatalf("unexpected identity %+v, %v", id, err)
+	}
+	forged := `{"accountId":"222222222222"}`
+	if _, err := v.Verify(context.Background(), Document{Provider: ProviderAWS, Document: forged, Signature: base64.StdEncoding.EncodeToString(sig)}); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected forged document to fail, got %v", err)
+	}
+}
+
+func TestVerifierJWTProviders(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	defer jwks.Close()
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(unsigned))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	v := &Verifier{GCPAudience: "https://central.example.com", GoogleJWKSURL: jwks.URL, AzureJWKSURL: jwks.URL, AzureTenants: []string{"tenant-1"}}
+	ctx := context.Background()
+
+	gcp := sign(map[string]any{
+		"iss": "https://accounts.google.com", "aud": "https://central.example.com", "exp": exp,
+		"google": map[string]any{"compute_engine": map[string]string{"project_id": "probe-fleet", "instance_id": "42", "zone": "us-central1-a"}},
+	})
+	if id, err := v.Verify(ctx, Document{Provider: ProviderGCP, Token: gcp}); err != nil || id.Account != "probe-fleet" || id.InstanceID != "42" {
+		t.Fatalf("unexpected gcp identity %+v, %v", id, err)
+	}
+	wrongAudience := sign(map[string]any{"iss": "https://accounts.google.com", "aud": "https://other.example.com", "exp": exp})
+	if _, err := v.Verify(ctx, Document{Provider: ProviderGCP, Token: wrongAudience}); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected wrong audience to fail, got %v", err)
+	}
+	expired := sign(map[string]any{"iss": "https://accounts.google.com", "aud": "https://central.example.com", "exp": time.Now().Add(-time.Hour).Unix()})
+	if _, err := v.Verify(ctx, Document{Provider: ProviderGCP, Token: expired}); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected expired token to fail, got %v", err)
+	}
+
+	azureClaims := map[string]any{
+		"iss": "https://sts.windows.net/tenant-1/", "tid": "tenant-1", "aud": DefaultAzureAudience, "exp": exp,
+		"xms_mirid": "/subscriptions/sub-123/resourcegroups/rg/providers/Microsoft.Compute/virtualMachines/probe-1",
+	}
+	if id, err := v.Verify(ctx, Document{Provider: ProviderAzure, Token: sign(azureClaims)}); err != nil || id.Account != "sub-123" || id.InstanceID != "probe-1" {
+		t.Fatalf("unexpected azure identity %+v, %v", id, err)
+	}
+	azureClaims["tid"], azureClaims["iss"] = "tenant-2", "https://sts.windows.net/tenant-2/"
+	if _, err := v.Verify(ctx, Document{Provider: ProviderAzure, Token: sign(azureClaims)}); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected other tenant to fail, got %v", err)
+	}
+	if fetches != 1 {
+		t.Fatalf("expected key set to be cached, fetched %d times", fetches)
+	}
+	tampered := gcp[:len(gcp)-4] + "AAAA"
+	if _, err := v.Verify(ctx, Document{Provider: ProviderGCP, Token: tampered}); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected tampered signature to fail, got %v", err)
+	}
+}
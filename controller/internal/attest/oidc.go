@@ -0,0 +1,175 @@
+package attest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClaimRule allows ID tokens whose Claim equals Value, or contains it when
+// the claim is a list such as "groups".
+type ClaimRule struct {
+	Claim string
+	Value string
+}
+
+func (r ClaimRule) String() string { return r.Claim + "=" + r.Value }
+
+// ParseClaimRules parses "claim=value" entries, e.g. "email=ops@example.com"
+// or "groups=probe-admins".
+func ParseClaimRules(entries []string) ([]ClaimRule, error) {
+	rules := make([]ClaimRule, 0, len(entries))
+	for _, entry := range entries {
+		claim, value, ok := strings.Cut(entry, "=")
+		claim, value = strings.TrimSpace(claim), strings.TrimSpace(value)
+		if !ok || claim == "" || value == "" {
+			return nil, fmt.Errorf("invalid claim rule %q, want claim=value", entry)
+		}
+		rules = append(rules, ClaimRule{Claim: claim, Value: value})
+	}
+	return rules, nil
+}
+
+// Operator is the person an ID token proves, and the rule that let them
+// enroll an agent.
+type Operator struct {
+	Subject string
+	Email   string
+	Rule    ClaimRule
+}
+
+// OIDC verifies ID tokens operators obtain through the agent's device flow
+// (`enroll --oidc-issuer`). A token must be signed by Issuer, name Audience
+// (the client ID agents use) and match at least one Allow rule.
+type OIDC struct {
+	Issuer   string
+	Audience string
+	Allow    []ClaimRule
+
+	HTTPClient *http.Client
+	Now        func() time.Time
+
+	mu      sync.Mutex
+	jwksURL string
+	keys    *Verifier
+}
+
+// Verify checks token and returns the operator it proves. Failures wrap
+// ErrUnverified.
+func (o *OIDC) Verify(ctx context.Context, token string) (Operator, error) {
+	op, err := o.verify(ctx, token)
+	if err != nil {
+		return Operator{}, fmt.Errorf("%w: %v", ErrUnverified, err)
+	}
+	return op, nil
+}
+
+func (o *OIDC) verify(ctx context.Context, token string) (Operator, error) {
+	if o.Issuer == "" || o.Audience == "" {
+		return Operator{}, errors.New("no OIDC issuer or audience configured")
+	}
+	jwksURL, keys, err := o.keySet(ctx)
+	if err != nil {
+		return Operator{}, err
+	}
+	var raw json.RawMessage
+	if err := keys.verifyJWT(ctx, token, jwksURL, &raw); err != nil {
+		return Operator{}, err
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return Operator{}, fmt.Errorf("parse token payload: %w", err)
+	}
+	if strings.TrimRight(claims.Issuer, "/") != strings.TrimRight(o.Issuer, "/") {
+		return Operator{}, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if err := claims.check(o.Audience, keys.now()); err != nil {
+		return Operator{}, err
+	}
+	var all map[string]any
+	if err := json.Unmarshal(raw, &all); err != nil {
+		return Operator{}, fmt.Errorf("parse token payload: %w", err)
+	}
+	op := Operator{}
+	op.Subject, _ = all["sub"].(string)
+	op.Email, _ = all["email"].(string)
+	for _, rule := range o.Allow {
+		if claimMatches(all, rule) {
+			op.Rule = rule
+			return op, nil
+		}
+	}
+	return Operator{}, fmt.Errorf("subject %q matches no allowed claim", op.Subject)
+}
+
+// claimMatches reports whether claims satisfy rule. An email is only
+// trusted when the provider does not mark it unverified.
+func claimMatches(claims map[string]any, rule ClaimRule) bool {
+	if rule.Claim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return false
+		}
+	}
+	switch v := claims[rule.Claim].(type) {
+	case string:
+		return v == rule.Value
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok && s == rule.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// keySet discovers the issuer's jwks_uri once and shares one key cache for
+// all tokens.
+func (o *OIDC) keySet(ctx context.Context) (string, *Verifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.keys == nil {
+		o.keys = &Verifier{HTTPClient: o.HTTPClient, Now: o.Now}
+	}
+	if o.jwksURL != "" {
+		return o.jwksURL, o.keys, nil
+	}
+	client := o.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	endpoint := strings.TrimRight(o.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("oidc discovery: %s", resp.Status)
+	}
+	var doc struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&doc); err != nil {
+		return "", nil, fmt.Errorf("parse oidc discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != strings.TrimRight(o.Issuer, "/") {
+		return "", nil, fmt.Errorf("discovery names issuer %q, want %q", doc.Issuer, o.Issuer)
+	}
+	if doc.JWKSURI == "" {
+		return "", nil, errors.New("oidc discovery names no jwks_uri")
+	}
+	o.jwksURL = doc.JWKSURI
+	return o.jwksURL, o.keys, nil
+}
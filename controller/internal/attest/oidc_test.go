@@ -0,0 +1,28 @@
+package attest
+
+import "testing"
+
+func TestClaimRules(t *testing.T) {
+	if _, err := ParseClaimRules([]string{"groups"}); err == nil {
+		t.Fatalf("expected rule without value to be rejected")
+	}
+	rules, err := ParseClaimRules([]string{"groups=probe-ops", " email = ops@example.com "})
+	if err != nil || len(rules) != 2 || rules[1] != (ClaimRule{Claim: "email", Value: "ops@example.com"}) {
+		t.Fatalf("unexpected rules %+v, %v", rules, err)
+	}
+	for _, tc := range []struct {
+		claims map[string]any
+		rule   ClaimRule
+		want   bool
+	}{
+		{map[string]any{"groups": []any{"finance", "probe-ops"}}, rules[0], true},
+		{map[string]any{"groups": "probe-ops"}, rules[0], true},
+		{map[string]any{"groups": []any{"finance"}}, rules[0], false},
+		{map[string]any{"email": "ops@example.com"}, rules[1], true},
+		{map[string]any{"email": "ops@example.com", "email_verified": false}, rules[1], false},
+	} {
+		if got := claimMatches(tc.claims, tc.rule); got != tc.want {
+			t.Fatalf("claimMatches(%v, %s) = %v", tc.claims, tc.rule, got)
+		}
+	}
+}
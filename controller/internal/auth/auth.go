@@ -0,0 +1,352 @@
+// Package auth authenticates controller requests through a chain of
+// pluggable schemes. Routes declare the role they require; handlers read the
+// resulting Principal from the request context instead of inspecting
+// credentials themselves.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/pingsantohq/agent/pkg/revocation"
+)
+
+// Role is the capability a principal holds.
+type Role string
+
+const (
+	RoleAgent Role = "agent"
+	RoleAdmin Role = "admin"
+)
+
+// Admin scopes limit what an admin credential may change. Every scope
+// grants ScopeRead, and ScopeAdmin grants all of them, including managing
+// API keys.
+const (
+	ScopeRead      = "read"
+	ScopePlans     = "plans"
+	ScopeArtifacts = "artifacts"
+	ScopeSettings  = "settings"
+	ScopeMonitors  = "monitors"
+	// ScopeAgents covers enrollment tokens and certificate renewals.
+	ScopeAgents = "agents"
+	ScopeAdmin  = "admin"
+)
+
+// Scopes lists every admin scope.
+var Scopes = []string{ScopeRead, ScopePlans, ScopeArtifacts, ScopeSettings, ScopeMonitors, ScopeAgents, ScopeAdmin}
+
+// ValidScope reports whether scope is one of Scopes.
+func ValidScope(scope string) bool {
+	return slices.Contains(Scopes, scope)
+}
+
+// Admin roles bundle scopes for common duties: viewers (such as auditors)
+// only read, operators also run upgrades, artifacts, monitors and enrollment,
+// and admins additionally change controller settings and manage API keys.
+const (
+	AdminRoleViewer   = "viewer"
+	AdminRoleOperator = "operator"
+	AdminRoleAdmin    = "admin"
+)
+
+// AdminRoles lists every admin role, least privileged first.
+var AdminRoles = []string{AdminRoleViewer, AdminRoleOperator, AdminRoleAdmin}
+
+// RoleScopes returns the scopes an admin role grants.
+func RoleScopes(role string) ([]string, bool) {
+	switch role {
+	case AdminRoleViewer:
+		return []string{ScopeRead}, true
+	case AdminRoleOperator:
+		return []string{ScopePlans, ScopeArtifacts, ScopeMonitors, ScopeAgents}, true
+	case AdminRoleAdmin:
+		return []string{ScopeAdmin}, true
+	}
+	return nil, false
+}
+
+// Principal is an authenticated caller.
+type Principal struct {
+	// Subject is the agent ID for agents or the credential name for admins.
+	Subject string
+	Role    Role
+	// Scheme names the authenticator that accepted the request.
+	Scheme string
+	// Scopes are the admin scopes the credential holds; empty for agents.
+	Scopes []string
+}
+
+// HasScope reports whether p's scopes grant scope.
+func (p Principal) HasScope(scope string) bool {
+	if scope == ScopeRead && len(p.Scopes) > 0 {
+		return true
+	}
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// ErrNoCredentials is returned by an Authenticator when the request does not
+// carry credentials for its scheme, letting the chain try the next one.
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator validates one credential scheme. It returns ErrNoCredentials
+// when the scheme does not apply and any other error when credentials are
+// present but invalid.
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Func adapts a function to Authenticator, e.g. for an OIDC token verifier.
+type Func func(r *http.Request) (Principal, error)
+
+// Authenticate calls f.
+func (f Func) Authenticate(r *http.Request) (Principal, error) { return f(r) }
+
+// Chain tries each authenticator in order. The first that accepts the request
+// wins; the first that rejects presented credentials stops the chain.
+type Chain []Authenticator
+
+// Authenticate implements Authenticator.
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal stored by Require.
+func FromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(contextKey{}).(Principal)
+	return p, ok
+}
+
+// Require wraps next so it only runs for requests that a authenticates with
+// one of roles. Failures get 401 with the reason.
+func Require(a Authenticator, roles ...Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, err := a.Authenticate(r)
+			if err != nil {
+				msg := "unauthorized"
+				if !errors.Is(err, ErrNoCredentials) {
+					msg = err.Error()
+				}
+				http.Error(w, msg, http.StatusUnauthorized)
+				return
+			}
+			if !hasRole(p, roles) {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+		})
+	}
+}
+
+// RequireScope wraps next so it only runs for principals, already
+// authenticated by Require, that hold scope. Others get 403.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p, ok := FromContext(r.Context()); !ok || !p.HasScope(scope) {
+				http.Error(w, fmt.Sprintf("forbidden: requires the %s scope", scope), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func hasRole(p Principal, roles []Role) bool {
+	if len(roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// StaticBearer accepts "Authorization: Bearer <Token>" as an admin. It is
+// disabled when Token is empty.
+type StaticBearer struct {
+	Token string
+}
+
+// Authenticate implements Authenticator.
+func (s StaticBearer) Authenticate(r *http.Request) (Principal, error) {
+	token, ok := bearerToken(r)
+	if !ok || strings.TrimSpace(s.Token) == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	if !secureEqual(token, s.Token) {
+		// Leave the token to later bearer schemes (e.g. OIDC) in the chain.
+		return Principal{}, ErrNoCredentials
+	}
+	return Principal{Subject: "admin", Role: RoleAdmin, Scheme: "bearer", Scopes: []string{ScopeAdmin}}, nil
+}
+
+// APIKey is a named admin credential. It holds every scope unless Scopes
+// is set.
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// APIKeys accepts any configured key sent in Header (default X-API-Key).
+type APIKeys struct {
+	Header string
+	Keys   []APIKey
+}
+
+// Authenticate implements Authenticator.
+func (a APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	presented := strings.TrimSpace(r.Header.Get(header))
+	if presented == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	for _, k := range a.Keys {
+		if k.Key != "" && secureEqual(presented, k.Key) {
+			scopes := k.Scopes
+			if len(scopes) == 0 {
+				scopes = []string{ScopeAdmin}
+			}
+			return Principal{Subject: k.Name, Role: RoleAdmin, Scheme: "api_key", Scopes: scopes}, nil
+		}
+	}
+	return Principal{}, errors.New("invalid API key")
+}
+
+// HashKey returns the stored form of an API key secret.
+func HashKey(secret string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashedKeys accepts API keys sent in Header (default X-API-Key) that are
+// stored only as HashKey digests. Lookup resolves a digest to its admin
+// principal and returns ErrNoCredentials for unknown keys, so the chain can
+// try statically configured keys next.
+type HashedKeys struct {
+	Header string
+	Lookup func(ctx context.Context, hash string) (Principal, error)
+}
+
+// Authenticate implements Authenticator.
+func (h HashedKeys) Authenticate(r *http.Request) (Principal, error) {
+	header := h.Header
+	if header == "" {
+		header = "X-API-Key"
+	}
+	presented := strings.TrimSpace(r.Header.Get(header))
+	if presented == "" || h.Lookup == nil {
+		return Principal{}, ErrNoCredentials
+	}
+	return h.Lookup(r.Context(), HashKey(presented))
+}
+
+// DefaultSPIFFEAgentPrefix is the SPIFFE ID path that precedes the agent ID
+// when ClientCert.SPIFFEAgentPrefix is empty.
+const DefaultSPIFFEAgentPrefix = "/pingsanto/agent/"
+
+// ClientCert identifies agents by a verified TLS client certificate. When
+// SPIFFETrustDomain is set, a SPIFFE ID in the certificate's URI SANs takes
+// precedence over the common name: spiffe://<domain><prefix><agent-id> maps
+// to <agent-id>, and SPIFFE IDs from another trust domain or outside the
+// prefix are rejected. A non-nil Revocation rejects revoked certificates.
+type ClientCert struct {
+	SPIFFETrustDomain string
+	// SPIFFEAgentPrefix defaults to DefaultSPIFFEAgentPrefix.
+	SPIFFEAgentPrefix string
+	Revocation        *revocation.Checker
+}
+
+// Authenticate implements Authenticator.
+func (c ClientCert) Authenticate(r *http.Request) (Principal, error) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return Principal{}, ErrNoCredentials
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	if err := c.Revocation.VerifyConnection(*r.TLS); err != nil {
+		return Principal{}, err
+	}
+	if c.SPIFFETrustDomain != "" {
+		for _, uri := range leaf.URIs {
+			if uri.Scheme == "spiffe" {
+				return c.spiffePrincipal(uri.Host, uri.Path)
+			}
+		}
+	}
+	cn := strings.TrimSpace(leaf.Subject.CommonName)
+	if cn == "" {
+		return Principal{}, errors.New("client certificate has no common name")
+	}
+	return Principal{Subject: cn, Role: RoleAgent, Scheme: "mtls"}, nil
+}
+
+func (c ClientCert) spiffePrincipal(domain, path string) (Principal, error) {
+	id := "spiffe://" + domain + path
+	if !strings.EqualFold(domain, c.SPIFFETrustDomain) {
+		return Principal{}, fmt.Errorf("SPIFFE ID %s is not in trust domain %s", id, c.SPIFFETrustDomain)
+	}
+	prefix := c.SPIFFEAgentPrefix
+	if prefix == "" {
+		prefix = DefaultSPIFFEAgentPrefix
+	}
+	agentID, ok := strings.CutPrefix(path, prefix)
+	if !ok || agentID == "" || strings.Contains(agentID, "/") {
+		return Principal{}, fmt.Errorf("SPIFFE ID %s does not name an agent (want spiffe://%s%s<agent-id>)", id, c.SPIFFETrustDomain, prefix)
+	}
+	return Principal{Subject: agentID, Role: RoleAgent, Scheme: "spiffe"}, nil
+}
+
+// AgentHeader trusts the X-Agent-ID header. It is intended for development
+// and deployments that terminate mTLS in front of the controller.
+type AgentHeader struct{}
+
+// Authenticate implements Authenticator.
+func (AgentHeader) Authenticate(r *http.Request) (Principal, error) {
+	id := strings.TrimSpace(r.Header.Get("X-Agent-ID"))
+	if id == "" {
+		return Principal{}, ErrNoCredentials
+	}
+	return Principal{Subject: id, Role: RoleAgent, Scheme: "header"}, nil
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	value := r.Header.Get("Authorization")
+	if !strings.HasPrefix(value, prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(value, prefix)), true
+}
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestChainTriesSchemesInOrder(t *testing.T) {
+	chain := Chain{
+		StaticBearer{Token: "root"},
+		APIKeys{Keys: []APIKey{{Name: "ci", Key: "ci-key"}}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer root")
+	if p, err := chain.Authenticate(req); err != nil || p.Role != RoleAdmin || p.Scheme != "bearer" {
+		t.Fatalf("bearer: got %+v, %v", p, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", "ci-key")
+	if p, err := chain.Authenticate(req); err != nil || p.Subject != "ci" || p.Scheme != "api_key" {
+		t.Fatalf("api key: got %+v, %v", p, err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", "wrong")
+	if _, err := chain.Authenticate(req); err == nil || err == ErrNoCredentials {
+		t.Fatalf("expected invalid key error, got %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer other")
+	if _, err := chain.Authenticate(req); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials for unknown bearer, got %v", err)
+	}
+}
+
+func TestClientCertIdentifiesAgent(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, err := (ClientCert{}).Authenticate(req); err != ErrNoCredentials {
+		t.Fatalf("expected ErrNoCredentials without TLS, got %v", err)
+	}
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "agent-7"}}}}
+	p, err := (ClientCert{}).Authenticate(req)
+	if err != nil || p.Subject != "agent-7" || p.Role != RoleAgent {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+}
+
+func TestClientCertMapsSPIFFEIDs(t *testing.T) {
+	cert := func(uri, cn string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		leaf := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		if uri != "" {
+			u, _ := url.Parse(uri)
+			leaf.URIs = []*url.URL{u}
+		}
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+		return req
+	}
+	a := ClientCert{SPIFFETrustDomain: "example.org"}
+
+	p, err := a.Authenticate(cert("spiffe://example.org/pingsanto/agent/agt_9", ""))
+	if err != nil || p.Subject != "agt_9" || p.Role != RoleAgent || p.Scheme != "spiffe" {
+		t.Fatalf("got %+v, %v", p, err)
+	}
+	for _, uri := range []string{
+		"spiffe://other.org/pingsanto/agent/agt_9",
+		"spiffe://example.org/workload/web",
+		"spiffe://example.org/pingsanto/agent/",
+	} {
+		if _, err := a.Authenticate(cert(uri, "agt_9")); err == nil || err == ErrNoCredentials {
+			t.Fatalf("expected %s to be rejected, got %v", uri, err)
+		}
+	}
+	if p, err := a.Authenticate(cert("", "agent-7")); err != nil || p.Subject != "agent-7" {
+		t.Fatalf("expected CN fallback, got %+v, %v", p, err)
+	}
+	if p, err := (ClientCert{}).Authenticate(cert("spiffe://example.org/pingsanto/agent/agt_9", "agent-7")); err != nil || p.Subject != "agent-7" {
+		t.Fatalf("expected SPIFFE mapping to be off without a trust domain, got %+v, %v", p, err)
+	}
+	custom := ClientCert{SPIFFETrustDomain: "example.org", SPIFFEAgentPrefix: "/agents/"}
+	if p, err := custom.Authenticate(cert("spiffe://example.org/agents/agt_3", "")); err != nil || p.Subject != "agt_3" {
+		t.Fatalf("expected custom prefix mapping, got %+v, %v", p, err)
+	}
+}
+
+func TestRequireEnforcesRoleAndStoresPrincipal(t *testing.T) {
+	var got Principal
+	handler := Require(Chain{AgentHeader{}, StaticBearer{Token: "root"}}, RoleAgent)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ = FromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Agent-ID", "agent-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || got.Subject != "agent-1" {
+		t.Fatalf("agent request: code=%d principal=%+v", rec.Code, got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer root")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("admin on agent route: expected 401, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("anonymous: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestHashedKeysAndScopes(t *testing.T) {
+	keys := HashedKeys{Lookup: func(_ context.Context, hash string) (Principal, error) {
+		if hash != HashKey("psk_ops") {
+			return Principal{}, ErrNoCredentials
+		}
+		return Principal{Subject: "ops", Role: RoleAdmin, Scopes: []string{ScopePlans}}, nil
+	}}
+	chain := Chain{keys, APIKeys{Keys: []APIKey{{Name: "ci", Key: "ci-key"}}}}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-API-Key", "psk_ops")
+	p, err := chain.Authenticate(req)
+	if err != nil || p.Subject != "ops" {
+		t.Fatalf("hashed key: got %+v, %v", p, err)
+	}
+	if !p.HasScope(ScopePlans) || !p.HasScope(ScopeRead) || p.HasScope(ScopeSettings) || p.HasScope(ScopeAdmin) {
+		t.Fatalf("unexpected scopes for %+v", p)
+	}
+
+	// Unknown hashed keys fall through to the static keys.
+	req.Header.Set("X-API-Key", "ci-key")
+	if p, err := chain.Authenticate(req); err != nil || p.Subject != "ci" || !p.HasScope(ScopeSettings) {
+		t.Fatalf("static key: got %+v, %v", p, err)
+	}
+	if (Principal{Role: RoleAgent}).HasScope(ScopeRead) {
+		t.Fatal("agents must not hold admin scopes")
+	}
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/agent/pkg/revocation"
+)
+
+func TestClientCertRejectsRevokedCertificates(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "agent CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	request := func(serial int64) *http.Request {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "agt_" + big.NewInt(serial).String()},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatalf("create agent certificate: %v", err)
+		}
+		leaf, _ := x509.ParseCertificate(der)
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{
+			PeerCertificates: []*x509.Certificate{leaf},
+			VerifiedChains:   [][]*x509.Certificate{{leaf, ca}},
+		}
+		return req
+	}
+
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: big.NewInt(7), RevocationTime: time.Now().Add(-time.Minute)},
+		},
+	}, ca, caKey)
+	if err != nil {
+		t.Fatalf("create CRL: %v", err)
+	}
+	crlPath := filepath.Join(t.TempDir(), "agents.crl")
+	if err := os.WriteFile(crlPath, crlDER, 0o600); err != nil {
+		t.Fatalf("write CRL: %v", err)
+	}
+
+	a := ClientCert{Revocation: &revocation.Checker{Mode: revocation.Hard, CRLFiles: []string{crlPath}}}
+	if p, err := a.Authenticate(request(8)); err != nil || p.Subject != "agt_8" {
+		t.Fatalf("expected unrevoked agent to authenticate, got %+v, %v", p, err)
+	}
+	if _, err := a.Authenticate(request(7)); !errors.Is(err, revocation.ErrRevoked) {
+		t.Fatalf("expected revoked agent to be rejected, got %v", err)
+	}
+
+	// Without a CRL or responder the status is unknown.
+	hard := ClientCert{Revocation: &revocation.Checker{Mode: revocation.Hard}}
+	if _, err := hard.Authenticate(request(9)); err == nil || err == ErrNoCredentials {
+		t.Fatalf("expected hard mode to reject unknown status, got %v", err)
+	}
+	soft := ClientCert{Revocation: &revocation.Checker{Mode: revocation.Soft}}
+	if p, err := soft.Authenticate(request(9)); err != nil || p.Subject != "agt_9" {
+		t.Fatalf("expected soft mode to accept unknown status, got %+v, %v", p, err)
+	}
+}
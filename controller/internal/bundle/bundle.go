@@ -0,0 +1,236 @@
+// Package bundle defines the versioned YAML monitor bundle used for git-driven
+// monitor management. A bundle declares monitors and which agents run them;
+// applying it publishes a new snapshot revision for every agent it names.
+package bundle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	// APIVersion is the only bundle schema version understood by this controller.
+	APIVersion = "pingsanto.io/v1"
+	// Kind identifies monitor bundle documents.
+	Kind = "MonitorBundle"
+)
+
+var supportedProtocols = map[string]struct{}{
+	"icmp": {},
+	"tcp":  {},
+	"udp":  {},
+	"http": {},
+	"dns":  {},
+}
+
+// Bundle is the top-level bundle document.
+type Bundle struct {
+	APIVersion  string       `yaml:"apiVersion" json:"apiVersion"`
+	Kind        string       `yaml:"kind" json:"kind"`
+	Monitors    []Monitor    `yaml:"monitors" json:"monitors"`
+	Assignments []Assignment `yaml:"assignments" json:"assignments"`
+}
+
+// Monitor declares a single monitor definition.
+type Monitor struct {
+	ID            string   `yaml:"id" json:"id"`
+	Protocol      string   `yaml:"protocol" json:"protocol"`
+	Targets       []string `yaml:"targets" json:"targets"`
+	CadenceMillis int      `yaml:"cadence_ms" json:"cadence_ms"`
+	TimeoutMillis int      `yaml:"timeout_ms" json:"timeout_ms"`
+	Configuration string   `yaml:"configuration,omitempty" json:"configuration,omitempty"`
+	Disabled      bool     `yaml:"disabled,omitempty" json:"disabled,omitempty"`
+}
+
+// Assignment binds monitors to agents. An agent named with an empty monitor
+// list has all of its monitors removed.
+type Assignment struct {
+	Agents   []string `yaml:"agents" json:"agents"`
+	Monitors []string `yaml:"monitors" json:"monitors"`
+}
+
+// Problem is a single schema violation reported by Lint.
+type Problem struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.Field, p.Message)
+}
+
+// LintError wraps the problems found while validating a bundle.
+type LintError struct {
+	Problems []Problem
+}
+
+func (e *LintError) Error() string {
+	parts := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		parts[i] = p.String()
+	}
+	return "invalid bundle: " + strings.Join(parts, "; ")
+}
+
+// Parse decodes a YAML bundle, rejecting unknown fields and trailing documents.
+// JSON input is accepted as well since it is a subset of YAML.
+func Parse(data []byte) (Bundle, error) {
+	var b Bundle
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&b); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Bundle{}, fmt.Errorf("parse bundle: empty document")
+		}
+		return Bundle{}, fmt.Errorf("parse bundle: %w", err)
+	}
+	var extra any
+	if err := dec.Decode(&extra); !errors.Is(err, io.EOF) {
+		return Bundle{}, fmt.Errorf("parse bundle: expected a single document")
+	}
+	return b, nil
+}
+
+// Lint checks the bundle against the schema and returns every problem found.
+func Lint(b Bundle) []Problem {
+	var problems []Problem
+	add := func(field, format string, args ...any) {
+		problems = append(problems, Problem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if b.APIVersion != APIVersion {
+		add("apiVersion", "must be %q, got %q", APIVersion, b.APIVersion)
+	}
+	if b.Kind != Kind {
+		add("kind", "must be %q, got %q", Kind, b.Kind)
+	}
+
+	ids := make(map[string]struct{}, len(b.Monitors))
+	for i, m := range b.Monitors {
+		field := fmt.Sprintf("monitors[%d]", i)
+		id := strings.TrimSpace(m.ID)
+		switch {
+		case id == "":
+			add(field+".id", "required")
+		case id != m.ID:
+			add(field+".id", "must not contain surrounding whitespace")
+		default:
+			if _, dup := ids[id]; dup {
+				add(field+".id", "duplicate monitor id %q", id)
+			}
+			ids[id] = struct{}{}
+		}
+		problems = append(problems, LintMonitor(field, m)...)
+	}
+
+	agents := map[string]struct{}{}
+	for i, a := range b.Assignments {
+		field := fmt.Sprintf("assignments[%d]", i)
+		if len(a.Agents) == 0 {
+			add(field+".agents", "at least one agent required")
+		}
+		for j, agent := range a.Agents {
+			if strings.TrimSpace(agent) == "" {
+				add(fmt.Sprintf("%s.agents[%d]", field, j), "must not be empty")
+			}
+			agents[agent] = struct{}{}
+		}
+		for j, ref := range a.Monitors {
+			if _, ok := ids[ref]; !ok {
+				add(fmt.Sprintf("%s.monitors[%d]", field, j), "unknown monitor %q", ref)
+			}
+		}
+	}
+	if len(agents) == 0 && len(problems) == 0 {
+		add("assignments", "bundle assigns no agents")
+	}
+	return problems
+}
+
+// LintMonitor checks a single monitor definition, reporting problems under
+// field (for example "monitors[0]", or "" for top-level names). Monitor ID
+// uniqueness is left to the caller.
+func LintMonitor(field string, m Monitor) []Problem {
+	var problems []Problem
+	add := func(name, format string, args ...any) {
+		if field != "" {
+			name = field + "." + name
+		}
+		problems = append(problems, Problem{Field: name, Message: fmt.Sprintf(format, args...)})
+	}
+	if _, ok := supportedProtocols[m.Protocol]; !ok {
+		add("protocol", "unsupported protocol %q", m.Protocol)
+	}
+	if len(m.Targets) == 0 {
+		add("targets", "at least one target required")
+	}
+	for j, target := range m.Targets {
+		if strings.TrimSpace(target) == "" {
+			add(fmt.Sprintf("targets[%d]", j), "must not be empty")
+		}
+	}
+	if m.CadenceMillis <= 0 {
+		add("cadence_ms", "must be positive")
+	}
+	if m.TimeoutMillis < 0 {
+		add("timeout_ms", "must not be negative")
+	} else if m.CadenceMillis > 0 && m.TimeoutMillis > m.CadenceMillis {
+		add("timeout_ms", "must not exceed cadence_ms")
+	}
+	return problems
+}
+
+// Validate returns a *LintError when the bundle has schema problems.
+func Validate(b Bundle) error {
+	if problems := Lint(b); len(problems) > 0 {
+		return &LintError{Problems: problems}
+	}
+	return nil
+}
+
+// Snapshots expands assignments into the full monitor set for each named agent.
+func Snapshots(b Bundle) map[string][]store.MonitorAssignment {
+	byID := make(map[string]Monitor, len(b.Monitors))
+	for _, m := range b.Monitors {
+		byID[m.ID] = m
+	}
+	assigned := map[string]map[string]struct{}{}
+	for _, a := range b.Assignments {
+		for _, agent := range a.Agents {
+			if assigned[agent] == nil {
+				assigned[agent] = map[string]struct{}{}
+			}
+			for _, ref := range a.Monitors {
+				assigned[agent][ref] = struct{}{}
+			}
+		}
+	}
+
+	out := make(map[string][]store.MonitorAssignment, len(assigned))
+	for agent, refs := range assigned {
+		monitors := make([]store.MonitorAssignment, 0, len(refs))
+		for ref := range refs {
+			m := byID[ref]
+			monitors = append(monitors, store.MonitorAssignment{
+				MonitorID:     m.ID,
+				Protocol:      m.Protocol,
+				Targets:       append([]string(nil), m.Targets...),
+				CadenceMillis: m.CadenceMillis,
+				TimeoutMillis: m.TimeoutMillis,
+				Configuration: m.Configuration,
+				Disabled:      m.Disabled,
+			})
+		}
+		sort.Slice(monitors, func(i, j int) bool { return monitors[i].MonitorID < monitors[j].MonitorID })
+		out[agent] = monitors
+	}
+	return out
+}
@@ -0,0 +1,95 @@
+package bundle
+
+import (
+	"strings"
+	"testing"
+)
+
+const sampleBundle = `
+apiVersion: pingsanto.io/v1
+kind: MonitorBundle
+monitors:
+  - id: mon_dns
+    protocol: icmp
+    targets: ["1.1.1.1"]
+    cadence_ms: 3000
+    timeout_ms: 1000
+  - id: mon_web
+    protocol: tcp
+    targets: ["203.0.113.8:443"]
+    cadence_ms: 5000
+assignments:
+  - agents: [agt_1, agt_2]
+    monitors: [mon_dns]
+  - agents: [agt_2]
+    monitors: [mon_web]
+`
+
+func TestParseLintAndExpand(t *testing.T) {
+	b, err := Parse([]byte(sampleBundle))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if problems := Lint(b); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+	snapshots := Snapshots(b)
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 agents, got %d", len(snapshots))
+	}
+	if got := snapshots["agt_2"]; len(got) != 2 || got[0].MonitorID != "mon_dns" || got[1].MonitorID != "mon_web" {
+		t.Fatalf("unexpected agt_2 monitors: %+v", got)
+	}
+	if got := snapshots["agt_1"]; len(got) != 1 || got[0].CadenceMillis != 3000 {
+		t.Fatalf("unexpected agt_1 monitors: %+v", got)
+	}
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	_, err := Parse([]byte("apiVersion: pingsanto.io/v1\nkind: MonitorBundle\nmonitor: []\n"))
+	if err == nil || !strings.Contains(err.Error(), "monitor") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestLintReportsSchemaProblems(t *testing.T) {
+	b, err := Parse([]byte(`
+apiVersion: pingsanto.io/v2
+kind: MonitorBundle
+monitors:
+  - id: mon_a
+    protocol: carrier-pigeon
+    targets: []
+    cadence_ms: 1000
+    timeout_ms: 2000
+  - id: mon_a
+    protocol: icmp
+    targets: ["1.1.1.1"]
+    cadence_ms: 1000
+assignments:
+  - agents: [agt_1]
+    monitors: [mon_missing]
+`))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	fields := map[string]bool{}
+	for _, p := range Lint(b) {
+		fields[p.Field] = true
+	}
+	for _, want := range []string{
+		"apiVersion",
+		"monitors[0].protocol",
+		"monitors[0].targets",
+		"monitors[0].timeout_ms",
+		"monitors[1].id",
+		"assignments[0].monitors[0]",
+	} {
+		if !fields[want] {
+			t.Fatalf("expected problem for %s, got %v", want, fields)
+		}
+	}
+	if err := Validate(b); err == nil {
+		t.Fatalf("expected Validate to fail")
+	}
+}
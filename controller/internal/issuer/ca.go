@@ -0,0 +1,167 @@
+// Package issuer signs client credentials for enrolling agents with a CA
+// certificate and key held by the controller.
+package issuer
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+)
+
+// DefaultValidity is the lifetime of issued agent certificates.
+const DefaultValidity = 90 * 24 * time.Hour
+
+// Credentials are the PEM files an agent stores on enrollment: its client
+// certificate and key, and the CA bundle it trusts for the controller.
+type Credentials struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	CAPEM   []byte
+}
+
+// CA issues agent client certificates whose common name is the agent ID, so
+// the controller's mtls authentication maps them back to the agent.
+type CA struct {
+	cert     *x509.Certificate
+	signer   crypto.Signer
+	bundle   []byte
+	validity time.Duration
+	now      func() time.Time
+}
+
+// LoadCA reads the issuing CA from certFile and keyFile. trustBundleFile is
+// the CA bundle handed to agents for verifying the controller; when empty it
+// defaults to certFile, for controllers whose TLS certificate comes from the
+// same CA. A zero validity uses DefaultValidity.
+func LoadCA(certFile, keyFile, trustBundleFile string, validity time.Duration) (*CA, error) {
+	certPEM, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA certificate: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read CA key: %w", err)
+	}
+	bundle := certPEM
+	if trustBundleFile != "" {
+		if bundle, err = os.ReadFile(trustBundleFile); err != nil {
+			return nil, fmt.Errorf("read trust bundle: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("trust bundle %s contains no certificates", trustBundleFile)
+		}
+	}
+	return NewCA(certPEM, keyPEM, bundle, validity)
+}
+
+// NewCA is LoadCA for PEM data already in memory.
+func NewCA(certPEM, keyPEM, trustBundle []byte, validity time.Duration) (*CA, error) {
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("load CA key pair: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse CA certificate: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("CA certificate is not marked as a CA")
+	}
+	signer, ok := pair.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("CA key cannot sign")
+	}
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	if len(trustBundle) == 0 {
+		trustBundle = certPEM
+	}
+	return &CA{cert: cert, signer: signer, bundle: trustBundle, validity: validity, now: time.Now}, nil
+}
+
+// IssueAgentCertificate generates a key pair for agentID and signs a client
+// certificate for it.
+func (c *CA) IssueAgentCertificate(ctx context.Context, agentID string) (Credentials, error) {
+	if agentID == "" {
+		return Credentials{}, errors.New("agent ID required")
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("generate key: %w", err)
+	}
+	certPEM, err := c.sign(agentID, &key.PublicKey)
+	if err != nil {
+		return Credentials{}, err
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("encode key: %w", err)
+	}
+	return Credentials{
+		CertPEM: certPEM,
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		CAPEM:   c.bundle,
+	}, nil
+}
+
+// SignAgentCSR signs a client certificate for agentID over the public key of
+// a PEM certificate request, for agents renewing with a key they generated.
+// The request's subject is ignored. The returned credentials carry no key.
+func (c *CA) SignAgentCSR(ctx context.Context, agentID string, csrPEM []byte) (Credentials, error) {
+	if agentID == "" {
+		return Credentials{}, errors.New("agent ID required")
+	}
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return Credentials{}, errors.New("no PEM certificate request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("parse certificate request: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return Credentials{}, fmt.Errorf("certificate request signature: %w", err)
+	}
+	certPEM, err := c.sign(agentID, csr.PublicKey)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{CertPEM: certPEM, CAPEM: c.bundle}, nil
+}
+
+func (c *CA) sign(agentID string, pub any) ([]byte, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("generate serial: %w", err)
+	}
+	now := c.now()
+	notAfter := now.Add(c.validity)
+	if notAfter.After(c.cert.NotAfter) {
+		notAfter = c.cert.NotAfter
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: agentID},
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, pub, c.signer)
+	if err != nil {
+		return nil, fmt.Errorf("sign certificate: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
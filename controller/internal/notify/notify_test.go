@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackAndMulti(t *testing.T) {
+	var got map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+	}))
+	defer srv.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer failing.Close()
+
+	msg := Message{Subject: "Agent 1.2.0 published", Text: "Version 1.2.0 is now planned."}
+	err := Multi{Slack{WebhookURL: failing.URL}, Slack{WebhookURL: srv.URL}}.Notify(context.Background(), msg)
+	if err == nil || !strings.Contains(err.Error(), "status 403: invalid_token") {
+		t.Fatalf("expected the failing webhook's error, got %v", err)
+	}
+	if got["text"] != "*Agent 1.2.0 published*\nVersion 1.2.0 is now planned." {
+		t.Fatalf("later notifiers should still run, got %v", got)
+	}
+	if err := (Multi{}).Notify(context.Background(), msg); err != nil {
+		t.Fatalf("empty Multi: %v", err)
+	}
+}
+
+func TestSMTPMessage(t *testing.T) {
+	s := SMTP{Addr: "mail.example.com:587", From: "controller@example.com", To: []string{"ops@example.com", "rel@example.com"}}
+	if err := s.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if err := (SMTP{Addr: "mail.example.com", From: "a@example.com", To: []string{"b@example.com"}}).Validate(); err == nil {
+		t.Fatal("expected an address without port to be invalid")
+	}
+	raw := string(s.message(Message{Subject: "Rollout\r\nBcc: x@example.com done", Text: "line one\nline two"}, time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)))
+	for _, want := range []string{
+		"To: ops@example.com, rel@example.com\r\n",
+		"Subject: Rollout Bcc: x@example.com done\r\n",
+		"Date: Sun, 01 Mar 2026 12:00:00 +0000\r\n",
+		"\r\n\r\nline one\r\nline two\r\n",
+	} {
+		if !strings.Contains(raw, want) {
+			t.Fatalf("message missing %q:\n%s", want, raw)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package results
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PostgresStore writes batches to result_batches and probe_results
+// (migrations/0016_probe_results.sql).
+type PostgresStore struct {
+	pool *pgxpool.Pool
+}
+
+// NewPostgresStore uses pool, typically the controller store's.
+func NewPostgresStore(pool *pgxpool.Pool) *PostgresStore {
+	return &PostgresStore{pool: pool}
+}
+
+var probeResultColumns = []string{
+	"agent_id", "batch_seq", "monitor_id", "ts", "proto", "ip", "rtt_ms",
+	"success", "seq", "jitter_ms", "loss_window_pct", "mos", "received_at",
+}
+
+func (p *PostgresStore) Append(ctx context.Context, batch Batch) (int, error) {
+	labels, err := json.Marshal(batch.Labels)
+	if err != nil {
+		return 0, err
+	}
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// COPY cannot skip conflicts, so results are staged and then inserted
+	// past the unique index from migrations/0024_probe_results_dedupe.sql.
+	if _, err := tx.Exec(ctx, `CREATE TEMP TABLE incoming_results (LIKE probe_results) ON COMMIT DROP;`); err != nil {
+		return 0, err
+	}
+	rows := make([][]any, 0, len(batch.Results))
+	for _, r := range batch.Results {
+		rows = append(rows, []any{
+			batch.AgentID, int64(batch.BatchSeq), r.MonitorID, r.Timestamp, r.Proto, r.IP, r.RTTMilliseconds,
+			r.Success, int64(r.Sequence), r.JitterMs, r.LossWindowPct, r.MOS, batch.ReceivedAt,
+		})
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"incoming_results"}, probeResultColumns, pgx.CopyFromRows(rows)); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(ctx, `
+INSERT INTO probe_results SELECT DISTINCT ON (agent_id, monitor_id, ts, seq) * FROM incoming_results
+ON CONFLICT (agent_id, monitor_id, ts, seq) DO NOTHING;
+`)
+	if err != nil {
+		return 0, err
+	}
+	stored := int(tag.RowsAffected())
+	if stored == 0 {
+		return 0, ErrDuplicateBatch
+	}
+
+	if _, err := tx.Exec(ctx, `
+INSERT INTO result_batches (agent_id, batch_seq, sent_at, received_at, labels, result_count)
+VALUES ($1,$2,$3,$4,$5,$6)
+ON CONFLICT (agent_id, batch_seq, sent_at) DO NOTHING;
+`, batch.AgentID, int64(batch.BatchSeq), batch.SentAt, batch.ReceivedAt, labels, stored); err != nil {
+		return 0, err
+	}
+	return stored, tx.Commit(ctx)
+}
@@ -0,0 +1,153 @@
+// Package results persists probe results uploaded by agents.
+package results
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaxResultsPerBatch bounds a single upload; agents send far smaller batches.
+const MaxResultsPerBatch = 10000
+
+// Envelope is the batch agents post to /api/agent/v1/results, mirroring
+// agent/pkg/types.ResultEnvelope.
+type Envelope struct {
+	AgentID  string            `json:"agent_id"`
+	SentAt   time.Time         `json:"sent_at"`
+	BatchSeq uint64            `json:"batch_seq"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Results  []ProbeResult     `json:"results"`
+}
+
+// ProbeResult is one probe outcome, mirroring agent/pkg/types.ProbeResult.
+type ProbeResult struct {
+	MonitorID       string    `json:"monitor_id"`
+	Timestamp       time.Time `json:"ts"`
+	Proto           string    `json:"proto"`
+	IP              string    `json:"ip"`
+	RTTMilliseconds float64   `json:"rtt_ms"`
+	Success         bool      `json:"success"`
+	Sequence        uint64    `json:"seq"`
+	JitterMs        float64   `json:"jitter_ms"`
+	LossWindowPct   float64   `json:"loss_window_pct"`
+	MOS             float64   `json:"mos"`
+}
+
+// Validate checks the envelope carries an agent, a batch sequence and send
+// time, and between one and MaxResultsPerBatch results that each name their
+// monitor and timestamp.
+func (e Envelope) Validate() error {
+	switch {
+	case strings.TrimSpace(e.AgentID) == "":
+		return errors.New("agent_id required")
+	case e.BatchSeq == 0:
+		return errors.New("batch_seq required")
+	case e.SentAt.IsZero():
+		return errors.New("sent_at required")
+	case len(e.Results) == 0:
+		return errors.New("results required")
+	case len(e.Results) > MaxResultsPerBatch:
+		return fmt.Errorf("at most %d results per batch", MaxResultsPerBatch)
+	}
+	for i, r := range e.Results {
+		if strings.TrimSpace(r.MonitorID) == "" {
+			return fmt.Errorf("results[%d]: monitor_id required", i)
+		}
+		if r.Timestamp.IsZero() {
+			return fmt.Errorf("results[%d]: ts required", i)
+		}
+	}
+	return nil
+}
+
+// Batch is an accepted envelope.
+type Batch struct {
+	Envelope
+	ReceivedAt time.Time `json:"received_at"`
+}
+
+// ErrDuplicateBatch signals a batch whose results were all stored before.
+// Results are identified by agent, monitor, ts and seq rather than by batch:
+// agents stamp a fresh batch_seq and sent_at on every send and regroup
+// results that failed to send, so a retried result may arrive in a
+// different batch.
+var ErrDuplicateBatch = errors.New("duplicate result batch")
+
+// Store persists result batches.
+type Store interface {
+	// Append stores the results of batch that were not stored before and
+	// returns how many it stored, or ErrDuplicateBatch when there were none.
+	Append(ctx context.Context, batch Batch) (int, error)
+}
+
+type resultKey struct {
+	agentID   string
+	monitorID string
+	ts        int64
+	seq       uint64
+}
+
+func keyOf(agentID string, r ProbeResult) resultKey {
+	return resultKey{agentID: agentID, monitorID: r.MonitorID, ts: r.Timestamp.UnixNano(), seq: r.Sequence}
+}
+
+// maxMemoryBatches bounds the in-memory history; the oldest batches, and
+// their deduplication keys, are dropped first.
+const maxMemoryBatches = 10000
+
+// MemoryStore keeps recent batches in memory, for scaffolding and tests.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	batches []Batch
+	seen    map[resultKey]bool
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{seen: map[resultKey]bool{}}
+}
+
+func (m *MemoryStore) Append(ctx context.Context, batch Batch) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fresh := make([]ProbeResult, 0, len(batch.Results))
+	for _, r := range batch.Results {
+		key := keyOf(batch.AgentID, r)
+		if m.seen[key] {
+			continue
+		}
+		m.seen[key] = true
+		fresh = append(fresh, r)
+	}
+	if len(fresh) == 0 {
+		return 0, ErrDuplicateBatch
+	}
+	batch.Results = fresh
+	m.batches = append(m.batches, batch)
+	if over := len(m.batches) - maxMemoryBatches; over > 0 {
+		for _, old := range m.batches[:over] {
+			for _, r := range old.Results {
+				delete(m.seen, keyOf(old.AgentID, r))
+			}
+		}
+		m.batches = append([]Batch(nil), m.batches[over:]...)
+	}
+	return len(fresh), nil
+}
+
+// Batches returns the stored batches of agentID, oldest first.
+func (m *MemoryStore) Batches(agentID string) []Batch {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []Batch
+	for _, b := range m.batches {
+		if b.AgentID == agentID {
+			out = append(out, b)
+		}
+	}
+	return out
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	defaultAgentStaleAfter = 5 * time.Minute
+	defaultAgentsPageSize  = 100
+	maxAgentsPageSize      = 1000
+)
+
+// adminListAgentsHandler serves the agent inventory, filtered by repeated
+// label=key=value parameters plus version, channel and status, one page at a
+// time. Pass the response's next_after as after to fetch the following page.
+func adminListAgentsHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	staleAfter := cfg.AgentStaleAfter
+	if staleAfter <= 0 {
+		staleAfter = defaultAgentStaleAfter
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := store.AgentFilter{
+			Version:     query.Get("version"),
+			Channel:     query.Get("channel"),
+			Status:      query.Get("status"),
+			StaleBefore: time.Now().UTC().Add(-staleAfter),
+			After:       query.Get("after"),
+		}
+		if filter.Status != "" && !store.ValidAgentStatus(filter.Status) {
+			http.Error(w, fmt.Sprintf("invalid status %q", filter.Status), http.StatusBadRequest)
+			return
+		}
+		for _, raw := range query["label"] {
+			key, value, ok := strings.Cut(raw, "=")
+			if !ok || strings.TrimSpace(key) == "" {
+				http.Error(w, fmt.Sprintf("invalid label %q (want key=value)", raw), http.StatusBadRequest)
+				return
+			}
+			if filter.Labels == nil {
+				filter.Labels = map[string]string{}
+			}
+			filter.Labels[key] = value
+		}
+		limit := defaultAgentsPageSize
+		if raw := query.Get("limit"); raw != "" {
+			if v, err := strconv.Atoi(raw); err == nil && v > 0 {
+				limit = min(v, maxAgentsPageSize)
+			}
+		}
+		// Fetch one extra row to learn whether another page follows.
+		filter.Limit = limit + 1
+
+		agents, err := deps.Store.ListAgents(r.Context(), filter)
+		if err != nil {
+			deps.Logger.Printf("list agents failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		var next string
+		if len(agents) > limit {
+			agents = agents[:limit]
+			next = agents[limit-1].AgentID
+		}
+		if agents == nil {
+			agents = []store.AgentSummary{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items     []store.AgentSummary `json:"items"`
+			NextAfter string               `json:"next_after,omitempty"`
+		}{Items: agents, NextAfter: next})
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminListAgentsFiltersAndPaginates(t *testing.T) {
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	ready, notReady := true, false
+	for _, hb := range []store.Heartbeat{
+		{AgentID: "agt_1", ReceivedAt: now, Labels: map[string]string{"site": "ATL-1"}, Ready: &ready},
+		{AgentID: "agt_2", ReceivedAt: now, Labels: map[string]string{"site": "ATL-1"}, Ready: &notReady, ReadyReason: "queue full"},
+		{AgentID: "agt_3", ReceivedAt: now.Add(-time.Hour), Labels: map[string]string{"site": "SFO-1"}, Ready: &ready},
+	} {
+		if err := st.RecordHeartbeat(ctx, hb); err != nil {
+			t.Fatalf("RecordHeartbeat: %v", err)
+		}
+	}
+	if err := st.RecordUpgradeReport(ctx, store.UpgradeReport{AgentID: "agt_1", CurrentVersion: "1.4.0", Channel: "canary", Status: "success", CompletedAt: now}); err != nil {
+		t.Fatalf("RecordUpgradeReport: %v", err)
+	}
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	list := func(query string) ([]store.AgentSummary, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/agents"+query, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("list %q: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Items     []store.AgentSummary `json:"items"`
+			NextAfter string               `json:"next_after"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp.Items, resp.NextAfter
+	}
+
+	page, next := list("?limit=2")
+	if len(page) != 2 || page[0].AgentID != "agt_1" || next != "agt_2" {
+		t.Fatalf("first page %+v next %q", page, next)
+	}
+	if page[0].Version != "1.4.0" || page[0].Channel != "canary" || page[0].Status != store.AgentStatusReady {
+		t.Fatalf("unexpected agt_1 row %+v", page[0])
+	}
+	if page[1].Version != store.UnknownAgentVersion || page[1].Status != store.AgentStatusNotReady || page[1].ReadyReason != "queue full" {
+		t.Fatalf("unexpected agt_2 row %+v", page[1])
+	}
+	page, next = list("?limit=2&after=agt_2")
+	if len(page) != 1 || page[0].AgentID != "agt_3" || page[0].Status != store.AgentStatusStale || next != "" {
+		t.Fatalf("second page %+v next %q", page, next)
+	}
+
+	if page, _ = list("?label=site=ATL-1&status=ready"); len(page) != 1 || page[0].AgentID != "agt_1" {
+		t.Fatalf("filtered page %+v", page)
+	}
+	if page, _ = list("?version=unknown"); len(page) != 2 {
+		t.Fatalf("version filter %+v", page)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/agents?status=asleep", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid status: got %d", rec.Code)
+	}
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"sort"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/bundle"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	catalogRoute        = "/api/admin/v1/catalog/monitors"
+	catalogMonitorRoute = catalogRoute + "/{monitor_id}"
+)
+
+// agentRevision reports the snapshot revision an admin change left an agent on.
+type agentRevision struct {
+	AgentID  string `json:"agent_id"`
+	Revision string `json:"revision"`
+}
+
+// catalogChange is the response to catalog writes: the monitor as stored (nil
+// after a delete) and the agents whose snapshots were republished.
+type catalogChange struct {
+	Monitor *store.CatalogMonitor `json:"monitor,omitempty"`
+	Agents  []agentRevision       `json:"agents"`
+}
+
+func adminListCatalogHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		catalog, err := deps.Store.ListCatalogMonitors(r.Context())
+		if err != nil {
+			deps.Logger.Printf("list monitor catalog failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items []store.CatalogMonitor `json:"items"`
+		}{Items: catalog})
+	}
+}
+
+func adminGetCatalogMonitorHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		m, err := deps.Store.GetCatalogMonitor(r.Context(), mux.Vars(r)["monitor_id"])
+		if err != nil {
+			writeCatalogError(w, deps, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(m)
+	}
+}
+
+// adminPutCatalogMonitorHandler creates or replaces a catalog monitor and
+// publishes new snapshot revisions for every agent it was or now is assigned to.
+func adminPutCatalogMonitorHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["monitor_id"]
+		var req store.CatalogMonitor
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if req.MonitorID != "" && req.MonitorID != id {
+			http.Error(w, "monitor_id does not match the path", http.StatusBadRequest)
+			return
+		}
+		req.MonitorID = id
+		if problems := bundle.LintMonitor("", bundle.Monitor{
+			ID:            req.MonitorID,
+			Protocol:      req.Protocol,
+			Targets:       req.Targets,
+			CadenceMillis: req.CadenceMillis,
+			TimeoutMillis: req.TimeoutMillis,
+		}); len(problems) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(struct {
+				Problems []bundle.Problem `json:"problems"`
+			}{Problems: problems})
+			return
+		}
+		if err := store.ValidateCatalogMonitor(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		previous, err := deps.Store.GetCatalogMonitor(r.Context(), id)
+		created := errors.Is(err, store.ErrMonitorNotFound)
+		if err != nil && !created {
+			writeCatalogError(w, deps, err)
+			return
+		}
+		saved, err := deps.Store.SaveCatalogMonitor(r.Context(), req)
+		if err != nil {
+			deps.Logger.Printf("save catalog monitor %s failed: %v", id, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		agents, err := republishCatalog(r.Context(), deps, hub, func(agentID string, labels map[string]string) bool {
+			return saved.Assigned(agentID, labels) || (!created && previous.Assigned(agentID, labels))
+		}, slices.Concat(previous.Agents, saved.Agents)...)
+		if err != nil {
+			deps.Logger.Printf("publish catalog monitor %s failed: %v", id, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		deps.Logger.Printf("admin saved catalog monitor %s: agents=%d", id, len(agents))
+
+		w.Header().Set("Content-Type", "application/json")
+		if created {
+			w.WriteHeader(http.StatusCreated)
+		}
+		_ = json.NewEncoder(w).Encode(catalogChange{Monitor: &saved, Agents: agents})
+	}
+}
+
+// adminDeleteCatalogMonitorHandler removes a catalog monitor and publishes
+// snapshots without it for the agents that ran it.
+func adminDeleteCatalogMonitorHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["monitor_id"]
+		previous, err := deps.Store.GetCatalogMonitor(r.Context(), id)
+		if err != nil {
+			writeCatalogError(w, deps, err)
+			return
+		}
+		if err := deps.Store.DeleteCatalogMonitor(r.Context(), id); err != nil {
+			writeCatalogError(w, deps, err)
+			return
+		}
+
+		agents, err := republishCatalog(r.Context(), deps, hub, previous.Assigned, previous.Agents...)
+		if err != nil {
+			deps.Logger.Printf("publish catalog after deleting %s failed: %v", id, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		deps.Logger.Printf("admin deleted catalog monitor %s: agents=%d", id, len(agents))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(catalogChange{Agents: agents})
+	}
+}
+
+// republishCatalog publishes the catalog-derived monitors of every known
+// agent for which affected returns true, plus the named agents, and wakes
+// their streams. Monitors published by bundles or directly are kept; sets
+// that did not change keep their revision.
+func republishCatalog(ctx context.Context, deps Dependencies, hub *snapshotHub, affected func(agentID string, labels map[string]string) bool, named ...string) ([]agentRevision, error) {
+	catalog, err := deps.Store.ListCatalogMonitors(ctx)
+	if err != nil {
+		return nil, err
+	}
+	labels, err := deps.Store.ListAgentLabels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	desired := store.ResolveCatalog(catalog, labels)
+
+	snapshots := map[string][]store.MonitorAssignment{}
+	for agentID, agentLabels := range labels {
+		if affected(agentID, agentLabels) {
+			snapshots[agentID] = desired[agentID]
+		}
+	}
+	// Agents named explicitly may not have sent a heartbeat yet.
+	for _, agentID := range named {
+		snapshots[agentID] = desired[agentID]
+	}
+	agents := []agentRevision{}
+	if len(snapshots) == 0 {
+		return agents, nil
+	}
+	if err := mergeOwnedMonitors(ctx, deps, snapshots, true); err != nil {
+		return nil, err
+	}
+
+	applied, err := deps.Store.ApplyMonitorSnapshots(ctx, snapshots)
+	if err != nil {
+		return nil, err
+	}
+	for agentID, snapshot := range applied {
+		hub.notify(agentID)
+		agents = append(agents, agentRevision{AgentID: agentID, Revision: snapshot.Revision})
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].AgentID < agents[j].AgentID })
+	return agents, nil
+}
+
+// syncCatalogLabels republishes an agent's catalog monitors after its
+// heartbeat labels change, so label selectors follow agents as they join or
+// leave a group.
+func syncCatalogLabels(ctx context.Context, deps Dependencies, hub *snapshotHub, hb store.Heartbeat, previous map[string]string) {
+	if maps.Equal(previous, hb.Labels) {
+		return
+	}
+	catalog, err := deps.Store.ListCatalogMonitors(ctx)
+	if err != nil {
+		deps.Logger.Printf("load monitor catalog for agent %s failed: %v", hb.AgentID, err)
+		return
+	}
+	var selected bool
+	for _, m := range catalog {
+		if len(m.Selector) > 0 && (m.Assigned(hb.AgentID, previous) || m.Assigned(hb.AgentID, hb.Labels)) {
+			selected = true
+			break
+		}
+	}
+	if !selected {
+		return
+	}
+	if _, err := republishCatalog(ctx, deps, hub, func(agentID string, _ map[string]string) bool {
+		return agentID == hb.AgentID
+	}); err != nil {
+		deps.Logger.Printf("publish catalog monitors for agent %s failed: %v", hb.AgentID, err)
+	}
+}
+
+// mergeOwnedMonitors folds each agent's current monitors owned by the other
+// side (the catalog, or bundles and direct publishes) into snapshots, so a
+// publish only replaces its own monitors.
+func mergeOwnedMonitors(ctx context.Context, deps Dependencies, snapshots map[string][]store.MonitorAssignment, catalog bool) error {
+	for agentID, owned := range snapshots {
+		current, err := deps.Store.GetMonitorSnapshot(ctx, agentID, "")
+		if err != nil && !errors.Is(err, store.ErrSnapshotNotFound) {
+			return err
+		}
+		snapshots[agentID] = store.MergeMonitors(current.Monitors, owned, catalog)
+	}
+	return nil
+}
+
+func writeCatalogError(w http.ResponseWriter, deps Dependencies, err error) {
+	if errors.Is(err, store.ErrMonitorNotFound) {
+		http.Error(w, "monitor not found", http.StatusNotFound)
+		return
+	}
+	deps.Logger.Printf("monitor catalog request failed: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminMonitorCatalogPublishesSnapshots(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	})
+
+	do := func(method, path, agentID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if agentID != "" {
+			req.Header.Set("X-Agent-ID", agentID)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	heartbeat := func(agentID, site string) {
+		body := `{"labels":{"site":"` + site + `"}}`
+		if rec := do(http.MethodPost, "/api/agent/v1/heartbeat", agentID, body); rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat %s: status %d", agentID, rec.Code)
+		}
+	}
+	monitorIDs := func(agentID string) []string {
+		rec := do(http.MethodGet, "/api/agent/v1/monitors", agentID, "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("monitors for %s: status %d", agentID, rec.Code)
+		}
+		var payload agentSnapshotPayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("decode snapshot: %v", err)
+		}
+		ids := []string{}
+		for _, m := range payload.Monitors {
+			ids = append(ids, m.MonitorID)
+		}
+		return ids
+	}
+	save := func(body string, wantStatus int) catalogChange {
+		rec := do(http.MethodPut, "/api/admin/v1/catalog/monitors/mon_web", "", body)
+		if rec.Code != wantStatus {
+			t.Fatalf("put status %d, want %d: %s", rec.Code, wantStatus, rec.Body.String())
+		}
+		var change catalogChange
+		if err := json.Unmarshal(rec.Body.Bytes(), &change); err != nil {
+			t.Fatalf("decode change: %v", err)
+		}
+		return change
+	}
+
+	heartbeat("agt_atl", "ATL-1")
+	heartbeat("agt_sfo", "SFO-1")
+
+	change := save(`{"protocol":"tcp","targets":["203.0.113.8:443"],"cadence_ms":5000,"agents":["agt_new"],"selector":{"site":"ATL-1"}}`, http.StatusCreated)
+	if len(change.Agents) != 2 || change.Agents[0].AgentID != "agt_atl" || change.Agents[1].AgentID != "agt_new" {
+		t.Fatalf("unexpected agents %+v", change.Agents)
+	}
+	if got := monitorIDs("agt_atl"); len(got) != 1 || got[0] != "mon_web" {
+		t.Fatalf("agt_atl monitors %v", got)
+	}
+
+	// An agent that moves into the selected site picks the monitor up.
+	heartbeat("agt_sfo", "ATL-1")
+	if got := monitorIDs("agt_sfo"); len(got) != 1 || got[0] != "mon_web" {
+		t.Fatalf("agt_sfo monitors after relabel %v", got)
+	}
+
+	change = save(`{"protocol":"tcp","targets":["203.0.113.8:443"],"cadence_ms":10000,"selector":{"site":"ATL-1"}}`, http.StatusOK)
+	if len(change.Agents) != 3 || change.Agents[0].Revision != "2" || change.Monitor.CadenceMillis != 10000 {
+		t.Fatalf("unexpected update %+v", change)
+	}
+	if got := monitorIDs("agt_new"); len(got) != 0 {
+		t.Fatalf("agt_new should lose the monitor, got %v", got)
+	}
+
+	if rec := do(http.MethodPut, "/api/admin/v1/catalog/monitors/mon_web", "", `{"protocol":"smtp","targets":[],"cadence_ms":0}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("invalid monitor status %d", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/api/admin/v1/catalog/monitors", "", "")
+	var list struct {
+		Items []store.CatalogMonitor `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Items) != 1 || list.Items[0].Selector["site"] != "ATL-1" {
+		t.Fatalf("unexpected catalog %s (%v)", rec.Body.String(), err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/admin/v1/catalog/monitors/mon_web", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete status %d", rec.Code)
+	}
+	if got := monitorIDs("agt_atl"); len(got) != 0 {
+		t.Fatalf("agt_atl monitors after delete %v", got)
+	}
+	if rec := do(http.MethodDelete, "/api/admin/v1/catalog/monitors/mon_web", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("second delete status %d", rec.Code)
+	}
+}
+
+func TestAdminMonitorCatalogKeepsBundleMonitors(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	})
+	do := func(method, path, agentID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if agentID != "" {
+			req.Header.Set("X-Agent-ID", agentID)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	monitorIDs := func(agentID string) string {
+		rec := do(http.MethodGet, "/api/agent/v1/monitors", agentID, "")
+		var payload agentSnapshotPayload
+		if err := json.Unmarshal(rec.Body.Bytes(), &payload); err != nil {
+			t.Fatalf("decode snapshot: %v", err)
+		}
+		ids := []string{}
+		for _, m := range payload.Monitors {
+			ids = append(ids, m.MonitorID)
+		}
+		return strings.Join(ids, ",")
+	}
+	heartbeat := func(site string) {
+		if rec := do(http.MethodPost, "/api/agent/v1/heartbeat", "agt_atl", `{"labels":{"site":"`+site+`"}}`); rec.Code != http.StatusOK {
+			t.Fatalf("heartbeat status %d", rec.Code)
+		}
+	}
+
+	heartbeat("ATL-1")
+	bundle := `
+apiVersion: pingsanto.io/v1
+kind: MonitorBundle
+monitors:
+  - id: mon_dns
+    protocol: icmp
+    targets: ["1.1.1.1"]
+    cadence_ms: 3000
+assignments:
+  - agents: [agt_atl]
+    monitors: [mon_dns]
+`
+	if rec := do(http.MethodPost, "/api/admin/v1/monitors/bundles", "", bundle); rec.Code != http.StatusOK {
+		t.Fatalf("bundle status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPut, "/api/admin/v1/catalog/monitors/mon_web", "", `{"protocol":"tcp","targets":["203.0.113.8:443"],"cadence_ms":5000,"selector":{"site":"ATL-1"}}`); rec.Code != http.StatusCreated {
+		t.Fatalf("catalog put status %d", rec.Code)
+	}
+	if got := monitorIDs("agt_atl"); got != "mon_dns,mon_web" {
+		t.Fatalf("monitors after catalog put %q", got)
+	}
+
+	// Leaving the selected site drops only the catalog monitor.
+	heartbeat("SFO-1")
+	if got := monitorIDs("agt_atl"); got != "mon_dns" {
+		t.Fatalf("monitors after relabel %q", got)
+	}
+	heartbeat("ATL-1")
+	if got := monitorIDs("agt_atl"); got != "mon_dns,mon_web" {
+		t.Fatalf("monitors after rejoining %q", got)
+	}
+
+	// Re-applying the bundle keeps the catalog monitor too.
+	if rec := do(http.MethodPost, "/api/admin/v1/monitors/bundles", "", strings.Replace(bundle, "3000", "6000", 1)); rec.Code != http.StatusOK {
+		t.Fatalf("bundle status %d", rec.Code)
+	}
+	if got := monitorIDs("agt_atl"); got != "mon_dns,mon_web" {
+		t.Fatalf("monitors after bundle update %q", got)
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	defaultHistoryPageSize      = 100
+	defaultAgentHistoryPageSize = 50
+	maxHistoryPageSize          = 1000
+)
+
+// adminFleetHistoryHandler serves upgrade reports across the fleet, newest
+// first, filtered by agent_id, status, version, channel and a since/until
+// completion range (RFC 3339). Pass the response's next_cursor as cursor to
+// fetch the following page.
+func adminFleetHistoryHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := store.HistoryFilter{
+			AgentID: query.Get("agent_id"),
+			Status:  query.Get("status"),
+			Version: query.Get("version"),
+			Channel: query.Get("channel"),
+		}
+		for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			raw := query.Get(name)
+			if raw == "" {
+				continue
+			}
+			ts, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %q (want RFC 3339)", name, raw), http.StatusBadRequest)
+				return
+			}
+			*dst = ts
+		}
+		limit, err := pageHistory(query, &filter, defaultHistoryPageSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reports, err := deps.Store.ListUpgradeHistory(r.Context(), filter)
+		if err != nil {
+			deps.Logger.Printf("list fleet history failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		reports, next := historyPage(reports, limit)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items      []store.UpgradeReport `json:"items"`
+			NextCursor string                `json:"next_cursor,omitempty"`
+		}{Items: reports, NextCursor: next})
+	}
+}
+
+// adminHistoryHandler serves one agent's upgrade reports, newest first, a
+// page at a time. Pass the response's next_cursor as cursor to fetch the
+// following page.
+func adminHistoryHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := mux.Vars(r)["agent_id"]
+		if agentID == "" {
+			http.Error(w, "agent_id required", http.StatusBadRequest)
+			return
+		}
+		filter := store.HistoryFilter{AgentID: agentID}
+		limit, err := pageHistory(r.URL.Query(), &filter, defaultAgentHistoryPageSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reports, err := deps.Store.ListUpgradeHistory(r.Context(), filter)
+		if err != nil {
+			deps.Logger.Printf("list history failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		reports, next := historyPage(reports, limit)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			AgentID    string                `json:"agent_id"`
+			Items      []store.UpgradeReport `json:"items"`
+			NextCursor string                `json:"next_cursor,omitempty"`
+		}{AgentID: agentID, Items: reports, NextCursor: next})
+	}
+}
+
+// pageHistory applies the cursor and limit query parameters to filter and
+// returns the page size. The filter asks for one extra row so historyPage
+// can tell whether another page follows.
+func pageHistory(query url.Values, filter *store.HistoryFilter, defaultLimit int) (int, error) {
+	if raw := query.Get("cursor"); raw != "" {
+		cursor, err := store.ParseHistoryCursor(raw)
+		if err != nil {
+			return 0, err
+		}
+		filter.After = &cursor
+	}
+	limit := defaultLimit
+	if raw := query.Get("limit"); raw != "" {
+		if v, err := strconv.Atoi(raw); err == nil && v > 0 {
+			limit = min(v, maxHistoryPageSize)
+		}
+	}
+	filter.Limit = limit + 1
+	return limit, nil
+}
+
+// historyPage trims reports fetched by a pageHistory filter to limit and
+// returns the cursor of the next page, or "" on the last page.
+func historyPage(reports []store.UpgradeReport, limit int) ([]store.UpgradeReport, string) {
+	var next string
+	if len(reports) > limit {
+		reports = reports[:limit]
+		next = reports[limit-1].Cursor().String()
+	}
+	if reports == nil {
+		reports = []store.UpgradeReport{}
+	}
+	return reports, next
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminFleetHistoryFiltersAndPaginates(t *testing.T) {
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		status := "success"
+		if i%2 == 1 {
+			status = "failed"
+		}
+		report := store.UpgradeReport{
+			AgentID:        fmt.Sprintf("agt_%d", i),
+			CurrentVersion: "1.4.2",
+			Channel:        "stable",
+			Status:         status,
+			// Two reports share each timestamp so pages must break ties.
+			CompletedAt: base.Add(time.Duration(i/2) * time.Minute),
+		}
+		if err := st.RecordUpgradeReport(ctx, report); err != nil {
+			t.Fatalf("RecordUpgradeReport: %v", err)
+		}
+	}
+	if err := st.RecordUpgradeReport(ctx, store.UpgradeReport{AgentID: "agt_9", CurrentVersion: "1.4.1", Channel: "stable", Status: "failed", CompletedAt: base}); err != nil {
+		t.Fatalf("RecordUpgradeReport: %v", err)
+	}
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	list := func(query url.Values, wantStatus int) ([]string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/upgrade/history?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != wantStatus {
+			t.Fatalf("history %v: status %d, want %d: %s", query, rec.Code, wantStatus, rec.Body.String())
+		}
+		if wantStatus != http.StatusOK {
+			return nil, ""
+		}
+		var resp struct {
+			Items      []store.UpgradeReport `json:"items"`
+			NextCursor string                `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		agents := []string{}
+		for _, r := range resp.Items {
+			agents = append(agents, r.AgentID)
+		}
+		return agents, resp.NextCursor
+	}
+
+	if agents, _ := list(url.Values{"status": {"failed"}, "version": {"1.4.2"}}, http.StatusOK); fmt.Sprint(agents) != "[agt_3 agt_1]" {
+		t.Fatalf("failed 1.4.2 reports %v", agents)
+	}
+
+	var all []string
+	query := url.Values{"version": {"1.4.2"}, "limit": {"2"}}
+	for page := 0; ; page++ {
+		if page > 5 {
+			t.Fatalf("pagination did not terminate: %v", all)
+		}
+		agents, next := list(query, http.StatusOK)
+		all = append(all, agents...)
+		if next == "" {
+			break
+		}
+		query.Set("cursor", next)
+	}
+	if fmt.Sprint(all) != "[agt_4 agt_3 agt_2 agt_1 agt_0]" {
+		t.Fatalf("paged history %v", all)
+	}
+
+	window := url.Values{"since": {base.Add(time.Minute).Format(time.RFC3339)}, "until": {base.Add(2 * time.Minute).Format(time.RFC3339)}}
+	if agents, _ := list(window, http.StatusOK); fmt.Sprint(agents) != "[agt_3 agt_2]" {
+		t.Fatalf("time window %v", agents)
+	}
+	list(url.Values{"since": {"yesterday"}}, http.StatusBadRequest)
+	list(url.Values{"cursor": {"%%%"}}, http.StatusBadRequest)
+}
+
+func TestAdminAgentHistoryPaginates(t *testing.T) {
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+	base := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		report := store.UpgradeReport{AgentID: "agt_1", CurrentVersion: fmt.Sprintf("1.4.%d", i), Status: "success", CompletedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := st.RecordUpgradeReport(ctx, report); err != nil {
+			t.Fatalf("RecordUpgradeReport: %v", err)
+		}
+	}
+	if err := st.RecordUpgradeReport(ctx, store.UpgradeReport{AgentID: "agt_2", CurrentVersion: "9.9.9", Status: "success", CompletedAt: base}); err != nil {
+		t.Fatalf("RecordUpgradeReport: %v", err)
+	}
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	get := func(query url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/upgrade/history/agt_1?"+query.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var versions []string
+	query := url.Values{"limit": {"2"}}
+	for page := 0; ; page++ {
+		if page > 5 {
+			t.Fatalf("pagination did not terminate: %v", versions)
+		}
+		rec := get(query)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("history: status %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			AgentID    string                `json:"agent_id"`
+			Items      []store.UpgradeReport `json:"items"`
+			NextCursor string                `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		if resp.AgentID != "agt_1" || len(resp.Items) > 2 {
+			t.Fatalf("unexpected page %+v", resp)
+		}
+		for _, r := range resp.Items {
+			versions = append(versions, r.CurrentVersion)
+		}
+		if resp.NextCursor == "" {
+			break
+		}
+		query.Set("cursor", resp.NextCursor)
+	}
+	if fmt.Sprint(versions) != "[1.4.4 1.4.3 1.4.2 1.4.1 1.4.0]" {
+		t.Fatalf("paged history %v", versions)
+	}
+
+	if rec := get(url.Values{"cursor": {"bogus"}}); rec.Code != http.StatusBadRequest {
+		t.Fatalf("bad cursor: status %d", rec.Code)
+	}
+}
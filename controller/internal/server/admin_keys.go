@@ -0,0 +1,158 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+// adminKeyPrefix marks controller-issued admin API keys so they are easy to
+// spot in secret scanners.
+const adminKeyPrefix = "psk_"
+
+type createAdminKeyResponse struct {
+	// Key is the secret to send in the X-API-Key header. It is only returned
+	// here; the controller keeps a hash.
+	Key string `json:"key"`
+	store.AdminKey
+}
+
+// adminKeyLookup resolves hashed API keys created through the admin API.
+// Store failures are logged rather than echoed to the caller.
+func adminKeyLookup(deps Dependencies) func(ctx context.Context, hash string) (auth.Principal, error) {
+	return func(ctx context.Context, hash string) (auth.Principal, error) {
+		if deps.Store == nil {
+			return auth.Principal{}, auth.ErrNoCredentials
+		}
+		key, err := deps.Store.LookupAdminKey(ctx, hash)
+		switch {
+		case errors.Is(err, store.ErrAdminKeyNotFound):
+			return auth.Principal{}, auth.ErrNoCredentials
+		case err != nil:
+			if deps.Logger != nil {
+				deps.Logger.Printf("admin key lookup failed: %v", err)
+			}
+			return auth.Principal{}, errors.New("unable to verify API key")
+		case key.RevokedAt != nil:
+			return auth.Principal{}, errors.New("API key revoked")
+		}
+		return auth.Principal{Subject: key.Name, Role: auth.RoleAdmin, Scheme: "api_key", Scopes: key.Scopes}, nil
+	}
+}
+
+// adminCreateKeyHandler mints a scoped admin API key and returns its secret
+// once.
+func adminCreateKeyHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+			// Role grants the scopes of an admin role; Scopes lists them
+			// directly. Exactly one is required.
+			Role   string   `json:"role"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		key := store.AdminKey{Name: strings.TrimSpace(req.Name)}
+		if key.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		key.Role = strings.ToLower(strings.TrimSpace(req.Role))
+		switch {
+		case key.Role != "" && len(req.Scopes) > 0:
+			http.Error(w, "set either role or scopes, not both", http.StatusBadRequest)
+			return
+		case key.Role != "":
+			scopes, ok := auth.RoleScopes(key.Role)
+			if !ok {
+				http.Error(w, fmt.Sprintf("invalid role %q (want %s)", key.Role, strings.Join(auth.AdminRoles, ", ")), http.StatusBadRequest)
+				return
+			}
+			req.Scopes = scopes
+		case len(req.Scopes) == 0:
+			http.Error(w, fmt.Sprintf("role (%s) or scopes (any of %s) required", strings.Join(auth.AdminRoles, ", "), strings.Join(auth.Scopes, ", ")), http.StatusBadRequest)
+			return
+		}
+		for _, scope := range req.Scopes {
+			scope = strings.ToLower(strings.TrimSpace(scope))
+			if !auth.ValidScope(scope) {
+				http.Error(w, fmt.Sprintf("invalid scope %q (want any of %s)", scope, strings.Join(auth.Scopes, ", ")), http.StatusBadRequest)
+				return
+			}
+			if !slices.Contains(key.Scopes, scope) {
+				key.Scopes = append(key.Scopes, scope)
+			}
+		}
+		if p, ok := auth.FromContext(r.Context()); ok {
+			key.CreatedBy = p.Subject
+		}
+
+		secret, err := newEnrollmentSecret()
+		if err != nil {
+			deps.Logger.Printf("generate admin key failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		secret = adminKeyPrefix + secret
+		created, err := deps.Store.CreateAdminKey(r.Context(), key, auth.HashKey(secret))
+		if err != nil {
+			deps.Logger.Printf("create admin key failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		deps.Logger.Printf("admin key %s (%s) created by %s with scopes %s", created.ID, created.Name, created.CreatedBy, strings.Join(created.Scopes, ","))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(createAdminKeyResponse{Key: secret, AdminKey: created})
+	}
+}
+
+func adminListKeysHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keys, err := deps.Store.ListAdminKeys(r.Context())
+		if err != nil {
+			deps.Logger.Printf("list admin keys failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if keys == nil {
+			keys = []store.AdminKey{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items []store.AdminKey `json:"items"`
+		}{Items: keys})
+	}
+}
+
+// adminRevokeKeyHandler revokes an admin API key; requests made with it are
+// rejected from then on.
+func adminRevokeKeyHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key, err := deps.Store.RevokeAdminKey(r.Context(), mux.Vars(r)["key_id"])
+		if err != nil {
+			if errors.Is(err, store.ErrAdminKeyNotFound) {
+				http.Error(w, "admin key not found", http.StatusNotFound)
+				return
+			}
+			deps.Logger.Printf("revoke admin key failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		deps.Logger.Printf("admin key %s (%s) revoked", key.ID, key.Name)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(key)
+	}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminKeysAreScopedAndRevocable(t *testing.T) {
+	st := store.NewMemoryStore()
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	create := func(body string) createAdminKeyResponse {
+		rec := do(http.MethodPost, "/api/admin/v1/keys", "", body)
+		if rec.Code != http.StatusCreated {
+			t.Fatalf("create key: status %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp createAdminKeyResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp
+	}
+
+	if rec := do(http.MethodPost, "/api/admin/v1/keys", "", `{"name":"ci","scopes":["everything"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid scope: expected 400, got %d", rec.Code)
+	}
+	release := create(`{"name":"release","scopes":["plans","artifacts"]}`)
+	auditor := create(`{"name":"auditor","scopes":["read"]}`)
+	if !strings.HasPrefix(release.Key, adminKeyPrefix) || release.CreatedBy != "admin" {
+		t.Fatalf("unexpected key %+v", release)
+	}
+
+	const plan = `{"channel":"stable","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`
+	for _, tc := range []struct {
+		name, method, path, key, body string
+		want                          int
+	}{
+		{"release pushes plans", http.MethodPost, "/api/admin/v1/upgrade/plan", release.Key, plan, http.StatusOK},
+		{"release reads history", http.MethodGet, "/api/admin/v1/upgrade/history", release.Key, "", http.StatusOK},
+		{"release cannot change settings", http.MethodPost, "/api/admin/v1/settings/notifications", release.Key, `{"notify_on_publish":false}`, http.StatusForbidden},
+		{"auditor reads plans", http.MethodGet, "/api/admin/v1/upgrade/plans", auditor.Key, "", http.StatusOK},
+		{"auditor cannot push plans", http.MethodPost, "/api/admin/v1/upgrade/plan", auditor.Key, plan, http.StatusForbidden},
+		{"only admins manage keys", http.MethodGet, "/api/admin/v1/keys", release.Key, "", http.StatusForbidden},
+		{"unknown key", http.MethodGet, "/api/admin/v1/upgrade/plans", "psk_unknown", "", http.StatusUnauthorized},
+	} {
+		if rec := do(tc.method, tc.path, tc.key, tc.body); rec.Code != tc.want {
+			t.Fatalf("%s: expected %d, got %d: %s", tc.name, tc.want, rec.Code, rec.Body.String())
+		}
+	}
+
+	if rec := do(http.MethodDelete, "/api/admin/v1/keys/"+release.ID, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("revoke: status %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/api/admin/v1/upgrade/plans", release.Key, ""); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: expected 401, got %d", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/admin/v1/keys/key_99", "", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown key id: expected 404, got %d", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/api/admin/v1/keys", "", "")
+	if strings.Contains(rec.Body.String(), release.Key) || strings.Contains(rec.Body.String(), auditor.Key) {
+		t.Fatalf("key listing leaks secrets: %s", rec.Body.String())
+	}
+	var list struct {
+		Items []store.AdminKey `json:"items"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list.Items) != 2 || list.Items[1].RevokedAt == nil {
+		t.Fatalf("unexpected listing %s (%v)", rec.Body.String(), err)
+	}
+}
+
+func TestAdminRolesGateRoutes(t *testing.T) {
+	cfg := Config{
+		AdminBearerToken: "token",
+		AdminAPIKeys:     []auth.APIKey{{Name: "audit", Key: "audit-key", Scopes: []string{auth.ScopeRead}}},
+	}
+	srv := New(cfg, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	keyFor := func(role string) string {
+		rec := do(http.MethodPost, "/api/admin/v1/keys", "", `{"name":"`+role+`","role":"`+role+`"}`)
+		var resp createAdminKeyResponse
+		if rec.Code != http.StatusCreated || json.Unmarshal(rec.Body.Bytes(), &resp) != nil || resp.Role != role {
+			t.Fatalf("create %s key: status %d: %s", role, rec.Code, rec.Body.String())
+		}
+		return resp.Key
+	}
+	viewer, operator, admin := keyFor(auth.AdminRoleViewer), keyFor(auth.AdminRoleOperator), keyFor(auth.AdminRoleAdmin)
+	if rec := do(http.MethodPost, "/api/admin/v1/keys", "", `{"name":"x","role":"root"}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("unknown role: expected 400, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/keys", "", `{"name":"x","role":"viewer","scopes":["plans"]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("role and scopes: expected 400, got %d", rec.Code)
+	}
+
+	const plan = `{"channel":"stable","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`
+	const settings = `{"notify_on_publish":false}`
+	for _, tc := range []struct {
+		key, method, path, body string
+		want                    int
+	}{
+		{viewer, http.MethodGet, "/api/admin/v1/upgrade/history", "", http.StatusOK},
+		{viewer, http.MethodPost, "/api/admin/v1/upgrade/plan", plan, http.StatusForbidden},
+		{"audit-key", http.MethodGet, "/api/admin/v1/upgrade/history/agt_1", "", http.StatusOK},
+		{"audit-key", http.MethodPost, "/api/admin/v1/upgrade/plan", plan, http.StatusForbidden},
+		{operator, http.MethodPost, "/api/admin/v1/upgrade/plan", plan, http.StatusOK},
+		{operator, http.MethodPost, "/api/admin/v1/settings/notifications", settings, http.StatusForbidden},
+		{operator, http.MethodGet, "/api/admin/v1/keys", "", http.StatusForbidden},
+		{admin, http.MethodPost, "/api/admin/v1/settings/notifications", settings, http.StatusOK},
+		{admin, http.MethodGet, "/api/admin/v1/keys", "", http.StatusOK},
+	} {
+		if rec := do(tc.method, tc.path, tc.key, tc.body); rec.Code != tc.want {
+			t.Fatalf("%s %s with %s: expected %d, got %d", tc.method, tc.path, tc.key, tc.want, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	defaultPlansPageSize = 100
+	maxPlansPageSize     = 1000
+)
+
+// adminListPlansHandler serves the stored upgrade plans, filtered by kind
+// (agent or channel), channel, version and paused, one page at a time. Pass
+// the response's next_after as after to fetch the following page.
+func adminListPlansHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := store.PlanFilter{
+			Kind:    query.Get("kind"),
+			Channel: query.Get("channel"),
+			Version: query.Get("version"),
+			After:   query.Get("after"),
+		}
+		if filter.Kind != "" && filter.Kind != store.PlanKindAgent && filter.Kind != store.PlanKindChannel {
+			http.Error(w, fmt.Sprintf("invalid kind %q (want %s or %s)", filter.Kind, store.PlanKindAgent, store.PlanKindChannel), http.StatusBadRequest)
+			return
+		}
+		if raw := query.Get("paused"); raw != "" {
+			paused, err := strconv.ParseBool(raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid paused %q", raw), http.StatusBadRequest)
+				return
+			}
+			filter.Paused = &paused
+		}
+		limit := defaultPlansPageSize
+		if raw := query.Get("limit"); raw != "" {
+			if v, err := strconv.Atoi(raw); err == nil && v > 0 {
+				limit = min(v, maxPlansPageSize)
+			}
+		}
+		// Fetch one extra row to learn whether another page follows.
+		filter.Limit = limit + 1
+
+		plans, err := deps.Store.ListUpgradePlans(r.Context(), filter)
+		if err != nil {
+			deps.Logger.Printf("list plans failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		var next string
+		if len(plans) > limit {
+			plans = plans[:limit]
+			next = plans[limit-1].AgentID
+		}
+		if plans == nil {
+			plans = []store.UpgradePlanResponse{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items     []store.UpgradePlanResponse `json:"items"`
+			NextAfter string                      `json:"next_after,omitempty"`
+		}{Items: plans, NextAfter: next})
+	}
+}
+
+// adminGetPlanHandler serves the plan stored under key, an agent ID or
+// channel:<name>, with its ETag for a conditional upsert.
+func adminGetPlanHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		plan, etag, err := deps.Store.GetUpgradePlan(r.Context(), mux.Vars(r)["key"])
+		if err != nil {
+			writePlanError(w, deps, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		_ = json.NewEncoder(w).Encode(plan)
+	}
+}
+
+// adminDeletePlanHandler retires the plan stored under key, an agent ID or
+// channel:<name>. Agents that relied on it get 404 from the plan endpoint
+// (or fall back to their channel plan), and a rollout of a deleted channel
+// plan is superseded.
+func adminDeletePlanHandler(cfg Config, deps Dependencies, rollouts *rolloutManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := mux.Vars(r)["key"]
+		if err := deps.Store.DeleteUpgradePlan(r.Context(), key); err != nil {
+			writePlanError(w, deps, err)
+			return
+		}
+		deps.Logger.Printf("admin deleted plan %s", key)
+		if channel, ok := strings.CutPrefix(key, "channel:"); ok {
+			if _, err := rollouts.evaluate(r.Context(), channel); err != nil && !errors.Is(err, store.ErrRolloutNotFound) {
+				deps.Logger.Printf("evaluate rollout after deleting plan %s failed: %v", key, err)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminListPlansFiltersAndPaginates(t *testing.T) {
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+	for _, input := range []store.PlanInput{
+		{Channel: "stable", Version: "1.4.0"},
+		{Channel: "canary", Version: "1.5.0", Paused: true},
+		{AgentID: "agt_1", Channel: "stable", Version: "1.5.0"},
+	} {
+		if _, _, err := st.UpsertUpgradePlan(ctx, input); err != nil {
+			t.Fatalf("UpsertUpgradePlan: %v", err)
+		}
+	}
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	list := func(query string, wantStatus int) ([]string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/upgrade/plans"+query, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != wantStatus {
+			t.Fatalf("list %q: status %d, want %d: %s", query, rec.Code, wantStatus, rec.Body.String())
+		}
+		if wantStatus != http.StatusOK {
+			return nil, ""
+		}
+		var resp struct {
+			Items     []store.UpgradePlanResponse `json:"items"`
+			NextAfter string                      `json:"next_after"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		keys := []string{}
+		for _, plan := range resp.Items {
+			keys = append(keys, plan.AgentID)
+		}
+		return keys, resp.NextAfter
+	}
+
+	keys, next := list("?limit=2", http.StatusOK)
+	if len(keys) != 2 || keys[0] != "agt_1" || keys[1] != "channel:canary" || next != "channel:canary" {
+		t.Fatalf("first page %v next %q", keys, next)
+	}
+	if keys, next = list("?limit=2&after="+next, http.StatusOK); len(keys) != 1 || keys[0] != "channel:stable" || next != "" {
+		t.Fatalf("second page %v next %q", keys, next)
+	}
+	for query, want := range map[string]string{
+		"?kind=agent":                  "agt_1",
+		"?kind=channel&version=1.5.0":  "channel:canary",
+		"?paused=true":                 "channel:canary",
+		"?channel=stable&kind=channel": "channel:stable",
+	} {
+		if keys, _ := list(query, http.StatusOK); len(keys) != 1 || keys[0] != want {
+			t.Fatalf("list %q = %v, want [%s]", query, keys, want)
+		}
+	}
+	list("?kind=fleet", http.StatusBadRequest)
+	list("?paused=maybe", http.StatusBadRequest)
+}
+
+func TestAdminDeletePlanRetiresIt(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	do := func(method, path, agentID, body string) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if agentID != "" {
+			req.Header.Set("X-Agent-ID", agentID)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	const artifact = `"artifact":{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}`
+	for _, body := range []string{`{"channel":"canary",` + artifact + `}`, `{"agent_id":"agt_1","channel":"canary",` + artifact + `}`} {
+		if code := do(http.MethodPost, "/api/admin/v1/upgrade/plan", "", body); code != http.StatusOK {
+			t.Fatalf("upsert status %d", code)
+		}
+	}
+
+	// Without its own plan the agent falls back to the channel plan.
+	if code := do(http.MethodDelete, "/api/admin/v1/upgrade/plan/agt_1", "", ""); code != http.StatusNoContent {
+		t.Fatalf("delete agent plan: status %d", code)
+	}
+	if code := do(http.MethodGet, "/api/agent/v1/upgrade/plan?channel=canary", "agt_1", ""); code != http.StatusOK {
+		t.Fatalf("channel fallback: status %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/admin/v1/upgrade/plan/channel:canary", "", ""); code != http.StatusNoContent {
+		t.Fatalf("delete channel plan: status %d", code)
+	}
+	if code := do(http.MethodGet, "/api/agent/v1/upgrade/plan?channel=canary", "agt_1", ""); code != http.StatusNotFound {
+		t.Fatalf("after delete: expected 404, got %d", code)
+	}
+	if code := do(http.MethodDelete, "/api/admin/v1/upgrade/plan/channel:canary", "", ""); code != http.StatusNotFound {
+		t.Fatalf("second delete: expected 404, got %d", code)
+	}
+}
+
+func TestAdminPlanUpsertRequiresIfMatch(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token", RequirePlanIfMatch: true}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	do := func(method, path, body string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	upsert := func(version string, header http.Header) *httptest.ResponseRecorder {
+		return do(http.MethodPost, "/api/admin/v1/upgrade/plan", `{"channel":"stable","artifact":{"version":"`+version+`","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`, header)
+	}
+
+	if rec := upsert("1.0.0", nil); rec.Code != http.StatusPreconditionRequired {
+		t.Fatalf("unconditional upsert: expected 428, got %d", rec.Code)
+	}
+	created := upsert("1.0.0", http.Header{"If-None-Match": {"*"}})
+	if created.Code != http.StatusOK {
+		t.Fatalf("create: status %d: %s", created.Code, created.Body.String())
+	}
+	if rec := upsert("1.0.0", http.Header{"If-None-Match": {"*"}}); rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("second create: expected 412, got %d", rec.Code)
+	}
+
+	read := do(http.MethodGet, "/api/admin/v1/upgrade/plan/channel:stable", "", nil)
+	etag := read.Header().Get("ETag")
+	if read.Code != http.StatusOK || etag != created.Header().Get("ETag") {
+		t.Fatalf("read plan: status %d, etag %q", read.Code, etag)
+	}
+	// Two operators edit from the same ETag; the second edit is refused.
+	first := upsert("1.1.0", http.Header{"If-Match": {etag}})
+	if first.Code != http.StatusOK {
+		t.Fatalf("first edit: status %d", first.Code)
+	}
+	second := upsert("1.2.0", http.Header{"If-Match": {etag}})
+	if second.Code != http.StatusPreconditionFailed || second.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("second edit: status %d, etag %q", second.Code, second.Header().Get("ETag"))
+	}
+	var plan store.UpgradePlanResponse
+	if err := json.Unmarshal(do(http.MethodGet, "/api/admin/v1/upgrade/plan/channel:stable", "", nil).Body.Bytes(), &plan); err != nil || plan.Artifact.Version != "1.1.0" {
+		t.Fatalf("plan after refused edit: %+v (%v)", plan, err)
+	}
+	// A refused precondition is a client conflict, not a store failure.
+	if metrics := do(http.MethodGet, "/metrics", "", nil).Body.String(); strings.Contains(metrics, `op="UpsertUpgradePlan"`) {
+		t.Fatalf("precondition failures counted as store errors:\n%s", metrics)
+	}
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	archiveHistoryLimit    = 100
+	archiveRevisionLimit   = 50
+	archiveDirectivesLimit = 100
+)
+
+// agentArchive is the controller-side counterpart of the agent diag bundle:
+// everything the controller knows about one agent, for support tickets.
+type agentArchive struct {
+	AgentID          string                     `json:"agent_id"`
+	GeneratedAt      time.Time                  `json:"generated_at"`
+	Heartbeat        *store.Heartbeat           `json:"heartbeat,omitempty"`
+	UpgradePlan      *store.UpgradePlanResponse `json:"upgrade_plan,omitempty"`
+	UpgradePlanETag  string                     `json:"upgrade_plan_etag,omitempty"`
+	UpgradeHistory   []store.UpgradeReport      `json:"upgrade_history"`
+	MonitorSnapshot  *store.MonitorSnapshot     `json:"monitor_snapshot,omitempty"`
+	MonitorRevisions []store.MonitorRevision    `json:"monitor_revisions"`
+	Directives       []store.Directive          `json:"directives"`
+	// Errors lists sections that could not be loaded; the rest of the archive is still usable.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func buildAgentArchive(ctx context.Context, deps Dependencies, agentID string) agentArchive {
+	archive := agentArchive{
+		AgentID:          agentID,
+		GeneratedAt:      time.Now().UTC(),
+		UpgradeHistory:   []store.UpgradeReport{},
+		MonitorRevisions: []store.MonitorRevision{},
+		Directives:       []store.Directive{},
+	}
+	fail := func(section string, err error) {
+		if archive.Errors == nil {
+			archive.Errors = map[string]string{}
+		}
+		archive.Errors[section] = err.Error()
+	}
+
+	if hb, err := deps.Store.GetHeartbeat(ctx, agentID); err == nil {
+		archive.Heartbeat = &hb
+	} else if !errors.Is(err, store.ErrAgentNotFound) {
+		fail("heartbeat", err)
+	}
+
+	if history, err := deps.Store.ListUpgradeHistory(ctx, store.HistoryFilter{AgentID: agentID, Limit: archiveHistoryLimit}); err == nil {
+		if history != nil {
+			archive.UpgradeHistory = history
+		}
+	} else {
+		fail("upgrade_history", err)
+	}
+
+	channel := "stable"
+	if len(archive.UpgradeHistory) > 0 && archive.UpgradeHistory[0].Channel != "" {
+		channel = archive.UpgradeHistory[0].Channel
+	}
+	if plan, etag, err := deps.Store.FetchUpgradePlan(ctx, agentID, channel); err == nil {
+		archive.UpgradePlan = &plan
+		archive.UpgradePlanETag = etag
+	} else if !errors.Is(err, store.ErrPlanNotFound) {
+		fail("upgrade_plan", err)
+	}
+
+	if snapshot, err := deps.Store.GetMonitorSnapshot(ctx, agentID, ""); err == nil {
+		archive.MonitorSnapshot = &snapshot
+	} else if !errors.Is(err, store.ErrSnapshotNotFound) {
+		fail("monitor_snapshot", err)
+	}
+	if revisions, err := deps.Store.ListMonitorRevisions(ctx, agentID, archiveRevisionLimit); err == nil {
+		if revisions != nil {
+			archive.MonitorRevisions = revisions
+		}
+	} else {
+		fail("monitor_revisions", err)
+	}
+
+	if directives, err := deps.Store.ListDirectives(ctx, agentID, archiveDirectivesLimit); err == nil {
+		if directives != nil {
+			archive.Directives = directives
+		}
+	} else {
+		fail("directives", err)
+	}
+	return archive
+}
+
+// adminAgentArchiveHandler serves the agent archive as JSON, or as a tar.gz
+// with one file per section when format=tar.gz.
+func adminAgentArchiveHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := mux.Vars(r)["agent_id"]
+		archive := buildAgentArchive(r.Context(), deps, agentID)
+		if archive.Heartbeat == nil && archive.MonitorSnapshot == nil && len(archive.UpgradeHistory) == 0 && len(archive.Directives) == 0 && len(archive.Errors) == 0 {
+			http.Error(w, "agent not found", http.StatusNotFound)
+			return
+		}
+
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+			w.Header().Set("Content-Type", "application/json")
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			_ = enc.Encode(archive)
+		case "tar.gz", "tgz":
+			name := fmt.Sprintf("agent_%s_%s.tar.gz", agentID, archive.GeneratedAt.Format("20060102T150405Z"))
+			w.Header().Set("Content-Type", "application/gzip")
+			w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+			if err := writeAgentArchiveTar(w, archive); err != nil {
+				deps.Logger.Printf("write agent archive for %s failed: %v", agentID, err)
+			}
+		default:
+			http.Error(w, "unsupported format", http.StatusBadRequest)
+		}
+	}
+}
+
+func writeAgentArchiveTar(w http.ResponseWriter, archive agentArchive) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	prefix := "agent_" + archive.AgentID + "/"
+
+	manifest := map[string]any{
+		"agent_id":     archive.AgentID,
+		"generated_at": archive.GeneratedAt,
+		"errors":       archive.Errors,
+	}
+	sections := []struct {
+		name  string
+		value any
+	}{
+		{"manifest.json", manifest},
+		{"heartbeat.json", archive.Heartbeat},
+		{"upgrade_plan.json", map[string]any{"plan": archive.UpgradePlan, "etag": archive.UpgradePlanETag}},
+		{"upgrade_history.json", archive.UpgradeHistory},
+		{"monitor_snapshot.json", archive.MonitorSnapshot},
+		{"monitor_revisions.json", archive.MonitorRevisions},
+		{"directives.json", archive.Directives},
+	}
+	for _, section := range sections {
+		data, err := json.MarshalIndent(section.value, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal %s: %w", section.name, err)
+		}
+		header := &tar.Header{
+			Name:    prefix + section.name,
+			Mode:    0o644,
+			Size:    int64(len(data)),
+			ModTime: archive.GeneratedAt,
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("write tar header for %s: %w", section.name, err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			return fmt.Errorf("write tar content for %s: %w", section.name, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminAgentArchive(t *testing.T) {
+	st := store.NewMemoryStore()
+	ctx := context.Background()
+	ready := false
+	if err := st.RecordHeartbeat(ctx, store.Heartbeat{
+		AgentID:     "agent-1",
+		ReceivedAt:  time.Now().UTC(),
+		Labels:      map[string]string{"site": "ATL-1"},
+		Ready:       &ready,
+		ReadyReason: "monitor sync stale (3m0s)",
+	}); err != nil {
+		t.Fatalf("RecordHeartbeat: %v", err)
+	}
+	if _, err := st.PublishMonitorSnapshot(ctx, "agent-1", []store.MonitorAssignment{{MonitorID: "m1", Protocol: "icmp"}}); err != nil {
+		t.Fatalf("PublishMonitorSnapshot: %v", err)
+	}
+	if err := st.RecordUpgradeReport(ctx, store.UpgradeReport{AgentID: "agent-1", Channel: "beta", Status: "success", CurrentVersion: "1.2.0"}); err != nil {
+		t.Fatalf("RecordUpgradeReport: %v", err)
+	}
+
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp
+	}
+
+	resp := get("/api/admin/v1/agents/agent-1/archive")
+	var archive agentArchive
+	if err := json.NewDecoder(resp.Body).Decode(&archive); err != nil {
+		t.Fatalf("decode archive: %v", err)
+	}
+	resp.Body.Close()
+	if archive.Heartbeat == nil || archive.Heartbeat.Labels["site"] != "ATL-1" || archive.Heartbeat.ReadyReason == "" {
+		t.Fatalf("expected heartbeat with labels and readiness, got %+v", archive.Heartbeat)
+	}
+	if archive.MonitorSnapshot == nil || len(archive.MonitorSnapshot.Monitors) != 1 || len(archive.MonitorRevisions) != 1 {
+		t.Fatalf("expected monitor snapshot and revisions, got %+v", archive)
+	}
+	if len(archive.UpgradeHistory) != 1 || archive.UpgradePlan == nil || archive.UpgradePlan.Channel != "beta" {
+		t.Fatalf("expected history and plan for the reported channel, got %+v / %+v", archive.UpgradeHistory, archive.UpgradePlan)
+	}
+
+	resp = get("/api/admin/v1/agents/agent-1/archive?format=tar.gz")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Disposition") == "" {
+		t.Fatalf("expected attachment disposition")
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 7 || names[0] != "agent_agent-1/directives.json" {
+		t.Fatalf("unexpected archive entries: %v", names)
+	}
+
+	resp = get("/api/admin/v1/agents/unknown/archive")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown agent, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+// defaultRenewalWindow selects certificates expiring within the next 30 days when a
+// renewal campaign does not name agents explicitly.
+const defaultRenewalWindow = 30 * 24 * time.Hour
+
+type heartbeatResponse struct {
+	Directives []store.Directive `json:"directives"`
+}
+
+func heartbeatHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := requestAgentID(r)
+
+		var hb store.Heartbeat
+		if err := json.NewDecoder(r.Body).Decode(&hb); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		hb.AgentID = agentID
+
+		resp, err := recordHeartbeat(r.Context(), deps, hub, hb)
+		if err != nil {
+			http.Error(w, "unable to record heartbeat", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// recordHeartbeat stores hb, syncs catalog monitors with the agent's labels
+// and returns its pending directives.
+func recordHeartbeat(ctx context.Context, deps Dependencies, hub *snapshotHub, hb store.Heartbeat) (heartbeatResponse, error) {
+	hb.ReceivedAt = time.Now().UTC()
+	previous, prevErr := deps.Store.GetHeartbeat(ctx, hb.AgentID)
+	if err := deps.Store.RecordHeartbeat(ctx, hb); err != nil {
+		deps.Logger.Printf("record heartbeat failed for agent %s: %v", hb.AgentID, err)
+		return heartbeatResponse{}, err
+	}
+	if prevErr == nil || errors.Is(prevErr, store.ErrAgentNotFound) {
+		syncCatalogLabels(ctx, deps, hub, hb, previous.Labels)
+	}
+	directives, err := deps.Store.PendingDirectives(ctx, hb.AgentID)
+	if err != nil {
+		deps.Logger.Printf("load directives failed for agent %s: %v", hb.AgentID, err)
+		directives = nil
+	}
+	if directives == nil {
+		directives = []store.Directive{}
+	}
+	return heartbeatResponse{Directives: directives}, nil
+}
+
+func directiveAckHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := requestAgentID(r)
+
+		var req struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		id := mux.Vars(r)["directive_id"]
+		if err := deps.Store.CompleteDirective(r.Context(), agentID, id, req.Status, req.Message); err != nil {
+			if errors.Is(err, store.ErrDirectiveNotFound) {
+				http.Error(w, "directive not found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Status != store.DirectiveDone {
+			deps.Logger.Printf("agent %s reported directive %s %s: %s", agentID, id, req.Status, req.Message)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func adminCertExpiryHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var before time.Time
+		if raw := r.URL.Query().Get("within"); raw != "" {
+			within, err := time.ParseDuration(raw)
+			if err != nil || within <= 0 {
+				http.Error(w, "invalid within", http.StatusBadRequest)
+				return
+			}
+			before = time.Now().UTC().Add(within)
+		}
+		limit := 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		report, err := deps.Store.ListCertExpiry(r.Context(), before, limit)
+		if err != nil {
+			deps.Logger.Printf("cert expiry report failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if report == nil {
+			report = []store.CertExpiry{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"agents": report})
+	}
+}
+
+// adminCertRenewalHandler queues renew_certificate directives for the named agents,
+// or for every agent whose certificate expires within the requested window.
+func adminCertRenewalHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			AgentIDs []string `json:"agent_ids"`
+			Within   string   `json:"within"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+
+		var agentIDs []string
+		for _, id := range req.AgentIDs {
+			if id = strings.TrimSpace(id); id != "" {
+				agentIDs = append(agentIDs, id)
+			}
+		}
+		if len(agentIDs) == 0 {
+			within := defaultRenewalWindow
+			if req.Within != "" {
+				parsed, err := time.ParseDuration(req.Within)
+				if err != nil || parsed <= 0 {
+					http.Error(w, "invalid within", http.StatusBadRequest)
+					return
+				}
+				within = parsed
+			}
+			report, err := deps.Store.ListCertExpiry(r.Context(), time.Now().UTC().Add(within), 0)
+			if err != nil {
+				deps.Logger.Printf("cert expiry report failed: %v", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			for _, row := range report {
+				agentIDs = append(agentIDs, row.AgentID)
+			}
+		}
+
+		directives := []store.Directive{}
+		if len(agentIDs) > 0 {
+			queued, err := deps.Store.EnqueueDirectives(r.Context(), store.DirectiveRenewCertificate, agentIDs, nil)
+			if err != nil {
+				deps.Logger.Printf("queue certificate renewals failed: %v", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			directives = queued
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_ = json.NewEncoder(w).Encode(map[string]any{"directives": directives})
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestCertRenewalCampaignReachesAgentsViaHeartbeat(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token"}
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	}
+	srv := New(cfg, deps)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	heartbeat := func(agentID string, expiry time.Time) heartbeatResponse {
+		body := fmt.Sprintf(`{"sent_at":"2026-10-01T00:00:00Z","cert_expires_at":%q}`, expiry.Format(time.RFC3339))
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/agent/v1/heartbeat", bytes.NewBufferString(body))
+		req.Header.Set("X-Agent-ID", agentID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("heartbeat: %v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("heartbeat status %d", resp.StatusCode)
+		}
+		var out heartbeatResponse
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			t.Fatalf("decode heartbeat response: %v", err)
+		}
+		return out
+	}
+
+	now := time.Now().UTC()
+	heartbeat("agent-late", now.Add(90*24*time.Hour))
+	heartbeat("agent-soon", now.Add(5*24*time.Hour))
+	heartbeat("agent-sooner", now.Add(2*24*time.Hour))
+
+	adminReq := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		return resp
+	}
+
+	resp := adminReq(http.MethodGet, "/api/admin/v1/certs/expiry?within=720h", "")
+	var report struct {
+		Agents []store.CertExpiry `json:"agents"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	resp.Body.Close()
+	if len(report.Agents) != 2 || report.Agents[0].AgentID != "agent-sooner" || report.Agents[1].AgentID != "agent-soon" {
+		t.Fatalf("unexpected report ordering: %+v", report.Agents)
+	}
+
+	resp = adminReq(http.MethodPost, "/api/admin/v1/certs/renewals", `{"within":"720h"}`)
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("renewal status %d", resp.StatusCode)
+	}
+	resp.Body.Close()
+	// Re-running the campaign must not queue duplicates.
+	adminReq(http.MethodPost, "/api/admin/v1/certs/renewals", `{"within":"720h"}`).Body.Close()
+
+	if got := heartbeat("agent-late", now.Add(90*24*time.Hour)); len(got.Directives) != 0 {
+		t.Fatalf("agent outside the window received directives: %+v", got.Directives)
+	}
+	got := heartbeat("agent-soon", now.Add(5*24*time.Hour))
+	if len(got.Directives) != 1 || got.Directives[0].Type != store.DirectiveRenewCertificate {
+		t.Fatalf("expected one renewal directive, got %+v", got.Directives)
+	}
+
+	ackURL := ts.URL + "/api/agent/v1/directives/" + got.Directives[0].ID + "/ack"
+	req, _ := http.NewRequest(http.MethodPost, ackURL, bytes.NewBufferString(`{"status":"done"}`))
+	req.Header.Set("X-Agent-ID", "agent-soon")
+	ackResp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("ack: %v", err)
+	}
+	ackResp.Body.Close()
+	if ackResp.StatusCode != http.StatusNoContent {
+		t.Fatalf("ack status %d", ackResp.StatusCode)
+	}
+	if got := heartbeat("agent-soon", now.Add(365*24*time.Hour)); len(got.Directives) != 0 {
+		t.Fatalf("acknowledged directive redelivered: %+v", got.Directives)
+	}
+
+	resp = adminReq(http.MethodGet, "/api/admin/v1/certs/expiry", "")
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("decode report: %v", err)
+	}
+	resp.Body.Close()
+	last := report.Agents[len(report.Agents)-1]
+	if last.AgentID != "agent-soon" || last.Renewal == nil || last.Renewal.Status != store.DirectiveDone {
+		t.Fatalf("expected renewed agent last with done status, got %+v", last)
+	}
+}
@@ -0,0 +1,429 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/requestid"
+	"github.com/pingsantohq/controller/internal/results"
+	"github.com/pingsantohq/controller/internal/store"
+	"github.com/pingsantohq/controller/internal/webhook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// agentServiceName is the gRPC service offering the agent API. Its messages
+// are the JSON documents of the REST API, sent with the
+// application/grpc+json content type; see docs/agent_upgrade_api.md.
+const agentServiceName = "pingsanto.agent.v1.Agent"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+// jsonCodec marshals gRPC messages as JSON so they share the REST API types.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                       { return "json" }
+
+type planRequest struct {
+	Channel     string `json:"channel,omitempty"`
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+}
+
+type directiveAckRequest struct {
+	DirectiveID string `json:"directive_id"`
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+}
+
+type monitorsRequest struct {
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+	Full        bool   `json:"full,omitempty"`
+}
+
+type monitorsReply struct {
+	ETag        string                `json:"etag"`
+	NotModified bool                  `json:"not_modified,omitempty"`
+	Snapshot    *agentSnapshotPayload `json:"snapshot,omitempty"`
+}
+
+type watchMonitorsRequest struct {
+	Since string `json:"since,omitempty"`
+}
+
+type watchPlanRequest struct {
+	Channel     string `json:"channel,omitempty"`
+	IfNoneMatch string `json:"if_none_match,omitempty"`
+}
+
+type emptyReply struct{}
+
+// agentRPC serves the agent API over gRPC with the same authentication,
+// replay protection, rate limits and handler logic as the REST routes.
+type agentRPC struct {
+	deps     Dependencies
+	hub      *snapshotHub
+	plans    *snapshotHub
+	stats    *statsCollector
+	rollouts *rolloutManager
+	webhooks *webhook.Dispatcher
+	metrics  *controllerMetrics
+	replay   *replayGuard
+	limit    *rateLimiter
+}
+
+var agentServiceDesc = grpc.ServiceDesc{
+	ServiceName: agentServiceName,
+	HandlerType: (*any)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("GetPlan", (*agentRPC).getPlan),
+		unaryMethod("Report", (*agentRPC).report),
+		unaryMethod("Heartbeat", (*agentRPC).heartbeat),
+		unaryMethod("AckDirective", (*agentRPC).ackDirective),
+		unaryMethod("GetMonitors", (*agentRPC).getMonitors),
+		unaryMethod("UploadResults", (*agentRPC).uploadResults),
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchMonitors", Handler: watchMonitorsHandler, ServerStreams: true},
+		{StreamName: "WatchPlan", Handler: watchPlanHandler, ServerStreams: true},
+	},
+}
+
+// unaryMethod adapts a typed agentRPC method to a grpc.MethodDesc.
+func unaryMethod[Req, Resp any](name string, call func(*agentRPC, context.Context, *Req) (*Resp, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			req := new(Req)
+			if err := dec(req); err != nil {
+				return nil, status.Error(codes.InvalidArgument, "invalid json")
+			}
+			handler := func(ctx context.Context, req any) (any, error) {
+				return call(srv.(*agentRPC), ctx, req.(*Req))
+			}
+			if interceptor == nil {
+				return handler(ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + agentServiceName + "/" + name}
+			return interceptor(ctx, req, info, handler)
+		},
+	}
+}
+
+// GRPCServer returns a gRPC server for the agent API, to run on its own
+// listener next to the HTTP server. tlsConfig is the listener configuration
+// from TLSConfig, or nil for plaintext.
+func (s *Server) GRPCServer(tlsConfig *tls.Config) *grpc.Server {
+	keepaliveTime := s.cfg.MonitorStreamKeepalive
+	if keepaliveTime <= 0 {
+		keepaliveTime = defaultMonitorStreamKeepalive
+	}
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.rpc.unaryInterceptor),
+		grpc.ChainStreamInterceptor(s.rpc.streamInterceptor),
+		// Pings keep idle monitor streams from being reaped, as keepalive
+		// lines do on the REST stream.
+		grpc.KeepaliveParams(keepalive.ServerParameters{Time: keepaliveTime}),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	gs := grpc.NewServer(opts...)
+	gs.RegisterService(&agentServiceDesc, s.rpc)
+	return gs
+}
+
+func (a *agentRPC) unaryInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	ctx, id := rpcRequestID(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestid.Header, id))
+	ctx, err := a.admit(ctx, info.FullMethod)
+	var resp any
+	if err == nil {
+		resp, err = handler(ctx, req)
+	}
+	a.logRPC(ctx, id, info.FullMethod, start, err)
+	return resp, err
+}
+
+func (a *agentRPC) streamInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	ctx, id := rpcRequestID(ss.Context())
+	_ = ss.SetHeader(metadata.Pairs(requestid.Header, id))
+	ctx, err := a.admit(ctx, info.FullMethod)
+	if err == nil {
+		err = handler(srv, &rpcStream{ServerStream: ss, ctx: ctx})
+	}
+	a.logRPC(ctx, id, info.FullMethod, start, err)
+	return err
+}
+
+// rpcStream overrides the context a stream handler sees.
+type rpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *rpcStream) Context() context.Context { return s.ctx }
+
+// rpcRequestID reuses a valid x-request-id sent by the client or assigns a
+// new one, as requestLogger does for HTTP.
+func rpcRequestID(ctx context.Context) (context.Context, string) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var id string
+	if values := md.Get(requestid.Header); len(values) > 0 {
+		id = values[0]
+	}
+	if !requestid.Valid(id) {
+		id = requestid.New()
+	}
+	return requestid.With(ctx, id), id
+}
+
+// admit runs the checks the REST agent routes apply: agent authentication,
+// replay protection and the per-agent rate limit. The authenticators see
+// the call as an HTTP request carrying its metadata as headers and the
+// connection's TLS state, so header, mTLS and SPIFFE modes all work.
+func (a *agentRPC) admit(ctx context.Context, method string) (context.Context, error) {
+	r := (&http.Request{Method: http.MethodPost, URL: &url.URL{Path: method}, Header: http.Header{}}).WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		for _, v := range values {
+			r.Header.Add(key, v)
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		r.RemoteAddr = p.Addr.String()
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			r.TLS = &info.State
+		}
+	}
+
+	p, err := a.deps.AgentAuth.Authenticate(r)
+	if err != nil {
+		msg := "unauthorized"
+		if !errors.Is(err, auth.ErrNoCredentials) {
+			msg = err.Error()
+		}
+		return ctx, status.Error(codes.Unauthenticated, msg)
+	}
+	if p.Role != auth.RoleAgent {
+		return ctx, status.Error(codes.Unauthenticated, "unauthorized")
+	}
+	ctx = auth.WithPrincipal(ctx, p)
+	if reason := a.replay.refuse(r.WithContext(ctx)); reason != "" {
+		return ctx, status.Error(codes.Unauthenticated, "request rejected: "+reason)
+	}
+	if a.limit != nil {
+		if ok, wait := a.limit.allow(p.Subject); !ok {
+			a.metrics.rateLimited(a.limit.role)
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", strconv.Itoa(int(math.Ceil(wait.Seconds())))))
+			return ctx, status.Error(codes.ResourceExhausted, "rate limit exceeded")
+		}
+	}
+	return ctx, nil
+}
+
+// logRPC writes the request log record for a call, mirroring requestLogger.
+func (a *agentRPC) logRPC(ctx context.Context, id, method string, start time.Time, err error) {
+	code := status.Code(err)
+	level := slog.LevelInfo
+	if code == codes.Internal || code == codes.Unknown {
+		level = slog.LevelError
+	}
+	var subject, remote string
+	if p, ok := auth.FromContext(ctx); ok {
+		subject = p.Subject
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		remote = p.Addr.String()
+	}
+	a.deps.Log.LogAttrs(ctx, level, "rpc",
+		slog.String("request_id", id),
+		slog.String("method", method),
+		slog.String("code", code.String()),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+		slog.String("remote_addr", remote),
+		slog.String("subject", subject),
+	)
+}
+
+func contextAgentID(ctx context.Context) string {
+	p, _ := auth.FromContext(ctx)
+	return p.Subject
+}
+
+var errRPCInternal = status.Error(codes.Internal, "internal error")
+
+func (a *agentRPC) getPlan(ctx context.Context, req *planRequest) (*planEvent, error) {
+	agentID := contextAgentID(ctx)
+	plan, etag, err := a.deps.Store.FetchUpgradePlan(ctx, agentID, req.Channel)
+	if err != nil {
+		if errors.Is(err, store.ErrPlanNotFound) {
+			return nil, status.Error(codes.NotFound, "plan not found")
+		}
+		a.deps.Logger.Printf("fetch plan failed for agent %s: %v", agentID, err)
+		return nil, errRPCInternal
+	}
+	if req.IfNoneMatch != "" && req.IfNoneMatch == etag {
+		return &planEvent{ETag: etag, NotModified: true}, nil
+	}
+	return &planEvent{ETag: etag, Plan: &plan}, nil
+}
+
+func (a *agentRPC) report(ctx context.Context, req *store.UpgradeReport) (*emptyReply, error) {
+	req.AgentID = contextAgentID(ctx)
+	if err := recordReport(ctx, a.deps, a.rollouts, a.webhooks, a.metrics, *req); err != nil {
+		return nil, status.Error(codes.Internal, "unable to record report")
+	}
+	return &emptyReply{}, nil
+}
+
+func (a *agentRPC) heartbeat(ctx context.Context, hb *store.Heartbeat) (*heartbeatResponse, error) {
+	hb.AgentID = contextAgentID(ctx)
+	resp, err := recordHeartbeat(ctx, a.deps, a.hub, *hb)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to record heartbeat")
+	}
+	return &resp, nil
+}
+
+func (a *agentRPC) ackDirective(ctx context.Context, req *directiveAckRequest) (*emptyReply, error) {
+	agentID := contextAgentID(ctx)
+	if err := a.deps.Store.CompleteDirective(ctx, agentID, req.DirectiveID, req.Status, req.Message); err != nil {
+		if errors.Is(err, store.ErrDirectiveNotFound) {
+			return nil, status.Error(codes.NotFound, "directive not found")
+		}
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.Status != store.DirectiveDone {
+		a.deps.Logger.Printf("agent %s reported directive %s %s: %s", agentID, req.DirectiveID, req.Status, req.Message)
+	}
+	return &emptyReply{}, nil
+}
+
+func (a *agentRPC) getMonitors(ctx context.Context, req *monitorsRequest) (*monitorsReply, error) {
+	agentID := contextAgentID(ctx)
+	latest, overlay, err := latestSnapshot(ctx, a.deps, agentID)
+	if err != nil {
+		a.deps.Logger.Printf("fetch monitor snapshot failed for agent %s: %v", agentID, err)
+		return nil, errRPCInternal
+	}
+	etag := snapshotETag(latest.Revision, overlay)
+	if req.IfNoneMatch != "" && req.IfNoneMatch == etag {
+		return &monitorsReply{ETag: etag, NotModified: true}, nil
+	}
+	payload := snapshotSince(ctx, a.deps, req.IfNoneMatch, req.Full, latest, overlay)
+	return &monitorsReply{ETag: etag, Snapshot: &payload}, nil
+}
+
+func (a *agentRPC) uploadResults(ctx context.Context, envelope *results.Envelope) (*resultsResponse, error) {
+	envelope.AgentID = contextAgentID(ctx)
+	if err := envelope.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	resp, err := storeResults(ctx, a.deps, a.stats, *envelope)
+	if err != nil {
+		return nil, status.Error(codes.Internal, "unable to store results")
+	}
+	return &resp, nil
+}
+
+// watchMonitorsHandler streams snapshot deltas whenever a new revision or
+// config overlay is published for the agent, like the REST monitor stream.
+func watchMonitorsHandler(srv any, stream grpc.ServerStream) error {
+	a := srv.(*agentRPC)
+	var req watchMonitorsRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid json")
+	}
+	ctx := stream.Context()
+	agentID := contextAgentID(ctx)
+
+	updates, unsubscribe := a.hub.subscribe(agentID)
+	defer unsubscribe()
+
+	feed := newMonitorFeed(ctx, a.deps, agentID, req.Since)
+	push := func() error {
+		payload, ok, err := feed.next(ctx)
+		if err != nil {
+			a.deps.Logger.Printf("monitor stream push failed for agent %s: %v", agentID, err)
+			return errRPCInternal
+		}
+		if !ok {
+			return nil
+		}
+		return stream.SendMsg(&payload)
+	}
+	if err := push(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-updates:
+			if err := push(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// watchPlanHandler streams the agent's plan whenever it changes, like the
+// REST plan stream.
+func watchPlanHandler(srv any, stream grpc.ServerStream) error {
+	a := srv.(*agentRPC)
+	var req watchPlanRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return status.Error(codes.InvalidArgument, "invalid json")
+	}
+	ctx := stream.Context()
+	agentID := contextAgentID(ctx)
+
+	feed := &planFeed{deps: a.deps, agentID: agentID, channel: req.Channel, etag: req.IfNoneMatch}
+	updates, unsubscribe := feed.subscribe(a.plans)
+	defer unsubscribe()
+	push := func() error {
+		event, ok, err := feed.next(ctx)
+		if err != nil {
+			a.deps.Logger.Printf("plan stream push failed for agent %s: %v", agentID, err)
+			return errRPCInternal
+		}
+		if !ok {
+			return nil
+		}
+		return stream.SendMsg(&event)
+	}
+	if err := push(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-updates:
+			if err := push(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/results"
+	"github.com/pingsantohq/controller/internal/store"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestGRPCAgentAPISharesStoreWithREST(t *testing.T) {
+	st := store.NewMemoryStore()
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+	admin := func(method, path, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", method, path, rec.Code, rec.Body.String())
+		}
+	}
+
+	conn := dialAgentRPC(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	call := func(ctx context.Context, method string, req, resp any) error {
+		return conn.Invoke(ctx, "/"+agentServiceName+"/"+method, req, resp)
+	}
+	if err := call(ctx, "GetPlan", &planRequest{}, &planEvent{}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("anonymous call: expected Unauthenticated, got %v", err)
+	}
+	agentCtx := metadata.AppendToOutgoingContext(ctx, "x-agent-id", "agent-1")
+
+	admin(http.MethodPost, "/api/admin/v1/upgrade/plan", `{"channel":"stable","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`)
+	var plan planEvent
+	if err := call(agentCtx, "GetPlan", &planRequest{}, &plan); err != nil || plan.Plan == nil || plan.Plan.Artifact.Version != "1.2.0" {
+		t.Fatalf("GetPlan: %+v (%v)", plan, err)
+	}
+	var again planEvent
+	if err := call(agentCtx, "GetPlan", &planRequest{IfNoneMatch: plan.ETag}, &again); err != nil || !again.NotModified || again.Plan != nil {
+		t.Fatalf("conditional GetPlan: %+v (%v)", again, err)
+	}
+
+	report := store.UpgradeReport{AgentID: "spoofed", Channel: "stable", Status: "success", CurrentVersion: "1.2.0"}
+	if err := call(agentCtx, "Report", &report, &emptyReply{}); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	history, err := st.ListUpgradeHistory(ctx, store.HistoryFilter{AgentID: "agent-1", Limit: 10})
+	if err != nil || len(history) != 1 || history[0].Status != "success" {
+		t.Fatalf("report not recorded for the authenticated agent: %+v (%v)", history, err)
+	}
+
+	var hb heartbeatResponse
+	if err := call(agentCtx, "Heartbeat", &store.Heartbeat{QueueDepth: 3}, &hb); err != nil || hb.Directives == nil {
+		t.Fatalf("Heartbeat: %+v (%v)", hb, err)
+	}
+
+	envelope := results.Envelope{SentAt: time.Now(), BatchSeq: 1, Results: []results.ProbeResult{{MonitorID: "mon_a", Timestamp: time.Now()}}}
+	var uploaded resultsResponse
+	if err := call(agentCtx, "UploadResults", &envelope, &uploaded); err != nil || uploaded.Accepted != 1 {
+		t.Fatalf("UploadResults: %+v (%v)", uploaded, err)
+	}
+	if err := call(agentCtx, "UploadResults", &envelope, &uploaded); err != nil || !uploaded.Duplicate {
+		t.Fatalf("UploadResults retry: %+v (%v)", uploaded, err)
+	}
+	if err := call(agentCtx, "UploadResults", &results.Envelope{}, &uploaded); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("empty batch: expected InvalidArgument, got %v", err)
+	}
+
+	admin(http.MethodPost, "/api/admin/v1/monitors/agent-1/snapshots", `{"monitors":[{"monitor_id":"mon_a","protocol":"icmp"}]}`)
+	stream, err := conn.NewStream(agentCtx, &agentServiceDesc.Streams[0], "/"+agentServiceName+"/WatchMonitors")
+	if err != nil {
+		t.Fatalf("WatchMonitors: %v", err)
+	}
+	if err := stream.SendMsg(&watchMonitorsRequest{}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("close send: %v", err)
+	}
+	var first agentSnapshotPayload
+	if err := stream.RecvMsg(&first); err != nil || first.Incremental || len(first.Monitors) != 1 {
+		t.Fatalf("first snapshot: %+v (%v)", first, err)
+	}
+	admin(http.MethodPost, "/api/admin/v1/monitors/agent-1/snapshots", `{"monitors":[{"monitor_id":"mon_a","protocol":"icmp"},{"monitor_id":"mon_b","protocol":"icmp"}]}`)
+	var second agentSnapshotPayload
+	if err := stream.RecvMsg(&second); err != nil || !second.Incremental || len(second.Monitors) != 1 || second.Monitors[0].MonitorID != "mon_b" {
+		t.Fatalf("pushed delta: %+v (%v)", second, err)
+	}
+
+	var monitors monitorsReply
+	if err := call(agentCtx, "GetMonitors", &monitorsRequest{}, &monitors); err != nil || monitors.Snapshot == nil || monitors.Snapshot.Revision != second.Revision {
+		t.Fatalf("GetMonitors: %+v (%v)", monitors, err)
+	}
+}
+
+func TestGRPCAgentAPIRateLimit(t *testing.T) {
+	srv := New(Config{AgentRateLimit: 1, AgentRateBurst: 1}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	conn := dialAgentRPC(t, srv)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-agent-id", "agent-1")
+	method := "/" + agentServiceName + "/Heartbeat"
+	if err := conn.Invoke(ctx, method, &store.Heartbeat{}, &heartbeatResponse{}); err != nil {
+		t.Fatalf("first heartbeat: %v", err)
+	}
+	var header metadata.MD
+	err := conn.Invoke(ctx, method, &store.Heartbeat{}, &heartbeatResponse{}, grpc.Header(&header))
+	if status.Code(err) != codes.ResourceExhausted || len(header.Get("retry-after")) != 1 {
+		t.Fatalf("expected ResourceExhausted with retry-after, got %v (%v)", err, header)
+	}
+}
+
+// dialAgentRPC serves srv's gRPC agent API in memory and connects to it.
+func dialAgentRPC(t *testing.T, srv *Server) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	gs := srv.GRPCServer(nil)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype("json")),
+	)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	defaultMonitorStreamKeepalive = 15 * time.Second
+	maxMonitorLongPollWait        = 60 * time.Second
+)
+
+// snapshotHub fans out "new revision published" signals to open agent
+// streams; a second hub signals plan changes to plan streams. It is
+// process-local; agents connected to another replica catch up on reconnect.
+type snapshotHub struct {
+	mu   sync.Mutex
+	subs map[string]map[chan struct{}]struct{}
+}
+
+func newSnapshotHub() *snapshotHub {
+	return &snapshotHub{subs: map[string]map[chan struct{}]struct{}{}}
+}
+
+// subscribe returns a channel woken by notify for any of keys, usually the
+// agent ID alone.
+func (h *snapshotHub) subscribe(keys ...string) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	h.mu.Lock()
+	for _, key := range keys {
+		if h.subs[key] == nil {
+			h.subs[key] = map[chan struct{}]struct{}{}
+		}
+		h.subs[key][ch] = struct{}{}
+	}
+	h.mu.Unlock()
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		for _, key := range keys {
+			delete(h.subs[key], ch)
+			if len(h.subs[key]) == 0 {
+				delete(h.subs, key)
+			}
+		}
+	}
+}
+
+func (h *snapshotHub) notify(key string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// notifyAll wakes every open stream, e.g. after the fleet config overlay changes.
+func (h *snapshotHub) notifyAll() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, subs := range h.subs {
+		for ch := range subs {
+			select {
+			case ch <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// agentSnapshotPayload is the agent-facing snapshot shape documented in
+// agent/docs/monitor_assignments_api.md.
+type agentSnapshotPayload struct {
+	Revision    string                    `json:"revision"`
+	GeneratedAt time.Time                 `json:"generated_at"`
+	Incremental bool                      `json:"incremental,omitempty"`
+	Removed     []string                  `json:"removed,omitempty"`
+	Monitors    []store.MonitorAssignment `json:"monitors"`
+	Config      *store.ConfigOverlay      `json:"config,omitempty"`
+}
+
+// buildAgentSnapshot returns a full snapshot when base has no revision, otherwise
+// an incremental delta carrying only added/changed monitors plus removals.
+func buildAgentSnapshot(base, latest store.MonitorSnapshot) agentSnapshotPayload {
+	payload := agentSnapshotPayload{
+		Revision:    latest.Revision,
+		GeneratedAt: latest.GeneratedAt,
+		Monitors:    latest.Monitors,
+	}
+	if base.Revision == "" {
+		if payload.Monitors == nil {
+			payload.Monitors = []store.MonitorAssignment{}
+		}
+		return payload
+	}
+	diff := store.DiffMonitorSnapshots(base, latest)
+	payload.Incremental = true
+	payload.Monitors = append([]store.MonitorAssignment{}, diff.Added...)
+	for _, change := range diff.Changed {
+		payload.Monitors = append(payload.Monitors, change.After)
+	}
+	for _, removed := range diff.Removed {
+		payload.Removed = append(payload.Removed, removed.MonitorID)
+	}
+	return payload
+}
+
+// withOverlay attaches the fleet config overlay, if any, to payload.
+func (payload agentSnapshotPayload) withOverlay(overlay store.ConfigOverlay) agentSnapshotPayload {
+	if !overlay.IsZero() {
+		payload.Config = &overlay
+	}
+	return payload
+}
+
+// snapshotETag identifies the revision plus the config overlay, so agents
+// refetch when either changes.
+func snapshotETag(revision string, overlay store.ConfigOverlay) string {
+	if overlay.IsZero() {
+		return fmt.Sprintf("\"rev-%s\"", revision)
+	}
+	return fmt.Sprintf("\"rev-%s-cfg-%d\"", revision, overlay.UpdatedAt.UnixNano())
+}
+
+// etagRevision returns the snapshot revision named by an ETag from
+// snapshotETag, or "" for any other value.
+func etagRevision(etag string) string {
+	rest, ok := strings.CutPrefix(strings.Trim(etag, "\""), "rev-")
+	if !ok {
+		return ""
+	}
+	revision, _, _ := strings.Cut(rest, "-cfg-")
+	return revision
+}
+
+// monitorSnapshotHandler serves the agent's latest snapshot with ETag support. A
+// positive ?wait= duration turns a conditional request into a long-poll: the
+// response is held until a new revision is published or the wait elapses (304).
+// When If-None-Match names an older revision the controller still stores,
+// the response is an incremental delta from it; ?full=true always returns the
+// whole snapshot.
+func monitorSnapshotHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := requestAgentID(r)
+		var wait time.Duration
+		if raw := r.URL.Query().Get("wait"); raw != "" {
+			var err error
+			wait, err = time.ParseDuration(raw)
+			if err != nil || wait < 0 {
+				http.Error(w, "invalid wait duration", http.StatusBadRequest)
+				return
+			}
+			if wait > maxMonitorLongPollWait {
+				wait = maxMonitorLongPollWait
+			}
+		}
+
+		// Subscribe before reading so a publish between the read and the wait is not missed.
+		updates, unsubscribe := hub.subscribe(agentID)
+		defer unsubscribe()
+
+		latest, overlay, err := latestSnapshot(r.Context(), deps, agentID)
+		if err != nil {
+			deps.Logger.Printf("fetch monitor snapshot failed for agent %s: %v", agentID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		match := r.Header.Get("If-None-Match")
+		if match != "" && match == snapshotETag(latest.Revision, overlay) && wait > 0 {
+			rc := http.NewResponseController(w)
+			if err := rc.SetWriteDeadline(time.Now().Add(wait + 5*time.Second)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				deps.Logger.Printf("long-poll: extend write deadline for agent %s: %v", agentID, err)
+			}
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-timer.C:
+			case <-updates:
+				latest, overlay, err = latestSnapshot(r.Context(), deps, agentID)
+				if err != nil {
+					deps.Logger.Printf("fetch monitor snapshot failed for agent %s: %v", agentID, err)
+					http.Error(w, "internal error", http.StatusInternalServerError)
+					return
+				}
+			}
+		}
+
+		etag := snapshotETag(latest.Revision, overlay)
+		if match != "" && match == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full, _ := strconv.ParseBool(r.URL.Query().Get("full"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", etag)
+		if err := json.NewEncoder(w).Encode(snapshotSince(r.Context(), deps, match, full, latest, overlay)); err != nil {
+			deps.Logger.Printf("encode monitor snapshot failed: %v", err)
+		}
+	}
+}
+
+// snapshotSince returns latest as a delta from the revision named by the
+// agent's ETag match, or in full when full is set or that revision is gone.
+func snapshotSince(ctx context.Context, deps Dependencies, match string, full bool, latest store.MonitorSnapshot, overlay store.ConfigOverlay) agentSnapshotPayload {
+	var base store.MonitorSnapshot
+	if revision := etagRevision(match); revision != "" && !full {
+		// Unknown or pruned revisions fall back to a full snapshot.
+		if snap, err := deps.Store.GetMonitorSnapshot(ctx, latest.AgentID, revision); err == nil {
+			base = snap
+		}
+	}
+	return buildAgentSnapshot(base, latest).withOverlay(overlay)
+}
+
+// latestSnapshot returns the newest snapshot for the agent, or an empty revision
+// "0" when nothing has been published yet so agents start with no monitors,
+// together with the fleet config overlay.
+func latestSnapshot(ctx context.Context, deps Dependencies, agentID string) (store.MonitorSnapshot, store.ConfigOverlay, error) {
+	overlay, err := deps.Store.GetConfigOverlay(ctx)
+	if err != nil {
+		return store.MonitorSnapshot{}, store.ConfigOverlay{}, fmt.Errorf("get config overlay: %w", err)
+	}
+	snapshot, err := deps.Store.GetMonitorSnapshot(ctx, agentID, "")
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		return store.MonitorSnapshot{AgentID: agentID, Revision: "0", GeneratedAt: time.Now().UTC()}, overlay, nil
+	}
+	return snapshot, overlay, err
+}
+
+// monitorStreamHandler holds the connection open and pushes newline-delimited
+// snapshot deltas whenever a new revision is published for the agent. Blank
+// lines are written as keepalives so idle connections are not reaped.
+func monitorStreamHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := requestAgentID(r)
+
+		rc := http.NewResponseController(w)
+		// Streams outlive the server-wide write timeout.
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			deps.Logger.Printf("monitor stream: clear write deadline for agent %s: %v", agentID, err)
+		}
+
+		updates, unsubscribe := hub.subscribe(agentID)
+		defer unsubscribe()
+
+		feed := newMonitorFeed(r.Context(), deps, agentID, r.URL.Query().Get("since"))
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			deps.Logger.Printf("monitor stream: flush unsupported: %v", err)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+		push := func() error {
+			payload, ok, err := feed.next(r.Context())
+			if err != nil || !ok {
+				return err
+			}
+			if err := enc.Encode(payload); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		keepalive := cfg.MonitorStreamKeepalive
+		if keepalive <= 0 {
+			keepalive = defaultMonitorStreamKeepalive
+		}
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+
+		if err := push(); err != nil {
+			deps.Logger.Printf("monitor stream push failed for agent %s: %v", agentID, err)
+			return
+		}
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updates:
+				if err := push(); err != nil {
+					deps.Logger.Printf("monitor stream push failed for agent %s: %v", agentID, err)
+					return
+				}
+			case <-ticker.C:
+				if _, err := w.Write([]byte("\n")); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// monitorFeed tracks what an open stream last sent an agent, so each push is
+// a delta from it.
+type monitorFeed struct {
+	deps        Dependencies
+	agentID     string
+	last        store.MonitorSnapshot
+	lastOverlay store.ConfigOverlay
+}
+
+// newMonitorFeed starts a feed from revision since, or from nothing when the
+// controller does not have it.
+func newMonitorFeed(ctx context.Context, deps Dependencies, agentID, since string) *monitorFeed {
+	f := &monitorFeed{deps: deps, agentID: agentID}
+	if since != "" {
+		if snap, err := deps.Store.GetMonitorSnapshot(ctx, agentID, since); err == nil {
+			f.last = snap
+		}
+	}
+	return f
+}
+
+// next returns the delta to push, or false when the agent is up to date or
+// nothing has been published for it yet.
+func (f *monitorFeed) next(ctx context.Context) (agentSnapshotPayload, bool, error) {
+	overlay, err := f.deps.Store.GetConfigOverlay(ctx)
+	if err != nil {
+		return agentSnapshotPayload{}, false, fmt.Errorf("get config overlay: %w", err)
+	}
+	latest, err := f.deps.Store.GetMonitorSnapshot(ctx, f.agentID, "")
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		return agentSnapshotPayload{}, false, nil
+	}
+	if err != nil {
+		return agentSnapshotPayload{}, false, err
+	}
+	// An overlay change alone is pushed as an empty delta carrying the new config.
+	if latest.Revision == f.last.Revision && overlay == f.lastOverlay {
+		return agentSnapshotPayload{}, false, nil
+	}
+	payload := buildAgentSnapshot(f.last, latest).withOverlay(overlay)
+	f.last, f.lastOverlay = latest, overlay
+	return payload, true, nil
+}
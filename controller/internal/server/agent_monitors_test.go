@@ -0,0 +1,363 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestMonitorStreamPushesIncrementalSnapshots(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token", MonitorStreamKeepalive: 20 * time.Millisecond}
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	}
+	srv := New(cfg, deps)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	publish := func(body string) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/v1/monitors/agent-123/snapshots", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("publish: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("publish status %d", resp.StatusCode)
+		}
+	}
+	publish(`{"monitors":[{"monitor_id":"mon_a","protocol":"icmp"},{"monitor_id":"mon_b","protocol":"icmp"}]}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/api/agent/v1/monitors/stream", nil)
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.Header.Set("X-Agent-ID", "agent-123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stream status %d", resp.StatusCode)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	next := func() agentSnapshotPayload {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var payload agentSnapshotPayload
+			if err := json.Unmarshal([]byte(line), &payload); err != nil {
+				t.Fatalf("decode stream line: %v", err)
+			}
+			return payload
+		}
+	}
+
+	first := next()
+	if first.Revision != "1" || first.Incremental || len(first.Monitors) != 2 {
+		t.Fatalf("unexpected initial snapshot: %+v", first)
+	}
+
+	publish(`{"monitors":[{"monitor_id":"mon_a","protocol":"tcp"}]}`)
+	second := next()
+	if second.Revision != "2" || !second.Incremental {
+		t.Fatalf("unexpected pushed snapshot: %+v", second)
+	}
+	if len(second.Monitors) != 1 || second.Monitors[0].Protocol != "tcp" {
+		t.Fatalf("unexpected pushed monitors: %+v", second.Monitors)
+	}
+	if len(second.Removed) != 1 || second.Removed[0] != "mon_b" {
+		t.Fatalf("unexpected removals: %+v", second.Removed)
+	}
+}
+
+func TestMonitorSnapshotLongPollReturnsOnPublish(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token"}
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	}
+	srv := New(cfg, deps)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	fetch := func(query, etag string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/agent/v1/monitors"+query, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("X-Agent-ID", "agent-123")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("fetch monitors: %v", err)
+		}
+		return resp
+	}
+
+	resp := fetch("", "")
+	resp.Body.Close()
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("unexpected initial response: %d etag=%q", resp.StatusCode, etag)
+	}
+
+	resp = fetch("?wait=50ms", etag)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("expected 304 after wait elapsed, got %d", resp.StatusCode)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		deps.Store.PublishMonitorSnapshot(context.Background(), "agent-123", []store.MonitorAssignment{{MonitorID: "mon_a", Protocol: "icmp"}})
+		srv.hub.notify("agent-123")
+	}()
+	started := time.Now()
+	resp = fetch("?wait=5s", etag)
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 after publish, got %d", resp.StatusCode)
+	}
+	if time.Since(started) > 4*time.Second {
+		t.Fatalf("long-poll did not return promptly on publish")
+	}
+	var payload agentSnapshotPayload
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatalf("decode snapshot: %v", err)
+	}
+	if payload.Revision != "1" || len(payload.Monitors) != 1 {
+		t.Fatalf("unexpected snapshot: %+v", payload)
+	}
+}
+
+func TestMonitorSnapshotServesIncrementalDelta(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	srv := New(Config{}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	fetch := func(query, etag string) (*httptest.ResponseRecorder, agentSnapshotPayload) {
+		req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/monitors"+query, nil)
+		req.Header.Set("X-Agent-ID", "agent-123")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		var payload agentSnapshotPayload
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode snapshot: %v", err)
+			}
+		}
+		return rec, payload
+	}
+
+	if _, err := st.PublishMonitorSnapshot(ctx, "agent-123", []store.MonitorAssignment{
+		{MonitorID: "mon_a", Protocol: "icmp"},
+		{MonitorID: "mon_b", Protocol: "icmp"},
+	}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	rec, first := fetch("", "")
+	etag := rec.Header().Get("ETag")
+	if first.Incremental || len(first.Monitors) != 2 {
+		t.Fatalf("unexpected initial snapshot: %+v", first)
+	}
+
+	if _, err := st.PublishMonitorSnapshot(ctx, "agent-123", []store.MonitorAssignment{
+		{MonitorID: "mon_a", Protocol: "tcp"},
+		{MonitorID: "mon_c", Protocol: "icmp"},
+	}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	_, delta := fetch("", etag)
+	if delta.Revision != "2" || !delta.Incremental || len(delta.Monitors) != 2 {
+		t.Fatalf("expected incremental delta, got %+v", delta)
+	}
+	if len(delta.Removed) != 1 || delta.Removed[0] != "mon_b" {
+		t.Fatalf("unexpected removals: %+v", delta.Removed)
+	}
+
+	if _, full := fetch("?full=true", etag); full.Incremental || len(full.Monitors) != 2 {
+		t.Fatalf("expected full snapshot with ?full=true, got %+v", full)
+	}
+	if _, unknown := fetch("", `"rev-99"`); unknown.Incremental || len(unknown.Monitors) != 2 {
+		t.Fatalf("expected full snapshot for an unknown revision, got %+v", unknown)
+	}
+}
+
+func TestAdminApplyBundleIsAllOrNothing(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token"}
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	}
+	srv := New(cfg, deps)
+
+	apply := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/monitors/bundles", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := apply(`
+apiVersion: pingsanto.io/v1
+kind: MonitorBundle
+monitors:
+  - id: mon_a
+    protocol: icmp
+    targets: ["203.0.113.7"]
+    cadence_ms: 3000
+assignments:
+  - agents: [agent-1, agent-2]
+    monitors: [mon_a]
+`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("apply status %d: %s", rr.Code, rr.Body.String())
+	}
+	var applied struct {
+		Agents []struct {
+			AgentID  string `json:"agent_id"`
+			Revision string `json:"revision"`
+		} `json:"agents"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&applied); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if len(applied.Agents) != 2 || applied.Agents[0].AgentID != "agent-1" || applied.Agents[0].Revision != "1" {
+		t.Fatalf("unexpected apply response: %+v", applied)
+	}
+
+	rr = apply(`
+apiVersion: pingsanto.io/v1
+kind: MonitorBundle
+monitors:
+  - id: mon_a
+    protocol: icmp
+    targets: ["203.0.113.9"]
+    cadence_ms: 3000
+assignments:
+  - agents: [agent-1]
+    monitors: [mon_a]
+  - agents: [agent-2]
+    monitors: [mon_unknown]
+`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 for invalid bundle, got %d", rr.Code)
+	}
+	snapshot, err := deps.Store.GetMonitorSnapshot(context.Background(), "agent-1", "")
+	if err != nil {
+		t.Fatalf("GetMonitorSnapshot: %v", err)
+	}
+	if snapshot.Revision != "1" || snapshot.Monitors[0].Targets[0] != "203.0.113.7" {
+		t.Fatalf("invalid bundle must not publish revisions, got %+v", snapshot)
+	}
+}
+
+func TestConfigOverlayDeliveredWithSnapshot(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token"}
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	}
+	srv := New(cfg, deps)
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	fetch := func(query, etag string) (*http.Response, agentSnapshotPayload) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/api/agent/v1/monitors"+query, nil)
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("X-Agent-ID", "agent-123")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("fetch monitors: %v", err)
+		}
+		defer resp.Body.Close()
+		var payload agentSnapshotPayload
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+				t.Fatalf("decode snapshot: %v", err)
+			}
+		}
+		return resp, payload
+	}
+	setOverlay := func(body string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/v1/settings/config-overlay", bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatalf("NewRequest: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("set overlay: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	resp, payload := fetch("", "")
+	etag := resp.Header.Get("ETag")
+	if payload.Config != nil {
+		t.Fatalf("expected no overlay before one is set, got %+v", payload.Config)
+	}
+
+	for _, body := range []string{`{"queue_disk_bytes_cap":"lots"}`, `{"log_level":"loud"}`} {
+		if status := setOverlay(body); status != http.StatusBadRequest {
+			t.Fatalf("expected invalid overlay %s to be rejected, got %d", body, status)
+		}
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		setOverlay(`{"heartbeat_sec":30,"queue_disk_bytes_cap":"1GiB","log_level":"Debug"}`)
+	}()
+	resp, payload = fetch("?wait=5s", etag)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected overlay change to end the long-poll, got %d", resp.StatusCode)
+	}
+	if resp.Header.Get("ETag") == etag {
+		t.Fatalf("expected ETag to change with the overlay")
+	}
+	if payload.Config == nil || payload.Config.HeartbeatSec != 30 || payload.Config.QueueDiskBytesCap != "1GiB" || payload.Config.LogLevel != "debug" {
+		t.Fatalf("unexpected overlay in snapshot: %+v", payload.Config)
+	}
+
+	setOverlay(`{}`)
+	if _, payload = fetch("", ""); payload.Config != nil {
+		t.Fatalf("expected cleared overlay, got %+v", payload.Config)
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const planStreamRoute = "/api/agent/v1/upgrade/plan/stream"
+
+// planWatchStore wakes open plan streams whenever a plan changes, whether
+// through the admin API or a rollout stage advancing. Streams subscribe under
+// their agent ID and their channel's plan key, so a change only wakes the
+// streams that plan can reach; they re-fetch and push if their plan differs.
+type planWatchStore struct {
+	store.Store
+	hub *snapshotHub
+}
+
+func (s planWatchStore) UpsertUpgradePlan(ctx context.Context, input store.PlanInput) (store.UpgradePlanResponse, string, error) {
+	plan, etag, err := s.Store.UpsertUpgradePlan(ctx, input)
+	if err == nil {
+		s.hub.notify(input.Key())
+	}
+	return plan, etag, err
+}
+
+func (s planWatchStore) DeleteUpgradePlan(ctx context.Context, key string) error {
+	err := s.Store.DeleteUpgradePlan(ctx, key)
+	if err == nil {
+		s.hub.notify(key)
+	}
+	return err
+}
+
+func (s planWatchStore) SetPlanRollout(ctx context.Context, key string, percent int) (store.UpgradePlanResponse, string, error) {
+	plan, etag, err := s.Store.SetPlanRollout(ctx, key, percent)
+	if err == nil {
+		s.hub.notify(key)
+	}
+	return plan, etag, err
+}
+
+func (s planWatchStore) SetPlanPaused(ctx context.Context, key string, paused bool) (store.UpgradePlanResponse, string, error) {
+	plan, etag, err := s.Store.SetPlanPaused(ctx, key, paused)
+	if err == nil {
+		s.hub.notify(key)
+	}
+	return plan, etag, err
+}
+
+// planEvent is one line of the plan stream, and the GetPlan and WatchPlan
+// gRPC replies. NoPlan announces that the plan last sent was withdrawn.
+type planEvent struct {
+	ETag        string                     `json:"etag"`
+	NotModified bool                       `json:"not_modified,omitempty"`
+	NoPlan      bool                       `json:"no_plan,omitempty"`
+	Plan        *store.UpgradePlanResponse `json:"plan,omitempty"`
+}
+
+// planFeed tracks the plan ETag an open stream last sent an agent.
+type planFeed struct {
+	deps    Dependencies
+	agentID string
+	channel string
+	etag    string
+}
+
+// subscribe registers the feed with hub for changes to the agent's own plan
+// and its channel's plan.
+func (f *planFeed) subscribe(hub *snapshotHub) (<-chan struct{}, func()) {
+	return hub.subscribe(f.agentID, store.ChannelPlanKey(f.channel))
+}
+
+// next returns the agent's plan when it differs from the last one sent, a
+// NoPlan event when the plan last sent is gone (deleted, or the agent fell
+// out of its rollout), or false when nothing changed.
+func (f *planFeed) next(ctx context.Context) (planEvent, bool, error) {
+	plan, etag, err := f.deps.Store.FetchUpgradePlan(ctx, f.agentID, f.channel)
+	if errors.Is(err, store.ErrPlanNotFound) {
+		if f.etag == "" {
+			return planEvent{}, false, nil
+		}
+		f.etag = ""
+		return planEvent{NoPlan: true}, true, nil
+	}
+	if err != nil {
+		return planEvent{}, false, err
+	}
+	if etag == f.etag {
+		return planEvent{}, false, nil
+	}
+	f.etag = etag
+	return planEvent{ETag: etag, Plan: &plan}, true, nil
+}
+
+// planStreamHandler holds the connection open and pushes the agent's plan,
+// with its ETag, as a JSON line whenever an admin or a rollout changes it,
+// so agents do not wait for their next poll. The first line is the current
+// plan unless it matches If-None-Match. Blank lines are keepalives, as on
+// the monitor stream.
+func planStreamHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := requestAgentID(r)
+
+		rc := http.NewResponseController(w)
+		// Streams outlive the server-wide write timeout.
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			deps.Logger.Printf("plan stream: clear write deadline for agent %s: %v", agentID, err)
+		}
+
+		feed := &planFeed{deps: deps, agentID: agentID, channel: r.URL.Query().Get("channel"), etag: r.Header.Get("If-None-Match")}
+		updates, unsubscribe := feed.subscribe(hub)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			deps.Logger.Printf("plan stream: flush unsupported: %v", err)
+			return
+		}
+
+		enc := json.NewEncoder(w)
+		push := func() error {
+			event, ok, err := feed.next(r.Context())
+			if err != nil || !ok {
+				return err
+			}
+			if err := enc.Encode(event); err != nil {
+				return err
+			}
+			return rc.Flush()
+		}
+
+		keepalive := cfg.MonitorStreamKeepalive
+		if keepalive <= 0 {
+			keepalive = defaultMonitorStreamKeepalive
+		}
+		ticker := time.NewTicker(keepalive)
+		defer ticker.Stop()
+
+		if err := push(); err != nil {
+			deps.Logger.Printf("plan stream push failed for agent %s: %v", agentID, err)
+			return
+		}
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-updates:
+				if err := push(); err != nil {
+					deps.Logger.Printf("plan stream push failed for agent %s: %v", agentID, err)
+					return
+				}
+			case <-ticker.C:
+				if _, err := w.Write([]byte("\n")); err != nil {
+					return
+				}
+				if err := rc.Flush(); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestPlanStreamPushesPlanChanges(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token", MonitorStreamKeepalive: 20 * time.Millisecond}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+	admin := func(method, path, body string) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer token")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("%s %s: status %d", method, path, resp.StatusCode)
+		}
+	}
+	admin(http.MethodPost, "/api/admin/v1/upgrade/plan", `{"channel":"stable","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+planStreamRoute+"?channel=stable", nil)
+	req.Header.Set("X-Agent-ID", "agent-1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("open stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("stream status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	next := func() planEvent {
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatalf("read stream: %v", err)
+			}
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			var event planEvent
+			if err := json.Unmarshal([]byte(line), &event); err != nil {
+				t.Fatalf("decode stream line: %v", err)
+			}
+			return event
+		}
+	}
+
+	first := next()
+	if first.Plan == nil || first.Plan.Artifact.Version != "1.2.0" || first.ETag == "" {
+		t.Fatalf("unexpected first event %+v", first)
+	}
+	admin(http.MethodPost, "/api/admin/v1/upgrade/plan", `{"channel":"stable","artifact":{"version":"1.3.0","url":"https://a.example.com/b.tar.gz","sha256":"def"}}`)
+	second := next()
+	if second.Plan == nil || second.Plan.Artifact.Version != "1.3.0" || second.ETag == first.ETag {
+		t.Fatalf("unexpected pushed plan %+v", second)
+	}
+	admin(http.MethodPost, "/api/admin/v1/upgrade/rollouts", `{"channel":"stable","cohorts":[100]}`)
+	admin(http.MethodPost, "/api/admin/v1/upgrade/rollouts/stable/pause", "")
+	// Starting the rollout sets the plan's percentage, which is pushed too.
+	for i := 0; ; i++ {
+		event := next()
+		if event.Plan != nil && event.Plan.Paused {
+			break
+		}
+		if i == 1 {
+			t.Fatalf("pause not pushed: %+v", event)
+		}
+	}
+	// Withdrawing the plan is announced rather than left to the next poll.
+	admin(http.MethodDelete, "/api/admin/v1/upgrade/plan/channel:stable", "")
+	if event := next(); !event.NoPlan || event.Plan != nil {
+		t.Fatalf("expected no_plan event, got %+v", event)
+	}
+	admin(http.MethodPost, "/api/admin/v1/upgrade/plan", `{"channel":"stable","artifact":{"version":"1.4.0","url":"https://a.example.com/c.tar.gz","sha256":"ghi"}}`)
+	if event := next(); event.Plan == nil || event.Plan.Artifact.Version != "1.4.0" {
+		t.Fatalf("unexpected plan after re-publishing %+v", event)
+	}
+}
+
+func TestPlanWatchStoreWakesOnlyAffectedStreams(t *testing.T) {
+	hub := newSnapshotHub()
+	watch := planWatchStore{Store: store.NewMemoryStore(), hub: hub}
+	deps := Dependencies{Store: watch}
+	stable := &planFeed{deps: deps, agentID: "agent-1", channel: ""}
+	canary := &planFeed{deps: deps, agentID: "agent-2", channel: "Canary"}
+	stableUpdates, unsubscribeStable := stable.subscribe(hub)
+	defer unsubscribeStable()
+	canaryUpdates, unsubscribeCanary := canary.subscribe(hub)
+	defer unsubscribeCanary()
+
+	woken := func(ch <-chan struct{}) bool {
+		select {
+		case <-ch:
+			return true
+		default:
+			return false
+		}
+	}
+	ctx := context.Background()
+	if _, _, err := watch.UpsertUpgradePlan(ctx, store.PlanInput{Channel: "canary", Version: "1.2.0", ArtifactURL: "https://a.example.com/a.tar.gz", ArtifactSHA256: "abc"}); err != nil {
+		t.Fatalf("upsert canary plan: %v", err)
+	}
+	if woken(stableUpdates) || !woken(canaryUpdates) {
+		t.Fatalf("canary plan should wake only the canary stream")
+	}
+	if _, _, err := watch.UpsertUpgradePlan(ctx, store.PlanInput{AgentID: "agent-1", Version: "1.2.0", ArtifactURL: "https://a.example.com/a.tar.gz", ArtifactSHA256: "abc"}); err != nil {
+		t.Fatalf("upsert agent plan: %v", err)
+	}
+	if !woken(stableUpdates) || woken(canaryUpdates) {
+		t.Fatalf("agent plan should wake only that agent's stream")
+	}
+	if err := watch.DeleteUpgradePlan(ctx, "channel:canary"); err != nil {
+		t.Fatalf("delete canary plan: %v", err)
+	}
+	if woken(stableUpdates) || !woken(canaryUpdates) {
+		t.Fatalf("deleting the canary plan should wake only the canary stream")
+	}
+}
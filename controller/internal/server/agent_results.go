@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/results"
+)
+
+const (
+	resultsRoute = "/api/agent/v1/results"
+	// maxResultsBytes caps an upload body; a full batch of MaxResultsPerBatch
+	// results stays well below it.
+	maxResultsBytes = 8 << 20
+)
+
+type resultsResponse struct {
+	Accepted  int  `json:"accepted"`
+	Duplicate bool `json:"duplicate,omitempty"`
+}
+
+// resultsHandler ingests a result envelope. Results the agent already
+// delivered, such as a retry after a lost response, are acknowledged without
+// being stored again, so the agent does not keep resending them.
+func resultsHandler(cfg Config, deps Dependencies, stats *statsCollector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := requestAgentID(r)
+
+		var envelope results.Envelope
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxResultsBytes)).Decode(&envelope); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "result batch too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		envelope.AgentID = agentID
+		if err := envelope.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := storeResults(r.Context(), deps, stats, envelope)
+		if err != nil {
+			http.Error(w, "unable to store results", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+// storeResults appends a validated envelope, acknowledging duplicates.
+func storeResults(ctx context.Context, deps Dependencies, stats *statsCollector, envelope results.Envelope) (resultsResponse, error) {
+	batch := results.Batch{Envelope: envelope, ReceivedAt: time.Now().UTC()}
+	stored, err := deps.Results.Append(ctx, batch)
+	switch {
+	case errors.Is(err, results.ErrDuplicateBatch):
+		return resultsResponse{Duplicate: true}, nil
+	case err != nil:
+		deps.Logger.Printf("store results failed for agent %s batch %d: %v", envelope.AgentID, envelope.BatchSeq, err)
+		return resultsResponse{}, err
+	}
+	stats.addResults(stored)
+	return resultsResponse{Accepted: stored}, nil
+}
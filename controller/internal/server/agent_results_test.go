@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/results"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAgentResultsIngestionDeduplicatesBatches(t *testing.T) {
+	resultStore := results.NewMemoryStore()
+	srv := New(Config{}, Dependencies{
+		Logger:  log.New(io.Discard, "", 0),
+		Store:   store.NewMemoryStore(),
+		Results: resultStore,
+	})
+
+	post := func(agentID, body string) (int, resultsResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/agent/v1/results", strings.NewReader(body))
+		req.Header.Set("X-Agent-ID", agentID)
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		var out resultsResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+		}
+		return rec.Code, out
+	}
+
+	batch := `{"agent_id":"spoofed","sent_at":"2026-10-01T00:00:00Z","batch_seq":7,"labels":{"site":"ATL-1"},"results":[
+		{"monitor_id":"mon-1","ts":"2026-10-01T00:00:00Z","proto":"icmp","ip":"192.0.2.1","rtt_ms":12.5,"success":true,"seq":1},
+		{"monitor_id":"mon-2","ts":"2026-10-01T00:00:00Z","proto":"tcp","ip":"192.0.2.2","success":false,"seq":2}]}`
+	if code, out := post("agent-1", batch); code != http.StatusOK || out.Accepted != 2 || out.Duplicate {
+		t.Fatalf("first upload: status %d, %+v", code, out)
+	}
+	if code, out := post("agent-1", batch); code != http.StatusOK || out.Accepted != 0 || !out.Duplicate {
+		t.Fatalf("retried upload: status %d, %+v", code, out)
+	}
+	// A failed send is retried in a new batch, with a fresh batch_seq and
+	// sent_at, alongside newer results; only the newer ones are stored.
+	regrouped := `{"sent_at":"2026-10-01T00:00:30Z","batch_seq":8,"results":[
+		{"monitor_id":"mon-2","ts":"2026-10-01T00:00:00Z","proto":"tcp","ip":"192.0.2.2","success":false,"seq":2},
+		{"monitor_id":"mon-1","ts":"2026-10-01T00:00:30Z","proto":"icmp","ip":"192.0.2.1","success":true,"seq":3}]}`
+	if code, out := post("agent-1", regrouped); code != http.StatusOK || out.Accepted != 1 || out.Duplicate {
+		t.Fatalf("regrouped upload: status %d, %+v", code, out)
+	}
+	// A restarted agent counts batches and probes from 1 again.
+	restarted := strings.ReplaceAll(batch, "2026-10-01T00:00:00Z", "2026-10-01T01:00:00Z")
+	if code, out := post("agent-1", restarted); code != http.StatusOK || out.Accepted != 2 {
+		t.Fatalf("upload after restart: status %d, %+v", code, out)
+	}
+
+	stored := resultStore.Batches("agent-1")
+	if len(stored) != 3 || len(stored[1].Results) != 1 || stored[0].BatchSeq != 7 || stored[0].Labels["site"] != "ATL-1" || stored[0].Results[0].RTTMilliseconds != 12.5 {
+		t.Fatalf("unexpected stored batches %+v", stored)
+	}
+	if len(resultStore.Batches("spoofed")) != 0 {
+		t.Fatalf("agent_id must come from the credentials")
+	}
+	if got := srv.stats.results.Load(); got != 5 {
+		t.Fatalf("expected 5 ingested results counted, got %d", got)
+	}
+
+	for name, body := range map[string]string{
+		"no results":   `{"sent_at":"2026-10-01T00:00:00Z","batch_seq":8,"results":[]}`,
+		"no batch_seq": `{"sent_at":"2026-10-01T00:00:00Z","results":[{"monitor_id":"mon-1","ts":"2026-10-01T00:00:00Z"}]}`,
+		"no monitor":   `{"sent_at":"2026-10-01T00:00:00Z","batch_seq":9,"results":[{"ts":"2026-10-01T00:00:00Z"}]}`,
+		"bad json":     `{`,
+	} {
+		if code, _ := post("agent-1", body); code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", name, code)
+		}
+	}
+
+	sample, err := srv.stats.sample(context.Background())
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	if sample.ResultsIngested != 5 {
+		t.Fatalf("unexpected results ingested %d", sample.ResultsIngested)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	defaultAuditPageSize = 100
+	maxAuditPageSize     = 1000
+)
+
+// auditMiddleware records every admin request that can change state (any
+// method but GET and HEAD) in the store's audit log once it has been
+// answered, including requests refused for lacking a scope. It wraps handlers
+// behind auth.Require so the actor is known.
+func auditMiddleware(deps Dependencies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+			body := &digestReader{ReadCloser: r.Body, sum: sha256.New()}
+			r.Body = body
+			rec := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r)
+			// Digest the whole payload even when the handler stopped early.
+			_, _ = io.Copy(io.Discard, body)
+
+			entry := store.AuditEntry{
+				Method:        r.Method,
+				Path:          r.URL.Path,
+				Status:        rec.status,
+				PayloadSHA256: hex.EncodeToString(body.sum.Sum(nil)),
+				PayloadBytes:  body.n,
+				RemoteAddr:    r.RemoteAddr,
+			}
+			if p, ok := auth.FromContext(r.Context()); ok {
+				entry.Actor, entry.Scheme = p.Subject, p.Scheme
+			}
+			// Record the mutation even if the client has gone away.
+			if _, err := deps.Store.RecordAuditEntry(context.WithoutCancel(r.Context()), entry); err != nil {
+				deps.Logger.Printf("record audit entry for %s %s by %s failed: %v", entry.Method, entry.Path, entry.Actor, err)
+			}
+		})
+	}
+}
+
+// digestReader counts the bytes read through it and, when sum is set,
+// hashes them.
+type digestReader struct {
+	io.ReadCloser
+	sum hash.Hash
+	n   int64
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	if d.sum != nil {
+		d.sum.Write(p[:n])
+	}
+	d.n += int64(n)
+	return n, err
+}
+
+// statusResponseWriter records the status code and body length of a
+// response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	bytes       int64
+}
+
+func (w *statusResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status, w.wroteHeader = code, true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusResponseWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush monitor streams.
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// adminAuditHandler serves the admin audit log, newest first, filtered by
+// actor, method, path (a prefix) and a since/until range (RFC 3339). Pass the
+// response's next_cursor as cursor to fetch the following page.
+func adminAuditHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		filter := store.AuditFilter{
+			Actor:      query.Get("actor"),
+			Method:     query.Get("method"),
+			PathPrefix: query.Get("path"),
+		}
+		for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+			raw := query.Get(name)
+			if raw == "" {
+				continue
+			}
+			ts, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s %q (want RFC 3339)", name, raw), http.StatusBadRequest)
+				return
+			}
+			*dst = ts
+		}
+		if raw := query.Get("cursor"); raw != "" {
+			before, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil || before <= 0 {
+				http.Error(w, "invalid cursor", http.StatusBadRequest)
+				return
+			}
+			filter.Before = before
+		}
+		limit := defaultAuditPageSize
+		if raw := query.Get("limit"); raw != "" {
+			if v, err := strconv.Atoi(raw); err == nil && v > 0 {
+				limit = min(v, maxAuditPageSize)
+			}
+		}
+		// Fetch one extra row to learn whether another page follows.
+		filter.Limit = limit + 1
+
+		entries, err := deps.Store.ListAuditEntries(r.Context(), filter)
+		if err != nil {
+			deps.Logger.Printf("list audit entries failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		var next string
+		if len(entries) > limit {
+			entries = entries[:limit]
+			next = strconv.FormatInt(entries[limit-1].ID, 10)
+		}
+		if entries == nil {
+			entries = []store.AuditEntry{}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items      []store.AuditEntry `json:"items"`
+			NextCursor string             `json:"next_cursor,omitempty"`
+		}{Items: entries, NextCursor: next})
+	}
+}
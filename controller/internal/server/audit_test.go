@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminMutationsAreAudited(t *testing.T) {
+	cfg := Config{
+		AdminBearerToken: "token",
+		AdminAPIKeys:     []auth.APIKey{{Name: "audit", Key: "audit-key", Scopes: []string{auth.ScopeRead}}},
+	}
+	srv := New(cfg, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			req.Header.Set("X-API-Key", key)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	list := func(query url.Values) ([]store.AuditEntry, string) {
+		rec := do(http.MethodGet, "/api/admin/v1/audit?"+query.Encode(), "audit-key", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("audit %v: status %d: %s", query, rec.Code, rec.Body.String())
+		}
+		var resp struct {
+			Items      []store.AuditEntry `json:"items"`
+			NextCursor string             `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return resp.Items, resp.NextCursor
+	}
+
+	const plan = `{"channel":"stable","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/plan", "", plan); rec.Code != http.StatusOK {
+		t.Fatalf("upsert plan: status %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/settings/notifications", "", `{"notify_on_publish":false}`); rec.Code != http.StatusOK {
+		t.Fatalf("update settings: status %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/plan", "audit-key", plan); rec.Code != http.StatusForbidden {
+		t.Fatalf("auditor push: expected 403, got %d", rec.Code)
+	}
+	do(http.MethodGet, "/api/admin/v1/upgrade/plans", "", "")
+	do(http.MethodPost, "/api/admin/v1/upgrade/plan", "wrong-key", plan)
+
+	entries, _ := list(nil)
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 audited mutations, got %+v", entries)
+	}
+	refused, settings, upsert := entries[0], entries[1], entries[2]
+	sum := sha256.Sum256([]byte(plan))
+	if upsert.Actor != "admin" || upsert.Scheme != "bearer" || upsert.Method != http.MethodPost ||
+		upsert.Path != "/api/admin/v1/upgrade/plan" || upsert.Status != http.StatusOK ||
+		upsert.PayloadSHA256 != hex.EncodeToString(sum[:]) || upsert.PayloadBytes != int64(len(plan)) || upsert.CreatedAt.IsZero() {
+		t.Fatalf("unexpected upsert entry %+v", upsert)
+	}
+	if settings.Path != "/api/admin/v1/settings/notifications" {
+		t.Fatalf("unexpected settings entry %+v", settings)
+	}
+	if refused.Actor != "audit" || refused.Status != http.StatusForbidden || refused.PayloadSHA256 != upsert.PayloadSHA256 {
+		t.Fatalf("unexpected refused entry %+v", refused)
+	}
+
+	if got, _ := list(url.Values{"actor": {"admin"}, "path": {"/api/admin/v1/upgrade/"}}); len(got) != 1 || got[0].ID != upsert.ID {
+		t.Fatalf("filtered audit %+v", got)
+	}
+	page, next := list(url.Values{"limit": {"2"}})
+	if len(page) != 2 || next == "" {
+		t.Fatalf("first page %+v next %q", page, next)
+	}
+	if rest, next := list(url.Values{"limit": {"2"}, "cursor": {next}}); len(rest) != 1 || rest[0].ID != upsert.ID || next != "" {
+		t.Fatalf("second page %+v next %q", rest, next)
+	}
+	if rec := do(http.MethodGet, "/api/admin/v1/audit?cursor=abc", "", ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid cursor: expected 400, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,480 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v3"
+
+	"github.com/pingsantohq/controller/internal/attest"
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/issuer"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	enrollRoute      = "/api/agent/v1/enroll"
+	certificateRoute = "/api/agent/v1/certificate"
+
+	defaultEnrollmentTokenTTL = 24 * time.Hour
+	maxEnrollmentTokenTTL     = 30 * 24 * time.Hour
+)
+
+// CertIssuer signs client credentials for enrolling agents; issuer.CA is the
+// built-in implementation.
+type CertIssuer interface {
+	IssueAgentCertificate(ctx context.Context, agentID string) (issuer.Credentials, error)
+	// SignAgentCSR signs a certificate for agentID over the key in a PEM
+	// certificate request; the credentials carry no key.
+	SignAgentCSR(ctx context.Context, agentID string, csrPEM []byte) (issuer.Credentials, error)
+}
+
+// Attestor verifies cloud instance identity documents; attest.Verifier is
+// the built-in implementation.
+type Attestor interface {
+	Verify(ctx context.Context, doc attest.Document) (attest.Identity, error)
+}
+
+// OIDCVerifier checks ID tokens presented instead of an enrollment token;
+// attest.OIDC is the built-in implementation.
+type OIDCVerifier interface {
+	Verify(ctx context.Context, token string) (attest.Operator, error)
+}
+
+type mintEnrollmentTokenRequest struct {
+	// TTL is a Go duration such as "24h"; it defaults to 24h and may not
+	// exceed 30 days.
+	TTL     string            `json:"ttl"`
+	AgentID string            `json:"agent_id"`
+	Labels  map[string]string `json:"labels"`
+	Note    string            `json:"note"`
+	// CloudProvider and CloudAccounts restrict the token to hosts attesting
+	// an instance identity in one of these accounts (AWS account IDs, GCP
+	// project IDs or Azure subscription IDs).
+	CloudProvider string   `json:"cloud_provider"`
+	CloudAccounts []string `json:"cloud_accounts"`
+}
+
+type mintEnrollmentTokenResponse struct {
+	// Token is the secret to pass to `pingsanto-agent enroll --token`. It is
+	// only returned here; the controller keeps a hash.
+	Token string `json:"token"`
+	store.EnrollmentToken
+}
+
+type enrollRequest struct {
+	Token       string            `json:"token"`
+	Labels      map[string]string `json:"labels"`
+	AgentID     string            `json:"agent_id"`
+	Attestation *attest.Document  `json:"attestation"`
+}
+
+type enrollResponse struct {
+	AgentID    string            `json:"agent_id"`
+	CertPEM    string            `json:"certificate_pem"`
+	KeyPEM     string            `json:"private_key_pem"`
+	CAPEM      string            `json:"ca_pem"`
+	ConfigYAML string            `json:"config_yaml"`
+	Labels     map[string]string `json:"labels,omitempty"`
+}
+
+func adminMintEnrollmentTokenHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req mintEnrollmentTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		ttl := defaultEnrollmentTokenTTL
+		if raw := strings.TrimSpace(req.TTL); raw != "" {
+			parsed, err := time.ParseDuration(raw)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "ttl must be a positive duration such as 24h", http.StatusBadRequest)
+				return
+			}
+			if parsed > maxEnrollmentTokenTTL {
+				http.Error(w, "ttl may not exceed 720h", http.StatusBadRequest)
+				return
+			}
+			ttl = parsed
+		}
+		for key := range req.Labels {
+			if strings.TrimSpace(key) == "" {
+				http.Error(w, "label keys must not be empty", http.StatusBadRequest)
+				return
+			}
+		}
+		provider := strings.ToLower(strings.TrimSpace(req.CloudProvider))
+		switch provider {
+		case "", attest.ProviderAWS, attest.ProviderGCP, attest.ProviderAzure:
+		default:
+			http.Error(w, "cloud_provider must be aws, gcp or azure", http.StatusBadRequest)
+			return
+		}
+		var accounts []string
+		for _, account := range req.CloudAccounts {
+			if account = strings.TrimSpace(account); account != "" {
+				accounts = append(accounts, account)
+			}
+		}
+		if provider == "" && len(accounts) > 0 {
+			http.Error(w, "cloud_accounts requires cloud_provider", http.StatusBadRequest)
+			return
+		}
+
+		secret, err := newEnrollmentSecret()
+		if err != nil {
+			deps.Logger.Printf("generate enrollment token failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		token, err := deps.Store.CreateEnrollmentToken(r.Context(), store.EnrollmentToken{
+			AgentID:       strings.TrimSpace(req.AgentID),
+			Labels:        req.Labels,
+			Note:          strings.TrimSpace(req.Note),
+			ExpiresAt:     time.Now().UTC().Add(ttl),
+			CloudProvider: provider,
+			CloudAccounts: accounts,
+		}, store.HashEnrollmentToken(secret))
+		if err != nil {
+			deps.Logger.Printf("create enrollment token failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(mintEnrollmentTokenResponse{Token: secret, EnrollmentToken: token})
+	}
+}
+
+func adminListEnrollmentTokensHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := 100
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = parsed
+		}
+		status := strings.TrimSpace(r.URL.Query().Get("status"))
+		switch status {
+		case "", store.EnrollmentTokenActive, store.EnrollmentTokenUsed, store.EnrollmentTokenRevoked, store.EnrollmentTokenExpired:
+		default:
+			http.Error(w, fmt.Sprintf("invalid status %q", status), http.StatusBadRequest)
+			return
+		}
+		tokens, err := deps.Store.ListEnrollmentTokens(r.Context(), status, limit)
+		if err != nil {
+			deps.Logger.Printf("list enrollment tokens failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		if tokens == nil {
+			tokens = []store.EnrollmentToken{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Tokens []store.EnrollmentToken `json:"tokens"`
+		}{Tokens: tokens})
+	}
+}
+
+func adminRevokeEnrollmentTokenHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, err := deps.Store.RevokeEnrollmentToken(r.Context(), mux.Vars(r)["token_id"])
+		switch {
+		case errors.Is(err, store.ErrEnrollmentTokenNotFound):
+			http.Error(w, "enrollment token not found", http.StatusNotFound)
+			return
+		case errors.Is(err, store.ErrEnrollmentTokenUsed):
+			http.Error(w, "enrollment token already used", http.StatusConflict)
+			return
+		case err != nil:
+			deps.Logger.Printf("revoke enrollment token failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(token)
+	}
+}
+
+// enrollHandler redeems a single-use enrollment token, or an operator's OIDC
+// ID token sent as a bearer token, and returns freshly issued credentials.
+// The token is the credential, so the route carries no agent
+// authentication. A request may only keep a chosen agent_id with a token
+// scoped to it, or when it presents a verified client certificate for that
+// agent, as `enroll --renew` does.
+func enrollHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	certAuth := agentCertAuthenticator(cfg, deps)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if deps.Issuer == nil {
+			http.Error(w, "enrollment is not configured on this controller", http.StatusServiceUnavailable)
+			return
+		}
+		var req enrollRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		var proven bool
+		if req.AgentID != "" && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			p, err := certAuth.Authenticate(r)
+			proven = err == nil && p.Subject == strings.TrimSpace(req.AgentID)
+		}
+		if strings.TrimSpace(req.Token) == "" {
+			idToken, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || strings.TrimSpace(idToken) == "" || deps.OIDC == nil {
+				http.Error(w, "enrollment token required", http.StatusUnauthorized)
+				return
+			}
+			enrollWithIDToken(w, r, deps, req, strings.TrimSpace(idToken), proven)
+			return
+		}
+
+		var cloud store.CloudIdentity
+		if req.Attestation != nil {
+			if deps.Attestor == nil {
+				deps.Logger.Printf("enrollment: ignoring %s attestation, cloud attestation is not configured", req.Attestation.Provider)
+			} else {
+				identity, err := deps.Attestor.Verify(r.Context(), *req.Attestation)
+				if err != nil {
+					deps.Logger.Printf("enrollment rejected: %v (requested agent %q)", err, req.AgentID)
+					http.Error(w, "invalid, expired or already used enrollment token", http.StatusUnauthorized)
+					return
+				}
+				cloud = store.CloudIdentity{Provider: identity.Provider, Account: identity.Account}
+			}
+		}
+
+		token, err := deps.Store.RedeemEnrollmentToken(r.Context(), store.HashEnrollmentToken(req.Token), req.AgentID, proven, cloud)
+		if errors.Is(err, store.ErrEnrollmentTokenInvalid) {
+			deps.Logger.Printf("enrollment rejected: invalid token (requested agent %q, cloud %q/%q)", req.AgentID, cloud.Provider, cloud.Account)
+			http.Error(w, "invalid, expired or already used enrollment token", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			deps.Logger.Printf("redeem enrollment token failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		creds, err := deps.Issuer.IssueAgentCertificate(r.Context(), token.UsedBy)
+		if err != nil {
+			deps.Logger.Printf("issue certificate for agent %s (token %s) failed: %v", token.UsedBy, token.ID, err)
+			http.Error(w, "unable to issue certificate", http.StatusInternalServerError)
+			return
+		}
+		if cloud.Provider != "" {
+			deps.Logger.Printf("agent %s enrolled with token %s from %s account %s", token.UsedBy, token.ID, cloud.Provider, cloud.Account)
+		} else {
+			deps.Logger.Printf("agent %s enrolled with token %s", token.UsedBy, token.ID)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(enrollResponse{
+			AgentID: token.UsedBy,
+			CertPEM: string(creds.CertPEM),
+			KeyPEM:  string(creds.KeyPEM),
+			CAPEM:   string(creds.CAPEM),
+			Labels:  token.Labels,
+		})
+	}
+}
+
+// enrollWithIDToken issues credentials to an operator-approved host. The
+// agent keeps its ID only when it proved it over mTLS; otherwise it gets a
+// fresh one, and the labels it asked for.
+func enrollWithIDToken(w http.ResponseWriter, r *http.Request, deps Dependencies, req enrollRequest, idToken string, proven bool) {
+	operator, err := deps.OIDC.Verify(r.Context(), idToken)
+	if err != nil {
+		deps.Logger.Printf("enrollment rejected: %v (requested agent %q)", err, req.AgentID)
+		http.Error(w, "invalid or unauthorized id token", http.StatusUnauthorized)
+		return
+	}
+	agentID := store.NewAgentID()
+	if proven {
+		agentID = strings.TrimSpace(req.AgentID)
+	}
+	creds, err := deps.Issuer.IssueAgentCertificate(r.Context(), agentID)
+	if err != nil {
+		deps.Logger.Printf("issue certificate for agent %s (oidc subject %s) failed: %v", agentID, operator.Subject, err)
+		http.Error(w, "unable to issue certificate", http.StatusInternalServerError)
+		return
+	}
+	deps.Logger.Printf("agent %s enrolled by oidc subject %s (%s) via %s", agentID, operator.Subject, operator.Email, operator.Rule)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(enrollResponse{
+		AgentID: agentID,
+		CertPEM: string(creds.CertPEM),
+		KeyPEM:  string(creds.KeyPEM),
+		CAPEM:   string(creds.CAPEM),
+		Labels:  req.Labels,
+	})
+}
+
+type renewCertificateRequest struct {
+	CSRPEM string `json:"csr_pem"`
+}
+
+type renewCertificateResponse struct {
+	CertPEM string `json:"certificate_pem"`
+	CAPEM   string `json:"ca_pem"`
+}
+
+// renewCertificateHandler signs a new client certificate for an agent that
+// proves its identity with its current one, answering a renew_certificate
+// directive without a token. The agent keeps its private key; it sends only
+// a certificate request, whose subject is replaced by the agent ID.
+func renewCertificateHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if deps.Issuer == nil {
+			http.Error(w, "enrollment is not configured on this controller", http.StatusServiceUnavailable)
+			return
+		}
+		p, _ := auth.FromContext(r.Context())
+		if p.Scheme != "mtls" {
+			http.Error(w, "certificate renewal requires a client certificate", http.StatusForbidden)
+			return
+		}
+		var req renewCertificateRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		creds, err := deps.Issuer.SignAgentCSR(r.Context(), p.Subject, []byte(req.CSRPEM))
+		if err != nil {
+			deps.Logger.Printf("renew certificate for agent %s failed: %v", p.Subject, err)
+			http.Error(w, "invalid certificate request", http.StatusBadRequest)
+			return
+		}
+		deps.Logger.Printf("agent %s renewed its certificate", p.Subject)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(renewCertificateResponse{CertPEM: string(creds.CertPEM), CAPEM: string(creds.CAPEM)})
+	}
+}
+
+type enrollmentBundleRequest struct {
+	AgentID string            `json:"agent_id"`
+	Labels  map[string]string `json:"labels"`
+	// Server is the controller URL the agent will connect to; it defaults to
+	// the URL this request was made against.
+	Server string `json:"server"`
+}
+
+// enrollmentBundleState is the state.yaml subset `pingsanto-agent enroll
+// --from-bundle` reads.
+type enrollmentBundleState struct {
+	AgentID string            `yaml:"agent_id"`
+	Server  string            `yaml:"server"`
+	Labels  map[string]string `yaml:"labels,omitempty"`
+}
+
+// adminEnrollmentBundleHandler issues credentials for a host that cannot
+// reach the controller and returns them as a tar.gz to install via USB or a
+// disk image. The bundle holds the agent's private key, so it is served
+// once and never stored.
+func adminEnrollmentBundleHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if deps.Issuer == nil {
+			http.Error(w, "enrollment is not configured on this controller", http.StatusServiceUnavailable)
+			return
+		}
+		var req enrollmentBundleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		agentID := strings.TrimSpace(req.AgentID)
+		if agentID == "" {
+			agentID = store.NewAgentID()
+		}
+		server := strings.TrimSpace(req.Server)
+		if server == "" {
+			server = requestBaseURL(r)
+		}
+
+		creds, err := deps.Issuer.IssueAgentCertificate(r.Context(), agentID)
+		if err != nil {
+			deps.Logger.Printf("issue bundle certificate for agent %s failed: %v", agentID, err)
+			http.Error(w, "unable to issue certificate", http.StatusInternalServerError)
+			return
+		}
+		state, err := yaml.Marshal(enrollmentBundleState{AgentID: agentID, Server: server, Labels: req.Labels})
+		if err != nil {
+			deps.Logger.Printf("encode bundle state for agent %s failed: %v", agentID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		deps.Logger.Printf("issued offline enrollment bundle for agent %s", agentID)
+
+		now := time.Now().UTC()
+		name := fmt.Sprintf("pingsanto-enroll_%s.tar.gz", agentID)
+		w.Header().Set("Content-Type", "application/gzip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+		w.Header().Set("Cache-Control", "no-store")
+		files := []struct {
+			name string
+			data []byte
+		}{
+			{"state.yaml", state},
+			{"client.crt", creds.CertPEM},
+			{"client.key", creds.KeyPEM},
+			{"ca.pem", creds.CAPEM},
+		}
+		gw := gzip.NewWriter(w)
+		tw := tar.NewWriter(gw)
+		prefix := "pingsanto-enroll_" + agentID + "/"
+		for _, f := range files {
+			header := &tar.Header{Name: prefix + f.name, Mode: 0o600, Size: int64(len(f.data)), ModTime: now}
+			if err := tw.WriteHeader(header); err != nil {
+				deps.Logger.Printf("write enrollment bundle for %s failed: %v", agentID, err)
+				return
+			}
+			if _, err := tw.Write(f.data); err != nil {
+				deps.Logger.Printf("write enrollment bundle for %s failed: %v", agentID, err)
+				return
+			}
+		}
+		err = tw.Close()
+		if err == nil {
+			err = gw.Close()
+		}
+		if err != nil {
+			deps.Logger.Printf("write enrollment bundle for %s failed: %v", agentID, err)
+		}
+	}
+}
+
+// requestBaseURL reconstructs the scheme and host a request was made to.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func newEnrollmentSecret() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
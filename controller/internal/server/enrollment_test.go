@@ -0,0 +1,420 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/attest"
+	"github.com/pingsantohq/controller/internal/issuer"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestEnrollmentTokenIsSingleUse(t *testing.T) {
+	caCert, caKey := testCA(t, "agent CA")
+	keyDER, _ := x509.MarshalECPrivateKey(caKey)
+	ca, err := issuer.NewCA(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil, 0,
+	)
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	srv := New(Config{AdminBearerToken: "token", ReplayProtection: ReplayProtectionEnforce}, Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+		Issuer: ca,
+	})
+	do := func(method, path, body string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if admin {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := do(http.MethodPost, "/api/admin/v1/enrollment/tokens", `{"ttl":"1h","labels":{"site":"ATL-1"}}`, true)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("mint status %d: %s", rr.Code, rr.Body.String())
+	}
+	var minted mintEnrollmentTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&minted); err != nil || minted.Token == "" || minted.Status != store.EnrollmentTokenActive {
+		t.Fatalf("unexpected mint response %+v, %v", minted, err)
+	}
+
+	rr = do(http.MethodPost, enrollRoute, `{"token":"`+minted.Token+`","labels":{"site":"other","isp":"Comcast"}}`, false)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll status %d: %s", rr.Code, rr.Body.String())
+	}
+	var enrolled enrollResponse
+	if err := json.NewDecoder(rr.Body).Decode(&enrolled); err != nil {
+		t.Fatalf("decode enroll response: %v", err)
+	}
+	block, _ := pem.Decode([]byte(enrolled.CertPEM))
+	if block == nil {
+		t.Fatalf("no certificate in enroll response")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || cert.Subject.CommonName != enrolled.AgentID || cert.CheckSignatureFrom(caCert) != nil {
+		t.Fatalf("unexpected certificate for %s: %v", enrolled.AgentID, err)
+	}
+	if enrolled.Labels["site"] != "ATL-1" || enrolled.KeyPEM == "" || enrolled.CAPEM == "" {
+		t.Fatalf("unexpected enroll response %+v", enrolled)
+	}
+
+	if rr := do(http.MethodPost, enrollRoute, `{"token":"`+minted.Token+`"}`, false); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected reused token to be rejected, got %d", rr.Code)
+	}
+	if rr := do(http.MethodDelete, "/api/admin/v1/enrollment/tokens/"+minted.ID, "", true); rr.Code != http.StatusConflict {
+		t.Fatalf("expected revoking a used token to conflict, got %d", rr.Code)
+	}
+
+	rr = do(http.MethodPost, "/api/admin/v1/enrollment/tokens", `{}`, true)
+	json.NewDecoder(rr.Body).Decode(&minted)
+	if rr := do(http.MethodDelete, "/api/admin/v1/enrollment/tokens/"+minted.ID, "", true); rr.Code != http.StatusOK {
+		t.Fatalf("revoke status %d", rr.Code)
+	}
+	if rr := do(http.MethodPost, enrollRoute, `{"token":"`+minted.Token+`"}`, false); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected revoked token to be rejected, got %d", rr.Code)
+	}
+
+	rr = do(http.MethodGet, "/api/admin/v1/enrollment/tokens?status=used", "", true)
+	var list struct {
+		Tokens []store.EnrollmentToken `json:"tokens"`
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&list); err != nil || len(list.Tokens) != 1 || list.Tokens[0].UsedBy != enrolled.AgentID {
+		t.Fatalf("unexpected token list %+v, %v", list, err)
+	}
+
+	if rr := do(http.MethodPost, "/api/admin/v1/enrollment/tokens", `{"ttl":"1000h"}`, true); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected oversized ttl to be rejected, got %d", rr.Code)
+	}
+	if rr := do(http.MethodGet, "/api/admin/v1/enrollment/tokens?status=bogus", "", true); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected unknown status to be rejected, got %d", rr.Code)
+	}
+
+	// An unscoped token cannot be used to take over the enrolled agent's ID.
+	mint := func() string {
+		rr := do(http.MethodPost, "/api/admin/v1/enrollment/tokens", `{}`, true)
+		var m mintEnrollmentTokenResponse
+		json.NewDecoder(rr.Body).Decode(&m)
+		return m.Token
+	}
+	claim := `{"token":"` + mint() + `","agent_id":"` + enrolled.AgentID + `"}`
+	if rr := do(http.MethodPost, enrollRoute, claim, false); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected unproven agent_id to be refused, got %d", rr.Code)
+	}
+	// Presenting the agent's own certificate, as `enroll --renew` does, proves it.
+	req := httptest.NewRequest(http.MethodPost, enrollRoute, bytes.NewBufferString(claim))
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}, VerifiedChains: [][]*x509.Certificate{{cert, caCert}}}
+	renewed := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(renewed, req)
+	var again enrollResponse
+	if err := json.NewDecoder(renewed.Body).Decode(&again); renewed.Code != http.StatusOK || err != nil || again.AgentID != enrolled.AgentID {
+		t.Fatalf("renewal with client certificate: status %d, %+v", renewed.Code, again)
+	}
+}
+
+type stubAttestor struct{}
+
+// Verify accepts documents whose Document field is "<provider>:<account>".
+func (stubAttestor) Verify(ctx context.Context, doc attest.Document) (attest.Identity, error) {
+	provider, account, ok := strings.Cut(doc.Document, ":")
+	if !ok || provider != doc.Provider {
+		return attest.Identity{}, attest.ErrUnverified
+	}
+	return attest.Identity{Provider: provider, Account: account}, nil
+}
+
+func TestEnrollmentTokenCloudScope(t *testing.T) {
+	caCert, caKey := testCA(t, "agent CA")
+	keyDER, _ := x509.MarshalECPrivateKey(caKey)
+	ca, err := issuer.NewCA(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil, 0,
+	)
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{
+		Logger:   log.New(io.Discard, "", 0),
+		Store:    store.NewMemoryStore(),
+		Issuer:   ca,
+		Attestor: stubAttestor{},
+	})
+	do := func(method, path, body string, admin bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		if admin {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := do(http.MethodPost, "/api/admin/v1/enrollment/tokens", `{"cloud_accounts":["123456789012"]}`, true); rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected accounts without provider to be rejected, got %d", rr.Code)
+	}
+	rr := do(http.MethodPost, "/api/admin/v1/enrollment/tokens", `{"cloud_provider":"aws","cloud_accounts":["123456789012"]}`, true)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("mint status %d: %s", rr.Code, rr.Body.String())
+	}
+	var minted mintEnrollmentTokenResponse
+	if err := json.NewDecoder(rr.Body).Decode(&minted); err != nil {
+		t.Fatalf("decode mint response: %v", err)
+	}
+
+	for _, body := range []string{
+		`{"token":"` + minted.Token + `"}`,
+		`{"token":"` + minted.Token + `","attestation":{"provider":"aws","document":"aws:999999999999"}}`,
+		`{"token":"` + minted.Token + `","attestation":{"provider":"gcp","document":"gcp:123456789012"}}`,
+		`{"token":"` + minted.Token + `","attestation":{"provider":"aws","document":"forged"}}`,
+	} {
+		if rr := do(http.MethodPost, enrollRoute, body, false); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("expected %s to be rejected, got %d", body, rr.Code)
+		}
+	}
+	rr = do(http.MethodPost, enrollRoute, `{"token":"`+minted.Token+`","attestation":{"provider":"aws","document":"aws:123456789012"}}`, false)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll status %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestAgentRenewsCertificateWithCSR(t *testing.T) {
+	caCert, caKey := testCA(t, "agent CA")
+	keyDER, _ := x509.MarshalECPrivateKey(caKey)
+	ca, err := issuer.NewCA(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil, 0,
+	)
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	srv := New(Config{AgentAuthMode: "mtls,header"}, Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+		Issuer: ca,
+	})
+	creds, err := ca.IssueAgentCertificate(context.Background(), "agt_renew")
+	if err != nil {
+		t.Fatalf("IssueAgentCertificate: %v", err)
+	}
+	block, _ := pem.Decode(creds.CertPEM)
+	current, _ := x509.ParseCertificate(block.Bytes)
+
+	newKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csrDER, _ := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "agt_other"}}, newKey)
+	body, _ := json.Marshal(renewCertificateRequest{CSRPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))})
+	renew := func(withCert bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, certificateRoute, bytes.NewReader(body))
+		if withCert {
+			req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{current}, VerifiedChains: [][]*x509.Certificate{{current, caCert}}}
+		} else {
+			req.Header.Set("X-Agent-ID", "agt_renew")
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := renew(false); rr.Code != http.StatusForbidden {
+		t.Fatalf("expected header-authenticated renewal to be refused, got %d", rr.Code)
+	}
+	rr := renew(true)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("renew status %d: %s", rr.Code, rr.Body.String())
+	}
+	var resp renewCertificateResponse
+	if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+		t.Fatalf("decode renew response: %v", err)
+	}
+	block, _ = pem.Decode([]byte(resp.CertPEM))
+	if block == nil {
+		t.Fatalf("no certificate in renew response")
+	}
+	renewed, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || renewed.Subject.CommonName != "agt_renew" || renewed.CheckSignatureFrom(caCert) != nil {
+		t.Fatalf("unexpected renewed certificate %+v, %v", renewed.Subject, err)
+	}
+	if pub, ok := renewed.PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(&newKey.PublicKey) {
+		t.Fatalf("renewed certificate does not carry the requested key")
+	}
+}
+
+func TestEnrollmentWithOIDCIDToken(t *testing.T) {
+	caCert, caKey := testCA(t, "agent CA")
+	keyDER, _ := x509.MarshalECPrivateKey(caKey)
+	ca, err := issuer.NewCA(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil, 0,
+	)
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	signingKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	var idp *httptest.Server
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{"issuer": idp.URL, "jwks_uri": idp.URL + "/keys"})
+		case "/keys":
+			json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+				"kid": "k1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(signingKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signingKey.E)).Bytes()),
+			}}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+	sign := func(claims map[string]any) string {
+		header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "k1"})
+		payload, _ := json.Marshal(claims)
+		unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+		digest := sha256.Sum256([]byte(unsigned))
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, signingKey, crypto.SHA256, digest[:])
+		return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig)
+	}
+
+	srv := New(Config{}, Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+		Issuer: ca,
+		OIDC: &attest.OIDC{
+			Issuer:   idp.URL,
+			Audience: "pingsanto-agent",
+			Allow:    []attest.ClaimRule{{Claim: "groups", Value: "probe-ops"}},
+		},
+	})
+	enroll := func(body, idToken string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, enrollRoute, bytes.NewBufferString(body))
+		if idToken != "" {
+			req.Header.Set("Authorization", "Bearer "+idToken)
+		}
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+	claims := func(overrides map[string]any) map[string]any {
+		c := map[string]any{"iss": idp.URL, "aud": "pingsanto-agent", "exp": exp, "sub": "alice", "groups": []string{"probe-ops"}}
+		for k, v := range overrides {
+			c[k] = v
+		}
+		return c
+	}
+
+	rr := enroll(`{"labels":{"site":"ATL-1"},"agent_id":"agt-victim"}`, sign(claims(nil)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("enroll status %d: %s", rr.Code, rr.Body.String())
+	}
+	var enrolled enrollResponse
+	if err := json.NewDecoder(rr.Body).Decode(&enrolled); err != nil {
+		t.Fatalf("decode enroll response: %v", err)
+	}
+	block, _ := pem.Decode([]byte(enrolled.CertPEM))
+	if block == nil {
+		t.Fatalf("no certificate in enroll response")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || cert.Subject.CommonName != enrolled.AgentID || enrolled.Labels["site"] != "ATL-1" {
+		t.Fatalf("unexpected enrollment %+v, %v", enrolled, err)
+	}
+	if enrolled.AgentID == "agt-victim" {
+		t.Fatalf("unproven agent_id was honoured")
+	}
+
+	for name, token := range map[string]string{
+		"no token":       "",
+		"wrong group":    sign(claims(map[string]any{"groups": []string{"finance"}})),
+		"wrong audience": sign(claims(map[string]any{"aud": "other-client"})),
+		"wrong issuer":   sign(claims(map[string]any{"iss": "https://evil.example.com"})),
+		"expired":        sign(claims(map[string]any{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"tampered":       sign(claims(nil))[:20] + "x" + sign(claims(nil))[21:],
+	} {
+		if rr := enroll(`{}`, token); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: expected 401, got %d", name, rr.Code)
+		}
+	}
+}
+
+func TestEnrollmentBundleContainsCredentials(t *testing.T) {
+	caCert, caKey := testCA(t, "agent CA")
+	keyDER, _ := x509.MarshalECPrivateKey(caKey)
+	ca, err := issuer.NewCA(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caCert.Raw}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		nil, 0,
+	)
+	if err != nil {
+		t.Fatalf("NewCA: %v", err)
+	}
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore(), Issuer: ca})
+
+	req := httptest.NewRequest(http.MethodPost, "http://central.example.com/api/admin/v1/enrollment/bundles", bytes.NewBufferString(`{"agent_id":"agt_air","labels":{"site":"ATL-1"}}`))
+	req.Header.Set("Authorization", "Bearer token")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("bundle status %d: %s", rr.Code, rr.Body.String())
+	}
+
+	gz, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+	prefix := "pingsanto-enroll_agt_air/"
+	state := files[prefix+"state.yaml"]
+	if !strings.Contains(state, "agent_id: agt_air") || !strings.Contains(state, "server: http://central.example.com") || !strings.Contains(state, "site: ATL-1") {
+		t.Fatalf("unexpected state.yaml %q", state)
+	}
+	pair, err := tls.X509KeyPair([]byte(files[prefix+"client.crt"]), []byte(files[prefix+"client.key"]))
+	if err != nil || pair.Leaf.Subject.CommonName != "agt_air" {
+		t.Fatalf("unexpected bundle credentials: %v", err)
+	}
+	if files[prefix+"ca.pem"] == "" {
+		t.Fatalf("expected ca.pem in bundle, got %v", files)
+	}
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const artifactUploadRoute = "/api/admin/v1/artifacts"
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram. Monitor and plan streams stay open for minutes and land in +Inf.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// reportStatuses are the upgrade report statuses counted by name; anything
+// else agents send is counted as "other" to bound label cardinality.
+var reportStatuses = []string{"success", "failed", "skipped", "deferred", "staged"}
+
+// expectedStoreErrors are lookups and conflicts that handlers answer with
+// 4xx rather than store failures.
+var expectedStoreErrors = []error{
+	store.ErrPlanNotFound, store.ErrPlanPreconditionFailed, store.ErrRolloutNotFound, store.ErrSnapshotNotFound,
+	store.ErrMonitorNotFound, store.ErrAgentNotFound, store.ErrDirectiveNotFound,
+	store.ErrEnrollmentTokenNotFound, store.ErrEnrollmentTokenInvalid, store.ErrEnrollmentTokenUsed,
+	store.ErrAdminKeyNotFound,
+}
+
+type routeKey struct {
+	route  string
+	method string
+}
+
+type routeStats struct {
+	codes   map[int]uint64
+	buckets []uint64
+	sum     float64
+	count   uint64
+}
+
+// controllerMetrics holds the request, plan, report, artifact upload and
+// store counters served on /metrics, per controller process.
+type controllerMetrics struct {
+	now func() time.Time
+
+	mu            sync.Mutex
+	routes        map[routeKey]*routeStats
+	planFetches   map[int]uint64
+	reports       map[string]uint64
+	uploads       map[int]uint64
+	uploadBytes   uint64
+	uploadSeconds float64
+	storeErrors   map[string]uint64
+	limited       map[string]uint64
+}
+
+func newControllerMetrics() *controllerMetrics {
+	return &controllerMetrics{
+		now:         time.Now,
+		routes:      map[routeKey]*routeStats{},
+		planFetches: map[int]uint64{},
+		reports:     map[string]uint64{},
+		uploads:     map[int]uint64{},
+		storeErrors: map[string]uint64{},
+		limited:     map[string]uint64{},
+	}
+}
+
+// middleware records the status and duration of every routed request by
+// route template. It relies on the matched route, so it must run as router
+// middleware.
+func (m *controllerMetrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tmpl, _ := route.GetPathTemplate()
+		var body *digestReader
+		if tmpl == artifactUploadRoute {
+			body = &digestReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+		start := m.now()
+		rec := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		elapsed := m.now().Sub(start).Seconds()
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		key := routeKey{route: tmpl, method: r.Method}
+		stats := m.routes[key]
+		if stats == nil {
+			stats = &routeStats{codes: map[int]uint64{}, buckets: make([]uint64, len(latencyBuckets))}
+			m.routes[key] = stats
+		}
+		stats.codes[rec.status]++
+		stats.sum += elapsed
+		stats.count++
+		for i, bound := range latencyBuckets {
+			if elapsed <= bound {
+				stats.buckets[i]++
+			}
+		}
+		switch {
+		case tmpl == planRoute:
+			m.planFetches[rec.status]++
+		case body != nil:
+			m.uploads[rec.status]++
+			m.uploadBytes += uint64(body.n)
+			m.uploadSeconds += elapsed
+		}
+	})
+}
+
+// report counts an ingested upgrade report.
+func (m *controllerMetrics) report(status string) {
+	if !slices.Contains(reportStatuses, status) {
+		status = "other"
+	}
+	m.mu.Lock()
+	m.reports[status]++
+	m.mu.Unlock()
+}
+
+// storeCall counts err against op unless it is nil or an expected lookup
+// miss or conflict, and reports whether it did.
+func (m *controllerMetrics) storeCall(op string, err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, expected := range expectedStoreErrors {
+		if errors.Is(err, expected) {
+			return false
+		}
+	}
+	m.mu.Lock()
+	m.storeErrors[op]++
+	m.mu.Unlock()
+	return true
+}
+
+// rateLimited counts a request refused by the rate limiter for role.
+func (m *controllerMetrics) rateLimited(role auth.Role) {
+	m.mu.Lock()
+	m.limited[string(role)]++
+	m.mu.Unlock()
+}
+
+func (m *controllerMetrics) writeMetrics(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+	fmt.Fprintln(w, "# HELP pingsanto_controller_http_requests_total Requests answered, by route template, method and status code.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_http_requests_total counter")
+	for _, key := range keys {
+		codes := m.routes[key].codes
+		for _, code := range sortedKeys(codes) {
+			fmt.Fprintf(w, "pingsanto_controller_http_requests_total{route=%q,method=%q,code=\"%d\"} %d\n", key.route, key.method, code, codes[code])
+		}
+	}
+	fmt.Fprintln(w, "# HELP pingsanto_controller_http_request_duration_seconds Request latency by route template and method.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_http_request_duration_seconds histogram")
+	for _, key := range keys {
+		stats := m.routes[key]
+		labels := fmt.Sprintf("route=%q,method=%q", key.route, key.method)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "pingsanto_controller_http_request_duration_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(bound, 'g', -1, 64), stats.buckets[i])
+		}
+		fmt.Fprintf(w, "pingsanto_controller_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, stats.count)
+		fmt.Fprintf(w, "pingsanto_controller_http_request_duration_seconds_sum{%s} %g\n", labels, stats.sum)
+		fmt.Fprintf(w, "pingsanto_controller_http_request_duration_seconds_count{%s} %d\n", labels, stats.count)
+	}
+
+	fmt.Fprintln(w, "# HELP pingsanto_controller_plan_fetches_total Agent plan fetches by status code (200 new plan, 304 unchanged, 404 none).")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_plan_fetches_total counter")
+	for _, code := range sortedKeys(m.planFetches) {
+		fmt.Fprintf(w, "pingsanto_controller_plan_fetches_total{code=\"%d\"} %d\n", code, m.planFetches[code])
+	}
+	fmt.Fprintln(w, "# HELP pingsanto_controller_upgrade_reports_total Upgrade reports ingested, by status.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_upgrade_reports_total counter")
+	for _, status := range sortedKeys(m.reports) {
+		fmt.Fprintf(w, "pingsanto_controller_upgrade_reports_total{status=%q} %d\n", status, m.reports[status])
+	}
+	fmt.Fprintln(w, "# HELP pingsanto_controller_artifact_uploads_total Admin artifact uploads by status code.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_artifact_uploads_total counter")
+	for _, code := range sortedKeys(m.uploads) {
+		fmt.Fprintf(w, "pingsanto_controller_artifact_uploads_total{code=\"%d\"} %d\n", code, m.uploads[code])
+	}
+	fmt.Fprintln(w, "# HELP pingsanto_controller_artifact_upload_bytes_total Request bytes received by artifact uploads.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_artifact_upload_bytes_total counter")
+	fmt.Fprintf(w, "pingsanto_controller_artifact_upload_bytes_total %d\n", m.uploadBytes)
+	fmt.Fprintln(w, "# HELP pingsanto_controller_artifact_upload_seconds_total Time spent receiving and storing artifact uploads; divide bytes by it for throughput.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_artifact_upload_seconds_total counter")
+	fmt.Fprintf(w, "pingsanto_controller_artifact_upload_seconds_total %g\n", m.uploadSeconds)
+	fmt.Fprintln(w, "# HELP pingsanto_controller_store_errors_total Store calls that failed, by method; not-found lookups and conflicts are not counted.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_store_errors_total counter")
+	for _, op := range sortedKeys(m.storeErrors) {
+		fmt.Fprintf(w, "pingsanto_controller_store_errors_total{op=%q} %d\n", op, m.storeErrors[op])
+	}
+	fmt.Fprintln(w, "# HELP pingsanto_controller_rate_limited_total Requests refused with 429 by the per-client rate limit, by caller role.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_rate_limited_total counter")
+	for _, role := range sortedKeys(m.limited) {
+		fmt.Fprintf(w, "pingsanto_controller_rate_limited_total{role=%q} %d\n", role, m.limited[role])
+	}
+}
+
+func metricsHandler(guard *replayGuard, metrics *controllerMetrics) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.writeMetrics(w)
+		guard.writeMetrics(w)
+	}
+}
+
+func sortedKeys[K int | string](counts map[K]uint64) []K {
+	keys := make([]K, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
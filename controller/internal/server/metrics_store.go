@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/requestid"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+// meteredStore counts failed store calls per method for
+// pingsanto_controller_store_errors_total and logs them with the ID of the
+// request that made them.
+type meteredStore struct {
+	store.Store
+	metrics *controllerMetrics
+	log     *slog.Logger
+}
+
+func (s meteredStore) observe(ctx context.Context, op string, err *error) {
+	if !s.metrics.storeCall(op, *err) {
+		return
+	}
+	s.log.LogAttrs(ctx, slog.LevelError, "store call failed",
+		slog.String("request_id", requestid.FromContext(ctx)),
+		slog.String("op", op),
+		slog.String("error", (*err).Error()))
+}
+
+func (s meteredStore) FetchUpgradePlan(ctx context.Context, agentID string, channel string) (_ store.UpgradePlanResponse, _ string, err error) {
+	defer s.observe(ctx, "FetchUpgradePlan", &err)
+	return s.Store.FetchUpgradePlan(ctx, agentID, channel)
+}
+
+func (s meteredStore) RecordUpgradeReport(ctx context.Context, report store.UpgradeReport) (err error) {
+	defer s.observe(ctx, "RecordUpgradeReport", &err)
+	return s.Store.RecordUpgradeReport(ctx, report)
+}
+
+func (s meteredStore) UpsertUpgradePlan(ctx context.Context, input store.PlanInput) (_ store.UpgradePlanResponse, _ string, err error) {
+	defer s.observe(ctx, "UpsertUpgradePlan", &err)
+	return s.Store.UpsertUpgradePlan(ctx, input)
+}
+
+func (s meteredStore) GetUpgradePlan(ctx context.Context, key string) (_ store.UpgradePlanResponse, _ string, err error) {
+	defer s.observe(ctx, "GetUpgradePlan", &err)
+	return s.Store.GetUpgradePlan(ctx, key)
+}
+
+func (s meteredStore) ListUpgradePlans(ctx context.Context, filter store.PlanFilter) (_ []store.UpgradePlanResponse, err error) {
+	defer s.observe(ctx, "ListUpgradePlans", &err)
+	return s.Store.ListUpgradePlans(ctx, filter)
+}
+
+func (s meteredStore) DeleteUpgradePlan(ctx context.Context, key string) (err error) {
+	defer s.observe(ctx, "DeleteUpgradePlan", &err)
+	return s.Store.DeleteUpgradePlan(ctx, key)
+}
+
+func (s meteredStore) SetPlanRollout(ctx context.Context, key string, percent int) (_ store.UpgradePlanResponse, _ string, err error) {
+	defer s.observe(ctx, "SetPlanRollout", &err)
+	return s.Store.SetPlanRollout(ctx, key, percent)
+}
+
+func (s meteredStore) SetPlanPaused(ctx context.Context, key string, paused bool) (_ store.UpgradePlanResponse, _ string, err error) {
+	defer s.observe(ctx, "SetPlanPaused", &err)
+	return s.Store.SetPlanPaused(ctx, key, paused)
+}
+
+func (s meteredStore) SaveRollout(ctx context.Context, r store.Rollout) (_ store.Rollout, err error) {
+	defer s.observe(ctx, "SaveRollout", &err)
+	return s.Store.SaveRollout(ctx, r)
+}
+
+func (s meteredStore) GetRollout(ctx context.Context, channel string) (_ store.Rollout, err error) {
+	defer s.observe(ctx, "GetRollout", &err)
+	return s.Store.GetRollout(ctx, channel)
+}
+
+func (s meteredStore) ListRollouts(ctx context.Context) (_ []store.Rollout, err error) {
+	defer s.observe(ctx, "ListRollouts", &err)
+	return s.Store.ListRollouts(ctx)
+}
+
+func (s meteredStore) CountUpgradeOutcomes(ctx context.Context, channel, version string, since time.Time) (_ store.UpgradeOutcomes, err error) {
+	defer s.observe(ctx, "CountUpgradeOutcomes", &err)
+	return s.Store.CountUpgradeOutcomes(ctx, channel, version, since)
+}
+
+func (s meteredStore) ListUpgradeHistory(ctx context.Context, filter store.HistoryFilter) (_ []store.UpgradeReport, err error) {
+	defer s.observe(ctx, "ListUpgradeHistory", &err)
+	return s.Store.ListUpgradeHistory(ctx, filter)
+}
+
+func (s meteredStore) GetNotificationSettings(ctx context.Context) (_ store.NotificationSettings, err error) {
+	defer s.observe(ctx, "GetNotificationSettings", &err)
+	return s.Store.GetNotificationSettings(ctx)
+}
+
+func (s meteredStore) UpdateNotificationSettings(ctx context.Context, notify bool) (_ store.NotificationSettings, err error) {
+	defer s.observe(ctx, "UpdateNotificationSettings", &err)
+	return s.Store.UpdateNotificationSettings(ctx, notify)
+}
+
+func (s meteredStore) GetConfigOverlay(ctx context.Context) (_ store.ConfigOverlay, err error) {
+	defer s.observe(ctx, "GetConfigOverlay", &err)
+	return s.Store.GetConfigOverlay(ctx)
+}
+
+func (s meteredStore) UpdateConfigOverlay(ctx context.Context, overlay store.ConfigOverlay) (_ store.ConfigOverlay, err error) {
+	defer s.observe(ctx, "UpdateConfigOverlay", &err)
+	return s.Store.UpdateConfigOverlay(ctx, overlay)
+}
+
+func (s meteredStore) PublishMonitorSnapshot(ctx context.Context, agentID string, monitors []store.MonitorAssignment) (_ store.MonitorSnapshot, err error) {
+	defer s.observe(ctx, "PublishMonitorSnapshot", &err)
+	return s.Store.PublishMonitorSnapshot(ctx, agentID, monitors)
+}
+
+func (s meteredStore) ApplyMonitorSnapshots(ctx context.Context, snapshots map[string][]store.MonitorAssignment) (_ map[string]store.MonitorSnapshot, err error) {
+	defer s.observe(ctx, "ApplyMonitorSnapshots", &err)
+	return s.Store.ApplyMonitorSnapshots(ctx, snapshots)
+}
+
+func (s meteredStore) GetMonitorSnapshot(ctx context.Context, agentID string, revision string) (_ store.MonitorSnapshot, err error) {
+	defer s.observe(ctx, "GetMonitorSnapshot", &err)
+	return s.Store.GetMonitorSnapshot(ctx, agentID, revision)
+}
+
+func (s meteredStore) ListMonitorRevisions(ctx context.Context, agentID string, limit int) (_ []store.MonitorRevision, err error) {
+	defer s.observe(ctx, "ListMonitorRevisions", &err)
+	return s.Store.ListMonitorRevisions(ctx, agentID, limit)
+}
+
+func (s meteredStore) ListCatalogMonitors(ctx context.Context) (_ []store.CatalogMonitor, err error) {
+	defer s.observe(ctx, "ListCatalogMonitors", &err)
+	return s.Store.ListCatalogMonitors(ctx)
+}
+
+func (s meteredStore) GetCatalogMonitor(ctx context.Context, id string) (_ store.CatalogMonitor, err error) {
+	defer s.observe(ctx, "GetCatalogMonitor", &err)
+	return s.Store.GetCatalogMonitor(ctx, id)
+}
+
+func (s meteredStore) SaveCatalogMonitor(ctx context.Context, m store.CatalogMonitor) (_ store.CatalogMonitor, err error) {
+	defer s.observe(ctx, "SaveCatalogMonitor", &err)
+	return s.Store.SaveCatalogMonitor(ctx, m)
+}
+
+func (s meteredStore) DeleteCatalogMonitor(ctx context.Context, id string) (err error) {
+	defer s.observe(ctx, "DeleteCatalogMonitor", &err)
+	return s.Store.DeleteCatalogMonitor(ctx, id)
+}
+
+func (s meteredStore) RecordHeartbeat(ctx context.Context, hb store.Heartbeat) (err error) {
+	defer s.observe(ctx, "RecordHeartbeat", &err)
+	return s.Store.RecordHeartbeat(ctx, hb)
+}
+
+func (s meteredStore) GetHeartbeat(ctx context.Context, agentID string) (_ store.Heartbeat, err error) {
+	defer s.observe(ctx, "GetHeartbeat", &err)
+	return s.Store.GetHeartbeat(ctx, agentID)
+}
+
+func (s meteredStore) ListAgents(ctx context.Context, filter store.AgentFilter) (_ []store.AgentSummary, err error) {
+	defer s.observe(ctx, "ListAgents", &err)
+	return s.Store.ListAgents(ctx, filter)
+}
+
+func (s meteredStore) ListAgentLabels(ctx context.Context) (_ map[string]map[string]string, err error) {
+	defer s.observe(ctx, "ListAgentLabels", &err)
+	return s.Store.ListAgentLabels(ctx)
+}
+
+func (s meteredStore) ListCertExpiry(ctx context.Context, before time.Time, limit int) (_ []store.CertExpiry, err error) {
+	defer s.observe(ctx, "ListCertExpiry", &err)
+	return s.Store.ListCertExpiry(ctx, before, limit)
+}
+
+func (s meteredStore) EnqueueDirectives(ctx context.Context, directiveType string, agentIDs []string, payload map[string]any) (_ []store.Directive, err error) {
+	defer s.observe(ctx, "EnqueueDirectives", &err)
+	return s.Store.EnqueueDirectives(ctx, directiveType, agentIDs, payload)
+}
+
+func (s meteredStore) PendingDirectives(ctx context.Context, agentID string) (_ []store.Directive, err error) {
+	defer s.observe(ctx, "PendingDirectives", &err)
+	return s.Store.PendingDirectives(ctx, agentID)
+}
+
+func (s meteredStore) ListDirectives(ctx context.Context, agentID string, limit int) (_ []store.Directive, err error) {
+	defer s.observe(ctx, "ListDirectives", &err)
+	return s.Store.ListDirectives(ctx, agentID, limit)
+}
+
+func (s meteredStore) CompleteDirective(ctx context.Context, agentID, id, status, message string) (err error) {
+	defer s.observe(ctx, "CompleteDirective", &err)
+	return s.Store.CompleteDirective(ctx, agentID, id, status, message)
+}
+
+func (s meteredStore) FleetStats(ctx context.Context) (_ store.FleetStats, err error) {
+	defer s.observe(ctx, "FleetStats", &err)
+	return s.Store.FleetStats(ctx)
+}
+
+func (s meteredStore) RecordStatsSample(ctx context.Context, sample store.StatsSample) (err error) {
+	defer s.observe(ctx, "RecordStatsSample", &err)
+	return s.Store.RecordStatsSample(ctx, sample)
+}
+
+func (s meteredStore) ListStatsSamples(ctx context.Context, since time.Time, limit int) (_ []store.StatsSample, err error) {
+	defer s.observe(ctx, "ListStatsSamples", &err)
+	return s.Store.ListStatsSamples(ctx, since, limit)
+}
+
+func (s meteredStore) PruneStatsSamples(ctx context.Context, before time.Time) (_ int, err error) {
+	defer s.observe(ctx, "PruneStatsSamples", &err)
+	return s.Store.PruneStatsSamples(ctx, before)
+}
+
+func (s meteredStore) CreateEnrollmentToken(ctx context.Context, token store.EnrollmentToken, hash string) (_ store.EnrollmentToken, err error) {
+	defer s.observe(ctx, "CreateEnrollmentToken", &err)
+	return s.Store.CreateEnrollmentToken(ctx, token, hash)
+}
+
+func (s meteredStore) ListEnrollmentTokens(ctx context.Context, status string, limit int) (_ []store.EnrollmentToken, err error) {
+	defer s.observe(ctx, "ListEnrollmentTokens", &err)
+	return s.Store.ListEnrollmentTokens(ctx, status, limit)
+}
+
+func (s meteredStore) RevokeEnrollmentToken(ctx context.Context, id string) (_ store.EnrollmentToken, err error) {
+	defer s.observe(ctx, "RevokeEnrollmentToken", &err)
+	return s.Store.RevokeEnrollmentToken(ctx, id)
+}
+
+func (s meteredStore) RedeemEnrollmentToken(ctx context.Context, hash, agentID string, proven bool, cloud store.CloudIdentity) (_ store.EnrollmentToken, err error) {
+	defer s.observe(ctx, "RedeemEnrollmentToken", &err)
+	return s.Store.RedeemEnrollmentToken(ctx, hash, agentID, proven, cloud)
+}
+
+func (s meteredStore) CreateAdminKey(ctx context.Context, key store.AdminKey, hash string) (_ store.AdminKey, err error) {
+	defer s.observe(ctx, "CreateAdminKey", &err)
+	return s.Store.CreateAdminKey(ctx, key, hash)
+}
+
+func (s meteredStore) ListAdminKeys(ctx context.Context) (_ []store.AdminKey, err error) {
+	defer s.observe(ctx, "ListAdminKeys", &err)
+	return s.Store.ListAdminKeys(ctx)
+}
+
+func (s meteredStore) RevokeAdminKey(ctx context.Context, id string) (_ store.AdminKey, err error) {
+	defer s.observe(ctx, "RevokeAdminKey", &err)
+	return s.Store.RevokeAdminKey(ctx, id)
+}
+
+func (s meteredStore) LookupAdminKey(ctx context.Context, hash string) (_ store.AdminKey, err error) {
+	defer s.observe(ctx, "LookupAdminKey", &err)
+	return s.Store.LookupAdminKey(ctx, hash)
+}
+
+func (s meteredStore) RecordAuditEntry(ctx context.Context, entry store.AuditEntry) (_ store.AuditEntry, err error) {
+	defer s.observe(ctx, "RecordAuditEntry", &err)
+	return s.Store.RecordAuditEntry(ctx, entry)
+}
+
+func (s meteredStore) ListAuditEntries(ctx context.Context, filter store.AuditFilter) (_ []store.AuditEntry, err error) {
+	defer s.observe(ctx, "ListAuditEntries", &err)
+	return s.Store.ListAuditEntries(ctx, filter)
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+// flakyStore fails agent inventory listings as a database outage would.
+type flakyStore struct {
+	store.Store
+}
+
+func (flakyStore) ListAgents(ctx context.Context, filter store.AgentFilter) ([]store.AgentSummary, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestControllerMetrics(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: flakyStore{store.NewMemoryStore()}})
+	do := func(req *http.Request) *httptest.ResponseRecorder {
+		if req.Header.Get("X-Agent-ID") == "" {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	agent := func(method, path, body string) *http.Request {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Agent-ID", "agt_1")
+		return req
+	}
+
+	do(httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", strings.NewReader(`{"agent_id":"agt_1","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`)))
+	rec := do(agent(http.MethodGet, "/api/agent/v1/upgrade/plan", ""))
+	notModified := agent(http.MethodGet, "/api/agent/v1/upgrade/plan", "")
+	notModified.Header.Set("If-None-Match", rec.Header().Get("ETag"))
+	if rec := do(notModified); rec.Code != http.StatusNotModified {
+		t.Fatalf("plan revalidation: status %d", rec.Code)
+	}
+	do(agent(http.MethodPost, "/api/agent/v1/upgrade/report", `{"current_version":"1.2.0","status":"failed"}`))
+	do(agent(http.MethodPost, "/api/agent/v1/upgrade/report", `{"current_version":"1.2.0","status":"exploded"}`))
+	if rec := do(httptest.NewRequest(http.MethodGet, "/api/admin/v1/agents", nil)); rec.Code != http.StatusInternalServerError {
+		t.Fatalf("agents with failing store: status %d", rec.Code)
+	}
+	do(httptest.NewRequest(http.MethodGet, "/api/admin/v1/upgrade/history/agt_missing", nil))
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	writer.WriteField("version", "1.2.0")
+	part, _ := writer.CreateFormFile("file", "agent.tar.gz")
+	part.Write([]byte("artifact"))
+	writer.Close()
+	upload := httptest.NewRequest(http.MethodPost, "/api/admin/v1/artifacts", bytes.NewReader(body.Bytes()))
+	upload.Header.Set("Content-Type", writer.FormDataContentType())
+	if rec := do(upload); rec.Code != http.StatusOK {
+		t.Fatalf("upload: status %d", rec.Code)
+	}
+
+	metrics := do(httptest.NewRequest(http.MethodGet, "/metrics", nil)).Body.String()
+	for _, want := range []string{
+		`pingsanto_controller_http_requests_total{route="/api/agent/v1/upgrade/plan",method="GET",code="304"} 1`,
+		`pingsanto_controller_http_request_duration_seconds_count{route="/api/agent/v1/upgrade/report",method="POST"} 2`,
+		`pingsanto_controller_http_request_duration_seconds_bucket{route="/api/agent/v1/upgrade/report",method="POST",le="+Inf"} 2`,
+		`pingsanto_controller_plan_fetches_total{code="200"} 1`,
+		`pingsanto_controller_plan_fetches_total{code="304"} 1`,
+		`pingsanto_controller_upgrade_reports_total{status="failed"} 1`,
+		`pingsanto_controller_upgrade_reports_total{status="other"} 1`,
+		`pingsanto_controller_artifact_uploads_total{code="200"} 1`,
+		"pingsanto_controller_artifact_upload_bytes_total " + strconv.Itoa(body.Len()),
+		`pingsanto_controller_store_errors_total{op="ListAgents"} 1`,
+		"pingsanto_controller_agent_request_nonces_tracked 0",
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Fatalf("metrics missing %q:\n%s", want, metrics)
+		}
+	}
+	if strings.Contains(metrics, `op="FetchUpgradePlan"`) || strings.Contains(metrics, `op="ListUpgradeHistory"`) {
+		t.Fatalf("successful or not-found store calls counted as errors:\n%s", metrics)
+	}
+}
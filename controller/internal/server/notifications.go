@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/notify"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const notifyTimeout = 30 * time.Second
+
+// publishNotifier sends plan publish and rollout completion notifications
+// through Dependencies.Notifier while the notify_on_publish setting is on.
+// Deliveries run in the background so a slow mail server or webhook never
+// holds up the request that triggered them.
+type publishNotifier struct {
+	store   store.Store
+	backend notify.Notifier
+	logf    func(string, ...any)
+}
+
+func newPublishNotifier(deps Dependencies) *publishNotifier {
+	return &publishNotifier{store: deps.Store, backend: deps.Notifier, logf: deps.Logger.Printf}
+}
+
+func (n *publishNotifier) send(msg notify.Message) {
+	if n == nil || n.backend == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+		defer cancel()
+		settings, err := n.store.GetNotificationSettings(ctx)
+		if err != nil {
+			n.logf("notification %q not sent: read settings: %v", msg.Subject, err)
+			return
+		}
+		if !settings.NotifyOnPublish {
+			return
+		}
+		if err := n.backend.Notify(ctx, msg); err != nil {
+			n.logf("notification %q failed: %v", msg.Subject, err)
+		}
+	}()
+}
+
+// planPublished announces a plan that an admin created or replaced.
+func (n *publishNotifier) planPublished(plan store.UpgradePlanResponse, actor string) {
+	target := "agent " + plan.AgentID
+	if store.PlanKind(plan.AgentID) == store.PlanKindChannel {
+		target = "channel " + plan.Channel
+	}
+	lines := []string{
+		fmt.Sprintf("Version %s is now planned for %s.", plan.Artifact.Version, target),
+		"Artifact: " + plan.Artifact.URL,
+	}
+	if plan.RolloutPercent < 100 {
+		lines = append(lines, fmt.Sprintf("Rollout: %d%% of the channel's agents.", plan.RolloutPercent))
+	}
+	if plan.Paused {
+		lines = append(lines, "The plan is paused; agents will not upgrade until it is resumed.")
+	}
+	if plan.Notes != "" {
+		lines = append(lines, "Notes: "+plan.Notes)
+	}
+	if actor != "" {
+		lines = append(lines, "Published by "+actor+".")
+	}
+	n.send(notify.Message{
+		Subject: fmt.Sprintf("PingSanto agent %s published for %s", plan.Artifact.Version, target),
+		Text:    strings.Join(lines, "\n"),
+	})
+}
+
+// rolloutCompleted announces a cohort rollout that reached 100%.
+func (n *publishNotifier) rolloutCompleted(r store.Rollout) {
+	cohorts := make([]string, len(r.Cohorts))
+	for i, percent := range r.Cohorts {
+		cohorts[i] = strconv.Itoa(percent) + "%"
+	}
+	n.send(notify.Message{
+		Subject: fmt.Sprintf("PingSanto agent %s rollout on %s completed", r.Version, r.Channel),
+		Text: fmt.Sprintf("Version %s reached every agent on the %s channel through cohorts of %s.\nFinal cohort: %d succeeded, %d failed.",
+			r.Version, r.Channel, strings.Join(cohorts, ", "), r.Succeeded, r.Failed),
+	})
+}
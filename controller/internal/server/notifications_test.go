@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/notify"
+)
+
+type recordingNotifier chan notify.Message
+
+func (n recordingNotifier) Notify(ctx context.Context, msg notify.Message) error {
+	n <- msg
+	return nil
+}
+
+func TestPublishNotificationsHonorToggle(t *testing.T) {
+	sent := make(recordingNotifier, 4)
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Notifier: sent})
+	do := func(method, path, agentID, body string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if agentID != "" {
+			req.Header.Set("X-Agent-ID", agentID)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code >= 300 {
+			t.Fatalf("%s %s: status %d: %s", method, path, rec.Code, rec.Body.String())
+		}
+	}
+	expect := func(subject string) notify.Message {
+		select {
+		case msg := <-sent:
+			if msg.Subject != subject {
+				t.Fatalf("expected %q, got %+v", subject, msg)
+			}
+			return msg
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no notification %q", subject)
+		}
+		return notify.Message{}
+	}
+	const plan = `{"channel":"stable","rollout_percent":100,"notes":"fixes DNS probes","artifact":{"version":"1.2.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`
+
+	do(http.MethodPost, "/api/admin/v1/upgrade/plan", "", plan)
+	if msg := expect("PingSanto agent 1.2.0 published for channel stable"); !strings.Contains(msg.Text, "fixes DNS probes") || !strings.Contains(msg.Text, "Published by admin.") {
+		t.Fatalf("unexpected text %q", msg.Text)
+	}
+
+	do(http.MethodPost, "/api/admin/v1/settings/notifications", "", `{"notify_on_publish":false}`)
+	do(http.MethodPost, "/api/admin/v1/upgrade/plan", "", plan)
+	select {
+	case msg := <-sent:
+		t.Fatalf("notification sent while disabled: %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	do(http.MethodPost, "/api/admin/v1/settings/notifications", "", `{"notify_on_publish":true}`)
+	do(http.MethodPost, "/api/admin/v1/upgrade/rollouts", "", `{"channel":"stable","cohorts":[100],"min_reports":1}`)
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	do(http.MethodPost, "/api/agent/v1/upgrade/report", "agt_1", `{"current_version":"1.2.0","channel":"stable","status":"success","completed_at":"`+now+`"}`)
+	if msg := expect("PingSanto agent 1.2.0 rollout on stable completed"); !strings.Contains(msg.Text, "1 succeeded, 0 failed") {
+		t.Fatalf("unexpected text %q", msg.Text)
+	}
+}
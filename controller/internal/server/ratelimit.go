@@ -0,0 +1,100 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/auth"
+)
+
+// rateLimitIdle is how long a client's bucket is kept after its last
+// request; by then it has refilled and forgetting it changes nothing.
+const rateLimitIdle = 10 * time.Minute
+
+// rateLimiter is a token bucket per authenticated principal: each holds up
+// to burst tokens, refills at rate per second, and every request takes one.
+// Buckets are per controller process.
+type rateLimiter struct {
+	role    auth.Role
+	rate    float64
+	burst   float64
+	now     func() time.Time
+	metrics *controllerMetrics
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil, which limits nothing, when rate is not
+// positive. burst defaults to one second's worth of requests.
+func newRateLimiter(role auth.Role, rate float64, burst int, metrics *controllerMetrics) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &rateLimiter{role: role, rate: rate, burst: float64(burst), now: time.Now, metrics: metrics, buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token from key's bucket, or reports how long until one is
+// available.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	l.pruneLocked(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < rateLimitIdle {
+		return
+	}
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= rateLimitIdle {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// middleware answers 429 with Retry-After once the authenticated caller has
+// used up its bucket. It wraps handlers behind auth.Require, so each agent
+// ID or admin credential gets its own bucket.
+func (l *rateLimiter) middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := auth.FromContext(r.Context())
+		ok, wait := l.allow(p.Subject)
+		if !ok {
+			l.metrics.rateLimited(l.role)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
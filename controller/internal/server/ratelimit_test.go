@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/auth"
+)
+
+func TestRateLimitPerClient(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token", AgentRateLimit: 0.5, AgentRateBurst: 2}
+	srv := New(cfg, Dependencies{Logger: log.New(io.Discard, "", 0)})
+	do := func(path, agentID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if agentID != "" {
+			req.Header.Set("X-Agent-ID", agentID)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do("/api/agent/v1/upgrade/plan", "agt_1"); rec.Code != http.StatusOK {
+			t.Fatalf("request %d within burst: status %d", i, rec.Code)
+		}
+	}
+	rec := do("/api/agent/v1/upgrade/plan", "agt_1")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("expected 429 with Retry-After 2, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do("/api/agent/v1/upgrade/plan", "agt_2"); rec.Code != http.StatusOK {
+		t.Fatalf("other agents keep their own bucket: status %d", rec.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if rec := do("/api/admin/v1/upgrade/plans", ""); rec.Code != http.StatusOK {
+			t.Fatalf("admin requests are not limited without AdminRateLimit: status %d", rec.Code)
+		}
+	}
+	if metrics := do("/metrics", "").Body.String(); !strings.Contains(metrics, `pingsanto_controller_rate_limited_total{role="agent"} 1`) {
+		t.Fatalf("rate limited requests not counted:\n%s", metrics)
+	}
+
+	now := time.Unix(1000, 0)
+	l := newRateLimiter(auth.RoleAdmin, 2, 0, newControllerMetrics())
+	l.now = func() time.Time { return now }
+	if ok, _ := l.allow("ci"); !ok {
+		t.Fatal("first request refused")
+	}
+	l.allow("ci")
+	if ok, wait := l.allow("ci"); ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected refusal for 500ms, got %t %s", ok, wait)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.allow("ci"); !ok {
+		t.Fatal("bucket did not refill")
+	}
+	if newRateLimiter(auth.RoleAgent, 0, 10, nil) != nil {
+		t.Fatal("a zero rate should disable limiting")
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/auth"
+)
+
+// Agent request freshness headers. Agents stamp every request with the send
+// time (unix seconds) and a random nonce; the pair lets the controller reject
+// stale or replayed requests, which matters most in header auth mode where the
+// agent identity is otherwise a bare header.
+const (
+	headerRequestTimestamp = "X-PingSanto-Timestamp"
+	headerRequestNonce     = "X-PingSanto-Nonce"
+)
+
+// Replay protection modes.
+const (
+	ReplayProtectionOff     = "off"
+	ReplayProtectionLog     = "log"
+	ReplayProtectionEnforce = "enforce"
+)
+
+const (
+	defaultReplayMaxSkew = 5 * time.Minute
+	maxNonceLength       = 128
+)
+
+// replayGuard validates request timestamps against the configured clock-skew
+// tolerance and remembers nonces for twice that window, which is long enough
+// that any replay outside it already fails the timestamp check. Nonces are
+// tracked per controller process. The guard runs after agent authentication
+// and scopes nonces to the authenticated agent.
+type replayGuard struct {
+	mode    string
+	maxSkew time.Duration
+	now     func() time.Time
+	logf    func(string, ...any)
+
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	lastPrune time.Time
+	rejects   map[string]uint64
+}
+
+func newReplayGuard(cfg Config, deps Dependencies) *replayGuard {
+	mode := strings.ToLower(strings.TrimSpace(cfg.ReplayProtection))
+	switch mode {
+	case ReplayProtectionLog, ReplayProtectionEnforce:
+	default:
+		mode = ReplayProtectionOff
+	}
+	skew := cfg.ReplayMaxSkew
+	if skew <= 0 {
+		skew = defaultReplayMaxSkew
+	}
+	return &replayGuard{
+		mode:    mode,
+		maxSkew: skew,
+		now:     time.Now,
+		logf:    deps.Logger.Printf,
+		seen:    map[string]time.Time{},
+		rejects: map[string]uint64{},
+	}
+}
+
+// middleware wraps the authenticated agent routes. Enrollment is not one of
+// them: it precedes the agent's identity, and its single-use token already
+// prevents replays.
+func (g *replayGuard) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reason := g.refuse(r); reason != "" {
+			http.Error(w, "request rejected: "+reason, http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// refuse checks an agent request, logging stale or replayed ones, and
+// returns the reason when the mode says to reject it.
+func (g *replayGuard) refuse(r *http.Request) string {
+	if g.mode == ReplayProtectionOff {
+		return ""
+	}
+	reason := g.check(r)
+	if reason == "" {
+		return ""
+	}
+	g.logf("agent request rejected (%s): agent=%q path=%s mode=%s", reason, g.agentID(r), r.URL.Path, g.mode)
+	if g.mode != ReplayProtectionEnforce {
+		return ""
+	}
+	return reason
+}
+
+// agentID identifies the caller for nonce scoping and logs from the
+// principal stored by agent authentication.
+func (g *replayGuard) agentID(r *http.Request) string {
+	p, _ := auth.FromContext(r.Context())
+	return p.Subject
+}
+
+// check returns the rejection reason for r, or "" when the request is fresh.
+func (g *replayGuard) check(r *http.Request) string {
+	rawTS := strings.TrimSpace(r.Header.Get(headerRequestTimestamp))
+	nonce := strings.TrimSpace(r.Header.Get(headerRequestNonce))
+	now := g.now()
+
+	var reason string
+	switch {
+	case rawTS == "":
+		reason = "missing_timestamp"
+	case nonce == "":
+		reason = "missing_nonce"
+	case len(nonce) > maxNonceLength:
+		reason = "invalid_nonce"
+	}
+	if reason == "" {
+		secs, err := strconv.ParseInt(rawTS, 10, 64)
+		if err != nil {
+			reason = "invalid_timestamp"
+		} else if skew := now.Sub(time.Unix(secs, 0)); skew > g.maxSkew || skew < -g.maxSkew {
+			reason = "clock_skew"
+		}
+	}
+
+	key := g.agentID(r) + "\x00" + nonce
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if reason == "" {
+		if exp, ok := g.seen[key]; ok && now.Before(exp) {
+			reason = "replayed_nonce"
+		} else {
+			g.seen[key] = now.Add(2 * g.maxSkew)
+			g.pruneLocked(now)
+		}
+	}
+	if reason != "" {
+		g.rejects[reason]++
+	}
+	return reason
+}
+
+func (g *replayGuard) pruneLocked(now time.Time) {
+	if now.Sub(g.lastPrune) < g.maxSkew {
+		return
+	}
+	for key, exp := range g.seen {
+		if !now.Before(exp) {
+			delete(g.seen, key)
+		}
+	}
+	g.lastPrune = now
+}
+
+func (g *replayGuard) writeMetrics(w io.Writer) {
+	g.mu.Lock()
+	reasons := make([]string, 0, len(g.rejects))
+	for reason := range g.rejects {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	counts := make([]uint64, len(reasons))
+	for i, reason := range reasons {
+		counts[i] = g.rejects[reason]
+	}
+	tracked := len(g.seen)
+	g.mu.Unlock()
+
+	fmt.Fprintln(w, "# HELP pingsanto_controller_agent_request_rejects_total Agent requests failing timestamp or nonce validation.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_agent_request_rejects_total counter")
+	for i, reason := range reasons {
+		fmt.Fprintf(w, "pingsanto_controller_agent_request_rejects_total{reason=%q,mode=%q} %d\n", reason, g.mode, counts[i])
+	}
+	fmt.Fprintln(w, "# HELP pingsanto_controller_agent_request_nonces_tracked Nonces currently held for replay detection.")
+	fmt.Fprintln(w, "# TYPE pingsanto_controller_agent_request_nonces_tracked gauge")
+	fmt.Fprintf(w, "pingsanto_controller_agent_request_nonces_tracked %d\n", tracked)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestReplayGuardRejectsStaleAndReplayedRequests(t *testing.T) {
+	cfg := Config{ReplayProtection: ReplayProtectionEnforce, ReplayMaxSkew: time.Minute}
+	srv := New(cfg, Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	})
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	send := func(ts0 time.Time, nonce string) int {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/agent/v1/heartbeat", strings.NewReader(`{}`))
+		req.Header.Set("X-Agent-ID", "agent-1")
+		if !ts0.IsZero() {
+			req.Header.Set(headerRequestTimestamp, strconv.FormatInt(ts0.Unix(), 10))
+		}
+		if nonce != "" {
+			req.Header.Set(headerRequestNonce, nonce)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("request: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	now := time.Now()
+	if code := send(now, "n-1"); code != http.StatusOK {
+		t.Fatalf("fresh request: status %d", code)
+	}
+	if code := send(now, "n-1"); code != http.StatusUnauthorized {
+		t.Fatalf("replayed nonce: status %d", code)
+	}
+	if code := send(now.Add(-5*time.Minute), "n-2"); code != http.StatusUnauthorized {
+		t.Fatalf("stale timestamp: status %d", code)
+	}
+	if code := send(now.Add(30*time.Second), "n-3"); code != http.StatusOK {
+		t.Fatalf("timestamp within skew tolerance: status %d", code)
+	}
+	if code := send(time.Time{}, "n-4"); code != http.StatusUnauthorized {
+		t.Fatalf("missing timestamp: status %d", code)
+	}
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("metrics: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{
+		`pingsanto_controller_agent_request_rejects_total{reason="replayed_nonce",mode="enforce"} 1`,
+		`pingsanto_controller_agent_request_rejects_total{reason="clock_skew",mode="enforce"} 1`,
+		`pingsanto_controller_agent_request_rejects_total{reason="missing_timestamp",mode="enforce"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestReplayGuardLogModeAllowsRequests(t *testing.T) {
+	srv := New(Config{ReplayProtection: ReplayProtectionLog}, Dependencies{Logger: log.New(io.Discard, "", 0)})
+	req := httptest.NewRequest(http.MethodPost, "/api/agent/v1/heartbeat", strings.NewReader(`{}`))
+	req.Header.Set("X-Agent-ID", "agent-1")
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("log mode should not block requests, got %d", rec.Code)
+	}
+}
+
+func TestReplayGuardScopesNoncesToAuthenticatedAgent(t *testing.T) {
+	var calls atomic.Int32
+	agentAuth := auth.Func(func(r *http.Request) (auth.Principal, error) {
+		calls.Add(1)
+		return auth.AgentHeader{}.Authenticate(r)
+	})
+	srv := New(Config{ReplayProtection: ReplayProtectionEnforce}, Dependencies{
+		Logger:    log.New(io.Discard, "", 0),
+		Store:     store.NewMemoryStore(),
+		AgentAuth: agentAuth,
+	})
+	send := func(agentID string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/agent/v1/heartbeat", strings.NewReader(`{}`))
+		req.Header.Set("X-Agent-ID", agentID)
+		req.Header.Set(headerRequestTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+		req.Header.Set(headerRequestNonce, "shared")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := send("agent-1"); code != http.StatusOK {
+		t.Fatalf("first request: status %d", code)
+	}
+	if calls.Load() != 1 {
+		t.Fatalf("expected one authentication per request, got %d", calls.Load())
+	}
+	if code := send("agent-2"); code != http.StatusOK {
+		t.Fatalf("same nonce from another agent: status %d", code)
+	}
+	if code := send("agent-1"); code != http.StatusUnauthorized {
+		t.Fatalf("replayed nonce: status %d", code)
+	}
+}
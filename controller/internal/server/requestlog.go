@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/requestid"
+)
+
+// requestInfo collects what inner handlers learn about a request (its route
+// template and authenticated caller) for the request log line.
+type requestInfo struct {
+	route   string
+	subject string
+}
+
+type requestInfoKey struct{}
+
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestLogger assigns each request an ID, reusing a valid X-Request-ID
+// sent by the client or a proxy, returns it in the X-Request-ID header,
+// carries it in the request context for store calls, and logs one line per
+// request once it has been answered. Health checks and metrics scrapes log
+// at debug level.
+func requestLogger(log *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+		info := &requestInfo{}
+		ctx := context.WithValue(requestid.With(r.Context(), id), requestInfoKey{}, info)
+
+		start := time.Now()
+		rec := &statusResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		level := slog.LevelInfo
+		switch {
+		case rec.status >= 500:
+			level = slog.LevelError
+		case r.URL.Path == "/healthz" || r.URL.Path == "/metrics":
+			level = slog.LevelDebug
+		}
+		log.LogAttrs(ctx, level, "request",
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", info.route),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int64("bytes", rec.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+			slog.String("subject", info.subject),
+		)
+	})
+}
+
+// noteRoute records the matched route template for the request log. It must
+// run as router middleware.
+func noteRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFrom(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notePrincipal records the authenticated caller for the request log; it
+// wraps handlers behind auth.Require.
+func notePrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFrom(r.Context()); info != nil {
+			if p, ok := auth.FromContext(r.Context()); ok {
+				info.subject = p.Subject
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pingsantohq/controller/internal/requestid"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestRequestLogsCarryRequestIDs(t *testing.T) {
+	var buf bytes.Buffer
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Log:    slog.New(slog.NewJSONHandler(&buf, nil)),
+		Store:  flakyStore{store.NewMemoryStore()},
+	}
+	srv := New(Config{AdminBearerToken: "token"}, deps)
+	do := func(path, id string) string {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		if id != "" {
+			req.Header.Set(requestid.Header, id)
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec.Header().Get(requestid.Header)
+	}
+	records := func() []map[string]any {
+		var out []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var rec map[string]any
+			if err := json.Unmarshal([]byte(line), &rec); err != nil {
+				t.Fatalf("log line %q is not JSON: %v", line, err)
+			}
+			out = append(out, rec)
+		}
+		buf.Reset()
+		return out
+	}
+
+	generated := do("/api/admin/v1/upgrade/plans", "")
+	if !requestid.Valid(generated) {
+		t.Fatalf("generated request ID %q", generated)
+	}
+	logs := records()
+	if len(logs) != 1 || logs[0]["msg"] != "request" || logs[0]["request_id"] != generated ||
+		logs[0]["route"] != "/api/admin/v1/upgrade/plans" || logs[0]["status"] != float64(200) || logs[0]["subject"] != "admin" {
+		t.Fatalf("unexpected request log %v", logs)
+	}
+
+	if id := do("/api/admin/v1/agents", "lb-7f3a.42"); id != "lb-7f3a.42" {
+		t.Fatalf("client request ID not reused, got %q", id)
+	}
+	logs = records()
+	if len(logs) != 2 || logs[0]["msg"] != "store call failed" || logs[0]["request_id"] != "lb-7f3a.42" || logs[0]["op"] != "ListAgents" ||
+		logs[1]["request_id"] != "lb-7f3a.42" || logs[1]["level"] != "ERROR" {
+		t.Fatalf("store failure not tied to request: %v", logs)
+	}
+
+	if id := do("/api/admin/v1/agents", "bad id\n"); id == "bad id\n" || !requestid.Valid(id) {
+		t.Fatalf("invalid client request ID reused: %q", id)
+	}
+	records()
+	do("/healthz", "")
+	if buf.Len() != 0 {
+		t.Fatalf("health checks should log at debug level: %s", buf.String())
+	}
+}
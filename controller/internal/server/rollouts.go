@@ -0,0 +1,297 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	rolloutCheckInterval     = time.Minute
+	defaultRolloutMinReports = 1
+	defaultRolloutFailures   = 0.1
+)
+
+// rolloutManager advances cohort rollouts as upgrade reports arrive and on a
+// timer, so soak periods end without further reports. It moves the channel
+// plan's rollout percentage with the cohort and pauses the plan when a
+// rollout pauses.
+type rolloutManager struct {
+	store  store.Store
+	notify *publishNotifier
+	logf   func(string, ...any)
+	now    func() time.Time
+
+	// mu serialises evaluations in this process; a concurrent evaluation on
+	// another replica computes the same step from the same stored state.
+	mu sync.Mutex
+}
+
+func newRolloutManager(deps Dependencies, notifier *publishNotifier) *rolloutManager {
+	return &rolloutManager{store: deps.Store, notify: notifier, logf: deps.Logger.Printf, now: time.Now}
+}
+
+// evaluate steps the channel's active rollout, if any.
+func (m *rolloutManager) evaluate(ctx context.Context, channel string) (store.Rollout, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, err := m.store.GetRollout(ctx, channel)
+	if err != nil || r.Status != store.RolloutActive {
+		return r, err
+	}
+	key := "channel:" + r.Channel
+	plan, _, err := m.store.GetUpgradePlan(ctx, key)
+	if errors.Is(err, store.ErrPlanNotFound) || (err == nil && plan.Artifact.Version != r.Version) {
+		r.Status = store.RolloutSuperseded
+		r.Reason = "channel plan no longer targets " + r.Version
+		m.logf("rollout %s %s superseded", r.Channel, r.Version)
+		return m.store.SaveRollout(ctx, r)
+	}
+	if err != nil {
+		return r, err
+	}
+
+	outcomes, err := m.store.CountUpgradeOutcomes(ctx, r.Channel, r.Version, r.CohortStartedAt)
+	if err != nil {
+		return r, err
+	}
+	next := r.Step(outcomes, m.now().UTC())
+	if next.Succeeded == r.Succeeded && next.Failed == r.Failed && next.Status == r.Status && next.Cohort == r.Cohort {
+		return r, nil
+	}
+	switch {
+	case next.Status == store.RolloutPaused:
+		if _, _, err := m.store.SetPlanPaused(ctx, key, true); err != nil {
+			return r, err
+		}
+		m.logf("rollout %s %s paused: %s", r.Channel, r.Version, next.Reason)
+	case next.Cohort != r.Cohort:
+		if _, _, err := m.store.SetPlanRollout(ctx, key, next.Percent()); err != nil {
+			return r, err
+		}
+		m.logf("rollout %s %s advanced to %d%%", r.Channel, r.Version, next.Percent())
+	case next.Status == store.RolloutCompleted:
+		m.logf("rollout %s %s completed", r.Channel, r.Version)
+	}
+	saved, err := m.store.SaveRollout(ctx, next)
+	if err == nil && saved.Status == store.RolloutCompleted {
+		m.notify.rolloutCompleted(saved)
+	}
+	return saved, err
+}
+
+// run evaluates every active rollout on each interval until ctx is cancelled.
+func (m *rolloutManager) run(ctx context.Context) {
+	ticker := time.NewTicker(rolloutCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rollouts, err := m.store.ListRollouts(ctx)
+			if err != nil {
+				m.logf("list rollouts failed: %v", err)
+				continue
+			}
+			for _, r := range rollouts {
+				if r.Status != store.RolloutActive {
+					continue
+				}
+				if _, err := m.evaluate(ctx, r.Channel); err != nil {
+					m.logf("evaluate rollout %s failed: %v", r.Channel, err)
+				}
+			}
+		}
+	}
+}
+
+// RunRollouts advances cohort rollouts until ctx is cancelled.
+func (s *Server) RunRollouts(ctx context.Context) {
+	s.rollouts.run(ctx)
+}
+
+// adminCreateRolloutHandler starts a cohort rollout of the channel plan's
+// current version, replacing any finished rollout of that channel.
+func adminCreateRolloutHandler(cfg Config, deps Dependencies, rollouts *rolloutManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Channel        string   `json:"channel"`
+			Version        string   `json:"version"`
+			Cohorts        []int    `json:"cohorts"`
+			MinReports     *int     `json:"min_reports"`
+			MaxFailureRate *float64 `json:"max_failure_rate"`
+			SoakSeconds    int      `json:"soak_seconds"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		now := rollouts.now().UTC()
+		rollout := store.Rollout{
+			Channel:         strings.ToLower(strings.TrimSpace(defaultChannel(req.Channel))),
+			Version:         req.Version,
+			Cohorts:         req.Cohorts,
+			MinReports:      defaultRolloutMinReports,
+			MaxFailureRate:  defaultRolloutFailures,
+			SoakSeconds:     req.SoakSeconds,
+			Status:          store.RolloutActive,
+			CohortStartedAt: now,
+			CreatedAt:       now,
+		}
+		if req.MinReports != nil {
+			rollout.MinReports = *req.MinReports
+		}
+		if req.MaxFailureRate != nil {
+			rollout.MaxFailureRate = *req.MaxFailureRate
+		}
+		if err := store.ValidateRollout(rollout); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		rollouts.mu.Lock()
+		defer rollouts.mu.Unlock()
+		if existing, err := deps.Store.GetRollout(r.Context(), rollout.Channel); err == nil && (existing.Status == store.RolloutActive || existing.Status == store.RolloutPaused) {
+			http.Error(w, "channel "+rollout.Channel+" already has a "+existing.Status+" rollout", http.StatusConflict)
+			return
+		} else if err != nil && !errors.Is(err, store.ErrRolloutNotFound) {
+			deps.Logger.Printf("load rollout %s failed: %v", rollout.Channel, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		key := "channel:" + rollout.Channel
+		plan, _, err := deps.Store.GetUpgradePlan(r.Context(), key)
+		if err != nil {
+			writePlanError(w, deps, err)
+			return
+		}
+		if rollout.Version == "" {
+			rollout.Version = plan.Artifact.Version
+		}
+		if plan.Artifact.Version != rollout.Version {
+			http.Error(w, "channel plan targets "+plan.Artifact.Version+", not "+rollout.Version, http.StatusConflict)
+			return
+		}
+		if _, _, err := deps.Store.SetPlanRollout(r.Context(), key, rollout.Percent()); err != nil {
+			writePlanError(w, deps, err)
+			return
+		}
+		saved, err := deps.Store.SaveRollout(r.Context(), rollout)
+		if err != nil {
+			deps.Logger.Printf("save rollout %s failed: %v", rollout.Channel, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		deps.Logger.Printf("admin started rollout of %s on %s at %d%%", saved.Version, saved.Channel, saved.Percent())
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(saved)
+	}
+}
+
+func adminListRolloutsHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rollouts, err := deps.Store.ListRollouts(r.Context())
+		if err != nil {
+			deps.Logger.Printf("list rollouts failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Items []store.Rollout `json:"items"`
+		}{Items: rollouts})
+	}
+}
+
+// adminGetRolloutHandler evaluates the channel's rollout before returning
+// it, so the counts are current.
+func adminGetRolloutHandler(cfg Config, deps Dependencies, rollouts *rolloutManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rollout, err := rollouts.evaluate(r.Context(), mux.Vars(r)["channel"])
+		if err != nil {
+			writeRolloutError(w, deps, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(rollout)
+	}
+}
+
+// adminPauseRolloutHandler pauses or resumes a rollout along with its plan.
+// Resuming restarts the current cohort's counts and soak.
+func adminPauseRolloutHandler(cfg Config, deps Dependencies, rollouts *rolloutManager, paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rollouts.mu.Lock()
+		defer rollouts.mu.Unlock()
+		rollout, err := deps.Store.GetRollout(r.Context(), mux.Vars(r)["channel"])
+		if err != nil {
+			writeRolloutError(w, deps, err)
+			return
+		}
+		from, to := store.RolloutActive, store.RolloutPaused
+		if !paused {
+			from, to = to, from
+		}
+		if rollout.Status != from {
+			http.Error(w, "rollout is "+rollout.Status, http.StatusConflict)
+			return
+		}
+		if _, _, err := deps.Store.SetPlanPaused(r.Context(), "channel:"+rollout.Channel, paused); err != nil {
+			writePlanError(w, deps, err)
+			return
+		}
+		rollout.Status = to
+		rollout.Reason = ""
+		if paused {
+			rollout.Reason = "paused by admin"
+		} else {
+			rollout.CohortStartedAt = rollouts.now().UTC()
+			rollout.Succeeded, rollout.Failed = 0, 0
+		}
+		saved, err := deps.Store.SaveRollout(r.Context(), rollout)
+		if err != nil {
+			writeRolloutError(w, deps, err)
+			return
+		}
+		deps.Logger.Printf("admin set rollout %s %s", saved.Channel, saved.Status)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(saved)
+	}
+}
+
+func writeRolloutError(w http.ResponseWriter, deps Dependencies, err error) {
+	if errors.Is(err, store.ErrRolloutNotFound) {
+		http.Error(w, "rollout not found", http.StatusNotFound)
+		return
+	}
+	deps.Logger.Printf("rollout request failed: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func writePlanError(w http.ResponseWriter, deps Dependencies, err error) {
+	if errors.Is(err, store.ErrPlanNotFound) {
+		http.Error(w, "plan not found", http.StatusNotFound)
+		return
+	}
+	deps.Logger.Printf("plan request failed: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
+func defaultChannel(channel string) string {
+	if strings.TrimSpace(channel) == "" {
+		return "stable"
+	}
+	return channel
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestRolloutAdvancesAndPausesOnFailures(t *testing.T) {
+	st := store.NewMemoryStore()
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	do := func(method, path, agentID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if agentID != "" {
+			req.Header.Set("X-Agent-ID", agentID)
+		} else {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		return rec
+	}
+	report := func(agentID, status string) {
+		now := time.Now().UTC().Format(time.RFC3339Nano)
+		body := `{"current_version":"2.0.0","channel":"canary","status":"` + status + `","started_at":"` + now + `","completed_at":"` + now + `"}`
+		if rec := do(http.MethodPost, "/api/agent/v1/upgrade/report", agentID, body); rec.Code != http.StatusNoContent {
+			t.Fatalf("report from %s: status %d", agentID, rec.Code)
+		}
+	}
+	plan := func() store.UpgradePlanResponse {
+		p, _, err := st.GetUpgradePlan(context.Background(), "channel:canary")
+		if err != nil {
+			t.Fatalf("get plan: %v", err)
+		}
+		return p
+	}
+	rollout := func() store.Rollout {
+		rec := do(http.MethodGet, "/api/admin/v1/upgrade/rollouts/canary", "", "")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("get rollout: status %d", rec.Code)
+		}
+		var r store.Rollout
+		if err := json.Unmarshal(rec.Body.Bytes(), &r); err != nil {
+			t.Fatalf("decode rollout: %v", err)
+		}
+		return r
+	}
+
+	const create = `{"channel":"canary","cohorts":[10,50,100],"min_reports":2,"max_failure_rate":0.5}`
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/rollouts", "", create); rec.Code != http.StatusNotFound {
+		t.Fatalf("rollout without plan: expected 404, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/plan", "", `{"channel":"canary","artifact":{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("upsert plan: status %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/rollouts", "", `{"channel":"canary","cohorts":[10,50]}`); rec.Code != http.StatusBadRequest {
+		t.Fatalf("cohorts short of 100: expected 400, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/rollouts", "", create); rec.Code != http.StatusCreated {
+		t.Fatalf("create rollout: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/rollouts", "", create); rec.Code != http.StatusConflict {
+		t.Fatalf("second rollout: expected 409, got %d", rec.Code)
+	}
+	if p := plan(); p.RolloutPercent != 10 {
+		t.Fatalf("plan percent %d, want 10", p.RolloutPercent)
+	}
+
+	report("agt_1", "success")
+	if r := rollout(); r.Cohort != 0 || r.Succeeded != 1 {
+		t.Fatalf("one report should not advance: %+v", r)
+	}
+	report("agt_2", "success")
+	if r := rollout(); r.Cohort != 1 || r.Status != store.RolloutActive {
+		t.Fatalf("expected the second cohort: %+v", r)
+	}
+	if p := plan(); p.RolloutPercent != 50 {
+		t.Fatalf("plan percent %d, want 50", p.RolloutPercent)
+	}
+
+	// One failure out of the two required reports is within the threshold.
+	report("agt_3", "failed")
+	if r := rollout(); r.Status != store.RolloutActive {
+		t.Fatalf("rollout paused early: %+v", r)
+	}
+	report("agt_4", "failed")
+	r := rollout()
+	if r.Status != store.RolloutPaused || r.Reason == "" {
+		t.Fatalf("expected a paused rollout: %+v", r)
+	}
+	if p := plan(); !p.Paused || p.RolloutPercent != 50 {
+		t.Fatalf("plan should be paused at 50%%: %+v", p)
+	}
+
+	if rec := do(http.MethodPost, "/api/admin/v1/upgrade/rollouts/canary/resume", "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("resume: status %d", rec.Code)
+	}
+	if r := rollout(); r.Status != store.RolloutActive || r.Failed != 0 {
+		t.Fatalf("resume should restart the cohort: %+v", r)
+	}
+	if p := plan(); p.Paused {
+		t.Fatalf("plan still paused after resume")
+	}
+	report("agt_5", "success")
+	report("agt_6", "success")
+	report("agt_7", "success")
+	report("agt_8", "success")
+	if r := rollout(); r.Status != store.RolloutCompleted {
+		t.Fatalf("expected a completed rollout: %+v", r)
+	}
+	if p := plan(); p.RolloutPercent != 100 {
+		t.Fatalf("plan percent %d, want 100", p.RolloutPercent)
+	}
+}
@@ -1,22 +1,34 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/pingsantohq/agent/pkg/revocation"
 	"github.com/pingsantohq/controller/internal/artifacts"
+	"github.com/pingsantohq/controller/internal/auth"
+	"github.com/pingsantohq/controller/internal/bundle"
+	"github.com/pingsantohq/controller/internal/notify"
+	"github.com/pingsantohq/controller/internal/results"
 	"github.com/pingsantohq/controller/internal/store"
+	"github.com/pingsantohq/controller/internal/webhook"
 )
 
+const maxBundleBytes = 10 << 20
+
 // Config controls HTTP server settings.
 type Config struct {
 	Addr             string
@@ -27,6 +39,58 @@ type Config struct {
 	AdminBearerToken string
 	PublicBaseURL    string
 	ArtifactPath     string
+	// MonitorStreamKeepalive is the idle interval between keepalive lines on agent monitor streams.
+	MonitorStreamKeepalive time.Duration
+	// ReplayProtection selects how agent requests with stale timestamps or reused
+	// nonces are handled: "off" (default), "log", or "enforce".
+	ReplayProtection string
+	// ReplayMaxSkew is the clock-skew tolerance for agent request timestamps.
+	ReplayMaxSkew time.Duration
+	// StatsInterval is how often capacity-planning samples are recorded (default 1m).
+	StatsInterval time.Duration
+	// StatsRetention is how long capacity-planning samples are kept (default 30d).
+	StatsRetention time.Duration
+	// AgentStaleAfter is how long after its last heartbeat the agent inventory
+	// reports an agent as stale (default 5m).
+	AgentStaleAfter time.Duration
+	// AdminAPIKeys are named admin credentials accepted in the X-API-Key header
+	// alongside AdminBearerToken.
+	AdminAPIKeys []auth.APIKey
+	// SPIFFETrustDomain enables mapping SPIFFE IDs in agent client
+	// certificates to agent IDs in mtls mode; SPIFFEAgentPrefix is the ID path
+	// before the agent ID (default "/pingsanto/agent/").
+	SPIFFETrustDomain string
+	SPIFFEAgentPrefix string
+	// AgentCertRevocation checks agent client certificates in mtls mode
+	// against OCSP and CRLs: "off" (default), "soft" (reject only certificates
+	// reported revoked) or "hard" (also reject when status is unknown).
+	// AgentCRLFiles are local CRLs consulted first.
+	AgentCertRevocation string
+	AgentCRLFiles       []string
+	// TLSCertFile and TLSKeyFile enable TLS on the listener (see TLSConfig).
+	// AgentClientCAFiles are the CA bundles agent client certificates are
+	// verified against; list the old and new CA during a rotation.
+	TLSCertFile        string
+	TLSKeyFile         string
+	AgentClientCAFiles []string
+	// Webhooks are the endpoints that receive signed upgrade report events.
+	Webhooks webhook.Config
+	// AgentRateLimit and AdminRateLimit are the sustained requests per second
+	// allowed for each agent and each admin credential, with bursts of up to
+	// AgentRateBurst and AdminRateBurst (default: one second's worth).
+	// Requests over the limit get 429; zero disables limiting.
+	AgentRateLimit float64
+	AgentRateBurst int
+	AdminRateLimit float64
+	AdminRateBurst int
+	// GRPCAddr is the listen address for the gRPC agent API served by
+	// Server.GRPCServer; cmd/controller leaves it off when empty.
+	GRPCAddr string
+	// RequirePlanIfMatch rejects admin plan upserts that send neither
+	// If-Match with the plan's current ETag nor If-None-Match: * (to create
+	// a plan) with 428, so concurrent edits cannot overwrite each other.
+	// Conditional headers are honoured either way.
+	RequirePlanIfMatch bool
 }
 
 // Dependencies holds external collaborators required by the server.
@@ -34,13 +98,40 @@ type Dependencies struct {
 	Logger        *log.Logger
 	Store         store.Store
 	ArtifactStore artifacts.Store
+	// Results stores probe results uploaded by agents (default: in memory).
+	Results results.Store
+	// AgentAuth and AdminAuth override the authentication chains built from
+	// Config, e.g. to add an OIDC verifier:
+	// auth.Chain{server.AdminAuthenticator(cfg, deps), oidcVerifier}.
+	AgentAuth auth.Authenticator
+	AdminAuth auth.Authenticator
+	// Issuer signs credentials for agents enrolling with a token; enrollment
+	// is disabled when nil.
+	Issuer CertIssuer
+	// Attestor verifies cloud identity documents sent at enrollment; hosts
+	// cannot redeem cloud-scoped tokens when nil.
+	Attestor Attestor
+	// OIDC verifies ID tokens operators obtain with `enroll --oidc-issuer`;
+	// enrollment requires a token when nil.
+	OIDC OIDCVerifier
+	// Notifier receives plan publish and rollout completion notifications
+	// while the notify_on_publish setting is on; none are sent when nil.
+	Notifier notify.Notifier
+	// Log receives request logs and store failures as structured records;
+	// it defaults to JSON lines on Logger's writer.
+	Log *slog.Logger
 }
 
 // Server wraps http.Server for convenience.
 type Server struct {
 	*http.Server
-	cfg  Config
-	deps Dependencies
+	cfg      Config
+	deps     Dependencies
+	hub      *snapshotHub
+	stats    *statsCollector
+	rollouts *rolloutManager
+	webhooks *webhook.Dispatcher
+	rpc      *agentRPC
 }
 
 // New constructs an HTTP server with upgrade endpoints.
@@ -51,9 +142,16 @@ func New(cfg Config, deps Dependencies) *Server {
 	if deps.Logger == nil {
 		deps.Logger = log.New(io.Discard, "", 0)
 	}
+	if deps.Log == nil {
+		deps.Log = slog.New(slog.NewJSONHandler(deps.Logger.Writer(), nil))
+	}
 	if deps.Store == nil {
 		deps.Store = store.NewMemoryStore()
 	}
+	metrics := newControllerMetrics()
+	deps.Store = meteredStore{Store: deps.Store, metrics: metrics, log: deps.Log}
+	plans := newSnapshotHub()
+	deps.Store = planWatchStore{Store: deps.Store, hub: plans}
 	if cfg.ArtifactPath == "" {
 		cfg.ArtifactPath = "/artifacts"
 	}
@@ -63,39 +161,119 @@ func New(cfg Config, deps Dependencies) *Server {
 	if deps.ArtifactStore == nil {
 		deps.ArtifactStore = artifacts.NewMemoryStore()
 	}
+	if deps.Results == nil {
+		deps.Results = results.NewMemoryStore()
+	}
+	if deps.AgentAuth == nil {
+		deps.AgentAuth = AgentAuthenticator(cfg, deps)
+	}
+	if deps.AdminAuth == nil {
+		deps.AdminAuth = AdminAuthenticator(cfg, deps)
+	}
 
-	r := mux.NewRouter()
-	r.HandleFunc("/api/agent/v1/upgrade/plan", planHandler(cfg, deps)).Methods(http.MethodGet)
-	r.HandleFunc("/api/agent/v1/upgrade/report", reportHandler(cfg, deps)).Methods(http.MethodPost)
-	r.HandleFunc("/api/admin/v1/upgrade/plan", adminUpsertPlanHandler(cfg, deps)).Methods(http.MethodPost)
-	r.HandleFunc("/api/admin/v1/upgrade/history/{agent_id}", adminHistoryHandler(cfg, deps)).Methods(http.MethodGet)
-	r.HandleFunc("/api/admin/v1/settings/notifications", adminGetNotificationSettingsHandler(cfg, deps)).Methods(http.MethodGet)
-	r.HandleFunc("/api/admin/v1/settings/notifications", adminUpdateNotificationSettingsHandler(cfg, deps)).Methods(http.MethodPost)
-	r.HandleFunc("/api/admin/v1/artifacts", adminUploadArtifactHandler(cfg, deps)).Methods(http.MethodPost)
 	artifactRoute := strings.TrimRight(cfg.ArtifactPath, "/")
 	if artifactRoute == "" {
 		artifactRoute = "/artifacts"
 	}
+
+	hub := newSnapshotHub()
+	replay := newReplayGuard(cfg, deps)
+	stats := newStatsCollector(cfg, deps, fmt.Sprintf("%s/{name}", artifactRoute))
+	notifier := newPublishNotifier(deps)
+	rollouts := newRolloutManager(deps, notifier)
+	webhooks := webhook.New(cfg.Webhooks, deps.Logger.Printf)
+
+	requireAgent := auth.Require(deps.AgentAuth, auth.RoleAgent)
+	agentLimit := newRateLimiter(auth.RoleAgent, cfg.AgentRateLimit, cfg.AgentRateBurst, metrics)
+	agent := func(h http.Handler) http.Handler {
+		return requireAgent(notePrincipal(replay.middleware(agentLimit.middleware(h))))
+	}
+	requireAdmin := auth.Require(deps.AdminAuth, auth.RoleAdmin)
+	adminLimit := newRateLimiter(auth.RoleAdmin, cfg.AdminRateLimit, cfg.AdminRateBurst, metrics)
+	audit := auditMiddleware(deps)
+	admin := func(scope string, h http.Handler) http.Handler {
+		return requireAdmin(notePrincipal(adminLimit.middleware(audit(auth.RequireScope(scope)(h)))))
+	}
+
+	r := mux.NewRouter()
+	r.Use(noteRoute)
+	r.Use(metrics.middleware)
+	r.Use(stats.middleware)
+	r.Handle(planRoute, agent(planHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle(planStreamRoute, agent(planStreamHandler(cfg, deps, plans))).Methods(http.MethodGet)
+	r.Handle("/api/agent/v1/upgrade/report", agent(reportHandler(cfg, deps, rollouts, webhooks, metrics))).Methods(http.MethodPost)
+	r.Handle("/api/agent/v1/heartbeat", agent(heartbeatHandler(cfg, deps, hub))).Methods(http.MethodPost)
+	r.Handle(resultsRoute, agent(resultsHandler(cfg, deps, stats))).Methods(http.MethodPost)
+	r.Handle("/api/agent/v1/directives/{directive_id}/ack", agent(directiveAckHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/agent/v1/monitors", agent(monitorSnapshotHandler(cfg, deps, hub))).Methods(http.MethodGet)
+	r.Handle("/api/agent/v1/monitors/stream", agent(monitorStreamHandler(cfg, deps, hub))).Methods(http.MethodGet)
+	r.HandleFunc(enrollRoute, enrollHandler(cfg, deps)).Methods(http.MethodPost)
+	r.Handle(certificateRoute, agent(renewCertificateHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/upgrade/plan", admin(auth.ScopePlans, adminUpsertPlanHandler(cfg, deps, notifier))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/upgrade/plans", admin(auth.ScopeRead, adminListPlansHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/upgrade/plan/{key}", admin(auth.ScopeRead, adminGetPlanHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/upgrade/plan/{key}", admin(auth.ScopePlans, adminDeletePlanHandler(cfg, deps, rollouts))).Methods(http.MethodDelete)
+	r.Handle("/api/admin/v1/upgrade/plan/{key}/rollout", admin(auth.ScopePlans, adminPlanRolloutHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/upgrade/rollouts", admin(auth.ScopePlans, adminCreateRolloutHandler(cfg, deps, rollouts))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/upgrade/rollouts", admin(auth.ScopeRead, adminListRolloutsHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/upgrade/rollouts/{channel}", admin(auth.ScopeRead, adminGetRolloutHandler(cfg, deps, rollouts))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/upgrade/rollouts/{channel}/pause", admin(auth.ScopePlans, adminPauseRolloutHandler(cfg, deps, rollouts, true))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/upgrade/rollouts/{channel}/resume", admin(auth.ScopePlans, adminPauseRolloutHandler(cfg, deps, rollouts, false))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/upgrade/history", admin(auth.ScopeRead, adminFleetHistoryHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/upgrade/history/{agent_id}", admin(auth.ScopeRead, adminHistoryHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/settings/notifications", admin(auth.ScopeRead, adminGetNotificationSettingsHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/settings/notifications", admin(auth.ScopeSettings, adminUpdateNotificationSettingsHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/settings/config-overlay", admin(auth.ScopeRead, adminGetConfigOverlayHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/settings/config-overlay", admin(auth.ScopeSettings, adminUpdateConfigOverlayHandler(cfg, deps, hub))).Methods(http.MethodPost)
+	r.Handle(artifactUploadRoute, admin(auth.ScopeArtifacts, adminUploadArtifactHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/agents", admin(auth.ScopeRead, adminListAgentsHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/agents/{agent_id}/archive", admin(auth.ScopeRead, adminAgentArchiveHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/certs/expiry", admin(auth.ScopeRead, adminCertExpiryHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/certs/renewals", admin(auth.ScopeAgents, adminCertRenewalHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/monitors/bundles", admin(auth.ScopeMonitors, adminApplyBundleHandler(cfg, deps, hub))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/monitors/{agent_id}/snapshots", admin(auth.ScopeMonitors, adminPublishSnapshotHandler(cfg, deps, hub))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/monitors/{agent_id}/snapshots", admin(auth.ScopeRead, adminListSnapshotsHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/monitors/{agent_id}/snapshots/{revision}", admin(auth.ScopeRead, adminGetSnapshotHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/monitors/{agent_id}/diff", admin(auth.ScopeRead, adminSnapshotDiffHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle(catalogRoute, admin(auth.ScopeRead, adminListCatalogHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle(catalogMonitorRoute, admin(auth.ScopeRead, adminGetCatalogMonitorHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle(catalogMonitorRoute, admin(auth.ScopeMonitors, adminPutCatalogMonitorHandler(cfg, deps, hub))).Methods(http.MethodPut)
+	r.Handle(catalogMonitorRoute, admin(auth.ScopeMonitors, adminDeleteCatalogMonitorHandler(cfg, deps, hub))).Methods(http.MethodDelete)
+	r.Handle("/api/admin/v1/stats", admin(auth.ScopeRead, adminStatsHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/enrollment/tokens", admin(auth.ScopeAgents, adminMintEnrollmentTokenHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/enrollment/tokens", admin(auth.ScopeRead, adminListEnrollmentTokensHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/enrollment/tokens/{token_id}", admin(auth.ScopeAgents, adminRevokeEnrollmentTokenHandler(cfg, deps))).Methods(http.MethodDelete)
+	r.Handle("/api/admin/v1/keys", admin(auth.ScopeAdmin, adminCreateKeyHandler(cfg, deps))).Methods(http.MethodPost)
+	r.Handle("/api/admin/v1/keys", admin(auth.ScopeAdmin, adminListKeysHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/keys/{key_id}", admin(auth.ScopeAdmin, adminRevokeKeyHandler(cfg, deps))).Methods(http.MethodDelete)
+	r.Handle("/api/admin/v1/audit", admin(auth.ScopeRead, adminAuditHandler(cfg, deps))).Methods(http.MethodGet)
+	r.Handle("/api/admin/v1/enrollment/bundles", admin(auth.ScopeAgents, adminEnrollmentBundleHandler(cfg, deps))).Methods(http.MethodPost)
 	r.HandleFunc(fmt.Sprintf("%s/{name}", artifactRoute), artifactDownloadHandler(cfg, deps)).Methods(http.MethodGet)
+	r.HandleFunc("/metrics", metricsHandler(replay, metrics)).Methods(http.MethodGet)
 	r.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
 
 	s := &http.Server{
 		Addr:         cfg.Addr,
-		Handler:      r,
+		Handler:      requestLogger(deps.Log, r),
 		ReadTimeout:  cfg.ReadTimeout,
 		WriteTimeout: cfg.WriteTimeout,
 		IdleTimeout:  cfg.IdleTimeout,
 	}
-	return &Server{Server: s, cfg: cfg, deps: deps}
+	rpc := &agentRPC{
+		deps: deps, hub: hub, plans: plans, stats: stats, rollouts: rollouts, webhooks: webhooks,
+		metrics: metrics, replay: replay, limit: agentLimit,
+	}
+	return &Server{Server: s, cfg: cfg, deps: deps, hub: hub, stats: stats, rollouts: rollouts, webhooks: webhooks, rpc: rpc}
+}
+
+// RunWebhooks delivers queued webhook events until ctx is cancelled.
+func (s *Server) RunWebhooks(ctx context.Context) {
+	s.webhooks.Run(ctx)
 }
 
 func planHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		agentID, err := extractAgentID(r, cfg.AgentAuthMode)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
+		agentID := requestAgentID(r)
 
 		channel := r.URL.Query().Get("channel")
 		plan, etag, err := deps.Store.FetchUpgradePlan(r.Context(), agentID, channel)
@@ -122,13 +300,9 @@ func planHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	}
 }
 
-func reportHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+func reportHandler(cfg Config, deps Dependencies, rollouts *rolloutManager, webhooks *webhook.Dispatcher, metrics *controllerMetrics) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		agentID, err := extractAgentID(r, cfg.AgentAuthMode)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusUnauthorized)
-			return
-		}
+		agentID := requestAgentID(r)
 
 		var req store.UpgradeReport
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -137,22 +311,37 @@ func reportHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 		}
 		req.AgentID = agentID
 
-		if err := deps.Store.RecordUpgradeReport(r.Context(), req); err != nil {
-			deps.Logger.Printf("record report failed for agent %s: %v", agentID, err)
+		if err := recordReport(r.Context(), deps, rollouts, webhooks, metrics, req); err != nil {
 			http.Error(w, "unable to record report", http.StatusInternalServerError)
 			return
 		}
-
 		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-func adminUpsertPlanHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+// recordReport stores an agent's upgrade report and acts on it, for the
+// REST and gRPC agent APIs alike.
+func recordReport(ctx context.Context, deps Dependencies, rollouts *rolloutManager, webhooks *webhook.Dispatcher, metrics *controllerMetrics, req store.UpgradeReport) error {
+	if err := deps.Store.RecordUpgradeReport(ctx, req); err != nil {
+		deps.Logger.Printf("record report failed for agent %s: %v", req.AgentID, err)
+		return err
+	}
+	metrics.report(req.Status)
+	// A report can move the channel's rollout on or pause it.
+	if _, err := rollouts.evaluate(ctx, defaultChannel(req.Channel)); err != nil && !errors.Is(err, store.ErrRolloutNotFound) {
+		deps.Logger.Printf("evaluate rollout after report from agent %s failed: %v", req.AgentID, err)
+	}
+	switch req.Status {
+	case "success":
+		webhooks.Publish(webhook.EventUpgradeSucceeded, req)
+	case "failed":
+		webhooks.Publish(webhook.EventUpgradeFailed, req)
+	}
+	return nil
+}
+
+func adminUpsertPlanHandler(cfg Config, deps Dependencies, notifier *publishNotifier) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeAdmin(r, cfg.AdminBearerToken) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
 		var req struct {
 			AgentID  string         `json:"agent_id"`
 			Channel  string         `json:"channel"`
@@ -160,32 +349,83 @@ func adminUpsertPlanHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 			Schedule store.Schedule `json:"schedule"`
 			Paused   bool           `json:"paused"`
 			Notes    string         `json:"notes"`
+			// RolloutPercent limits a channel plan to a share of its agents.
+			RolloutPercent *int `json:"rollout_percent"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "invalid json", http.StatusBadRequest)
 			return
 		}
+		if req.Artifact.Size < 0 {
+			http.Error(w, "artifact size must not be negative", http.StatusBadRequest)
+			return
+		}
+		if err := store.ValidateDeltas(req.Artifact.Deltas); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := store.ValidateMirrors(req.Artifact.Mirrors); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := store.ValidatePlatforms(req.Artifact.Platforms); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := store.ValidateMaintenanceWindows(req.Schedule.MaintenanceWindows); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+		ifAbsent := strings.TrimSpace(r.Header.Get("If-None-Match")) == "*"
+		if cfg.RequirePlanIfMatch && ifMatch == "" && !ifAbsent {
+			http.Error(w, "If-Match required: send the plan's current ETag, or If-None-Match: * to create it", http.StatusPreconditionRequired)
+			return
+		}
 
 		input := store.PlanInput{
-			AgentID:          req.AgentID,
-			Channel:          req.Channel,
-			Version:          req.Artifact.Version,
-			ArtifactURL:      req.Artifact.URL,
-			ArtifactSHA256:   req.Artifact.SHA256,
-			SignatureURL:     req.Artifact.SignatureURL,
-			ForceApply:       req.Artifact.ForceApply,
-			ScheduleEarliest: req.Schedule.Earliest,
-			ScheduleLatest:   req.Schedule.Latest,
-			Paused:           req.Paused,
-			Notes:            req.Notes,
+			AgentID:            req.AgentID,
+			Channel:            req.Channel,
+			Version:            req.Artifact.Version,
+			ArtifactURL:        req.Artifact.URL,
+			ArtifactSHA256:     req.Artifact.SHA256,
+			SignatureURL:       req.Artifact.SignatureURL,
+			ArtifactSize:       req.Artifact.Size,
+			ForceApply:         req.Artifact.ForceApply,
+			IgnoreReadiness:    req.Artifact.IgnoreReadiness,
+			ScheduleEarliest:   req.Schedule.Earliest,
+			ScheduleLatest:     req.Schedule.Latest,
+			MaintenanceWindows: req.Schedule.MaintenanceWindows,
+			Prefetch:           req.Schedule.Prefetch,
+			Paused:             req.Paused,
+			Notes:              req.Notes,
+			Deltas:             req.Artifact.Deltas,
+			Mirrors:            req.Artifact.Mirrors,
+			Platforms:          req.Artifact.Platforms,
+			RolloutPercent:     req.RolloutPercent,
+			IfMatch:            ifMatch,
+			IfAbsent:           ifAbsent,
 		}
 
 		plan, etag, err := deps.Store.UpsertUpgradePlan(r.Context(), input)
+		if errors.Is(err, store.ErrPlanPreconditionFailed) {
+			// Hand back the current ETag so the client can re-read and retry.
+			if _, current, err := deps.Store.GetUpgradePlan(r.Context(), input.Key()); err == nil {
+				w.Header().Set("ETag", current)
+			}
+			http.Error(w, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
 		if err != nil {
 			deps.Logger.Printf("upsert plan failed: %v", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+		var actor string
+		if p, ok := auth.FromContext(r.Context()); ok {
+			actor = p.Subject
+		}
+		notifier.planPublished(plan, actor)
 
 		w.Header().Set("Content-Type", "application/json")
 		w.Header().Set("ETag", etag)
@@ -194,46 +434,47 @@ func adminUpsertPlanHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	}
 }
 
-func adminHistoryHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+// adminPlanRolloutHandler changes the share of a channel's agents that
+// receive its plan, e.g. to widen a rollout once the first wave is healthy.
+func adminPlanRolloutHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeAdmin(r, cfg.AdminBearerToken) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		key := mux.Vars(r)["key"]
+		var req struct {
+			Percent *int `json:"percent"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Percent == nil {
+			http.Error(w, "invalid json: percent required", http.StatusBadRequest)
 			return
 		}
-		vars := mux.Vars(r)
-		agentID := vars["agent_id"]
-		if agentID == "" {
-			http.Error(w, "agent_id required", http.StatusBadRequest)
+		if !strings.HasPrefix(key, "channel:") {
+			http.Error(w, "rollouts apply only to channel plans (channel:<name>)", http.StatusBadRequest)
 			return
 		}
-		limit := 50
-		if raw := r.URL.Query().Get("limit"); raw != "" {
-			if v, err := strconv.Atoi(raw); err == nil && v > 0 {
-				limit = v
-			}
+		if err := store.ValidateRolloutPercent(*req.Percent); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		reports, err := deps.Store.ListUpgradeHistory(r.Context(), agentID, limit)
+		plan, etag, err := deps.Store.SetPlanRollout(r.Context(), key, *req.Percent)
 		if err != nil {
-			deps.Logger.Printf("list history failed: %v", err)
+			if errors.Is(err, store.ErrPlanNotFound) {
+				http.Error(w, "plan not found", http.StatusNotFound)
+				return
+			}
+			deps.Logger.Printf("set rollout for plan %s failed: %v", key, err)
 			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
+		deps.Logger.Printf("admin set plan %s rollout to %d%%", key, plan.RolloutPercent)
 
 		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(struct {
-			AgentID string                `json:"agent_id"`
-			Items   []store.UpgradeReport `json:"items"`
-		}{AgentID: agentID, Items: reports})
+		w.Header().Set("ETag", etag)
+		_ = json.NewEncoder(w).Encode(plan)
 	}
 }
 
 func adminGetNotificationSettingsHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeAdmin(r, cfg.AdminBearerToken) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
 		settings, err := deps.Store.GetNotificationSettings(r.Context())
 		if err != nil {
 			deps.Logger.Printf("get notification settings failed: %v", err)
@@ -247,10 +488,6 @@ func adminGetNotificationSettingsHandler(cfg Config, deps Dependencies) http.Han
 
 func adminUpdateNotificationSettingsHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeAdmin(r, cfg.AdminBearerToken) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
 		var req struct {
 			NotifyOnPublish *bool `json:"notify_on_publish"`
 		}
@@ -273,12 +510,49 @@ func adminUpdateNotificationSettingsHandler(cfg Config, deps Dependencies) http.
 	}
 }
 
-func adminUploadArtifactHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+func adminGetConfigOverlayHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !authorizeAdmin(r, cfg.AdminBearerToken) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		overlay, err := deps.Store.GetConfigOverlay(r.Context())
+		if err != nil {
+			deps.Logger.Printf("get config overlay failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
 			return
 		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(overlay)
+	}
+}
+
+// adminUpdateConfigOverlayHandler replaces the fleet config overlay and wakes
+// agents waiting on monitor long-polls or streams so it applies promptly. An
+// empty object clears the overlay.
+func adminUpdateConfigOverlayHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req store.ConfigOverlay
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := store.ValidateConfigOverlay(req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		overlay, err := deps.Store.UpdateConfigOverlay(r.Context(), req)
+		if err != nil {
+			deps.Logger.Printf("update config overlay failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		hub.notifyAll()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(overlay)
+	}
+}
+
+func adminUploadArtifactHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
 		if deps.ArtifactStore == nil {
 			http.Error(w, "artifact store not configured", http.StatusServiceUnavailable)
 			return
@@ -350,6 +624,175 @@ func adminUploadArtifactHandler(cfg Config, deps Dependencies) http.HandlerFunc
 	}
 }
 
+// adminPublishSnapshotHandler replaces the directly published monitors of an
+// agent, keeping those the catalog assigns it.
+func adminPublishSnapshotHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := mux.Vars(r)["agent_id"]
+		var req struct {
+			Monitors []store.MonitorAssignment `json:"monitors"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid json", http.StatusBadRequest)
+			return
+		}
+		if err := store.ValidateMonitors(req.Monitors); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		snapshots := map[string][]store.MonitorAssignment{agentID: req.Monitors}
+		if err := mergeOwnedMonitors(r.Context(), deps, snapshots, false); err != nil {
+			deps.Logger.Printf("load monitor snapshot failed for agent %s: %v", agentID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		snapshot, err := deps.Store.PublishMonitorSnapshot(r.Context(), agentID, snapshots[agentID])
+		if err != nil {
+			deps.Logger.Printf("publish monitor snapshot failed for agent %s: %v", agentID, err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		hub.notify(agentID)
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+func adminListSnapshotsHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := mux.Vars(r)["agent_id"]
+		limit := 50
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			if v, err := strconv.Atoi(raw); err == nil && v > 0 {
+				limit = v
+			}
+		}
+
+		revisions, err := deps.Store.ListMonitorRevisions(r.Context(), agentID, limit)
+		if err != nil {
+			deps.Logger.Printf("list monitor revisions failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			AgentID string                  `json:"agent_id"`
+			Items   []store.MonitorRevision `json:"items"`
+		}{AgentID: agentID, Items: revisions})
+	}
+}
+
+func adminGetSnapshotHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		snapshot, err := deps.Store.GetMonitorSnapshot(r.Context(), vars["agent_id"], vars["revision"])
+		if err != nil {
+			writeSnapshotError(w, deps, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(snapshot)
+	}
+}
+
+// adminSnapshotDiffHandler reports added/removed/changed monitors between two
+// revisions. "to" defaults to the latest revision and "from" to the one before it.
+func adminSnapshotDiffHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		agentID := mux.Vars(r)["agent_id"]
+		query := r.URL.Query()
+
+		to, err := deps.Store.GetMonitorSnapshot(r.Context(), agentID, query.Get("to"))
+		if err != nil {
+			writeSnapshotError(w, deps, err)
+			return
+		}
+		fromRevision := query.Get("from")
+		if fromRevision == "" {
+			fromRevision = store.PreviousRevision(to.Revision)
+		}
+		from := store.MonitorSnapshot{AgentID: agentID}
+		if fromRevision != "" {
+			from, err = deps.Store.GetMonitorSnapshot(r.Context(), agentID, fromRevision)
+			if err != nil {
+				writeSnapshotError(w, deps, err)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(store.DiffMonitorSnapshots(from, to))
+	}
+}
+
+// adminApplyBundleHandler lints a YAML (or JSON) monitor bundle and publishes the
+// resulting snapshots for every named agent in a single transaction. Catalog
+// monitors of those agents are kept.
+func adminApplyBundleHandler(cfg Config, deps Dependencies, hub *snapshotHub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleBytes+1))
+		if err != nil {
+			http.Error(w, "unable to read body", http.StatusBadRequest)
+			return
+		}
+		if len(data) > maxBundleBytes {
+			http.Error(w, "bundle too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		b, err := bundle.Parse(data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if problems := bundle.Lint(b); len(problems) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			_ = json.NewEncoder(w).Encode(struct {
+				Problems []bundle.Problem `json:"problems"`
+			}{Problems: problems})
+			return
+		}
+
+		snapshots := bundle.Snapshots(b)
+		if err := mergeOwnedMonitors(r.Context(), deps, snapshots, false); err != nil {
+			deps.Logger.Printf("load monitor snapshots for bundle failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		applied, err := deps.Store.ApplyMonitorSnapshots(r.Context(), snapshots)
+		if err != nil {
+			deps.Logger.Printf("apply monitor bundle failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		items := make([]agentRevision, 0, len(applied))
+		for agentID, snapshot := range applied {
+			hub.notify(agentID)
+			items = append(items, agentRevision{AgentID: agentID, Revision: snapshot.Revision})
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].AgentID < items[j].AgentID })
+		deps.Logger.Printf("admin bundle applied: agents=%d monitors=%d", len(items), len(b.Monitors))
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(struct {
+			Agents []agentRevision `json:"agents"`
+		}{Agents: items})
+	}
+}
+
+func writeSnapshotError(w http.ResponseWriter, deps Dependencies, err error) {
+	if errors.Is(err, store.ErrSnapshotNotFound) {
+		http.Error(w, "snapshot not found", http.StatusNotFound)
+		return
+	}
+	deps.Logger.Printf("fetch monitor snapshot failed: %v", err)
+	http.Error(w, "internal error", http.StatusInternalServerError)
+}
+
 func artifactDownloadHandler(cfg Config, deps Dependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if deps.ArtifactStore == nil {
@@ -388,30 +831,61 @@ func buildArtifactURL(cfg Config, r *http.Request, artifactName string) string {
 	return fmt.Sprintf("%s%s/%s", strings.TrimRight(base, "/"), pathPrefix, artifactName)
 }
 
-func extractAgentID(r *http.Request, mode string) (string, error) {
-	switch strings.ToLower(mode) {
-	case "mtls":
-		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
-			return "", errors.New("client certificate required")
+// requestAgentID returns the agent identified by the route's auth middleware.
+func requestAgentID(r *http.Request) string {
+	p, _ := auth.FromContext(r.Context())
+	return p.Subject
+}
+
+// agentAuthModes are the entries cfg.AgentAuthMode may list.
+var agentAuthModes = []string{"mtls", "header"}
+
+// ValidateAgentAuthMode rejects agent auth mode lists naming an unknown mode.
+func ValidateAgentAuthMode(raw string) error {
+	for _, mode := range strings.Split(raw, ",") {
+		mode = strings.ToLower(strings.TrimSpace(mode))
+		if mode != "" && !slices.Contains(agentAuthModes, mode) {
+			return fmt.Errorf("unknown mode %q (valid modes: %s)", mode, strings.Join(agentAuthModes, ", "))
 		}
-		return r.TLS.PeerCertificates[0].Subject.CommonName, nil
-	default:
-		id := r.Header.Get("X-Agent-ID")
-		if strings.TrimSpace(id) == "" {
-			return "", errors.New("missing X-Agent-ID header")
+	}
+	return nil
+}
+
+// AgentAuthenticator builds the agent authentication chain from
+// cfg.AgentAuthMode, a comma-separated list of "mtls" and "header" tried in
+// order. Unknown entries are skipped; see ValidateAgentAuthMode.
+func AgentAuthenticator(cfg Config, deps Dependencies) auth.Authenticator {
+	var chain auth.Chain
+	for _, mode := range strings.Split(cfg.AgentAuthMode, ",") {
+		switch strings.ToLower(strings.TrimSpace(mode)) {
+		case "mtls":
+			chain = append(chain, agentCertAuthenticator(cfg, deps))
+		case "header", "":
+			chain = append(chain, auth.AgentHeader{})
 		}
-		return id, nil
 	}
+	return chain
 }
 
-func authorizeAdmin(r *http.Request, token string) bool {
-	if strings.TrimSpace(token) == "" {
-		return false
+// agentCertAuthenticator identifies agents by their client certificates.
+// Revocation warnings go to deps.Logger.
+func agentCertAuthenticator(cfg Config, deps Dependencies) auth.ClientCert {
+	// Unknown revocation modes fail closed as hard; cmd/controller rejects them.
+	checker := &revocation.Checker{
+		Mode:     revocation.Mode(strings.ToLower(strings.TrimSpace(cfg.AgentCertRevocation))),
+		CRLFiles: cfg.AgentCRLFiles,
+		Logger:   deps.Logger,
 	}
-	const prefix = "Bearer "
-	value := r.Header.Get("Authorization")
-	if !strings.HasPrefix(value, prefix) {
-		return false
+	return auth.ClientCert{SPIFFETrustDomain: cfg.SPIFFETrustDomain, SPIFFEAgentPrefix: cfg.SPIFFEAgentPrefix, Revocation: checker}
+}
+
+// AdminAuthenticator builds the admin authentication chain: the static bearer
+// token, then API keys created through the admin API, then the named API keys
+// from Config.
+func AdminAuthenticator(cfg Config, deps Dependencies) auth.Authenticator {
+	return auth.Chain{
+		auth.StaticBearer{Token: cfg.AdminBearerToken},
+		auth.HashedKeys{Lookup: adminKeyLookup(deps)},
+		auth.APIKeys{Keys: cfg.AdminAPIKeys},
 	}
-	return strings.TrimSpace(strings.TrimPrefix(value, prefix)) == token
 }
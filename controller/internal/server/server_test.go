@@ -3,14 +3,17 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/pingsantohq/controller/internal/artifacts"
+	"github.com/pingsantohq/controller/internal/auth"
 	"github.com/pingsantohq/controller/internal/store"
 )
 
@@ -246,3 +249,353 @@ func TestAgentPlanIncludesUploadedArtifact(t *testing.T) {
 		t.Fatalf("unexpected version: %s", planPayload.Artifact.Version)
 	}
 }
+
+func TestAdminPlanDeltas(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	upsert := func(delta string) int {
+		body := `{"agent_id":"agent-123","artifact":{"version":"2.0.0","url":"https://example.com/a.tar.gz","sha256":"abc","deltas":[` + delta + `]}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := upsert(`{"from_version":"1.9.0","format":"xdelta","url":"https://example.com/d","base_sha256":"def"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected unsupported format to be rejected, got %d", code)
+	}
+	if code := upsert(`{"from_version":"1.9.0","format":"zstd","url":"https://example.com/d","base_sha256":"def"}`); code != http.StatusOK {
+		t.Fatalf("expected zstd delta to be accepted, got %d", code)
+	}
+	if code := upsert(`{"from_version":"1.9.0","format":"bsdiff","url":"https://example.com/d"}`); code != http.StatusBadRequest {
+		t.Fatalf("expected missing base_sha256 to be rejected, got %d", code)
+	}
+	if code := upsert(`{"from_version":"1.9.0","format":"bsdiff","url":"https://example.com/d","base_sha256":"def"}`); code != http.StatusOK {
+		t.Fatalf("upsert status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan", nil)
+	req.Header.Set("X-Agent-ID", "agent-123")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	var plan store.UpgradePlanResponse
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if len(plan.Artifact.Deltas) != 1 || plan.Artifact.Deltas[0].FromVersion != "1.9.0" || plan.Artifact.Deltas[0].BaseSHA256 != "def" {
+		t.Fatalf("unexpected deltas %+v", plan.Artifact.Deltas)
+	}
+}
+
+func TestAdminPlanMirrors(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	upsert := func(mirrors string) int {
+		body := `{"agent_id":"agent-123","artifact":{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc","mirrors":` + mirrors + `}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := upsert(`[{"signature_url":"https://b.example.com/a.sig"}]`); code != http.StatusBadRequest {
+		t.Fatalf("expected mirror without url to be rejected, got %d", code)
+	}
+	if code := upsert(`[{"url":"https://b.example.com/a.tar.gz","signature_url":"https://b.example.com/a.sig"},{"url":"https://c.example.com/a.tar.gz"}]`); code != http.StatusOK {
+		t.Fatalf("upsert status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan", nil)
+	req.Header.Set("X-Agent-ID", "agent-123")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	var plan store.UpgradePlanResponse
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if len(plan.Artifact.Mirrors) != 2 || plan.Artifact.Mirrors[0].SignatureURL != "https://b.example.com/a.sig" || plan.Artifact.Mirrors[1].URL != "https://c.example.com/a.tar.gz" {
+		t.Fatalf("unexpected mirrors %+v", plan.Artifact.Mirrors)
+	}
+}
+
+func TestAdminPlanPlatforms(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	upsert := func(platforms string) int {
+		body := `{"agent_id":"agent-123","artifact":{"version":"2.0.0","url":"https://a.example.com/amd64.tar.gz","sha256":"abc","platforms":` + platforms + `}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	arm := `{"os":"linux","arch":"arm64","url":"https://a.example.com/arm64.tar.gz","sha256":"def","mirrors":[{"url":"https://b.example.com/arm64.tar.gz"}]}`
+	for name, platforms := range map[string]string{
+		"missing arch":   `[{"os":"linux","url":"https://a.example.com/x.tar.gz","sha256":"def"}]`,
+		"missing sha256": `[{"os":"linux","arch":"arm64","url":"https://a.example.com/x.tar.gz"}]`,
+		"duplicate":      `[` + arm + `,` + arm + `]`,
+		"bad mirror":     `[{"os":"linux","arch":"arm64","url":"https://a.example.com/x.tar.gz","sha256":"def","mirrors":[{}]}]`,
+	} {
+		if code := upsert(platforms); code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", name, code)
+		}
+	}
+	if code := upsert(`[` + arm + `]`); code != http.StatusOK {
+		t.Fatalf("upsert status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan", nil)
+	req.Header.Set("X-Agent-ID", "agent-123")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	var plan store.UpgradePlanResponse
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if len(plan.Artifact.Platforms) != 1 {
+		t.Fatalf("unexpected platforms %+v", plan.Artifact.Platforms)
+	}
+	if p := plan.Artifact.Platforms[0]; p.Arch != "arm64" || p.SHA256 != "def" || len(p.Mirrors) != 1 {
+		t.Fatalf("unexpected platform %+v", p)
+	}
+}
+
+func TestAdminPlanArtifactSize(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	upsert := func(artifact string) int {
+		body := `{"agent_id":"agent-123","artifact":` + artifact + `}`
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := upsert(`{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc","size":-1}`); code != http.StatusBadRequest {
+		t.Fatalf("negative size: expected 400, got %d", code)
+	}
+	if code := upsert(`{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc","size":1,"platforms":[{"os":"linux","arch":"arm64","url":"https://a.example.com/b.tar.gz","sha256":"def","size":-5}]}`); code != http.StatusBadRequest {
+		t.Fatalf("negative platform size: expected 400, got %d", code)
+	}
+	if code := upsert(`{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc","size":52428800,"platforms":[{"os":"linux","arch":"arm64","url":"https://a.example.com/b.tar.gz","sha256":"def","size":48234496}]}`); code != http.StatusOK {
+		t.Fatalf("upsert status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan", nil)
+	req.Header.Set("X-Agent-ID", "agent-123")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	var plan store.UpgradePlanResponse
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if plan.Artifact.Size != 52428800 || len(plan.Artifact.Platforms) != 1 || plan.Artifact.Platforms[0].Size != 48234496 {
+		t.Fatalf("unexpected artifact %+v", plan.Artifact)
+	}
+}
+
+func TestAdminPlanMaintenanceWindows(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	upsert := func(windows string) int {
+		body := `{"agent_id":"agent-123","artifact":{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"},"schedule":{"maintenance_windows":` + windows + `}}`
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	for _, windows := range []string{`["Sat"]`, `["Someday 02:00-04:00"]`, `["Sat 02:00-25:00"]`, `["Sat 02:00-02:00"]`} {
+		if code := upsert(windows); code != http.StatusBadRequest {
+			t.Fatalf("%s: expected 400, got %d", windows, code)
+		}
+	}
+	if code := upsert(`["Sat 02:00-04:00","Mon-Fri,Sun 22:00-01:00"]`); code != http.StatusOK {
+		t.Fatalf("upsert status %d", code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan", nil)
+	req.Header.Set("X-Agent-ID", "agent-123")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	var plan store.UpgradePlanResponse
+	if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil {
+		t.Fatalf("decode plan: %v", err)
+	}
+	if got := plan.Schedule.MaintenanceWindows; len(got) != 2 || got[0] != "Sat 02:00-04:00" {
+		t.Fatalf("unexpected maintenance windows %v", got)
+	}
+}
+
+func TestAdminPlanPrefetch(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	fetch := func(schedule string) store.Schedule {
+		t.Helper()
+		body := `{"agent_id":"agent-123","artifact":{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"},"schedule":` + schedule + `}`
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/upgrade/plan", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("upsert status %d", rr.Code)
+		}
+
+		req = httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan", nil)
+		req.Header.Set("X-Agent-ID", "agent-123")
+		rr = httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		var plan store.UpgradePlanResponse
+		if err := json.NewDecoder(rr.Body).Decode(&plan); err != nil {
+			t.Fatalf("decode plan: %v", err)
+		}
+		return plan.Schedule
+	}
+
+	if schedule := fetch(`{"maintenance_windows":["Sat 02:00-04:00"],"prefetch":true}`); !schedule.Prefetch {
+		t.Fatalf("expected prefetch to round-trip, got %+v", schedule)
+	}
+	if schedule := fetch(`{"maintenance_windows":["Sat 02:00-04:00"]}`); schedule.Prefetch {
+		t.Fatalf("expected prefetch to default to false, got %+v", schedule)
+	}
+}
+
+func TestAdminPlanRolloutPercent(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: store.NewMemoryStore()})
+	admin := func(path, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	const artifact = `"artifact":{"version":"2.0.0","url":"https://a.example.com/a.tar.gz","sha256":"abc"}`
+	if code := admin("/api/admin/v1/upgrade/plan", `{"agent_id":"agent-123",`+artifact+`,"rollout_percent":10}`); code != http.StatusBadRequest {
+		t.Fatalf("per-agent rollout: expected 400, got %d", code)
+	}
+	if code := admin("/api/admin/v1/upgrade/plan", `{"channel":"canary",`+artifact+`,"rollout_percent":101}`); code != http.StatusBadRequest {
+		t.Fatalf("rollout over 100: expected 400, got %d", code)
+	}
+	if code := admin("/api/admin/v1/upgrade/plan", `{"channel":"canary",`+artifact+`,"rollout_percent":0}`); code != http.StatusOK {
+		t.Fatalf("upsert status %d", code)
+	}
+
+	receiving := func() int {
+		n := 0
+		for i := 0; i < 200; i++ {
+			req := httptest.NewRequest(http.MethodGet, "/api/agent/v1/upgrade/plan?channel=canary", nil)
+			req.Header.Set("X-Agent-ID", fmt.Sprintf("agt_%d", i))
+			rr := httptest.NewRecorder()
+			srv.Handler.ServeHTTP(rr, req)
+			if rr.Code == http.StatusOK {
+				n++
+			} else if rr.Code != http.StatusNotFound {
+				t.Fatalf("agt_%d: status %d", i, rr.Code)
+			}
+		}
+		return n
+	}
+	if n := receiving(); n != 0 {
+		t.Fatalf("0%% rollout reached %d agents", n)
+	}
+	if code := admin("/api/admin/v1/upgrade/plan/channel:canary/rollout", `{"percent":25}`); code != http.StatusOK {
+		t.Fatalf("bump status %d", code)
+	}
+	if n := receiving(); n < 25 || n > 75 {
+		t.Fatalf("25%% rollout reached %d of 200 agents", n)
+	}
+	if code := admin("/api/admin/v1/upgrade/plan/channel:canary/rollout", `{"percent":100}`); code != http.StatusOK {
+		t.Fatalf("bump status %d", code)
+	}
+	if n := receiving(); n != 200 {
+		t.Fatalf("100%% rollout reached %d agents", n)
+	}
+	if code := admin("/api/admin/v1/upgrade/plan/channel:beta/rollout", `{"percent":50}`); code != http.StatusNotFound {
+		t.Fatalf("unknown plan: expected 404, got %d", code)
+	}
+	if code := admin("/api/admin/v1/upgrade/plan/agent-123/rollout", `{"percent":50}`); code != http.StatusBadRequest {
+		t.Fatalf("per-agent key: expected 400, got %d", code)
+	}
+}
+
+func TestAdminMonitorSnapshotDiff(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token"}
+	deps := Dependencies{
+		Logger: log.New(io.Discard, "", 0),
+		Store:  store.NewMemoryStore(),
+	}
+	srv := New(cfg, deps)
+
+	publish := func(body string) {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/v1/monitors/agent-123/snapshots", bytes.NewBufferString(body))
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("publish status %d: %s", rr.Code, rr.Body.String())
+		}
+	}
+	publish(`{"monitors":[{"monitor_id":"mon_a","protocol":"icmp","targets":["203.0.113.7"],"cadence_ms":3000}]}`)
+	publish(`{"monitors":[{"monitor_id":"mon_a","protocol":"icmp","targets":["203.0.113.9"],"cadence_ms":3000},{"monitor_id":"mon_b","protocol":"tcp","targets":["203.0.113.8:443"]}]}`)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/monitors/agent-123/diff", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rr := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("diff status %d", rr.Code)
+	}
+
+	var diff store.MonitorDiff
+	if err := json.NewDecoder(rr.Body).Decode(&diff); err != nil {
+		t.Fatalf("decode diff: %v", err)
+	}
+	if diff.FromRevision != "1" || diff.ToRevision != "2" {
+		t.Fatalf("unexpected revisions: %+v", diff)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].MonitorID != "mon_b" {
+		t.Fatalf("unexpected added: %+v", diff.Added)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Fields[0] != "targets" {
+		t.Fatalf("unexpected changed: %+v", diff.Changed)
+	}
+
+	missing := httptest.NewRequest(http.MethodGet, "/api/admin/v1/monitors/agent-123/diff?from=1&to=7", nil)
+	missing.Header.Set("Authorization", "Bearer token")
+	rr = httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rr, missing)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown revision, got %d", rr.Code)
+	}
+}
+
+func TestAdminRoutesAcceptNamedAPIKeys(t *testing.T) {
+	cfg := Config{AdminBearerToken: "token", AdminAPIKeys: []auth.APIKey{{Name: "ci", Key: "ci-key"}}}
+	srv := New(cfg, Dependencies{})
+
+	for key, want := range map[string]int{"ci-key": http.StatusOK, "nope": http.StatusUnauthorized} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/settings/notifications", nil)
+		req.Header.Set("X-API-Key", key)
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Fatalf("key %q: expected %d, got %d", key, want, rec.Code)
+		}
+	}
+
+	// Agent credentials never satisfy admin routes.
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/v1/settings/notifications", nil)
+	req.Header.Set("X-Agent-ID", "agent-1")
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("agent on admin route: expected 401, got %d", rec.Code)
+	}
+}
+
+func TestValidateAgentAuthMode(t *testing.T) {
+	for _, mode := range []string{"header", "mtls", " MTLS , header "} {
+		if err := ValidateAgentAuthMode(mode); err != nil {
+			t.Fatalf("%q: unexpected error %v", mode, err)
+		}
+	}
+	err := ValidateAgentAuthMode("mtls,token")
+	if err == nil || !strings.Contains(err.Error(), `"token"`) || !strings.Contains(err.Error(), "mtls, header") {
+		t.Fatalf("expected unknown mode to be rejected with the valid modes, got %v", err)
+	}
+}
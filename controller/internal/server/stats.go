@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+const (
+	defaultStatsInterval = time.Minute
+	defaultStatsWindow   = 24 * time.Hour
+	// defaultStatsRetention keeps a month of samples.
+	defaultStatsRetention = 30 * 24 * time.Hour
+	planRoute             = "/api/agent/v1/upgrade/plan"
+)
+
+// statsCollector counts controller load between samples and periodically
+// persists a capacity-planning sample combining those counters with the
+// store's fleet and storage figures.
+type statsCollector struct {
+	store         store.Store
+	logf          func(string, ...any)
+	interval      time.Duration
+	retention     time.Duration
+	artifactRoute string
+	now           func() time.Time
+
+	planPolls     atomic.Uint64
+	artifactBytes atomic.Uint64
+	results       atomic.Uint64
+
+	mu         sync.Mutex
+	lastSample time.Time
+}
+
+func newStatsCollector(cfg Config, deps Dependencies, artifactRoute string) *statsCollector {
+	interval := cfg.StatsInterval
+	if interval <= 0 {
+		interval = defaultStatsInterval
+	}
+	retention := cfg.StatsRetention
+	if retention <= 0 {
+		retention = defaultStatsRetention
+	}
+	return &statsCollector{
+		store:         deps.Store,
+		logf:          deps.Logger.Printf,
+		interval:      interval,
+		retention:     retention,
+		artifactRoute: artifactRoute,
+		now:           time.Now,
+		lastSample:    time.Now().UTC(),
+	}
+}
+
+// middleware counts plan polls and artifact bytes served. It relies on the
+// matched route template, so it must run as router middleware.
+func (c *statsCollector) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		tmpl, _ := route.GetPathTemplate()
+		switch tmpl {
+		case planRoute:
+			c.planPolls.Add(1)
+		case c.artifactRoute:
+			w = &countingResponseWriter{ResponseWriter: w, counter: &c.artifactBytes}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// addResults records ingested probe results.
+func (c *statsCollector) addResults(n int) {
+	if n > 0 {
+		c.results.Add(uint64(n))
+	}
+}
+
+// run samples on every interval until ctx is cancelled.
+func (c *statsCollector) run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.sample(ctx); err != nil {
+				c.logf("controller stats sample failed: %v", err)
+			}
+		}
+	}
+}
+
+func (c *statsCollector) sample(ctx context.Context) (store.StatsSample, error) {
+	fleet, err := c.store.FleetStats(ctx)
+	if err != nil {
+		return store.StatsSample{}, err
+	}
+
+	c.mu.Lock()
+	now := c.now().UTC()
+	elapsed := now.Sub(c.lastSample)
+	c.lastSample = now
+	c.mu.Unlock()
+
+	sample := store.StatsSample{
+		SampledAt:           now,
+		IntervalSeconds:     elapsed.Seconds(),
+		AgentsTotal:         fleet.AgentsTotal,
+		AgentsByVersion:     fleet.AgentsByVersion,
+		StorageBytes:        fleet.StorageBytes,
+		ResultsIngested:     c.results.Swap(0),
+		ArtifactBytesServed: c.artifactBytes.Swap(0),
+		PlanPolls:           c.planPolls.Swap(0),
+	}
+	if err := c.store.RecordStatsSample(ctx, sample); err != nil {
+		return store.StatsSample{}, err
+	}
+	if _, err := c.store.PruneStatsSamples(ctx, now.Add(-c.retention)); err != nil {
+		c.logf("controller stats retention failed: %v", err)
+	}
+	return sample, nil
+}
+
+type countingResponseWriter struct {
+	http.ResponseWriter
+	counter *atomic.Uint64
+}
+
+func (w *countingResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.counter.Add(uint64(n))
+	return n, err
+}
+
+// RunStats samples controller statistics until ctx is cancelled.
+func (s *Server) RunStats(ctx context.Context) {
+	s.stats.run(ctx)
+}
+
+type statsPoint struct {
+	SampledAt           time.Time      `json:"sampled_at"`
+	AgentsTotal         int            `json:"agents_total"`
+	AgentsByVersion     map[string]int `json:"agents_by_version"`
+	StorageBytes        int64          `json:"storage_bytes"`
+	ResultsPerSec       float64        `json:"results_per_sec"`
+	ArtifactBytesPerSec float64        `json:"artifact_bytes_per_sec"`
+	PlanPollsPerSec     float64        `json:"plan_polls_per_sec"`
+	IntervalSeconds     float64        `json:"interval_seconds"`
+	ResultsIngested     uint64         `json:"results_ingested"`
+	ArtifactBytesServed uint64         `json:"artifact_bytes_served"`
+	PlanPolls           uint64         `json:"plan_polls"`
+}
+
+type rateSummary struct {
+	Avg  float64 `json:"avg"`
+	Peak float64 `json:"peak"`
+}
+
+type statsSummary struct {
+	Samples                  int         `json:"samples"`
+	AgentsGrowthPerDay       float64     `json:"agents_growth_per_day"`
+	StorageGrowthBytesPerDay float64     `json:"storage_growth_bytes_per_day"`
+	ResultsPerSec            rateSummary `json:"results_per_sec"`
+	ArtifactBytesPerSec      rateSummary `json:"artifact_bytes_per_sec"`
+	PlanPollsPerSec          rateSummary `json:"plan_polls_per_sec"`
+}
+
+func perSecond(count uint64, seconds float64) float64 {
+	if seconds <= 0 {
+		return 0
+	}
+	return float64(count) / seconds
+}
+
+func summarizeStats(points []statsPoint) statsSummary {
+	summary := statsSummary{Samples: len(points)}
+	if len(points) == 0 {
+		return summary
+	}
+	var seconds float64
+	var results, artifactBytes, polls uint64
+	for _, p := range points {
+		seconds += p.IntervalSeconds
+		results += p.ResultsIngested
+		artifactBytes += p.ArtifactBytesServed
+		polls += p.PlanPolls
+		summary.ResultsPerSec.Peak = max(summary.ResultsPerSec.Peak, p.ResultsPerSec)
+		summary.ArtifactBytesPerSec.Peak = max(summary.ArtifactBytesPerSec.Peak, p.ArtifactBytesPerSec)
+		summary.PlanPollsPerSec.Peak = max(summary.PlanPollsPerSec.Peak, p.PlanPollsPerSec)
+	}
+	summary.ResultsPerSec.Avg = perSecond(results, seconds)
+	summary.ArtifactBytesPerSec.Avg = perSecond(artifactBytes, seconds)
+	summary.PlanPollsPerSec.Avg = perSecond(polls, seconds)
+
+	first, last := points[0], points[len(points)-1]
+	if days := last.SampledAt.Sub(first.SampledAt).Hours() / 24; days > 0 {
+		summary.AgentsGrowthPerDay = float64(last.AgentsTotal-first.AgentsTotal) / days
+		summary.StorageGrowthBytesPerDay = float64(last.StorageBytes-first.StorageBytes) / days
+	}
+	return summary
+}
+
+func adminStatsHandler(cfg Config, deps Dependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		window := defaultStatsWindow
+		if raw := r.URL.Query().Get("window"); raw != "" {
+			d, err := time.ParseDuration(raw)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid window", http.StatusBadRequest)
+				return
+			}
+			window = d
+		}
+		limit := 0
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+
+		now := time.Now().UTC()
+		samples, err := deps.Store.ListStatsSamples(r.Context(), now.Add(-window), limit)
+		if err != nil {
+			deps.Logger.Printf("list stats samples failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		current, err := deps.Store.FleetStats(r.Context())
+		if err != nil {
+			deps.Logger.Printf("fleet stats failed: %v", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		points := make([]statsPoint, 0, len(samples))
+		for _, s := range samples {
+			points = append(points, statsPoint{
+				SampledAt:           s.SampledAt,
+				AgentsTotal:         s.AgentsTotal,
+				AgentsByVersion:     s.AgentsByVersion,
+				StorageBytes:        s.StorageBytes,
+				ResultsPerSec:       perSecond(s.ResultsIngested, s.IntervalSeconds),
+				ArtifactBytesPerSec: perSecond(s.ArtifactBytesServed, s.IntervalSeconds),
+				PlanPollsPerSec:     perSecond(s.PlanPolls, s.IntervalSeconds),
+				IntervalSeconds:     s.IntervalSeconds,
+				ResultsIngested:     s.ResultsIngested,
+				ArtifactBytesServed: s.ArtifactBytesServed,
+				PlanPolls:           s.PlanPolls,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"generated_at": now,
+			"window":       window.String(),
+			"current":      current,
+			"summary":      summarizeStats(points),
+			"samples":      points,
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/artifacts"
+	"github.com/pingsantohq/controller/internal/store"
+)
+
+func TestAdminStatsReportsSampledLoad(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	artifactStore := artifacts.NewMemoryStore()
+	meta, err := artifactStore.Save(ctx, artifacts.SaveRequest{
+		Version:      "1.2.0",
+		Artifact:     strings.NewReader("0123456789"),
+		ArtifactName: "agent.tar.gz",
+	})
+	if err != nil {
+		t.Fatalf("save artifact: %v", err)
+	}
+	if err := st.RecordUpgradeReport(ctx, store.UpgradeReport{AgentID: "agent-1", CurrentVersion: "1.2.0", Status: "success", CompletedAt: time.Now()}); err != nil {
+		t.Fatalf("record report: %v", err)
+	}
+	if err := st.RecordHeartbeat(ctx, store.Heartbeat{AgentID: "agent-2", SentAt: time.Now()}); err != nil {
+		t.Fatalf("record heartbeat: %v", err)
+	}
+
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{
+		Logger:        log.New(io.Discard, "", 0),
+		Store:         st,
+		ArtifactStore: artifactStore,
+	})
+	ts := httptest.NewServer(srv.Handler)
+	defer ts.Close()
+
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/agent/v1/upgrade/plan", nil)
+		req.Header.Set("X-Agent-ID", "agent-1")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("plan: %v", err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := http.Get(ts.URL + "/artifacts/" + meta.ArtifactName)
+	if err != nil {
+		t.Fatalf("artifact: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	start := srv.stats.lastSample
+	srv.stats.now = func() time.Time { return start.Add(10 * time.Second) }
+	sample, err := srv.stats.sample(ctx)
+	if err != nil {
+		t.Fatalf("sample: %v", err)
+	}
+	if sample.PlanPolls != 3 || sample.ArtifactBytesServed != 10 {
+		t.Fatalf("unexpected counters %+v", sample)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/admin/v1/stats?window=1h", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stats status %d", resp.StatusCode)
+	}
+	var out struct {
+		Current store.FleetStats `json:"current"`
+		Summary statsSummary     `json:"summary"`
+		Samples []statsPoint     `json:"samples"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out.Current.AgentsTotal != 2 || out.Current.AgentsByVersion["1.2.0"] != 1 || out.Current.AgentsByVersion[store.UnknownAgentVersion] != 1 {
+		t.Fatalf("unexpected fleet stats %+v", out.Current)
+	}
+	if len(out.Samples) != 1 || out.Samples[0].PlanPollsPerSec != 0.3 || out.Samples[0].ArtifactBytesPerSec != 1 {
+		t.Fatalf("unexpected samples %+v", out.Samples)
+	}
+	if out.Summary.PlanPollsPerSec.Peak != 0.3 {
+		t.Fatalf("unexpected summary %+v", out.Summary)
+	}
+}
+
+func TestStatsSamplesExpireAfterRetention(t *testing.T) {
+	ctx := context.Background()
+	st := store.NewMemoryStore()
+	srv := New(Config{StatsRetention: time.Hour}, Dependencies{Logger: log.New(io.Discard, "", 0), Store: st})
+
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 30 * time.Minute, 90 * time.Minute} {
+		srv.stats.now = func() time.Time { return start.Add(offset) }
+		if _, err := srv.stats.sample(ctx); err != nil {
+			t.Fatalf("sample: %v", err)
+		}
+	}
+	samples, err := st.ListStatsSamples(ctx, time.Time{}, 0)
+	if err != nil {
+		t.Fatalf("list samples: %v", err)
+	}
+	if len(samples) != 2 || !samples[0].SampledAt.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("expected samples older than an hour to be pruned, got %+v", samples)
+	}
+}
+
+func TestAdminStatsRequiresAuth(t *testing.T) {
+	srv := New(Config{AdminBearerToken: "token"}, Dependencies{})
+	rec := httptest.NewRecorder()
+	srv.Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/admin/v1/stats", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+}
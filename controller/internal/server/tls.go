@@ -0,0 +1,52 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// TLSConfig builds the listener TLS configuration from cfg, or returns nil
+// when TLSCertFile is unset and the controller serves plain HTTP (e.g. behind
+// a terminating proxy). Agent client certificates are verified against every
+// bundle in AgentClientCAFiles, so during an agent CA rotation certificates
+// from the old and the new CA are both accepted. Client certificates are
+// optional at the TLS layer; routes still require the configured agent or
+// admin credentials.
+func TLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" && cfg.TLSKeyFile == "" {
+		if len(cfg.AgentClientCAFiles) > 0 {
+			return nil, errors.New("agent client CA files require a TLS certificate and key")
+		}
+		return nil, nil
+	}
+	if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+		return nil, errors.New("TLS certificate and key must be set together")
+	}
+	certificate, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load TLS certificate: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{certificate},
+	}
+	if len(cfg.AgentClientCAFiles) == 0 {
+		return tlsCfg, nil
+	}
+	pool := x509.NewCertPool()
+	for _, path := range cfg.AgentClientCAFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read agent client CA: %w", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("agent client CA %s contains no certificates", path)
+		}
+	}
+	tlsCfg.ClientCAs = pool
+	tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsCfg, nil
+}
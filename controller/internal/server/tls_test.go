@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTLSConfigAcceptsAgentsFromEveryClientCA(t *testing.T) {
+	dir := t.TempDir()
+	writePEM := func(name, kind string, der []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+		return path
+	}
+
+	oldCA, oldKey := testCA(t, "old agent CA")
+	newCA, newKey := testCA(t, "new agent CA")
+	strayCA, strayKey := testCA(t, "stray CA")
+	serverCert, serverKey := testLeaf(t, oldCA, oldKey, "controller", func(c *x509.Certificate) {
+		c.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+		c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	})
+	keyDER, _ := x509.MarshalECPrivateKey(serverKey)
+
+	cfg := Config{
+		AgentAuthMode:      "mtls",
+		TLSCertFile:        writePEM("server.crt", "CERTIFICATE", serverCert.Raw),
+		TLSKeyFile:         writePEM("server.key", "EC PRIVATE KEY", keyDER),
+		AgentClientCAFiles: []string{writePEM("old.pem", "CERTIFICATE", oldCA.Raw), writePEM("new.pem", "CERTIFICATE", newCA.Raw)},
+	}
+	tlsCfg, err := TLSConfig(cfg)
+	if err != nil {
+		t.Fatalf("TLSConfig: %v", err)
+	}
+	authn := AgentAuthenticator(cfg, Dependencies{})
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, err := authn.Authenticate(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		io.WriteString(w, p.Subject)
+	}))
+	srv.TLS = tlsCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(oldCA)
+	call := func(ca *x509.Certificate, caKey *ecdsa.PrivateKey, agentID string) (string, error) {
+		cert, key := testLeaf(t, ca, caKey, agentID, func(c *x509.Certificate) {
+			c.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		})
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}},
+		}}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("status %d: %s", resp.StatusCode, body)
+		}
+		return string(body), nil
+	}
+
+	for agentID, ca := range map[string]struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+	}{"agt_old": {oldCA, oldKey}, "agt_new": {newCA, newKey}} {
+		if got, err := call(ca.cert, ca.key, agentID); err != nil || got != agentID {
+			t.Fatalf("expected %s to authenticate, got %q, %v", agentID, got, err)
+		}
+	}
+	if _, err := call(strayCA, strayKey, "agt_stray"); err == nil {
+		t.Fatalf("expected certificate from an unlisted CA to be rejected")
+	}
+
+	if tlsCfg, err := TLSConfig(Config{}); err != nil || tlsCfg != nil {
+		t.Fatalf("expected plain HTTP without a certificate, got %v, %v", tlsCfg, err)
+	}
+	if _, err := TLSConfig(Config{AgentClientCAFiles: cfg.AgentClientCAFiles}); err == nil {
+		t.Fatalf("expected client CAs without a certificate to be rejected")
+	}
+}
+
+func testCA(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func testLeaf(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string, mutate func(*x509.Certificate)) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	mutate(tmpl)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pingsantohq/controller/internal/webhook"
+)
+
+func TestUpgradeReportsPublishWebhooks(t *testing.T) {
+	events := make(chan webhook.Event, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := webhook.Verify("s3cret", r.Header, body, time.Minute, time.Now()); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+		var ev webhook.Event
+		if err := json.Unmarshal(body, &ev); err != nil {
+			t.Errorf("decode: %v", err)
+		}
+		events <- ev
+	}))
+	defer receiver.Close()
+
+	cfg := Config{Webhooks: webhook.Config{URLs: []string{receiver.URL}, Secret: "s3cret"}}
+	srv := New(cfg, Dependencies{Logger: log.New(io.Discard, "", 0)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.RunWebhooks(ctx)
+
+	for _, status := range []string{"staged", "failed", "success"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/agent/v1/upgrade/report", strings.NewReader(`{"current_version":"1.2.0","channel":"stable","status":"`+status+`"}`))
+		req.Header.Set("X-Agent-ID", "agt_1")
+		rec := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusNoContent {
+			t.Fatalf("report %s: status %d", status, rec.Code)
+		}
+	}
+	for _, want := range []string{webhook.EventUpgradeFailed, webhook.EventUpgradeSucceeded} {
+		select {
+		case ev := <-events:
+			data, _ := ev.Data.(map[string]any)
+			if ev.Type != want || data["agent_id"] != "agt_1" {
+				t.Fatalf("expected %s for agt_1, got %+v", want, ev)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event delivered", want)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected event %+v", ev)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
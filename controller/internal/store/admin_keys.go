@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// AdminKey is a named, scoped admin API key. Only a hash of the secret is
+// stored; the secret itself is shown once, when the key is created.
+type AdminKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Role is the admin role the key was created with, if any; Scopes holds
+	// the scopes it expands to.
+	Role      string     `json:"role,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	CreatedBy string     `json:"created_by,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ErrAdminKeyNotFound signals an unknown key ID or hash.
+var ErrAdminKeyNotFound = errors.New("admin key not found")
+
+type adminKeyRecord struct {
+	AdminKey
+	hash string
+}
+
+func (m *memoryStore) CreateAdminKey(ctx context.Context, key AdminKey, hash string) (AdminKey, error) {
+	if hash == "" {
+		return AdminKey{}, errors.New("key hash required")
+	}
+	if strings.TrimSpace(key.Name) == "" {
+		return AdminKey{}, errors.New("name required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rec := range m.adminKeys {
+		if rec.hash == hash {
+			return AdminKey{}, errors.New("duplicate admin key")
+		}
+	}
+	m.adminKeySeq++
+	key.ID = fmt.Sprintf("key_%d", m.adminKeySeq)
+	key.Scopes = slices.Clone(key.Scopes)
+	key.CreatedAt = time.Now().UTC()
+	key.RevokedAt = nil
+	m.adminKeys = append(m.adminKeys, adminKeyRecord{AdminKey: key, hash: hash})
+	return key, nil
+}
+
+func (m *memoryStore) ListAdminKeys(ctx context.Context) ([]AdminKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]AdminKey, 0, len(m.adminKeys))
+	for i := len(m.adminKeys) - 1; i >= 0; i-- {
+		out = append(out, m.adminKeys[i].AdminKey)
+	}
+	return out, nil
+}
+
+func (m *memoryStore) RevokeAdminKey(ctx context.Context, id string) (AdminKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.adminKeys {
+		k := &m.adminKeys[i].AdminKey
+		if k.ID != id {
+			continue
+		}
+		if k.RevokedAt == nil {
+			now := time.Now().UTC()
+			k.RevokedAt = &now
+		}
+		return *k, nil
+	}
+	return AdminKey{}, ErrAdminKeyNotFound
+}
+
+func (m *memoryStore) LookupAdminKey(ctx context.Context, hash string) (AdminKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, rec := range m.adminKeys {
+		if rec.hash == hash {
+			return rec.AdminKey, nil
+		}
+	}
+	return AdminKey{}, ErrAdminKeyNotFound
+}
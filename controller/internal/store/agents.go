@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directive types understood by agents.
+const (
+	DirectiveRenewCertificate = "renew_certificate"
+)
+
+// Directive statuses.
+const (
+	DirectivePending     = "pending"
+	DirectiveDone        = "done"
+	DirectiveFailed      = "failed"
+	DirectiveUnsupported = "unsupported"
+)
+
+// Heartbeat is the periodic liveness payload posted by agents.
+type Heartbeat struct {
+	AgentID                  string            `json:"agent_id"`
+	SentAt                   time.Time         `json:"sent_at"`
+	ReceivedAt               time.Time         `json:"received_at"`
+	QueueDepth               int64             `json:"queue_depth"`
+	QueueDroppedTotal        uint64            `json:"queue_dropped_total"`
+	QueueSpilledTotal        uint64            `json:"queue_spilled_total"`
+	BackfillPendingBytes     int64             `json:"backfill_pending_bytes"`
+	BackfillReplayedBatches  uint64            `json:"backfill_replayed_batches_total"`
+	BackfillReplayedResults  uint64            `json:"backfill_replayed_results_total"`
+	BackfillReplayLagSeconds float64           `json:"backfill_replay_lag_seconds"`
+	BackfillReplayThroughput float64           `json:"backfill_replay_throughput"`
+	CertExpiresAt            *time.Time        `json:"cert_expires_at,omitempty"`
+	Labels                   map[string]string `json:"labels,omitempty"`
+	Ready                    *bool             `json:"ready,omitempty"`
+	ReadyReason              string            `json:"ready_reason,omitempty"`
+}
+
+// Directive is a controller-issued instruction delivered to an agent in its
+// heartbeat response and acknowledged once handled.
+type Directive struct {
+	ID          string         `json:"id"`
+	AgentID     string         `json:"agent_id"`
+	Type        string         `json:"type"`
+	Payload     map[string]any `json:"payload,omitempty"`
+	Status      string         `json:"status"`
+	Message     string         `json:"message,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+}
+
+// CertExpiry is a row in the fleet certificate expiry report.
+type CertExpiry struct {
+	AgentID         string     `json:"agent_id"`
+	CertExpiresAt   time.Time  `json:"cert_expires_at"`
+	LastHeartbeatAt time.Time  `json:"last_heartbeat_at"`
+	Renewal         *Directive `json:"renewal,omitempty"`
+}
+
+// ErrDirectiveNotFound signals an unknown directive ID for the agent.
+var ErrDirectiveNotFound = errors.New("directive not found")
+
+// ErrAgentNotFound signals that no heartbeat has been recorded for the agent.
+var ErrAgentNotFound = errors.New("agent not found")
+
+func validDirectiveResult(status string) bool {
+	switch status {
+	case DirectiveDone, DirectiveFailed, DirectiveUnsupported:
+		return true
+	}
+	return false
+}
+
+func (m *memoryStore) RecordHeartbeat(ctx context.Context, hb Heartbeat) error {
+	if strings.TrimSpace(hb.AgentID) == "" {
+		return errors.New("agent_id required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.agents[hb.AgentID] = hb
+	return nil
+}
+
+func (m *memoryStore) GetHeartbeat(ctx context.Context, agentID string) (Heartbeat, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hb, ok := m.agents[agentID]
+	if !ok {
+		return Heartbeat{}, ErrAgentNotFound
+	}
+	return hb, nil
+}
+
+func (m *memoryStore) ListCertExpiry(ctx context.Context, before time.Time, limit int) ([]CertExpiry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var rows []CertExpiry
+	for _, hb := range m.agents {
+		if hb.CertExpiresAt == nil {
+			continue
+		}
+		if !before.IsZero() && !hb.CertExpiresAt.Before(before) {
+			continue
+		}
+		row := CertExpiry{
+			AgentID:         hb.AgentID,
+			CertExpiresAt:   *hb.CertExpiresAt,
+			LastHeartbeatAt: hb.ReceivedAt,
+		}
+		if d, ok := m.latestDirectiveLocked(hb.AgentID, DirectiveRenewCertificate); ok {
+			row.Renewal = &d
+		}
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].CertExpiresAt.Equal(rows[j].CertExpiresAt) {
+			return rows[i].AgentID < rows[j].AgentID
+		}
+		return rows[i].CertExpiresAt.Before(rows[j].CertExpiresAt)
+	})
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+	}
+	return rows, nil
+}
+
+func (m *memoryStore) EnqueueDirectives(ctx context.Context, directiveType string, agentIDs []string, payload map[string]any) ([]Directive, error) {
+	if strings.TrimSpace(directiveType) == "" {
+		return nil, errors.New("directive type required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]Directive, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		if d, ok := m.latestDirectiveLocked(agentID, directiveType); ok && d.Status == DirectivePending {
+			out = append(out, d)
+			continue
+		}
+		m.directiveSeq++
+		d := Directive{
+			ID:        fmt.Sprintf("dir_%d", m.directiveSeq),
+			AgentID:   agentID,
+			Type:      directiveType,
+			Payload:   payload,
+			Status:    DirectivePending,
+			CreatedAt: time.Now().UTC(),
+		}
+		m.directives = append(m.directives, d)
+		out = append(out, d)
+	}
+	return out, nil
+}
+
+func (m *memoryStore) PendingDirectives(ctx context.Context, agentID string) ([]Directive, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []Directive
+	for _, d := range m.directives {
+		if d.AgentID == agentID && d.Status == DirectivePending {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryStore) ListDirectives(ctx context.Context, agentID string, limit int) ([]Directive, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []Directive
+	for i := len(m.directives) - 1; i >= 0; i-- {
+		if m.directives[i].AgentID != agentID {
+			continue
+		}
+		out = append(out, m.directives[i])
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryStore) CompleteDirective(ctx context.Context, agentID, id, status, message string) error {
+	if !validDirectiveResult(status) {
+		return fmt.Errorf("invalid directive status %q", status)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.directives {
+		if d.ID == id && d.AgentID == agentID {
+			now := time.Now().UTC()
+			m.directives[i].Status = status
+			m.directives[i].Message = message
+			m.directives[i].CompletedAt = &now
+			return nil
+		}
+	}
+	return ErrDirectiveNotFound
+}
+
+func (m *memoryStore) latestDirectiveLocked(agentID, directiveType string) (Directive, bool) {
+	for i := len(m.directives) - 1; i >= 0; i-- {
+		d := m.directives[i]
+		if d.AgentID == agentID && d.Type == directiveType {
+			return d, true
+		}
+	}
+	return Directive{}, false
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+)
+
+// AuditEntry records one admin mutation: who made it, when, against which
+// route, how it ended, and a digest of the payload sent.
+type AuditEntry struct {
+	// ID is assigned by the store and increases with every entry.
+	ID int64 `json:"id"`
+	// Actor is the credential name of the admin, Scheme how it authenticated.
+	Actor  string `json:"actor"`
+	Scheme string `json:"scheme,omitempty"`
+	Method string `json:"method"`
+	Path   string `json:"path"`
+	// Status is the HTTP status the request was answered with.
+	Status int `json:"status"`
+	// PayloadSHA256 is the hex SHA-256 of the request body, PayloadBytes its
+	// length.
+	PayloadSHA256 string    `json:"payload_sha256"`
+	PayloadBytes  int64     `json:"payload_bytes"`
+	RemoteAddr    string    `json:"remote_addr,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// AuditFilter selects audit entries. Empty fields match every entry; Since
+// and Until bound CreatedAt as [Since, Until).
+type AuditFilter struct {
+	Actor  string
+	Method string
+	// PathPrefix matches entries whose path starts with it.
+	PathPrefix string
+	Since      time.Time
+	Until      time.Time
+	// Before is the pagination cursor: only entries with a lower ID are
+	// returned, newest first, at most Limit of them.
+	Before int64
+	Limit  int
+}
+
+func (f AuditFilter) matches(e AuditEntry) bool {
+	switch {
+	case f.Actor != "" && e.Actor != f.Actor,
+		f.Method != "" && !strings.EqualFold(e.Method, f.Method),
+		f.PathPrefix != "" && !strings.HasPrefix(e.Path, f.PathPrefix),
+		!f.Since.IsZero() && e.CreatedAt.Before(f.Since),
+		!f.Until.IsZero() && !e.CreatedAt.Before(f.Until),
+		f.Before > 0 && e.ID >= f.Before:
+		return false
+	}
+	return true
+}
+
+func (m *memoryStore) RecordAuditEntry(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	if strings.TrimSpace(entry.Method) == "" || strings.TrimSpace(entry.Path) == "" {
+		return AuditEntry{}, errors.New("method and path required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auditSeq++
+	entry.ID = m.auditSeq
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+	m.audit = append(m.audit, entry)
+	return entry, nil
+}
+
+func (m *memoryStore) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []AuditEntry
+	for i := len(m.audit) - 1; i >= 0; i-- {
+		if !filter.matches(m.audit[i]) {
+			continue
+		}
+		out = append(out, m.audit[i])
+		if filter.Limit > 0 && len(out) == filter.Limit {
+			break
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CatalogMonitor is a monitor managed through the admin catalog API together
+// with the agents that run it: those named in Agents plus every agent whose
+// latest heartbeat labels include all of Selector.
+type CatalogMonitor struct {
+	MonitorAssignment
+	Agents    []string          `json:"agents,omitempty"`
+	Selector  map[string]string `json:"selector,omitempty"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// ErrMonitorNotFound signals an unknown catalog monitor ID.
+var ErrMonitorNotFound = errors.New("monitor not found")
+
+// ValidateCatalogMonitor checks a catalog monitor before it is saved.
+func ValidateCatalogMonitor(m CatalogMonitor) error {
+	if err := ValidateMonitors([]MonitorAssignment{m.MonitorAssignment}); err != nil {
+		return errors.New(strings.TrimPrefix(err.Error(), "monitors[0]: "))
+	}
+	if m.MonitorID != strings.TrimSpace(m.MonitorID) {
+		return errors.New("monitor_id must not contain surrounding whitespace")
+	}
+	for i, agentID := range m.Agents {
+		if strings.TrimSpace(agentID) == "" || strings.TrimSpace(agentID) != agentID {
+			return fmt.Errorf("agents[%d]: invalid agent_id %q", i, agentID)
+		}
+	}
+	for key := range m.Selector {
+		if strings.TrimSpace(key) == "" {
+			return errors.New("selector: label names must not be empty")
+		}
+	}
+	return nil
+}
+
+// Assigned reports whether the monitor is assigned to the agent with the
+// given heartbeat labels.
+func (m CatalogMonitor) Assigned(agentID string, labels map[string]string) bool {
+	for _, id := range m.Agents {
+		if id == agentID {
+			return true
+		}
+	}
+	if len(m.Selector) == 0 {
+		return false
+	}
+	for key, value := range m.Selector {
+		if got, ok := labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveCatalog expands the catalog into the catalog-owned monitors of every
+// agent it targets, marked with MonitorSourceCatalog. labels holds each known agent's latest heartbeat labels; agents
+// named explicitly are included whether or not they have reported.
+func ResolveCatalog(catalog []CatalogMonitor, labels map[string]map[string]string) map[string][]MonitorAssignment {
+	out := map[string][]MonitorAssignment{}
+	add := func(agentID string, m CatalogMonitor) {
+		for _, existing := range out[agentID] {
+			if existing.MonitorID == m.MonitorID {
+				return
+			}
+		}
+		assignment := m.MonitorAssignment
+		assignment.Source = MonitorSourceCatalog
+		out[agentID] = append(out[agentID], assignment)
+	}
+	for _, m := range catalog {
+		for _, agentID := range m.Agents {
+			add(agentID, m)
+		}
+		if len(m.Selector) == 0 {
+			continue
+		}
+		for agentID, agentLabels := range labels {
+			if m.Assigned(agentID, agentLabels) {
+				add(agentID, m)
+			}
+		}
+	}
+	for agentID := range out {
+		out[agentID] = normalizeMonitors(out[agentID])
+	}
+	return out
+}
+
+func normalizeCatalogMonitor(m CatalogMonitor) CatalogMonitor {
+	m.Targets = append([]string(nil), m.Targets...)
+	m.Source = ""
+	agents := append([]string(nil), m.Agents...)
+	sort.Strings(agents)
+	m.Agents = agents[:0]
+	for i, agentID := range agents {
+		if i == 0 || agentID != agents[i-1] {
+			m.Agents = append(m.Agents, agentID)
+		}
+	}
+	if len(m.Agents) == 0 {
+		m.Agents = nil
+	}
+	if len(m.Selector) == 0 {
+		m.Selector = nil
+	}
+	m.UpdatedAt = time.Now().UTC()
+	return m
+}
+
+func (m *memoryStore) ListCatalogMonitors(ctx context.Context) ([]CatalogMonitor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]CatalogMonitor, 0, len(m.catalog))
+	for _, mon := range m.catalog {
+		out = append(out, mon)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MonitorID < out[j].MonitorID })
+	return out, nil
+}
+
+func (m *memoryStore) GetCatalogMonitor(ctx context.Context, id string) (CatalogMonitor, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	mon, ok := m.catalog[id]
+	if !ok {
+		return CatalogMonitor{}, ErrMonitorNotFound
+	}
+	return mon, nil
+}
+
+func (m *memoryStore) SaveCatalogMonitor(ctx context.Context, mon CatalogMonitor) (CatalogMonitor, error) {
+	if err := ValidateCatalogMonitor(mon); err != nil {
+		return CatalogMonitor{}, err
+	}
+	mon = normalizeCatalogMonitor(mon)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.catalog[mon.MonitorID] = mon
+	return mon, nil
+}
+
+func (m *memoryStore) DeleteCatalogMonitor(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.catalog[id]; !ok {
+		return ErrMonitorNotFound
+	}
+	delete(m.catalog, id)
+	return nil
+}
+
+func (m *memoryStore) ListAgentLabels(ctx context.Context) (map[string]map[string]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make(map[string]map[string]string, len(m.agents))
+	for agentID, hb := range m.agents {
+		out[agentID] = hb.Labels
+	}
+	return out, nil
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Enrollment token statuses, derived from the token's timestamps.
+const (
+	EnrollmentTokenActive  = "active"
+	EnrollmentTokenUsed    = "used"
+	EnrollmentTokenRevoked = "revoked"
+	EnrollmentTokenExpired = "expired"
+)
+
+// EnrollmentToken is a single-use, time-limited credential that enrolls one
+// agent. Only a hash of the secret is stored; the secret itself is shown
+// once, when the token is minted.
+type EnrollmentToken struct {
+	ID string `json:"id"`
+	// AgentID, when set, restricts the token to enrolling (or renewing) that
+	// agent.
+	AgentID string `json:"agent_id,omitempty"`
+	// Labels are applied to the enrolled agent, overriding its own.
+	Labels    map[string]string `json:"labels,omitempty"`
+	Note      string            `json:"note,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ExpiresAt time.Time         `json:"expires_at"`
+	UsedAt    *time.Time        `json:"used_at,omitempty"`
+	UsedBy    string            `json:"used_by,omitempty"`
+	RevokedAt *time.Time        `json:"revoked_at,omitempty"`
+	Status    string            `json:"status"`
+	// CloudProvider, when set, requires the enrolling host to attest an
+	// instance identity from that provider (aws, gcp or azure), in one of
+	// CloudAccounts if any are listed.
+	CloudProvider string   `json:"cloud_provider,omitempty"`
+	CloudAccounts []string `json:"cloud_accounts,omitempty"`
+}
+
+// CloudIdentity is the verified cloud account an enrolling host runs in.
+type CloudIdentity struct {
+	Provider string
+	Account  string
+}
+
+// allows reports whether a token with this scope may be redeemed by id.
+func (t EnrollmentToken) allows(id CloudIdentity) bool {
+	if t.CloudProvider == "" {
+		return true
+	}
+	if id.Provider != t.CloudProvider {
+		return false
+	}
+	return len(t.CloudAccounts) == 0 || slices.Contains(t.CloudAccounts, id.Account)
+}
+
+// ErrEnrollmentTokenNotFound signals an unknown token ID.
+var ErrEnrollmentTokenNotFound = errors.New("enrollment token not found")
+
+// ErrEnrollmentTokenInvalid is returned when redeeming a token that does not
+// exist, has expired, was revoked, was already used, is scoped to another
+// agent or cloud account, or is unscoped and asked for an agent ID the caller
+// has not proven. The cases are deliberately indistinguishable to the caller.
+var ErrEnrollmentTokenInvalid = errors.New("invalid enrollment token")
+
+// ErrEnrollmentTokenUsed is returned when revoking a token that already
+// enrolled an agent.
+var ErrEnrollmentTokenUsed = errors.New("enrollment token already used")
+
+// HashEnrollmentToken returns the stored form of a token secret.
+func HashEnrollmentToken(secret string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewAgentID returns a fresh agent ID in the agt_<hex> form agents use.
+func NewAgentID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(fmt.Sprintf("read random: %v", err))
+	}
+	return "agt_" + hex.EncodeToString(b[:])
+}
+
+func enrollmentTokenStatus(t EnrollmentToken, now time.Time) string {
+	switch {
+	case t.UsedAt != nil:
+		return EnrollmentTokenUsed
+	case t.RevokedAt != nil:
+		return EnrollmentTokenRevoked
+	case !now.Before(t.ExpiresAt):
+		return EnrollmentTokenExpired
+	}
+	return EnrollmentTokenActive
+}
+
+type enrollmentTokenRecord struct {
+	EnrollmentToken
+	hash string
+}
+
+func (m *memoryStore) CreateEnrollmentToken(ctx context.Context, token EnrollmentToken, hash string) (EnrollmentToken, error) {
+	if hash == "" {
+		return EnrollmentToken{}, errors.New("token hash required")
+	}
+	if token.ExpiresAt.IsZero() {
+		return EnrollmentToken{}, errors.New("expires_at required")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, rec := range m.enrollmentTokens {
+		if rec.hash == hash {
+			return EnrollmentToken{}, errors.New("duplicate enrollment token")
+		}
+	}
+	m.enrollmentTokenSeq++
+	token.ID = fmt.Sprintf("enr_%d", m.enrollmentTokenSeq)
+	token.Labels = maps.Clone(token.Labels)
+	token.CloudAccounts = slices.Clone(token.CloudAccounts)
+	token.CreatedAt = time.Now().UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.UsedAt, token.UsedBy, token.RevokedAt = nil, "", nil
+	m.enrollmentTokens = append(m.enrollmentTokens, enrollmentTokenRecord{EnrollmentToken: token, hash: hash})
+	token.Status = enrollmentTokenStatus(token, token.CreatedAt)
+	return token, nil
+}
+
+func (m *memoryStore) ListEnrollmentTokens(ctx context.Context, status string, limit int) ([]EnrollmentToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := time.Now()
+	var out []EnrollmentToken
+	for i := len(m.enrollmentTokens) - 1; i >= 0; i-- {
+		t := m.enrollmentTokens[i].EnrollmentToken
+		t.Status = enrollmentTokenStatus(t, now)
+		if status != "" && t.Status != status {
+			continue
+		}
+		out = append(out, t)
+		if limit > 0 && len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func (m *memoryStore) RevokeEnrollmentToken(ctx context.Context, id string) (EnrollmentToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.enrollmentTokens {
+		t := &m.enrollmentTokens[i].EnrollmentToken
+		if t.ID != id {
+			continue
+		}
+		if t.UsedAt != nil {
+			return EnrollmentToken{}, ErrEnrollmentTokenUsed
+		}
+		if t.RevokedAt == nil {
+			now := time.Now().UTC()
+			t.RevokedAt = &now
+		}
+		out := *t
+		out.Status = EnrollmentTokenRevoked
+		return out, nil
+	}
+	return EnrollmentToken{}, ErrEnrollmentTokenNotFound
+}
+
+func (m *memoryStore) RedeemEnrollmentToken(ctx context.Context, hash, agentID string, proven bool, cloud CloudIdentity) (EnrollmentToken, error) {
+	agentID = strings.TrimSpace(agentID)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now().UTC()
+	for i := range m.enrollmentTokens {
+		rec := &m.enrollmentTokens[i]
+		if rec.hash != hash {
+			continue
+		}
+		t := &rec.EnrollmentToken
+		if enrollmentTokenStatus(*t, now) != EnrollmentTokenActive || !t.allows(cloud) {
+			return EnrollmentToken{}, ErrEnrollmentTokenInvalid
+		}
+		usedBy := t.AgentID
+		switch {
+		case usedBy != "" && agentID != "" && agentID != usedBy:
+			return EnrollmentToken{}, ErrEnrollmentTokenInvalid
+		case usedBy == "" && agentID != "" && !proven:
+			return EnrollmentToken{}, ErrEnrollmentTokenInvalid
+		case usedBy == "" && agentID != "":
+			usedBy = agentID
+		case usedBy == "":
+			usedBy = NewAgentID()
+		}
+		t.UsedAt, t.UsedBy = &now, usedBy
+		out := *t
+		out.Labels = maps.Clone(t.Labels)
+		out.Status = EnrollmentTokenUsed
+		return out, nil
+	}
+	return EnrollmentToken{}, ErrEnrollmentTokenInvalid
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HistoryFilter selects upgrade reports across the fleet. Empty fields match
+// every report; Since and Until bound CompletedAt as [Since, Until).
+type HistoryFilter struct {
+	AgentID string
+	Status  string
+	Version string
+	Channel string
+	Since   time.Time
+	Until   time.Time
+	// After is the keyset pagination cursor: only reports older than it are
+	// returned, newest first, at most Limit of them.
+	After *HistoryCursor
+	Limit int
+}
+
+// HistoryCursor is the position of a report in history order (CompletedAt
+// descending, then ID descending).
+type HistoryCursor struct {
+	CompletedAt time.Time
+	ID          string
+}
+
+// Cursor returns the position of r for resuming a history listing after it.
+func (r UpgradeReport) Cursor() HistoryCursor {
+	return HistoryCursor{CompletedAt: r.CompletedAt, ID: r.ID}
+}
+
+// String encodes the cursor as an opaque page token.
+func (c HistoryCursor) String() string {
+	raw := c.CompletedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseHistoryCursor decodes a page token produced by HistoryCursor.String.
+func ParseHistoryCursor(token string) (HistoryCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return HistoryCursor{}, errors.New("invalid cursor")
+	}
+	at, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return HistoryCursor{}, errors.New("invalid cursor")
+	}
+	completedAt, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return HistoryCursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	return HistoryCursor{CompletedAt: completedAt, ID: id}, nil
+}
+
+// before reports whether c sorts before other in history order, i.e. is newer.
+func (c HistoryCursor) before(other HistoryCursor) bool {
+	if !c.CompletedAt.Equal(other.CompletedAt) {
+		return c.CompletedAt.After(other.CompletedAt)
+	}
+	return c.ID > other.ID
+}
+
+func (f HistoryFilter) matches(r UpgradeReport) bool {
+	switch {
+	case f.AgentID != "" && r.AgentID != f.AgentID,
+		f.Status != "" && r.Status != f.Status,
+		f.Version != "" && r.CurrentVersion != f.Version,
+		f.Channel != "" && normalizeChannel(r.Channel) != normalizeChannel(f.Channel),
+		!f.Since.IsZero() && r.CompletedAt.Before(f.Since),
+		!f.Until.IsZero() && !r.CompletedAt.Before(f.Until),
+		f.After != nil && !f.After.before(r.Cursor()):
+		return false
+	}
+	return true
+}
+
+func (m *memoryStore) ListUpgradeHistory(ctx context.Context, filter HistoryFilter) ([]UpgradeReport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var results []UpgradeReport
+	for _, r := range m.reports {
+		if filter.matches(r) {
+			results = append(results, r)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Cursor().before(results[j].Cursor())
+	})
+	if filter.Limit > 0 && len(results) > filter.Limit {
+		results = results[:filter.Limit]
+	}
+	return results, nil
+}
@@ -0,0 +1,134 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Agent statuses in the inventory, derived from the latest heartbeat.
+const (
+	AgentStatusReady    = "ready"
+	AgentStatusNotReady = "not_ready"
+	// AgentStatusUnknown is a live agent that does not report readiness.
+	AgentStatusUnknown = "unknown"
+	// AgentStatusStale is an agent whose last heartbeat is too old to trust
+	// its readiness.
+	AgentStatusStale = "stale"
+)
+
+// ValidAgentStatus reports whether status is one of the AgentStatus values.
+func ValidAgentStatus(status string) bool {
+	switch status {
+	case AgentStatusReady, AgentStatusNotReady, AgentStatusUnknown, AgentStatusStale:
+		return true
+	}
+	return false
+}
+
+// AgentStatus derives an agent's inventory status from its latest heartbeat;
+// heartbeats received before staleBefore make the agent stale.
+func AgentStatus(hb Heartbeat, staleBefore time.Time) string {
+	switch {
+	case hb.ReceivedAt.Before(staleBefore):
+		return AgentStatusStale
+	case hb.Ready == nil:
+		return AgentStatusUnknown
+	case *hb.Ready:
+		return AgentStatusReady
+	default:
+		return AgentStatusNotReady
+	}
+}
+
+// AgentSummary is a row in the agent inventory. Version comes from the
+// agent's latest successful upgrade report and Channel from its latest report.
+type AgentSummary struct {
+	AgentID         string            `json:"agent_id"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	Version         string            `json:"version"`
+	Channel         string            `json:"channel,omitempty"`
+	LastHeartbeatAt time.Time         `json:"last_heartbeat_at"`
+	Status          string            `json:"status"`
+	ReadyReason     string            `json:"ready_reason,omitempty"`
+}
+
+// AgentFilter selects agents from the inventory. Empty fields match every
+// agent; Labels must all be present on the agent.
+type AgentFilter struct {
+	Labels  map[string]string
+	Version string
+	Channel string
+	Status  string
+	// StaleBefore is the heartbeat age cut-off for AgentStatusStale.
+	StaleBefore time.Time
+	// After is the pagination cursor: only agents with a greater ID are
+	// returned, in ID order, at most Limit of them.
+	After string
+	Limit int
+}
+
+func (m *memoryStore) ListAgents(ctx context.Context, filter AgentFilter) ([]AgentSummary, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type reportInfo struct {
+		version, channel     string
+		versionAt, channelAt time.Time
+	}
+	reports := map[string]reportInfo{}
+	for _, r := range m.reports {
+		info := reports[r.AgentID]
+		if info.channelAt.IsZero() || !info.channelAt.After(r.CompletedAt) {
+			info.channel, info.channelAt = r.Channel, r.CompletedAt
+		}
+		if r.Status == "success" && r.CurrentVersion != "" && (info.versionAt.IsZero() || !info.versionAt.After(r.CompletedAt)) {
+			info.version, info.versionAt = r.CurrentVersion, r.CompletedAt
+		}
+		reports[r.AgentID] = info
+	}
+
+	var out []AgentSummary
+	for agentID, hb := range m.agents {
+		if filter.After != "" && agentID <= filter.After {
+			continue
+		}
+		info := reports[agentID]
+		row := AgentSummary{
+			AgentID:         agentID,
+			Labels:          hb.Labels,
+			Version:         defaultString(info.version, UnknownAgentVersion),
+			Channel:         info.channel,
+			LastHeartbeatAt: hb.ReceivedAt,
+			Status:          AgentStatus(hb, filter.StaleBefore),
+			ReadyReason:     hb.ReadyReason,
+		}
+		if !filter.matches(row) {
+			continue
+		}
+		out = append(out, row)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AgentID < out[j].AgentID })
+	if filter.Limit > 0 && len(out) > filter.Limit {
+		out = out[:filter.Limit]
+	}
+	return out, nil
+}
+
+func (f AgentFilter) matches(row AgentSummary) bool {
+	if f.Version != "" && row.Version != f.Version {
+		return false
+	}
+	if f.Channel != "" && row.Channel != f.Channel {
+		return false
+	}
+	if f.Status != "" && row.Status != f.Status {
+		return false
+	}
+	for key, value := range f.Labels {
+		if got, ok := row.Labels[key]; !ok || got != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,318 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MonitorAssignment mirrors the agent-facing monitor schema in agent/pkg/types/monitor.go.
+type MonitorAssignment struct {
+	MonitorID     string   `json:"monitor_id"`
+	Protocol      string   `json:"protocol"`
+	Targets       []string `json:"targets"`
+	CadenceMillis int      `json:"cadence_ms"`
+	TimeoutMillis int      `json:"timeout_ms"`
+	Configuration string   `json:"configuration"`
+	Disabled      bool     `json:"disabled"`
+	// Source is MonitorSourceCatalog for monitors the catalog publishes;
+	// bundles and direct publishes leave it empty. Each side replaces only
+	// its own monitors in an agent's snapshot.
+	Source string `json:"source,omitempty"`
+}
+
+// MonitorSourceCatalog marks catalog-owned monitors in a snapshot.
+const MonitorSourceCatalog = "catalog"
+
+// MergeMonitors returns current with the monitors owned by the catalog (or,
+// when catalog is false, by bundles and direct publishes) replaced by owned.
+// A directly published monitor shadows a catalog monitor with the same ID.
+func MergeMonitors(current, owned []MonitorAssignment, catalog bool) []MonitorAssignment {
+	source := ""
+	if catalog {
+		source = MonitorSourceCatalog
+	}
+	merged := make([]MonitorAssignment, 0, len(current)+len(owned))
+	for _, m := range current {
+		if (m.Source == MonitorSourceCatalog) != catalog {
+			merged = append(merged, m)
+		}
+	}
+	for _, m := range owned {
+		m.Source = source
+		merged = append(merged, m)
+	}
+	direct := map[string]bool{}
+	for _, m := range merged {
+		if m.Source != MonitorSourceCatalog {
+			direct[m.MonitorID] = true
+		}
+	}
+	out := merged[:0]
+	for _, m := range merged {
+		if m.Source == MonitorSourceCatalog && direct[m.MonitorID] {
+			continue
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// MonitorSnapshot is an immutable revision of the monitors assigned to an agent.
+type MonitorSnapshot struct {
+	AgentID     string              `json:"agent_id"`
+	Revision    string              `json:"revision"`
+	GeneratedAt time.Time           `json:"generated_at"`
+	Monitors    []MonitorAssignment `json:"monitors"`
+}
+
+// MonitorRevision summarises a stored snapshot without its monitor bodies.
+type MonitorRevision struct {
+	Revision     string    `json:"revision"`
+	GeneratedAt  time.Time `json:"generated_at"`
+	MonitorCount int       `json:"monitor_count"`
+}
+
+// MonitorDiff describes what changed between two snapshot revisions.
+type MonitorDiff struct {
+	AgentID      string              `json:"agent_id"`
+	FromRevision string              `json:"from_revision"`
+	ToRevision   string              `json:"to_revision"`
+	Added        []MonitorAssignment `json:"added"`
+	Removed      []MonitorAssignment `json:"removed"`
+	Changed      []MonitorChange     `json:"changed"`
+}
+
+// MonitorChange captures a monitor present in both revisions whose definition differs.
+type MonitorChange struct {
+	MonitorID string            `json:"monitor_id"`
+	Fields    []string          `json:"fields"`
+	Before    MonitorAssignment `json:"before"`
+	After     MonitorAssignment `json:"after"`
+}
+
+// ErrSnapshotNotFound signals the absence of the requested monitor snapshot revision.
+var ErrSnapshotNotFound = errors.New("monitor snapshot not found")
+
+// ValidateMonitors checks a monitor set before it is published as a snapshot.
+func ValidateMonitors(monitors []MonitorAssignment) error {
+	seen := make(map[string]struct{}, len(monitors))
+	for i, m := range monitors {
+		id := strings.TrimSpace(m.MonitorID)
+		if id == "" {
+			return fmt.Errorf("monitors[%d]: monitor_id required", i)
+		}
+		if _, ok := seen[id]; ok {
+			return fmt.Errorf("monitors[%d]: duplicate monitor_id %q", i, id)
+		}
+		seen[id] = struct{}{}
+		if strings.TrimSpace(m.Protocol) == "" {
+			return fmt.Errorf("monitors[%d]: protocol required", i)
+		}
+		if m.CadenceMillis < 0 || m.TimeoutMillis < 0 {
+			return fmt.Errorf("monitors[%d]: cadence_ms and timeout_ms must be non-negative", i)
+		}
+	}
+	return nil
+}
+
+// DiffMonitorSnapshots compares two snapshots keyed by monitor ID.
+func DiffMonitorSnapshots(from, to MonitorSnapshot) MonitorDiff {
+	diff := MonitorDiff{
+		AgentID:      defaultString(to.AgentID, from.AgentID),
+		FromRevision: from.Revision,
+		ToRevision:   to.Revision,
+		Added:        []MonitorAssignment{},
+		Removed:      []MonitorAssignment{},
+		Changed:      []MonitorChange{},
+	}
+	before := make(map[string]MonitorAssignment, len(from.Monitors))
+	for _, m := range from.Monitors {
+		before[m.MonitorID] = m
+	}
+	after := make(map[string]MonitorAssignment, len(to.Monitors))
+	for _, m := range to.Monitors {
+		after[m.MonitorID] = m
+		prev, ok := before[m.MonitorID]
+		if !ok {
+			diff.Added = append(diff.Added, m)
+			continue
+		}
+		if fields := changedMonitorFields(prev, m); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, MonitorChange{MonitorID: m.MonitorID, Fields: fields, Before: prev, After: m})
+		}
+	}
+	for _, m := range from.Monitors {
+		if _, ok := after[m.MonitorID]; !ok {
+			diff.Removed = append(diff.Removed, m)
+		}
+	}
+	return diff
+}
+
+func changedMonitorFields(a, b MonitorAssignment) []string {
+	var fields []string
+	if a.Protocol != b.Protocol {
+		fields = append(fields, "protocol")
+	}
+	if !reflect.DeepEqual(normalizeTargets(a.Targets), normalizeTargets(b.Targets)) {
+		fields = append(fields, "targets")
+	}
+	if a.CadenceMillis != b.CadenceMillis {
+		fields = append(fields, "cadence_ms")
+	}
+	if a.TimeoutMillis != b.TimeoutMillis {
+		fields = append(fields, "timeout_ms")
+	}
+	if a.Configuration != b.Configuration {
+		fields = append(fields, "configuration")
+	}
+	if a.Disabled != b.Disabled {
+		fields = append(fields, "disabled")
+	}
+	if a.Source != b.Source {
+		fields = append(fields, "source")
+	}
+	return fields
+}
+
+func normalizeTargets(targets []string) []string {
+	if len(targets) == 0 {
+		return nil
+	}
+	return targets
+}
+
+// normalizeMonitors returns a copy of monitors sorted by ID so revisions compare deterministically.
+func normalizeMonitors(monitors []MonitorAssignment) []MonitorAssignment {
+	out := make([]MonitorAssignment, len(monitors))
+	for i, m := range monitors {
+		m.MonitorID = strings.TrimSpace(m.MonitorID)
+		m.Targets = append([]string(nil), m.Targets...)
+		out[i] = m
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].MonitorID < out[j].MonitorID })
+	return out
+}
+
+func sameMonitors(a, b []MonitorAssignment) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].MonitorID != b[i].MonitorID || len(changedMonitorFields(a[i], b[i])) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// validateSnapshotSet checks every agent's monitors up front so a batch apply
+// either publishes all revisions or none.
+func validateSnapshotSet(snapshots map[string][]MonitorAssignment) error {
+	if len(snapshots) == 0 {
+		return errors.New("no agents to apply")
+	}
+	for agentID, monitors := range snapshots {
+		if strings.TrimSpace(agentID) == "" || strings.TrimSpace(agentID) != agentID {
+			return fmt.Errorf("invalid agent_id %q", agentID)
+		}
+		if err := ValidateMonitors(monitors); err != nil {
+			return fmt.Errorf("agent %s: %w", agentID, err)
+		}
+	}
+	return nil
+}
+
+// PreviousRevision returns the revision published immediately before rev, or "" for the first.
+func PreviousRevision(rev string) string {
+	n, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil || n <= 1 {
+		return ""
+	}
+	return strconv.FormatInt(n-1, 10)
+}
+
+func (m *memoryStore) PublishMonitorSnapshot(ctx context.Context, agentID string, monitors []MonitorAssignment) (MonitorSnapshot, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return MonitorSnapshot{}, errors.New("agent_id required")
+	}
+	if err := ValidateMonitors(monitors); err != nil {
+		return MonitorSnapshot{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.publishLocked(agentID, normalizeMonitors(monitors)), nil
+}
+
+func (m *memoryStore) ApplyMonitorSnapshots(ctx context.Context, snapshots map[string][]MonitorAssignment) (map[string]MonitorSnapshot, error) {
+	if err := validateSnapshotSet(snapshots); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	applied := make(map[string]MonitorSnapshot, len(snapshots))
+	for agentID, monitors := range snapshots {
+		applied[agentID] = m.publishLocked(agentID, normalizeMonitors(monitors))
+	}
+	return applied, nil
+}
+
+func (m *memoryStore) publishLocked(agentID string, normalized []MonitorAssignment) MonitorSnapshot {
+	history := m.snapshots[agentID]
+	if n := len(history); n > 0 && sameMonitors(history[n-1].Monitors, normalized) {
+		return history[n-1]
+	}
+	snapshot := MonitorSnapshot{
+		AgentID:     agentID,
+		Revision:    strconv.Itoa(len(history) + 1),
+		GeneratedAt: time.Now().UTC(),
+		Monitors:    normalized,
+	}
+	m.snapshots[agentID] = append(history, snapshot)
+	return snapshot
+}
+
+func (m *memoryStore) GetMonitorSnapshot(ctx context.Context, agentID string, revision string) (MonitorSnapshot, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history := m.snapshots[agentID]
+	if len(history) == 0 {
+		return MonitorSnapshot{}, ErrSnapshotNotFound
+	}
+	if strings.TrimSpace(revision) == "" {
+		return history[len(history)-1], nil
+	}
+	for _, snap := range history {
+		if snap.Revision == revision {
+			return snap, nil
+		}
+	}
+	return MonitorSnapshot{}, ErrSnapshotNotFound
+}
+
+func (m *memoryStore) ListMonitorRevisions(ctx context.Context, agentID string, limit int) ([]MonitorRevision, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	history := m.snapshots[agentID]
+	var results []MonitorRevision
+	for i := len(history) - 1; i >= 0; i-- {
+		results = append(results, MonitorRevision{
+			Revision:     history[i].Revision,
+			GeneratedAt:  history[i].GeneratedAt,
+			MonitorCount: len(history[i].Monitors),
+		})
+		if limit > 0 && len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ConfigOverlay is fleet-wide agent tuning delivered with every monitor
+// snapshot. It mirrors ConfigOverlay in agent/pkg/types/monitor.go; zero
+// fields leave the agent's local setting in place.
+type ConfigOverlay struct {
+	HeartbeatSec      int       `json:"heartbeat_sec,omitempty"`
+	QueueMemItemsCap  int       `json:"queue_mem_items_cap,omitempty"`
+	QueueDiskBytesCap string    `json:"queue_disk_bytes_cap,omitempty"`
+	LogLevel          string    `json:"log_level,omitempty"`
+	UpdatedAt         time.Time `json:"updated_at,omitempty"`
+}
+
+// IsZero reports whether the overlay sets no fields.
+func (o ConfigOverlay) IsZero() bool {
+	return o.HeartbeatSec == 0 && o.QueueMemItemsCap == 0 && o.QueueDiskBytesCap == "" && o.LogLevel == ""
+}
+
+// byteSizePattern matches the sizes the agent accepts for disk_bytes_cap,
+// e.g. 512MiB, 2GiB or a plain byte count.
+var byteSizePattern = regexp.MustCompile(`^\d+(\.\d+)?\s*([KMGT]I?B|B)?$`)
+
+// ValidateConfigOverlay checks an overlay before it is published to the fleet.
+func ValidateConfigOverlay(o ConfigOverlay) error {
+	if o.HeartbeatSec < 0 {
+		return fmt.Errorf("heartbeat_sec must be non-negative")
+	}
+	if o.QueueMemItemsCap < 0 {
+		return fmt.Errorf("queue_mem_items_cap must be non-negative")
+	}
+	if o.QueueDiskBytesCap != "" && !byteSizePattern.MatchString(strings.ToUpper(strings.TrimSpace(o.QueueDiskBytesCap))) {
+		return fmt.Errorf("queue_disk_bytes_cap %q is not a size; use e.g. 512MiB or 2GiB", o.QueueDiskBytesCap)
+	}
+	switch strings.ToLower(strings.TrimSpace(o.LogLevel)) {
+	case "", "debug", "info":
+	default:
+		return fmt.Errorf("log_level %q is not supported; use debug or info", o.LogLevel)
+	}
+	return nil
+}
+
+// normalizeConfigOverlay trims the string fields of a validated overlay.
+func normalizeConfigOverlay(o ConfigOverlay) ConfigOverlay {
+	o.QueueDiskBytesCap = strings.TrimSpace(o.QueueDiskBytesCap)
+	o.LogLevel = strings.ToLower(strings.TrimSpace(o.LogLevel))
+	return o
+}
+
+func (m *memoryStore) GetConfigOverlay(ctx context.Context) (ConfigOverlay, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.configOverlay, nil
+}
+
+func (m *memoryStore) UpdateConfigOverlay(ctx context.Context, overlay ConfigOverlay) (ConfigOverlay, error) {
+	if err := ValidateConfigOverlay(overlay); err != nil {
+		return ConfigOverlay{}, err
+	}
+	overlay = normalizeConfigOverlay(overlay)
+	overlay.UpdatedAt = time.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configOverlay = overlay
+	return overlay, nil
+}
@@ -0,0 +1,77 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// Plan kinds, told apart by the plan key: channel plans are stored under
+// "channel:<name>", per-agent plans under the agent ID.
+const (
+	PlanKindAgent   = "agent"
+	PlanKindChannel = "channel"
+)
+
+// PlanKind reports whether key names a channel or a per-agent plan.
+func PlanKind(key string) string {
+	if strings.HasPrefix(key, "channel:") {
+		return PlanKindChannel
+	}
+	return PlanKindAgent
+}
+
+// PlanFilter selects stored upgrade plans. Empty fields match every plan.
+type PlanFilter struct {
+	Kind    string
+	Channel string
+	Version string
+	Paused  *bool
+	// After is the pagination cursor: only plans with a greater key are
+	// returned, in key order, at most Limit of them.
+	After string
+	Limit int
+}
+
+func (f PlanFilter) matches(plan UpgradePlanResponse) bool {
+	if f.After != "" && plan.AgentID <= f.After {
+		return false
+	}
+	if f.Kind != "" && PlanKind(plan.AgentID) != f.Kind {
+		return false
+	}
+	if f.Channel != "" && normalizeChannel(plan.Channel) != normalizeChannel(f.Channel) {
+		return false
+	}
+	if f.Version != "" && plan.Artifact.Version != f.Version {
+		return false
+	}
+	return f.Paused == nil || plan.Paused == *f.Paused
+}
+
+func (m *memoryStore) ListUpgradePlans(ctx context.Context, filter PlanFilter) ([]UpgradePlanResponse, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []UpgradePlanResponse
+	for _, plan := range m.plans {
+		if filter.matches(plan) {
+			out = append(out, plan)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].AgentID < out[j].AgentID })
+	if filter.Limit > 0 && len(out) > filter.Limit {
+		out = out[:filter.Limit]
+	}
+	return out, nil
+}
+
+func (m *memoryStore) DeleteUpgradePlan(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.plans[key]; !ok {
+		return ErrPlanNotFound
+	}
+	delete(m.plans, key)
+	m.deletedPlans[key] = true
+	return nil
+}
@@ -5,6 +5,9 @@ import (
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -40,6 +43,12 @@ func (p *PostgresStore) Close() {
 	p.pool.Close()
 }
 
+// Pool exposes the connection pool so other stores, such as the results
+// store, can share it.
+func (p *PostgresStore) Pool() *pgxpool.Pool {
+	return p.pool
+}
+
 func (p *PostgresStore) FetchUpgradePlan(ctx context.Context, agentID string, channel string) (UpgradePlanResponse, string, error) {
 	if plan, etag, err := p.fetchPlanRecord(ctx, agentID); err == nil {
 		return plan, etag, nil
@@ -47,8 +56,11 @@ func (p *PostgresStore) FetchUpgradePlan(ctx context.Context, agentID string, ch
 		return UpgradePlanResponse{}, "", err
 	}
 
-	if key := channelPlanKey(channel); key != "" {
+	if key := ChannelPlanKey(channel); key != "" {
 		if plan, etag, err := p.fetchPlanRecord(ctx, key); err == nil {
+			if !InRollout(agentID, plan) {
+				return UpgradePlanResponse{}, "", ErrPlanNotFound
+			}
 			return plan, etag, nil
 		} else if err != nil && !errors.Is(err, ErrPlanNotFound) {
 			return UpgradePlanResponse{}, "", err
@@ -60,37 +72,70 @@ func (p *PostgresStore) FetchUpgradePlan(ctx context.Context, agentID string, ch
 
 func (p *PostgresStore) fetchPlanRecord(ctx context.Context, key string) (UpgradePlanResponse, string, error) {
 	const query = `
-SELECT agent_id, channel, version, artifact_url, artifact_sha256,
-       artifact_signature_url, force_apply, schedule_earliest, schedule_latest,
-       paused, notes, etag, updated_at
+SELECT ` + planColumns + `
   FROM agent_upgrade_plans
  WHERE agent_id = $1;
 `
-	row := p.pool.QueryRow(ctx, query, key)
+	plan, etag, err := scanPlan(p.pool.QueryRow(ctx, query, key))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
+	}
+	return plan, etag, err
+}
+
+// planColumns are the agent_upgrade_plans columns read by scanPlan.
+const planColumns = `agent_id, channel, version, artifact_url, artifact_sha256,
+       artifact_signature_url, force_apply, ignore_readiness, schedule_earliest, schedule_latest,
+       paused, notes, etag, updated_at, artifact_deltas, artifact_mirrors, artifact_platforms,
+       schedule_maintenance_windows, artifact_size, schedule_prefetch, rollout_percent`
+
+func scanPlan(row pgx.Row) (UpgradePlanResponse, string, error) {
 	var plan UpgradePlanResponse
 	var artifactURL, artifactSHA, signatureURL, etag, notes string
 	var scheduleEarliest, scheduleLatest *time.Time
 	var updatedAt time.Time
-	var forceApply, paused bool
+	var forceApply, ignoreReadiness, paused bool
 	var channelValue, version string
+	var deltasJSON, mirrorsJSON, platformsJSON []byte
+	var maintenanceWindows []string
+	var artifactSize int64
+	var prefetch bool
 	if err := row.Scan(&plan.AgentID, &channelValue, &version, &artifactURL, &artifactSHA, &signatureURL,
-		&forceApply, &scheduleEarliest, &scheduleLatest, &paused, &notes, &etag, &updatedAt); err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			return UpgradePlanResponse{}, "", ErrPlanNotFound
-		}
+		&forceApply, &ignoreReadiness, &scheduleEarliest, &scheduleLatest, &paused, &notes, &etag, &updatedAt, &deltasJSON, &mirrorsJSON, &platformsJSON,
+		&maintenanceWindows, &artifactSize, &prefetch, &plan.RolloutPercent); err != nil {
 		return UpgradePlanResponse{}, "", err
 	}
 
 	plan.Channel = channelValue
 	plan.GeneratedAt = updatedAt.UTC()
 	plan.Artifact = Artifact{
-		Version:      version,
-		URL:          artifactURL,
-		SHA256:       artifactSHA,
-		SignatureURL: signatureURL,
-		ForceApply:   forceApply,
+		Version:         version,
+		URL:             artifactURL,
+		SHA256:          artifactSHA,
+		SignatureURL:    signatureURL,
+		Size:            artifactSize,
+		ForceApply:      forceApply,
+		IgnoreReadiness: ignoreReadiness,
+	}
+	if len(deltasJSON) > 0 {
+		if err := json.Unmarshal(deltasJSON, &plan.Artifact.Deltas); err != nil {
+			return UpgradePlanResponse{}, "", fmt.Errorf("decode artifact deltas: %w", err)
+		}
+	}
+	if len(mirrorsJSON) > 0 {
+		if err := json.Unmarshal(mirrorsJSON, &plan.Artifact.Mirrors); err != nil {
+			return UpgradePlanResponse{}, "", fmt.Errorf("decode artifact mirrors: %w", err)
+		}
+	}
+	if len(platformsJSON) > 0 {
+		if err := json.Unmarshal(platformsJSON, &plan.Artifact.Platforms); err != nil {
+			return UpgradePlanResponse{}, "", fmt.Errorf("decode artifact platforms: %w", err)
+		}
+	}
+	plan.Schedule = Schedule{Earliest: scheduleEarliest, Latest: scheduleLatest, Prefetch: prefetch}
+	if len(maintenanceWindows) > 0 {
+		plan.Schedule.MaintenanceWindows = maintenanceWindows
 	}
-	plan.Schedule = Schedule{Earliest: scheduleEarliest, Latest: scheduleLatest}
 	plan.Paused = paused
 	plan.Notes = notes
 	return plan, etag, nil
@@ -130,36 +175,74 @@ func (p *PostgresStore) UpsertUpgradePlan(ctx context.Context, input PlanInput)
 		return UpgradePlanResponse{}, "", errors.New("version required")
 	}
 	channel := defaultString(input.Channel, "stable")
-	agentKey := strings.TrimSpace(input.AgentID)
-	if agentKey == "" {
-		agentKey = channelPlanKey(channel)
+	agentKey := input.Key()
+	rollout, err := planRolloutPercent(input, agentKey)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
 	}
 	plan := UpgradePlanResponse{
 		AgentID:     agentKey,
 		GeneratedAt: time.Now().UTC(),
 		Channel:     channel,
 		Artifact: Artifact{
-			Version:      input.Version,
-			URL:          input.ArtifactURL,
-			SHA256:       input.ArtifactSHA256,
-			SignatureURL: input.SignatureURL,
-			ForceApply:   input.ForceApply,
+			Version:         input.Version,
+			URL:             input.ArtifactURL,
+			SHA256:          input.ArtifactSHA256,
+			SignatureURL:    input.SignatureURL,
+			Size:            input.ArtifactSize,
+			ForceApply:      input.ForceApply,
+			IgnoreReadiness: input.IgnoreReadiness,
+			Deltas:          input.Deltas,
+			Mirrors:         input.Mirrors,
+			Platforms:       input.Platforms,
 		},
 		Schedule: Schedule{
-			Earliest: input.ScheduleEarliest,
-			Latest:   input.ScheduleLatest,
+			Earliest:           input.ScheduleEarliest,
+			Latest:             input.ScheduleLatest,
+			MaintenanceWindows: input.MaintenanceWindows,
+			Prefetch:           input.Prefetch,
 		},
-		Paused: input.Paused,
-		Notes:  input.Notes,
+		Paused:         input.Paused,
+		Notes:          input.Notes,
+		RolloutPercent: rollout,
 	}
 	etag := computeETag(plan)
+	deltas := plan.Artifact.Deltas
+	if deltas == nil {
+		deltas = []Delta{}
+	}
+	deltasJSON, err := json.Marshal(deltas)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	mirrors := plan.Artifact.Mirrors
+	if mirrors == nil {
+		mirrors = []Mirror{}
+	}
+	mirrorsJSON, err := json.Marshal(mirrors)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	platforms := plan.Artifact.Platforms
+	if platforms == nil {
+		platforms = []Platform{}
+	}
+	platformsJSON, err := json.Marshal(platforms)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	maintenanceWindows := plan.Schedule.MaintenanceWindows
+	if maintenanceWindows == nil {
+		maintenanceWindows = []string{}
+	}
 
 	const upsert = `
 INSERT INTO agent_upgrade_plans (
     agent_id, channel, version, artifact_url, artifact_sha256,
-    artifact_signature_url, force_apply, schedule_earliest, schedule_latest,
-    paused, notes, etag, updated_at
-) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,NOW())
+    artifact_signature_url, force_apply, ignore_readiness, schedule_earliest, schedule_latest,
+    paused, notes, etag, updated_at, artifact_deltas, artifact_mirrors, artifact_platforms,
+    schedule_maintenance_windows, artifact_size, schedule_prefetch, rollout_percent
+) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,NOW(),$14,$15,$16,$17,$18,$19,$20)
 ON CONFLICT (agent_id) DO UPDATE SET
     channel = EXCLUDED.channel,
     version = EXCLUDED.version,
@@ -167,14 +250,42 @@ ON CONFLICT (agent_id) DO UPDATE SET
     artifact_sha256 = EXCLUDED.artifact_sha256,
     artifact_signature_url = EXCLUDED.artifact_signature_url,
     force_apply = EXCLUDED.force_apply,
+    ignore_readiness = EXCLUDED.ignore_readiness,
     schedule_earliest = EXCLUDED.schedule_earliest,
     schedule_latest = EXCLUDED.schedule_latest,
     paused = EXCLUDED.paused,
     notes = EXCLUDED.notes,
     etag = EXCLUDED.etag,
-    updated_at = NOW();
+    updated_at = NOW(),
+    artifact_deltas = EXCLUDED.artifact_deltas,
+    artifact_mirrors = EXCLUDED.artifact_mirrors,
+    artifact_platforms = EXCLUDED.artifact_platforms,
+    schedule_maintenance_windows = EXCLUDED.schedule_maintenance_windows,
+    artifact_size = EXCLUDED.artifact_size,
+    schedule_prefetch = EXCLUDED.schedule_prefetch,
+    rollout_percent = EXCLUDED.rollout_percent;
 `
-	_, err := p.pool.Exec(ctx, upsert,
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	defer tx.Rollback(ctx)
+	if input.IfMatch != "" || input.IfAbsent {
+		// Serialise conditional writers per key; a row lock would not cover
+		// a plan that does not exist yet.
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "plan:"+plan.AgentID); err != nil {
+			return UpgradePlanResponse{}, "", err
+		}
+		var current string
+		err := tx.QueryRow(ctx, `SELECT etag FROM agent_upgrade_plans WHERE agent_id = $1`, plan.AgentID).Scan(&current)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return UpgradePlanResponse{}, "", err
+		}
+		if err := checkPlanPrecondition(input, err == nil, current); err != nil {
+			return UpgradePlanResponse{}, "", err
+		}
+	}
+	_, err = tx.Exec(ctx, upsert,
 		plan.AgentID,
 		plan.Channel,
 		plan.Artifact.Version,
@@ -182,31 +293,255 @@ ON CONFLICT (agent_id) DO UPDATE SET
 		plan.Artifact.SHA256,
 		plan.Artifact.SignatureURL,
 		plan.Artifact.ForceApply,
+		plan.Artifact.IgnoreReadiness,
 		plan.Schedule.Earliest,
 		plan.Schedule.Latest,
 		plan.Paused,
 		nullString(plan.Notes),
 		etag,
+		deltasJSON,
+		mirrorsJSON,
+		platformsJSON,
+		maintenanceWindows,
+		plan.Artifact.Size,
+		plan.Schedule.Prefetch,
+		plan.RolloutPercent,
 	)
 	if err != nil {
 		return UpgradePlanResponse{}, "", err
 	}
+	if err := tx.Commit(ctx); err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
 	return plan, etag, nil
 }
 
-func (p *PostgresStore) ListUpgradeHistory(ctx context.Context, agentID string, limit int) ([]UpgradeReport, error) {
-	if limit <= 0 {
-		limit = 50
+func (p *PostgresStore) SetPlanRollout(ctx context.Context, key string, percent int) (UpgradePlanResponse, string, error) {
+	if !strings.HasPrefix(key, "channel:") {
+		return UpgradePlanResponse{}, "", errors.New("rollout_percent applies only to channel plans")
+	}
+	if err := ValidateRolloutPercent(percent); err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	plan, _, err := p.fetchPlanRecord(ctx, key)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	plan.RolloutPercent = percent
+	plan.GeneratedAt = time.Now().UTC()
+	etag := computeETag(plan)
+	const update = `
+UPDATE agent_upgrade_plans
+   SET rollout_percent = $2, etag = $3, updated_at = $4
+ WHERE agent_id = $1;
+`
+	tag, err := p.pool.Exec(ctx, update, key, percent, etag, plan.GeneratedAt)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
+	}
+	return plan, etag, nil
+}
+
+func (p *PostgresStore) GetUpgradePlan(ctx context.Context, key string) (UpgradePlanResponse, string, error) {
+	return p.fetchPlanRecord(ctx, key)
+}
+
+func (p *PostgresStore) ListUpgradePlans(ctx context.Context, filter PlanFilter) ([]UpgradePlanResponse, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 500
+	}
+	var paused any
+	if filter.Paused != nil {
+		paused = *filter.Paused
+	}
+	const query = `
+SELECT ` + planColumns + `
+  FROM agent_upgrade_plans
+ WHERE agent_id > $1
+   AND ($2 = '' OR (agent_id LIKE 'channel:%') = ($2 = $3))
+   AND ($4 = '' OR lower(channel) = lower($4))
+   AND ($5 = '' OR version = $5)
+   AND ($6::boolean IS NULL OR paused = $6::boolean)
+ ORDER BY agent_id
+ LIMIT $7;
+`
+	rows, err := p.pool.Query(ctx, query, filter.After, filter.Kind, PlanKindChannel,
+		filter.Channel, filter.Version, paused, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []UpgradePlanResponse
+	for rows.Next() {
+		plan, _, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+	return plans, rows.Err()
+}
+
+func (p *PostgresStore) DeleteUpgradePlan(ctx context.Context, key string) error {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM agent_upgrade_plans WHERE agent_id = $1`, key)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrPlanNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) SetPlanPaused(ctx context.Context, key string, paused bool) (UpgradePlanResponse, string, error) {
+	plan, _, err := p.fetchPlanRecord(ctx, key)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	plan.Paused = paused
+	plan.GeneratedAt = time.Now().UTC()
+	etag := computeETag(plan)
+	const update = `
+UPDATE agent_upgrade_plans
+   SET paused = $2, etag = $3, updated_at = $4
+ WHERE agent_id = $1;
+`
+	tag, err := p.pool.Exec(ctx, update, key, paused, etag, plan.GeneratedAt)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
+	if tag.RowsAffected() == 0 {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
+	}
+	return plan, etag, nil
+}
+
+func (p *PostgresStore) SaveRollout(ctx context.Context, r Rollout) (Rollout, error) {
+	r.Channel = normalizeChannel(r.Channel)
+	if err := ValidateRollout(r); err != nil {
+		return Rollout{}, err
+	}
+	r.UpdatedAt = time.Now().UTC()
+	payload, err := json.Marshal(r)
+	if err != nil {
+		return Rollout{}, err
+	}
+	const upsert = `
+INSERT INTO upgrade_rollouts (channel, definition, updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (channel) DO UPDATE SET
+    definition = EXCLUDED.definition,
+    updated_at = EXCLUDED.updated_at;
+`
+	if _, err := p.pool.Exec(ctx, upsert, r.Channel, payload, r.UpdatedAt); err != nil {
+		return Rollout{}, err
+	}
+	return r, nil
+}
+
+func (p *PostgresStore) GetRollout(ctx context.Context, channel string) (Rollout, error) {
+	var raw []byte
+	if err := p.pool.QueryRow(ctx, `SELECT definition FROM upgrade_rollouts WHERE channel = $1`, normalizeChannel(channel)).Scan(&raw); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Rollout{}, ErrRolloutNotFound
+		}
+		return Rollout{}, err
+	}
+	var r Rollout
+	if err := json.Unmarshal(raw, &r); err != nil {
+		return Rollout{}, err
+	}
+	return r, nil
+}
+
+func (p *PostgresStore) ListRollouts(ctx context.Context) ([]Rollout, error) {
+	rows, err := p.pool.Query(ctx, `SELECT definition FROM upgrade_rollouts ORDER BY channel`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollouts := []Rollout{}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var r Rollout
+		if err := json.Unmarshal(raw, &r); err != nil {
+			return nil, err
+		}
+		rollouts = append(rollouts, r)
+	}
+	return rollouts, rows.Err()
+}
+
+func (p *PostgresStore) CountUpgradeOutcomes(ctx context.Context, channel, version string, since time.Time) (UpgradeOutcomes, error) {
+	const query = `
+SELECT status, COUNT(*)
+  FROM (SELECT DISTINCT ON (agent_id) status
+          FROM agent_upgrade_history
+         WHERE lower(channel) = $1 AND target_version = $2 AND completed_at >= $3
+           AND status IN ('success', 'failed')
+         ORDER BY agent_id, completed_at DESC) latest
+ GROUP BY status;
+`
+	rows, err := p.pool.Query(ctx, query, normalizeChannel(channel), version, since)
+	if err != nil {
+		return UpgradeOutcomes{}, err
+	}
+	defer rows.Close()
+
+	var out UpgradeOutcomes
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return UpgradeOutcomes{}, err
+		}
+		if status == "success" {
+			out.Succeeded = count
+		} else {
+			out.Failed = count
+		}
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) ListUpgradeHistory(ctx context.Context, filter HistoryFilter) ([]UpgradeReport, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 50
+	}
+	var since, until, afterAt, afterID any
+	if !filter.Since.IsZero() {
+		since = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until
+	}
+	if filter.After != nil {
+		afterAt, afterID = filter.After.CompletedAt, filter.After.ID
 	}
 	const query = `
-SELECT agent_id, channel, target_version, previous_version, status,
+SELECT id::text, agent_id, channel, target_version, previous_version, status,
        message, details, started_at, completed_at
   FROM agent_upgrade_history
- WHERE agent_id = $1
- ORDER BY completed_at DESC
- LIMIT $2;
+ WHERE ($1 = '' OR agent_id = $1)
+   AND ($2 = '' OR status = $2)
+   AND ($3 = '' OR target_version = $3)
+   AND ($4 = '' OR lower(channel) = lower($4))
+   AND ($5::timestamptz IS NULL OR completed_at >= $5::timestamptz)
+   AND ($6::timestamptz IS NULL OR completed_at < $6::timestamptz)
+   AND ($7::timestamptz IS NULL OR (completed_at, id) < ($7::timestamptz, $8::uuid))
+ ORDER BY completed_at DESC, id DESC
+ LIMIT $9;
 `
-	rows, err := p.pool.Query(ctx, query, agentID, limit)
+	rows, err := p.pool.Query(ctx, query, filter.AgentID, filter.Status, filter.Version, filter.Channel,
+		since, until, afterAt, afterID, filter.Limit)
 	if err != nil {
 		return nil, err
 	}
@@ -219,7 +554,7 @@ SELECT agent_id, channel, target_version, previous_version, status,
 		var prevVersion sql.NullString
 		var message sql.NullString
 		var detailsBytes []byte
-		if err := rows.Scan(&r.AgentID, &r.Channel, &targetVersion, &prevVersion, &r.Status, &message, &detailsBytes, &r.StartedAt, &r.CompletedAt); err != nil {
+		if err := rows.Scan(&r.ID, &r.AgentID, &r.Channel, &targetVersion, &prevVersion, &r.Status, &message, &detailsBytes, &r.StartedAt, &r.CompletedAt); err != nil {
 			return nil, err
 		}
 		r.CurrentVersion = targetVersion
@@ -277,3 +612,959 @@ RETURNING notify_on_publish, updated_at;
 	}
 	return settings, nil
 }
+
+func (p *PostgresStore) GetConfigOverlay(ctx context.Context) (ConfigOverlay, error) {
+	const query = `SELECT config_overlay FROM controller_settings WHERE id = TRUE`
+	var raw []byte
+	if err := p.pool.QueryRow(ctx, query).Scan(&raw); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ConfigOverlay{}, nil
+		}
+		return ConfigOverlay{}, err
+	}
+	var overlay ConfigOverlay
+	if err := json.Unmarshal(raw, &overlay); err != nil {
+		return ConfigOverlay{}, fmt.Errorf("decode config overlay: %w", err)
+	}
+	return overlay, nil
+}
+
+func (p *PostgresStore) UpdateConfigOverlay(ctx context.Context, overlay ConfigOverlay) (ConfigOverlay, error) {
+	if err := ValidateConfigOverlay(overlay); err != nil {
+		return ConfigOverlay{}, err
+	}
+	overlay = normalizeConfigOverlay(overlay)
+	overlay.UpdatedAt = time.Now().UTC()
+	payload, err := json.Marshal(overlay)
+	if err != nil {
+		return ConfigOverlay{}, err
+	}
+	const upsert = `
+INSERT INTO controller_settings (id, config_overlay, updated_at)
+VALUES (TRUE, $1, NOW())
+ON CONFLICT (id) DO UPDATE SET
+    config_overlay = EXCLUDED.config_overlay,
+    updated_at = NOW();
+`
+	if _, err := p.pool.Exec(ctx, upsert, payload); err != nil {
+		return ConfigOverlay{}, err
+	}
+	return overlay, nil
+}
+
+func (p *PostgresStore) PublishMonitorSnapshot(ctx context.Context, agentID string, monitors []MonitorAssignment) (MonitorSnapshot, error) {
+	agentID = strings.TrimSpace(agentID)
+	if agentID == "" {
+		return MonitorSnapshot{}, errors.New("agent_id required")
+	}
+	if err := ValidateMonitors(monitors); err != nil {
+		return MonitorSnapshot{}, err
+	}
+	applied, err := p.applySnapshots(ctx, map[string][]MonitorAssignment{agentID: monitors})
+	if err != nil {
+		return MonitorSnapshot{}, err
+	}
+	return applied[agentID], nil
+}
+
+func (p *PostgresStore) ApplyMonitorSnapshots(ctx context.Context, snapshots map[string][]MonitorAssignment) (map[string]MonitorSnapshot, error) {
+	if err := validateSnapshotSet(snapshots); err != nil {
+		return nil, err
+	}
+	return p.applySnapshots(ctx, snapshots)
+}
+
+func (p *PostgresStore) applySnapshots(ctx context.Context, snapshots map[string][]MonitorAssignment) (map[string]MonitorSnapshot, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock agents in a stable order so concurrent applies cannot deadlock.
+	agentIDs := make([]string, 0, len(snapshots))
+	for agentID := range snapshots {
+		agentIDs = append(agentIDs, agentID)
+	}
+	sort.Strings(agentIDs)
+
+	applied := make(map[string]MonitorSnapshot, len(snapshots))
+	for _, agentID := range agentIDs {
+		snapshot, err := publishSnapshotTx(ctx, tx, agentID, normalizeMonitors(snapshots[agentID]))
+		if err != nil {
+			return nil, err
+		}
+		applied[agentID] = snapshot
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func publishSnapshotTx(ctx context.Context, tx pgx.Tx, agentID string, normalized []MonitorAssignment) (MonitorSnapshot, error) {
+	payload, err := json.Marshal(normalized)
+	if err != nil {
+		return MonitorSnapshot{}, err
+	}
+
+	// Serialise publishers per agent so revision numbers stay contiguous.
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, agentID); err != nil {
+		return MonitorSnapshot{}, err
+	}
+
+	latest, err := scanMonitorSnapshot(tx.QueryRow(ctx, `
+SELECT agent_id, revision, monitors, generated_at
+  FROM monitor_snapshots
+ WHERE agent_id = $1
+ ORDER BY revision DESC
+ LIMIT 1;
+`, agentID))
+	if err != nil && !errors.Is(err, ErrSnapshotNotFound) {
+		return MonitorSnapshot{}, err
+	}
+	if err == nil && sameMonitors(latest.Monitors, normalized) {
+		return latest, nil
+	}
+
+	const insert = `
+INSERT INTO monitor_snapshots (agent_id, revision, monitors, generated_at)
+SELECT $1, COALESCE(MAX(revision), 0) + 1, $2, NOW()
+  FROM monitor_snapshots
+ WHERE agent_id = $1
+RETURNING agent_id, revision, monitors, generated_at;
+`
+	return scanMonitorSnapshot(tx.QueryRow(ctx, insert, agentID, payload))
+}
+
+func (p *PostgresStore) GetMonitorSnapshot(ctx context.Context, agentID string, revision string) (MonitorSnapshot, error) {
+	if strings.TrimSpace(revision) == "" {
+		const latest = `
+SELECT agent_id, revision, monitors, generated_at
+  FROM monitor_snapshots
+ WHERE agent_id = $1
+ ORDER BY revision DESC
+ LIMIT 1;
+`
+		return scanMonitorSnapshot(p.pool.QueryRow(ctx, latest, agentID))
+	}
+	rev, err := strconv.ParseInt(revision, 10, 64)
+	if err != nil {
+		return MonitorSnapshot{}, ErrSnapshotNotFound
+	}
+	const query = `
+SELECT agent_id, revision, monitors, generated_at
+  FROM monitor_snapshots
+ WHERE agent_id = $1 AND revision = $2;
+`
+	return scanMonitorSnapshot(p.pool.QueryRow(ctx, query, agentID, rev))
+}
+
+func (p *PostgresStore) ListMonitorRevisions(ctx context.Context, agentID string, limit int) ([]MonitorRevision, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	const query = `
+SELECT revision, jsonb_array_length(monitors), generated_at
+  FROM monitor_snapshots
+ WHERE agent_id = $1
+ ORDER BY revision DESC
+ LIMIT $2;
+`
+	rows, err := p.pool.Query(ctx, query, agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []MonitorRevision
+	for rows.Next() {
+		var rev int64
+		var item MonitorRevision
+		if err := rows.Scan(&rev, &item.MonitorCount, &item.GeneratedAt); err != nil {
+			return nil, err
+		}
+		item.Revision = strconv.FormatInt(rev, 10)
+		revisions = append(revisions, item)
+	}
+	return revisions, rows.Err()
+}
+
+func scanMonitorSnapshot(row pgx.Row) (MonitorSnapshot, error) {
+	var snapshot MonitorSnapshot
+	var rev int64
+	var monitorsJSON []byte
+	if err := row.Scan(&snapshot.AgentID, &rev, &monitorsJSON, &snapshot.GeneratedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return MonitorSnapshot{}, ErrSnapshotNotFound
+		}
+		return MonitorSnapshot{}, err
+	}
+	if err := json.Unmarshal(monitorsJSON, &snapshot.Monitors); err != nil {
+		return MonitorSnapshot{}, err
+	}
+	snapshot.Revision = strconv.FormatInt(rev, 10)
+	snapshot.GeneratedAt = snapshot.GeneratedAt.UTC()
+	return snapshot, nil
+}
+
+func (p *PostgresStore) ListCatalogMonitors(ctx context.Context) ([]CatalogMonitor, error) {
+	rows, err := p.pool.Query(ctx, `SELECT definition FROM monitor_catalog ORDER BY monitor_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	catalog := []CatalogMonitor{}
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var m CatalogMonitor
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return nil, err
+		}
+		catalog = append(catalog, m)
+	}
+	return catalog, rows.Err()
+}
+
+func (p *PostgresStore) GetCatalogMonitor(ctx context.Context, id string) (CatalogMonitor, error) {
+	var raw []byte
+	if err := p.pool.QueryRow(ctx, `SELECT definition FROM monitor_catalog WHERE monitor_id = $1`, id).Scan(&raw); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return CatalogMonitor{}, ErrMonitorNotFound
+		}
+		return CatalogMonitor{}, err
+	}
+	var m CatalogMonitor
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return CatalogMonitor{}, err
+	}
+	return m, nil
+}
+
+func (p *PostgresStore) SaveCatalogMonitor(ctx context.Context, m CatalogMonitor) (CatalogMonitor, error) {
+	if err := ValidateCatalogMonitor(m); err != nil {
+		return CatalogMonitor{}, err
+	}
+	m = normalizeCatalogMonitor(m)
+	payload, err := json.Marshal(m)
+	if err != nil {
+		return CatalogMonitor{}, err
+	}
+	const upsert = `
+INSERT INTO monitor_catalog (monitor_id, definition, updated_at)
+VALUES ($1, $2, $3)
+ON CONFLICT (monitor_id) DO UPDATE SET
+    definition = EXCLUDED.definition,
+    updated_at = EXCLUDED.updated_at;
+`
+	if _, err := p.pool.Exec(ctx, upsert, m.MonitorID, payload, m.UpdatedAt); err != nil {
+		return CatalogMonitor{}, err
+	}
+	return m, nil
+}
+
+func (p *PostgresStore) DeleteCatalogMonitor(ctx context.Context, id string) error {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM monitor_catalog WHERE monitor_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrMonitorNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) RecordHeartbeat(ctx context.Context, hb Heartbeat) error {
+	if strings.TrimSpace(hb.AgentID) == "" {
+		return errors.New("agent_id required")
+	}
+	payload, err := json.Marshal(hb)
+	if err != nil {
+		return err
+	}
+	const upsert = `
+INSERT INTO agents (agent_id, last_heartbeat_at, heartbeat, cert_expires_at, updated_at)
+VALUES ($1,$2,$3,$4,NOW())
+ON CONFLICT (agent_id) DO UPDATE SET
+    last_heartbeat_at = EXCLUDED.last_heartbeat_at,
+    heartbeat = EXCLUDED.heartbeat,
+    cert_expires_at = COALESCE(EXCLUDED.cert_expires_at, agents.cert_expires_at),
+    updated_at = NOW();
+`
+	_, err = p.pool.Exec(ctx, upsert, hb.AgentID, hb.ReceivedAt, payload, hb.CertExpiresAt)
+	return err
+}
+
+func (p *PostgresStore) GetHeartbeat(ctx context.Context, agentID string) (Heartbeat, error) {
+	const query = `SELECT heartbeat, cert_expires_at FROM agents WHERE agent_id = $1`
+	var payload []byte
+	var certExpiry *time.Time
+	if err := p.pool.QueryRow(ctx, query, agentID).Scan(&payload, &certExpiry); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return Heartbeat{}, ErrAgentNotFound
+		}
+		return Heartbeat{}, err
+	}
+	var hb Heartbeat
+	if err := json.Unmarshal(payload, &hb); err != nil {
+		return Heartbeat{}, err
+	}
+	if hb.CertExpiresAt == nil {
+		hb.CertExpiresAt = certExpiry
+	}
+	return hb, nil
+}
+
+func (p *PostgresStore) ListAgents(ctx context.Context, filter AgentFilter) ([]AgentSummary, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 500
+	}
+	var labels any
+	if len(filter.Labels) > 0 {
+		raw, err := json.Marshal(filter.Labels)
+		if err != nil {
+			return nil, err
+		}
+		labels = raw
+	}
+	const query = `
+WITH inventory AS (
+SELECT a.agent_id, a.heartbeat->'labels' AS labels, a.last_heartbeat_at,
+       COALESCE(v.target_version, $1) AS version, COALESCE(c.channel, '') AS channel,
+       CASE WHEN a.last_heartbeat_at < $2 THEN $3
+            WHEN a.heartbeat->>'ready' = 'true' THEN $4
+            WHEN a.heartbeat->>'ready' = 'false' THEN $5
+            ELSE $6 END AS status,
+       COALESCE(a.heartbeat->>'ready_reason', '') AS ready_reason
+  FROM agents a
+  LEFT JOIN LATERAL (
+        SELECT target_version
+          FROM agent_upgrade_history
+         WHERE agent_id = a.agent_id AND status = 'success'
+         ORDER BY completed_at DESC
+         LIMIT 1
+       ) v ON TRUE
+  LEFT JOIN LATERAL (
+        SELECT channel
+          FROM agent_upgrade_history
+         WHERE agent_id = a.agent_id
+         ORDER BY completed_at DESC
+         LIMIT 1
+       ) c ON TRUE
+ WHERE a.agent_id > $7
+)
+SELECT agent_id, labels, last_heartbeat_at, version, channel, status, ready_reason
+  FROM inventory
+ WHERE ($8::jsonb IS NULL OR labels @> $8::jsonb)
+   AND ($9 = '' OR version = $9)
+   AND ($10 = '' OR channel = $10)
+   AND ($11 = '' OR status = $11)
+ ORDER BY agent_id
+ LIMIT $12;
+`
+	rows, err := p.pool.Query(ctx, query, UnknownAgentVersion, filter.StaleBefore,
+		AgentStatusStale, AgentStatusReady, AgentStatusNotReady, AgentStatusUnknown,
+		filter.After, labels, filter.Version, filter.Channel, filter.Status, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var agents []AgentSummary
+	for rows.Next() {
+		var row AgentSummary
+		var rawLabels []byte
+		if err := rows.Scan(&row.AgentID, &rawLabels, &row.LastHeartbeatAt, &row.Version, &row.Channel, &row.Status, &row.ReadyReason); err != nil {
+			return nil, err
+		}
+		if len(rawLabels) > 0 {
+			if err := json.Unmarshal(rawLabels, &row.Labels); err != nil {
+				return nil, err
+			}
+		}
+		agents = append(agents, row)
+	}
+	return agents, rows.Err()
+}
+
+func (p *PostgresStore) ListAgentLabels(ctx context.Context) (map[string]map[string]string, error) {
+	rows, err := p.pool.Query(ctx, `SELECT agent_id, heartbeat->'labels' FROM agents`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := map[string]map[string]string{}
+	for rows.Next() {
+		var agentID string
+		var raw []byte
+		if err := rows.Scan(&agentID, &raw); err != nil {
+			return nil, err
+		}
+		var labels map[string]string
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &labels); err != nil {
+				return nil, err
+			}
+		}
+		out[agentID] = labels
+	}
+	return out, rows.Err()
+}
+
+func (p *PostgresStore) ListCertExpiry(ctx context.Context, before time.Time, limit int) ([]CertExpiry, error) {
+	if limit <= 0 {
+		limit = 500
+	}
+	var beforeArg any
+	if !before.IsZero() {
+		beforeArg = before
+	}
+	const query = `
+SELECT a.agent_id, a.cert_expires_at, a.last_heartbeat_at,
+       d.id::text, d.status, d.message, d.created_at, d.completed_at
+  FROM agents a
+  LEFT JOIN LATERAL (
+        SELECT id, status, message, created_at, completed_at
+          FROM agent_directives
+         WHERE agent_id = a.agent_id AND type = $1
+         ORDER BY created_at DESC
+         LIMIT 1
+       ) d ON TRUE
+ WHERE a.cert_expires_at IS NOT NULL
+   AND ($2::timestamptz IS NULL OR a.cert_expires_at < $2)
+ ORDER BY a.cert_expires_at ASC, a.agent_id ASC
+ LIMIT $3;
+`
+	rows, err := p.pool.Query(ctx, query, DirectiveRenewCertificate, beforeArg, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var report []CertExpiry
+	for rows.Next() {
+		var row CertExpiry
+		var dirID, dirStatus, dirMessage sql.NullString
+		var dirCreated sql.NullTime
+		var dirCompleted *time.Time
+		if err := rows.Scan(&row.AgentID, &row.CertExpiresAt, &row.LastHeartbeatAt, &dirID, &dirStatus, &dirMessage, &dirCreated, &dirCompleted); err != nil {
+			return nil, err
+		}
+		if dirID.Valid {
+			row.Renewal = &Directive{
+				ID:          dirID.String,
+				AgentID:     row.AgentID,
+				Type:        DirectiveRenewCertificate,
+				Status:      dirStatus.String,
+				Message:     dirMessage.String,
+				CreatedAt:   dirCreated.Time,
+				CompletedAt: dirCompleted,
+			}
+		}
+		report = append(report, row)
+	}
+	return report, rows.Err()
+}
+
+func (p *PostgresStore) EnqueueDirectives(ctx context.Context, directiveType string, agentIDs []string, payload map[string]any) ([]Directive, error) {
+	if strings.TrimSpace(directiveType) == "" {
+		return nil, errors.New("directive type required")
+	}
+	var payloadJSON []byte
+	if payload != nil {
+		var err error
+		if payloadJSON, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	const existing = `
+SELECT id::text, payload, created_at
+  FROM agent_directives
+ WHERE agent_id = $1 AND type = $2 AND status = 'pending'
+ ORDER BY created_at DESC
+ LIMIT 1;
+`
+	const insert = `
+INSERT INTO agent_directives (agent_id, type, payload, status)
+VALUES ($1,$2,$3,'pending')
+RETURNING id::text, created_at;
+`
+	out := make([]Directive, 0, len(agentIDs))
+	for _, agentID := range agentIDs {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, "directive:"+agentID); err != nil {
+			return nil, err
+		}
+		d := Directive{AgentID: agentID, Type: directiveType, Status: DirectivePending}
+		var existingPayload []byte
+		err := tx.QueryRow(ctx, existing, agentID, directiveType).Scan(&d.ID, &existingPayload, &d.CreatedAt)
+		switch {
+		case err == nil:
+			if len(existingPayload) > 0 {
+				_ = json.Unmarshal(existingPayload, &d.Payload)
+			}
+		case errors.Is(err, pgx.ErrNoRows):
+			if err := tx.QueryRow(ctx, insert, agentID, directiveType, payloadJSON).Scan(&d.ID, &d.CreatedAt); err != nil {
+				return nil, err
+			}
+			d.Payload = payload
+		default:
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (p *PostgresStore) PendingDirectives(ctx context.Context, agentID string) ([]Directive, error) {
+	const query = `
+SELECT id::text, type, payload, created_at
+  FROM agent_directives
+ WHERE agent_id = $1 AND status = 'pending'
+ ORDER BY created_at ASC;
+`
+	rows, err := p.pool.Query(ctx, query, agentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var directives []Directive
+	for rows.Next() {
+		d := Directive{AgentID: agentID, Status: DirectivePending}
+		var payload []byte
+		if err := rows.Scan(&d.ID, &d.Type, &payload, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(payload) > 0 {
+			_ = json.Unmarshal(payload, &d.Payload)
+		}
+		directives = append(directives, d)
+	}
+	return directives, rows.Err()
+}
+
+func (p *PostgresStore) ListDirectives(ctx context.Context, agentID string, limit int) ([]Directive, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+	const query = `
+SELECT id::text, type, payload, status, message, created_at, completed_at
+  FROM agent_directives
+ WHERE agent_id = $1
+ ORDER BY created_at DESC
+ LIMIT $2;
+`
+	rows, err := p.pool.Query(ctx, query, agentID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var directives []Directive
+	for rows.Next() {
+		d := Directive{AgentID: agentID}
+		var payload []byte
+		var message sql.NullString
+		if err := rows.Scan(&d.ID, &d.Type, &payload, &d.Status, &message, &d.CreatedAt, &d.CompletedAt); err != nil {
+			return nil, err
+		}
+		d.Message = message.String
+		if len(payload) > 0 {
+			_ = json.Unmarshal(payload, &d.Payload)
+		}
+		directives = append(directives, d)
+	}
+	return directives, rows.Err()
+}
+
+func (p *PostgresStore) CompleteDirective(ctx context.Context, agentID, id, status, message string) error {
+	if !validDirectiveResult(status) {
+		return fmt.Errorf("invalid directive status %q", status)
+	}
+	const update = `
+UPDATE agent_directives
+   SET status = $3, message = $4, completed_at = NOW()
+ WHERE id::text = $1 AND agent_id = $2;
+`
+	tag, err := p.pool.Exec(ctx, update, id, agentID, status, nullString(message))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrDirectiveNotFound
+	}
+	return nil
+}
+
+func (p *PostgresStore) FleetStats(ctx context.Context) (FleetStats, error) {
+	// target_version holds the report's current_version, as in the memory store.
+	const query = `
+SELECT COALESCE(h.target_version, $1), COUNT(*)
+  FROM (SELECT agent_id FROM agents
+        UNION
+        SELECT agent_id FROM agent_upgrade_history) a
+  LEFT JOIN LATERAL (
+        SELECT target_version
+          FROM agent_upgrade_history
+         WHERE agent_id = a.agent_id AND status = 'success' AND target_version <> ''
+         ORDER BY completed_at DESC
+         LIMIT 1
+       ) h ON TRUE
+ GROUP BY 1;
+`
+	rows, err := p.pool.Query(ctx, query, UnknownAgentVersion)
+	if err != nil {
+		return FleetStats{}, err
+	}
+	defer rows.Close()
+
+	stats := FleetStats{AgentsByVersion: map[string]int{}}
+	for rows.Next() {
+		var version string
+		var count int
+		if err := rows.Scan(&version, &count); err != nil {
+			return FleetStats{}, err
+		}
+		stats.AgentsByVersion[version] = count
+		stats.AgentsTotal += count
+	}
+	if err := rows.Err(); err != nil {
+		return FleetStats{}, err
+	}
+
+	if err := p.pool.QueryRow(ctx, `SELECT pg_database_size(current_database());`).Scan(&stats.StorageBytes); err != nil {
+		return FleetStats{}, err
+	}
+	return stats, nil
+}
+
+func (p *PostgresStore) RecordStatsSample(ctx context.Context, sample StatsSample) error {
+	payload, err := json.Marshal(sample)
+	if err != nil {
+		return err
+	}
+	_, err = p.pool.Exec(ctx, `
+INSERT INTO controller_stats_samples (sampled_at, sample)
+VALUES ($1, $2)
+ON CONFLICT (sampled_at) DO UPDATE SET sample = EXCLUDED.sample;
+`, sample.SampledAt, payload)
+	return err
+}
+
+func (p *PostgresStore) ListStatsSamples(ctx context.Context, since time.Time, limit int) ([]StatsSample, error) {
+	if limit <= 0 {
+		limit = 1440
+	}
+	var sinceArg any
+	if !since.IsZero() {
+		sinceArg = since
+	}
+	const query = `
+SELECT sample FROM (
+    SELECT sampled_at, sample
+      FROM controller_stats_samples
+     WHERE ($1::timestamptz IS NULL OR sampled_at >= $1)
+     ORDER BY sampled_at DESC
+     LIMIT $2
+) recent
+ORDER BY sampled_at ASC;
+`
+	rows, err := p.pool.Query(ctx, query, sinceArg, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var samples []StatsSample
+	for rows.Next() {
+		var raw []byte
+		if err := rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		var sample StatsSample
+		if err := json.Unmarshal(raw, &sample); err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+	return samples, rows.Err()
+}
+
+func (p *PostgresStore) PruneStatsSamples(ctx context.Context, before time.Time) (int, error) {
+	tag, err := p.pool.Exec(ctx, `DELETE FROM controller_stats_samples WHERE sampled_at < $1;`, before)
+	if err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
+const enrollmentTokenColumns = `id::text, agent_id, labels, note, created_at, expires_at, used_at, used_by, revoked_at, cloud_provider, cloud_accounts`
+
+func scanEnrollmentToken(row pgx.Row) (EnrollmentToken, error) {
+	var t EnrollmentToken
+	var labels []byte
+	var usedBy sql.NullString
+	if err := row.Scan(&t.ID, &t.AgentID, &labels, &t.Note, &t.CreatedAt, &t.ExpiresAt, &t.UsedAt, &usedBy, &t.RevokedAt, &t.CloudProvider, &t.CloudAccounts); err != nil {
+		return EnrollmentToken{}, err
+	}
+	t.UsedBy = usedBy.String
+	if len(labels) > 0 {
+		if err := json.Unmarshal(labels, &t.Labels); err != nil {
+			return EnrollmentToken{}, err
+		}
+	}
+	t.Status = enrollmentTokenStatus(t, time.Now())
+	return t, nil
+}
+
+func (p *PostgresStore) CreateEnrollmentToken(ctx context.Context, token EnrollmentToken, hash string) (EnrollmentToken, error) {
+	if hash == "" {
+		return EnrollmentToken{}, errors.New("token hash required")
+	}
+	if token.ExpiresAt.IsZero() {
+		return EnrollmentToken{}, errors.New("expires_at required")
+	}
+	var labels []byte
+	if len(token.Labels) > 0 {
+		var err error
+		if labels, err = json.Marshal(token.Labels); err != nil {
+			return EnrollmentToken{}, err
+		}
+	}
+	insert := `
+INSERT INTO enrollment_tokens (token_hash, agent_id, labels, note, expires_at, cloud_provider, cloud_accounts)
+VALUES ($1,$2,$3,$4,$5,$6,$7)
+RETURNING ` + enrollmentTokenColumns + `;
+`
+	accounts := token.CloudAccounts
+	if accounts == nil {
+		accounts = []string{}
+	}
+	return scanEnrollmentToken(p.pool.QueryRow(ctx, insert, hash, token.AgentID, labels, token.Note, token.ExpiresAt.UTC(), token.CloudProvider, accounts))
+}
+
+// enrollmentTokenStatusClauses select the tokens enrollmentTokenStatus puts
+// in each status.
+var enrollmentTokenStatusClauses = map[string]string{
+	EnrollmentTokenActive:  `used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()`,
+	EnrollmentTokenUsed:    `used_at IS NOT NULL`,
+	EnrollmentTokenRevoked: `used_at IS NULL AND revoked_at IS NOT NULL`,
+	EnrollmentTokenExpired: `used_at IS NULL AND revoked_at IS NULL AND expires_at <= NOW()`,
+}
+
+func (p *PostgresStore) ListEnrollmentTokens(ctx context.Context, status string, limit int) ([]EnrollmentToken, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	where := "TRUE"
+	if status != "" {
+		clause, ok := enrollmentTokenStatusClauses[status]
+		if !ok {
+			return nil, fmt.Errorf("unknown enrollment token status %q", status)
+		}
+		where = clause
+	}
+	query := `SELECT ` + enrollmentTokenColumns + ` FROM enrollment_tokens WHERE ` + where + ` ORDER BY created_at DESC LIMIT $1`
+	rows, err := p.pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []EnrollmentToken
+	for rows.Next() {
+		t, err := scanEnrollmentToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func (p *PostgresStore) RevokeEnrollmentToken(ctx context.Context, id string) (EnrollmentToken, error) {
+	update := `
+UPDATE enrollment_tokens
+   SET revoked_at = COALESCE(revoked_at, NOW())
+ WHERE id::text = $1 AND used_at IS NULL
+RETURNING ` + enrollmentTokenColumns + `;
+`
+	t, err := scanEnrollmentToken(p.pool.QueryRow(ctx, update, id))
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return t, err
+	}
+	var used bool
+	if err := p.pool.QueryRow(ctx, `SELECT used_at IS NOT NULL FROM enrollment_tokens WHERE id::text = $1`, id).Scan(&used); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return EnrollmentToken{}, ErrEnrollmentTokenNotFound
+		}
+		return EnrollmentToken{}, err
+	}
+	return EnrollmentToken{}, ErrEnrollmentTokenUsed
+}
+
+func (p *PostgresStore) RedeemEnrollmentToken(ctx context.Context, hash, agentID string, proven bool, cloud CloudIdentity) (EnrollmentToken, error) {
+	agentID = strings.TrimSpace(agentID)
+	// Unscoped tokens only take the requested ID when the caller proved it.
+	candidate := NewAgentID()
+	if proven && agentID != "" {
+		candidate = agentID
+	}
+	// The conditional UPDATE is the single-use guarantee: concurrent
+	// redemptions of one token cannot both match used_at IS NULL.
+	update := `
+UPDATE enrollment_tokens
+   SET used_at = NOW(), used_by = CASE WHEN agent_id <> '' THEN agent_id ELSE $2 END
+ WHERE token_hash = $1
+   AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+   AND ($3 = '' OR agent_id = $3 OR (agent_id = '' AND $6))
+   AND (cloud_provider = '' OR (cloud_provider = $4 AND (cardinality(cloud_accounts) = 0 OR $5 = ANY(cloud_accounts))))
+RETURNING ` + enrollmentTokenColumns + `;
+`
+	t, err := scanEnrollmentToken(p.pool.QueryRow(ctx, update, hash, candidate, agentID, cloud.Provider, cloud.Account, proven))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return EnrollmentToken{}, ErrEnrollmentTokenInvalid
+	}
+	return t, err
+}
+
+const adminKeyColumns = `id::text, name, role, scopes, created_by, created_at, revoked_at`
+
+func scanAdminKey(row pgx.Row) (AdminKey, error) {
+	var k AdminKey
+	if err := row.Scan(&k.ID, &k.Name, &k.Role, &k.Scopes, &k.CreatedBy, &k.CreatedAt, &k.RevokedAt); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return AdminKey{}, ErrAdminKeyNotFound
+		}
+		return AdminKey{}, err
+	}
+	return k, nil
+}
+
+func (p *PostgresStore) CreateAdminKey(ctx context.Context, key AdminKey, hash string) (AdminKey, error) {
+	if hash == "" {
+		return AdminKey{}, errors.New("key hash required")
+	}
+	if strings.TrimSpace(key.Name) == "" {
+		return AdminKey{}, errors.New("name required")
+	}
+	scopes := key.Scopes
+	if scopes == nil {
+		scopes = []string{}
+	}
+	insert := `
+INSERT INTO admin_keys (key_hash, name, role, scopes, created_by)
+VALUES ($1,$2,$3,$4,$5)
+RETURNING ` + adminKeyColumns + `;
+`
+	return scanAdminKey(p.pool.QueryRow(ctx, insert, hash, key.Name, key.Role, scopes, key.CreatedBy))
+}
+
+func (p *PostgresStore) ListAdminKeys(ctx context.Context) ([]AdminKey, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+adminKeyColumns+` FROM admin_keys ORDER BY created_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []AdminKey
+	for rows.Next() {
+		k, err := scanAdminKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (p *PostgresStore) RevokeAdminKey(ctx context.Context, id string) (AdminKey, error) {
+	update := `
+UPDATE admin_keys
+   SET revoked_at = COALESCE(revoked_at, NOW())
+ WHERE id::text = $1
+RETURNING ` + adminKeyColumns + `;
+`
+	return scanAdminKey(p.pool.QueryRow(ctx, update, id))
+}
+
+func (p *PostgresStore) LookupAdminKey(ctx context.Context, hash string) (AdminKey, error) {
+	return scanAdminKey(p.pool.QueryRow(ctx, `SELECT `+adminKeyColumns+` FROM admin_keys WHERE key_hash = $1`, hash))
+}
+
+const auditColumns = `id, actor, scheme, method, path, status, payload_sha256, payload_bytes, remote_addr, created_at`
+
+func scanAuditEntry(row pgx.Row) (AuditEntry, error) {
+	var e AuditEntry
+	err := row.Scan(&e.ID, &e.Actor, &e.Scheme, &e.Method, &e.Path, &e.Status, &e.PayloadSHA256, &e.PayloadBytes, &e.RemoteAddr, &e.CreatedAt)
+	return e, err
+}
+
+func (p *PostgresStore) RecordAuditEntry(ctx context.Context, entry AuditEntry) (AuditEntry, error) {
+	if strings.TrimSpace(entry.Method) == "" || strings.TrimSpace(entry.Path) == "" {
+		return AuditEntry{}, errors.New("method and path required")
+	}
+	var createdAt any
+	if !entry.CreatedAt.IsZero() {
+		createdAt = entry.CreatedAt
+	}
+	insert := `
+INSERT INTO admin_audit_log (actor, scheme, method, path, status, payload_sha256, payload_bytes, remote_addr, created_at)
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,COALESCE($9::timestamptz, NOW()))
+RETURNING ` + auditColumns + `;
+`
+	return scanAuditEntry(p.pool.QueryRow(ctx, insert, entry.Actor, entry.Scheme, entry.Method, entry.Path,
+		entry.Status, entry.PayloadSHA256, entry.PayloadBytes, entry.RemoteAddr, createdAt))
+}
+
+func (p *PostgresStore) ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = 100
+	}
+	var since, until any
+	if !filter.Since.IsZero() {
+		since = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until
+	}
+	query := `
+SELECT ` + auditColumns + `
+  FROM admin_audit_log
+ WHERE ($1 = '' OR actor = $1)
+   AND ($2 = '' OR upper(method) = upper($2))
+   AND ($3 = '' OR starts_with(path, $3))
+   AND ($4::timestamptz IS NULL OR created_at >= $4::timestamptz)
+   AND ($5::timestamptz IS NULL OR created_at < $5::timestamptz)
+   AND ($6 <= 0 OR id < $6)
+ ORDER BY id DESC
+ LIMIT $7;
+`
+	rows, err := p.pool.Query(ctx, query, filter.Actor, filter.Method, filter.PathPrefix, since, until, filter.Before, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []AuditEntry
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
@@ -0,0 +1,203 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Rollout statuses.
+const (
+	RolloutActive    = "active"
+	RolloutPaused    = "paused"
+	RolloutCompleted = "completed"
+	// RolloutSuperseded marks a rollout whose channel plan moved to another
+	// version.
+	RolloutSuperseded = "superseded"
+)
+
+// Rollout walks a channel plan through cohorts of increasing rollout
+// percentage. Each cohort must collect MinReports upgrade outcomes and soak
+// for SoakSeconds before the next starts; a failure rate above
+// MaxFailureRate pauses the rollout and its plan.
+type Rollout struct {
+	Channel        string  `json:"channel"`
+	Version        string  `json:"version"`
+	Cohorts        []int   `json:"cohorts"`
+	Cohort         int     `json:"cohort"`
+	MinReports     int     `json:"min_reports"`
+	MaxFailureRate float64 `json:"max_failure_rate"`
+	SoakSeconds    int     `json:"soak_seconds"`
+	Status         string  `json:"status"`
+	Reason         string  `json:"reason,omitempty"`
+	// Succeeded and Failed count agents whose latest report for Version in
+	// the current cohort was a success or a failure.
+	Succeeded       int       `json:"succeeded"`
+	Failed          int       `json:"failed"`
+	CohortStartedAt time.Time `json:"cohort_started_at"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// UpgradeOutcomes counts agents by the result of their latest upgrade report.
+type UpgradeOutcomes struct {
+	Succeeded int
+	Failed    int
+}
+
+// ErrRolloutNotFound signals that the channel has no rollout.
+var ErrRolloutNotFound = errors.New("rollout not found")
+
+// Percent is the rollout percentage of the current cohort.
+func (r Rollout) Percent() int {
+	if r.Cohort < 0 || r.Cohort >= len(r.Cohorts) {
+		return 100
+	}
+	return r.Cohorts[r.Cohort]
+}
+
+// ValidateRollout checks a rollout definition before it starts.
+func ValidateRollout(r Rollout) error {
+	if strings.TrimSpace(r.Channel) == "" {
+		return errors.New("channel required")
+	}
+	if len(r.Cohorts) == 0 {
+		return errors.New("cohorts required")
+	}
+	for i, percent := range r.Cohorts {
+		if percent <= 0 || percent > 100 {
+			return fmt.Errorf("cohorts[%d]: percent must be between 1 and 100, got %d", i, percent)
+		}
+		if i > 0 && percent <= r.Cohorts[i-1] {
+			return fmt.Errorf("cohorts[%d]: percentages must increase", i)
+		}
+	}
+	if r.Cohorts[len(r.Cohorts)-1] != 100 {
+		return errors.New("the last cohort must be 100")
+	}
+	if r.MinReports < 0 || r.SoakSeconds < 0 {
+		return errors.New("min_reports and soak_seconds must not be negative")
+	}
+	if r.MaxFailureRate < 0 || r.MaxFailureRate > 1 {
+		return fmt.Errorf("max_failure_rate must be between 0 and 1, got %g", r.MaxFailureRate)
+	}
+	return nil
+}
+
+// Step applies the current cohort's outcomes: it pauses the rollout when the
+// failure rate, taken over at least MinReports agents, exceeds
+// MaxFailureRate, and otherwise moves to the next cohort (or completes) once
+// the cohort has enough reports and has soaked. Inactive rollouts are
+// returned unchanged.
+func (r Rollout) Step(outcomes UpgradeOutcomes, now time.Time) Rollout {
+	if r.Status != RolloutActive {
+		return r
+	}
+	r.Succeeded, r.Failed = outcomes.Succeeded, outcomes.Failed
+	total := r.Succeeded + r.Failed
+	sample := max(total, r.MinReports, 1)
+	if rate := float64(r.Failed) / float64(sample); rate > r.MaxFailureRate {
+		r.Status = RolloutPaused
+		r.Reason = fmt.Sprintf("failure rate %.0f%% (%d of %d agents) exceeds %.0f%% at %d%%",
+			rate*100, r.Failed, total, r.MaxFailureRate*100, r.Percent())
+		return r
+	}
+	if total < r.MinReports || now.Sub(r.CohortStartedAt) < time.Duration(r.SoakSeconds)*time.Second {
+		return r
+	}
+	if r.Cohort >= len(r.Cohorts)-1 {
+		r.Status = RolloutCompleted
+		return r
+	}
+	r.Cohort++
+	r.CohortStartedAt = now
+	r.Succeeded, r.Failed = 0, 0
+	return r
+}
+
+func (m *memoryStore) GetUpgradePlan(ctx context.Context, key string) (UpgradePlanResponse, string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	plan, ok := m.plans[key]
+	if !ok {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
+	}
+	return plan, computeETag(plan), nil
+}
+
+func (m *memoryStore) SetPlanPaused(ctx context.Context, key string, paused bool) (UpgradePlanResponse, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	plan, ok := m.plans[key]
+	if !ok {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
+	}
+	plan.Paused = paused
+	plan.GeneratedAt = time.Now().UTC()
+	m.plans[key] = plan
+	return plan, computeETag(plan), nil
+}
+
+func (m *memoryStore) SaveRollout(ctx context.Context, r Rollout) (Rollout, error) {
+	r.Channel = normalizeChannel(r.Channel)
+	if err := ValidateRollout(r); err != nil {
+		return Rollout{}, err
+	}
+	r.UpdatedAt = time.Now().UTC()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rollouts[r.Channel] = r
+	return r, nil
+}
+
+func (m *memoryStore) GetRollout(ctx context.Context, channel string) (Rollout, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.rollouts[normalizeChannel(channel)]
+	if !ok {
+		return Rollout{}, ErrRolloutNotFound
+	}
+	return r, nil
+}
+
+func (m *memoryStore) ListRollouts(ctx context.Context) ([]Rollout, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	out := make([]Rollout, 0, len(m.rollouts))
+	for _, r := range m.rollouts {
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Channel < out[j].Channel })
+	return out, nil
+}
+
+func (m *memoryStore) CountUpgradeOutcomes(ctx context.Context, channel, version string, since time.Time) (UpgradeOutcomes, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	channel = normalizeChannel(channel)
+	latest := map[string]UpgradeReport{}
+	for _, r := range m.reports {
+		if normalizeChannel(r.Channel) != channel || r.CurrentVersion != version || r.CompletedAt.Before(since) {
+			continue
+		}
+		if r.Status != "success" && r.Status != "failed" {
+			continue
+		}
+		if prev, ok := latest[r.AgentID]; ok && prev.CompletedAt.After(r.CompletedAt) {
+			continue
+		}
+		latest[r.AgentID] = r
+	}
+	var out UpgradeOutcomes
+	for _, r := range latest {
+		if r.Status == "success" {
+			out.Succeeded++
+		} else {
+			out.Failed++
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// UnknownAgentVersion groups agents that have never reported a successful upgrade.
+const UnknownAgentVersion = "unknown"
+
+// FleetStats is a point-in-time view of fleet size and database footprint.
+// Agents are grouped by the current_version of their latest successful
+// upgrade report; agents without one count as UnknownAgentVersion.
+type FleetStats struct {
+	AgentsTotal     int            `json:"agents_total"`
+	AgentsByVersion map[string]int `json:"agents_by_version"`
+	// StorageBytes is the on-disk size of the controller database; zero for
+	// stores that cannot report it.
+	StorageBytes int64 `json:"storage_bytes"`
+}
+
+// StatsSample is one capacity-planning sample. Counters cover the interval
+// that ended at SampledAt.
+type StatsSample struct {
+	SampledAt           time.Time      `json:"sampled_at"`
+	IntervalSeconds     float64        `json:"interval_seconds"`
+	AgentsTotal         int            `json:"agents_total"`
+	AgentsByVersion     map[string]int `json:"agents_by_version"`
+	StorageBytes        int64          `json:"storage_bytes"`
+	ResultsIngested     uint64         `json:"results_ingested"`
+	ArtifactBytesServed uint64         `json:"artifact_bytes_served"`
+	PlanPolls           uint64         `json:"plan_polls"`
+}
+
+// maxMemoryStatsSamples bounds the in-memory history (a week at one sample per minute).
+const maxMemoryStatsSamples = 7 * 24 * 60
+
+func (m *memoryStore) FleetStats(ctx context.Context) (FleetStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := make(map[string]string)
+	for agentID := range m.agents {
+		versions[agentID] = UnknownAgentVersion
+	}
+	latest := make(map[string]time.Time)
+	for _, r := range m.reports {
+		if _, ok := versions[r.AgentID]; !ok {
+			versions[r.AgentID] = UnknownAgentVersion
+		}
+		if r.Status != "success" || r.CurrentVersion == "" {
+			continue
+		}
+		if at, ok := latest[r.AgentID]; ok && at.After(r.CompletedAt) {
+			continue
+		}
+		latest[r.AgentID] = r.CompletedAt
+		versions[r.AgentID] = r.CurrentVersion
+	}
+
+	stats := FleetStats{AgentsTotal: len(versions), AgentsByVersion: map[string]int{}}
+	for _, version := range versions {
+		stats.AgentsByVersion[version]++
+	}
+	return stats, nil
+}
+
+func (m *memoryStore) RecordStatsSample(ctx context.Context, sample StatsSample) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statsSamples = append(m.statsSamples, sample)
+	if over := len(m.statsSamples) - maxMemoryStatsSamples; over > 0 {
+		m.statsSamples = append([]StatsSample(nil), m.statsSamples[over:]...)
+	}
+	return nil
+}
+
+func (m *memoryStore) ListStatsSamples(ctx context.Context, since time.Time, limit int) ([]StatsSample, error) {
+	if limit <= 0 {
+		limit = 1440
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var out []StatsSample
+	for _, s := range m.statsSamples {
+		if !since.IsZero() && s.SampledAt.Before(since) {
+			continue
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].SampledAt.Before(out[j].SampledAt) })
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, nil
+}
+
+func (m *memoryStore) PruneStatsSamples(ctx context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := m.statsSamples[:0]
+	for _, s := range m.statsSamples {
+		if !s.SampledAt.Before(before) {
+			kept = append(kept, s)
+		}
+	}
+	removed := len(m.statsSamples) - len(kept)
+	m.statsSamples = kept
+	return removed, nil
+}
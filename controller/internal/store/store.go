@@ -3,11 +3,11 @@ package store
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -22,20 +22,45 @@ type UpgradePlanResponse struct {
 	Schedule    Schedule  `json:"schedule"`
 	Paused      bool      `json:"paused"`
 	Notes       string    `json:"notes,omitempty"`
+	// RolloutPercent is the share of the channel's agents that receive a
+	// channel plan, chosen by InRollout; per-agent plans are always 100.
+	RolloutPercent int `json:"rollout_percent"`
 }
 
 type PlanInput struct {
-	AgentID          string
-	Channel          string
-	Version          string
-	ArtifactURL      string
-	ArtifactSHA256   string
-	SignatureURL     string
+	AgentID        string
+	Channel        string
+	Version        string
+	ArtifactURL    string
+	ArtifactSHA256 string
+	SignatureURL   string
+	// ArtifactSize is the artifact length in bytes; zero means unknown.
+	ArtifactSize     int64
 	ForceApply       bool
+	IgnoreReadiness  bool
 	ScheduleEarliest *time.Time
 	ScheduleLatest   *time.Time
-	Paused           bool
-	Notes            string
+	// MaintenanceWindows are recurring agent-local windows, see
+	// ValidateMaintenanceWindows.
+	MaintenanceWindows []string
+	// Prefetch has agents download the artifact before the window opens.
+	Prefetch bool
+	Paused   bool
+	Notes    string
+	// Deltas are optional patches from earlier versions to this artifact.
+	Deltas []Delta
+	// Mirrors are fallback locations for the artifact, tried in order.
+	Mirrors []Mirror
+	// Platforms are per-OS/architecture builds of this version.
+	Platforms []Platform
+	// RolloutPercent limits a channel plan to a stable subset of agents;
+	// nil means 100.
+	RolloutPercent *int
+	// IfMatch makes the upsert conditional on the ETag of the plan stored
+	// under the key ("*" accepts any stored plan); IfAbsent on there being
+	// none. A failed condition returns ErrPlanPreconditionFailed.
+	IfMatch  string
+	IfAbsent bool
 }
 
 type Artifact struct {
@@ -43,16 +68,171 @@ type Artifact struct {
 	URL          string `json:"url"`
 	SHA256       string `json:"sha256"`
 	SignatureURL string `json:"signature_url"`
-	ForceApply   bool   `json:"force_apply"`
+	// Size is the artifact length in bytes, which agents compare with free
+	// disk space before downloading; zero means unknown.
+	Size            int64 `json:"size,omitempty"`
+	ForceApply      bool  `json:"force_apply"`
+	IgnoreReadiness bool  `json:"ignore_readiness,omitempty"`
+
+	// Deltas let agents on a listed version download a patch instead of
+	// the full artifact.
+	Deltas []Delta `json:"deltas,omitempty"`
+	// Mirrors are tried in order when URL (or a later mirror) fails.
+	Mirrors []Mirror `json:"mirrors,omitempty"`
+	// Platforms override the artifact for agents on a matching GOOS/GOARCH;
+	// the top-level artifact serves every other agent.
+	Platforms []Platform `json:"platforms,omitempty"`
+}
+
+// Platform is the build of the plan version for one GOOS/GOARCH pair. Its
+// deltas and mirrors replace the top-level ones, which describe another
+// binary.
+type Platform struct {
+	OS           string   `json:"os"`
+	Arch         string   `json:"arch"`
+	URL          string   `json:"url"`
+	SHA256       string   `json:"sha256"`
+	SignatureURL string   `json:"signature_url,omitempty"`
+	Size         int64    `json:"size,omitempty"`
+	Deltas       []Delta  `json:"deltas,omitempty"`
+	Mirrors      []Mirror `json:"mirrors,omitempty"`
+}
+
+// ValidatePlatforms checks that every platform entry names its OS,
+// architecture, URL and checksum, with at most one entry per pair and no
+// negative size.
+func ValidatePlatforms(platforms []Platform) error {
+	seen := make(map[string]bool, len(platforms))
+	for i, p := range platforms {
+		key := p.OS + "/" + p.Arch
+		switch {
+		case strings.TrimSpace(p.OS) == "" || strings.TrimSpace(p.Arch) == "":
+			return fmt.Errorf("platforms[%d]: os and arch required", i)
+		case strings.TrimSpace(p.URL) == "":
+			return fmt.Errorf("platforms[%d]: url required", i)
+		case strings.TrimSpace(p.SHA256) == "":
+			return fmt.Errorf("platforms[%d]: sha256 required", i)
+		case p.Size < 0:
+			return fmt.Errorf("platforms[%d]: size must not be negative", i)
+		case seen[key]:
+			return fmt.Errorf("platforms[%d]: duplicate platform %q", i, key)
+		}
+		seen[key] = true
+		if err := ValidateDeltas(p.Deltas); err != nil {
+			return fmt.Errorf("platforms[%d].%w", i, err)
+		}
+		if err := ValidateMirrors(p.Mirrors); err != nil {
+			return fmt.Errorf("platforms[%d].%w", i, err)
+		}
+	}
+	return nil
+}
+
+// Mirror is another host serving the same artifact, and optionally its
+// signature.
+type Mirror struct {
+	URL          string `json:"url"`
+	SignatureURL string `json:"signature_url,omitempty"`
+}
+
+// ValidateMirrors checks that every mirror has a URL.
+func ValidateMirrors(mirrors []Mirror) error {
+	for i, m := range mirrors {
+		if strings.TrimSpace(m.URL) == "" {
+			return fmt.Errorf("mirrors[%d]: url required", i)
+		}
+	}
+	return nil
+}
+
+const (
+	// DeltaFormatBSDiff is a classic BSDIFF40 patch (bzip2-compressed blocks).
+	DeltaFormatBSDiff = "bsdiff"
+	// DeltaFormatZstd is a zstd frame written with --patch-from=<base>.
+	DeltaFormatZstd = "zstd"
+)
+
+// Delta is a binary patch that turns the artifact of FromVersion (whose
+// SHA-256 is BaseSHA256) into the plan artifact. Agents verify the result
+// against the plan artifact's SHA-256 and fall back to the full download
+// when the base does not match.
+type Delta struct {
+	FromVersion string `json:"from_version"`
+	Format      string `json:"format"`
+	URL         string `json:"url"`
+	SHA256      string `json:"sha256,omitempty"`
+	BaseSHA256  string `json:"base_sha256"`
+}
+
+// ValidateDeltas checks that every delta names its base and a supported
+// format, with at most one delta per base version.
+func ValidateDeltas(deltas []Delta) error {
+	seen := make(map[string]bool, len(deltas))
+	for i, d := range deltas {
+		switch {
+		case strings.TrimSpace(d.FromVersion) == "":
+			return fmt.Errorf("deltas[%d]: from_version required", i)
+		case strings.TrimSpace(d.URL) == "":
+			return fmt.Errorf("deltas[%d]: url required", i)
+		case strings.TrimSpace(d.BaseSHA256) == "":
+			return fmt.Errorf("deltas[%d]: base_sha256 required", i)
+		case d.Format != DeltaFormatBSDiff && d.Format != DeltaFormatZstd:
+			return fmt.Errorf("deltas[%d]: unsupported format %q", i, d.Format)
+		case seen[d.FromVersion]:
+			return fmt.Errorf("deltas[%d]: duplicate from_version %q", i, d.FromVersion)
+		}
+		seen[d.FromVersion] = true
+	}
+	return nil
 }
 
 type Schedule struct {
 	Earliest *time.Time `json:"earliest,omitempty"`
 	Latest   *time.Time `json:"latest,omitempty"`
+	// MaintenanceWindows limit upgrades to recurring periods in each agent's
+	// local time, such as "Sat 02:00-04:00".
+	MaintenanceWindows []string `json:"maintenance_windows,omitempty"`
+	// Prefetch asks agents to download and verify the artifact as soon as
+	// they see the plan, and only install once the windows allow it.
+	Prefetch bool `json:"prefetch,omitempty"`
+}
+
+var windowDays = map[string]bool{"sun": true, "mon": true, "tue": true, "wed": true, "thu": true, "fri": true, "sat": true}
+
+// ValidateMaintenanceWindows checks each window has the form agents parse:
+// "<days> HH:MM-HH:MM", where days is "daily", a day name, a range such as
+// Mon-Fri, or a comma list of those, and the times differ.
+func ValidateMaintenanceWindows(windows []string) error {
+	for i, raw := range windows {
+		days, clock, ok := strings.Cut(strings.TrimSpace(raw), " ")
+		if !ok {
+			return fmt.Errorf("maintenance_windows[%d]: want \"<days> HH:MM-HH:MM\", got %q", i, raw)
+		}
+		if !strings.EqualFold(days, "daily") {
+			for _, part := range strings.Split(days, ",") {
+				first, last, isRange := strings.Cut(part, "-")
+				if !windowDays[strings.ToLower(first)] || (isRange && !windowDays[strings.ToLower(last)]) {
+					return fmt.Errorf("maintenance_windows[%d]: unknown days %q", i, part)
+				}
+			}
+		}
+		from, to, ok := strings.Cut(strings.ReplaceAll(strings.TrimSpace(clock), "–", "-"), "-")
+		start, startErr := time.Parse("15:04", strings.TrimSpace(from))
+		end, endErr := time.Parse("15:04", strings.TrimSpace(to))
+		if !ok || startErr != nil || endErr != nil {
+			return fmt.Errorf("maintenance_windows[%d]: invalid time range %q", i, clock)
+		}
+		if start.Equal(end) {
+			return fmt.Errorf("maintenance_windows[%d]: start and end are equal", i)
+		}
+	}
+	return nil
 }
 
 // UpgradeReport is the shape persisted by the controller after agent submission.
 type UpgradeReport struct {
+	// ID is assigned by the store when the report is recorded.
+	ID              string         `json:"id,omitempty"`
 	AgentID         string         `json:"agent_id"`
 	CurrentVersion  string         `json:"current_version"`
 	PreviousVersion string         `json:"previous_version"`
@@ -73,21 +253,154 @@ type NotificationSettings struct {
 // ErrPlanNotFound signals the absence of an upgrade plan for the requested agent.
 var ErrPlanNotFound = errors.New("upgrade plan not found")
 
+// ErrPlanPreconditionFailed signals that a conditional plan upsert found a
+// different plan under the key than the caller expected.
+var ErrPlanPreconditionFailed = errors.New("upgrade plan changed since it was read")
+
+// ValidateRolloutPercent checks a plan rollout percentage.
+func ValidateRolloutPercent(percent int) error {
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("rollout_percent must be between 0 and 100, got %d", percent)
+	}
+	return nil
+}
+
+// InRollout reports whether agentID is among the plan's RolloutPercent share
+// of agents. Each agent falls in a fixed bucket per plan version, so raising
+// the percentage only adds agents, while a new version spreads the first
+// waves over different agents.
+func InRollout(agentID string, plan UpgradePlanResponse) bool {
+	if plan.RolloutPercent >= 100 {
+		return true
+	}
+	if plan.RolloutPercent <= 0 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(plan.Artifact.Version + "/" + agentID))
+	return binary.BigEndian.Uint64(sum[:8])%100 < uint64(plan.RolloutPercent)
+}
+
+// planRolloutPercent resolves the rollout percentage of a plan upsert.
+func planRolloutPercent(input PlanInput, key string) (int, error) {
+	if input.RolloutPercent == nil {
+		return 100, nil
+	}
+	if !strings.HasPrefix(key, "channel:") {
+		return 0, errors.New("rollout_percent applies only to channel plans")
+	}
+	if err := ValidateRolloutPercent(*input.RolloutPercent); err != nil {
+		return 0, err
+	}
+	return *input.RolloutPercent, nil
+}
+
 // Store exposes persistence operations required by the upgrade API.
 type Store interface {
 	FetchUpgradePlan(ctx context.Context, agentID string, channel string) (UpgradePlanResponse, string, error)
 	RecordUpgradeReport(ctx context.Context, report UpgradeReport) error
 	UpsertUpgradePlan(ctx context.Context, input PlanInput) (UpgradePlanResponse, string, error)
-	ListUpgradeHistory(ctx context.Context, agentID string, limit int) ([]UpgradeReport, error)
+	// GetUpgradePlan returns the plan stored under key: an agent ID or a
+	// channel key such as "channel:stable".
+	GetUpgradePlan(ctx context.Context, key string) (UpgradePlanResponse, string, error)
+	// ListUpgradePlans returns the stored per-agent and channel plans that
+	// match filter, ordered by key.
+	ListUpgradePlans(ctx context.Context, filter PlanFilter) ([]UpgradePlanResponse, error)
+	// DeleteUpgradePlan retires the plan stored under key; agents that
+	// relied on it get ErrPlanNotFound (or their channel plan) afterwards.
+	DeleteUpgradePlan(ctx context.Context, key string) error
+	// SetPlanRollout changes the rollout percentage of the channel plan stored
+	// under key (such as "channel:stable").
+	SetPlanRollout(ctx context.Context, key string, percent int) (UpgradePlanResponse, string, error)
+	SetPlanPaused(ctx context.Context, key string, paused bool) (UpgradePlanResponse, string, error)
+	// SaveRollout creates or replaces the rollout of its channel.
+	SaveRollout(ctx context.Context, r Rollout) (Rollout, error)
+	GetRollout(ctx context.Context, channel string) (Rollout, error)
+	ListRollouts(ctx context.Context) ([]Rollout, error)
+	// CountUpgradeOutcomes counts agents on channel whose latest success or
+	// failed report for version completed at or after since.
+	CountUpgradeOutcomes(ctx context.Context, channel, version string, since time.Time) (UpgradeOutcomes, error)
+	// ListUpgradeHistory returns the reports matching filter, newest first.
+	ListUpgradeHistory(ctx context.Context, filter HistoryFilter) ([]UpgradeReport, error)
 	GetNotificationSettings(ctx context.Context) (NotificationSettings, error)
 	UpdateNotificationSettings(ctx context.Context, notify bool) (NotificationSettings, error)
+	// GetConfigOverlay returns the fleet-wide agent config overlay (zero when unset).
+	GetConfigOverlay(ctx context.Context) (ConfigOverlay, error)
+	UpdateConfigOverlay(ctx context.Context, overlay ConfigOverlay) (ConfigOverlay, error)
+	PublishMonitorSnapshot(ctx context.Context, agentID string, monitors []MonitorAssignment) (MonitorSnapshot, error)
+	// ApplyMonitorSnapshots publishes a snapshot for every agent in one all-or-nothing step.
+	ApplyMonitorSnapshots(ctx context.Context, snapshots map[string][]MonitorAssignment) (map[string]MonitorSnapshot, error)
+	GetMonitorSnapshot(ctx context.Context, agentID string, revision string) (MonitorSnapshot, error)
+	ListMonitorRevisions(ctx context.Context, agentID string, limit int) ([]MonitorRevision, error)
+	// ListCatalogMonitors returns the admin-managed monitor catalog sorted by ID.
+	ListCatalogMonitors(ctx context.Context) ([]CatalogMonitor, error)
+	GetCatalogMonitor(ctx context.Context, id string) (CatalogMonitor, error)
+	// SaveCatalogMonitor creates or replaces the catalog monitor with m's ID.
+	SaveCatalogMonitor(ctx context.Context, m CatalogMonitor) (CatalogMonitor, error)
+	DeleteCatalogMonitor(ctx context.Context, id string) error
+	RecordHeartbeat(ctx context.Context, hb Heartbeat) error
+	GetHeartbeat(ctx context.Context, agentID string) (Heartbeat, error)
+	// ListAgents returns the agent inventory matching filter, ordered by ID.
+	ListAgents(ctx context.Context, filter AgentFilter) ([]AgentSummary, error)
+	// ListAgentLabels returns the labels of every agent's latest heartbeat.
+	ListAgentLabels(ctx context.Context) (map[string]map[string]string, error)
+	// ListCertExpiry returns agents whose certificate expires before the given time
+	// (all reporting agents when before is zero), soonest first.
+	ListCertExpiry(ctx context.Context, before time.Time, limit int) ([]CertExpiry, error)
+	// EnqueueDirectives queues a directive per agent, reusing an existing pending one of the same type.
+	EnqueueDirectives(ctx context.Context, directiveType string, agentIDs []string, payload map[string]any) ([]Directive, error)
+	PendingDirectives(ctx context.Context, agentID string) ([]Directive, error)
+	// ListDirectives returns the agent's directives in any status, newest first.
+	ListDirectives(ctx context.Context, agentID string, limit int) ([]Directive, error)
+	CompleteDirective(ctx context.Context, agentID, id, status, message string) error
+	FleetStats(ctx context.Context) (FleetStats, error)
+	RecordStatsSample(ctx context.Context, sample StatsSample) error
+	// ListStatsSamples returns samples taken at or after since, oldest first,
+	// keeping the most recent limit.
+	ListStatsSamples(ctx context.Context, since time.Time, limit int) ([]StatsSample, error)
+	// PruneStatsSamples deletes samples taken before before and returns how
+	// many were removed.
+	PruneStatsSamples(ctx context.Context, before time.Time) (int, error)
+	// CreateEnrollmentToken stores a token under the hash of its secret.
+	CreateEnrollmentToken(ctx context.Context, token EnrollmentToken, hash string) (EnrollmentToken, error)
+	// ListEnrollmentTokens returns tokens newest first, only those in status
+	// when it is set.
+	ListEnrollmentTokens(ctx context.Context, status string, limit int) ([]EnrollmentToken, error)
+	RevokeEnrollmentToken(ctx context.Context, id string) (EnrollmentToken, error)
+	// RedeemEnrollmentToken atomically marks the active token with hash as
+	// used, so each token enrolls at most one agent. The agent is the one the
+	// token is scoped to, which agentID must then match if set. An unscoped
+	// token enrolls a new ID, or agentID when proven reports that the caller
+	// already authenticated as it; an unproven agentID is refused, so a token
+	// cannot be used to take over another agent's identity. cloud is the
+	// verified cloud identity of the enrolling host, if any; tokens scoped to
+	// a cloud account only redeem for a matching identity.
+	RedeemEnrollmentToken(ctx context.Context, hash, agentID string, proven bool, cloud CloudIdentity) (EnrollmentToken, error)
+	// CreateAdminKey stores a new admin API key under the hash of its secret.
+	CreateAdminKey(ctx context.Context, key AdminKey, hash string) (AdminKey, error)
+	// ListAdminKeys returns every admin key, newest first, revoked ones included.
+	ListAdminKeys(ctx context.Context) ([]AdminKey, error)
+	// RevokeAdminKey revokes the key with id; revoking twice is a no-op.
+	RevokeAdminKey(ctx context.Context, id string) (AdminKey, error)
+	// LookupAdminKey returns the key whose secret hashes to hash, revoked or
+	// not, or ErrAdminKeyNotFound.
+	LookupAdminKey(ctx context.Context, hash string) (AdminKey, error)
+	// RecordAuditEntry appends an admin mutation to the audit log. Entries
+	// are never changed or removed.
+	RecordAuditEntry(ctx context.Context, entry AuditEntry) (AuditEntry, error)
+	// ListAuditEntries returns the audit entries matching filter, newest first.
+	ListAuditEntries(ctx context.Context, filter AuditFilter) ([]AuditEntry, error)
 }
 
 // NewMemoryStore returns an in-memory implementation useful for scaffolding/testing.
 func NewMemoryStore() Store {
 	return &memoryStore{
 		plans:           map[string]UpgradePlanResponse{},
+		deletedPlans:    map[string]bool{},
 		reports:         []UpgradeReport{},
+		snapshots:       map[string][]MonitorSnapshot{},
+		catalog:         map[string]CatalogMonitor{},
+		rollouts:        map[string]Rollout{},
+		agents:          map[string]Heartbeat{},
 		notifyOnPublish: true,
 		notifyUpdatedAt: time.Now().UTC(),
 	}
@@ -97,8 +410,30 @@ type memoryStore struct {
 	mu              sync.RWMutex
 	plans           map[string]UpgradePlanResponse
 	reports         []UpgradeReport
+	reportSeq       int
+	snapshots       map[string][]MonitorSnapshot
+	catalog         map[string]CatalogMonitor
+	rollouts        map[string]Rollout
+	agents          map[string]Heartbeat
+	directives      []Directive
+	directiveSeq    int
+	statsSamples    []StatsSample
 	notifyOnPublish bool
 	notifyUpdatedAt time.Time
+	configOverlay   ConfigOverlay
+
+	enrollmentTokens   []enrollmentTokenRecord
+	enrollmentTokenSeq int
+
+	adminKeys   []adminKeyRecord
+	adminKeySeq int
+
+	audit    []AuditEntry
+	auditSeq int64
+
+	// deletedPlans records retired plan keys so agents that relied on them
+	// get ErrPlanNotFound instead of the scaffolding default plan.
+	deletedPlans map[string]bool
 }
 
 func (m *memoryStore) FetchUpgradePlan(ctx context.Context, agentID string, channel string) (UpgradePlanResponse, string, error) {
@@ -109,12 +444,18 @@ func (m *memoryStore) FetchUpgradePlan(ctx context.Context, agentID string, chan
 		return plan, computeETag(plan), nil
 	}
 
-	if key := channelPlanKey(channel); key != "" {
+	if key := ChannelPlanKey(channel); key != "" {
 		if plan, ok := m.plans[key]; ok {
+			if !InRollout(agentID, plan) {
+				return UpgradePlanResponse{}, "", ErrPlanNotFound
+			}
 			return plan, computeETag(plan), nil
 		}
 	}
 
+	if m.deletedPlans[agentID] || m.deletedPlans[ChannelPlanKey(channel)] {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
+	}
 	plan := defaultPlan(agentID, channel)
 	return plan, computeETag(plan), nil
 }
@@ -122,6 +463,9 @@ func (m *memoryStore) FetchUpgradePlan(ctx context.Context, agentID string, chan
 func (m *memoryStore) RecordUpgradeReport(ctx context.Context, report UpgradeReport) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.reportSeq++
+	// Zero-padded so IDs order like the reports were recorded.
+	report.ID = fmt.Sprintf("rpt_%012d", m.reportSeq)
 	m.reports = append(m.reports, report)
 	return nil
 }
@@ -131,52 +475,71 @@ func (m *memoryStore) UpsertUpgradePlan(ctx context.Context, input PlanInput) (U
 		return UpgradePlanResponse{}, "", errors.New("version required")
 	}
 	channel := defaultString(input.Channel, "stable")
-	key := strings.TrimSpace(input.AgentID)
-	if key == "" {
-		key = channelPlanKey(channel)
+	key := input.Key()
+	rollout, err := planRolloutPercent(input, key)
+	if err != nil {
+		return UpgradePlanResponse{}, "", err
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	current, exists := m.plans[key]
+	var currentETag string
+	if exists {
+		currentETag = computeETag(current)
+	}
+	if err := checkPlanPrecondition(input, exists, currentETag); err != nil {
+		return UpgradePlanResponse{}, "", err
+	}
 	plan := UpgradePlanResponse{
 		AgentID:     key,
 		GeneratedAt: time.Now().UTC(),
 		Channel:     channel,
 		Artifact: Artifact{
-			Version:      input.Version,
-			URL:          input.ArtifactURL,
-			SHA256:       input.ArtifactSHA256,
-			SignatureURL: input.SignatureURL,
-			ForceApply:   input.ForceApply,
+			Version:         input.Version,
+			URL:             input.ArtifactURL,
+			SHA256:          input.ArtifactSHA256,
+			SignatureURL:    input.SignatureURL,
+			Size:            input.ArtifactSize,
+			ForceApply:      input.ForceApply,
+			IgnoreReadiness: input.IgnoreReadiness,
+			Deltas:          input.Deltas,
+			Mirrors:         input.Mirrors,
+			Platforms:       input.Platforms,
 		},
 		Schedule: Schedule{
-			Earliest: input.ScheduleEarliest,
-			Latest:   input.ScheduleLatest,
+			Earliest:           input.ScheduleEarliest,
+			Latest:             input.ScheduleLatest,
+			MaintenanceWindows: input.MaintenanceWindows,
+			Prefetch:           input.Prefetch,
 		},
-		Paused: input.Paused,
-		Notes:  input.Notes,
+		Paused:         input.Paused,
+		Notes:          input.Notes,
+		RolloutPercent: rollout,
 	}
 	m.plans[key] = plan
+	delete(m.deletedPlans, key)
 	etag := computeETag(plan)
 	return plan, etag, nil
 }
 
-func (m *memoryStore) ListUpgradeHistory(ctx context.Context, agentID string, limit int) ([]UpgradeReport, error) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	var results []UpgradeReport
-	for _, r := range m.reports {
-		if r.AgentID == agentID {
-			results = append(results, r)
-		}
+func (m *memoryStore) SetPlanRollout(ctx context.Context, key string, percent int) (UpgradePlanResponse, string, error) {
+	if !strings.HasPrefix(key, "channel:") {
+		return UpgradePlanResponse{}, "", errors.New("rollout_percent applies only to channel plans")
+	}
+	if err := ValidateRolloutPercent(percent); err != nil {
+		return UpgradePlanResponse{}, "", err
 	}
-	sort.Slice(results, func(i, j int) bool {
-		return results[i].CompletedAt.After(results[j].CompletedAt)
-	})
-	if limit > 0 && len(results) > limit {
-		results = results[:limit]
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	plan, ok := m.plans[key]
+	if !ok {
+		return UpgradePlanResponse{}, "", ErrPlanNotFound
 	}
-	return results, nil
+	plan.RolloutPercent = percent
+	plan.GeneratedAt = time.Now().UTC()
+	m.plans[key] = plan
+	return plan, computeETag(plan), nil
 }
 
 func (m *memoryStore) GetNotificationSettings(ctx context.Context) (NotificationSettings, error) {
@@ -199,6 +562,31 @@ func (m *memoryStore) UpdateNotificationSettings(ctx context.Context, notify boo
 	}, nil
 }
 
+// Key is the key the plan is stored under: the agent ID, or
+// channel:<name> for a channel plan.
+func (input PlanInput) Key() string {
+	if key := strings.TrimSpace(input.AgentID); key != "" {
+		return key
+	}
+	return ChannelPlanKey(defaultString(input.Channel, "stable"))
+}
+
+// checkPlanPrecondition evaluates input's IfMatch and IfAbsent against the
+// plan currently stored under its key.
+func checkPlanPrecondition(input PlanInput, exists bool, etag string) error {
+	switch {
+	case input.IfAbsent && exists:
+		return ErrPlanPreconditionFailed
+	case input.IfMatch == "":
+		return nil
+	case !exists:
+		return ErrPlanPreconditionFailed
+	case input.IfMatch != "*" && input.IfMatch != etag:
+		return ErrPlanPreconditionFailed
+	}
+	return nil
+}
+
 func computeETag(plan UpgradePlanResponse) string {
 	payload, _ := json.Marshal(plan)
 	sum := sha256.Sum256(payload)
@@ -216,7 +604,9 @@ func normalizeChannel(channel string) string {
 	return strings.ToLower(strings.TrimSpace(channel))
 }
 
-func channelPlanKey(channel string) string {
+// ChannelPlanKey returns the key channel's plan is stored under, such as
+// "channel:stable"; an empty channel means stable.
+func ChannelPlanKey(channel string) string {
 	normalized := normalizeChannel(channel)
 	if normalized == "" {
 		normalized = "stable"
@@ -239,7 +629,8 @@ func defaultPlan(agentID, channel string) UpgradePlanResponse {
 			SHA256:       "deadbeef",
 			SignatureURL: "https://artifacts.example.com/pingsanto/agent/1.0.0/pingsanto-agent.sig",
 		},
-		Paused: false,
-		Notes:  "scaffolding plan",
+		Paused:         false,
+		Notes:          "scaffolding plan",
+		RolloutPercent: 100,
 	}
 }
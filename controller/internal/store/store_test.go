@@ -2,7 +2,11 @@ package store
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMemoryStoreChannelFallback(t *testing.T) {
@@ -30,6 +34,37 @@ func TestMemoryStoreChannelFallback(t *testing.T) {
 	}
 }
 
+func TestMemoryStoreConditionalPlanUpsert(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	input := PlanInput{Channel: "stable", Version: "1.0.1", IfMatch: "*"}
+
+	if _, _, err := store.UpsertUpgradePlan(ctx, input); !errors.Is(err, ErrPlanPreconditionFailed) {
+		t.Fatalf("If-Match * without a plan: expected ErrPlanPreconditionFailed, got %v", err)
+	}
+	input.IfMatch, input.IfAbsent = "", true
+	_, etag, err := store.UpsertUpgradePlan(ctx, input)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, _, err := store.UpsertUpgradePlan(ctx, input); !errors.Is(err, ErrPlanPreconditionFailed) {
+		t.Fatalf("second create: expected ErrPlanPreconditionFailed, got %v", err)
+	}
+
+	input.IfAbsent, input.IfMatch, input.Version = false, etag, "1.0.2"
+	if _, _, err := store.UpsertUpgradePlan(ctx, input); err != nil {
+		t.Fatalf("update with current ETag: %v", err)
+	}
+	input.Version = "1.0.3"
+	if _, _, err := store.UpsertUpgradePlan(ctx, input); !errors.Is(err, ErrPlanPreconditionFailed) {
+		t.Fatalf("update with stale ETag: expected ErrPlanPreconditionFailed, got %v", err)
+	}
+	plan, _, err := store.GetUpgradePlan(ctx, "channel:stable")
+	if err != nil || plan.Artifact.Version != "1.0.2" {
+		t.Fatalf("stale update was applied: %+v (%v)", plan, err)
+	}
+}
+
 func TestMemoryStoreDefaultPlan(t *testing.T) {
 	ctx := context.Background()
 	store := NewMemoryStore()
@@ -43,11 +78,207 @@ func TestMemoryStoreDefaultPlan(t *testing.T) {
 	}
 }
 
+func TestInRolloutIsMonotonic(t *testing.T) {
+	plan := UpgradePlanResponse{Artifact: Artifact{Version: "1.2.0"}}
+	for i := 0; i < 100; i++ {
+		agentID := fmt.Sprintf("agt_%d", i)
+		var included bool
+		for percent := 0; percent <= 100; percent += 10 {
+			plan.RolloutPercent = percent
+			in := InRollout(agentID, plan)
+			if included && !in {
+				t.Fatalf("%s dropped out at %d%%", agentID, percent)
+			}
+			included = in
+		}
+		if !included {
+			t.Fatalf("%s not included at 100%%", agentID)
+		}
+	}
+}
+
 func TestChannelPlanKey(t *testing.T) {
-	if got := channelPlanKey("Stable"); got != "channel:stable" {
+	if got := ChannelPlanKey("Stable"); got != "channel:stable" {
 		t.Fatalf("unexpected key: %s", got)
 	}
-	if got := channelPlanKey(""); got != "channel:stable" {
+	if got := ChannelPlanKey(""); got != "channel:stable" {
 		t.Fatalf("expected stable default key, got %s", got)
 	}
 }
+
+func TestMemoryStoreMonitorSnapshotRevisions(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+
+	first, err := store.PublishMonitorSnapshot(ctx, "agt_1", []MonitorAssignment{
+		{MonitorID: "mon_b", Protocol: "icmp", Targets: []string{"203.0.113.7"}, CadenceMillis: 3000},
+		{MonitorID: "mon_a", Protocol: "tcp", Targets: []string{"203.0.113.8:443"}, CadenceMillis: 5000},
+	})
+	if err != nil {
+		t.Fatalf("PublishMonitorSnapshot: %v", err)
+	}
+	if first.Revision != "1" || first.Monitors[0].MonitorID != "mon_a" {
+		t.Fatalf("unexpected first snapshot: %#v", first)
+	}
+
+	again, err := store.PublishMonitorSnapshot(ctx, "agt_1", first.Monitors)
+	if err != nil {
+		t.Fatalf("PublishMonitorSnapshot unchanged: %v", err)
+	}
+	if again.Revision != "1" {
+		t.Fatalf("expected unchanged publish to reuse revision 1, got %s", again.Revision)
+	}
+
+	second, err := store.PublishMonitorSnapshot(ctx, "agt_1", []MonitorAssignment{
+		{MonitorID: "mon_a", Protocol: "tcp", Targets: []string{"203.0.113.8:443"}, CadenceMillis: 10000},
+		{MonitorID: "mon_c", Protocol: "http", Targets: []string{"https://example.com"}},
+	})
+	if err != nil {
+		t.Fatalf("PublishMonitorSnapshot second: %v", err)
+	}
+
+	diff := DiffMonitorSnapshots(first, second)
+	if diff.FromRevision != "1" || diff.ToRevision != "2" {
+		t.Fatalf("unexpected revisions: %#v", diff)
+	}
+	if len(diff.Added) != 1 || diff.Added[0].MonitorID != "mon_c" {
+		t.Fatalf("unexpected added: %#v", diff.Added)
+	}
+	if len(diff.Removed) != 1 || diff.Removed[0].MonitorID != "mon_b" {
+		t.Fatalf("unexpected removed: %#v", diff.Removed)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].MonitorID != "mon_a" || len(diff.Changed[0].Fields) != 1 || diff.Changed[0].Fields[0] != "cadence_ms" {
+		t.Fatalf("unexpected changed: %#v", diff.Changed)
+	}
+
+	revisions, err := store.ListMonitorRevisions(ctx, "agt_1", 10)
+	if err != nil {
+		t.Fatalf("ListMonitorRevisions: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Revision != "2" {
+		t.Fatalf("unexpected revisions: %#v", revisions)
+	}
+	if _, err := store.GetMonitorSnapshot(ctx, "agt_1", "9"); err != ErrSnapshotNotFound {
+		t.Fatalf("expected ErrSnapshotNotFound, got %v", err)
+	}
+}
+
+func TestValidateMonitorsRejectsDuplicates(t *testing.T) {
+	err := ValidateMonitors([]MonitorAssignment{
+		{MonitorID: "mon_a", Protocol: "icmp"},
+		{MonitorID: "mon_a", Protocol: "icmp"},
+	})
+	if err == nil {
+		t.Fatalf("expected duplicate monitor_id error")
+	}
+}
+
+func TestMemoryStoreEnrollmentTokensAreSingleUse(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore()
+	expires := time.Now().Add(time.Hour)
+
+	open, err := st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires, Labels: map[string]string{"site": "ATL-1"}}, HashEnrollmentToken("open"))
+	if err != nil || open.Status != EnrollmentTokenActive {
+		t.Fatalf("CreateEnrollmentToken: %+v, %v", open, err)
+	}
+	used, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("open"), "", false, CloudIdentity{})
+	if err != nil || used.Status != EnrollmentTokenUsed || used.UsedBy == "" || used.Labels["site"] != "ATL-1" {
+		t.Fatalf("RedeemEnrollmentToken: %+v, %v", used, err)
+	}
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("open"), "", false, CloudIdentity{}); !errors.Is(err, ErrEnrollmentTokenInvalid) {
+		t.Fatalf("expected second redemption to fail, got %v", err)
+	}
+	if _, err := st.RevokeEnrollmentToken(ctx, open.ID); !errors.Is(err, ErrEnrollmentTokenUsed) {
+		t.Fatalf("expected used token revoke to fail, got %v", err)
+	}
+
+	scoped, _ := st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires, AgentID: "agt_1"}, HashEnrollmentToken("scoped"))
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("scoped"), "agt_2", false, CloudIdentity{}); !errors.Is(err, ErrEnrollmentTokenInvalid) {
+		t.Fatalf("expected mis-scoped redemption to fail, got %v", err)
+	}
+	if got, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("scoped"), "", false, CloudIdentity{}); err != nil || got.UsedBy != "agt_1" || got.ID != scoped.ID {
+		t.Fatalf("expected scoped token to enroll agt_1, got %+v, %v", got, err)
+	}
+
+	revoked, _ := st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires}, HashEnrollmentToken("revoked"))
+	if got, err := st.RevokeEnrollmentToken(ctx, revoked.ID); err != nil || got.Status != EnrollmentTokenRevoked {
+		t.Fatalf("RevokeEnrollmentToken: %+v, %v", got, err)
+	}
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("revoked"), "", false, CloudIdentity{}); !errors.Is(err, ErrEnrollmentTokenInvalid) {
+		t.Fatalf("expected revoked token to fail, got %v", err)
+	}
+
+	st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: time.Now().Add(-time.Second)}, HashEnrollmentToken("expired"))
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("expired"), "", false, CloudIdentity{}); !errors.Is(err, ErrEnrollmentTokenInvalid) {
+		t.Fatalf("expected expired token to fail, got %v", err)
+	}
+	if _, err := st.RevokeEnrollmentToken(ctx, "enr_missing"); !errors.Is(err, ErrEnrollmentTokenNotFound) {
+		t.Fatalf("expected unknown token, got %v", err)
+	}
+
+	tokens, _ := st.ListEnrollmentTokens(ctx, "", 0)
+	if len(tokens) != 4 || tokens[0].Status != EnrollmentTokenExpired || tokens[3].ID != open.ID {
+		t.Fatalf("unexpected token list: %+v", tokens)
+	}
+}
+
+func TestMemoryStoreUnscopedTokenRefusesUnprovenAgentID(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore()
+	expires := time.Now().Add(time.Hour)
+	st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires}, HashEnrollmentToken("open"))
+
+	// An existing agent's ID must not be claimable with any token.
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("open"), "agt_existing", false, CloudIdentity{}); !errors.Is(err, ErrEnrollmentTokenInvalid) {
+		t.Fatalf("expected unproven agent ID to be refused, got %v", err)
+	}
+	if got, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("open"), "agt_existing", true, CloudIdentity{}); err != nil || got.UsedBy != "agt_existing" {
+		t.Fatalf("expected proven renewal to keep its ID, got %+v, %v", got, err)
+	}
+
+	st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires}, HashEnrollmentToken("fresh"))
+	got, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("fresh"), "", false, CloudIdentity{})
+	if err != nil || !strings.HasPrefix(got.UsedBy, "agt_") || got.UsedBy == "agt_existing" {
+		t.Fatalf("expected a fresh agent ID, got %+v, %v", got, err)
+	}
+}
+
+func TestMemoryStoreListEnrollmentTokensFiltersBeforeLimit(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore()
+	expires := time.Now().Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires}, HashEnrollmentToken(fmt.Sprintf("active-%d", i)))
+	}
+	// Newer used tokens must not crowd active ones out of the page.
+	for i := 0; i < 3; i++ {
+		secret := fmt.Sprintf("used-%d", i)
+		st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires}, HashEnrollmentToken(secret))
+		st.RedeemEnrollmentToken(ctx, HashEnrollmentToken(secret), "", false, CloudIdentity{})
+	}
+	tokens, err := st.ListEnrollmentTokens(ctx, EnrollmentTokenActive, 2)
+	if err != nil || len(tokens) != 2 || tokens[0].Status != EnrollmentTokenActive || tokens[1].Status != EnrollmentTokenActive {
+		t.Fatalf("unexpected active page: %+v, %v", tokens, err)
+	}
+}
+
+func TestMemoryStoreEnrollmentTokenCloudScope(t *testing.T) {
+	ctx := context.Background()
+	st := NewMemoryStore()
+	expires := time.Now().Add(time.Hour)
+	st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires, CloudProvider: "aws", CloudAccounts: []string{"111111111111"}}, HashEnrollmentToken("aws"))
+	st.CreateEnrollmentToken(ctx, EnrollmentToken{ExpiresAt: expires, CloudProvider: "gcp"}, HashEnrollmentToken("gcp"))
+
+	for _, id := range []CloudIdentity{{}, {Provider: "gcp", Account: "111111111111"}, {Provider: "aws", Account: "222222222222"}} {
+		if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("aws"), "", false, id); !errors.Is(err, ErrEnrollmentTokenInvalid) {
+			t.Fatalf("expected %+v to be rejected, got %v", id, err)
+		}
+	}
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("aws"), "", false, CloudIdentity{Provider: "aws", Account: "111111111111"}); err != nil {
+		t.Fatalf("expected matching account to redeem: %v", err)
+	}
+	if _, err := st.RedeemEnrollmentToken(ctx, HashEnrollmentToken("gcp"), "", false, CloudIdentity{Provider: "gcp", Account: "any-project"}); err != nil {
+		t.Fatalf("expected any project to redeem a provider-only scope: %v", err)
+	}
+}
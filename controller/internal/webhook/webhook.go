@@ -0,0 +1,268 @@
+// Package webhook delivers signed controller events to operator-configured
+// HTTP endpoints, such as PagerDuty or Slack automation.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types.
+const (
+	EventUpgradeSucceeded = "upgrade.succeeded"
+	EventUpgradeFailed    = "upgrade.failed"
+)
+
+// EventTypes lists every event type.
+var EventTypes = []string{EventUpgradeSucceeded, EventUpgradeFailed}
+
+// Delivery headers. Signature is "sha256=" followed by the hex HMAC-SHA256,
+// keyed with the shared secret, of the Timestamp header value, a dot and the
+// request body.
+const (
+	HeaderEvent     = "X-PingSanto-Event"
+	HeaderDelivery  = "X-PingSanto-Delivery"
+	HeaderTimestamp = "X-PingSanto-Timestamp"
+	HeaderSignature = "X-PingSanto-Signature"
+)
+
+// Event is the JSON body of a delivery.
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Config selects where events go.
+type Config struct {
+	URLs []string
+	// Secret signs every delivery; it is required when URLs are set.
+	Secret string
+	// Events limits deliveries to these types (default: all).
+	Events []string
+	// Timeout bounds each delivery attempt (default 10s).
+	Timeout time.Duration
+}
+
+// Validate checks that the configuration can be used to deliver events.
+func (c Config) Validate() error {
+	if len(c.URLs) == 0 {
+		return nil
+	}
+	if c.Secret == "" {
+		return errors.New("a signing secret is required")
+	}
+	for _, raw := range c.URLs {
+		if !strings.HasPrefix(raw, "https://") && !strings.HasPrefix(raw, "http://") {
+			return fmt.Errorf("invalid URL %q (want http:// or https://)", raw)
+		}
+	}
+	for _, typ := range c.Events {
+		if !slices.Contains(EventTypes, typ) {
+			return fmt.Errorf("unknown event %q (want any of %s)", typ, strings.Join(EventTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// queueSize bounds events waiting for delivery to one URL; later events for
+// that URL are dropped while its queue is full.
+const queueSize = 1024
+
+// Dispatcher queues events and delivers them from Run, retrying failed
+// deliveries with backoff. Every URL has its own queue and worker, so an
+// endpoint that is down or slow only delays its own deliveries. A nil
+// Dispatcher or one without URLs discards events.
+type Dispatcher struct {
+	cfg     Config
+	client  *http.Client
+	logf    func(string, ...any)
+	now     func() time.Time
+	targets []target
+	// retryDelays are the waits before each retry of a failed delivery.
+	retryDelays []time.Duration
+}
+
+// target is one URL and the encoded events waiting for it.
+type target struct {
+	url   string
+	queue chan delivery
+}
+
+type delivery struct {
+	ev   Event
+	body []byte
+}
+
+// New returns a dispatcher for cfg, which must be valid.
+func New(cfg Config, logf func(string, ...any)) *Dispatcher {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if logf == nil {
+		logf = func(string, ...any) {}
+	}
+	targets := make([]target, 0, len(cfg.URLs))
+	for _, url := range cfg.URLs {
+		targets = append(targets, target{url: url, queue: make(chan delivery, queueSize)})
+	}
+	return &Dispatcher{
+		cfg:         cfg,
+		client:      &http.Client{Timeout: cfg.Timeout},
+		logf:        logf,
+		now:         time.Now,
+		targets:     targets,
+		retryDelays: []time.Duration{time.Second, 10 * time.Second, time.Minute},
+	}
+}
+
+// Enabled reports whether d delivers events of type typ.
+func (d *Dispatcher) Enabled(typ string) bool {
+	if d == nil || len(d.cfg.URLs) == 0 {
+		return false
+	}
+	return len(d.cfg.Events) == 0 || slices.Contains(d.cfg.Events, typ)
+}
+
+// Publish queues an event of type typ carrying data for every URL without
+// blocking.
+func (d *Dispatcher) Publish(typ string, data any) {
+	if !d.Enabled(typ) {
+		return
+	}
+	ev := Event{ID: newID(), Type: typ, CreatedAt: d.now().UTC(), Data: data}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		d.logf("encode webhook event %s failed: %v", ev.ID, err)
+		return
+	}
+	for _, t := range d.targets {
+		select {
+		case t.queue <- delivery{ev: ev, body: body}:
+		default:
+			d.logf("webhook queue for %s full, dropping %s event %s", t.url, ev.Type, ev.ID)
+		}
+	}
+}
+
+// Run delivers queued events, one worker per URL, until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, t := range d.targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case next := <-t.queue:
+					d.deliver(ctx, t.url, next.ev, next.body)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver posts body to url, retrying transport errors, 429 and 5xx
+// responses.
+func (d *Dispatcher) deliver(ctx context.Context, url string, ev Event, body []byte) {
+	for attempt := 0; ; attempt++ {
+		err := d.post(ctx, url, ev, body)
+		if err == nil {
+			return
+		}
+		var perm permanentError
+		if errors.As(err, &perm) || attempt >= len(d.retryDelays) {
+			d.logf("webhook %s event %s to %s failed after %d attempt(s): %v", ev.Type, ev.ID, url, attempt+1, err)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.retryDelays[attempt]):
+		}
+	}
+}
+
+type permanentError struct{ status int }
+
+func (e permanentError) Error() string { return fmt.Sprintf("status %d", e.status) }
+
+func (d *Dispatcher) post(ctx context.Context, url string, ev Event, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return permanentError{}
+	}
+	timestamp := strconv.FormatInt(d.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pingsanto-controller")
+	req.Header.Set(HeaderEvent, ev.Type)
+	req.Header.Set(HeaderDelivery, ev.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(d.cfg.Secret, timestamp, body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return permanentError{status: resp.StatusCode}
+}
+
+// Sign returns the signature header value for body sent at timestamp.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a delivery's signature and that its timestamp is within
+// tolerance of now, for receivers written in Go.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp := header.Get(HeaderTimestamp)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("missing or invalid timestamp")
+	}
+	if skew := now.Sub(time.Unix(unix, 0)); skew > tolerance || skew < -tolerance {
+		return fmt.Errorf("timestamp outside tolerance (%s)", skew.Round(time.Second))
+	}
+	if !hmac.Equal([]byte(header.Get(HeaderSignature)), []byte(Sign(secret, timestamp, body))) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func newID() string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return "evt_" + hex.EncodeToString(b[:])
+}
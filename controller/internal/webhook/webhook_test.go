@@ -0,0 +1,116 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherSignsAndRetries(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		received []Event
+	)
+	delivered := make(chan struct{}, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if err := Verify("s3cret", r.Header, body, time.Minute, time.Now()); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+		if err := Verify("other", r.Header, body, time.Minute, time.Now()); err == nil {
+			t.Errorf("verify with the wrong secret succeeded")
+		}
+		var ev Event
+		if err := json.Unmarshal(body, &ev); err != nil || r.Header.Get(HeaderEvent) != ev.Type || r.Header.Get(HeaderDelivery) != ev.ID {
+			t.Errorf("unexpected delivery %s (%v) headers %v", body, err, r.Header)
+		}
+		received = append(received, ev)
+		delivered <- struct{}{}
+	}))
+	defer srv.Close()
+
+	cfg := Config{URLs: []string{srv.URL}, Secret: "s3cret", Events: []string{EventUpgradeFailed}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	d := New(cfg, t.Logf)
+	d.retryDelays = []time.Duration{10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go d.Run(ctx)
+
+	d.Publish(EventUpgradeSucceeded, map[string]string{"agent_id": "agt_1"})
+	d.Publish(EventUpgradeFailed, map[string]string{"agent_id": "agt_2"})
+	select {
+	case <-delivered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not delivered")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 || len(received) != 1 || received[0].Type != EventUpgradeFailed || received[0].Data.(map[string]any)["agent_id"] != "agt_2" {
+		t.Fatalf("attempts %d, received %+v", attempts, received)
+	}
+
+	for _, bad := range []Config{
+		{URLs: []string{srv.URL}},
+		{URLs: []string{"ftp://example.com"}, Secret: "x"},
+		{URLs: []string{srv.URL}, Secret: "x", Events: []string{"plan.deleted"}},
+	} {
+		if bad.Validate() == nil {
+			t.Fatalf("expected %+v to be invalid", bad)
+		}
+	}
+}
+
+func TestDispatcherRetriesDoNotBlockOtherURLs(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	delivered := make(chan string, 4)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered <- r.Header.Get(HeaderDelivery)
+	}))
+	defer healthy.Close()
+
+	d := New(Config{URLs: []string{failing.URL, healthy.URL}, Secret: "s3cret"}, t.Logf)
+	// The failing endpoint's first retry waits far longer than the test.
+	d.retryDelays = []time.Duration{time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+
+	d.Publish(EventUpgradeFailed, map[string]string{"agent_id": "agt_1"})
+	d.Publish(EventUpgradeSucceeded, map[string]string{"agent_id": "agt_2"})
+	for i := 0; i < 2; i++ {
+		select {
+		case <-delivered:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("healthy URL got %d of 2 events while the other URL was retrying", i)
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after cancel")
+	}
+}
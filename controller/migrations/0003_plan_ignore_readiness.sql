@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE agent_upgrade_plans
+    ADD COLUMN IF NOT EXISTS ignore_readiness BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
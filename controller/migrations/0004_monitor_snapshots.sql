@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS monitor_snapshots (
+    agent_id TEXT NOT NULL,
+    revision BIGINT NOT NULL,
+    monitors JSONB NOT NULL,
+    generated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (agent_id, revision)
+);
+
+COMMIT;
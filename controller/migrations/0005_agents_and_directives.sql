@@ -0,0 +1,30 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS agents (
+    agent_id TEXT PRIMARY KEY,
+    last_heartbeat_at TIMESTAMPTZ NOT NULL,
+    heartbeat JSONB NOT NULL,
+    cert_expires_at TIMESTAMPTZ NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_agents_cert_expiry
+    ON agents(cert_expires_at)
+    WHERE cert_expires_at IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS agent_directives (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    agent_id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    payload JSONB NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    message TEXT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    completed_at TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_directives_pending
+    ON agent_directives(agent_id, created_at)
+    WHERE status = 'pending';
+
+COMMIT;
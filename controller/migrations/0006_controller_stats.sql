@@ -0,0 +1,8 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS controller_stats_samples (
+    sampled_at TIMESTAMPTZ PRIMARY KEY,
+    sample JSONB NOT NULL
+);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE controller_settings
+    ADD COLUMN IF NOT EXISTS config_overlay JSONB NOT NULL DEFAULT '{}'::jsonb;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE enrollment_tokens
+    ADD COLUMN IF NOT EXISTS cloud_provider TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS cloud_accounts TEXT[] NOT NULL DEFAULT '{}';
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE agent_upgrade_plans
+    ADD COLUMN IF NOT EXISTS artifact_mirrors JSONB NOT NULL DEFAULT '[]'::jsonb;
+
+COMMIT;
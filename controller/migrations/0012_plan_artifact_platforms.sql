@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE agent_upgrade_plans
+    ADD COLUMN IF NOT EXISTS artifact_platforms JSONB NOT NULL DEFAULT '[]'::jsonb;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE agent_upgrade_plans
+    ADD COLUMN IF NOT EXISTS schedule_maintenance_windows TEXT[] NOT NULL DEFAULT '{}';
+
+COMMIT;
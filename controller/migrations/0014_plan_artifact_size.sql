@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE agent_upgrade_plans
+    ADD COLUMN IF NOT EXISTS artifact_size BIGINT NOT NULL DEFAULT 0;
+
+COMMIT;
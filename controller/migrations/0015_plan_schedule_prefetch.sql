@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE agent_upgrade_plans
+    ADD COLUMN IF NOT EXISTS schedule_prefetch BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMIT;
@@ -0,0 +1,35 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS result_batches (
+    agent_id TEXT NOT NULL,
+    batch_seq BIGINT NOT NULL,
+    sent_at TIMESTAMPTZ NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL,
+    labels JSONB NULL,
+    result_count INTEGER NOT NULL,
+    PRIMARY KEY (agent_id, batch_seq, sent_at)
+);
+
+CREATE TABLE IF NOT EXISTS probe_results (
+    agent_id TEXT NOT NULL,
+    batch_seq BIGINT NOT NULL,
+    monitor_id TEXT NOT NULL,
+    ts TIMESTAMPTZ NOT NULL,
+    proto TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    rtt_ms DOUBLE PRECISION NOT NULL,
+    success BOOLEAN NOT NULL,
+    seq BIGINT NOT NULL,
+    jitter_ms DOUBLE PRECISION NOT NULL,
+    loss_window_pct DOUBLE PRECISION NOT NULL,
+    mos DOUBLE PRECISION NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_probe_results_monitor_ts
+    ON probe_results(monitor_id, ts);
+
+CREATE INDEX IF NOT EXISTS idx_probe_results_agent_ts
+    ON probe_results(agent_id, ts);
+
+COMMIT;
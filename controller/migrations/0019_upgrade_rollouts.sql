@@ -0,0 +1,12 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS upgrade_rollouts (
+    channel TEXT PRIMARY KEY,
+    definition JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_upgrade_history_channel_version
+    ON agent_upgrade_history(lower(channel), target_version, completed_at);
+
+COMMIT;
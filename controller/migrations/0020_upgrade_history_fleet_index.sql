@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Fleet-wide history listings page newest first by (completed_at, id).
+CREATE INDEX IF NOT EXISTS idx_agent_upgrade_history_time
+    ON agent_upgrade_history(completed_at DESC, id DESC);
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+ALTER TABLE admin_keys
+    ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT '';
+
+COMMIT;
@@ -0,0 +1,16 @@
+BEGIN;
+
+-- Agents regroup results that failed to send into new batches, so retries
+-- are recognised per result instead of per batch.
+DELETE FROM probe_results a
+USING probe_results b
+WHERE a.ctid < b.ctid
+  AND a.agent_id = b.agent_id
+  AND a.monitor_id = b.monitor_id
+  AND a.ts = b.ts
+  AND a.seq = b.seq;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_probe_results_identity
+    ON probe_results(agent_id, monitor_id, ts, seq);
+
+COMMIT;